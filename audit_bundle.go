@@ -0,0 +1,122 @@
+package steward
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// auditBundle is the content of an audit export from central. Central
+// do not keep a history of the changes to the acl's and keys, so the
+// bundle holds the state at the time of the export.
+type auditBundle struct {
+	Node    Node      `json:"node"`
+	Created time.Time `json:"created"`
+	// The acl's, node groups, command groups, and the acknowledged
+	// public keys of the nodes with the hash of them.
+	State centralAuthState `json:"state"`
+}
+
+// auditBundleResult is the reply of an audit export.
+type auditBundleResult struct {
+	// The path of the bundle file.
+	Path string `json:"path"`
+	// The path of the file holding the signature.
+	SignaturePath string `json:"signaturePath"`
+	// The base64 encoded ed25519 signature of the bundle file, and the
+	// public key to verify it with.
+	Signature string `json:"signature"`
+	PublicKey string `json:"publicKey"`
+}
+
+// newAuditBundle will create an audit bundle of the current central
+// auth state, and return it serialized. The maps are serialized with
+// sorted keys, so the same state always gives the same bytes.
+func (s *server) newAuditBundle() ([]byte, error) {
+	js, err := s.centralAuth.exportState()
+	if err != nil {
+		return nil, err
+	}
+
+	b := auditBundle{
+		Node:    Node(s.configuration.NodeName),
+		Created: time.Now().UTC(),
+	}
+	err = json.Unmarshal(js, &b.State)
+	if err != nil {
+		return nil, fmt.Errorf("error: failed to unmarshal central auth state: %v", err)
+	}
+
+	out, err := json.MarshalIndent(b, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("error: failed to marshal audit bundle: %v", err)
+	}
+
+	return out, nil
+}
+
+// exportAuditBundle will create an audit bundle, sign it with the
+// signing key of the node, and write the bundle and the signature to
+// files in the folder given.
+func (s *server) exportAuditBundle(folder string) (auditBundleResult, error) {
+	b, err := s.newAuditBundle()
+	if err != nil {
+		return auditBundleResult{}, err
+	}
+
+	pub, priv := s.nodeAuth.signingKeys()
+	if len(priv) != ed25519.PrivateKeySize {
+		return auditBundleResult{}, fmt.Errorf("error: no valid private signing key found to sign the audit bundle with")
+	}
+	sig := ed25519.Sign(priv, b)
+
+	err = os.MkdirAll(folder, 0700)
+	if err != nil {
+		return auditBundleResult{}, fmt.Errorf("error: failed to create audit folder: %v", err)
+	}
+
+	name := fmt.Sprintf("audit-bundle-%v", time.Now().UTC().Format("20060102T150405.000000000Z"))
+	r := auditBundleResult{
+		Path:          filepath.Join(folder, name+".json"),
+		SignaturePath: filepath.Join(folder, name+".sig"),
+		Signature:     base64.StdEncoding.EncodeToString(sig),
+		PublicKey:     base64.StdEncoding.EncodeToString(pub),
+	}
+
+	err = os.WriteFile(r.Path, b, 0600)
+	if err != nil {
+		return auditBundleResult{}, fmt.Errorf("error: failed to write audit bundle: %v", err)
+	}
+	err = os.WriteFile(r.SignaturePath, []byte(r.Signature+"\n"), 0600)
+	if err != nil {
+		return auditBundleResult{}, fmt.Errorf("error: failed to write audit bundle signature: %v", err)
+	}
+
+	return r, nil
+}
+
+// verifyAuditBundle will check that the bundle was signed with the
+// private key belonging to the base64 encoded public key given.
+func verifyAuditBundle(bundle []byte, signatureB64 string, publicKeyB64 string) error {
+	sig, err := base64.StdEncoding.DecodeString(signatureB64)
+	if err != nil {
+		return fmt.Errorf("error: failed to decode signature: %v", err)
+	}
+	pub, err := base64.StdEncoding.DecodeString(publicKeyB64)
+	if err != nil {
+		return fmt.Errorf("error: failed to decode public key: %v", err)
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("error: public key has length %v, want %v", len(pub), ed25519.PublicKeySize)
+	}
+
+	if !ed25519.Verify(pub, bundle, sig) {
+		return fmt.Errorf("error: signature of audit bundle is not valid")
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+package steward
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// aclDocumentVersion is the version of the schema of aclDocument. It is
+// increased when the schema is changed, so an older document can be
+// detected and migrated when imported.
+const aclDocumentVersion = 1
+
+// aclDocument is a human readable and editable representation of the
+// ACL's and the groups in the schemaMain, used by REQAclExport and
+// REQAclImport. Exporting a document and importing it again gives the
+// same ACL's and groups.
+type aclDocument struct {
+	// The version of the schema of the document.
+	Version int `json:"version" yaml:"version"`
+	// The node groups with their nodes.
+	NodeGroups map[nodeGroup][]Node `json:"nodeGroups,omitempty" yaml:"nodeGroups,omitempty"`
+	// The command groups with their commands.
+	CommandGroups map[commandGroup][]command `json:"commandGroups,omitempty" yaml:"commandGroups,omitempty"`
+	// The ACL rules, one for each host and source node or group.
+	ACLs []aclRule `json:"acls,omitempty" yaml:"acls,omitempty"`
+}
+
+// aclRule holds the commands or command groups a source node or node
+// group is allowed to run on a host node or node group.
+type aclRule struct {
+	Host     Node      `json:"host" yaml:"host"`
+	Source   Node      `json:"source" yaml:"source"`
+	Commands []command `json:"commands" yaml:"commands"`
+}
+
+// exportACLDocument will export the ACL's and the groups as an
+// aclDocument in the format given, which is "json" or "yaml". The
+// content is sorted, so an unchanged schema gives the same export.
+func (c *centralAuth) exportACLDocument(format string) ([]byte, error) {
+	c.accessLists.schemaMain.mu.Lock()
+	d := aclDocument{
+		Version:       aclDocumentVersion,
+		NodeGroups:    make(map[nodeGroup][]Node),
+		CommandGroups: make(map[commandGroup][]command),
+	}
+
+	for ng, nodes := range c.accessLists.schemaMain.NodeGroupMap {
+		for n := range nodes {
+			d.NodeGroups[ng] = append(d.NodeGroups[ng], n)
+		}
+		sort.Slice(d.NodeGroups[ng], func(i, j int) bool { return d.NodeGroups[ng][i] < d.NodeGroups[ng][j] })
+	}
+
+	for cg, cmds := range c.accessLists.schemaMain.CommandGroupMap {
+		for cmd := range cmds {
+			d.CommandGroups[cg] = append(d.CommandGroups[cg], cmd)
+		}
+		sort.Slice(d.CommandGroups[cg], func(i, j int) bool { return d.CommandGroups[cg][i] < d.CommandGroups[cg][j] })
+	}
+
+	for host, sources := range c.accessLists.schemaMain.ACLMap {
+		for source, cmds := range sources {
+			r := aclRule{Host: host, Source: source, Commands: []command{}}
+			for cmd := range cmds {
+				r.Commands = append(r.Commands, cmd)
+			}
+			sort.Slice(r.Commands, func(i, j int) bool { return r.Commands[i] < r.Commands[j] })
+			d.ACLs = append(d.ACLs, r)
+		}
+	}
+	c.accessLists.schemaMain.mu.Unlock()
+
+	sort.Slice(d.ACLs, func(i, j int) bool {
+		if d.ACLs[i].Host != d.ACLs[j].Host {
+			return d.ACLs[i].Host < d.ACLs[j].Host
+		}
+		return d.ACLs[i].Source < d.ACLs[j].Source
+	})
+
+	switch format {
+	case "json":
+		return json.MarshalIndent(d, "", "  ")
+	case "yaml":
+		return yaml.Marshal(d)
+	default:
+		return nil, fmt.Errorf("error: exportACLDocument: unknown format %q, want json or yaml", format)
+	}
+}
+
+// isACLDocument will return true if the data given is an aclDocument,
+// and not the ACLMap exported by exportACLs.
+func isACLDocument(b []byte) bool {
+	var probe struct {
+		Version *int `yaml:"version"`
+	}
+
+	// A yaml parser also parses json.
+	err := yaml.Unmarshal(b, &probe)
+	return err == nil && probe.Version != nil
+}
+
+// parseACLDocument will parse and validate an aclDocument in json or
+// yaml format.
+func parseACLDocument(b []byte) (aclDocument, error) {
+	var d aclDocument
+
+	err := yaml.Unmarshal(b, &d)
+	if err != nil {
+		return aclDocument{}, fmt.Errorf("failed to parse acl document: %v", err)
+	}
+
+	switch {
+	case d.Version < 1:
+		return aclDocument{}, fmt.Errorf("acl document has no version")
+	case d.Version > aclDocumentVersion:
+		return aclDocument{}, fmt.Errorf("acl document version %v is newer than the supported version %v", d.Version, aclDocumentVersion)
+	}
+
+	return d, d.validate()
+}
+
+// validate will check that the names of the nodes, groups and commands
+// of the document are valid. A group used by a rule does not have to be
+// defined, since a rule can be added before the members of its groups.
+func (d aclDocument) validate() error {
+	checkNode := func(n Node) error {
+		switch {
+		case strings.TrimSpace(string(n)) == "":
+			return fmt.Errorf("empty node name")
+		case strings.ContainsAny(string(n), " \t\n"):
+			return fmt.Errorf("node name %q contains whitespace", n)
+		}
+		return nil
+	}
+
+	checkCommand := func(c command) error {
+		if strings.TrimSpace(string(c)) == "" {
+			return fmt.Errorf("empty command")
+		}
+		if _, _, err := aclRegex(c); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	for ng, nodes := range d.NodeGroups {
+		if !strings.HasPrefix(string(ng), "grp_nodes_") {
+			return fmt.Errorf("node group %v do not start with grp_nodes_", ng)
+		}
+		for _, n := range nodes {
+			if strings.HasPrefix(string(n), "grp_nodes_") {
+				return fmt.Errorf("node group %v: a group can not be a member of a group: %v", ng, n)
+			}
+			if err := checkNode(n); err != nil {
+				return fmt.Errorf("node group %v: %v", ng, err)
+			}
+		}
+	}
+
+	for cg, cmds := range d.CommandGroups {
+		if !strings.HasPrefix(string(cg), "grp_commands_") {
+			return fmt.Errorf("command group %v do not start with grp_commands_", cg)
+		}
+		for _, c := range cmds {
+			if strings.HasPrefix(string(c), "grp_commands_") {
+				return fmt.Errorf("command group %v: a group can not be a member of a group: %v", cg, c)
+			}
+			if err := checkCommand(c); err != nil {
+				return fmt.Errorf("command group %v: %v", cg, err)
+			}
+		}
+	}
+
+	for i, r := range d.ACLs {
+		if err := checkNode(r.Host); err != nil {
+			return fmt.Errorf("acl %v: host: %v", i, err)
+		}
+		if err := checkNode(r.Source); err != nil {
+			return fmt.Errorf("acl %v: source: %v", i, err)
+		}
+		for _, c := range r.Commands {
+			if err := checkCommand(c); err != nil {
+				return fmt.Errorf("acl %v: %v", i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// importACLDocument will validate the aclDocument given in json or yaml
+// format, and import it. If replace is true the current ACL's and groups
+// are replaced with the ones of the document, and else the ones of the
+// document are merged with the current. Nothing is imported if the
+// document is not valid.
+func (c *centralAuth) importACLDocument(b []byte, replace bool) error {
+	d, err := parseACLDocument(b)
+	if err != nil {
+		return fmt.Errorf("error: importACLDocument: %v", err)
+	}
+
+	if replace {
+		c.accessLists.schemaMain.mu.Lock()
+		defer c.accessLists.schemaMain.mu.Unlock()
+
+		c.accessLists.schemaMain.ACLMap = make(map[Node]map[Node]map[command]struct{})
+		c.accessLists.schemaMain.NodeGroupMap = make(map[nodeGroup]map[Node]struct{})
+		c.accessLists.schemaMain.CommandGroupMap = make(map[commandGroup]map[command]struct{})
+
+		for ng, nodes := range d.NodeGroups {
+			c.accessLists.schemaMain.NodeGroupMap[ng] = make(map[Node]struct{})
+			for _, n := range nodes {
+				c.accessLists.schemaMain.NodeGroupMap[ng][n] = struct{}{}
+			}
+		}
+		for cg, cmds := range d.CommandGroups {
+			c.accessLists.schemaMain.CommandGroupMap[cg] = make(map[command]struct{})
+			for _, cmd := range cmds {
+				c.accessLists.schemaMain.CommandGroupMap[cg][cmd] = struct{}{}
+			}
+		}
+		for _, r := range d.ACLs {
+			if _, ok := c.accessLists.schemaMain.ACLMap[r.Host]; !ok {
+				c.accessLists.schemaMain.ACLMap[r.Host] = make(map[Node]map[command]struct{})
+			}
+			if _, ok := c.accessLists.schemaMain.ACLMap[r.Host][r.Source]; !ok {
+				c.accessLists.schemaMain.ACLMap[r.Host][r.Source] = make(map[command]struct{})
+			}
+			for _, cmd := range r.Commands {
+				c.accessLists.schemaMain.ACLMap[r.Host][r.Source][cmd] = struct{}{}
+			}
+		}
+
+		return c.generateACLsForAllNodes()
+	}
+
+	for ng, nodes := range d.NodeGroups {
+		for _, n := range nodes {
+			c.groupNodesAddNode(ng, n)
+		}
+	}
+	for cg, cmds := range d.CommandGroups {
+		for _, cmd := range cmds {
+			c.groupCommandsAddCommand(cg, cmd)
+		}
+	}
+	for _, r := range d.ACLs {
+		for _, cmd := range r.Commands {
+			err := c.aclAddCommand(r.Host, r.Source, cmd)
+			if err != nil {
+				return fmt.Errorf("error: importACLDocument: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -58,6 +59,27 @@ func newAccessLists(pki *pki, errorKernel *errorKernel, configuration *Configura
 
 // type node string
 type command string
+
+// aclRegexPrefix flags a command in the ACL as a regular expression,
+// like "regex:systemctl status .*", that must match the whole command.
+const aclRegexPrefix = "regex:"
+
+// aclRegex will return the compiled regular expression of a command
+// flagged as a regular expression with aclRegexPrefix. The expression is
+// anchored so it must match the whole command. False is returned if the
+// command is not a regular expression.
+func aclRegex(cmd command) (*regexp.Regexp, bool, error) {
+	if !strings.HasPrefix(string(cmd), aclRegexPrefix) {
+		return nil, false, nil
+	}
+
+	re, err := regexp.Compile("^(?:" + strings.TrimPrefix(string(cmd), aclRegexPrefix) + ")$")
+	if err != nil {
+		return nil, true, fmt.Errorf("invalid regular expression in acl command %q: %v", cmd, err)
+	}
+
+	return re, true, nil
+}
 type nodeGroup string
 type commandGroup string
 
@@ -204,7 +226,13 @@ func (a *accessLists) commandAsSlice(c command) []command {
 // If the node or the fromNode do not exist they will be created.
 // The json encoded schema for a node and the hash of those data
 // will also be generated.
-func (c *centralAuth) aclAddCommand(host Node, source Node, cmd command) {
+// An error is returned if the command is a regular expression that
+// do not compile.
+func (c *centralAuth) aclAddCommand(host Node, source Node, cmd command) error {
+	if _, _, err := aclRegex(cmd); err != nil {
+		return fmt.Errorf("aclAddCommand: %v", err)
+	}
+
 	c.accessLists.schemaMain.mu.Lock()
 	defer c.accessLists.schemaMain.mu.Unlock()
 
@@ -230,6 +258,7 @@ func (c *centralAuth) aclAddCommand(host Node, source Node, cmd command) {
 
 	// fmt.Printf(" * DEBUG: aclNodeFromnodeCommandAdd: a.schemaMain.ACLMap=%v\n", a.schemaMain.ACLMap)
 
+	return nil
 }
 
 // aclDeleteCommand will delete the specified command from the fromnode.
@@ -361,16 +390,13 @@ func (c *centralAuth) generateACLsForAllNodes() error {
 
 			// Create the hash for the data for the host node.
 			hash := func() [32]byte {
-				sns := c.accessLists.nodeMapToSlice(n)
-
-				b, err := cbor.Marshal(sns)
+				hash, err := hashACL(n, m)
 				if err != nil {
 					err := fmt.Errorf("error: generateACLsForAllNodes: failed to generate cbor for hash:  %v", err)
 					log.Printf("%v\n", err)
 					return [32]byte{}
 				}
 
-				hash := sha256.Sum256(b)
 				return hash
 			}()
 
@@ -389,6 +415,9 @@ func (c *centralAuth) generateACLsForAllNodes() error {
 	inf = fmt.Errorf("generateACLsFor all nodes, GeneratedACLsMap contains: %#v", c.accessLists.schemaGenerated.GeneratedACLsMap)
 	c.accessLists.errorKernel.logConsoleOnlyIfDebug(inf, c.accessLists.configuration)
 
+	// Replicate the new acl's to the standby nodes.
+	c.replication.notify()
+
 	return nil
 }
 
@@ -408,17 +437,17 @@ type sourceNodeCommands struct {
 	Commands []command
 }
 
-// nodeMapToSlice will return a sourceNode structure, with the map sourceNode part
-// of the map converted into a slice. Both the from node, and the commands
-// defined for each sourceNode are sorted.
-// This function is used when creating the hash of the nodeMap since we can not
+// aclToSlice will return a sourceNode structure, with the map sourceNode part
+// of the ACL of the host converted into a slice. Both the from node, and the
+// commands defined for each sourceNode are sorted.
+// This function is used when creating the hash of the ACL since we can not
 // guarantee the order of a hash map, but we can with a slice.
-func (a *accessLists) nodeMapToSlice(host Node) sourceNode {
+func aclToSlice(host Node, acl map[Node]map[command]struct{}) sourceNode {
 	srcNodes := sourceNode{
 		HostNode: host,
 	}
 
-	for sn, commandMap := range a.schemaGenerated.ACLsToConvert[host] {
+	for sn, commandMap := range acl {
 		srcC := sourceNodeCommands{
 			Source: sn,
 		}
@@ -440,11 +469,24 @@ func (a *accessLists) nodeMapToSlice(host Node) sourceNode {
 		return srcNodes.SourceCommands[i].Source < srcNodes.SourceCommands[j].Source
 	})
 
-	// fmt.Printf(" * nodeMapToSlice: fromNodes: %#v\n", fns)
+	// fmt.Printf(" * aclToSlice: fromNodes: %#v\n", fns)
 
 	return srcNodes
 }
 
+// hashACL will return the hash of the ACL of the host, calculated over
+// the sorted slice representation of the ACL so the hash stays the same
+// unless the ACL is changed. The hash is sent with the ACL to the host,
+// so the host can verify the ACL it receives.
+func hashACL(host Node, acl map[Node]map[command]struct{}) ([32]byte, error) {
+	b, err := cbor.Marshal(aclToSlice(host, acl))
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	return sha256.Sum256(b), nil
+}
+
 // groupNodesAddNode adds a node to a group. If the group does
 // not exist it will be created.
 func (c *centralAuth) groupNodesAddNode(ng nodeGroup, n Node) {
@@ -525,6 +567,11 @@ func (c *centralAuth) groupCommandsAddCommand(cg commandGroup, cmd command) {
 		return
 	}
 
+	if _, _, err := aclRegex(cmd); err != nil {
+		log.Printf("error: groupCommandsAddCommand: %v\n", err)
+		return
+	}
+
 	c.accessLists.schemaMain.mu.Lock()
 	defer c.accessLists.schemaMain.mu.Unlock()
 	if _, ok := c.accessLists.schemaMain.CommandGroupMap[cg]; !ok {
@@ -614,8 +661,179 @@ func (c *centralAuth) importACLs(js []byte) error {
 		return fmt.Errorf("error: failed to unmarshal into ACLMap: %v", err)
 	}
 
+	for _, sources := range m {
+		for _, cmds := range sources {
+			for cmd := range cmds {
+				if _, _, err := aclRegex(cmd); err != nil {
+					return fmt.Errorf("error: importACLs: %v", err)
+				}
+			}
+		}
+	}
+
 	c.accessLists.schemaMain.ACLMap = m
 
 	return nil
 
 }
+
+// cloneNodeReport holds what was copied when cloning the configuration
+// of one node to another.
+type cloneNodeReport struct {
+	Source Node `json:"source"`
+	Target Node `json:"target"`
+	// Overwrite is true if existing entries for the target were replaced,
+	// and false if they were merged with the entries of the source.
+	Overwrite bool `json:"overwrite"`
+	// The source nodes allowed to send to the source node, that are now
+	// also allowed to send to the target node.
+	ACLSources []Node `json:"aclSources"`
+	// The host nodes that allowed the source node to send to them, and
+	// that now also allow the target node.
+	ACLHosts []Node `json:"aclHosts"`
+	// The node groups the target node was added to.
+	NodeGroups []nodeGroup `json:"nodeGroups"`
+	// Entries for the target that differed from the source before cloning.
+	Conflicts []string `json:"conflicts"`
+}
+
+// cloneNodeConfig will copy the acl's where the source node is the host
+// or the source, and the node group memberships of the source node to
+// the target node. If overwrite is true the existing entries of the target
+// are replaced with the ones of the source, else they are merged.
+func (c *centralAuth) cloneNodeConfig(source Node, target Node, overwrite bool) (cloneNodeReport, error) {
+	r := cloneNodeReport{
+		Source:     source,
+		Target:     target,
+		Overwrite:  overwrite,
+		ACLSources: []Node{},
+		ACLHosts:   []Node{},
+		NodeGroups: []nodeGroup{},
+		Conflicts:  []string{},
+	}
+
+	if source == target {
+		return r, fmt.Errorf("error: cloneNodeConfig: source and target node are the same: %v", source)
+	}
+
+	c.accessLists.schemaMain.mu.Lock()
+	defer c.accessLists.schemaMain.mu.Unlock()
+
+	aclMap := c.accessLists.schemaMain.ACLMap
+
+	// copyCommands will return a copy of the commands, merged with the
+	// existing commands if not overwriting.
+	copyCommands := func(src map[command]struct{}, existing map[command]struct{}) map[command]struct{} {
+		cmds := make(map[command]struct{})
+		if !overwrite {
+			for cmd := range existing {
+				cmds[cmd] = struct{}{}
+			}
+		}
+		for cmd := range src {
+			cmds[cmd] = struct{}{}
+		}
+		return cmds
+	}
+
+	// --- The acl's where the source is the host.
+	_, sourceIsHost := aclMap[source]
+	_, targetIsHost := aclMap[target]
+	if sourceIsHost || targetIsHost {
+		if !targetIsHost {
+			aclMap[target] = make(map[Node]map[command]struct{})
+		}
+
+		for sn, existing := range aclMap[target] {
+			if _, ok := aclMap[source][sn]; !ok {
+				r.Conflicts = append(r.Conflicts, fmt.Sprintf("host=%v, source=%v: not present for %v", target, sn, source))
+				if overwrite {
+					delete(aclMap[target], sn)
+				}
+				continue
+			}
+			if !equalCommands(existing, aclMap[source][sn]) {
+				r.Conflicts = append(r.Conflicts, fmt.Sprintf("host=%v, source=%v: commands differ from %v", target, sn, source))
+			}
+		}
+
+		for sn, cmds := range aclMap[source] {
+			aclMap[target][sn] = copyCommands(cmds, aclMap[target][sn])
+			r.ACLSources = append(r.ACLSources, sn)
+		}
+
+		if len(aclMap[target]) == 0 {
+			delete(aclMap, target)
+		}
+	}
+
+	// --- The acl's where the source is the source.
+	for host, sources := range aclMap {
+		if host == target {
+			continue
+		}
+		cmds, ok := sources[source]
+		if !ok {
+			// The target is allowed on a host where the source is not.
+			if _, ok := sources[target]; ok {
+				r.Conflicts = append(r.Conflicts, fmt.Sprintf("host=%v, source=%v: not present for %v", host, target, source))
+				if overwrite {
+					delete(sources, target)
+				}
+			}
+			continue
+		}
+
+		if existing, ok := sources[target]; ok && !equalCommands(existing, cmds) {
+			r.Conflicts = append(r.Conflicts, fmt.Sprintf("host=%v, source=%v: commands differ from %v", host, target, source))
+		}
+
+		sources[target] = copyCommands(cmds, sources[target])
+		r.ACLHosts = append(r.ACLHosts, host)
+	}
+
+	// --- The node group memberships.
+	for ng, nodes := range c.accessLists.schemaMain.NodeGroupMap {
+		_, sourceMember := nodes[source]
+		_, targetMember := nodes[target]
+
+		switch {
+		case sourceMember:
+			nodes[target] = struct{}{}
+			r.NodeGroups = append(r.NodeGroups, ng)
+		case targetMember:
+			r.Conflicts = append(r.Conflicts, fmt.Sprintf("group=%v: %v is not a member", ng, source))
+			if overwrite {
+				delete(nodes, target)
+			}
+		}
+	}
+
+	sort.Slice(r.ACLSources, func(i, j int) bool { return r.ACLSources[i] < r.ACLSources[j] })
+	sort.Slice(r.ACLHosts, func(i, j int) bool { return r.ACLHosts[i] < r.ACLHosts[j] })
+	sort.Slice(r.NodeGroups, func(i, j int) bool { return r.NodeGroups[i] < r.NodeGroups[j] })
+	sort.Strings(r.Conflicts)
+
+	err := c.generateACLsForAllNodes()
+	if err != nil {
+		er := fmt.Errorf("error: cloneNodeConfig: %v", err)
+		log.Printf("%v\n", er)
+	}
+
+	return r, nil
+}
+
+// equalCommands will return true if the two command maps contains the
+// same commands.
+func equalCommands(a map[command]struct{}, b map[command]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for cmd := range a {
+		if _, ok := b[cmd]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
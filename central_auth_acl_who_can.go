@@ -0,0 +1,84 @@
+package steward
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// aclWhoCanResult is the reply of REQAclWhoCan.
+type aclWhoCanResult struct {
+	Host    Node   `json:"host"`
+	Command string `json:"command"`
+	// The source nodes allowed to run the command on the host.
+	Sources []aclWhoCanSource `json:"sources"`
+}
+
+// aclWhoCanSource is a source node allowed to run the command, with
+// the commands of the ACL that allowed it, so grants that are too broad
+// like "*" can be found.
+type aclWhoCanSource struct {
+	Node      Node      `json:"node"`
+	AllowedBy []command `json:"allowedBy"`
+}
+
+// aclWhoCan will find the source nodes allowed to run the command on the
+// host, from the ACL generated for the host, so the node and command
+// groups are expanded. The command is matched the same way as the host
+// does when verifying the ACL, with "*", a literal match, or a regular
+// expression.
+func (c *centralAuth) aclWhoCan(host Node, cmd string) (aclWhoCanResult, error) {
+	r := aclWhoCanResult{
+		Host:    host,
+		Command: cmd,
+		Sources: []aclWhoCanSource{},
+	}
+
+	c.accessLists.schemaGenerated.mu.Lock()
+	generated, ok := c.accessLists.schemaGenerated.GeneratedACLsMap[host]
+	c.accessLists.schemaGenerated.mu.Unlock()
+	if !ok {
+		return r, nil
+	}
+
+	sources := make(map[Node]map[command]struct{})
+	err := cbor.Unmarshal(generated.Data, &sources)
+	if err != nil {
+		return r, fmt.Errorf("failed to unmarshal the generated acl for host %v: %v", host, err)
+	}
+
+	for source, cmds := range sources {
+		var allowedBy []command
+		for c := range cmds {
+			if aclCommandMatch(c, cmd) {
+				allowedBy = append(allowedBy, c)
+			}
+		}
+		if len(allowedBy) == 0 {
+			continue
+		}
+
+		sort.Slice(allowedBy, func(i, j int) bool { return allowedBy[i] < allowedBy[j] })
+		r.Sources = append(r.Sources, aclWhoCanSource{Node: source, AllowedBy: allowedBy})
+	}
+
+	sort.Slice(r.Sources, func(i, j int) bool { return r.Sources[i].Node < r.Sources[j].Node })
+
+	return r, nil
+}
+
+// aclCommandMatch will return true if the command of an ACL allows the
+// command given.
+func aclCommandMatch(c command, cmd string) bool {
+	if c == "*" || string(c) == cmd {
+		return true
+	}
+
+	re, isRegex, err := aclRegex(c)
+	if !isRegex || err != nil {
+		return false
+	}
+
+	return re.MatchString(cmd)
+}
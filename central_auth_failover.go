@@ -0,0 +1,166 @@
+package steward
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// centralAuthState is a snapshot of the central auth state that are
+// replicated from the central to the standby node, so the standby have
+// what it needs to take over as central with REQFailover.
+type centralAuthState struct {
+	ACLMap          map[Node]map[Node]map[command]struct{} `json:"aclMap"`
+	NodeGroupMap    map[nodeGroup]map[Node]struct{}        `json:"nodeGroupMap"`
+	CommandGroupMap map[commandGroup]map[command]struct{}  `json:"commandGroupMap"`
+	// The acknowledged public keys of the nodes, and the hash of them.
+	PublicKeys     map[Node][]byte `json:"publicKeys"`
+	PublicKeysHash [32]byte        `json:"publicKeysHash"`
+}
+
+// exportState will return a snapshot of the current central auth state
+// in JSON format.
+func (c *centralAuth) exportState() ([]byte, error) {
+	st := centralAuthState{}
+
+	c.accessLists.schemaMain.mu.Lock()
+	st.ACLMap = c.accessLists.schemaMain.ACLMap
+	st.NodeGroupMap = c.accessLists.schemaMain.NodeGroupMap
+	st.CommandGroupMap = c.accessLists.schemaMain.CommandGroupMap
+	c.pki.nodesAcked.mu.Lock()
+	st.PublicKeys = c.pki.nodesAcked.keysAndHash.Keys
+	st.PublicKeysHash = c.pki.nodesAcked.keysAndHash.Hash
+
+	// Marshal while holding the locks, since the maps are shared with
+	// the running central auth.
+	js, err := json.Marshal(st)
+	c.pki.nodesAcked.mu.Unlock()
+	c.accessLists.schemaMain.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("error: exportState: failed to marshal central auth state: %v", err)
+	}
+
+	return js, nil
+}
+
+// importState will replace the current central auth state with the
+// snapshot given, store it to the db and files used for persistence,
+// and generate the acl's for all the nodes.
+func (c *centralAuth) importState(js []byte) error {
+	st := centralAuthState{}
+	err := json.Unmarshal(js, &st)
+	if err != nil {
+		return fmt.Errorf("error: importState: failed to unmarshal central auth state: %v", err)
+	}
+
+	if st.ACLMap == nil {
+		st.ACLMap = make(map[Node]map[Node]map[command]struct{})
+	}
+	if st.NodeGroupMap == nil {
+		st.NodeGroupMap = make(map[nodeGroup]map[Node]struct{})
+	}
+	if st.CommandGroupMap == nil {
+		st.CommandGroupMap = make(map[commandGroup]map[command]struct{})
+	}
+	if st.PublicKeys == nil {
+		st.PublicKeys = make(map[Node][]byte)
+	}
+
+	// Replace the public keys, and remove the keys from the db that are
+	// not part of the snapshot.
+	err = func() error {
+		c.pki.nodesAcked.mu.Lock()
+		defer c.pki.nodesAcked.mu.Unlock()
+
+		remove := []string{}
+		for n := range c.pki.nodesAcked.keysAndHash.Keys {
+			if _, ok := st.PublicKeys[n]; !ok {
+				remove = append(remove, string(n))
+			}
+		}
+		if len(remove) > 0 {
+			err := c.pki.dbDeletePublicKeys(c.pki.bucketNamePublicKeys, remove)
+			if err != nil {
+				return fmt.Errorf("error: importState: failed to delete public keys from db: %v", err)
+			}
+		}
+
+		for n, k := range st.PublicKeys {
+			err := c.pki.dbUpdatePublicKey(string(n), k)
+			if err != nil {
+				return fmt.Errorf("error: importState: failed to store public key in db: %v", err)
+			}
+		}
+
+		err := c.pki.dbUpdateHash(st.PublicKeysHash[:])
+		if err != nil {
+			return fmt.Errorf("error: importState: failed to store public keys hash in db: %v", err)
+		}
+
+		c.pki.nodesAcked.keysAndHash.Keys = st.PublicKeys
+		c.pki.nodesAcked.keysAndHash.Hash = st.PublicKeysHash
+
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	c.accessLists.schemaMain.mu.Lock()
+	c.accessLists.schemaMain.ACLMap = st.ACLMap
+	c.accessLists.schemaMain.NodeGroupMap = st.NodeGroupMap
+	c.accessLists.schemaMain.CommandGroupMap = st.CommandGroupMap
+	c.accessLists.schemaMain.mu.Unlock()
+
+	err = c.generateACLsForAllNodes()
+	if err != nil {
+		return fmt.Errorf("error: importState: failed to generate acl's: %v", err)
+	}
+
+	return nil
+}
+
+// centralReplicaFilePath returns the path of the file where a standby
+// node stores the central auth state replicated to it.
+func centralReplicaFilePath(c *Configuration) string {
+	return filepath.Join(c.DatabaseFolder, "central_replica.txt")
+}
+
+// saveCentralReplica will store the replicated central auth state on
+// the standby node, so it is kept between restarts of the standby.
+func saveCentralReplica(c *Configuration, js []byte) error {
+	// Check that what we store is a valid state before replacing the
+	// replica we already have.
+	st := centralAuthState{}
+	err := json.Unmarshal(js, &st)
+	if err != nil {
+		return fmt.Errorf("error: saveCentralReplica: received state is not valid: %v", err)
+	}
+
+	filePath := centralReplicaFilePath(c)
+	tmpPath := filePath + ".tmp"
+
+	err = os.WriteFile(tmpPath, js, 0600)
+	if err != nil {
+		return fmt.Errorf("error: saveCentralReplica: failed to write replica file: %v", err)
+	}
+
+	err = os.Rename(tmpPath, filePath)
+	if err != nil {
+		return fmt.Errorf("error: saveCentralReplica: failed to replace replica file: %v", err)
+	}
+
+	return nil
+}
+
+// loadCentralReplica will read the central auth state replicated to
+// the standby node.
+func loadCentralReplica(c *Configuration) ([]byte, error) {
+	js, err := os.ReadFile(centralReplicaFilePath(c))
+	if err != nil {
+		return nil, fmt.Errorf("error: loadCentralReplica: no replicated central state found: %v", err)
+	}
+
+	return js, nil
+}
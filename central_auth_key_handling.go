@@ -2,15 +2,12 @@ package steward
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-	"sort"
 	"sync"
 
-	"github.com/fxamacker/cbor/v2"
 	bolt "go.etcd.io/bbolt"
 )
 
@@ -20,11 +17,14 @@ type centralAuth struct {
 	accessLists *accessLists
 	// public key distribution related data and methods.
 	pki *pki
+	// replication of the state to the standby nodes.
+	replication *centralReplication
 }
 
 // newCentralAuth will return a new and prepared *centralAuth
 func newCentralAuth(configuration *Configuration, errorKernel *errorKernel) *centralAuth {
 	c := centralAuth{}
+	c.replication = newCentralReplication()
 	c.pki = newPKI(configuration, errorKernel)
 	c.accessLists = newAccessLists(c.pki, errorKernel, configuration)
 
@@ -254,33 +254,7 @@ func (c *centralAuth) updateHash(proc process, message Message) {
 	c.pki.nodesAcked.mu.Lock()
 	defer c.pki.nodesAcked.mu.Unlock()
 
-	type NodesAndKeys struct {
-		Node Node
-		Key  []byte
-	}
-
-	// Create a slice of all the map keys, and its value.
-	sortedNodesAndKeys := []NodesAndKeys{}
-
-	// Range the map, and add each k/v to the sorted slice, to be sorted later.
-	for k, v := range c.pki.nodesAcked.keysAndHash.Keys {
-		nk := NodesAndKeys{
-			Node: k,
-			Key:  v,
-		}
-
-		sortedNodesAndKeys = append(sortedNodesAndKeys, nk)
-	}
-
-	// sort the slice based on the node name.
-	// Sort all the commands.
-	sort.SliceStable(sortedNodesAndKeys, func(i, j int) bool {
-		return sortedNodesAndKeys[i].Node < sortedNodesAndKeys[j].Node
-	})
-
-	// Then create a hash based on the sorted slice.
-
-	b, err := cbor.Marshal(sortedNodesAndKeys)
+	hash, err := hashPublicKeys(c.pki.nodesAcked.keysAndHash.Keys)
 	if err != nil {
 		er := fmt.Errorf("error: methodREQKeysAllow, failed to marshal slice, and will not update hash for public keys:  %v", err)
 		c.pki.errorKernel.errSend(proc, message, er)
@@ -290,7 +264,6 @@ func (c *centralAuth) updateHash(proc process, message Message) {
 	}
 
 	// Store the key in the key value map.
-	hash := sha256.Sum256(b)
 	c.pki.nodesAcked.keysAndHash.Hash = hash
 
 	// Store the key to the db for persistence.
@@ -303,6 +276,8 @@ func (c *centralAuth) updateHash(proc process, message Message) {
 		return
 	}
 
+	// Replicate the new keys to the standby nodes.
+	c.replication.notify()
 }
 
 // dbViewHash will look up and return a specific value if it exists for a key in a bucket in a DB.
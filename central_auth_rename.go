@@ -0,0 +1,214 @@
+package steward
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// nodeRenameReport holds what was migrated when renaming a node.
+type nodeRenameReport struct {
+	OldName Node `json:"oldName"`
+	NewName Node `json:"newName"`
+	// ACLHost is true if the node had acl's as a host.
+	ACLHost bool `json:"aclHost"`
+	// The hosts where the node was allowed as a source.
+	ACLSourceOn []Node `json:"aclSourceOn"`
+	// The node groups the node is a member of.
+	NodeGroups []nodeGroup `json:"nodeGroups"`
+	// PublicKey is true if the public key of the node was migrated.
+	PublicKey bool `json:"publicKey"`
+	// The folders in the data folder that were renamed.
+	DataFolders []string `json:"dataFolders"`
+}
+
+// renameNodeInState will replace all the references to the old node
+// name in the state with the new name. It will return an error if the
+// new name is already in use, so we do not merge two nodes by accident.
+func renameNodeInState(st *centralAuthState, oldName Node, newName Node, r *nodeRenameReport) error {
+	if _, ok := st.ACLMap[newName]; ok {
+		return fmt.Errorf("error: node %v already have acl's as a host", newName)
+	}
+	if _, ok := st.PublicKeys[newName]; ok {
+		return fmt.Errorf("error: node %v already have a public key", newName)
+	}
+	for host, sources := range st.ACLMap {
+		if _, ok := sources[newName]; ok {
+			return fmt.Errorf("error: node %v already is a source on host %v", newName, host)
+		}
+	}
+	for ng, nodes := range st.NodeGroupMap {
+		if _, ok := nodes[newName]; ok {
+			return fmt.Errorf("error: node %v already is a member of group %v", newName, ng)
+		}
+	}
+
+	// --- The acl's where the node is the host.
+	if sources, ok := st.ACLMap[oldName]; ok {
+		st.ACLMap[newName] = sources
+		delete(st.ACLMap, oldName)
+		r.ACLHost = true
+	}
+
+	// --- The acl's where the node is the source.
+	for host, sources := range st.ACLMap {
+		if cmds, ok := sources[oldName]; ok {
+			sources[newName] = cmds
+			delete(sources, oldName)
+			r.ACLSourceOn = append(r.ACLSourceOn, host)
+		}
+	}
+
+	// --- The node group memberships.
+	for ng, nodes := range st.NodeGroupMap {
+		if _, ok := nodes[oldName]; ok {
+			nodes[newName] = struct{}{}
+			delete(nodes, oldName)
+			r.NodeGroups = append(r.NodeGroups, ng)
+		}
+	}
+
+	// --- The public key, and the hash of all the keys.
+	if key, ok := st.PublicKeys[oldName]; ok {
+		st.PublicKeys[newName] = key
+		delete(st.PublicKeys, oldName)
+		r.PublicKey = true
+
+		hash, err := hashPublicKeys(st.PublicKeys)
+		if err != nil {
+			return fmt.Errorf("error: failed to hash public keys: %v", err)
+		}
+		st.PublicKeysHash = hash
+	}
+
+	sort.Slice(r.ACLSourceOn, func(i, j int) bool { return r.ACLSourceOn[i] < r.ACLSourceOn[j] })
+	sort.Slice(r.NodeGroups, func(i, j int) bool { return r.NodeGroups[i] < r.NodeGroups[j] })
+
+	return nil
+}
+
+// findNodeDataFolders will return the folders below the data folder
+// holding the data received from the old node name. It will return an
+// error if a folder for the new node name already exist next to any of
+// them.
+func findNodeDataFolders(dataFolder string, oldName Node, newName Node) ([]string, error) {
+	folders := []string{}
+
+	err := filepath.WalkDir(dataFolder, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !de.IsDir() || de.Name() != string(oldName) || path == dataFolder {
+			return nil
+		}
+
+		newPath := filepath.Join(filepath.Dir(path), string(newName))
+		if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+			return fmt.Errorf("error: data folder %v already exist", newPath)
+		}
+
+		folders = append(folders, path)
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return folders, nil
+}
+
+// changeNodeName will migrate all the references to the old node name
+// in the central auth state and the data folder to the new name. If any
+// of the steps fails the state and the folders already renamed are put
+// back to how they were before.
+func (s *server) changeNodeName(oldName Node, newName Node) (nodeRenameReport, error) {
+	r := nodeRenameReport{
+		OldName:     oldName,
+		NewName:     newName,
+		ACLSourceOn: []Node{},
+		NodeGroups:  []nodeGroup{},
+		DataFolders: []string{},
+	}
+
+	if oldName == "" || newName == "" || oldName == newName {
+		return r, fmt.Errorf("error: changeNodeName: old and new name must be set and differ, got: %v, %v", oldName, newName)
+	}
+	if err := validateNodeName(string(newName)); err != nil {
+		return r, fmt.Errorf("error: changeNodeName: invalid new name: the name %v", err)
+	}
+
+	// Keep a copy of the current state so we can roll back.
+	backup, err := s.centralAuth.exportState()
+	if err != nil {
+		return r, fmt.Errorf("error: changeNodeName: %v", err)
+	}
+
+	st := centralAuthState{}
+	err = json.Unmarshal(backup, &st)
+	if err != nil {
+		return r, fmt.Errorf("error: changeNodeName: failed to unmarshal state: %v", err)
+	}
+
+	err = renameNodeInState(&st, oldName, newName, &r)
+	if err != nil {
+		return r, fmt.Errorf("error: changeNodeName: %v", err)
+	}
+
+	// Find the data folders before changing anything, so a conflict in
+	// the data folder is found before the state is changed.
+	folders, err := findNodeDataFolders(s.configuration.SubscribersDataFolder, oldName, newName)
+	if err != nil {
+		return r, fmt.Errorf("error: changeNodeName: %v", err)
+	}
+
+	js, err := json.Marshal(st)
+	if err != nil {
+		return r, fmt.Errorf("error: changeNodeName: failed to marshal state: %v", err)
+	}
+
+	// rollback will put back the folders renamed, and the state as it
+	// was before the rename.
+	rollback := func(renamed []string) error {
+		for _, f := range renamed {
+			err := os.Rename(filepath.Join(filepath.Dir(f), string(newName)), f)
+			if err != nil {
+				return fmt.Errorf("failed to rename back data folder %v: %v", f, err)
+			}
+		}
+		return s.centralAuth.importState(backup)
+	}
+
+	err = s.centralAuth.importState(js)
+	if err != nil {
+		if rerr := rollback(nil); rerr != nil {
+			return r, fmt.Errorf("error: changeNodeName: %v, and rollback failed: %v", err, rerr)
+		}
+		return r, fmt.Errorf("error: changeNodeName: %v", err)
+	}
+
+	renamed := []string{}
+	for _, f := range folders {
+		err := os.Rename(f, filepath.Join(filepath.Dir(f), string(newName)))
+		if err != nil {
+			er := fmt.Errorf("error: changeNodeName: failed to rename data folder %v: %v", f, err)
+			if rerr := rollback(renamed); rerr != nil {
+				return r, fmt.Errorf("%v, and rollback failed: %v", er, rerr)
+			}
+			return r, er
+		}
+		renamed = append(renamed, f)
+	}
+	r.DataFolders = renamed
+
+	// The paths of the renamed files have changed, so the data index
+	// is updated.
+	err = s.dataIndex.renameNode(oldName, newName)
+	if err != nil {
+		return r, fmt.Errorf("error: changeNodeName: node renamed, but failed to update the data index: %v", err)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,137 @@
+package steward
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// replicationEvent is a snapshot of the central auth state sent to the
+// standby nodes with REQReplicateTo when the state have changed.
+type replicationEvent struct {
+	// Seq is the sequence number of the event, taken from the time the
+	// event was created so it keeps increasing across restarts of the
+	// central.
+	Seq int64 `json:"seq"`
+	// Hash is the sha256 hash of the state in hex.
+	Hash string `json:"hash"`
+	// State is the central auth state in the same format as exported
+	// by exportState.
+	State json.RawMessage `json:"state"`
+}
+
+// newReplicationEvent will prepare an event with the state given.
+func newReplicationEvent(seq int64, state []byte) replicationEvent {
+	return replicationEvent{
+		Seq:   seq,
+		Hash:  fmt.Sprintf("%x", sha256.Sum256(state)),
+		State: state,
+	}
+}
+
+// verify will check that the hash of the event matches the state, so a
+// state that was changed or corrupted on the way is not applied.
+func (ev replicationEvent) verify() error {
+	hash := fmt.Sprintf("%x", sha256.Sum256(ev.State))
+	if hash != ev.Hash {
+		return fmt.Errorf("hash of the replicated state %v do not match the hash of the event %v", hash, ev.Hash)
+	}
+
+	return nil
+}
+
+// centralReplication keeps track of the standby nodes the central auth
+// state are replicated to with REQReplicateTo, and what state each of
+// them have acknowledged.
+type centralReplication struct {
+	// notifyChs are used to tell the publisher for each standby node
+	// that the state have changed.
+	notifyChs map[Node]chan struct{}
+	// ackedHash are the hash of the last state acknowledged by each
+	// standby node.
+	ackedHash map[Node]string
+	mu        sync.Mutex
+}
+
+func newCentralReplication() *centralReplication {
+	r := centralReplication{
+		notifyChs: make(map[Node]chan struct{}),
+		ackedHash: make(map[Node]string),
+	}
+
+	return &r
+}
+
+// subscribe will return the channel where the publisher for the standby
+// node given are notified about changes to the state.
+func (r *centralReplication) subscribe(node Node) <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch, ok := r.notifyChs[node]
+	if !ok {
+		// Buffered so several changes done close together are only
+		// replicated once, without blocking the one doing the change.
+		ch = make(chan struct{}, 1)
+		r.notifyChs[node] = ch
+	}
+
+	return ch
+}
+
+// notify will tell all the publishers that the state have changed.
+func (r *centralReplication) notify() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, ch := range r.notifyChs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ack will register the hash of the state acknowledged by a standby.
+func (r *centralReplication) ack(node Node, hash string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ackedHash[node] = hash
+}
+
+// acked will return the hash of the last state acknowledged by a standby.
+func (r *centralReplication) acked(node Node) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.ackedHash[node]
+}
+
+// replicaApplied keeps track of the last replication event applied on a
+// standby node, so events received out of order or more than once are
+// not applied.
+type replicaApplied struct {
+	seq int64
+	mu  sync.Mutex
+}
+
+// apply will call fn to apply the event if it is newer than the last
+// event applied.
+func (r *replicaApplied) apply(ev replicationEvent, fn func() error) (applied bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ev.Seq <= r.seq {
+		return false, nil
+	}
+
+	err = fn()
+	if err != nil {
+		return false, err
+	}
+	r.seq = ev.Seq
+
+	return true, nil
+}
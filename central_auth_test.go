@@ -1,11 +1,16 @@
 package steward
 
 import (
+	"crypto/ed25519"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"regexp"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/fxamacker/cbor/v2"
 )
@@ -395,6 +400,111 @@ func TestACLSchemaMainACLMap(t *testing.T) {
 
 }
 
+func TestACLRegexCommand(t *testing.T) {
+	if !*logging {
+		log.SetOutput(io.Discard)
+	}
+
+	err := tstSrv.centralAuth.aclAddCommand("ship700", "admin", "regex:systemctl status (")
+	if err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]: no error for regex that do not compile")
+	}
+	if _, ok := tstSrv.centralAuth.accessLists.schemaMain.ACLMap["ship700"]["admin"]["regex:systemctl status ("]; ok {
+		t.Fatalf(" \U0001F631  [FAILED]: regex that do not compile was added to the map")
+	}
+
+	err = tstSrv.centralAuth.aclAddCommand("ship700", "admin", "regex:systemctl status .*")
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: failed to add regex: %v", err)
+	}
+
+	n := nodeAuth{
+		nodeAcl: &nodeAcl{
+			aclAndHash: newAclAndHash(),
+			regexCache: make(map[command]*regexp.Regexp),
+			logger:     tstSrv.logger,
+		},
+		configuration: tstSrv.configuration,
+		errorKernel:   tstSrv.errorKernel,
+	}
+	n.nodeAcl.aclAndHash.Acl["admin"] = map[command]struct{}{"dmesg": {}}
+	for cmd := range tstSrv.centralAuth.accessLists.schemaMain.ACLMap["ship700"]["admin"] {
+		n.nodeAcl.aclAndHash.Acl["admin"][cmd] = struct{}{}
+	}
+
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"dmesg"}, true},
+		{[]string{"systemctl", "status", "nginx"}, true},
+		{[]string{"systemctl", "stop", "nginx"}, false},
+		// The regex must match the whole command.
+		{[]string{"sudo", "systemctl", "status", "nginx"}, false},
+	}
+
+	for _, tt := range tests {
+		m := Message{FromNode: "admin", Method: REQCliCommand, MethodArgs: tt.args}
+		if got := n.verifyAcl(m); got != tt.want {
+			t.Fatalf(" \U0001F631  [FAILED]: verifyAcl of %v, got %v, want %v", tt.args, got, tt.want)
+		}
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]	: %v\n", "TestACLRegexCommand")
+}
+
+func TestACLCommandMethods(t *testing.T) {
+	if !*logging {
+		log.SetOutput(io.Discard)
+	}
+
+	n := nodeAuth{
+		nodeAcl: &nodeAcl{
+			aclAndHash: newAclAndHash(),
+			regexCache: make(map[command]*regexp.Regexp),
+			logger:     tstSrv.logger,
+		},
+		configuration: tstSrv.configuration,
+		errorKernel:   tstSrv.errorKernel,
+	}
+	n.nodeAcl.aclAndHash.Acl["admin"] = map[command]struct{}{
+		"bash -i":                           {},
+		"systemctl start nginx":             {},
+		"systemctl is-active --quiet nginx": {},
+	}
+
+	tests := []struct {
+		name string
+		m    Message
+		want bool
+	}{
+		{"stream allowed", Message{Method: REQStreamCommand, MethodArgs: []string{"session1", "bash", "-i"}}, true},
+		{"stream not in acl", Message{Method: REQStreamCommand, MethodArgs: []string{"session1", "sh", "-i"}}, false},
+		// The session id is not part of the command.
+		{"stream session id", Message{Method: REQStreamCommand, MethodArgs: []string{"bash", "-i"}}, false},
+		{"stream no command", Message{Method: REQStreamCommand, MethodArgs: []string{"session1"}}, false},
+		{"resource limit allowed", Message{Method: REQResourceLimitExec, MethodArgs: []string{"cpu=1", "bash", "-i"}}, true},
+		{"resource limit not in acl", Message{Method: REQResourceLimitExec, MethodArgs: []string{"cpu=1", "rm", "-rf", "/"}}, false},
+		{"run with lock allowed", Message{Method: REQRunWithLock, MethodArgs: []string{"migration", "30", "bash", "-i"}}, true},
+		{"run with lock not in acl", Message{Method: REQRunWithLock, MethodArgs: []string{"migration", "30", "sh", "-c", "rm -rf /"}}, false},
+		{"cli command cont not in acl", Message{Method: REQCliCommandCont, MethodArgs: []string{"tail", "-f", "/var/log/syslog"}}, false},
+		{"reconcile service allowed", Message{Method: REQReconcileState, Data: []byte(`{"services":[{"name":"nginx","state":"running"}]}`)}, true},
+		{"reconcile command not in acl", Message{Method: REQReconcileState, Data: []byte(`{"commands":[{"check":["systemctl","is-active","--quiet","nginx"],"apply":["rm","-rf","/"]}]}`)}, false},
+		{"reconcile invalid document", Message{Method: REQReconcileState, Data: []byte(`{`)}, false},
+		// Methods not running commands are not checked.
+		{"hello", Message{Method: REQHello}, true},
+	}
+
+	for _, tt := range tests {
+		tt.m.FromNode = "admin"
+		if got := n.verifyAcl(tt.m); got != tt.want {
+			t.Fatalf(" \U0001F631  [FAILED]: verifyAcl of %v, got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]	: %v\n", "TestACLCommandMethods")
+}
+
 // Need to clean up from the other tests before this test is enabled
 //
 // func TestACLHash(t *testing.T) {
@@ -527,3 +637,433 @@ func TestImportACLs(t *testing.T) {
 	t.Logf(" \U0001f600 [SUCCESS]	: %v\n", "TestImportACLs")
 
 }
+
+func TestACLDocument(t *testing.T) {
+	if !*logging {
+		log.SetOutput(io.Discard)
+	}
+
+	c := tstSrv.centralAuth
+
+	// The current acl's are restored when done, which also checks that
+	// the document can be imported again.
+	initial, err := c.exportACLDocument("yaml")
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: exportACLDocument: %v\n", err)
+	}
+	defer func() {
+		if err := c.importACLDocument(initial, true); err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]: importACLDocument: restore: %v\n", err)
+		}
+	}()
+
+	c.groupNodesAddNode("grp_nodes_docships", "ship500")
+	c.groupNodesAddNode("grp_nodes_docships", "ship501")
+	c.groupCommandsAddCommand("grp_commands_docset", "uptime")
+	c.aclAddCommand("grp_nodes_docships", "admin", "grp_commands_docset")
+	c.aclAddCommand("ship502", "operator", "regex:systemctl status .*")
+
+	exported, err := c.exportACLDocument("yaml")
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: exportACLDocument: %v\n", err)
+	}
+
+	// Replacing with the export should give the same export.
+	if err := c.importACLDocument(exported, true); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: importACLDocument: %v\n", err)
+	}
+	again, err := c.exportACLDocument("yaml")
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: exportACLDocument: %v\n", err)
+	}
+	if string(again) != string(exported) {
+		t.Fatalf(" \U0001F631  [FAILED]: the export changed when imported, before:\n%s\nafter:\n%s\n", exported, again)
+	}
+
+	// Edit the document by removing the rule for ship502, and adding
+	// a node to the group.
+	d, err := parseACLDocument(exported)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: parseACLDocument: %v\n", err)
+	}
+	for i, r := range d.ACLs {
+		if r.Host == "ship502" {
+			d.ACLs = append(d.ACLs[:i], d.ACLs[i+1:]...)
+			break
+		}
+	}
+	d.NodeGroups["grp_nodes_docships"] = append(d.NodeGroups["grp_nodes_docships"], "ship503")
+
+	edited, err := json.Marshal(d)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: marshal: %v\n", err)
+	}
+	if err := c.importACLDocument(edited, true); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: importACLDocument: %v\n", err)
+	}
+
+	c.accessLists.schemaMain.mu.Lock()
+	_, ship502 := c.accessLists.schemaMain.ACLMap["ship502"]
+	_, ship503 := c.accessLists.schemaMain.NodeGroupMap["grp_nodes_docships"]["ship503"]
+	_, group := c.accessLists.schemaMain.ACLMap["grp_nodes_docships"]["admin"]["grp_commands_docset"]
+	c.accessLists.schemaMain.mu.Unlock()
+	if ship502 || !ship503 || !group {
+		t.Fatalf(" \U0001F631  [FAILED]: want the edits imported, got ship502 %v, ship503 %v, group rule %v\n", ship502, ship503, group)
+	}
+
+	// Merging should keep what is not in the document.
+	merge := []byte(`{"version":1,"acls":[{"host":"ship504","source":"admin","commands":["date"]}]}`)
+	if err := c.importACLDocument(merge, false); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: importACLDocument: merge: %v\n", err)
+	}
+	c.accessLists.schemaMain.mu.Lock()
+	_, ship504 := c.accessLists.schemaMain.ACLMap["ship504"]["admin"]["date"]
+	_, group = c.accessLists.schemaMain.ACLMap["grp_nodes_docships"]["admin"]["grp_commands_docset"]
+	c.accessLists.schemaMain.mu.Unlock()
+	if !ship504 || !group {
+		t.Fatalf(" \U0001F631  [FAILED]: want the document merged, got ship504 %v, group rule %v\n", ship504, group)
+	}
+
+	// Nothing should be imported from a document that is not valid.
+	before, _ := c.exportACLDocument("json")
+	invalid := []string{
+		`{"acls":[{"host":"ship505","source":"admin","commands":["date"]}]}`,
+		`{"version":2,"acls":[{"host":"ship505","source":"admin","commands":["date"]}]}`,
+		`{"version":1,"acls":[{"host":"ship505","source":"","commands":["date"]}]}`,
+		`{"version":1,"acls":[{"host":"ship505","source":"admin","commands":[" "]}]}`,
+		`{"version":1,"acls":[{"host":"ship505","source":"admin","commands":["regex:("]}]}`,
+		`{"version":1,"acls":[{"host":"ship 505","source":"admin","commands":["date"]}]}`,
+		`{"version":1,"nodeGroups":{"ships":["ship505"]}}`,
+	}
+	for _, doc := range invalid {
+		if err := c.importACLDocument([]byte(doc), true); err == nil {
+			t.Fatalf(" \U0001F631  [FAILED]: want an error importing %v\n", doc)
+		}
+	}
+	after, _ := c.exportACLDocument("json")
+	if string(before) != string(after) {
+		t.Fatalf(" \U0001F631  [FAILED]: the acl's changed when importing invalid documents\n")
+	}
+
+	if !isACLDocument(merge) || isACLDocument([]byte(`{"ship101":{"admin":{"HORSE":{}}}}`)) {
+		t.Fatalf(" \U0001F631  [FAILED]: isACLDocument did not tell the document from the acl map\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]	: %v\n", "TestACLDocument")
+}
+
+func TestACLWhoCan(t *testing.T) {
+	if !*logging {
+		log.SetOutput(io.Discard)
+	}
+
+	c := tstSrv.centralAuth
+
+	c.groupNodesAddNode("grp_nodes_whocanops", "whocanop1")
+	c.groupNodesAddNode("grp_nodes_whocanops", "whocanop2")
+	c.groupCommandsAddCommand("grp_commands_whocan", "uptime")
+	c.aclAddCommand("whocanhost", "grp_nodes_whocanops", "grp_commands_whocan")
+	c.aclAddCommand("whocanhost", "whocanadmin", "*")
+	c.aclAddCommand("whocanhost", "whocanlogs", "regex:journalctl -u [a-z]+")
+	c.aclAddCommand("whocanhost", "whocanother", "date")
+
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{cmd: "uptime", want: "[{whocanadmin [*]} {whocanop1 [uptime]} {whocanop2 [uptime]}]"},
+		{cmd: "journalctl -u nats", want: "[{whocanadmin [*]} {whocanlogs [regex:journalctl -u [a-z]+]}]"},
+		{cmd: "rm -rf /", want: "[{whocanadmin [*]}]"},
+	}
+
+	for _, tt := range tests {
+		r, err := c.aclWhoCan("whocanhost", tt.cmd)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]: aclWhoCan: %v\n", err)
+		}
+		if got := fmt.Sprint(r.Sources); got != tt.want {
+			t.Fatalf(" \U0001F631  [FAILED]: aclWhoCan: command %q: want %v, got %v\n", tt.cmd, tt.want, got)
+		}
+	}
+
+	r, err := c.aclWhoCan("whocannohost", "uptime")
+	if err != nil || len(r.Sources) != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]: aclWhoCan: want no sources for a host without acl, got %v, %v\n", r.Sources, err)
+	}
+
+	c.aclDeleteSource("whocanhost", "grp_nodes_whocanops")
+	c.aclDeleteSource("whocanhost", "whocanadmin")
+	c.aclDeleteSource("whocanhost", "whocanlogs")
+	c.aclDeleteSource("whocanhost", "whocanother")
+	c.groupNodesDeleteGroup("grp_nodes_whocanops")
+	c.groupCommandDeleteGroup("grp_commands_whocan")
+
+	t.Logf(" \U0001f600 [SUCCESS]	: %v\n", "TestACLWhoCan")
+}
+
+func TestCloneNodeConfig(t *testing.T) {
+	if !*logging {
+		log.SetOutput(io.Discard)
+	}
+
+	const grp_nodes_clone = "grp_nodes_clone"
+
+	tstSrv.centralAuth.aclAddCommand("ship200", "admin", "ls")
+	tstSrv.centralAuth.aclAddCommand("ship200", "operator", "date")
+	tstSrv.centralAuth.aclAddCommand("ship300", "ship200", "uptime")
+	tstSrv.centralAuth.groupNodesAddNode(grp_nodes_clone, "ship200")
+
+	// Clone to a fresh node.
+	r, err := tstSrv.centralAuth.cloneNodeConfig("ship200", "ship201", false)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: cloneNodeConfig: %v\n", err)
+	}
+
+	acl := tstSrv.centralAuth.accessLists.schemaMain.ACLMap
+	if fmt.Sprintf("%v", acl["ship201"]) != fmt.Sprintf("%v", acl["ship200"]) {
+		t.Fatalf(" \U0001F631  [FAILED]: acl of target do not match source: got %v, want %v\n", acl["ship201"], acl["ship200"])
+	}
+	if _, ok := acl["ship300"]["ship201"]["uptime"]; !ok {
+		t.Fatalf(" \U0001F631  [FAILED]: target not allowed as source on host ship300\n")
+	}
+	if _, ok := tstSrv.centralAuth.accessLists.schemaMain.NodeGroupMap[grp_nodes_clone]["ship201"]; !ok {
+		t.Fatalf(" \U0001F631  [FAILED]: target not added to node group\n")
+	}
+	if len(r.Conflicts) != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]: got conflicts for fresh node: %v\n", r.Conflicts)
+	}
+
+	// Clone to a node with conflicting entries, first merging and then
+	// overwriting.
+	tstSrv.centralAuth.aclAddCommand("ship202", "someone", "rm")
+	tstSrv.centralAuth.aclAddCommand("ship400", "ship202", "reboot")
+
+	r, err = tstSrv.centralAuth.cloneNodeConfig("ship200", "ship202", false)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: cloneNodeConfig: %v\n", err)
+	}
+	if _, ok := acl["ship202"]["someone"]; !ok || len(r.Conflicts) != 2 {
+		t.Fatalf(" \U0001F631  [FAILED]: merge did not keep existing entry, conflicts: %v\n", r.Conflicts)
+	}
+	if _, ok := acl["ship400"]["ship202"]; !ok {
+		t.Fatalf(" \U0001F631  [FAILED]: merge did not keep the target as source on a host where the source is not\n")
+	}
+
+	_, err = tstSrv.centralAuth.cloneNodeConfig("ship200", "ship202", true)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: cloneNodeConfig: %v\n", err)
+	}
+	if fmt.Sprintf("%v", acl["ship202"]) != fmt.Sprintf("%v", acl["ship200"]) {
+		t.Fatalf(" \U0001F631  [FAILED]: overwrite did not replace existing entries: got %v, want %v\n", acl["ship202"], acl["ship200"])
+	}
+	if _, ok := acl["ship400"]["ship202"]; ok {
+		t.Fatalf(" \U0001F631  [FAILED]: overwrite did not remove the target as source on a host where the source is not\n")
+	}
+
+	// Overwriting with a source that is not a host should remove the
+	// acl's of the target as a host.
+	tstSrv.centralAuth.aclAddCommand("ship203", "someone", "rm")
+	tstSrv.centralAuth.aclAddCommand("ship400", "ship204", "reboot")
+	_, err = tstSrv.centralAuth.cloneNodeConfig("ship204", "ship203", true)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: cloneNodeConfig: %v\n", err)
+	}
+	if _, ok := acl["ship203"]; ok {
+		t.Fatalf(" \U0001F631  [FAILED]: overwrite did not remove the acl's of the target: %v\n", acl["ship203"])
+	}
+	if _, ok := acl["ship400"]["ship203"]["reboot"]; !ok {
+		t.Fatalf(" \U0001F631  [FAILED]: target not allowed as source on host ship400\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]	: %v\n", "TestCloneNodeConfig")
+}
+
+func TestCentralFailover(t *testing.T) {
+	if !*logging {
+		log.SetOutput(io.Discard)
+	}
+
+	tstSrv.centralAuth.aclAddCommand("ship400", "admin", "uptime")
+
+	// Prepare a standby with its own database folder. The central
+	// services are already started for the test server, so they are
+	// marked as started to not be started again when failing over.
+	standbyConf := newConfigurationDefaults()
+	standbyConf.NodeName = "standby"
+	standbyConf.CentralNodeName = "central"
+	standbyConf.DatabaseFolder = t.TempDir()
+	standbyConf.IsStandbyCentral = true
+	standbyConf.IsCentralErrorLogger = true
+	standbyConf.StartSubREQHello = true
+	standbyConf.IsCentralAuth = true
+	standby := newCentralAuth(&standbyConf, tstSrv.errorKernel)
+	defer standby.pki.db.Close()
+
+	ch := make(chan []subjectAndMessage, 100)
+	proc := tstSrv.processInitial
+	proc.configuration = &standbyConf
+	proc.toRingbufferCh = ch
+	proc.server = &server{
+		centralAuth:    standby,
+		processInitial: tstSrv.processInitial,
+		replicaApplied: &replicaApplied{},
+	}
+
+	// waitReply will wait for the reply to the message with the id given,
+	// and skip the other messages published that are not replies. A reply
+	// to another message fails the test.
+	waitReply := func(id int) string {
+		timeout := time.After(time.Second * 5)
+		for {
+			select {
+			case sams := <-ch:
+				for _, sam := range sams {
+					switch {
+					case sam.Message.PreviousMessage == nil:
+					case sam.Message.PreviousMessage.ID == id:
+						return string(sam.Message.Data)
+					default:
+						t.Fatalf(" \U0001F631  [FAILED]: want reply to message %v, got reply to message %v: %s\n", id, sam.Message.PreviousMessage.ID, sam.Message.Data)
+					}
+				}
+			case <-timeout:
+				t.Fatalf(" \U0001F631  [FAILED]: no reply to message %v\n", id)
+			}
+		}
+	}
+
+	// Replicate the state from the central to the standby with the
+	// handler. State from another node than the central, and state where
+	// the hash do not match, is refused.
+	js, err := tstSrv.centralAuth.exportState()
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: exportState: %v\n", err)
+	}
+	event := func(seq int64, state []byte, hash string) []byte {
+		ev := newReplicationEvent(seq, state)
+		if hash != "" {
+			ev.Hash = hash
+		}
+		evJs, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]: marshal replication event: %v\n", err)
+		}
+		return evJs
+	}
+	replicate := func(id int, from Node, data []byte) {
+		m := Message{ID: id, ToNode: "standby", FromNode: from, Method: REQCentralReplicate, Data: data, ReplyMethod: REQTest}
+		if _, err := (methodREQCentralReplicate{}).handler(proc, m, "standby"); err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]: REQCentralReplicate: %v\n", err)
+		}
+	}
+	replicate(1, "ship1", event(1, js, ""))
+	replicate(2, "central", event(2, js, ""))
+	waitReply(2)
+	bad := Message{ToNode: "standby", FromNode: "central", Method: REQCentralReplicate, Data: event(3, []byte(`{}`), newReplicationEvent(3, js).Hash)}
+	if _, err := applyReplicationEvent(proc, bad); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]: want state where the hash do not match refused\n")
+	}
+
+	// An older event is acknowledged but not stored, so it can't replace
+	// the newer state.
+	replicate(11, "central", event(1, []byte(`{}`), ""))
+	waitReply(11)
+
+	replica, err := loadCentralReplica(&standbyConf)
+	if err != nil || string(replica) != string(js) {
+		t.Fatalf(" \U0001F631  [FAILED]: want the state from central replicated, got err: %v\n", err)
+	}
+
+	// Failover with the handler, which loads the replicated state and
+	// makes the standby the central.
+	m := Message{ID: 3, ToNode: "standby", FromNode: "central", Method: REQFailover, ReplyMethod: REQTest}
+	if _, err := (methodREQFailover{}).handler(proc, m, "standby"); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: REQFailover: %v\n", err)
+	}
+	if out := waitReply(3); !strings.Contains(out, "standby is now serving as central") {
+		t.Fatalf(" \U0001F631  [FAILED]: REQFailover: want the standby serving as central, got: %v\n", out)
+	}
+	if c := standbyConf.centralNode(); c != "standby" {
+		t.Fatalf(" \U0001F631  [FAILED]: REQFailover: want central standby, got %v\n", c)
+	}
+
+	// The standby should now answer an acl query for the node with the
+	// same acl's as the central.
+	standby.accessLists.schemaGenerated.mu.Lock()
+	got, ok := standby.accessLists.schemaGenerated.GeneratedACLsMap["ship400"]
+	standby.accessLists.schemaGenerated.mu.Unlock()
+	if !ok {
+		t.Fatalf(" \U0001F631  [FAILED]: no acl's generated for ship400 on the standby\n")
+	}
+
+	tstSrv.centralAuth.accessLists.schemaGenerated.mu.Lock()
+	want := tstSrv.centralAuth.accessLists.schemaGenerated.GeneratedACLsMap["ship400"]
+	tstSrv.centralAuth.accessLists.schemaGenerated.mu.Unlock()
+	if got.Hash != want.Hash {
+		t.Fatalf(" \U0001F631  [FAILED]: acl hash on standby do not match central: got %v, want %v\n", got.Hash, want.Hash)
+	}
+
+	if _, ok := standby.accessLists.schemaMain.ACLMap["ship400"]["admin"]["uptime"]; !ok {
+		t.Fatalf(" \U0001F631  [FAILED]: acl not found in the main acl map of the standby\n")
+	}
+
+	// The central services not already started should be started when
+	// a node is promoted.
+	promoted := Configuration{CentralNodeName: "central"}
+	if errorLogger, hello, centralAuth := promoted.promoteToCentral("standby"); !errorLogger || !hello || !centralAuth || !promoted.isCentralAuth() {
+		t.Fatalf(" \U0001F631  [FAILED]: want all the central services started when promoted\n")
+	}
+
+	// A node should only change central when the configured standby
+	// announces itself with a valid signature.
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: failed to generate keys: %v\n", err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]: failed to generate keys: %v\n", err)
+	}
+
+	nodeConf := Configuration{NodeName: "ship1", CentralNodeName: "central", StandbyCentralNode: "standby", SignatureMaxSkew: 300}
+	nodeProc := tstSrv.processInitial
+	nodeProc.configuration = &nodeConf
+	nodeProc.toRingbufferCh = ch
+	nodeProc.nodeAuth = &nodeAuth{
+		publicKeys:       &publicKeys{keysAndHash: newKeysAndHash()},
+		signatureMethods: newSignatureMethods(""),
+		nonceCache:       newNonceCache(nonceCacheMaxEntries),
+		configuration:    &nodeConf,
+		errorKernel:      tstSrv.errorKernel,
+	}
+	nodeProc.nodeAuth.publicKeys.keysAndHash.Keys["standby"] = pub
+	nodeProc.nodeAuth.publicKeys.keysAndHash.Keys["ship2"] = pub
+
+	announce := func(id int, from Node, newCentral string, key ed25519.PrivateKey) {
+		m := Message{ID: id, ToNode: "ship1", FromNode: from, Method: REQCentralChanged, MethodArgs: []string{newCentral}, ReplyMethod: REQTest, SignedAt: time.Now().Unix(), SignNonce: fmt.Sprint("nonce", id)}
+		m.ArgSignature = ed25519.Sign(key, []byte(signedString(m)))
+		if _, err := (methodREQCentralChanged{}).handler(nodeProc, m, "ship1"); err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]: REQCentralChanged: %v\n", err)
+		}
+	}
+
+	// Another node announcing itself, a signature from another key, and
+	// the standby announcing another node should all be refused.
+	announce(10, "ship2", "ship2", priv)
+	announce(11, "standby", "standby", otherPriv)
+	announce(12, "standby", "ship2", priv)
+	announce(13, "standby", "standby", priv)
+	if out := waitReply(13); out != "central changed to standby" {
+		t.Fatalf(" \U0001F631  [FAILED]: REQCentralChanged: want central changed, got: %v\n", out)
+	}
+	select {
+	case sams := <-ch:
+		t.Fatalf(" \U0001F631  [FAILED]: REQCentralChanged: want the other announcements refused, got: %v\n", sams[0].Message)
+	case <-time.After(time.Millisecond * 500):
+	}
+	if c := nodeConf.centralNode(); c != "standby" {
+		t.Fatalf(" \U0001F631  [FAILED]: REQCentralChanged: want central standby, got %v\n", c)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]	: %v\n", "TestCentralFailover")
+}
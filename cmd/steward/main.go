@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
@@ -63,11 +64,18 @@ func main() {
 	// Adding a safety function here so we can make sure that all processes
 	// are stopped after a given time if the context cancelation hangs.
 	go func() {
-		time.Sleep(time.Second * 10)
+		time.Sleep(time.Second * 15)
 		log.Printf("error: doing a non graceful shutdown of all processes..\n")
 		os.Exit(1)
 	}()
 
-	// Stop all processes.
-	s.Stop()
+	// Stop all processes, and give the messages being handled 10 seconds
+	// to finish.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	err = s.Stop(ctx)
+	if err != nil {
+		log.Printf("%v\n", err)
+	}
 }
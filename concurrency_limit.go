@@ -0,0 +1,77 @@
+package steward
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// concurrencyLimits holds the semaphores limiting the number of messages
+// handled at the same time by the subscribers of a method, as set with
+// the MaxConcurrent configuration.
+type concurrencyLimits struct {
+	// The semaphore of each limited method, with a buffer size of the
+	// max number of messages handled at the same time.
+	sems map[Method]chan struct{}
+}
+
+// newConcurrencyLimits will parse the comma separated list of
+// method=number given, and return the limits. An error is returned if
+// the list is not valid. Methods not listed are unlimited.
+func newConcurrencyLimits(maxConcurrent string) (*concurrencyLimits, error) {
+	c := concurrencyLimits{
+		sems: make(map[Method]chan struct{}),
+	}
+
+	if strings.TrimSpace(maxConcurrent) == "" {
+		return &c, nil
+	}
+
+	var mt Method
+	ma := mt.GetMethodsAvailable()
+
+	for _, v := range strings.Split(maxConcurrent, ",") {
+		kv := strings.SplitN(strings.TrimSpace(v), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("maxConcurrent: %q is not in the format method=number", v)
+		}
+
+		method := Method(strings.TrimSpace(kv[0]))
+		if _, ok := ma.CheckIfExists(method); !ok {
+			return nil, fmt.Errorf("maxConcurrent: no such method: %v", method)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("maxConcurrent: the limit for %v must be a number above 0, got: %v", method, kv[1])
+		}
+
+		c.sems[method] = make(chan struct{}, n)
+	}
+
+	return &c, nil
+}
+
+// acquire will wait for a free slot for the method, and return the func
+// to release the slot again. The wait is aborted if done is closed, and
+// false is returned. Methods without a limit will always get a slot.
+func (c *concurrencyLimits) acquire(method Method, done <-chan struct{}) (func(), bool) {
+	sem, ok := c.sems[method]
+	if !ok {
+		return func() {}, true
+	}
+
+	select {
+	case sem <- struct{}{}:
+	case <-done:
+		return nil, false
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() { <-sem })
+	}
+
+	return release, true
+}
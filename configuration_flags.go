@@ -4,8 +4,13 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
 
 	toml "github.com/pelletier/go-toml"
 )
@@ -19,6 +24,22 @@ import (
 type Configuration struct {
 	// RingBufferSize
 	RingBufferSize int
+	// PriorityAgingInterval is the number of seconds a message waiting
+	// in the ringbuffer is aged up one priority tier after, so messages
+	// with a lower priority are not starved by a steady stream of
+	// messages with a higher priority. 0 disables the aging.
+	PriorityAgingInterval int
+	// RingBufferPersist will store the messages in the ring buffer on
+	// disk until they are delivered, so they are resent after a restart.
+	RingBufferPersist bool
+	// RingBufferPersistPath is the path of the database file the messages
+	// of the ring buffer are stored in. If empty the file is created in
+	// the DatabaseFolder.
+	RingBufferPersistPath string
+	// RingBufferMaxPersisted is the max number of messages stored on disk
+	// waiting to be delivered. When reached no new messages are accepted
+	// until some of the stored messages are delivered. 0 is unlimited.
+	RingBufferMaxPersisted int
 	// The configuration folder on disk
 	ConfigFolder string
 	// The folder where the socket file should live
@@ -27,6 +48,19 @@ type Configuration struct {
 	TCPListener string
 	// HTTP Listener for sending messages to the system
 	HTTPListener string
+	// HTTPListenerCertFile and HTTPListenerKeyFile are the paths of the
+	// certificate and key to serve the HTTP listener with TLS. If empty
+	// plain HTTP is used.
+	HTTPListenerCertFile string
+	HTTPListenerKeyFile  string
+	// HTTPListenerClientCAFile is the path of the CA certificates used to
+	// verify the client certificates of the requests to the HTTP
+	// listener. Requires TLS.
+	HTTPListenerClientCAFile string
+	// HTTPListenerTokenFile is the path of the file holding the bearer
+	// token the requests to the HTTP listener must carry. If neither a
+	// token nor a client CA is given all requests are accepted.
+	HTTPListenerTokenFile string
 	// The folder where the database should live
 	DatabaseFolder string
 	// some unique string to identify this Edge unit
@@ -41,6 +75,10 @@ type Configuration struct {
 	NatsReconnectJitter int
 	// NatsReconnectJitterTLS in seconds
 	NatsReconnectJitterTLS int
+	// NatsConnPoolSize is the number of connections to the nats server,
+	// where the publishers and subscribers are spread round robin over
+	// the connections. The default is a single connection.
+	NatsConnPoolSize int
 	// REQKeysRequestUpdateInterval in seconds
 	REQKeysRequestUpdateInterval int
 	// REQAclRequestUpdateInterval in seconds
@@ -49,6 +87,9 @@ type Configuration struct {
 	ProfilingPort string
 	// host and port for prometheus listener, e.g. localhost:2112
 	PromHostAndPort string
+	// The comma separated upper bounds in seconds of the buckets of the
+	// histogram of the method handler execution time.
+	PromHandlerDurationBuckets string
 	// set to true if this is the node that should receive the error log's from other nodes
 	DefaultMessageTimeout int
 	// Default value for how long can a request method max be allowed to run.
@@ -57,6 +98,56 @@ type Configuration struct {
 	DefaultMessageRetries int
 	// Publisher data folder
 	SubscribersDataFolder string
+	// ReplyFolderTemplate is a text/template for the folder of the reply
+	// files within the SubscribersDataFolder, like
+	// "{{.Directory}}/{{.Date}}/{{.Node}}". The variables are Node, Method,
+	// Date, MessageID and Directory. If not set the folder is the
+	// directory of the message followed by the node.
+	ReplyFolderTemplate string
+	// Comma separated list of the folders where methods editing files,
+	// like REQPartialUpdateFile, are allowed to operate. If empty no
+	// files are allowed to be edited.
+	AllowedFileRoots string
+	// DefaultFileMode is the mode in octal, like 0644, for the files written
+	// by the file handlers. The handlers own mode is used if empty.
+	DefaultFileMode string
+	// DefaultDirMode is the mode in octal, like 0755, for the directories
+	// created by the file handlers. 0700 is used if empty.
+	DefaultDirMode string
+	// ToFileAppendRotateSize is the size in bytes a file appended to with
+	// REQToFileAppend is rotated at. 0 disables the rotation.
+	ToFileAppendRotateSize int
+	// ToFileAppendRotateKeep is the number of rotated copies of a file
+	// kept when rotated by REQToFileAppend.
+	ToFileAppendRotateKeep int
+	// MaxConcurrent is a comma separated list of method=number, like
+	// REQCliCommand=4, limiting the number of messages handled at the
+	// same time by the subscriber of the method. Messages above the
+	// limit are queued. Methods not listed are unlimited.
+	MaxConcurrent string
+	// DedupWindow is the number of seconds an ACK message received is
+	// remembered, so a message resent by the publisher is not handled
+	// again. 0 disables the deduplication.
+	DedupWindow int
+	// DedupMethods is a comma separated list of the methods to
+	// deduplicate. If empty all the methods that are not read-only are
+	// deduplicated.
+	DedupMethods string
+	// InboundRateLimit is the number of messages per second handled
+	// from each node. The messages above the limit are dropped. 0
+	// disables the rate limiting.
+	InboundRateLimit int
+	// InboundRateBurst is the number of messages a node can send above
+	// the InboundRateLimit in a burst.
+	InboundRateBurst int
+	// InboundRateLimitNodes is a comma separated list of node=rate or
+	// node=rate:burst, overriding the rate and burst for the nodes
+	// listed. A rate of 0 is unlimited.
+	InboundRateLimitNodes string
+	// CliCommandContMaxOutputBytes is the default max number of bytes of
+	// output delivered by REQCliCommandCont before the command is
+	// killed, used when not set in the message. 0 is unlimited.
+	CliCommandContMaxOutputBytes int
 	// central node to receive messages published from nodes
 	CentralNodeName string
 	// Path to the certificate of the root CA
@@ -71,6 +162,9 @@ type Configuration struct {
 	ErrorMessageTimeout int
 	// Retries for error messages.
 	ErrorMessageRetries int
+	// URL of a http webhook where errors are also posted to. The webhook
+	// error sink are disabled if empty.
+	ErrorWebhookURL string
 	// Compression
 	Compression string
 	// Serialization
@@ -85,11 +179,43 @@ type Configuration struct {
 	EnableSignatureCheck bool
 	// EnableAclCheck
 	EnableAclCheck bool
+	// SignatureCheckMethods is a comma separated list of the methods that
+	// require a valid signature when EnableSignatureCheck is set. If empty
+	// the methods in defaultSignatureMethods require a signature.
+	SignatureCheckMethods string
+	// SignatureMaxSkew is the max number of seconds the time a message
+	// was signed can differ from the time of the receiving node, for the
+	// message to be accepted when signature checking is enabled.
+	SignatureMaxSkew int
+	// SignKeysRotateGrace is the number of seconds the old public signing
+	// key of a node is still accepted after the keys were rotated with
+	// REQKeysRotate.
+	SignKeysRotateGrace int
+	// ValidateTrustStoreOnStartup will check the integrity of the stored
+	// public keys, their hash, and the signing keys of the node at startup.
+	ValidateTrustStoreOnStartup bool
+	// AbortOnTrustStoreError will stop the startup if problems are found
+	// when ValidateTrustStoreOnStartup is enabled.
+	AbortOnTrustStoreError bool
 	// IsCentralAuth
 	IsCentralAuth bool
+	// IsStandbyCentral tells if the node is a standby that can take
+	// over as central with REQFailover
+	IsStandbyCentral bool
+	// StandbyCentralNode is the name of the standby node that the central
+	// auth state are replicated to. Empty means no replication
+	StandbyCentralNode string
+	// ReplicateToNodes are the comma separated standby nodes the central
+	// auth state are continuously replicated to with REQReplicateTo.
+	ReplicateToNodes string
+	// REQCentralReplicateInterval in seconds
+	REQCentralReplicateInterval int
 	// EnableDebug will also enable printing all the messages received in the errorKernel
 	// to STDERR.
 	EnableDebug bool
+	// LogJSON will write the log entries as json objects with the level,
+	// node, subject, message ID and method as fields, instead of text.
+	LogJSON bool
 
 	// Make the current node send hello messages to central at given interval in seconds
 	StartPubREQHello int
@@ -101,18 +227,33 @@ type Configuration struct {
 
 	// Start the central error logger.
 	IsCentralErrorLogger bool
+	// ErrorAggregateWindow is the number of seconds identical errors
+	// from the node are coalesced, so only the first is sent and then
+	// one error with the number of times it was repeated when the
+	// window closes. 0 sends every error.
+	ErrorAggregateWindow int
 	// Subscriber for hello messages
 	StartSubREQHello bool
+	// HelloStaleMultiplier is the number of hello intervals, as set with
+	// StartPubREQHello, a node can be silent before central reports it
+	// as stale. 0 disables the check.
+	HelloStaleMultiplier int
 	// Subscriber for text logging
 	StartSubREQToFileAppend bool
 	// Subscriber for writing to file
 	StartSubREQToFile bool
 	// Subscriber for writing to file without ACK
 	StartSubREQToFileNACK bool
+	// Subscriber for writing to file only if the content changed
+	StartSubREQWriteFileIfChanged bool
 	// Subscriber for reading files to copy
 	StartSubREQCopyFileFrom bool
 	// Subscriber for writing copied files to disk
 	StartSubREQCopyFileTo bool
+	// Subscriber for reading directories to copy
+	StartSubREQCopyDirFrom bool
+	// Subscriber for writing copied directories to disk
+	StartSubREQCopyDirTo bool
 	// Subscriber for Echo Request
 	StartSubREQPing bool
 	// Subscriber for Echo Reply
@@ -125,12 +266,108 @@ type Configuration struct {
 	StartSubREQHttpGet bool
 	// Subscriber for REQHttpGetScheduled
 	StartSubREQHttpGetScheduled bool
-	// Subscriber for tailing log files
+	// Subscriber for http post
+	StartSubREQHttpPost bool
+	// HttpPostFollowRedirects tells if REQHttpPost should follow the
+	// redirects of the server, or reply with the redirect response.
+	HttpPostFollowRedirects bool
+	// Subscriber for tailing log files, and for stopping the tails
 	StartSubREQTailFile bool
+	// Subscriber for publishing messages on an interval
+	StartSubREQScheduled bool
 	// Subscriber for continously delivery of output from cli commands.
 	StartSubREQCliCommandCont bool
+	// Subscriber for interactive command sessions.
+	StartSubREQStreamCommand bool
+	// Start subscriber for listing the active sessions of the node
+	StartSubREQListActiveSessions bool
 	// Subscriber for relay messages.
 	StartSubREQRelay bool
+	// RelayMaxHops is the max number of nodes a message can be relayed
+	// by before it is dropped. 0 is no limit.
+	RelayMaxHops int
+	// Subscriber for setting the default message values
+	StartSubREQSetMessageDefaults bool
+	// Subscriber for setting the priority policy
+	StartSubREQSetPriorityPolicy bool
+	// Subscriber for rebuilding the index of the data folder
+	StartSubREQReindexDataFolder bool
+	// Subscriber for searching the index of the data folder
+	StartSubREQSearchDataFolder bool
+	// Subscriber for toggling degraded mode
+	StartSubREQDegradedMode bool
+	// Subscriber for verifying the integrity of the data folder
+	StartSubREQVerifyDataIntegrity bool
+	// Subscriber for compressing the stored reply files in the data folder
+	StartSubREQCompressStoredReplies bool
+	// Subscriber for streaming metric values
+	StartSubREQSubscribeMetrics bool
+	// Subscriber for doing partial updates of files
+	StartSubREQPartialUpdateFile bool
+	// Subscriber for auditing the connections to the local listeners
+	StartSubREQConnectionAudit bool
+	// Start subscriber for listing the error sinks
+	StartSubREQListErrorSinks bool
+	// Start subscriber for managing the error sinks
+	StartSubREQManageErrorSink bool
+	// Start subscriber for measuring the throughput to other nodes
+	StartSubREQMeasureThroughput bool
+	// Start subscriber for receiving throughput test data
+	StartSubREQThroughputDiscard bool
+	// Start subscriber for querying the read-only providers
+	StartSubREQQuery bool
+	// Start subscriber for fetching several files in one request
+	StartSubREQBulkFileFetch bool
+	// Start subscriber for previewing the rendering of a file template
+	StartSubREQFileTemplateRenderPreview bool
+	// Start subscriber for replying with the metadata of a message
+	StartSubREQAttachMetadata bool
+	// Start subscriber for reconciling the node with a desired state
+	StartSubREQReconcileState bool
+	// Start subscriber for scheduling a shutdown of the node
+	StartSubREQShutdownScheduled bool
+	// Start subscriber for validating the trust store
+	StartSubREQValidateTrustStore bool
+	// Start subscriber for inspecting the go routines of the processes
+	StartSubREQInspectProcessGoroutines bool
+	// Start subscriber for listing the dead letter store
+	StartSubREQListFailedMessages bool
+	// Start subscriber for inspecting the state of the messages being retried
+	StartSubREQInspectRetryState bool
+	// Start subscriber for adopting a new node name given by the central
+	StartSubREQAdoptNodeName bool
+	// Subscriber for rotating the signing keys of the node.
+	StartSubREQKeysRotate bool
+	// Start subscriber for inspecting the effective timeouts of a message
+	StartSubREQInspectTimeouts bool
+	// Start subscriber for running a command while holding a lock on central
+	StartSubREQRunWithLock bool
+	// Start subscriber for probing if a method is supported and authorized
+	StartSubREQProbeMethod bool
+	// Subscriber for being told that a new node is serving as central
+	StartSubREQCentralChanged bool
+	// Subscriber for inspecting the signature of a message
+	StartSubREQInspectSignature bool
+	// Subscriber for inspecting the nodes whose signatures are trusted
+	StartSubREQInspectAllowedSignatures bool
+	// Subscriber for replying with the commands the sender is allowed to run
+	StartSubREQEnvInfo bool
+	// Subscriber for listing the public keys known by the node
+	StartSubREQPublicKeysList bool
+	// Subscriber for probing the reachability of other nodes
+	StartSubREQReachabilityProbe bool
+	// Subscriber for running CLI commands with resource limits
+	StartSubREQResourceLimitExec bool
+	// Subscriber for setting the system clock from the central.
+	// The node needs to be privileged to be allowed to set the clock
+	StartSubREQSyncTime bool
+	// TimeSyncMaxJump is the max number of seconds REQSyncTime is allowed
+	// to adjust the clock without force
+	TimeSyncMaxJump int
+	// Subscriber for replying with the current time
+	StartSubREQTimeNow bool
+	// Subscriber for replying with the host diagnostics of the node
+	StartSubREQNodeInfo bool
 }
 
 // ConfigurationFromFile should have the same structure as
@@ -140,9 +377,17 @@ type Configuration struct {
 type ConfigurationFromFile struct {
 	ConfigFolder                 *string
 	RingBufferSize               *int
+	PriorityAgingInterval        *int
+	RingBufferPersist            *bool
+	RingBufferPersistPath        *string
+	RingBufferMaxPersisted       *int
 	SocketFolder                 *string
 	TCPListener                  *string
 	HTTPListener                 *string
+	HTTPListenerCertFile         *string
+	HTTPListenerKeyFile          *string
+	HTTPListenerClientCAFile     *string
+	HTTPListenerTokenFile        *string
 	DatabaseFolder               *string
 	NodeName                     *string
 	BrokerAddress                *string
@@ -150,20 +395,36 @@ type ConfigurationFromFile struct {
 	NatsConnectRetryInterval     *int
 	NatsReconnectJitter          *int
 	NatsReconnectJitterTLS       *int
+	NatsConnPoolSize             *int
 	REQKeysRequestUpdateInterval *int
 	REQAclRequestUpdateInterval  *int
 	ProfilingPort                *string
 	PromHostAndPort              *string
+	PromHandlerDurationBuckets   *string
 	DefaultMessageTimeout        *int
 	DefaultMessageRetries        *int
 	DefaultMethodTimeout         *int
 	SubscribersDataFolder        *string
+	ReplyFolderTemplate          *string
+	AllowedFileRoots             *string
+	DefaultFileMode              *string
+	DefaultDirMode               *string
+	ToFileAppendRotateSize       *int
+	ToFileAppendRotateKeep       *int
+	MaxConcurrent                *string
+	DedupWindow                  *int
+	DedupMethods                 *string
+	InboundRateLimit             *int
+	InboundRateBurst             *int
+	InboundRateLimitNodes        *string
+	CliCommandContMaxOutputBytes *int
 	CentralNodeName              *string
 	RootCAPath                   *string
 	NkeySeedFile                 *string
 	ExposeDataFolder             *string
 	ErrorMessageTimeout          *int
 	ErrorMessageRetries          *int
+	ErrorWebhookURL              *string
 	Compression                  *string
 	Serialization                *string
 	SetBlockProfileRate          *int
@@ -171,28 +432,89 @@ type ConfigurationFromFile struct {
 	EnableTUI                    *bool
 	EnableSignatureCheck         *bool
 	EnableAclCheck               *bool
+	SignatureCheckMethods        *string
+	SignatureMaxSkew             *int
+	SignKeysRotateGrace          *int
+	ValidateTrustStoreOnStartup  *bool
+	AbortOnTrustStoreError       *bool
 	IsCentralAuth                *bool
+	IsStandbyCentral             *bool
+	StandbyCentralNode           *string
+	ReplicateToNodes             *string
+	REQCentralReplicateInterval  *int
 	EnableDebug                  *bool
+	LogJSON                      *bool
 
-	StartPubREQHello            *int
-	EnableKeyUpdates            *bool
-	EnableAclUpdates            *bool
-	IsCentralErrorLogger        *bool
-	StartSubREQHello            *bool
-	StartSubREQToFileAppend     *bool
-	StartSubREQToFile           *bool
-	StartSubREQToFileNACK       *bool
-	StartSubREQCopyFileFrom     *bool
-	StartSubREQCopyFileTo       *bool
-	StartSubREQPing             *bool
-	StartSubREQPong             *bool
-	StartSubREQCliCommand       *bool
-	StartSubREQToConsole        *bool
-	StartSubREQHttpGet          *bool
-	StartSubREQHttpGetScheduled *bool
-	StartSubREQTailFile         *bool
-	StartSubREQCliCommandCont   *bool
-	StartSubREQRelay            *bool
+	StartPubREQHello                     *int
+	EnableKeyUpdates                     *bool
+	EnableAclUpdates                     *bool
+	IsCentralErrorLogger                 *bool
+	ErrorAggregateWindow                 *int
+	StartSubREQHello                     *bool
+	HelloStaleMultiplier                 *int
+	StartSubREQToFileAppend              *bool
+	StartSubREQToFile                    *bool
+	StartSubREQToFileNACK                *bool
+	StartSubREQWriteFileIfChanged        *bool
+	StartSubREQCopyFileFrom              *bool
+	StartSubREQCopyFileTo                *bool
+	StartSubREQCopyDirFrom               *bool
+	StartSubREQCopyDirTo                 *bool
+	StartSubREQPing                      *bool
+	StartSubREQPong                      *bool
+	StartSubREQCliCommand                *bool
+	StartSubREQToConsole                 *bool
+	StartSubREQHttpGet                   *bool
+	StartSubREQHttpGetScheduled          *bool
+	StartSubREQHttpPost                  *bool
+	HttpPostFollowRedirects              *bool
+	StartSubREQTailFile                  *bool
+	StartSubREQScheduled                 *bool
+	StartSubREQCliCommandCont            *bool
+	StartSubREQStreamCommand             *bool
+	StartSubREQListActiveSessions        *bool
+	StartSubREQRelay                     *bool
+	RelayMaxHops                         *int
+	StartSubREQSetMessageDefaults        *bool
+	StartSubREQSetPriorityPolicy         *bool
+	StartSubREQReindexDataFolder         *bool
+	StartSubREQSearchDataFolder          *bool
+	StartSubREQDegradedMode              *bool
+	StartSubREQVerifyDataIntegrity       *bool
+	StartSubREQCompressStoredReplies     *bool
+	StartSubREQSubscribeMetrics          *bool
+	StartSubREQPartialUpdateFile         *bool
+	StartSubREQConnectionAudit           *bool
+	StartSubREQListErrorSinks            *bool
+	StartSubREQManageErrorSink           *bool
+	StartSubREQMeasureThroughput         *bool
+	StartSubREQThroughputDiscard         *bool
+	StartSubREQQuery                     *bool
+	StartSubREQBulkFileFetch             *bool
+	StartSubREQFileTemplateRenderPreview *bool
+	StartSubREQAttachMetadata            *bool
+	StartSubREQReconcileState            *bool
+	StartSubREQShutdownScheduled         *bool
+	StartSubREQValidateTrustStore        *bool
+	StartSubREQInspectProcessGoroutines  *bool
+	StartSubREQListFailedMessages        *bool
+	StartSubREQInspectRetryState         *bool
+	StartSubREQAdoptNodeName             *bool
+	StartSubREQKeysRotate                *bool
+	StartSubREQInspectTimeouts           *bool
+	StartSubREQRunWithLock               *bool
+	StartSubREQProbeMethod               *bool
+	StartSubREQCentralChanged            *bool
+	StartSubREQInspectSignature          *bool
+	StartSubREQInspectAllowedSignatures  *bool
+	StartSubREQEnvInfo                   *bool
+	StartSubREQPublicKeysList            *bool
+	StartSubREQReachabilityProbe         *bool
+	StartSubREQResourceLimitExec         *bool
+	StartSubREQSyncTime                  *bool
+	TimeSyncMaxJump                      *int
+	StartSubREQTimeNow                   *bool
+	StartSubREQNodeInfo                  *bool
 }
 
 // NewConfiguration will return a *Configuration.
@@ -206,9 +528,17 @@ func newConfigurationDefaults() Configuration {
 	c := Configuration{
 		ConfigFolder:                 "./etc/",
 		RingBufferSize:               1000,
+		PriorityAgingInterval:        30,
+		RingBufferPersist:            true,
+		RingBufferPersistPath:        "",
+		RingBufferMaxPersisted:       100000,
 		SocketFolder:                 "./tmp",
 		TCPListener:                  "",
 		HTTPListener:                 "",
+		HTTPListenerCertFile:         "",
+		HTTPListenerKeyFile:          "",
+		HTTPListenerClientCAFile:     "",
+		HTTPListenerTokenFile:        "",
 		DatabaseFolder:               "./var/lib",
 		NodeName:                     "",
 		BrokerAddress:                "127.0.0.1:4222",
@@ -216,20 +546,36 @@ func newConfigurationDefaults() Configuration {
 		NatsConnectRetryInterval:     10,
 		NatsReconnectJitter:          100,
 		NatsReconnectJitterTLS:       1,
+		NatsConnPoolSize:             1,
 		REQKeysRequestUpdateInterval: 60,
 		REQAclRequestUpdateInterval:  60,
 		ProfilingPort:                "",
 		PromHostAndPort:              "",
+		PromHandlerDurationBuckets:   "0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5,10,30,60,300",
 		DefaultMessageTimeout:        10,
 		DefaultMessageRetries:        1,
 		DefaultMethodTimeout:         10,
 		SubscribersDataFolder:        "./data",
+		ReplyFolderTemplate:          "",
+		AllowedFileRoots:             "",
+		DefaultFileMode:              "",
+		DefaultDirMode:               "",
+		ToFileAppendRotateSize:       0,
+		ToFileAppendRotateKeep:       5,
+		MaxConcurrent:                "",
+		DedupWindow:                  120,
+		DedupMethods:                 "",
+		InboundRateLimit:             0,
+		InboundRateBurst:             20,
+		InboundRateLimitNodes:        "",
+		CliCommandContMaxOutputBytes: 10485760,
 		CentralNodeName:              "",
 		RootCAPath:                   "",
 		NkeySeedFile:                 "",
 		ExposeDataFolder:             "",
 		ErrorMessageTimeout:          60,
 		ErrorMessageRetries:          10,
+		ErrorWebhookURL:              "",
 		Compression:                  "",
 		Serialization:                "",
 		SetBlockProfileRate:          0,
@@ -237,28 +583,89 @@ func newConfigurationDefaults() Configuration {
 		EnableTUI:                    false,
 		EnableSignatureCheck:         false,
 		EnableAclCheck:               false,
+		SignatureCheckMethods:        "",
+		SignatureMaxSkew:             300,
+		SignKeysRotateGrace:          300,
+		ValidateTrustStoreOnStartup:  false,
+		AbortOnTrustStoreError:       false,
 		IsCentralAuth:                false,
+		IsStandbyCentral:             false,
+		StandbyCentralNode:           "",
+		ReplicateToNodes:             "",
+		REQCentralReplicateInterval:  60,
 		EnableDebug:                  false,
+		LogJSON:                      false,
 
-		StartPubREQHello:            30,
-		EnableKeyUpdates:            true,
-		EnableAclUpdates:            true,
-		IsCentralErrorLogger:        false,
-		StartSubREQHello:            true,
-		StartSubREQToFileAppend:     true,
-		StartSubREQToFile:           true,
-		StartSubREQToFileNACK:       true,
-		StartSubREQCopyFileFrom:     true,
-		StartSubREQCopyFileTo:       true,
-		StartSubREQPing:             true,
-		StartSubREQPong:             true,
-		StartSubREQCliCommand:       true,
-		StartSubREQToConsole:        true,
-		StartSubREQHttpGet:          true,
-		StartSubREQHttpGetScheduled: true,
-		StartSubREQTailFile:         true,
-		StartSubREQCliCommandCont:   true,
-		StartSubREQRelay:            false,
+		StartPubREQHello:                     30,
+		EnableKeyUpdates:                     true,
+		EnableAclUpdates:                     true,
+		IsCentralErrorLogger:                 false,
+		ErrorAggregateWindow:                 0,
+		StartSubREQHello:                     true,
+		HelloStaleMultiplier:                 3,
+		StartSubREQToFileAppend:              true,
+		StartSubREQToFile:                    true,
+		StartSubREQToFileNACK:                true,
+		StartSubREQWriteFileIfChanged:        true,
+		StartSubREQCopyFileFrom:              true,
+		StartSubREQCopyFileTo:                true,
+		StartSubREQCopyDirFrom:               true,
+		StartSubREQCopyDirTo:                 true,
+		StartSubREQPing:                      true,
+		StartSubREQPong:                      true,
+		StartSubREQCliCommand:                true,
+		StartSubREQToConsole:                 true,
+		StartSubREQHttpGet:                   true,
+		StartSubREQHttpGetScheduled:          true,
+		StartSubREQHttpPost:                  true,
+		HttpPostFollowRedirects:              true,
+		StartSubREQTailFile:                  true,
+		StartSubREQScheduled:                 true,
+		StartSubREQCliCommandCont:            true,
+		StartSubREQStreamCommand:             true,
+		StartSubREQListActiveSessions:        true,
+		StartSubREQRelay:                     false,
+		RelayMaxHops:                         10,
+		StartSubREQSetMessageDefaults:        true,
+		StartSubREQSetPriorityPolicy:         true,
+		StartSubREQReindexDataFolder:         true,
+		StartSubREQSearchDataFolder:          true,
+		StartSubREQDegradedMode:              true,
+		StartSubREQVerifyDataIntegrity:       true,
+		StartSubREQCompressStoredReplies:     true,
+		StartSubREQSubscribeMetrics:          true,
+		StartSubREQPartialUpdateFile:         true,
+		StartSubREQConnectionAudit:           true,
+		StartSubREQListErrorSinks:            true,
+		StartSubREQManageErrorSink:           true,
+		StartSubREQMeasureThroughput:         true,
+		StartSubREQThroughputDiscard:         true,
+		StartSubREQQuery:                     true,
+		StartSubREQBulkFileFetch:             true,
+		StartSubREQFileTemplateRenderPreview: true,
+		StartSubREQAttachMetadata:            true,
+		StartSubREQReconcileState:            true,
+		StartSubREQShutdownScheduled:         true,
+		StartSubREQValidateTrustStore:        true,
+		StartSubREQInspectProcessGoroutines:  true,
+		StartSubREQListFailedMessages:        true,
+		StartSubREQInspectRetryState:         true,
+		StartSubREQAdoptNodeName:             false,
+		StartSubREQKeysRotate:                true,
+		StartSubREQInspectTimeouts:           true,
+		StartSubREQRunWithLock:               true,
+		StartSubREQProbeMethod:               true,
+		StartSubREQCentralChanged:            true,
+		StartSubREQInspectSignature:          true,
+		StartSubREQInspectAllowedSignatures:  true,
+		StartSubREQEnvInfo:                   true,
+		StartSubREQPublicKeysList:            true,
+		StartSubREQReachabilityProbe:         true,
+		StartSubREQResourceLimitExec:         true,
+		StartSubREQSyncTime:                  false,
+		TimeSyncMaxJump:                      60,
+		StartSubREQTimeNow:                   true,
+		StartSubREQNodeInfo:                  true,
 	}
 	return c
 }
@@ -274,6 +681,26 @@ func checkConfigValues(cf ConfigurationFromFile) Configuration {
 	} else {
 		conf.RingBufferSize = *cf.RingBufferSize
 	}
+	if cf.PriorityAgingInterval == nil {
+		conf.PriorityAgingInterval = cd.PriorityAgingInterval
+	} else {
+		conf.PriorityAgingInterval = *cf.PriorityAgingInterval
+	}
+	if cf.RingBufferPersist == nil {
+		conf.RingBufferPersist = cd.RingBufferPersist
+	} else {
+		conf.RingBufferPersist = *cf.RingBufferPersist
+	}
+	if cf.RingBufferPersistPath == nil {
+		conf.RingBufferPersistPath = cd.RingBufferPersistPath
+	} else {
+		conf.RingBufferPersistPath = *cf.RingBufferPersistPath
+	}
+	if cf.RingBufferMaxPersisted == nil {
+		conf.RingBufferMaxPersisted = cd.RingBufferMaxPersisted
+	} else {
+		conf.RingBufferMaxPersisted = *cf.RingBufferMaxPersisted
+	}
 	if cf.ConfigFolder == nil {
 		conf.ConfigFolder = cd.ConfigFolder
 	} else {
@@ -294,6 +721,26 @@ func checkConfigValues(cf ConfigurationFromFile) Configuration {
 	} else {
 		conf.HTTPListener = *cf.HTTPListener
 	}
+	if cf.HTTPListenerCertFile == nil {
+		conf.HTTPListenerCertFile = cd.HTTPListenerCertFile
+	} else {
+		conf.HTTPListenerCertFile = *cf.HTTPListenerCertFile
+	}
+	if cf.HTTPListenerKeyFile == nil {
+		conf.HTTPListenerKeyFile = cd.HTTPListenerKeyFile
+	} else {
+		conf.HTTPListenerKeyFile = *cf.HTTPListenerKeyFile
+	}
+	if cf.HTTPListenerClientCAFile == nil {
+		conf.HTTPListenerClientCAFile = cd.HTTPListenerClientCAFile
+	} else {
+		conf.HTTPListenerClientCAFile = *cf.HTTPListenerClientCAFile
+	}
+	if cf.HTTPListenerTokenFile == nil {
+		conf.HTTPListenerTokenFile = cd.HTTPListenerTokenFile
+	} else {
+		conf.HTTPListenerTokenFile = *cf.HTTPListenerTokenFile
+	}
 	if cf.DatabaseFolder == nil {
 		conf.DatabaseFolder = cd.DatabaseFolder
 	} else {
@@ -329,6 +776,11 @@ func checkConfigValues(cf ConfigurationFromFile) Configuration {
 	} else {
 		conf.NatsReconnectJitterTLS = *cf.NatsReconnectJitterTLS
 	}
+	if cf.NatsConnPoolSize == nil {
+		conf.NatsConnPoolSize = cd.NatsConnPoolSize
+	} else {
+		conf.NatsConnPoolSize = *cf.NatsConnPoolSize
+	}
 	if cf.REQKeysRequestUpdateInterval == nil {
 		conf.REQKeysRequestUpdateInterval = cd.REQKeysRequestUpdateInterval
 	} else {
@@ -349,6 +801,11 @@ func checkConfigValues(cf ConfigurationFromFile) Configuration {
 	} else {
 		conf.PromHostAndPort = *cf.PromHostAndPort
 	}
+	if cf.PromHandlerDurationBuckets == nil {
+		conf.PromHandlerDurationBuckets = cd.PromHandlerDurationBuckets
+	} else {
+		conf.PromHandlerDurationBuckets = *cf.PromHandlerDurationBuckets
+	}
 	if cf.DefaultMessageTimeout == nil {
 		conf.DefaultMessageTimeout = cd.DefaultMessageTimeout
 	} else {
@@ -369,6 +826,71 @@ func checkConfigValues(cf ConfigurationFromFile) Configuration {
 	} else {
 		conf.SubscribersDataFolder = *cf.SubscribersDataFolder
 	}
+	if cf.ReplyFolderTemplate == nil {
+		conf.ReplyFolderTemplate = cd.ReplyFolderTemplate
+	} else {
+		conf.ReplyFolderTemplate = *cf.ReplyFolderTemplate
+	}
+	if cf.AllowedFileRoots == nil {
+		conf.AllowedFileRoots = cd.AllowedFileRoots
+	} else {
+		conf.AllowedFileRoots = *cf.AllowedFileRoots
+	}
+	if cf.DefaultFileMode == nil {
+		conf.DefaultFileMode = cd.DefaultFileMode
+	} else {
+		conf.DefaultFileMode = *cf.DefaultFileMode
+	}
+	if cf.DefaultDirMode == nil {
+		conf.DefaultDirMode = cd.DefaultDirMode
+	} else {
+		conf.DefaultDirMode = *cf.DefaultDirMode
+	}
+	if cf.ToFileAppendRotateSize == nil {
+		conf.ToFileAppendRotateSize = cd.ToFileAppendRotateSize
+	} else {
+		conf.ToFileAppendRotateSize = *cf.ToFileAppendRotateSize
+	}
+	if cf.ToFileAppendRotateKeep == nil {
+		conf.ToFileAppendRotateKeep = cd.ToFileAppendRotateKeep
+	} else {
+		conf.ToFileAppendRotateKeep = *cf.ToFileAppendRotateKeep
+	}
+	if cf.MaxConcurrent == nil {
+		conf.MaxConcurrent = cd.MaxConcurrent
+	} else {
+		conf.MaxConcurrent = *cf.MaxConcurrent
+	}
+	if cf.DedupWindow == nil {
+		conf.DedupWindow = cd.DedupWindow
+	} else {
+		conf.DedupWindow = *cf.DedupWindow
+	}
+	if cf.DedupMethods == nil {
+		conf.DedupMethods = cd.DedupMethods
+	} else {
+		conf.DedupMethods = *cf.DedupMethods
+	}
+	if cf.InboundRateLimit == nil {
+		conf.InboundRateLimit = cd.InboundRateLimit
+	} else {
+		conf.InboundRateLimit = *cf.InboundRateLimit
+	}
+	if cf.InboundRateBurst == nil {
+		conf.InboundRateBurst = cd.InboundRateBurst
+	} else {
+		conf.InboundRateBurst = *cf.InboundRateBurst
+	}
+	if cf.InboundRateLimitNodes == nil {
+		conf.InboundRateLimitNodes = cd.InboundRateLimitNodes
+	} else {
+		conf.InboundRateLimitNodes = *cf.InboundRateLimitNodes
+	}
+	if cf.CliCommandContMaxOutputBytes == nil {
+		conf.CliCommandContMaxOutputBytes = cd.CliCommandContMaxOutputBytes
+	} else {
+		conf.CliCommandContMaxOutputBytes = *cf.CliCommandContMaxOutputBytes
+	}
 	if cf.CentralNodeName == nil {
 		conf.CentralNodeName = cd.CentralNodeName
 	} else {
@@ -399,6 +921,11 @@ func checkConfigValues(cf ConfigurationFromFile) Configuration {
 	} else {
 		conf.ErrorMessageRetries = *cf.ErrorMessageRetries
 	}
+	if cf.ErrorWebhookURL == nil {
+		conf.ErrorWebhookURL = cd.ErrorWebhookURL
+	} else {
+		conf.ErrorWebhookURL = *cf.ErrorWebhookURL
+	}
 	if cf.Compression == nil {
 		conf.Compression = cd.Compression
 	} else {
@@ -434,16 +961,66 @@ func checkConfigValues(cf ConfigurationFromFile) Configuration {
 	} else {
 		conf.EnableAclCheck = *cf.EnableAclCheck
 	}
+	if cf.SignatureCheckMethods == nil {
+		conf.SignatureCheckMethods = cd.SignatureCheckMethods
+	} else {
+		conf.SignatureCheckMethods = *cf.SignatureCheckMethods
+	}
+	if cf.SignatureMaxSkew == nil {
+		conf.SignatureMaxSkew = cd.SignatureMaxSkew
+	} else {
+		conf.SignatureMaxSkew = *cf.SignatureMaxSkew
+	}
+	if cf.SignKeysRotateGrace == nil {
+		conf.SignKeysRotateGrace = cd.SignKeysRotateGrace
+	} else {
+		conf.SignKeysRotateGrace = *cf.SignKeysRotateGrace
+	}
+	if cf.ValidateTrustStoreOnStartup == nil {
+		conf.ValidateTrustStoreOnStartup = cd.ValidateTrustStoreOnStartup
+	} else {
+		conf.ValidateTrustStoreOnStartup = *cf.ValidateTrustStoreOnStartup
+	}
+	if cf.AbortOnTrustStoreError == nil {
+		conf.AbortOnTrustStoreError = cd.AbortOnTrustStoreError
+	} else {
+		conf.AbortOnTrustStoreError = *cf.AbortOnTrustStoreError
+	}
 	if cf.IsCentralAuth == nil {
 		conf.IsCentralAuth = cd.IsCentralAuth
 	} else {
 		conf.IsCentralAuth = *cf.IsCentralAuth
 	}
+	if cf.IsStandbyCentral == nil {
+		conf.IsStandbyCentral = cd.IsStandbyCentral
+	} else {
+		conf.IsStandbyCentral = *cf.IsStandbyCentral
+	}
+	if cf.StandbyCentralNode == nil {
+		conf.StandbyCentralNode = cd.StandbyCentralNode
+	} else {
+		conf.StandbyCentralNode = *cf.StandbyCentralNode
+	}
+	if cf.ReplicateToNodes == nil {
+		conf.ReplicateToNodes = cd.ReplicateToNodes
+	} else {
+		conf.ReplicateToNodes = *cf.ReplicateToNodes
+	}
+	if cf.REQCentralReplicateInterval == nil {
+		conf.REQCentralReplicateInterval = cd.REQCentralReplicateInterval
+	} else {
+		conf.REQCentralReplicateInterval = *cf.REQCentralReplicateInterval
+	}
 	if cf.EnableDebug == nil {
 		conf.EnableDebug = cd.EnableDebug
 	} else {
 		conf.EnableDebug = *cf.EnableDebug
 	}
+	if cf.LogJSON == nil {
+		conf.LogJSON = cd.LogJSON
+	} else {
+		conf.LogJSON = *cf.LogJSON
+	}
 
 	// --- Start pub/sub
 
@@ -469,11 +1046,21 @@ func checkConfigValues(cf ConfigurationFromFile) Configuration {
 	} else {
 		conf.IsCentralErrorLogger = *cf.IsCentralErrorLogger
 	}
+	if cf.ErrorAggregateWindow == nil {
+		conf.ErrorAggregateWindow = cd.ErrorAggregateWindow
+	} else {
+		conf.ErrorAggregateWindow = *cf.ErrorAggregateWindow
+	}
 	if cf.StartSubREQHello == nil {
 		conf.StartSubREQHello = cd.StartSubREQHello
 	} else {
 		conf.StartSubREQHello = *cf.StartSubREQHello
 	}
+	if cf.HelloStaleMultiplier == nil {
+		conf.HelloStaleMultiplier = cd.HelloStaleMultiplier
+	} else {
+		conf.HelloStaleMultiplier = *cf.HelloStaleMultiplier
+	}
 	if cf.StartSubREQToFileAppend == nil {
 		conf.StartSubREQToFileAppend = cd.StartSubREQToFileAppend
 	} else {
@@ -489,6 +1076,11 @@ func checkConfigValues(cf ConfigurationFromFile) Configuration {
 	} else {
 		conf.StartSubREQToFileNACK = *cf.StartSubREQToFileNACK
 	}
+	if cf.StartSubREQWriteFileIfChanged == nil {
+		conf.StartSubREQWriteFileIfChanged = cd.StartSubREQWriteFileIfChanged
+	} else {
+		conf.StartSubREQWriteFileIfChanged = *cf.StartSubREQWriteFileIfChanged
+	}
 	if cf.StartSubREQCopyFileFrom == nil {
 		conf.StartSubREQCopyFileFrom = cd.StartSubREQCopyFileFrom
 	} else {
@@ -499,6 +1091,16 @@ func checkConfigValues(cf ConfigurationFromFile) Configuration {
 	} else {
 		conf.StartSubREQCopyFileTo = *cf.StartSubREQCopyFileTo
 	}
+	if cf.StartSubREQCopyDirFrom == nil {
+		conf.StartSubREQCopyDirFrom = cd.StartSubREQCopyDirFrom
+	} else {
+		conf.StartSubREQCopyDirFrom = *cf.StartSubREQCopyDirFrom
+	}
+	if cf.StartSubREQCopyDirTo == nil {
+		conf.StartSubREQCopyDirTo = cd.StartSubREQCopyDirTo
+	} else {
+		conf.StartSubREQCopyDirTo = *cf.StartSubREQCopyDirTo
+	}
 	if cf.StartSubREQPing == nil {
 		conf.StartSubREQPing = cd.StartSubREQPing
 	} else {
@@ -529,21 +1131,251 @@ func checkConfigValues(cf ConfigurationFromFile) Configuration {
 	} else {
 		conf.StartSubREQHttpGetScheduled = *cf.StartSubREQHttpGetScheduled
 	}
+	if cf.StartSubREQHttpPost == nil {
+		conf.StartSubREQHttpPost = cd.StartSubREQHttpPost
+	} else {
+		conf.StartSubREQHttpPost = *cf.StartSubREQHttpPost
+	}
+	if cf.HttpPostFollowRedirects == nil {
+		conf.HttpPostFollowRedirects = cd.HttpPostFollowRedirects
+	} else {
+		conf.HttpPostFollowRedirects = *cf.HttpPostFollowRedirects
+	}
 	if cf.StartSubREQTailFile == nil {
 		conf.StartSubREQTailFile = cd.StartSubREQTailFile
 	} else {
 		conf.StartSubREQTailFile = *cf.StartSubREQTailFile
 	}
+	if cf.StartSubREQScheduled == nil {
+		conf.StartSubREQScheduled = cd.StartSubREQScheduled
+	} else {
+		conf.StartSubREQScheduled = *cf.StartSubREQScheduled
+	}
 	if cf.StartSubREQCliCommandCont == nil {
 		conf.StartSubREQCliCommandCont = cd.StartSubREQCliCommandCont
 	} else {
 		conf.StartSubREQCliCommandCont = *cf.StartSubREQCliCommandCont
 	}
+	if cf.StartSubREQStreamCommand == nil {
+		conf.StartSubREQStreamCommand = cd.StartSubREQStreamCommand
+	} else {
+		conf.StartSubREQStreamCommand = *cf.StartSubREQStreamCommand
+	}
+	if cf.StartSubREQListActiveSessions == nil {
+		conf.StartSubREQListActiveSessions = cd.StartSubREQListActiveSessions
+	} else {
+		conf.StartSubREQListActiveSessions = *cf.StartSubREQListActiveSessions
+	}
 	if cf.StartSubREQRelay == nil {
 		conf.StartSubREQRelay = cd.StartSubREQRelay
 	} else {
 		conf.StartSubREQRelay = *cf.StartSubREQRelay
 	}
+	if cf.RelayMaxHops == nil {
+		conf.RelayMaxHops = cd.RelayMaxHops
+	} else {
+		conf.RelayMaxHops = *cf.RelayMaxHops
+	}
+	if cf.StartSubREQSetMessageDefaults == nil {
+		conf.StartSubREQSetMessageDefaults = cd.StartSubREQSetMessageDefaults
+	} else {
+		conf.StartSubREQSetMessageDefaults = *cf.StartSubREQSetMessageDefaults
+	}
+	if cf.StartSubREQSetPriorityPolicy == nil {
+		conf.StartSubREQSetPriorityPolicy = cd.StartSubREQSetPriorityPolicy
+	} else {
+		conf.StartSubREQSetPriorityPolicy = *cf.StartSubREQSetPriorityPolicy
+	}
+	if cf.StartSubREQReindexDataFolder == nil {
+		conf.StartSubREQReindexDataFolder = cd.StartSubREQReindexDataFolder
+	} else {
+		conf.StartSubREQReindexDataFolder = *cf.StartSubREQReindexDataFolder
+	}
+	if cf.StartSubREQSearchDataFolder == nil {
+		conf.StartSubREQSearchDataFolder = cd.StartSubREQSearchDataFolder
+	} else {
+		conf.StartSubREQSearchDataFolder = *cf.StartSubREQSearchDataFolder
+	}
+	if cf.StartSubREQDegradedMode == nil {
+		conf.StartSubREQDegradedMode = cd.StartSubREQDegradedMode
+	} else {
+		conf.StartSubREQDegradedMode = *cf.StartSubREQDegradedMode
+	}
+	if cf.StartSubREQVerifyDataIntegrity == nil {
+		conf.StartSubREQVerifyDataIntegrity = cd.StartSubREQVerifyDataIntegrity
+	} else {
+		conf.StartSubREQVerifyDataIntegrity = *cf.StartSubREQVerifyDataIntegrity
+	}
+	if cf.StartSubREQCompressStoredReplies == nil {
+		conf.StartSubREQCompressStoredReplies = cd.StartSubREQCompressStoredReplies
+	} else {
+		conf.StartSubREQCompressStoredReplies = *cf.StartSubREQCompressStoredReplies
+	}
+	if cf.StartSubREQSubscribeMetrics == nil {
+		conf.StartSubREQSubscribeMetrics = cd.StartSubREQSubscribeMetrics
+	} else {
+		conf.StartSubREQSubscribeMetrics = *cf.StartSubREQSubscribeMetrics
+	}
+	if cf.StartSubREQPartialUpdateFile == nil {
+		conf.StartSubREQPartialUpdateFile = cd.StartSubREQPartialUpdateFile
+	} else {
+		conf.StartSubREQPartialUpdateFile = *cf.StartSubREQPartialUpdateFile
+	}
+	if cf.StartSubREQConnectionAudit == nil {
+		conf.StartSubREQConnectionAudit = cd.StartSubREQConnectionAudit
+	} else {
+		conf.StartSubREQConnectionAudit = *cf.StartSubREQConnectionAudit
+	}
+	if cf.StartSubREQListErrorSinks == nil {
+		conf.StartSubREQListErrorSinks = cd.StartSubREQListErrorSinks
+	} else {
+		conf.StartSubREQListErrorSinks = *cf.StartSubREQListErrorSinks
+	}
+	if cf.StartSubREQManageErrorSink == nil {
+		conf.StartSubREQManageErrorSink = cd.StartSubREQManageErrorSink
+	} else {
+		conf.StartSubREQManageErrorSink = *cf.StartSubREQManageErrorSink
+	}
+	if cf.StartSubREQMeasureThroughput == nil {
+		conf.StartSubREQMeasureThroughput = cd.StartSubREQMeasureThroughput
+	} else {
+		conf.StartSubREQMeasureThroughput = *cf.StartSubREQMeasureThroughput
+	}
+	if cf.StartSubREQThroughputDiscard == nil {
+		conf.StartSubREQThroughputDiscard = cd.StartSubREQThroughputDiscard
+	} else {
+		conf.StartSubREQThroughputDiscard = *cf.StartSubREQThroughputDiscard
+	}
+	if cf.StartSubREQQuery == nil {
+		conf.StartSubREQQuery = cd.StartSubREQQuery
+	} else {
+		conf.StartSubREQQuery = *cf.StartSubREQQuery
+	}
+	if cf.StartSubREQBulkFileFetch == nil {
+		conf.StartSubREQBulkFileFetch = cd.StartSubREQBulkFileFetch
+	} else {
+		conf.StartSubREQBulkFileFetch = *cf.StartSubREQBulkFileFetch
+	}
+	if cf.StartSubREQFileTemplateRenderPreview == nil {
+		conf.StartSubREQFileTemplateRenderPreview = cd.StartSubREQFileTemplateRenderPreview
+	} else {
+		conf.StartSubREQFileTemplateRenderPreview = *cf.StartSubREQFileTemplateRenderPreview
+	}
+	if cf.StartSubREQAttachMetadata == nil {
+		conf.StartSubREQAttachMetadata = cd.StartSubREQAttachMetadata
+	} else {
+		conf.StartSubREQAttachMetadata = *cf.StartSubREQAttachMetadata
+	}
+	if cf.StartSubREQReconcileState == nil {
+		conf.StartSubREQReconcileState = cd.StartSubREQReconcileState
+	} else {
+		conf.StartSubREQReconcileState = *cf.StartSubREQReconcileState
+	}
+	if cf.StartSubREQShutdownScheduled == nil {
+		conf.StartSubREQShutdownScheduled = cd.StartSubREQShutdownScheduled
+	} else {
+		conf.StartSubREQShutdownScheduled = *cf.StartSubREQShutdownScheduled
+	}
+	if cf.StartSubREQValidateTrustStore == nil {
+		conf.StartSubREQValidateTrustStore = cd.StartSubREQValidateTrustStore
+	} else {
+		conf.StartSubREQValidateTrustStore = *cf.StartSubREQValidateTrustStore
+	}
+	if cf.StartSubREQInspectProcessGoroutines == nil {
+		conf.StartSubREQInspectProcessGoroutines = cd.StartSubREQInspectProcessGoroutines
+	} else {
+		conf.StartSubREQInspectProcessGoroutines = *cf.StartSubREQInspectProcessGoroutines
+	}
+	if cf.StartSubREQListFailedMessages == nil {
+		conf.StartSubREQListFailedMessages = cd.StartSubREQListFailedMessages
+	} else {
+		conf.StartSubREQListFailedMessages = *cf.StartSubREQListFailedMessages
+	}
+	if cf.StartSubREQInspectRetryState == nil {
+		conf.StartSubREQInspectRetryState = cd.StartSubREQInspectRetryState
+	} else {
+		conf.StartSubREQInspectRetryState = *cf.StartSubREQInspectRetryState
+	}
+	if cf.StartSubREQAdoptNodeName == nil {
+		conf.StartSubREQAdoptNodeName = cd.StartSubREQAdoptNodeName
+	} else {
+		conf.StartSubREQAdoptNodeName = *cf.StartSubREQAdoptNodeName
+	}
+	if cf.StartSubREQKeysRotate == nil {
+		conf.StartSubREQKeysRotate = cd.StartSubREQKeysRotate
+	} else {
+		conf.StartSubREQKeysRotate = *cf.StartSubREQKeysRotate
+	}
+	if cf.StartSubREQInspectTimeouts == nil {
+		conf.StartSubREQInspectTimeouts = cd.StartSubREQInspectTimeouts
+	} else {
+		conf.StartSubREQInspectTimeouts = *cf.StartSubREQInspectTimeouts
+	}
+	if cf.StartSubREQRunWithLock == nil {
+		conf.StartSubREQRunWithLock = cd.StartSubREQRunWithLock
+	} else {
+		conf.StartSubREQRunWithLock = *cf.StartSubREQRunWithLock
+	}
+	if cf.StartSubREQProbeMethod == nil {
+		conf.StartSubREQProbeMethod = cd.StartSubREQProbeMethod
+	} else {
+		conf.StartSubREQProbeMethod = *cf.StartSubREQProbeMethod
+	}
+	if cf.StartSubREQCentralChanged == nil {
+		conf.StartSubREQCentralChanged = cd.StartSubREQCentralChanged
+	} else {
+		conf.StartSubREQCentralChanged = *cf.StartSubREQCentralChanged
+	}
+	if cf.StartSubREQInspectSignature == nil {
+		conf.StartSubREQInspectSignature = cd.StartSubREQInspectSignature
+	} else {
+		conf.StartSubREQInspectSignature = *cf.StartSubREQInspectSignature
+	}
+	if cf.StartSubREQInspectAllowedSignatures == nil {
+		conf.StartSubREQInspectAllowedSignatures = cd.StartSubREQInspectAllowedSignatures
+	} else {
+		conf.StartSubREQInspectAllowedSignatures = *cf.StartSubREQInspectAllowedSignatures
+	}
+	if cf.StartSubREQEnvInfo == nil {
+		conf.StartSubREQEnvInfo = cd.StartSubREQEnvInfo
+	} else {
+		conf.StartSubREQEnvInfo = *cf.StartSubREQEnvInfo
+	}
+	if cf.StartSubREQPublicKeysList == nil {
+		conf.StartSubREQPublicKeysList = cd.StartSubREQPublicKeysList
+	} else {
+		conf.StartSubREQPublicKeysList = *cf.StartSubREQPublicKeysList
+	}
+	if cf.StartSubREQReachabilityProbe == nil {
+		conf.StartSubREQReachabilityProbe = cd.StartSubREQReachabilityProbe
+	} else {
+		conf.StartSubREQReachabilityProbe = *cf.StartSubREQReachabilityProbe
+	}
+	if cf.StartSubREQResourceLimitExec == nil {
+		conf.StartSubREQResourceLimitExec = cd.StartSubREQResourceLimitExec
+	} else {
+		conf.StartSubREQResourceLimitExec = *cf.StartSubREQResourceLimitExec
+	}
+	if cf.StartSubREQSyncTime == nil {
+		conf.StartSubREQSyncTime = cd.StartSubREQSyncTime
+	} else {
+		conf.StartSubREQSyncTime = *cf.StartSubREQSyncTime
+	}
+	if cf.TimeSyncMaxJump == nil {
+		conf.TimeSyncMaxJump = cd.TimeSyncMaxJump
+	} else {
+		conf.TimeSyncMaxJump = *cf.TimeSyncMaxJump
+	}
+	if cf.StartSubREQTimeNow == nil {
+		conf.StartSubREQTimeNow = cd.StartSubREQTimeNow
+	} else {
+		conf.StartSubREQTimeNow = *cf.StartSubREQTimeNow
+	}
+	if cf.StartSubREQNodeInfo == nil {
+		conf.StartSubREQNodeInfo = cd.StartSubREQNodeInfo
+	} else {
+		conf.StartSubREQNodeInfo = *cf.StartSubREQNodeInfo
+	}
 
 	return conf
 }
@@ -578,8 +1410,16 @@ func (c *Configuration) CheckFlags() error {
 
 	//flag.StringVar(&c.ConfigFolder, "configFolder", fc.ConfigFolder, "Defaults to ./usr/local/steward/etc/. *NB* This flag is not used, if your config file are located somwhere else than default set the location in an env variable named CONFIGFOLDER")
 	flag.IntVar(&c.RingBufferSize, "ringBufferSize", fc.RingBufferSize, "size of the ringbuffer")
+	flag.IntVar(&c.PriorityAgingInterval, "priorityAgingInterval", fc.PriorityAgingInterval, "the number of seconds a message waiting in the ringbuffer is aged up one priority tier after, so messages with a lower priority are not starved. 0 disables the aging")
+	flag.BoolVar(&c.RingBufferPersist, "ringBufferPersist", fc.RingBufferPersist, "true/false, store the messages in the ringbuffer on disk until delivered, so they are resent after a restart")
+	flag.StringVar(&c.RingBufferPersistPath, "ringBufferPersistPath", fc.RingBufferPersistPath, "the path of the database file for the messages stored by the ringbuffer. If empty the file is created in the databaseFolder")
+	flag.IntVar(&c.RingBufferMaxPersisted, "ringBufferMaxPersisted", fc.RingBufferMaxPersisted, "the max number of messages stored on disk by the ringbuffer waiting to be delivered. When reached no new messages are accepted until some are delivered. 0 is unlimited")
 	flag.StringVar(&c.SocketFolder, "socketFolder", fc.SocketFolder, "folder who contains the socket file. Defaults to ./tmp/. If other folder is used this flag must be specified at startup.")
 	flag.StringVar(&c.TCPListener, "tcpListener", fc.TCPListener, "start up a TCP listener in addition to the Unix Socket, to give messages to the system. e.g. localhost:8888. No value means not to start the listener, which is default. NB: You probably don't want to start this on any other interface than localhost")
+	flag.StringVar(&c.HTTPListenerCertFile, "httpListenerCertFile", fc.HTTPListenerCertFile, "the path of the certificate to serve the HTTP listener with TLS. Must be given together with httpListenerKeyFile")
+	flag.StringVar(&c.HTTPListenerKeyFile, "httpListenerKeyFile", fc.HTTPListenerKeyFile, "the path of the key to serve the HTTP listener with TLS. Must be given together with httpListenerCertFile")
+	flag.StringVar(&c.HTTPListenerClientCAFile, "httpListenerClientCAFile", fc.HTTPListenerClientCAFile, "the path of the CA certificates used to verify client certificates on the HTTP listener. Requires TLS")
+	flag.StringVar(&c.HTTPListenerTokenFile, "httpListenerTokenFile", fc.HTTPListenerTokenFile, "the path of the file holding the bearer token the requests to the HTTP listener must carry")
 	flag.StringVar(&c.HTTPListener, "httpListener", fc.HTTPListener, "start up a HTTP listener in addition to the Unix Socket, to give messages to the system. e.g. localhost:8888. No value means not to start the listener, which is default. NB: You probably don't want to start this on any other interface than localhost")
 	flag.StringVar(&c.DatabaseFolder, "databaseFolder", fc.DatabaseFolder, "folder who contains the database file. Defaults to ./var/lib/. If other folder is used this flag must be specified at startup.")
 	flag.StringVar(&c.NodeName, "nodeName", fc.NodeName, "some unique string to identify this Edge unit")
@@ -588,20 +1428,36 @@ func (c *Configuration) CheckFlags() error {
 	flag.IntVar(&c.NatsConnectRetryInterval, "natsConnectRetryInterval", fc.NatsConnectRetryInterval, "default nats retry connect interval in seconds.")
 	flag.IntVar(&c.NatsReconnectJitter, "natsReconnectJitter", fc.NatsReconnectJitter, "default nats ReconnectJitter interval in milliseconds.")
 	flag.IntVar(&c.NatsReconnectJitterTLS, "natsReconnectJitterTLS", fc.NatsReconnectJitterTLS, "default nats ReconnectJitterTLS interval in seconds.")
+	flag.IntVar(&c.NatsConnPoolSize, "natsConnPoolSize", fc.NatsConnPoolSize, "the number of connections to the nats server, where the publishers and subscribers are spread round robin over the connections. Defaults to 1")
 	flag.IntVar(&c.REQKeysRequestUpdateInterval, "REQKeysRequestUpdateInterval", fc.REQKeysRequestUpdateInterval, "default interval in seconds for asking the central for public keys")
 	flag.IntVar(&c.REQAclRequestUpdateInterval, "REQAclRequestUpdateInterval", fc.REQAclRequestUpdateInterval, "default interval in seconds for asking the central for acl updates")
 	flag.StringVar(&c.ProfilingPort, "profilingPort", fc.ProfilingPort, "The number of the profiling port")
 	flag.StringVar(&c.PromHostAndPort, "promHostAndPort", fc.PromHostAndPort, "host and port for prometheus listener, e.g. localhost:2112")
+	flag.StringVar(&c.PromHandlerDurationBuckets, "promHandlerDurationBuckets", fc.PromHandlerDurationBuckets, "the comma separated upper bounds in seconds of the buckets of the histogram of the method handler execution time")
 	flag.IntVar(&c.DefaultMessageTimeout, "defaultMessageTimeout", fc.DefaultMessageTimeout, "default message timeout in seconds. This can be overridden on the message level")
 	flag.IntVar(&c.DefaultMessageRetries, "defaultMessageRetries", fc.DefaultMessageRetries, "default amount of retries that will be done before a message is thrown away, and out of the system")
 	flag.IntVar(&c.DefaultMethodTimeout, "defaultMethodTimeout", fc.DefaultMethodTimeout, "default amount of seconds a request method max will be allowed to run")
 	flag.StringVar(&c.SubscribersDataFolder, "subscribersDataFolder", fc.SubscribersDataFolder, "The data folder where subscribers are allowed to write their data if needed")
+	flag.StringVar(&c.ReplyFolderTemplate, "replyFolderTemplate", fc.ReplyFolderTemplate, "a text/template for the folder of the reply files within the subscribersDataFolder, like {{.Directory}}/{{.Date}}/{{.Node}}. The variables are Node, Method, Date, MessageID and Directory. If not set the folder is the directory of the message followed by the node")
+	flag.StringVar(&c.AllowedFileRoots, "allowedFileRoots", fc.AllowedFileRoots, "comma separated list of the folders where methods editing files, like REQPartialUpdateFile, are allowed to operate. If empty no files are allowed to be edited")
+	flag.StringVar(&c.DefaultFileMode, "defaultFileMode", fc.DefaultFileMode, "the mode in octal, like 0644, for the files written by the file handlers. The handlers own mode is used if empty")
+	flag.StringVar(&c.DefaultDirMode, "defaultDirMode", fc.DefaultDirMode, "the mode in octal, like 0755, for the directories created by the file handlers. 0700 is used if empty")
+	flag.IntVar(&c.ToFileAppendRotateSize, "toFileAppendRotateSize", fc.ToFileAppendRotateSize, "the size in bytes a file appended to with REQToFileAppend is rotated at, where the file is renamed to file.1, file.1 to file.2 and so on. 0 disables the rotation")
+	flag.IntVar(&c.ToFileAppendRotateKeep, "toFileAppendRotateKeep", fc.ToFileAppendRotateKeep, "the number of rotated copies of a file kept when rotated by REQToFileAppend")
+	flag.StringVar(&c.MaxConcurrent, "maxConcurrent", fc.MaxConcurrent, "comma separated list of method=number, like REQCliCommand=4, limiting the number of messages handled at the same time for the method. Messages above the limit are queued. Methods not listed are unlimited, which is default")
+	flag.IntVar(&c.DedupWindow, "dedupWindow", fc.DedupWindow, "the number of seconds an ACK message received is remembered, so a message resent by the publisher is not handled again, and the ACK is resent instead. 0 disables the deduplication")
+	flag.StringVar(&c.DedupMethods, "dedupMethods", fc.DedupMethods, "comma separated list of the methods to deduplicate. If empty all the methods that are not read-only are deduplicated, which is default")
+	flag.IntVar(&c.InboundRateLimit, "inboundRateLimit", fc.InboundRateLimit, "the number of messages per second handled from each node, where the messages above the limit are dropped. 0 disables the rate limiting, which is default")
+	flag.IntVar(&c.InboundRateBurst, "inboundRateBurst", fc.InboundRateBurst, "the number of messages a node can send above the inboundRateLimit in a burst")
+	flag.StringVar(&c.InboundRateLimitNodes, "inboundRateLimitNodes", fc.InboundRateLimitNodes, "comma separated list of node=rate or node=rate:burst, like ship1=50:100, overriding the inboundRateLimit and inboundRateBurst for the nodes listed. A rate of 0 is unlimited")
+	flag.IntVar(&c.CliCommandContMaxOutputBytes, "cliCommandContMaxOutputBytes", fc.CliCommandContMaxOutputBytes, "the default max number of bytes of output delivered by REQCliCommandCont before the command is killed, used when maxOutputBytes is not set in the message. 0 is unlimited")
 	flag.StringVar(&c.CentralNodeName, "centralNodeName", fc.CentralNodeName, "The name of the central node to receive messages published by this node")
 	flag.StringVar(&c.RootCAPath, "rootCAPath", fc.RootCAPath, "If TLS, enter the path for where to find the root CA certificate")
 	flag.StringVar(&c.NkeySeedFile, "nkeySeedFile", fc.NkeySeedFile, "The full path of the nkeys seed file")
 	flag.StringVar(&c.ExposeDataFolder, "exposeDataFolder", fc.ExposeDataFolder, "If set the data folder will be exposed on the given host:port. Default value is not exposed at all")
 	flag.IntVar(&c.ErrorMessageTimeout, "errorMessageTimeout", fc.ErrorMessageTimeout, "The number of seconds to wait for an error message to time out")
 	flag.IntVar(&c.ErrorMessageRetries, "errorMessageRetries", fc.ErrorMessageRetries, "The number of if times to retry an error message before we drop it")
+	flag.StringVar(&c.ErrorWebhookURL, "errorWebhookURL", fc.ErrorWebhookURL, "URL of a http webhook where errors are also posted to. Leave empty to disable the webhook error sink")
 	flag.StringVar(&c.Compression, "compression", fc.Compression, "compression method to use. defaults to no compression, z = zstd, g = gzip. Undefined value will default to no compression")
 	flag.StringVar(&c.Serialization, "serialization", fc.Serialization, "Serialization method to use. defaults to gob, other values are = cbor. Undefined value will default to gob")
 	flag.IntVar(&c.SetBlockProfileRate, "setBlockProfileRate", fc.SetBlockProfileRate, "Enable block profiling by setting the value to f.ex. 1. 0 = disabled")
@@ -609,8 +1465,18 @@ func (c *Configuration) CheckFlags() error {
 	flag.BoolVar(&c.EnableTUI, "enableTUI", fc.EnableTUI, "true/false for enabling the Terminal User Interface")
 	flag.BoolVar(&c.EnableSignatureCheck, "enableSignatureCheck", fc.EnableSignatureCheck, "true/false *TESTING* enable signature checking.")
 	flag.BoolVar(&c.EnableAclCheck, "enableAclCheck", fc.EnableAclCheck, "true/false *TESTING* enable Acl checking.")
+	flag.StringVar(&c.SignatureCheckMethods, "signatureCheckMethods", fc.SignatureCheckMethods, "comma separated list of the methods that require a valid signature when signature checking is enabled. If empty all the methods changing state, except the ones needed before the keys are known, and the methods reading files or the network, require a signature, which is default")
+	flag.IntVar(&c.SignatureMaxSkew, "signatureMaxSkew", fc.SignatureMaxSkew, "the max number of seconds the time a message was signed can differ from the time of the receiving node. Signed messages outside the skew, or with a nonce already seen, are rejected as replayed")
+	flag.IntVar(&c.SignKeysRotateGrace, "signKeysRotateGrace", fc.SignKeysRotateGrace, "the number of seconds the old public signing key of a node is still accepted after the keys were rotated with REQKeysRotate")
+	flag.BoolVar(&c.ValidateTrustStoreOnStartup, "validateTrustStoreOnStartup", fc.ValidateTrustStoreOnStartup, "set to true to validate the stored public keys and signing keys at startup")
+	flag.BoolVar(&c.AbortOnTrustStoreError, "abortOnTrustStoreError", fc.AbortOnTrustStoreError, "set to true to abort the startup if the trust store validation at startup finds problems")
 	flag.BoolVar(&c.IsCentralAuth, "isCentralAuth", fc.IsCentralAuth, "true/false, *TESTING* is this the central auth server")
+	flag.BoolVar(&c.IsStandbyCentral, "isStandbyCentral", fc.IsStandbyCentral, "true/false, is this a standby node that can be promoted to central with REQFailover")
+	flag.StringVar(&c.StandbyCentralNode, "standbyCentralNode", fc.StandbyCentralNode, "the name of the standby node that the central auth state should be replicated to. No value means no replication, which is default")
+	flag.StringVar(&c.ReplicateToNodes, "replicateToNodes", fc.ReplicateToNodes, "comma separated list of standby nodes that the central auth state are replicated to each time it changes. No value means no continuous replication, which is default")
+	flag.IntVar(&c.REQCentralReplicateInterval, "REQCentralReplicateInterval", fc.REQCentralReplicateInterval, "default interval in seconds for replicating the central auth state to the standby node")
 	flag.BoolVar(&c.EnableDebug, "enableDebug", fc.EnableDebug, "true/false, will enable debug logging so all messages sent to the errorKernel will also be printed to STDERR")
+	flag.BoolVar(&c.LogJSON, "logJSON", fc.LogJSON, "true/false, will write the log entries to STDERR as json objects with the level, node, subject, messageID and method as fields, instead of text")
 
 	// Start of Request publishers/subscribers
 
@@ -621,21 +1487,72 @@ func (c *Configuration) CheckFlags() error {
 	flag.BoolVar(&c.EnableAclUpdates, "EnableAclUpdates", fc.EnableAclUpdates, "true/false")
 
 	flag.BoolVar(&c.IsCentralErrorLogger, "isCentralErrorLogger", fc.IsCentralErrorLogger, "true/false")
+	flag.IntVar(&c.ErrorAggregateWindow, "errorAggregateWindow", fc.ErrorAggregateWindow, "the number of seconds identical errors are coalesced. The first error is sent at once, and the errors repeated within the window are sent as one error with the number of times repeated when the window closes. Errors only differing in numbers, like message ID's, are seen as identical. 0 sends every error, which is default")
 	flag.BoolVar(&c.StartSubREQHello, "startSubREQHello", fc.StartSubREQHello, "true/false")
+	flag.IntVar(&c.HelloStaleMultiplier, "helloStaleMultiplier", fc.HelloStaleMultiplier, "the number of hello intervals, as set with startPubREQHello, a node can be silent before central reports the node as stale with an error and the steward_hello_nodes_stale metric. 0 disables the check")
 	flag.BoolVar(&c.StartSubREQToFileAppend, "startSubREQToFileAppend", fc.StartSubREQToFileAppend, "true/false")
 	flag.BoolVar(&c.StartSubREQToFile, "startSubREQToFile", fc.StartSubREQToFile, "true/false")
 	flag.BoolVar(&c.StartSubREQToFileNACK, "startSubREQToFileNACK", fc.StartSubREQToFileNACK, "true/false")
+	flag.BoolVar(&c.StartSubREQWriteFileIfChanged, "startSubREQWriteFileIfChanged", fc.StartSubREQWriteFileIfChanged, "true/false")
 	flag.BoolVar(&c.StartSubREQCopyFileFrom, "startSubREQCopyFileFrom", fc.StartSubREQCopyFileFrom, "true/false")
 	flag.BoolVar(&c.StartSubREQCopyFileTo, "startSubREQCopyFileTo", fc.StartSubREQCopyFileTo, "true/false")
+	flag.BoolVar(&c.StartSubREQCopyDirFrom, "startSubREQCopyDirFrom", fc.StartSubREQCopyDirFrom, "true/false")
+	flag.BoolVar(&c.StartSubREQCopyDirTo, "startSubREQCopyDirTo", fc.StartSubREQCopyDirTo, "true/false")
 	flag.BoolVar(&c.StartSubREQPing, "startSubREQPing", fc.StartSubREQPing, "true/false")
 	flag.BoolVar(&c.StartSubREQPong, "startSubREQPong", fc.StartSubREQPong, "true/false")
 	flag.BoolVar(&c.StartSubREQCliCommand, "startSubREQCliCommand", fc.StartSubREQCliCommand, "true/false")
 	flag.BoolVar(&c.StartSubREQToConsole, "startSubREQToConsole", fc.StartSubREQToConsole, "true/false")
 	flag.BoolVar(&c.StartSubREQHttpGet, "startSubREQHttpGet", fc.StartSubREQHttpGet, "true/false")
 	flag.BoolVar(&c.StartSubREQHttpGetScheduled, "startSubREQHttpGetScheduled", fc.StartSubREQHttpGetScheduled, "true/false")
+	flag.BoolVar(&c.StartSubREQHttpPost, "startSubREQHttpPost", fc.StartSubREQHttpPost, "true/false")
+	flag.BoolVar(&c.HttpPostFollowRedirects, "httpPostFollowRedirects", fc.HttpPostFollowRedirects, "true/false, follow the redirects of the server with REQHttpPost")
 	flag.BoolVar(&c.StartSubREQTailFile, "startSubREQTailFile", fc.StartSubREQTailFile, "true/false")
+	flag.BoolVar(&c.StartSubREQScheduled, "startSubREQScheduled", fc.StartSubREQScheduled, "true/false")
 	flag.BoolVar(&c.StartSubREQCliCommandCont, "startSubREQCliCommandCont", fc.StartSubREQCliCommandCont, "true/false")
+	flag.BoolVar(&c.StartSubREQStreamCommand, "startSubREQStreamCommand", fc.StartSubREQStreamCommand, "true/false")
+	flag.BoolVar(&c.StartSubREQListActiveSessions, "startSubREQListActiveSessions", fc.StartSubREQListActiveSessions, "true/false")
 	flag.BoolVar(&c.StartSubREQRelay, "startSubREQRelay", fc.StartSubREQRelay, "true/false")
+	flag.IntVar(&c.RelayMaxHops, "relayMaxHops", fc.RelayMaxHops, "the max number of nodes a message can be relayed by before it is dropped, so a message relayed in a loop is stopped. 0 is no limit")
+	flag.BoolVar(&c.StartSubREQSetMessageDefaults, "startSubREQSetMessageDefaults", fc.StartSubREQSetMessageDefaults, "true/false")
+	flag.BoolVar(&c.StartSubREQSetPriorityPolicy, "startSubREQSetPriorityPolicy", fc.StartSubREQSetPriorityPolicy, "true/false")
+	flag.BoolVar(&c.StartSubREQReindexDataFolder, "startSubREQReindexDataFolder", fc.StartSubREQReindexDataFolder, "true/false")
+	flag.BoolVar(&c.StartSubREQSearchDataFolder, "startSubREQSearchDataFolder", fc.StartSubREQSearchDataFolder, "true/false")
+	flag.BoolVar(&c.StartSubREQDegradedMode, "startSubREQDegradedMode", fc.StartSubREQDegradedMode, "true/false")
+	flag.BoolVar(&c.StartSubREQVerifyDataIntegrity, "startSubREQVerifyDataIntegrity", fc.StartSubREQVerifyDataIntegrity, "true/false")
+	flag.BoolVar(&c.StartSubREQCompressStoredReplies, "startSubREQCompressStoredReplies", fc.StartSubREQCompressStoredReplies, "true/false")
+	flag.BoolVar(&c.StartSubREQSubscribeMetrics, "startSubREQSubscribeMetrics", fc.StartSubREQSubscribeMetrics, "true/false")
+	flag.BoolVar(&c.StartSubREQPartialUpdateFile, "startSubREQPartialUpdateFile", fc.StartSubREQPartialUpdateFile, "true/false")
+	flag.BoolVar(&c.StartSubREQConnectionAudit, "startSubREQConnectionAudit", fc.StartSubREQConnectionAudit, "true/false")
+	flag.BoolVar(&c.StartSubREQListErrorSinks, "startSubREQListErrorSinks", fc.StartSubREQListErrorSinks, "true/false")
+	flag.BoolVar(&c.StartSubREQManageErrorSink, "startSubREQManageErrorSink", fc.StartSubREQManageErrorSink, "true/false")
+	flag.BoolVar(&c.StartSubREQMeasureThroughput, "startSubREQMeasureThroughput", fc.StartSubREQMeasureThroughput, "true/false")
+	flag.BoolVar(&c.StartSubREQThroughputDiscard, "startSubREQThroughputDiscard", fc.StartSubREQThroughputDiscard, "true/false")
+	flag.BoolVar(&c.StartSubREQQuery, "startSubREQQuery", fc.StartSubREQQuery, "true/false")
+	flag.BoolVar(&c.StartSubREQBulkFileFetch, "startSubREQBulkFileFetch", fc.StartSubREQBulkFileFetch, "true/false")
+	flag.BoolVar(&c.StartSubREQFileTemplateRenderPreview, "startSubREQFileTemplateRenderPreview", fc.StartSubREQFileTemplateRenderPreview, "true/false")
+	flag.BoolVar(&c.StartSubREQAttachMetadata, "startSubREQAttachMetadata", fc.StartSubREQAttachMetadata, "true/false")
+	flag.BoolVar(&c.StartSubREQReconcileState, "startSubREQReconcileState", fc.StartSubREQReconcileState, "true/false")
+	flag.BoolVar(&c.StartSubREQShutdownScheduled, "startSubREQShutdownScheduled", fc.StartSubREQShutdownScheduled, "true/false")
+	flag.BoolVar(&c.StartSubREQValidateTrustStore, "startSubREQValidateTrustStore", fc.StartSubREQValidateTrustStore, "true/false")
+	flag.BoolVar(&c.StartSubREQInspectProcessGoroutines, "startSubREQInspectProcessGoroutines", fc.StartSubREQInspectProcessGoroutines, "true/false")
+	flag.BoolVar(&c.StartSubREQListFailedMessages, "startSubREQListFailedMessages", fc.StartSubREQListFailedMessages, "true/false")
+	flag.BoolVar(&c.StartSubREQInspectRetryState, "startSubREQInspectRetryState", fc.StartSubREQInspectRetryState, "true/false")
+	flag.BoolVar(&c.StartSubREQAdoptNodeName, "startSubREQAdoptNodeName", fc.StartSubREQAdoptNodeName, "true/false")
+	flag.BoolVar(&c.StartSubREQKeysRotate, "startSubREQKeysRotate", fc.StartSubREQKeysRotate, "true/false")
+	flag.BoolVar(&c.StartSubREQInspectTimeouts, "startSubREQInspectTimeouts", fc.StartSubREQInspectTimeouts, "true/false")
+	flag.BoolVar(&c.StartSubREQRunWithLock, "startSubREQRunWithLock", fc.StartSubREQRunWithLock, "true/false")
+	flag.BoolVar(&c.StartSubREQProbeMethod, "startSubREQProbeMethod", fc.StartSubREQProbeMethod, "true/false")
+	flag.BoolVar(&c.StartSubREQCentralChanged, "startSubREQCentralChanged", fc.StartSubREQCentralChanged, "true/false")
+	flag.BoolVar(&c.StartSubREQInspectSignature, "startSubREQInspectSignature", fc.StartSubREQInspectSignature, "true/false")
+	flag.BoolVar(&c.StartSubREQInspectAllowedSignatures, "startSubREQInspectAllowedSignatures", fc.StartSubREQInspectAllowedSignatures, "true/false")
+	flag.BoolVar(&c.StartSubREQEnvInfo, "startSubREQEnvInfo", fc.StartSubREQEnvInfo, "true/false")
+	flag.BoolVar(&c.StartSubREQPublicKeysList, "startSubREQPublicKeysList", fc.StartSubREQPublicKeysList, "true/false")
+	flag.BoolVar(&c.StartSubREQReachabilityProbe, "startSubREQReachabilityProbe", fc.StartSubREQReachabilityProbe, "true/false")
+	flag.BoolVar(&c.StartSubREQResourceLimitExec, "startSubREQResourceLimitExec", fc.StartSubREQResourceLimitExec, "true/false")
+	flag.BoolVar(&c.StartSubREQSyncTime, "startSubREQSyncTime", fc.StartSubREQSyncTime, "true/false, allow the system clock of this node to be set from the central. Steward needs to run as a privileged user to set the clock")
+	flag.IntVar(&c.TimeSyncMaxJump, "timeSyncMaxJump", fc.TimeSyncMaxJump, "the max number of seconds REQSyncTime is allowed to adjust the clock without being forced")
+	flag.BoolVar(&c.StartSubREQTimeNow, "startSubREQTimeNow", fc.StartSubREQTimeNow, "true/false")
+	flag.BoolVar(&c.StartSubREQNodeInfo, "startSubREQNodeInfo", fc.StartSubREQNodeInfo, "true/false")
 
 	purgeBufferDB := flag.Bool("purgeBufferDB", false, "true/false, purge the incoming buffer db and all it's state")
 
@@ -716,3 +1633,180 @@ func (c *Configuration) WriteConfigFile() error {
 
 	return nil
 }
+
+// centralMu guards the fields of the configuration telling which node
+// is the central, since they are changed by a failover on a running
+// node while the processes are reading them.
+var centralMu sync.RWMutex
+
+// centralNode will return the name of the node used as central.
+func (c *Configuration) centralNode() string {
+	centralMu.RLock()
+	defer centralMu.RUnlock()
+
+	return c.CentralNodeName
+}
+
+// setCentralNode will change the node used as central.
+func (c *Configuration) setCentralNode(node string) {
+	centralMu.Lock()
+	defer centralMu.Unlock()
+
+	c.CentralNodeName = node
+}
+
+// isCentralAuth will return true if the node is serving as central.
+func (c *Configuration) isCentralAuth() bool {
+	centralMu.RLock()
+	defer centralMu.RUnlock()
+
+	return c.IsCentralAuth
+}
+
+// promoteToCentral will make the node given the central, and return
+// which of the central services were not already enabled, and should
+// be started.
+func (c *Configuration) promoteToCentral(node string) (errorLogger bool, hello bool, centralAuth bool) {
+	centralMu.Lock()
+	defer centralMu.Unlock()
+
+	errorLogger = !c.IsCentralErrorLogger
+	hello = !c.StartSubREQHello
+	centralAuth = !c.IsCentralAuth
+
+	c.IsCentralErrorLogger = true
+	c.StartSubREQHello = true
+	c.IsCentralAuth = true
+	c.CentralNodeName = node
+
+	return errorLogger, hello, centralAuth
+}
+
+// Validate will check the configuration for values that would make the
+// node fail later when started, like listener addresses that can't be
+// parsed, folders that can't be written to, missing node names, and
+// options that can't be used together. All the problems found are
+// returned in one error.
+func (c *Configuration) Validate() error {
+	var problems []string
+
+	if err := validateNodeName(c.NodeName); err != nil {
+		problems = append(problems, fmt.Sprintf("nodeName %v", err))
+	}
+	if err := validateNodeName(c.CentralNodeName); err != nil {
+		problems = append(problems, fmt.Sprintf("centralNodeName %v", err))
+	}
+
+	// option is the name of an option, and its value.
+	type option struct {
+		name  string
+		value string
+	}
+
+	addresses := []option{
+		{"tcpListener", c.TCPListener},
+		{"httpListener", c.HTTPListener},
+		{"promHostAndPort", c.PromHostAndPort},
+		{"exposeDataFolder", c.ExposeDataFolder},
+	}
+	for _, a := range addresses {
+		if a.value == "" {
+			continue
+		}
+		if err := validateListenAddress(a.value); err != nil {
+			problems = append(problems, fmt.Sprintf("%v: %v", a.name, err))
+		}
+	}
+	if c.ProfilingPort != "" {
+		if err := validatePort(c.ProfilingPort); err != nil {
+			problems = append(problems, fmt.Sprintf("profilingPort: %v", err))
+		}
+	}
+
+	folders := []option{
+		{"databaseFolder", c.DatabaseFolder},
+		{"subscribersDataFolder", c.SubscribersDataFolder},
+	}
+	if c.EnableSocket {
+		folders = append(folders, option{"socketFolder", c.SocketFolder})
+	}
+	for _, f := range folders {
+		if err := validateWritableFolder(f.value); err != nil {
+			problems = append(problems, fmt.Sprintf("%v: %v", f.name, err))
+		}
+	}
+
+	if c.IsCentralAuth && c.IsStandbyCentral {
+		problems = append(problems, "isCentralAuth and isStandbyCentral can't both be set, a standby becomes central auth with REQFailover")
+	}
+	if (c.HTTPListenerCertFile == "") != (c.HTTPListenerKeyFile == "") {
+		problems = append(problems, "httpListenerCertFile and httpListenerKeyFile must be set together")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("error: invalid configuration: %v", strings.Join(problems, ", "))
+	}
+
+	return nil
+}
+
+// validateNodeName will check that the name can be used as the name of
+// a node, which is part of the nats subjects, and of the folders in the
+// data folder. The name can't be empty, have white space, the nats
+// wildcards, path separators, or empty elements between dots.
+func validateNodeName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("is not set")
+	case strings.ContainsAny(name, "*>/\\\x00") || strings.IndexFunc(name, unicode.IsSpace) != -1:
+		return fmt.Errorf("%q can't have white space or any of the characters * > / \\", name)
+	case strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, ".."):
+		return fmt.Errorf("%q can't start or end with a dot, or have two dots after each other", name)
+	}
+
+	return nil
+}
+
+// validateListenAddress will check that the address is a host and port,
+// like localhost:8888 or :8888.
+func validateListenAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v", address, err)
+	}
+
+	return validatePort(port)
+}
+
+// validatePort will check that the port is a number between 0 and 65535,
+// where 0 picks a free port.
+func validatePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+
+	return nil
+}
+
+// validateWritableFolder will check that the folder exists or can be
+// created, and that a file can be written in it.
+func validateWritableFolder(folder string) error {
+	if folder == "" {
+		return fmt.Errorf("no folder given")
+	}
+
+	err := os.MkdirAll(folder, 0700)
+	if err != nil {
+		return fmt.Errorf("folder %v can't be created: %v", folder, err)
+	}
+
+	f, err := os.CreateTemp(folder, ".validate")
+	if err != nil {
+		return fmt.Errorf("folder %v is not writable: %v", folder, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package steward
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The number of closed connections to keep in the registry, so we
+// can also see the recent connections that are no longer active.
+const connRegistryRecentMax = 100
+
+// connRecord holds the information about a single connection made to
+// one of the local listeners.
+type connRecord struct {
+	// The listener the connection was made to, like socket, tcp or http.
+	Listener string `json:"listener"`
+	// The address of the remote end of the connection.
+	RemoteAddr string `json:"remoteAddr"`
+	// The time the connection was made.
+	ConnectTime time.Time `json:"connectTime"`
+	// The time the connection was closed. Zero if still active.
+	DisconnectTime time.Time `json:"disconnectTime,omitempty"`
+	// The number of bytes read from the connection.
+	BytesRead int64 `json:"bytesRead"`
+	// The number of bytes written to the connection.
+	BytesWritten int64 `json:"bytesWritten"`
+	// The result of authenticating the connection. Empty if the
+	// listener do not use authentication.
+	AuthResult string `json:"authResult,omitempty"`
+	// Tells if the connection is still active.
+	Active bool `json:"active"`
+}
+
+// connAudit is the result replied back by REQConnectionAudit.
+type connAudit struct {
+	Active []connRecord `json:"active"`
+	Recent []connRecord `json:"recent"`
+}
+
+// connRegistry keeps track of the active and recent connections
+// made to the local unix socket, tcp and http listeners.
+type connRegistry struct {
+	active map[uint64]*trackedConn
+	recent []connRecord
+	nextID uint64
+	mu     sync.Mutex
+}
+
+func newConnRegistry() *connRegistry {
+	c := connRegistry{
+		active: make(map[uint64]*trackedConn),
+	}
+
+	return &c
+}
+
+// trackedConn is a net.Conn that counts the bytes transferred, and
+// removes itself from the active connections of the registry when
+// closed.
+type trackedConn struct {
+	net.Conn
+	id           uint64
+	registry     *connRegistry
+	record       connRecord
+	bytesRead    int64
+	bytesWritten int64
+	closeOnce    sync.Once
+}
+
+func (t *trackedConn) Read(b []byte) (int, error) {
+	n, err := t.Conn.Read(b)
+	atomic.AddInt64(&t.bytesRead, int64(n))
+	return n, err
+}
+
+func (t *trackedConn) Write(b []byte) (int, error) {
+	n, err := t.Conn.Write(b)
+	atomic.AddInt64(&t.bytesWritten, int64(n))
+	return n, err
+}
+
+func (t *trackedConn) Close() error {
+	t.closeOnce.Do(func() {
+		t.registry.done(t)
+	})
+	return t.Conn.Close()
+}
+
+// snapshot will return a copy of the record with the current
+// byte counters.
+func (t *trackedConn) snapshot() connRecord {
+	r := t.record
+	r.BytesRead = atomic.LoadInt64(&t.bytesRead)
+	r.BytesWritten = atomic.LoadInt64(&t.bytesWritten)
+	return r
+}
+
+// track will register the connection as active for the listener
+// given, and return a wrapped connection that should be used instead
+// of the original one.
+func (c *connRegistry) track(listener string, conn net.Conn) net.Conn {
+	remote := ""
+	if conn.RemoteAddr() != nil {
+		remote = conn.RemoteAddr().String()
+	}
+	// Unix socket connections have no remote address, so we use the
+	// local address of the socket instead.
+	if remote == "" && conn.LocalAddr() != nil {
+		remote = conn.LocalAddr().String()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	t := &trackedConn{
+		Conn:     conn,
+		id:       c.nextID,
+		registry: c,
+		record: connRecord{
+			Listener:    listener,
+			RemoteAddr:  remote,
+			ConnectTime: time.Now(),
+			Active:      true,
+		},
+	}
+	c.active[t.id] = t
+
+	return t
+}
+
+// record will add a finished request to the recent connections. It
+// is used for the http listener where we don't handle the connection
+// ourselves, but only see the individual requests.
+func (c *connRegistry) record(r connRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.addRecent(r)
+}
+
+// done will move the connection from the active to the recent
+// connections.
+func (c *connRegistry) done(t *trackedConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.active, t.id)
+
+	r := t.snapshot()
+	r.Active = false
+	r.DisconnectTime = time.Now()
+	c.addRecent(r)
+}
+
+// addRecent will add the record to the recent connections, and remove
+// the oldest if we have more than connRegistryRecentMax.
+// The caller must hold the lock.
+func (c *connRegistry) addRecent(r connRecord) {
+	c.recent = append(c.recent, r)
+	if len(c.recent) > connRegistryRecentMax {
+		c.recent = c.recent[len(c.recent)-connRegistryRecentMax:]
+	}
+}
+
+// audit will return the active connections sorted by connect time,
+// and the recent connections with the newest first.
+func (c *connRegistry) audit() connAudit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	a := connAudit{
+		Active: []connRecord{},
+		Recent: []connRecord{},
+	}
+
+	for _, t := range c.active {
+		a.Active = append(a.Active, t.snapshot())
+	}
+	sort.Slice(a.Active, func(i, j int) bool {
+		return a.Active[i].ConnectTime.Before(a.Active[j].ConnectTime)
+	})
+
+	for i := len(c.recent) - 1; i >= 0; i-- {
+		a.Recent = append(a.Recent, c.recent[i])
+	}
+
+	return a
+}
@@ -0,0 +1,217 @@
+package steward
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// The suffix added to the stored reply files when compressed.
+	compressedFileSuffix = ".gz"
+	// The age of the stored reply files to compress if no age is given.
+	compressStoredRepliesDefaultAge = time.Hour * 24
+)
+
+// compressionReport is the result of compressing the stored reply files.
+type compressionReport struct {
+	// The files compressed, relative to the data folder.
+	Compressed []string `json:"compressed"`
+	// The total size of the files before and after compression.
+	BytesBefore int64 `json:"bytesBefore"`
+	BytesAfter  int64 `json:"bytesAfter"`
+	BytesSaved  int64 `json:"bytesSaved"`
+	// Files that could not be compressed, and the reason.
+	Failed []string `json:"failed"`
+}
+
+// compressStoredReplies will walk the data folder and gzip all the
+// files last modified before olderThan in place, adding the .gz suffix
+// to the file name. Files already compressed are skipped. The
+// modification time of the original file is kept on the compressed
+// file. The index of the data folder is updated with the new names.
+func (d *dataIndex) compressStoredReplies(olderThan time.Time) (compressionReport, error) {
+	r := compressionReport{
+		Compressed: []string{},
+		Failed:     []string{},
+	}
+
+	absIndex, _ := filepath.Abs(d.filePath)
+
+	err := filepath.WalkDir(d.dataFolder, func(path string, de fs.DirEntry, err error) error {
+		// Files might be removed while we're walking the folder, and
+		// we just skip them if that happens.
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !de.Type().IsRegular() || strings.HasSuffix(path, compressedFileSuffix) {
+			return nil
+		}
+		if abs, _ := filepath.Abs(path); abs == absIndex {
+			return nil
+		}
+
+		fi, err := de.Info()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.ModTime().Before(olderThan) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(d.dataFolder, path)
+		if err != nil {
+			return err
+		}
+
+		size, err := compressFile(path, fi)
+		if err != nil {
+			r.Failed = append(r.Failed, fmt.Sprintf("%v: %v", relPath, err))
+			return nil
+		}
+
+		err = d.rename(path, path+compressedFileSuffix)
+		if err != nil {
+			r.Failed = append(r.Failed, fmt.Sprintf("%v: compressed, but failed to update the data index: %v", relPath, err))
+		}
+
+		r.Compressed = append(r.Compressed, relPath)
+		r.BytesBefore += fi.Size()
+		r.BytesAfter += size
+
+		return nil
+	})
+	if err != nil {
+		return r, fmt.Errorf("error: failed to walk data folder: %v", err)
+	}
+
+	r.BytesSaved = r.BytesBefore - r.BytesAfter
+
+	return r, nil
+}
+
+// compressFile will gzip the file at the path given to a new file with
+// the .gz suffix added, and remove the original file. The compressed
+// content is written to a temporary file first, so a failure will not
+// leave a broken compressed file behind. The size of the compressed
+// file is returned.
+func compressFile(path string, fi fs.FileInfo) (int64, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer src.Close()
+
+	dstPath := path + compressedFileSuffix
+	tmpPath := dstPath + ".tmp"
+
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return 0, fmt.Errorf("failed to create compressed file: %v", err)
+	}
+	defer os.Remove(tmpPath)
+
+	gz := gzip.NewWriter(dst)
+	gz.Name = filepath.Base(path)
+	gz.ModTime = fi.ModTime()
+
+	_, err = io.Copy(gz, src)
+	if err != nil {
+		dst.Close()
+		return 0, fmt.Errorf("failed to compress file: %v", err)
+	}
+	err = gz.Close()
+	if err != nil {
+		dst.Close()
+		return 0, fmt.Errorf("failed to compress file: %v", err)
+	}
+	err = dst.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to close compressed file: %v", err)
+	}
+
+	err = os.Chtimes(tmpPath, fi.ModTime(), fi.ModTime())
+	if err != nil {
+		return 0, fmt.Errorf("failed to set modification time of compressed file: %v", err)
+	}
+
+	dfi, err := os.Stat(tmpPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat compressed file: %v", err)
+	}
+
+	err = os.Rename(tmpPath, dstPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to rename compressed file: %v", err)
+	}
+	err = os.Remove(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove original file: %v", err)
+	}
+
+	return dfi.Size(), nil
+}
+
+// gzipFileReader will decompress a file while reading, and close both
+// the decompressor and the file when closed.
+type gzipFileReader struct {
+	*gzip.Reader
+	fh *os.File
+}
+
+func (g gzipFileReader) Close() error {
+	g.Reader.Close()
+	return g.fh.Close()
+}
+
+// openStoredFile will open the file at the path given for reading. If
+// the file do not exist, but a file compressed by
+// REQCompressStoredReplies is found in its place, the compressed file
+// is opened instead and decompressed while reading. The FileInfo
+// returned is for the file opened.
+func openStoredFile(path string) (io.ReadCloser, fs.FileInfo, error) {
+	fh, err := os.Open(path)
+	if err == nil {
+		fi, err := fh.Stat()
+		if err != nil {
+			fh.Close()
+			return nil, nil, err
+		}
+		return fh, fi, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	gzFh, gzErr := os.Open(path + compressedFileSuffix)
+	if gzErr != nil {
+		// Return the error for the original file, so it is still
+		// reported as not existing.
+		return nil, nil, err
+	}
+
+	fi, err := gzFh.Stat()
+	if err != nil {
+		gzFh.Close()
+		return nil, nil, err
+	}
+
+	gz, err := gzip.NewReader(gzFh)
+	if err != nil {
+		gzFh.Close()
+		return nil, nil, fmt.Errorf("failed to decompress file: %v", err)
+	}
+
+	return gzipFileReader{Reader: gz, fh: gzFh}, fi, nil
+}
@@ -0,0 +1,573 @@
+package steward
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dataIndexEntry holds the information about a single reply file
+// stored in the SubscribersDataFolder.
+type dataIndexEntry struct {
+	// The node the data was received from.
+	Node Node `json:"node"`
+	// The method of the request that produced the data.
+	Method Method `json:"method"`
+	// The directory below the data folder, not including the node.
+	Directory string `json:"directory"`
+	// The name of the file.
+	FileName string `json:"fileName"`
+	// The modification time of the file.
+	ModTime time.Time `json:"modTime"`
+	// The size of the file in bytes.
+	Size int64 `json:"size"`
+	// The sha256 checksum of the file content given as a hex string.
+	// The checksum is empty if the file changed while it was read.
+	Checksum string `json:"checksum"`
+}
+
+// dataIntegrityReport holds the result of verifying the checksums of
+// the files in the data index.
+type dataIntegrityReport struct {
+	// The number of files checked.
+	Checked int `json:"checked"`
+	// Files where the content no longer match the recorded checksum.
+	Corrupted []string `json:"corrupted"`
+	// Files in the index that no longer exist in the data folder.
+	Missing []string `json:"missing"`
+	// Files with no recorded checksum, and that could not be verified.
+	NoChecksum []string `json:"noChecksum"`
+}
+
+// dataIndexQuery holds the values to search the data index with.
+// Fields not set are not used when matching.
+type dataIndexQuery struct {
+	Node           Node      `json:"node"`
+	Method         Method    `json:"method"`
+	Directory      string    `json:"directory"`
+	FileName       string    `json:"fileName"`
+	ModifiedAfter  time.Time `json:"modifiedAfter"`
+	ModifiedBefore time.Time `json:"modifiedBefore"`
+	MinSize        int64     `json:"minSize"`
+	MaxSize        int64     `json:"maxSize"`
+}
+
+// match will return true if the entry matches all the fields set
+// in the query.
+func (q dataIndexQuery) match(e dataIndexEntry) bool {
+	switch {
+	case q.Node != "" && q.Node != e.Node:
+		return false
+	case q.Method != "" && q.Method != e.Method:
+		return false
+	case q.Directory != "" && q.Directory != e.Directory:
+		return false
+	case q.FileName != "" && q.FileName != e.FileName:
+		return false
+	case !q.ModifiedAfter.IsZero() && !e.ModTime.After(q.ModifiedAfter):
+		return false
+	case !q.ModifiedBefore.IsZero() && !e.ModTime.Before(q.ModifiedBefore):
+		return false
+	case q.MinSize != 0 && e.Size < q.MinSize:
+		return false
+	case q.MaxSize != 0 && e.Size > q.MaxSize:
+		return false
+	}
+
+	return true
+}
+
+const (
+	// The number of updates to queue before the files written are no
+	// longer added to the index until the next reindex.
+	dataIndexQueueSize = 10000
+	// The max number of updates applied to the index before the index
+	// is saved to file.
+	dataIndexBatchSize = 500
+	// How often the updates queued are applied to the index.
+	dataIndexBatchInterval = time.Second
+)
+
+// dataIndexUpdate is a file written in the data folder, that is
+// queued to be added to the index.
+type dataIndexUpdate struct {
+	path   string
+	method Method
+}
+
+// dataIndex is a searchable index of the reply files stored in the
+// SubscribersDataFolder. The key of the entries map is the path of
+// the file relative to the data folder.
+type dataIndex struct {
+	entries    map[string]dataIndexEntry
+	dataFolder string
+	filePath   string
+	mu         sync.Mutex
+	// updateCh is where the files written are queued, to be added to
+	// the index in batches by run.
+	updateCh chan dataIndexUpdate
+}
+
+func newDataIndex(c *Configuration) *dataIndex {
+	d := dataIndex{
+		entries:    make(map[string]dataIndexEntry),
+		dataFolder: c.SubscribersDataFolder,
+		filePath:   filepath.Join(c.DatabaseFolder, "data_index.txt"),
+		updateCh:   make(chan dataIndexUpdate, dataIndexQueueSize),
+	}
+
+	err := d.loadFromFile()
+	if err != nil {
+		log.Printf("error: loading data index from file: %v\n", err)
+	}
+
+	return &d
+}
+
+// loadFromFile will try to load the currently stored index from file,
+// and return the error if it fails.
+// If no file is found a nil error is returned.
+func (d *dataIndex) loadFromFile() error {
+	if _, err := os.Stat(d.filePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	fh, err := os.OpenFile(d.filePath, os.O_RDONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error: failed to open data index file: %v", err)
+	}
+	defer fh.Close()
+
+	b, err := io.ReadAll(fh)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err = json.Unmarshal(b, &d.entries)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// saveToFile will save the index to file for persistent storage.
+// The caller must hold the lock.
+func (d *dataIndex) saveToFile() error {
+	b, err := json.Marshal(d.entries)
+	if err != nil {
+		return fmt.Errorf("error: failed to marshal data index: %v", err)
+	}
+
+	err = os.WriteFile(d.filePath, b, 0600)
+	if err != nil {
+		return fmt.Errorf("error: failed to write data index file: %v", err)
+	}
+
+	return nil
+}
+
+// fileChecksum will return the sha256 checksum of the content of
+// the file as a hex string.
+func fileChecksum(path string) (string, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, fh)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newDataIndexEntry will create an index entry for the file at the path
+// given relative to the data folder. The last folder of the path
+// is the node, and the folders before that is the directory.
+func newDataIndexEntry(relPath string, fi fs.FileInfo, method Method, checksum string) dataIndexEntry {
+	e := dataIndexEntry{
+		Method:   method,
+		FileName: filepath.Base(relPath),
+		ModTime:  fi.ModTime(),
+		Size:     fi.Size(),
+		Checksum: checksum,
+	}
+
+	dir := filepath.Dir(relPath)
+	if dir != "." {
+		e.Node = Node(filepath.Base(dir))
+		if d := filepath.Dir(dir); d != "." {
+			e.Directory = d
+		}
+	}
+
+	return e
+}
+
+// reindex will walk the data folder and rebuild the index with all
+// the files found. The methods already known for files in the
+// current index are kept, since the method can not be found from
+// the file itself.
+// The checksums of files already indexed are kept if the modification
+// time and size are unchanged, so any silent corruption of the content
+// will not be hidden by a reindex.
+func (d *dataIndex) reindex() error {
+	entries := make(map[string]dataIndexEntry)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := filepath.WalkDir(d.dataFolder, func(path string, de fs.DirEntry, err error) error {
+		// Files might be removed while we're walking the folder, and
+		// we just skip them if that happens.
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Only regular files are indexed, so we skip folders, sockets
+		// and other special files.
+		if !de.Type().IsRegular() {
+			return nil
+		}
+
+		abs, _ := filepath.Abs(path)
+		absIndex, _ := filepath.Abs(d.filePath)
+		if abs == absIndex {
+			return nil
+		}
+
+		fi, err := de.Info()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(d.dataFolder, path)
+		if err != nil {
+			return err
+		}
+
+		old := d.entries[relPath]
+		checksum := unchangedChecksum(old, fi)
+		if checksum == "" {
+			checksum, fi, err = stableChecksum(path, fi)
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		entries[relPath] = newDataIndexEntry(relPath, fi, old.Method, checksum)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error: failed to walk data folder: %v", err)
+	}
+
+	d.entries = entries
+
+	return d.saveToFile()
+}
+
+// renameNode will move the entries of the files received from the old
+// node name to the new node name, keeping the method and checksum of
+// the entries.
+func (d *dataIndex) renameNode(oldName Node, newName Node) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for relPath, e := range d.entries {
+		if e.Node != oldName {
+			continue
+		}
+
+		e.Node = newName
+		delete(d.entries, relPath)
+		d.entries[filepath.Join(e.Directory, string(newName), e.FileName)] = e
+	}
+
+	return d.saveToFile()
+}
+
+// rename will move the index entry of the file at oldPath to newPath,
+// keeping the method of the entry. The size and checksum are updated
+// from the file at newPath.
+func (d *dataIndex) rename(oldPath string, newPath string) error {
+	fi, err := os.Stat(newPath)
+	if err != nil {
+		return fmt.Errorf("error: failed to stat file for data index: %v", err)
+	}
+
+	checksum, err := fileChecksum(newPath)
+	if err != nil {
+		return fmt.Errorf("error: failed to create checksum for data index: %v", err)
+	}
+
+	oldRel, err := filepath.Rel(d.dataFolder, oldPath)
+	if err != nil || strings.HasPrefix(oldRel, "..") {
+		return fmt.Errorf("error: file is not within the data folder: %v", oldPath)
+	}
+	newRel, err := filepath.Rel(d.dataFolder, newPath)
+	if err != nil || strings.HasPrefix(newRel, "..") {
+		return fmt.Errorf("error: file is not within the data folder: %v", newPath)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	method := d.entries[oldRel].Method
+	delete(d.entries, oldRel)
+	d.entries[newRel] = newDataIndexEntry(newRel, fi, method, checksum)
+
+	return d.saveToFile()
+}
+
+// unchangedChecksum will return the checksum of the entry if the file
+// still has the same modification time and size, and an empty string
+// if the file has changed.
+func unchangedChecksum(e dataIndexEntry, fi fs.FileInfo) string {
+	if !e.ModTime.Equal(fi.ModTime()) || e.Size != fi.Size() {
+		return ""
+	}
+
+	return e.Checksum
+}
+
+// stableChecksum will return the checksum of the file at the path, and
+// the file info of the file when it was read. If the file changed while
+// it was read, like when appended to, the checksum is empty, since the
+// content read might not be the content of the file. fi is the file
+// info of the file before it is read.
+func stableChecksum(path string, fi fs.FileInfo) (string, fs.FileInfo, error) {
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return "", fi, err
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		return "", fi, err
+	}
+	if !after.ModTime().Equal(fi.ModTime()) || after.Size() != fi.Size() {
+		return "", after, nil
+	}
+
+	return checksum, fi, nil
+}
+
+// queueUpdate will queue the file at the path given to be added to the
+// index by run. It is called when a file is written in the data folder
+// to keep the index up to date without having to walk the whole data
+// folder. If the queue is full an error is returned, and the file will
+// be indexed by the next reindex.
+func (d *dataIndex) queueUpdate(path string, method Method) error {
+	select {
+	case d.updateCh <- dataIndexUpdate{path: path, method: method}:
+		return nil
+	default:
+		return fmt.Errorf("error: data index update queue is full, %v will be indexed by the next reindex", path)
+	}
+}
+
+// run will add the files queued with queueUpdate to the index. The
+// updates are collected and applied in batches, so the index is not
+// saved to file for every file written.
+func (d *dataIndex) run(ctx context.Context, onError func(error)) {
+	ticker := time.NewTicker(dataIndexBatchInterval)
+	defer ticker.Stop()
+
+	batch := []dataIndexUpdate{}
+	apply := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := d.apply(batch)
+		if err != nil {
+			onError(err)
+		}
+		batch = []dataIndexUpdate{}
+	}
+
+	for {
+		select {
+		case u := <-d.updateCh:
+			batch = append(batch, u)
+			if len(batch) >= dataIndexBatchSize {
+				apply()
+			}
+		case <-ticker.C:
+			apply()
+		case <-ctx.Done():
+			apply()
+			return
+		}
+	}
+}
+
+// update will update the index entry for the file at the path given,
+// and save the index to file.
+func (d *dataIndex) update(path string, method Method) error {
+	return d.apply([]dataIndexUpdate{{path: path, method: method}})
+}
+
+// apply will update the index entries for all the files in the batch,
+// and save the index to file once. The files are read to record the
+// checksum of the content written, without holding the lock so the
+// index can still be searched while the batch is read. An error for a
+// single file does not stop the rest of the batch from being applied,
+// and the errors are returned together.
+func (d *dataIndex) apply(batch []dataIndexUpdate) error {
+	type applied struct {
+		relPath  string
+		fi       fs.FileInfo
+		method   Method
+		checksum string
+	}
+
+	errs := []string{}
+	done := []applied{}
+	for _, u := range batch {
+		relPath, err := filepath.Rel(d.dataFolder, u.path)
+		if err != nil || strings.HasPrefix(relPath, "..") {
+			errs = append(errs, fmt.Sprintf("file is not within the data folder: %v", u.path))
+			continue
+		}
+
+		fi, err := os.Stat(u.path)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("failed to stat file for data index: %v", err))
+			continue
+		}
+
+		checksum, fi, err := stableChecksum(u.path, fi)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("failed to create checksum for data index: %v", err))
+			continue
+		}
+
+		done = append(done, applied{relPath: relPath, fi: fi, method: u.method, checksum: checksum})
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, a := range done {
+		d.entries[a.relPath] = newDataIndexEntry(a.relPath, a.fi, a.method, a.checksum)
+	}
+
+	err := d.saveToFile()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error: failed to update data index: %v", strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// verify will re-read all the files in the index matching the query,
+// and compare the content with the recorded checksums. The entries to
+// verify are copied from the index, so the lock is not held while the
+// files are read.
+func (d *dataIndex) verify(q dataIndexQuery) dataIntegrityReport {
+	r := dataIntegrityReport{
+		Corrupted:  []string{},
+		Missing:    []string{},
+		NoChecksum: []string{},
+	}
+
+	d.mu.Lock()
+	entries := make(map[string]dataIndexEntry)
+	for relPath, e := range d.entries {
+		if q.match(e) {
+			entries[relPath] = e
+		}
+	}
+	d.mu.Unlock()
+
+	relPaths := []string{}
+	for relPath := range entries {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+
+	for _, relPath := range relPaths {
+		e := entries[relPath]
+		r.Checked++
+
+		path := filepath.Join(d.dataFolder, relPath)
+
+		if e.Checksum == "" {
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				r.Missing = append(r.Missing, relPath)
+				continue
+			}
+			r.NoChecksum = append(r.NoChecksum, relPath)
+			continue
+		}
+
+		checksum, err := fileChecksum(path)
+		switch {
+		case os.IsNotExist(err):
+			r.Missing = append(r.Missing, relPath)
+		case err != nil:
+			r.Corrupted = append(r.Corrupted, relPath)
+		case checksum != e.Checksum:
+			r.Corrupted = append(r.Corrupted, relPath)
+		}
+	}
+
+	return r
+}
+
+// search will return all the entries matching the query sorted
+// by node, directory and file name.
+func (d *dataIndex) search(q dataIndexQuery) []dataIndexEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result := []dataIndexEntry{}
+	for _, e := range d.entries {
+		if q.match(e) {
+			result = append(result, e)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Node != result[j].Node {
+			return result[i].Node < result[j].Node
+		}
+		if result[i].Directory != result[j].Directory {
+			return result[i].Directory < result[j].Directory
+		}
+		return result[i].FileName < result[j].FileName
+	})
+
+	return result
+}
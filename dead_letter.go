@@ -0,0 +1,205 @@
+package steward
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// deadLetterMaxEntries is the max number of entries kept in the dead
+// letter store. The oldest entries are removed when the max is reached.
+const deadLetterMaxEntries int = 1000
+
+// deadLetterEntry holds a message that could not be delivered after
+// all the retries were used.
+type deadLetterEntry struct {
+	// The id of the entry in the dead letter store.
+	ID int `json:"id"`
+	// The time the message was put in the dead letter store.
+	Time time.Time `json:"time"`
+	// The node the message was sent to.
+	ToNode Node `json:"toNode"`
+	// The method of the message.
+	Method Method `json:"method"`
+	// The number of delivery attempts done.
+	Attempts int `json:"attempts"`
+	// The last error when delivering the message.
+	LastError string `json:"lastError"`
+	// The message that failed.
+	Message Message `json:"message"`
+}
+
+// deadLetterQuery holds the values to filter the dead letter store
+// with. Fields not set are not used when matching.
+type deadLetterQuery struct {
+	ToNode Node      `json:"toNode"`
+	Method Method    `json:"method"`
+	After  time.Time `json:"after"`
+	Before time.Time `json:"before"`
+}
+
+// match will return true if the entry matches all the fields set
+// in the query.
+func (q deadLetterQuery) match(e deadLetterEntry) bool {
+	switch {
+	case q.ToNode != "" && q.ToNode != e.ToNode:
+		return false
+	case q.Method != "" && q.Method != e.Method:
+		return false
+	case !q.After.IsZero() && !e.Time.After(q.After):
+		return false
+	case !q.Before.IsZero() && !e.Time.Before(q.Before):
+		return false
+	}
+
+	return true
+}
+
+// deadLetterList is the result of listing the dead letter store.
+type deadLetterList struct {
+	// The number of entries matching the query.
+	Total int `json:"total"`
+	// The number of matching entries per destination node.
+	ByNode map[Node]int `json:"byNode"`
+	// The number of matching entries per method.
+	ByMethod map[Method]int `json:"byMethod"`
+	// The matching entries.
+	Entries []deadLetterEntry `json:"entries"`
+}
+
+// deadLetters is the store for the messages that could not be delivered.
+type deadLetters struct {
+	entries  []deadLetterEntry
+	nextID   int
+	filePath string
+	mu       sync.Mutex
+}
+
+func newDeadLetters(c *Configuration) *deadLetters {
+	d := deadLetters{
+		entries:  []deadLetterEntry{},
+		nextID:   1,
+		filePath: filepath.Join(c.DatabaseFolder, "dead_letters.txt"),
+	}
+
+	err := d.loadFromFile()
+	if err != nil {
+		log.Printf("error: loading dead letters from file: %v\n", err)
+	}
+
+	return &d
+}
+
+// loadFromFile will try to load the currently stored dead letters from
+// file, and return the error if it fails.
+// If no file is found a nil error is returned.
+func (d *deadLetters) loadFromFile() error {
+	if _, err := os.Stat(d.filePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	fh, err := os.OpenFile(d.filePath, os.O_RDONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error: failed to open dead letters file: %v", err)
+	}
+	defer fh.Close()
+
+	b, err := io.ReadAll(fh)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err = json.Unmarshal(b, &d.entries)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range d.entries {
+		if e.ID >= d.nextID {
+			d.nextID = e.ID + 1
+		}
+	}
+
+	return nil
+}
+
+// saveToFile will save the dead letters to file for persistent storage.
+// The caller must hold the lock.
+func (d *deadLetters) saveToFile() error {
+	b, err := json.Marshal(d.entries)
+	if err != nil {
+		return fmt.Errorf("error: failed to marshal dead letters: %v", err)
+	}
+
+	err = os.WriteFile(d.filePath, b, 0600)
+	if err != nil {
+		return fmt.Errorf("error: failed to write dead letters file: %v", err)
+	}
+
+	return nil
+}
+
+// add will put the message in the dead letter store, and return the
+// entry added.
+func (d *deadLetters) add(message Message, attempts int, lastError string) deadLetterEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// The previous message can be large, and is not needed to resend.
+	message.PreviousMessage = nil
+
+	e := deadLetterEntry{
+		ID:        d.nextID,
+		Time:      time.Now(),
+		ToNode:    message.ToNode,
+		Method:    message.Method,
+		Attempts:  attempts,
+		LastError: lastError,
+		Message:   message,
+	}
+	d.nextID++
+
+	d.entries = append(d.entries, e)
+	if len(d.entries) > deadLetterMaxEntries {
+		d.entries = d.entries[len(d.entries)-deadLetterMaxEntries:]
+	}
+
+	err := d.saveToFile()
+	if err != nil {
+		log.Printf("%v\n", err)
+	}
+
+	return e
+}
+
+// list will return the entries matching the query together with the
+// number of matching entries per node and method.
+func (d *deadLetters) list(q deadLetterQuery) deadLetterList {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l := deadLetterList{
+		ByNode:   make(map[Node]int),
+		ByMethod: make(map[Method]int),
+		Entries:  []deadLetterEntry{},
+	}
+
+	for _, e := range d.entries {
+		if !q.match(e) {
+			continue
+		}
+		l.Total++
+		l.ByNode[e.ToNode]++
+		l.ByMethod[e.Method]++
+		l.Entries = append(l.Entries, e)
+	}
+
+	return l
+}
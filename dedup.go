@@ -0,0 +1,150 @@
+package steward
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dedupCacheMaxEntries is the max number of messages remembered. When
+// full the oldest messages are evicted, even if they are still within
+// the window.
+const dedupCacheMaxEntries = 10000
+
+// dedupEntry is a message received, the ACK replied for it when
+// handled, and the time it was first seen.
+type dedupEntry struct {
+	ack     []byte
+	handled bool
+	seen    time.Time
+}
+
+// dedupCache remembers the ACK messages received recently, so a message
+// resent by the publisher because the ACK was lost is not handled again,
+// and the ACK is resent instead.
+type dedupCache struct {
+	// The methods the messages are deduplicated for.
+	methods map[Method]struct{}
+	// How long a message is remembered. 0 disables the deduplication.
+	window time.Duration
+	seen   map[string]*dedupEntry
+	// order holds the keys in the order they were seen, so the oldest
+	// can be evicted first.
+	order      []string
+	maxEntries int
+	mu         sync.Mutex
+}
+
+func newDedupCache(c *Configuration) *dedupCache {
+	d := dedupCache{
+		methods:    newDedupMethods(c.DedupMethods),
+		window:     time.Second * time.Duration(c.DedupWindow),
+		seen:       make(map[string]*dedupEntry),
+		maxEntries: dedupCacheMaxEntries,
+	}
+
+	return &d
+}
+
+// newDedupMethods will return the set of methods to deduplicate, from
+// the comma separated list of methods given. If the list is empty all
+// the methods that are not read-only are returned, since the read-only
+// methods are safe to run twice.
+func newDedupMethods(list string) map[Method]struct{} {
+	methods := make(map[Method]struct{})
+
+	var mt Method
+	ma := mt.GetMethodsAvailable()
+
+	if strings.TrimSpace(list) == "" {
+		for m, mh := range ma.Methodhandlers {
+			if !mh.isReadOnly() {
+				methods[m] = struct{}{}
+			}
+		}
+
+		return methods
+	}
+
+	for _, v := range strings.Split(list, ",") {
+		m := Method(strings.TrimSpace(v))
+		if _, ok := ma.CheckIfExists(m); !ok {
+			log.Printf("error: dedupMethods: no such method: %v\n", m)
+			continue
+		}
+		methods[m] = struct{}{}
+	}
+
+	return methods
+}
+
+// dedupKey will return the key of a message received. The ID of a
+// message is not unique, so the node, the method, the delivery ID and a
+// hash of the content of the message are part of the key. The publisher
+// sets a new time and nonce, and signs the message again for each
+// attempt to deliver it, while the delivery ID is kept, so a message
+// resent gives the same key as the original. Messages without a delivery
+// ID keep the nonce in the key, since the nonce is then what makes two
+// messages with the same content different.
+func dedupKey(m Message) string {
+	if m.DeliveryID != "" {
+		m.SignedAt = 0
+		m.SignNonce = ""
+	}
+	h := sha256.Sum256([]byte(signedString(m)))
+	return fmt.Sprintf("%v/%v/%v/%v/%v", m.FromNode, m.Method, m.ID, m.DeliveryID, hex.EncodeToString(h[:]))
+}
+
+// check will return true if the message with the key given was already
+// seen within the window, together with the ACK replied for it, and if
+// it was handled, since the handler might still be running. If not seen
+// the message is remembered, and done should be called with the ACK
+// when handled.
+func (d *dedupCache) check(key string, method Method, now time.Time) (ack []byte, handled bool, dup bool) {
+	if d.window == 0 {
+		return nil, false, false
+	}
+	if _, ok := d.methods[method]; !ok {
+		return nil, false, false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Evict the messages outside the window, and the oldest if the
+	// cache is full.
+	i := 0
+	for i < len(d.order) {
+		e := d.seen[d.order[i]]
+		if !e.seen.Before(now.Add(-d.window)) && len(d.order)-i < d.maxEntries {
+			break
+		}
+		delete(d.seen, d.order[i])
+		i++
+	}
+	d.order = d.order[i:]
+
+	if e, ok := d.seen[key]; ok {
+		return e.ack, e.handled, true
+	}
+
+	d.seen[key] = &dedupEntry{seen: now}
+	d.order = append(d.order, key)
+
+	return nil, false, false
+}
+
+// done will store the ACK replied for the message with the key given.
+func (d *dedupCache) done(key string, ack []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.seen[key]; ok {
+		e.ack = ack
+		e.handled = true
+	}
+}
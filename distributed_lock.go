@@ -0,0 +1,138 @@
+package steward
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// The time to wait before asking central for a lock again when
+	// it is held by someone else.
+	lockRetryInterval = time.Millisecond * 500
+)
+
+// lockLease is a lock held on central. The lease expires if it is not
+// renewed by the holder, so a lock is not held forever if the holder
+// dies.
+type lockLease struct {
+	// Holder is the unique id of the request holding the lock. It is
+	// only known to the node holding the lock, and is not sent to the
+	// nodes refused the lock.
+	Holder  string    `json:"holder,omitempty"`
+	Node    Node      `json:"node"`
+	Expires time.Time `json:"expires"`
+}
+
+// lockResult is the reply from central to a request for a lock.
+type lockResult struct {
+	Granted bool      `json:"granted"`
+	Lease   lockLease `json:"lease"`
+}
+
+// distributedLocks holds the leases of the locks on central, and on
+// the nodes the requests waiting for a reply from central about a
+// lock.
+type distributedLocks struct {
+	leases  map[string]lockLease
+	waiters map[string]chan lockResult
+	mu      sync.Mutex
+}
+
+func newDistributedLocks() *distributedLocks {
+	d := distributedLocks{
+		leases:  make(map[string]lockLease),
+		waiters: make(map[string]chan lockResult),
+	}
+
+	return &d
+}
+
+// acquire will give the lock with the name given to the holder on the
+// node if the lock is free, the lease of the current holder has expired,
+// or the holder on the same node already have the lock, in which case
+// the lease is renewed. If renew is true the lock is only given if the
+// holder already have it, so a renewal arriving after a release do not
+// take the lock again. The node is the node the request came from, so
+// a node can't renew or take over a lock held by another node by using
+// its holder. If the lock is refused the lease of the current holder is
+// returned without the holder.
+func (d *distributedLocks) acquire(name string, holder string, node Node, lease time.Duration, renew bool) lockResult {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	l, ok := d.leases[name]
+	held := ok && l.Holder == holder && l.Node == node
+	switch {
+	case renew && !held:
+		return lockResult{Granted: false, Lease: lockLease{Node: l.Node, Expires: l.Expires}}
+	case ok && !held && now.Before(l.Expires):
+		return lockResult{Granted: false, Lease: lockLease{Node: l.Node, Expires: l.Expires}}
+	}
+
+	l = lockLease{
+		Holder:  holder,
+		Node:    node,
+		Expires: now.Add(lease),
+	}
+	d.leases[name] = l
+
+	return lockResult{Granted: true, Lease: l}
+}
+
+// release will release the lock with the name given if it is held by
+// the holder given on the node given. It will return false if the lock
+// was not held by the holder on the node.
+func (d *distributedLocks) release(name string, holder string, node Node) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, ok := d.leases[name]
+	if !ok || l.Holder != holder || l.Node != node {
+		return false
+	}
+
+	delete(d.leases, name)
+	return true
+}
+
+// wait will register the holder as waiting for replies from central,
+// and return the channel where the replies are delivered.
+func (d *distributedLocks) wait(holder string) <-chan lockResult {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ch := make(chan lockResult, 1)
+	d.waiters[holder] = ch
+
+	return ch
+}
+
+// deliver will deliver the reply from central to the holder waiting for
+// it. If a reply is already waiting to be read it is replaced, since
+// only the latest reply is of interest.
+func (d *distributedLocks) deliver(holder string, r lockResult) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ch, ok := d.waiters[holder]
+	if !ok {
+		return false
+	}
+
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- r
+
+	return true
+}
+
+// stopWaiting will remove the holder from the ones waiting for replies.
+func (d *distributedLocks) stopWaiting(holder string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.waiters, holder)
+}
@@ -0,0 +1,91 @@
+package steward
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// errorAggregateNumbers matches the numbers in an error, like message
+// ID's, counters and times, which are replaced when normalizing the
+// error so errors only differing in the numbers are seen as the same.
+var errorAggregateNumbers = regexp.MustCompile(`[0-9]+`)
+
+// errorAggregateEntry is an error seen within the window.
+type errorAggregateEntry struct {
+	// The last of the repeated errors, which is sent with the count
+	// when the window closes.
+	last errorEvent
+	// The number of times the error was repeated after the first.
+	repeated int
+}
+
+// errorAggregator coalesces the identical errors from a node, so a
+// failing loop does not flood the error log. The first error is sent
+// at once and starts a window. The identical errors within the window
+// are only counted, and when the window closes a single error with the
+// number of times it was repeated is sent.
+type errorAggregator struct {
+	window time.Duration
+	// The errors seen within the window by their key.
+	entries map[string]*errorAggregateEntry
+	// send is called with the error sent when a window closes.
+	send func(errorEvent)
+	mu   sync.Mutex
+}
+
+func newErrorAggregator(window time.Duration, send func(errorEvent)) *errorAggregator {
+	a := errorAggregator{
+		window:  window,
+		entries: make(map[string]*errorAggregateEntry),
+		send:    send,
+	}
+
+	return &a
+}
+
+// errorAggregateKey will return the key of the error, which is the
+// node, the type and the normalized text of the error.
+func errorAggregateKey(ev errorEvent) string {
+	text := errorAggregateNumbers.ReplaceAllString(ev.err.Error(), "#")
+	return fmt.Sprintf("%v\n%v\n%v", ev.process.node, ev.errorType, text)
+}
+
+// first will return true if the error should be sent now, because an
+// identical error have not been seen within the window. If false the
+// error is counted, and sent with the count when the window closes.
+func (a *errorAggregator) first(ev errorEvent) bool {
+	key := errorAggregateKey(ev)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if entry, ok := a.entries[key]; ok {
+		entry.last = ev
+		entry.repeated++
+		return false
+	}
+
+	a.entries[key] = &errorAggregateEntry{last: ev}
+	time.AfterFunc(a.window, func() { a.close(key) })
+
+	return true
+}
+
+// close will close the window of the error, and send the error with
+// the number of times it was repeated if it was repeated.
+func (a *errorAggregator) close(key string) {
+	a.mu.Lock()
+	entry := a.entries[key]
+	delete(a.entries, key)
+	a.mu.Unlock()
+
+	if entry == nil || entry.repeated == 0 {
+		return
+	}
+
+	ev := entry.last
+	ev.err = fmt.Errorf("%v (repeated %v times in the last %v)", ev.err, entry.repeated, a.window)
+	a.send(ev)
+}
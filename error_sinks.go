@@ -0,0 +1,241 @@
+package steward
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errorSink is a destination where the error kernel will forward the
+// error and info messages it receives.
+type errorSink interface {
+	// send will deliver the formatted error to the sink.
+	send(er string, ev errorEvent) error
+}
+
+// errorSinkState holds the settings and the health of a sink.
+type errorSinkState struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// The URL to post to for sinks that use one.
+	URL string `json:"url,omitempty"`
+	// The last time an error was successfully delivered.
+	LastSuccess time.Time `json:"lastSuccess"`
+	// The last error from delivering to the sink.
+	LastError string `json:"lastError,omitempty"`
+	// The number of errors delivered.
+	Sent int `json:"sent"`
+	// The number of errors that failed to be delivered.
+	Dropped int `json:"dropped"`
+}
+
+type errorSinkEntry struct {
+	sink  errorSink
+	state errorSinkState
+}
+
+// errorSinks holds all the sinks of the error kernel.
+type errorSinks struct {
+	sinks map[string]*errorSinkEntry
+	mu    sync.Mutex
+}
+
+// newErrorSinks will prepare the sinks. The central error logger sink is
+// always enabled, the console sink is enabled if debug is enabled, and
+// the webhook sink is enabled if a webhook URL is configured.
+func newErrorSinks(e *errorKernel, c *Configuration) *errorSinks {
+	s := errorSinks{
+		sinks: make(map[string]*errorSinkEntry),
+	}
+
+	s.add("central", centralErrorSink{errorKernel: e}, true)
+	s.add("console", consoleErrorSink{logger: e.logger}, c.EnableDebug)
+
+	webhook := newWebhookErrorSink(c.ErrorWebhookURL)
+	s.add("webhook", webhook, c.ErrorWebhookURL != "")
+	s.sinks["webhook"].state.URL = c.ErrorWebhookURL
+
+	return &s
+}
+
+// add will add a sink with the name given.
+func (s *errorSinks) add(name string, sink errorSink, enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sinks[name] = &errorSinkEntry{
+		sink: sink,
+		state: errorSinkState{
+			Name:    name,
+			Enabled: enabled,
+		},
+	}
+}
+
+// send will deliver the error to all the enabled sinks, and update
+// the health of each sink.
+func (s *errorSinks) send(er string, ev errorEvent) {
+	s.mu.Lock()
+	entries := []*errorSinkEntry{}
+	for _, entry := range s.sinks {
+		if entry.state.Enabled {
+			entries = append(entries, entry)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, entry := range entries {
+		err := entry.sink.send(er, ev)
+
+		s.mu.Lock()
+		if err != nil {
+			entry.state.Dropped++
+			entry.state.LastError = err.Error()
+		} else {
+			entry.state.Sent++
+			entry.state.LastSuccess = time.Now()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// list will return the state of all the sinks sorted by name.
+func (s *errorSinks) list() []errorSinkState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := []errorSinkState{}
+	for _, entry := range s.sinks {
+		states = append(states, entry.state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+
+	return states
+}
+
+// manage will do the action on the sink with the name given, and
+// return the new state of the sink. The actions are enable, disable,
+// and setURL which takes the new URL as the value.
+func (s *errorSinks) manage(name string, action string, value string) (errorSinkState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.sinks[name]
+	if !ok {
+		return errorSinkState{}, fmt.Errorf("error: no error sink named %v", name)
+	}
+
+	switch action {
+	case "enable":
+		entry.state.Enabled = true
+	case "disable":
+		entry.state.Enabled = false
+	case "setURL":
+		w, ok := entry.sink.(*webhookErrorSink)
+		if !ok {
+			return entry.state, fmt.Errorf("error: error sink %v does not use an URL", name)
+		}
+		w.setURL(value)
+		entry.state.URL = value
+	default:
+		return entry.state, fmt.Errorf("error: unknown error sink action: %v", action)
+	}
+
+	return entry.state, nil
+}
+
+// ---
+
+// centralErrorSink will send the errors to the central error logger.
+type centralErrorSink struct {
+	errorKernel *errorKernel
+}
+
+func (c centralErrorSink) send(er string, ev errorEvent) error {
+	sam := subjectAndMessage{
+		Subject: newSubject(REQErrorLog, "errorCentral"),
+		Message: Message{
+			Directory:     "errorLog",
+			ToNode:        "errorCentral",
+			FromNode:      ev.process.node,
+			FileName:      "error.log",
+			Data:          []byte(er),
+			Method:        REQErrorLog,
+			ACKTimeout:    ev.process.configuration.ErrorMessageTimeout,
+			Retries:       ev.process.configuration.ErrorMessageRetries,
+			CorrelationID: ev.message.CorrelationID,
+		},
+	}
+
+	// Put the message on the channel to the ringbuffer.
+	c.errorKernel.ringBufferBulkInCh <- []subjectAndMessage{sam}
+
+	return nil
+}
+
+// consoleErrorSink will print the errors to the log.
+type consoleErrorSink struct {
+	logger logger
+}
+
+func (c consoleErrorSink) send(er string, ev errorEvent) error {
+	level := logLevelError
+	if ev.errorType == errTypeSendInfo {
+		level = logLevelInfo
+	}
+	c.logger.logf(level, procLogFields(ev.process, ev.message), "%v\n", er)
+
+	return nil
+}
+
+// webhookErrorSink will post the errors to a http webhook.
+type webhookErrorSink struct {
+	url    string
+	client http.Client
+	mu     sync.Mutex
+}
+
+func newWebhookErrorSink(url string) *webhookErrorSink {
+	w := webhookErrorSink{
+		url: url,
+		client: http.Client{
+			Timeout: time.Second * 5,
+		},
+	}
+
+	return &w
+}
+
+func (w *webhookErrorSink) setURL(url string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.url = url
+}
+
+func (w *webhookErrorSink) send(er string, ev errorEvent) error {
+	w.mu.Lock()
+	url := w.url
+	w.mu.Unlock()
+
+	if url == "" {
+		return fmt.Errorf("error: no webhook url set")
+	}
+
+	resp, err := w.client.Post(url, "text/plain", bytes.NewBufferString(er))
+	if err != nil {
+		return fmt.Errorf("error: webhook post failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("error: webhook returned status %v", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -10,7 +10,6 @@ package steward
 import (
 	"context"
 	"fmt"
-	"log"
 	"time"
 )
 
@@ -27,22 +26,51 @@ type errorKernel struct {
 	// testCh is used within REQTest for receving data for tests.
 	testCh chan []byte
 
+	// sinks are the destinations where errors are forwarded.
+	sinks *errorSinks
+	// aggregator coalesces the identical errors within the window set
+	// with the errorAggregateWindow flag. Nil if not enabled.
+	aggregator *errorAggregator
+	// ringBufferBulkInCh is the channel to the ringbuffer, set when
+	// the error kernel is started.
+	ringBufferBulkInCh chan<- []subjectAndMessage
+
+	// logger is where the errors are logged to the console, the same
+	// logger as the server.
+	logger logger
+
 	ctx     context.Context
 	cancel  context.CancelFunc
 	metrics *metrics
 }
 
 // newErrorKernel will initialize and return a new error kernel
-func newErrorKernel(ctx context.Context, m *metrics) *errorKernel {
+func newErrorKernel(ctx context.Context, m *metrics, c *Configuration, l logger) *errorKernel {
 	ctxC, cancel := context.WithCancel(ctx)
 
-	return &errorKernel{
+	e := errorKernel{
 		errorCh: make(chan errorEvent, 2),
 		testCh:  make(chan []byte),
 		ctx:     ctxC,
 		cancel:  cancel,
 		metrics: m,
+		logger:  l,
 	}
+	e.sinks = newErrorSinks(&e, c)
+
+	if c.ErrorAggregateWindow > 0 {
+		e.aggregator = newErrorAggregator(time.Second*time.Duration(c.ErrorAggregateWindow), func(ev errorEvent) {
+			e.sendErrorOrInfo(ev)
+			switch ev.errorType {
+			case errTypeSendInfo:
+				e.metrics.promInfoMessagesSentTotal.Inc()
+			default:
+				e.metrics.promErrorMessagesSentTotal.Inc()
+			}
+		})
+	}
+
+	return &e
 }
 
 // startErrorKernel will start the error kernel and check if there
@@ -56,8 +84,7 @@ func newErrorKernel(ctx context.Context, m *metrics) *errorKernel {
 // the error where. This should be right after sending the error
 // sending in the process.
 func (e *errorKernel) start(ringBufferBulkInCh chan<- []subjectAndMessage) error {
-	// NOTE: For now it will just print the error messages to the
-	// console.
+	e.ringBufferBulkInCh = ringBufferBulkInCh
 
 	for {
 		var errEvent errorEvent
@@ -67,36 +94,10 @@ func (e *errorKernel) start(ringBufferBulkInCh chan<- []subjectAndMessage) error
 			return fmt.Errorf("info: stopping errorKernel")
 		}
 
-		sendErrorOrInfo := func(errEvent errorEvent) {
-			var er string
-			// Decide what extra information to add to the error message.
-			switch {
-			case errEvent.message.RelayFromNode != "":
-				er = fmt.Sprintf("%v, node: %v, relayFromNode: %v, %v\n", time.Now().Format("Mon Jan _2 15:04:05 2006"), errEvent.process.node, errEvent.message.RelayFromNode, errEvent.err)
-			default:
-				er = fmt.Sprintf("%v, node: %v, %v\n", time.Now().Format("Mon Jan _2 15:04:05 2006"), errEvent.process.node, errEvent.err)
-			}
-
-			sam := subjectAndMessage{
-				Subject: newSubject(REQErrorLog, "errorCentral"),
-				Message: Message{
-					Directory:  "errorLog",
-					ToNode:     "errorCentral",
-					FromNode:   errEvent.process.node,
-					FileName:   "error.log",
-					Data:       []byte(er),
-					Method:     REQErrorLog,
-					ACKTimeout: errEvent.process.configuration.ErrorMessageTimeout,
-					Retries:    errEvent.process.configuration.ErrorMessageRetries,
-				},
-			}
-
-			// Put the message on the channel to the ringbuffer.
-			ringBufferBulkInCh <- []subjectAndMessage{sam}
-
-			if errEvent.process.configuration.EnableDebug {
-				log.Printf("%v\n", er)
-			}
+		// The identical errors within the aggregate window are only
+		// counted, and sent with the count when the window closes.
+		if e.aggregator != nil && errEvent.errorType != errTypeWithAction && !e.aggregator.first(errEvent) {
+			continue
 		}
 
 		// Check the type of the error to decide what to do.
@@ -115,7 +116,7 @@ func (e *errorKernel) start(ringBufferBulkInCh chan<- []subjectAndMessage) error
 			// to the errorCentral log server.
 
 			go func() {
-				sendErrorOrInfo(errEvent)
+				e.sendErrorOrInfo(errEvent)
 				e.metrics.promErrorMessagesSentTotal.Inc()
 			}()
 
@@ -124,7 +125,7 @@ func (e *errorKernel) start(ringBufferBulkInCh chan<- []subjectAndMessage) error
 			// to the errorCentral log server.
 
 			go func() {
-				sendErrorOrInfo(errEvent)
+				e.sendErrorOrInfo(errEvent)
 				e.metrics.promInfoMessagesSentTotal.Inc()
 			}()
 
@@ -134,7 +135,7 @@ func (e *errorKernel) start(ringBufferBulkInCh chan<- []subjectAndMessage) error
 			// an errActionContinue message.
 
 			go func() {
-				log.Printf("TESTING, we received and error from the process, but we're telling the process back to continue\n")
+				e.logger.logf(logLevelInfo, procLogFields(errEvent.process, errEvent.message), "TESTING, we received and error from the process, but we're telling the process back to continue\n")
 
 				// Send a message back to where the errWithAction function
 				// was called on the errorActionCh so the caller can decide
@@ -142,7 +143,7 @@ func (e *errorKernel) start(ringBufferBulkInCh chan<- []subjectAndMessage) error
 				select {
 				case errEvent.errorActionCh <- errActionContinue:
 				case <-e.ctx.Done():
-					log.Printf("info: errorKernel: got ctx.Done, will stop waiting for errAction\n")
+					e.logger.logf(logLevelInfo, logFields{}, "info: errorKernel: got ctx.Done, will stop waiting for errAction\n")
 					return
 				}
 
@@ -156,6 +157,26 @@ func (e *errorKernel) start(ringBufferBulkInCh chan<- []subjectAndMessage) error
 	}
 }
 
+// sendErrorOrInfo will add the time and the node to the error, and
+// forward it to all the enabled sinks.
+func (e *errorKernel) sendErrorOrInfo(errEvent errorEvent) {
+	var er string
+	// Decide what extra information to add to the error message.
+	switch {
+	case errEvent.message.RelayFromNode != "":
+		er = fmt.Sprintf("%v, node: %v, relayFromNode: %v, ", time.Now().Format("Mon Jan _2 15:04:05 2006"), errEvent.process.node, errEvent.message.RelayFromNode)
+	default:
+		er = fmt.Sprintf("%v, node: %v, ", time.Now().Format("Mon Jan _2 15:04:05 2006"), errEvent.process.node)
+	}
+	if errEvent.message.CorrelationID != "" {
+		er += fmt.Sprintf("correlationID: %v, ", errEvent.message.CorrelationID)
+	}
+	er += fmt.Sprintf("%v\n", errEvent.err)
+
+	// Forward the error to all the enabled sinks.
+	e.sinks.send(er, errEvent)
+}
+
 func (e *errorKernel) stop() {
 	e.cancel()
 }
@@ -192,7 +213,7 @@ func (e *errorKernel) infoSend(proc process, msg Message, err error) {
 
 func (e *errorKernel) logConsoleOnlyIfDebug(err error, c *Configuration) {
 	if c.EnableDebug {
-		log.Printf("%v\n", err)
+		e.logger.logf(logLevelDebug, logFields{}, "%v\n", err)
 	}
 }
 
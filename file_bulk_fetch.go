@@ -0,0 +1,115 @@
+package steward
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// The max total size of the files fetched with REQBulkFileFetch.
+	bulkFileFetchMaxBytes = 10 * 1024 * 1024
+	// The name of the entry in the archive listing the files that could
+	// not be fetched.
+	bulkFileFetchErrorsEntry = "_errors.txt"
+)
+
+// bulkFileFetch will read the files given, and return them as a gzipped
+// tar archive. All the files must be within the allowed roots. Files
+// that are missing, unreadable, outside the allowed roots, or that would
+// take the total size above maxBytes are not added, and the reason is
+// instead listed in the errors entry of the archive.
+func bulkFileFetch(allowedRoots string, paths []string, maxBytes int64) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	fileErrors := []string{}
+	var total int64
+
+	for _, path := range paths {
+		err := func() error {
+			fp, err := checkAllowedFileRoot(allowedRoots, path)
+			if err != nil {
+				return err
+			}
+
+			// A compressed stored reply file is decompressed while read.
+			fh, fi, err := openStoredFile(fp)
+			if err != nil {
+				return fmt.Errorf("failed to open file: %v", err)
+			}
+			defer fh.Close()
+
+			if !fi.Mode().IsRegular() {
+				return fmt.Errorf("not a regular file")
+			}
+			if total+fi.Size() > maxBytes {
+				return fmt.Errorf("file size %v would exceed the max total size of %v bytes", fi.Size(), maxBytes)
+			}
+
+			// Read the whole file before writing the header, so a read error
+			// will not leave a broken entry in the archive. The size of a
+			// decompressed file is only known after reading it.
+			b, err := io.ReadAll(io.LimitReader(fh, maxBytes-total+1))
+			if err != nil {
+				return fmt.Errorf("failed to read file: %v", err)
+			}
+			if total+int64(len(b)) > maxBytes {
+				return fmt.Errorf("file size %v would exceed the max total size of %v bytes", len(b), maxBytes)
+			}
+
+			hdr := tar.Header{
+				Name:    strings.TrimPrefix(fp, "/"),
+				Mode:    int64(fi.Mode().Perm()),
+				Size:    int64(len(b)),
+				ModTime: fi.ModTime(),
+			}
+			err = tw.WriteHeader(&hdr)
+			if err != nil {
+				return fmt.Errorf("failed to write tar header: %v", err)
+			}
+			_, err = tw.Write(b)
+			if err != nil {
+				return fmt.Errorf("failed to write to tar: %v", err)
+			}
+
+			total += int64(len(b))
+			return nil
+		}()
+		if err != nil {
+			fileErrors = append(fileErrors, fmt.Sprintf("%v: %v\n", path, err))
+		}
+	}
+
+	if len(fileErrors) > 0 {
+		b := []byte(strings.Join(fileErrors, ""))
+		hdr := tar.Header{
+			Name: bulkFileFetchErrorsEntry,
+			Mode: 0600,
+			Size: int64(len(b)),
+		}
+		err := tw.WriteHeader(&hdr)
+		if err != nil {
+			return nil, fmt.Errorf("error: bulkFileFetch: failed to write tar header: %v", err)
+		}
+		_, err = tw.Write(b)
+		if err != nil {
+			return nil, fmt.Errorf("error: bulkFileFetch: failed to write to tar: %v", err)
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error: bulkFileFetch: failed to close tar: %v", err)
+	}
+	err = gz.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error: bulkFileFetch: failed to close gzip: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
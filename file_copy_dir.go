@@ -0,0 +1,265 @@
+package steward
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	// The max size of the content of a file sent in one REQCopyDirTo
+	// message, kept below the default max message size of nats which
+	// is 1MB.
+	copyDirChunkSize = 500000
+
+	// The kinds of entries sent with REQCopyDirTo.
+	copyDirKindDir  = "dir"
+	copyDirKindFile = "file"
+)
+
+// copyDirReport is the summary replied back by REQCopyDirFrom.
+type copyDirReport struct {
+	// The number of files and directories sent to the destination node.
+	Files       int `json:"files"`
+	Directories int `json:"directories"`
+	// The total size of the files sent.
+	Bytes int64 `json:"bytes"`
+	// The symlinks found, which are not copied.
+	SkippedSymlinks []string `json:"skippedSymlinks"`
+}
+
+// copyDirEntry is a directory, or a chunk of a file, to be created on
+// the destination node with REQCopyDirTo.
+type copyDirEntry struct {
+	kind    string
+	dstPath string
+	mode    fs.FileMode
+	// The offset of the chunk within the file, and the total size of
+	// the file.
+	offset int64
+	size   int64
+	data   []byte
+}
+
+// methodArgs will return the methodArgs for the REQCopyDirTo message of
+// the entry.
+func (e copyDirEntry) methodArgs() []string {
+	return []string{
+		e.kind,
+		e.dstPath,
+		strconv.FormatUint(uint64(e.mode.Perm()), 8),
+		strconv.FormatInt(e.offset, 10),
+		strconv.FormatInt(e.size, 10),
+	}
+}
+
+// parseCopyDirEntry will parse the methodArgs and data of a REQCopyDirTo
+// message into an entry.
+func parseCopyDirEntry(methodArgs []string, data []byte) (copyDirEntry, error) {
+	if len(methodArgs) < 5 {
+		return copyDirEntry{}, fmt.Errorf("got <5 number methodArgs, want kind, dstPath, mode, offset and size")
+	}
+
+	e := copyDirEntry{
+		kind:    methodArgs[0],
+		dstPath: methodArgs[1],
+		data:    data,
+	}
+
+	mode, err := strconv.ParseUint(methodArgs[2], 8, 32)
+	if err != nil {
+		return copyDirEntry{}, fmt.Errorf("invalid mode %v: %v", methodArgs[2], err)
+	}
+	e.mode = fs.FileMode(mode).Perm()
+
+	e.offset, err = strconv.ParseInt(methodArgs[3], 10, 64)
+	if err != nil || e.offset < 0 {
+		return copyDirEntry{}, fmt.Errorf("invalid offset %v", methodArgs[3])
+	}
+	e.size, err = strconv.ParseInt(methodArgs[4], 10, 64)
+	if err != nil || e.size < 0 {
+		return copyDirEntry{}, fmt.Errorf("invalid size %v", methodArgs[4])
+	}
+
+	switch {
+	case e.kind != copyDirKindDir && e.kind != copyDirKindFile:
+		return copyDirEntry{}, fmt.Errorf("unknown kind %v", e.kind)
+	case e.dstPath == "":
+		return copyDirEntry{}, fmt.Errorf("no destination path given")
+	case e.offset+int64(len(e.data)) > e.size:
+		return copyDirEntry{}, fmt.Errorf("chunk at offset %v with length %v is outside of the file size %v", e.offset, len(e.data), e.size)
+	}
+	if err := checkCleanPath(e.dstPath); err != nil {
+		return copyDirEntry{}, fmt.Errorf("invalid destination path: %v", err)
+	}
+
+	return e, nil
+}
+
+// walkCopyDir will walk the source directory, and call send for each
+// directory and each chunk of the files found, with the destination
+// path set relative to dstDir. Symlinks are not followed, and are
+// listed as skipped in the report. The walk is aborted if the context
+// is done.
+func walkCopyDir(ctx context.Context, srcDir string, dstDir string, send func(copyDirEntry) error) (copyDirReport, error) {
+	r := copyDirReport{
+		SkippedSymlinks: []string{},
+	}
+
+	fi, err := os.Stat(srcDir)
+	if err != nil {
+		return r, fmt.Errorf("failed to stat src directory: %v", err)
+	}
+	if !fi.IsDir() {
+		return r, fmt.Errorf("src %v is not a directory", srcDir)
+	}
+
+	err = filepath.WalkDir(srcDir, func(path string, de fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dstDir, rel)
+
+		switch {
+		case de.Type()&fs.ModeSymlink != 0:
+			r.SkippedSymlinks = append(r.SkippedSymlinks, rel)
+			return nil
+		case de.IsDir():
+			fi, err := de.Info()
+			if err != nil {
+				return err
+			}
+			err = send(copyDirEntry{kind: copyDirKindDir, dstPath: dstPath, mode: fi.Mode()})
+			if err != nil {
+				return err
+			}
+			r.Directories++
+			return nil
+		case !de.Type().IsRegular():
+			// Devices, sockets and pipes are not copied.
+			return nil
+		}
+
+		n, err := sendCopyDirFile(ctx, path, dstPath, send)
+		if err != nil {
+			return err
+		}
+		r.Files++
+		r.Bytes += n
+
+		return nil
+	})
+
+	return r, err
+}
+
+// sendCopyDirFile will read the file at path, and call send for each
+// chunk of the file. An empty file is sent as one empty chunk. The size
+// of the file is returned.
+func sendCopyDirFile(ctx context.Context, path string, dstPath string, send func(copyDirEntry) error) (int64, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer fh.Close()
+
+	fi, err := fh.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file: %v", err)
+	}
+
+	var offset int64
+	buf := make([]byte, copyDirChunkSize)
+	for {
+		if ctx.Err() != nil {
+			return offset, ctx.Err()
+		}
+
+		n, err := io.ReadFull(fh, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return offset, fmt.Errorf("failed to read file %v: %v", path, err)
+		}
+
+		// Send the first chunk also for empty files, so they are created.
+		if n > 0 || offset == 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+
+			e := copyDirEntry{
+				kind:    copyDirKindFile,
+				dstPath: dstPath,
+				mode:    fi.Mode(),
+				offset:  offset,
+				size:    fi.Size(),
+				data:    data,
+			}
+			// The file might have grown since we got the size.
+			if e.offset+int64(n) > e.size {
+				e.size = e.offset + int64(n)
+			}
+
+			if err := send(e); err != nil {
+				return offset, err
+			}
+			offset += int64(n)
+		}
+
+		if err != nil || offset >= fi.Size() {
+			return offset, nil
+		}
+	}
+}
+
+// writeCopyDirEntry will create the directory, or write the chunk of
+// the file, of the entry. The chunks of a file can be written in any
+// order, since each chunk is written at its offset, and the file is
+// truncated to the size of the source file.
+func writeCopyDirEntry(e copyDirEntry, dirMode fs.FileMode) error {
+	if e.kind == copyDirKindDir {
+		err := os.MkdirAll(e.dstPath, e.mode)
+		if err != nil {
+			return fmt.Errorf("failed to create directory %v: %v", e.dstPath, err)
+		}
+		return os.Chmod(e.dstPath, e.mode)
+	}
+
+	// The directory might not have been created yet if the messages
+	// arrived out of order.
+	_, err := createFolderTree(filepath.Dir(e.dstPath), dirMode)
+	if err != nil {
+		return fmt.Errorf("failed to create directory for %v: %v", e.dstPath, err)
+	}
+
+	f, err := os.OpenFile(e.dstPath, os.O_CREATE|os.O_WRONLY, e.mode)
+	if err != nil {
+		return fmt.Errorf("failed to open file %v: %v", e.dstPath, err)
+	}
+	defer f.Close()
+
+	err = f.Truncate(e.size)
+	if err != nil {
+		return fmt.Errorf("failed to truncate file %v: %v", e.dstPath, err)
+	}
+	_, err = f.WriteAt(e.data, e.offset)
+	if err != nil {
+		return fmt.Errorf("failed to write to file %v: %v", e.dstPath, err)
+	}
+	err = f.Chmod(e.mode)
+	if err != nil {
+		return fmt.Errorf("failed to set mode of file %v: %v", e.dstPath, err)
+	}
+
+	return f.Sync()
+}
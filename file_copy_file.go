@@ -0,0 +1,202 @@
+package steward
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+const (
+	// The max size of the content of a file sent in one REQCopyFileTo
+	// message, kept below the default max message size of nats which
+	// is 1MB.
+	copyFileChunkSize = 500000
+
+	// The suffix of the file the chunks are written to on the
+	// destination node, until all the chunks are received and it is
+	// renamed to the destination path.
+	copyFilePartialSuffix = ".partial"
+)
+
+// copyFileChunk is a chunk of a file sent with REQCopyFileTo. The
+// destination node asks the source node for the next chunk with
+// REQCopyFileFrom when a chunk is written, so the chunks are written in
+// order and the size of the partial file is the offset to resume from.
+type copyFileChunk struct {
+	srcPath string
+	dstNode Node
+	dstPath string
+	srcNode Node
+	offset  int64
+	// done is true for the last chunk of the file.
+	done bool
+	data []byte
+}
+
+// methodArgs will return the methodArgs for the REQCopyFileTo message of
+// the chunk.
+func (c copyFileChunk) methodArgs() []string {
+	return []string{
+		c.srcPath,
+		string(c.dstNode),
+		c.dstPath,
+		string(c.srcNode),
+		strconv.FormatInt(c.offset, 10),
+		strconv.FormatBool(c.done),
+	}
+}
+
+// parseCopyFileChunk will parse the methodArgs and data of a
+// REQCopyFileTo message into a chunk. A message with only the 3 first
+// methodArgs holds the whole file.
+func parseCopyFileChunk(methodArgs []string, data []byte) (copyFileChunk, error) {
+	if len(methodArgs) < 3 {
+		return copyFileChunk{}, fmt.Errorf("got <3 number methodArgs: want srcfilePath,dstNode,dstFilePath")
+	}
+
+	c := copyFileChunk{
+		srcPath: methodArgs[0],
+		dstNode: Node(methodArgs[1]),
+		dstPath: methodArgs[2],
+		done:    true,
+		data:    data,
+	}
+	if err := checkCleanPath(c.dstPath); err != nil {
+		return copyFileChunk{}, fmt.Errorf("invalid destination path: %v", err)
+	}
+	if len(methodArgs) < 6 {
+		return c, nil
+	}
+
+	c.srcNode = Node(methodArgs[3])
+
+	var err error
+	c.offset, err = strconv.ParseInt(methodArgs[4], 10, 64)
+	if err != nil || c.offset < 0 {
+		return copyFileChunk{}, fmt.Errorf("invalid offset %v", methodArgs[4])
+	}
+	c.done, err = strconv.ParseBool(methodArgs[5])
+	if err != nil {
+		return copyFileChunk{}, fmt.Errorf("invalid done %v", methodArgs[5])
+	}
+	if !c.done && c.srcNode == "" {
+		return copyFileChunk{}, fmt.Errorf("no source node given to ask for the next chunk")
+	}
+
+	return c, nil
+}
+
+// readCopyFileChunk will read the chunk of the file starting at the
+// offset. done is true if the chunk is the last of the file.
+func readCopyFileChunk(srcPath string, offset int64) (data []byte, done bool, err error) {
+	// A compressed stored reply file is decompressed while read.
+	fh, fi, err := openStoredFile(srcPath)
+	switch {
+	case os.IsNotExist(err):
+		return nil, false, fmt.Errorf("src file not found: %v", srcPath)
+	case err != nil:
+		return nil, false, fmt.Errorf("failed to open file: %v, %v", srcPath, err)
+	}
+	defer fh.Close()
+
+	// The size of a compressed file is not the size of its content, so
+	// the offset can only be checked against the size of a plain file,
+	// which can also be seeked.
+	if f, ok := fh.(*os.File); ok {
+		if offset > fi.Size() {
+			return nil, false, fmt.Errorf("offset %v is beyond the size %v of the file %v", offset, fi.Size(), srcPath)
+		}
+		_, err = f.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, fh, offset)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to go to offset %v in file %v: %v", offset, srcPath, err)
+	}
+
+	// Read one byte more than the chunk to know if it is the last.
+	b, err := io.ReadAll(io.LimitReader(fh, copyFileChunkSize+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read file: %v, %v", srcPath, err)
+	}
+	if len(b) > copyFileChunkSize {
+		return b[:copyFileChunkSize], false, nil
+	}
+
+	return b, true, nil
+}
+
+// writeCopyFileChunk will write the chunk to the partial file of the
+// destination path, which is renamed to the destination path when the
+// last chunk is written. A chunk at offset 0 starts the file over. A
+// chunk before the end of the partial file was already written, and is
+// not written again, so written is false. received is the size of the
+// partial file, which is the offset of the next chunk.
+func writeCopyFileChunk(c copyFileChunk, fileMode fs.FileMode) (received int64, written bool, err error) {
+	partial := c.dstPath + copyFilePartialSuffix
+
+	flag := os.O_WRONLY
+	if c.offset == 0 {
+		flag |= os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(partial, flag, fileMode)
+	if os.IsNotExist(err) {
+		return 0, false, fmt.Errorf("no copy in progress for %v, got chunk at offset %v", c.dstPath, c.offset)
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to open file %v: %v", partial, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to stat file %v: %v", partial, err)
+	}
+	switch {
+	case c.offset > fi.Size():
+		return fi.Size(), false, fmt.Errorf("got chunk at offset %v for %v, but only %v bytes are received, resume the copy to get the missing chunks", c.offset, c.dstPath, fi.Size())
+	case c.offset < fi.Size():
+		return fi.Size(), false, nil
+	}
+
+	_, err = f.WriteAt(c.data, c.offset)
+	if err != nil {
+		return c.offset, false, fmt.Errorf("failed to write to file %v: %v", partial, err)
+	}
+	err = f.Sync()
+	if err != nil {
+		return c.offset, false, fmt.Errorf("failed to sync file %v: %v", partial, err)
+	}
+	received = c.offset + int64(len(c.data))
+
+	if !c.done {
+		return received, true, nil
+	}
+
+	err = f.Chmod(fileMode)
+	if err != nil {
+		return received, true, fmt.Errorf("failed to set mode of file: file: %v, error: %v", partial, err)
+	}
+	err = os.Rename(partial, c.dstPath)
+	if err != nil {
+		return received, true, fmt.Errorf("failed to rename %v to %v: %v", partial, c.dstPath, err)
+	}
+
+	return received, true, nil
+}
+
+// copyFileReceived will return the number of bytes received of the
+// file being copied to dstPath, which is where to resume the copy.
+func copyFileReceived(dstPath string) (int64, error) {
+	fi, err := os.Stat(dstPath + copyFilePartialSuffix)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
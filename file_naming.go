@@ -0,0 +1,118 @@
+package steward
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// replyFolderData is the data given to the ReplyFolderTemplate when the
+// folder for a reply file is created.
+type replyFolderData struct {
+	// The node the reply is from.
+	Node Node
+	// The method of the request the reply is for.
+	Method Method
+	// The date the reply was written, like 2006-01-02.
+	Date string
+	// The ID of the request the reply is for.
+	MessageID int
+	// The directory given in the request.
+	Directory string
+}
+
+// newReplyFolderTemplate will parse the ReplyFolderTemplate of the
+// configuration, and return nil if it is not set. The template is also
+// executed once with example data, so a template referring to variables
+// that do not exist fails at startup and not when the first reply is
+// written.
+func newReplyFolderTemplate(c *Configuration) (*template.Template, error) {
+	if strings.TrimSpace(c.ReplyFolderTemplate) == "" {
+		return nil, nil
+	}
+
+	t, err := template.New("replyFolder").Parse(c.ReplyFolderTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %v", err)
+	}
+
+	_, err = executeReplyFolderTemplate(t, replyFolderData{Node: "node", Method: REQCliCommand, Date: "2006-01-02", Directory: "dir"})
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// executeReplyFolderTemplate will return the folder relative to the
+// subscribers data folder created from the template.
+func executeReplyFolderTemplate(t *template.Template, d replyFolderData) (string, error) {
+	var b bytes.Buffer
+
+	err := t.Execute(&b, d)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute template: %v", err)
+	}
+
+	folder := filepath.Clean(b.String())
+	if folder == "." || filepath.IsAbs(folder) || folder == ".." || strings.HasPrefix(folder, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("template gave the folder %q, want a path within the subscribers data folder", b.String())
+	}
+
+	return folder, nil
+}
+
+// newReplyFolderData will return the data for the ReplyFolderTemplate
+// from the node, the directory and the request the reply is for.
+func newReplyFolderData(node Node, directory string, request Message, now time.Time) replyFolderData {
+	d := replyFolderData{
+		Node:      node,
+		Method:    request.Method,
+		Date:      now.Format("2006-01-02"),
+		MessageID: request.ID,
+		Directory: directory,
+	}
+
+	return d
+}
+
+// dataFolderPath will join the elements to the data folder, and return
+// an error if the path is not within the data folder, like when an
+// element from a message holds "../", or if an element holds a null
+// byte.
+func dataFolderPath(dataFolder string, elem ...string) (string, error) {
+	for _, e := range elem {
+		if strings.ContainsRune(e, 0) {
+			return "", fmt.Errorf("path element %q holds a null byte", e)
+		}
+	}
+
+	p := filepath.Join(append([]string{dataFolder}, elem...)...)
+
+	rel, err := filepath.Rel(filepath.Clean(dataFolder), p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %v is not within the data folder %v", p, dataFolder)
+	}
+
+	return p, nil
+}
+
+// checkCleanPath will return an error if the path holds a null byte or
+// a ".." element, so a path given in a message can't climb out of the
+// folder it appears to be in.
+func checkCleanPath(p string) error {
+	if strings.ContainsRune(p, 0) {
+		return fmt.Errorf("path %q holds a null byte", p)
+	}
+
+	for _, e := range strings.Split(filepath.ToSlash(p), "/") {
+		if e == ".." {
+			return fmt.Errorf("path %v holds a .. element", p)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,419 @@
+package steward
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// checkAllowedFileRoot will check that the path given is within one of
+// the comma separated allowed roots, and return the cleaned absolute
+// path of the file.
+func checkAllowedFileRoot(allowedRoots string, path string) (string, error) {
+	p, err := filepath.Abs(filepath.Clean(path))
+	if err != nil {
+		return "", fmt.Errorf("error: failed to get absolute path of %v: %v", path, err)
+	}
+
+	// If the file exists we also resolve any symlinks, so a link within
+	// an allowed root can't be used to edit a file outside of it.
+	if _, err := os.Lstat(p); err == nil {
+		p, err = filepath.EvalSymlinks(p)
+		if err != nil {
+			return "", fmt.Errorf("error: failed to resolve symlinks of %v: %v", path, err)
+		}
+	}
+
+	for _, root := range strings.Split(allowedRoots, ",") {
+		root = strings.TrimSpace(root)
+		if root == "" {
+			continue
+		}
+
+		r, err := filepath.Abs(filepath.Clean(root))
+		if err != nil {
+			continue
+		}
+		if rr, err := filepath.EvalSymlinks(r); err == nil {
+			r = rr
+		}
+
+		rel, err := filepath.Rel(r, p)
+		if err != nil {
+			continue
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("error: file %v is not within the allowed file roots: %q", path, allowedRoots)
+}
+
+// partialUpdateOp is the kind of operation to do on the lines of a file
+// with a partial update.
+type partialUpdateOp string
+
+const (
+	// Replace all lines matching a regular expression with a new line.
+	// Args: regexp, new line.
+	partialUpdateReplaceLines partialUpdateOp = "replaceLines"
+	// Insert a new line after all lines containing a marker.
+	// Args: marker, new line.
+	partialUpdateInsertAfter partialUpdateOp = "insertAfter"
+	// Set the value of a key within a section of an ini or yaml file.
+	// An empty section will set a key at the top level of the file.
+	// Args: section, key, value.
+	partialUpdateSetKey partialUpdateOp = "setKey"
+)
+
+// applyPartialUpdate will do the operation with the arguments given on
+// the lines, and return the updated lines. The fileName is used to tell
+// if setKey should use the yaml or the ini format.
+func applyPartialUpdate(lines []string, fileName string, op partialUpdateOp, args []string) ([]string, error) {
+	switch op {
+	case partialUpdateReplaceLines:
+		if len(args) < 2 {
+			return nil, fmt.Errorf("error: %v: want regexp and new line as arguments", op)
+		}
+		re, err := regexp.Compile(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("error: %v: failed to compile regexp: %v", op, err)
+		}
+
+		out := make([]string, 0, len(lines))
+		for _, l := range lines {
+			if re.MatchString(l) {
+				out = append(out, args[1])
+				continue
+			}
+			out = append(out, l)
+		}
+		return out, nil
+
+	case partialUpdateInsertAfter:
+		if len(args) < 2 {
+			return nil, fmt.Errorf("error: %v: want marker and new line as arguments", op)
+		}
+
+		out := make([]string, 0, len(lines)+1)
+		for _, l := range lines {
+			out = append(out, l)
+			if strings.Contains(l, args[0]) {
+				out = append(out, args[1])
+			}
+		}
+		return out, nil
+
+	case partialUpdateSetKey:
+		if len(args) < 3 {
+			return nil, fmt.Errorf("error: %v: want section, key and value as arguments", op)
+		}
+
+		switch strings.ToLower(filepath.Ext(fileName)) {
+		case ".yaml", ".yml":
+			return setYamlKey(lines, args[0], args[1], args[2]), nil
+		default:
+			return setIniKey(lines, args[0], args[1], args[2]), nil
+		}
+
+	default:
+		return nil, fmt.Errorf("error: unknown partial update operation: %v", op)
+	}
+}
+
+// setIniKey will set the key to the value within the [section] of the
+// ini lines. If the key do not exist it is added at the end of the
+// section, and if the section do not exist it is added at the end.
+func setIniKey(lines []string, section string, key string, value string) []string {
+	inSection := section == ""
+	sectionFound := section == ""
+	// The index to insert a new key at if it is not found.
+	insertAt := -1
+	if inSection {
+		insertAt = 0
+	}
+
+	for i, l := range lines {
+		t := strings.TrimSpace(l)
+
+		if strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]") {
+			if inSection {
+				break
+			}
+			inSection = strings.TrimSpace(t[1:len(t)-1]) == section
+			if inSection {
+				sectionFound = true
+				insertAt = i + 1
+			}
+			continue
+		}
+
+		if !inSection {
+			continue
+		}
+
+		if t != "" && !strings.HasPrefix(t, "#") && !strings.HasPrefix(t, ";") {
+			insertAt = i + 1
+		}
+
+		k, _, ok := strings.Cut(t, "=")
+		if ok && strings.TrimSpace(k) == key {
+			// Keep the style of the existing line with or without
+			// spaces around the equal sign.
+			sep := "="
+			if strings.Contains(l, " = ") {
+				sep = " = "
+			}
+			indent := l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+
+			out := append([]string{}, lines...)
+			out[i] = indent + key + sep + value
+			return out
+		}
+	}
+
+	if !sectionFound {
+		out := append([]string{}, lines...)
+		if len(out) > 0 && strings.TrimSpace(out[len(out)-1]) != "" {
+			out = append(out, "")
+		}
+		return append(out, "["+section+"]", key+"="+value)
+	}
+
+	out := make([]string, 0, len(lines)+1)
+	out = append(out, lines[:insertAt]...)
+	out = append(out, key+"="+value)
+	out = append(out, lines[insertAt:]...)
+	return out
+}
+
+// setYamlKey will set the key to the value within the top level section
+// of the yaml lines. If the key do not exist it is added at the end of
+// the section, and if the section do not exist it is added at the end.
+// Only simple key: value lines are handled, and not nested sections.
+func setYamlKey(lines []string, section string, key string, value string) []string {
+	indentOf := func(l string) string {
+		return l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+	}
+
+	inSection := section == ""
+	sectionFound := section == ""
+	keyIndent := ""
+	insertAt := -1
+	if inSection {
+		insertAt = 0
+	}
+
+	for i, l := range lines {
+		t := strings.TrimSpace(l)
+		if t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+		indent := indentOf(l)
+
+		if section != "" {
+			// A top level line starts a new section.
+			if indent == "" {
+				if inSection {
+					break
+				}
+				inSection = t == section+":"
+				if inSection {
+					sectionFound = true
+					insertAt = i + 1
+				}
+				continue
+			}
+			if !inSection {
+				continue
+			}
+			if keyIndent == "" {
+				keyIndent = indent
+			}
+			// Skip lines nested deeper than the keys of the section.
+			if indent != keyIndent {
+				insertAt = i + 1
+				continue
+			}
+		} else if indent != "" {
+			insertAt = i + 1
+			continue
+		}
+
+		insertAt = i + 1
+
+		k, _, ok := strings.Cut(t, ":")
+		if ok && strings.TrimSpace(k) == key {
+			out := append([]string{}, lines...)
+			out[i] = indent + key + ": " + value
+			return out
+		}
+	}
+
+	if section != "" && keyIndent == "" {
+		keyIndent = "  "
+	}
+
+	if !sectionFound {
+		out := append([]string{}, lines...)
+		return append(out, section+":", keyIndent+key+": "+value)
+	}
+
+	out := make([]string, 0, len(lines)+1)
+	out = append(out, lines[:insertAt]...)
+	out = append(out, keyIndent+key+": "+value)
+	out = append(out, lines[insertAt:]...)
+	return out
+}
+
+// lineDiffMaxCells is the max size of the table used to find the
+// longest common subsequence of the changed lines. If the changed lines
+// would need a bigger table they are all shown as removed and added,
+// so a large file with many changes can't use up the memory.
+const lineDiffMaxCells = 1000000
+
+// lineDiff will return a simple diff of the lines, where removed lines
+// are prefixed with "-", and added lines with "+". Each change is
+// preceded by a "@@ line n" header with the line number in the old lines.
+func lineDiff(old []string, new []string) string {
+	// The lines equal at the start and the end are not part of the
+	// change, so we only diff the lines between them.
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+	oldChanged := old[prefix : len(old)-suffix]
+	newChanged := new[prefix : len(new)-suffix]
+
+	if len(oldChanged) == 0 && len(newChanged) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	if (len(oldChanged)+1)*(len(newChanged)+1) > lineDiffMaxCells {
+		fmt.Fprintf(&sb, "@@ line %v\n", prefix+1)
+		for _, l := range oldChanged {
+			fmt.Fprintf(&sb, "-%v\n", l)
+		}
+		for _, l := range newChanged {
+			fmt.Fprintf(&sb, "+%v\n", l)
+		}
+		return sb.String()
+	}
+
+	old, new = oldChanged, newChanged
+
+	// Find the longest common subsequence of the lines.
+	lcs := make([][]int, len(old)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(new)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			switch {
+			case old[i] == new[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	inChange := false
+	i, j := 0, 0
+	for i < len(old) || j < len(new) {
+		switch {
+		case i < len(old) && j < len(new) && old[i] == new[j]:
+			inChange = false
+			i++
+			j++
+			continue
+		case !inChange:
+			fmt.Fprintf(&sb, "@@ line %v\n", prefix+i+1)
+			inChange = true
+		}
+
+		if i < len(old) && (j == len(new) || lcs[i+1][j] >= lcs[i][j+1]) {
+			fmt.Fprintf(&sb, "-%v\n", old[i])
+			i++
+		} else {
+			fmt.Fprintf(&sb, "+%v\n", new[j])
+			j++
+		}
+	}
+
+	return sb.String()
+}
+
+// partialUpdateFile will do the operation on the file at the path given.
+// A backup of the original file is written next to it before the file is
+// atomically replaced with the updated content. The diff between the
+// original and the updated content is returned.
+func partialUpdateFile(path string, op partialUpdateOp, args []string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("error: failed to stat file: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error: failed to read file: %v", err)
+	}
+
+	// Keep track of a trailing newline, so we write the file back the
+	// same way.
+	var lines []string
+	if len(b) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+	trailingNewline := len(b) == 0 || strings.HasSuffix(string(b), "\n")
+
+	newLines, err := applyPartialUpdate(lines, filepath.Base(path), op, args)
+	if err != nil {
+		return "", err
+	}
+
+	diff := lineDiff(lines, newLines)
+	if diff == "" {
+		return "", nil
+	}
+
+	newContent := strings.Join(newLines, "\n")
+	if trailingNewline {
+		newContent += "\n"
+	}
+
+	// The backup is created with O_EXCL, so an existing backup is never
+	// overwritten if two updates are done at the same time.
+	backup := fmt.Sprintf("%v.%v.bak", path, time.Now().Format("20060102-150405.000000000"))
+	bfh, err := os.OpenFile(backup, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return "", fmt.Errorf("error: failed to create backup file: %v", err)
+	}
+	_, err = bfh.Write(b)
+	if err != nil {
+		bfh.Close()
+		return "", fmt.Errorf("error: failed to write backup file: %v", err)
+	}
+	err = bfh.Close()
+	if err != nil {
+		return "", fmt.Errorf("error: failed to write backup file: %v", err)
+	}
+
+	err = writeFileAtomic(path, []byte(newContent), fi.Mode().Perm())
+	if err != nil {
+		return "", fmt.Errorf("error: %v", err)
+	}
+
+	return diff, nil
+}
@@ -0,0 +1,85 @@
+package steward
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// parseFileMode will parse a mode given in octal, like "0644".
+func parseFileMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("error: mode is not a valid octal permission: %v", s)
+	}
+
+	return os.FileMode(m), nil
+}
+
+// selectFileModes will return the modes to use for the files and the
+// directories written by the file handlers. The modes set in the message,
+// or in the request message if this is a reply, takes precedence. Then
+// the defaults of the node are used, and if they are not set either the
+// file mode given as the handlers own default and 0700 are used.
+func selectFileModes(message Message, proc process, handlerFileMode os.FileMode) (os.FileMode, os.FileMode, error) {
+	fileMode := proc.configuration.DefaultFileMode
+	dirMode := proc.configuration.DefaultDirMode
+
+	// The modes of the request message are used for the reply, since the
+	// file handlers are typically used as the reply method.
+	if message.PreviousMessage != nil {
+		if message.PreviousMessage.FileMode != "" {
+			fileMode = message.PreviousMessage.FileMode
+		}
+		if message.PreviousMessage.DirMode != "" {
+			dirMode = message.PreviousMessage.DirMode
+		}
+	}
+	if message.FileMode != "" {
+		fileMode = message.FileMode
+	}
+	if message.DirMode != "" {
+		dirMode = message.DirMode
+	}
+
+	fm := handlerFileMode
+	if fileMode != "" {
+		var err error
+		fm, err = parseFileMode(fileMode)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error: file mode: %v", err)
+		}
+	}
+
+	dm := os.FileMode(0700)
+	if dirMode != "" {
+		var err error
+		dm, err = parseFileMode(dirMode)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error: directory mode: %v", err)
+		}
+	}
+
+	return fm, dm, nil
+}
+
+// createFolderTree will create the directory tree if it does not exist,
+// and set the mode of the last directory. The mode is set explicitly so
+// it is not affected by the umask of the process.
+func createFolderTree(folderTree string, mode os.FileMode) (bool, error) {
+	if _, err := os.Stat(folderTree); !os.IsNotExist(err) {
+		return false, nil
+	}
+
+	err := os.MkdirAll(folderTree, mode)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.Chmod(folderTree, mode)
+	if err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
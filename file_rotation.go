@@ -0,0 +1,130 @@
+package steward
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// fileLock is the lock of a file, and the number of handlers holding or
+// waiting for it.
+type fileLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// fileLocks holds a lock for each file written by the file handlers, by
+// the resolved path of the file, so a file is not appended to while it
+// is rotated.
+type fileLocks struct {
+	locks map[string]*fileLock
+	mu    sync.Mutex
+}
+
+func newFileLocks() *fileLocks {
+	f := fileLocks{
+		locks: make(map[string]*fileLock),
+	}
+
+	return &f
+}
+
+// lock will lock the file with the path given, and return the function
+// to unlock it. The lock is removed when no handlers are using it.
+func (f *fileLocks) lock(path string) (unlock func()) {
+	f.mu.Lock()
+	l, ok := f.locks[path]
+	if !ok {
+		l = &fileLock{}
+		f.locks[path] = l
+	}
+	l.refs++
+	f.mu.Unlock()
+
+	l.mu.Lock()
+
+	return func() {
+		l.mu.Unlock()
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(f.locks, path)
+		}
+	}
+}
+
+// selectFileRotation will return the size in bytes a file appended to
+// is rotated at, and the number of rotated copies to keep. The fields of
+// the request message are used first, then the ones of the message, and
+// else the defaults of the node. A size of 0 disables the rotation.
+func selectFileRotation(message Message, proc process) (size int64, keep int) {
+	size = int64(proc.configuration.ToFileAppendRotateSize)
+	keep = proc.configuration.ToFileAppendRotateKeep
+
+	if message.PreviousMessage != nil {
+		if message.PreviousMessage.RotateSize != 0 {
+			size = int64(message.PreviousMessage.RotateSize)
+		}
+		if message.PreviousMessage.RotateKeep != 0 {
+			keep = message.PreviousMessage.RotateKeep
+		}
+	}
+	if message.RotateSize != 0 {
+		size = int64(message.RotateSize)
+	}
+	if message.RotateKeep != 0 {
+		keep = message.RotateKeep
+	}
+
+	if keep < 1 {
+		keep = 1
+	}
+
+	return size, keep
+}
+
+// rotateFile will rotate the file if writing the number of bytes given
+// would make it larger than the size given. The file is renamed to
+// file.1, the existing file.1 to file.2 and so on, and only the number
+// of rotated copies given are kept. It returns true if the file was
+// rotated. The caller must hold the lock of the file.
+func rotateFile(file string, writeSize int64, size int64, keep int) (bool, error) {
+	if size <= 0 {
+		return false, nil
+	}
+
+	fi, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat file: %v", err)
+	}
+
+	// An empty file is not rotated even if a single write is larger than
+	// the size, since that would only leave empty copies.
+	if fi.Size() == 0 || fi.Size()+writeSize <= size {
+		return false, nil
+	}
+
+	err = os.Remove(fmt.Sprintf("%v.%v", file, keep))
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to remove oldest rotated file: %v", err)
+	}
+
+	for i := keep - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%v.%v", file, i), fmt.Sprintf("%v.%v", file, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("failed to rename rotated file: %v", err)
+		}
+	}
+
+	err = os.Rename(file, file+".1")
+	if err != nil {
+		return false, fmt.Errorf("failed to rename file: %v", err)
+	}
+
+	return true, nil
+}
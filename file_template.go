@@ -0,0 +1,85 @@
+package steward
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// fileTemplateData is the data available within a file template. The
+// variables supplied with the request are found in .Vars, and the name
+// of the node rendering the template in .Node.
+type fileTemplateData struct {
+	Node Node
+	Vars map[string]string
+}
+
+// fileTemplateFuncs are the functions available within a file template.
+// Only functions without side effects are allowed, so rendering a
+// template can't do anything else than producing the content.
+var fileTemplateFuncs = template.FuncMap{
+	"upper":     strings.ToUpper,
+	"lower":     strings.ToLower,
+	"trimSpace": strings.TrimSpace,
+	"replace":   strings.ReplaceAll,
+	"join":      strings.Join,
+	"split":     strings.Split,
+	// default will return the value, or def if the value is empty.
+	"default": func(def string, value string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	},
+}
+
+// fileTemplatePreview is the result of REQFileTemplateRenderPreview.
+type fileTemplatePreview struct {
+	// The rendered content. Empty if the template failed.
+	Rendered string `json:"rendered"`
+	// The error parsing or executing the template, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// parseFileTemplateVars will parse the variables for a file template
+// given as a JSON object with string values. An empty string gives no
+// variables.
+func parseFileTemplateVars(js string) (map[string]string, error) {
+	vars := make(map[string]string)
+	if js == "" {
+		return vars, nil
+	}
+
+	err := json.Unmarshal([]byte(js), &vars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal template variables: %v", err)
+	}
+
+	return vars, nil
+}
+
+// renderFileTemplate will render the file template given with the
+// variables given for the node. Referencing a variable that is not
+// supplied is an error, so a template is never rendered with missing
+// values. This is the rendering used for all templated files, so a
+// preview will always give the same result as when writing the file.
+func renderFileTemplate(tmpl string, vars map[string]string, node Node) ([]byte, error) {
+	t, err := template.New("file").Funcs(fileTemplateFuncs).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %v", err)
+	}
+
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, fileTemplateData{Node: node, Vars: vars})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render template: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,62 @@
+package steward
+
+import (
+	"sort"
+	"time"
+)
+
+// helloNodes holds the time each node last said hello to central, so
+// central can notice the nodes that have stopped saying hello.
+type helloNodes struct {
+	lastSeen map[Node]time.Time
+	// The nodes currently seen as stale, so a node is only reported
+	// once when it becomes stale, and when it is back.
+	stale map[Node]struct{}
+}
+
+func newHelloNodes() *helloNodes {
+	h := helloNodes{
+		lastSeen: make(map[Node]time.Time),
+		stale:    make(map[Node]struct{}),
+	}
+
+	return &h
+}
+
+// seen will record the hello from the node. wasStale is true if the
+// node was stale, and is now back.
+func (h *helloNodes) seen(node Node, now time.Time) (wasStale bool) {
+	h.lastSeen[node] = now
+
+	if _, ok := h.stale[node]; ok {
+		delete(h.stale, node)
+		return true
+	}
+
+	return false
+}
+
+// check will return the nodes that have become stale since the last
+// check, sorted, because their last hello is older than the timeout.
+func (h *helloNodes) check(now time.Time, timeout time.Duration) []Node {
+	var nodes []Node
+
+	for node, last := range h.lastSeen {
+		if _, ok := h.stale[node]; ok {
+			continue
+		}
+		if now.Sub(last) > timeout {
+			h.stale[node] = struct{}{}
+			nodes = append(nodes, node)
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	return nodes
+}
+
+// staleCount will return the number of nodes currently stale.
+func (h *helloNodes) staleCount() int {
+	return len(h.stale)
+}
@@ -0,0 +1,107 @@
+package steward
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// httpListenerAuth holds the optional authentication of the requests to
+// the HTTP listener. A request is accepted if it carries the bearer
+// token, or a client certificate verified with the client CA. If neither
+// is configured all requests are accepted.
+type httpListenerAuth struct {
+	// The bearer token read from HTTPListenerTokenFile, if any.
+	token []byte
+	// The pool of CA certificates to verify the client certificates
+	// with, if any.
+	clientCAs *x509.CertPool
+}
+
+// newHTTPListenerAuth will prepare the authentication from the HTTP
+// listener settings of the configuration.
+func newHTTPListenerAuth(c *Configuration) (*httpListenerAuth, error) {
+	a := httpListenerAuth{}
+
+	if c.HTTPListenerTokenFile != "" {
+		b, err := os.ReadFile(c.HTTPListenerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token file: %v", err)
+		}
+		a.token = []byte(strings.TrimSpace(string(b)))
+		if len(a.token) == 0 {
+			return nil, fmt.Errorf("token file %v is empty", c.HTTPListenerTokenFile)
+		}
+	}
+
+	if c.HTTPListenerClientCAFile != "" {
+		if c.HTTPListenerCertFile == "" || c.HTTPListenerKeyFile == "" {
+			return nil, fmt.Errorf("a client CA is given without the cert and key files needed for TLS")
+		}
+
+		b, err := os.ReadFile(c.HTTPListenerClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %v", err)
+		}
+		a.clientCAs = x509.NewCertPool()
+		if !a.clientCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in client CA file %v", c.HTTPListenerClientCAFile)
+		}
+	}
+
+	return &a, nil
+}
+
+// tlsConfig will return the TLS configuration for the listener, or nil
+// if TLS is not configured.
+func (a *httpListenerAuth) tlsConfig(c *Configuration) (*tls.Config, error) {
+	if c.HTTPListenerCertFile == "" && c.HTTPListenerKeyFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.HTTPListenerCertFile, c.HTTPListenerKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cert and key: %v", err)
+	}
+
+	tc := tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	// The client certificate is verified if given, but not required at
+	// the TLS level, so a request without one can still be accepted by
+	// its token, and rejected requests are logged by the handler.
+	if a.clientCAs != nil {
+		tc.ClientCAs = a.clientCAs
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return &tc, nil
+}
+
+// authorize will check that the request is authenticated, and return the
+// reason if it is not.
+func (a *httpListenerAuth) authorize(r *http.Request) error {
+	if a.token == nil && a.clientCAs == nil {
+		return nil
+	}
+
+	if a.clientCAs != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return nil
+	}
+
+	if a.token != nil {
+		h := r.Header.Get("Authorization")
+		got := strings.TrimPrefix(h, "Bearer ")
+		if got != h && subtle.ConstantTimeCompare([]byte(got), a.token) == 1 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no valid bearer token or client certificate")
+}
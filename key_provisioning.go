@@ -0,0 +1,122 @@
+package steward
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// provisionedKeypair is the result of REQGenerateKeypairFor.
+type provisionedKeypair struct {
+	Node Node `json:"node"`
+	// The public key of the node, which is registered as allowed.
+	PublicKey []byte `json:"publicKey"`
+	// The private key of the node sealed with an anonymous nacl box to
+	// the operator who requested the keypair. It can only be opened with
+	// the private signing key of the operator with openProvisionedKey.
+	EncryptedPrivateKey []byte `json:"encryptedPrivateKey"`
+}
+
+// curve25519P is the prime 2^255 - 19 of the field used by both
+// ed25519 and curve25519.
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// ed25519PublicKeyToCurve25519 will convert an ed25519 public key to the
+// curve25519 public key for the same private key, so the signing keys
+// of the nodes can also be used to encrypt to them. The conversion is
+// the birational map u = (1 + y) / (1 - y) from the edwards curve to
+// the montgomery curve.
+func ed25519PublicKeyToCurve25519(pub ed25519.PublicKey) (*[32]byte, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key has length %v, want %v", len(pub), ed25519.PublicKeySize)
+	}
+
+	// The key is y in little endian, with the sign of x in the top bit.
+	le := make([]byte, len(pub))
+	for i := range pub {
+		le[len(pub)-1-i] = pub[i]
+	}
+	le[0] &= 0x7f
+	y := new(big.Int).SetBytes(le)
+
+	one := big.NewInt(1)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, curve25519P)
+	if den.Sign() == 0 {
+		return nil, fmt.Errorf("public key is not a valid ed25519 key")
+	}
+	den.ModInverse(den, curve25519P)
+
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, den)
+	u.Mod(u, curve25519P)
+
+	var out [32]byte
+	ub := u.Bytes()
+	for i := range ub {
+		out[i] = ub[len(ub)-1-i]
+	}
+
+	return &out, nil
+}
+
+// ed25519PrivateKeyToCurve25519 will convert an ed25519 private key to
+// the curve25519 private key matching ed25519PublicKeyToCurve25519,
+// which is the clamped scalar derived from the seed.
+func ed25519PrivateKeyToCurve25519(priv ed25519.PrivateKey) (*[32]byte, error) {
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("private key has length %v, want %v", len(priv), ed25519.PrivateKeySize)
+	}
+
+	h := sha512.Sum512(priv.Seed())
+	var out [32]byte
+	copy(out[:], h[:32])
+	out[0] &= 248
+	out[31] &= 127
+	out[31] |= 64
+
+	return &out, nil
+}
+
+// sealProvisionedKey will encrypt the private key given so it can only
+// be opened by the owner of the ed25519 public key of the operator.
+func sealProvisionedKey(priv ed25519.PrivateKey, operatorPub ed25519.PublicKey) ([]byte, error) {
+	recipient, err := ed25519PublicKeyToCurve25519(operatorPub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert the public key of the operator: %v", err)
+	}
+
+	sealed, err := box.SealAnonymous(nil, priv, recipient, rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt private key: %v", err)
+	}
+
+	return sealed, nil
+}
+
+// openProvisionedKey will decrypt a private key sealed with
+// sealProvisionedKey with the private signing key of the operator.
+func openProvisionedKey(sealed []byte, operatorPriv ed25519.PrivateKey) (ed25519.PrivateKey, error) {
+	priv, err := ed25519PrivateKeyToCurve25519(operatorPriv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert the private key of the operator: %v", err)
+	}
+	pub, err := ed25519PublicKeyToCurve25519(operatorPriv.Public().(ed25519.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert the public key of the operator: %v", err)
+	}
+
+	key, ok := box.OpenAnonymous(nil, sealed, pub, priv)
+	if !ok {
+		return nil, fmt.Errorf("failed to decrypt private key")
+	}
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("decrypted private key has length %v, want %v", len(key), ed25519.PrivateKeySize)
+	}
+
+	return ed25519.PrivateKey(key), nil
+}
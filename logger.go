@@ -0,0 +1,125 @@
+package steward
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The levels of the log entries.
+type logLevel string
+
+const (
+	logLevelDebug logLevel = "debug"
+	logLevelInfo  logLevel = "info"
+	logLevelError logLevel = "error"
+)
+
+// logFields are the fields describing where a log entry comes from. The
+// fields not known are left empty.
+type logFields struct {
+	Node      Node
+	Subject   string
+	MessageID int
+	Method    Method
+	// CorrelationID of the message, so all the log entries of one
+	// operation can be found.
+	CorrelationID string
+}
+
+// msgLogFields will return the fields of a log entry on the node given
+// for the message given.
+func msgLogFields(node Node, m Message) logFields {
+	return logFields{
+		Node:          node,
+		MessageID:     m.ID,
+		Method:        m.Method,
+		CorrelationID: m.CorrelationID,
+	}
+}
+
+// procLogFields will return the fields of a log entry for the process
+// and the message given.
+func procLogFields(p process, m Message) logFields {
+	f := msgLogFields(p.node, m)
+	f.Subject = string(p.subject.name())
+
+	return f
+}
+
+// logger is where steward writes its log entries.
+type logger interface {
+	logf(level logLevel, f logFields, format string, a ...interface{})
+}
+
+// newLogger will return the logger selected in the configuration, which
+// is the json logger if LogJSON is set, and else the text logger.
+func newLogger(c *Configuration) logger {
+	if c.LogJSON {
+		return newJSONLogger(os.Stderr)
+	}
+
+	return textLogger{}
+}
+
+// textLogger writes the log entries as free form text with the standard
+// logger, which is the default.
+type textLogger struct{}
+
+func (t textLogger) logf(level logLevel, f logFields, format string, a ...interface{}) {
+	log.Printf(format, a...)
+}
+
+// jsonLogger writes each log entry as a json object on its own line,
+// so the logs can be shipped to a log aggregator without parsing the
+// text.
+type jsonLogger struct {
+	enc *json.Encoder
+	mu  sync.Mutex
+}
+
+func newJSONLogger(w io.Writer) *jsonLogger {
+	j := jsonLogger{
+		enc: json.NewEncoder(w),
+	}
+
+	return &j
+}
+
+// jsonLogEntry is a log entry written by the jsonLogger.
+type jsonLogEntry struct {
+	Time          time.Time `json:"time"`
+	Level         logLevel  `json:"level"`
+	Node          Node      `json:"node,omitempty"`
+	Subject       string    `json:"subject,omitempty"`
+	MessageID     int       `json:"messageID,omitempty"`
+	Method        Method    `json:"method,omitempty"`
+	CorrelationID string    `json:"correlationID,omitempty"`
+	Msg           string    `json:"msg"`
+}
+
+func (j *jsonLogger) logf(level logLevel, f logFields, format string, a ...interface{}) {
+	e := jsonLogEntry{
+		Time:          time.Now(),
+		Level:         level,
+		Node:          f.Node,
+		Subject:       f.Subject,
+		MessageID:     f.MessageID,
+		Method:        f.Method,
+		CorrelationID: f.CorrelationID,
+		Msg:           strings.TrimSpace(fmt.Sprintf(format, a...)),
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	err := j.enc.Encode(e)
+	if err != nil {
+		log.Printf("error: jsonLogger: failed to write log entry: %v\n", err)
+	}
+}
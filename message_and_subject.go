@@ -1,9 +1,14 @@
 package steward
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
+	"time"
 )
 
 // --- Message
@@ -17,19 +22,39 @@ type Message struct {
 	ToNodes []Node `json:"toNodes,omitempty" yaml:"toNodes,omitempty"`
 	// The Unique ID of the message
 	ID int `json:"id" yaml:"id"`
+	// CorrelationID ties together all the messages of one operation,
+	// like the request, its replies and the messages sent by its
+	// handler, across nodes. It is set when the message is read if
+	// not given, and copied to the replies and the messages derived
+	// from it.
+	CorrelationID string `json:"correlationID,omitempty" yaml:"correlationID,omitempty"`
 	// The actual data in the message. This is typically where we
 	// specify the cli commands to execute on a node, and this is
 	// also the field where we put the returned data in a reply
 	// message.
 	Data []byte `json:"data" yaml:"data"`
+	// DataBase64 is the data of the message given as base64 text, so
+	// binary data like images can be given in a json or yaml message.
+	// It is decoded into Data when the message is read.
+	DataBase64 string `json:"dataBase64,omitempty" yaml:"dataBase64,omitempty"`
 	// Method, what request type to use, like REQCliCommand, REQHttpGet..
 	Method Method `json:"method" yaml:"method"`
 	// Additional arguments that might be needed when executing the
 	// method. Can be f.ex. an ip address if it is a tcp sender, or the
 	// shell command to execute in a cli session.
 	MethodArgs []string `json:"methodArgs" yaml:"methodArgs"`
-	// ArgSignature is the ed25519 signature of the methodArgs.
+	// ArgSignature is the ed25519 signature of the method, toNode,
+	// signedAt, signNonce and methodArgs of the message.
 	ArgSignature []byte `json:"argSignature" yaml:"argSignature"`
+	// SignedAt is the unix time in seconds when the message was signed.
+	SignedAt int64 `json:"signedAt" yaml:"signedAt"`
+	// SignNonce is a random value set when the message is signed, used
+	// together with SignedAt to detect replayed messages.
+	SignNonce string `json:"signNonce" yaml:"signNonce"`
+	// DeliveryID is set to the first SignNonce when the message is
+	// published, and kept when the message is signed again for a retry,
+	// so the receiver can find a retry as a duplicate.
+	DeliveryID string `json:"deliveryID,omitempty" yaml:"deliveryID,omitempty"`
 	// ReplyMethod, is the method to use for the reply message.
 	// By default the reply method will be set to log to file, but
 	// you can override it setting your own here.
@@ -59,6 +84,13 @@ type Message struct {
 	MethodTimeout int `json:"methodTimeout" yaml:"methodTimeout"`
 	// Timeout for long a process should be allowed to operate
 	ReplyMethodTimeout int `json:"replyMethodTimeout" yaml:"replyMethodTimeout"`
+	// TTL is the number of seconds the message is valid for, counted
+	// from when it is read by the node sending it. ExpiresAt is set
+	// from it when the message is read.
+	TTL int `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	// ExpiresAt is the time in unix nanoseconds after which the message
+	// is dropped instead of being delivered or handled. 0 is never.
+	ExpiresAt int64 `json:"expiresAt,omitempty" yaml:"expiresAt,omitempty"`
 	// Directory is a string that can be used to create the
 	//directory structure when saving the result of some method.
 	// For example "syslog","metrics", or "metrics/mysensor"
@@ -87,12 +119,181 @@ type Message struct {
 	// The method to use when the reply of the relayed message came
 	// back to where originated from.
 	RelayReplyMethod Method `json:"relayReplyMethod" yaml:"relayReplyMethod"`
+	// RelayHops is the number of nodes that have relayed the message.
+	RelayHops int `json:"relayHops,omitempty" yaml:"relayHops,omitempty"`
+	// RelayVisited are the nodes that have relayed the message, in the
+	// order they relayed it, so a message relayed in a loop is found.
+	RelayVisited []Node `json:"relayVisited,omitempty" yaml:"relayVisited,omitempty"`
+	// Metadata are arbitrary key-value pairs of context that travels
+	// with the message, like a change ticket id. The metadata are
+	// copied to the reply messages.
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	// FileMode is the mode in octal, like "0644", for files written by
+	// the file handlers. Overrides the defaultFileMode of the node.
+	FileMode string `json:"fileMode,omitempty" yaml:"fileMode,omitempty"`
+	// DirMode is the mode in octal, like "0755", for directories created
+	// by the file handlers. Overrides the defaultDirMode of the node.
+	DirMode string `json:"dirMode,omitempty" yaml:"dirMode,omitempty"`
+	// Priority is the priority tier of the message, "high", "normal" or
+	// "low". If not set the tier is found from the priority policy of
+	// the node.
+	Priority Priority `json:"priority,omitempty" yaml:"priority,omitempty"`
+	// Compression is the compression to use for the message when it is
+	// published, "zstd", "gzip" or "none". If not set the compression of
+	// the node set with the compression flag is used. The compression
+	// is also used for the reply messages.
+	Compression string `json:"compression,omitempty" yaml:"compression,omitempty"`
+	// MaxOutputBytes is the max number of bytes of output delivered by
+	// REQCliCommandCont before the command is killed. If 0 the default
+	// of the node set with the cliCommandContMaxOutputBytes flag is used.
+	MaxOutputBytes int `json:"maxOutputBytes,omitempty" yaml:"maxOutputBytes,omitempty"`
+	// OutputMode is how the output of REQCliCommandCont is replied,
+	// "line" for a reply per line, or "chunk" for the output as it is
+	// read, in chunks flushed by size or time. Defaults to "line".
+	OutputMode string `json:"outputMode,omitempty" yaml:"outputMode,omitempty"`
+	// DryRun will make REQCliCommand reply with the command as it would
+	// be executed, without executing it.
+	DryRun bool `json:"dryRun,omitempty" yaml:"dryRun,omitempty"`
+	// RotateSize is the size in bytes the file appended to by
+	// REQToFileAppend is rotated at. Overrides the toFileAppendRotateSize
+	// of the node.
+	RotateSize int `json:"rotateSize,omitempty" yaml:"rotateSize,omitempty"`
+	// RotateKeep is the number of rotated copies of the file kept by
+	// REQToFileAppend. Overrides the toFileAppendRotateKeep of the node.
+	RotateKeep int `json:"rotateKeep,omitempty" yaml:"rotateKeep,omitempty"`
+	// FileHeader is written first in the file appended to by
+	// REQToFileAppend when the file is created, like the header line
+	// of a CSV file.
+	FileHeader string `json:"fileHeader,omitempty" yaml:"fileHeader,omitempty"`
+	// Env are the environment variables set for the command run by
+	// REQCliCommand and REQCliCommandCont, merged over the environment
+	// of steward. The values are not printed in the logs.
+	Env commandEnv `json:"env,omitempty" yaml:"env,omitempty"`
+	// WorkDir is the working directory of the command run by
+	// REQCliCommand and REQCliCommandCont. If not set the working
+	// directory of steward is used.
+	WorkDir string `json:"workDir,omitempty" yaml:"workDir,omitempty"`
+	// Stdin is written to the stdin of the command run by REQCliCommand
+	// and REQCliCommandCont, and stdin is closed when all is written.
+	Stdin string `json:"stdin,omitempty" yaml:"stdin,omitempty"`
+	// ConsoleLevel is the level of the data written by REQToConsole,
+	// "info", "warn" or "error". The level sets the color, and warn and
+	// error are written to stderr. Defaults to "info".
+	ConsoleLevel string `json:"consoleLevel,omitempty" yaml:"consoleLevel,omitempty"`
+	// ConsolePrefix is put in front of every line written by
+	// REQToConsole.
+	ConsolePrefix string `json:"consolePrefix,omitempty" yaml:"consolePrefix,omitempty"`
+
+	// PingSentAt is the time in unix nanoseconds a REQPing was sent,
+	// set by the publisher of the node sending it. It is echoed back
+	// with the REQPong, so the round-trip latency can be found.
+	PingSentAt int64 `json:"pingSentAt,omitempty" yaml:"pingSentAt,omitempty"`
 
 	// done is used to signal when a message is fully processed.
 	// This is used for signaling back to the ringbuffer that we are
 	// done with processing a message, and the message can be removed
 	// from the ringbuffer and into the time series log.
 	done chan struct{}
+	// deliveryAttempts is the number of failed delivery attempts done
+	// before the message was resumed from the ringbuffer after a restart.
+	deliveryAttempts int
+	// attemptFailed is called with the number of failed delivery attempts
+	// each time a delivery attempt fails, so the ringbuffer can store it.
+	attemptFailed func(attempts int)
+}
+
+// newCorrelationID will return a new random correlation ID.
+func newCorrelationID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to read random bytes for correlation ID: %v", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// setCorrelationID will give the message a new correlation ID if it
+// does not have one.
+func (s *server) setCorrelationID(m Message) Message {
+	if m.CorrelationID != "" {
+		return m
+	}
+
+	id, err := newCorrelationID()
+	if err != nil {
+		er := fmt.Errorf("error: setCorrelationID: %v", err)
+		s.errorKernel.errSend(s.processInitial, m, er)
+		return m
+	}
+	m.CorrelationID = id
+
+	return m
+}
+
+// setExpiresAt will set the time the message expires from its TTL, if
+// it have a TTL and the expiry is not already set.
+func setExpiresAt(m Message, now time.Time) Message {
+	if m.ExpiresAt != 0 || m.TTL <= 0 {
+		return m
+	}
+
+	m.ExpiresAt = now.Add(time.Second * time.Duration(m.TTL)).UnixNano()
+
+	return m
+}
+
+// messageDeadline will return the time the message expires, relative to
+// now. Since now holds a reading of the monotonic clock, so will the
+// deadline, and comparing it to later readings of time.Now is not
+// affected by changes to the wall clock of the node. ok is false if the
+// message does not expire.
+func messageDeadline(m Message, now time.Time) (deadline time.Time, ok bool) {
+	if m.ExpiresAt == 0 {
+		return time.Time{}, false
+	}
+
+	return now.Add(time.Unix(0, m.ExpiresAt).Sub(now)), true
+}
+
+// messageExpired will return true if the message have expired at now.
+func messageExpired(m Message, now time.Time) bool {
+	return m.ExpiresAt != 0 && !now.Before(time.Unix(0, m.ExpiresAt))
+}
+
+// copyMetadata will return a copy of the metadata given, so the
+// metadata of a new message is not shared with the message it was
+// created from.
+func copyMetadata(md map[string]string) map[string]string {
+	if md == nil {
+		return nil
+	}
+
+	c := make(map[string]string, len(md))
+	for k, v := range md {
+		c[k] = v
+	}
+
+	return c
+}
+
+// commandEnv are the environment variables of a command, by name. The
+// values might hold secrets, so only the names are printed when the
+// message is logged.
+type commandEnv map[string]string
+
+func (e commandEnv) String() string {
+	names := make([]string, 0, len(e))
+	for k := range e {
+		names = append(names, k+"=<redacted>")
+	}
+	sort.Strings(names)
+
+	return "map[" + strings.Join(names, " ") + "]"
+}
+
+func (e commandEnv) GoString() string {
+	return "steward.commandEnv" + e.String()
 }
 
 // --- Subject
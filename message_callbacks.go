@@ -0,0 +1,130 @@
+package steward
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// messageCallbackQueueSize is the number of callback calls that can wait
+// for the worker. When full the calls are dropped, so a slow callback
+// never blocks the handling of the messages.
+const messageCallbackQueueSize = 1000
+
+// messageCallbackFunc is called with a copy of a message handled by the
+// node, and the output of the handler.
+type messageCallbackFunc func(Message, []byte)
+
+// messageCallbackCall is a call of a callback waiting for the worker.
+type messageCallbackCall struct {
+	fn      messageCallbackFunc
+	message Message
+	out     []byte
+}
+
+// messageCallbacks holds the callbacks registered by an application
+// embedding steward, by the method of the messages they are called for.
+type messageCallbacks struct {
+	callbacks map[Method][]messageCallbackFunc
+	callCh    chan messageCallbackCall
+	mu        sync.Mutex
+}
+
+func newMessageCallbacks() *messageCallbacks {
+	c := messageCallbacks{
+		callbacks: make(map[Method][]messageCallbackFunc),
+		callCh:    make(chan messageCallbackCall, messageCallbackQueueSize),
+	}
+
+	return &c
+}
+
+// add will register the callback for the method.
+func (c *messageCallbacks) add(method Method, fn messageCallbackFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.callbacks[method] = append(c.callbacks[method], fn)
+}
+
+// dispatch will queue the calls of the callbacks registered for the
+// method of the message, without waiting for them to be called. Each
+// callback gets its own copy of the message and the output, so a
+// callback can not change what the others or the handlers see. The
+// number of calls dropped because the queue was full is returned.
+func (c *messageCallbacks) dispatch(m Message, out []byte) (dropped int) {
+	c.mu.Lock()
+	fns := c.callbacks[m.Method]
+	c.mu.Unlock()
+
+	for _, fn := range fns {
+		call := messageCallbackCall{
+			fn:      fn,
+			message: copyMessage(m),
+			out:     append([]byte(nil), out...),
+		}
+
+		select {
+		case c.callCh <- call:
+		default:
+			dropped++
+		}
+	}
+
+	return dropped
+}
+
+// run will call the callbacks queued one at a time, until the context
+// is done. A panic in a callback is recovered and given to onPanic, so
+// the worker keeps running.
+func (c *messageCallbacks) run(ctx context.Context, onPanic func(Message, error)) {
+	for {
+		select {
+		case call := <-c.callCh:
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						onPanic(call.message, fmt.Errorf("callback for method %v panicked: %v\n%s", call.message.Method, r, debug.Stack()))
+					}
+				}()
+
+				call.fn(call.message, call.out)
+			}()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// copyMessage will return a copy of the message where the data, the
+// method arguments and the metadata are not shared with the original.
+func copyMessage(m Message) Message {
+	m.Data = append([]byte(nil), m.Data...)
+	m.MethodArgs = append([]string(nil), m.MethodArgs...)
+	m.Metadata = copyMetadata(m.Metadata)
+
+	return m
+}
+
+// RegisterMessageCallback will register a callback that is called each
+// time a message with the method given is handled by the node, with a
+// copy of the message and the output of the handler. The callbacks are
+// called one at a time by a worker, so the handling of the messages is
+// not blocked, and a panic in a callback is recovered and sent as an
+// error to central. If the callbacks can not keep up, the calls are
+// dropped. Note that the output of most handlers is the ACK, so to get
+// the output of a command the callback should be registered for the
+// reply method, like REQToConsole, on the node receiving the reply.
+func (s *server) RegisterMessageCallback(method Method, fn func(Message, []byte)) error {
+	if _, ok := method.GetMethodsAvailable().CheckIfExists(method); !ok {
+		return fmt.Errorf("error: RegisterMessageCallback: no such method: %v", method)
+	}
+	if fn == nil {
+		return fmt.Errorf("error: RegisterMessageCallback: callback for %v can not be nil", method)
+	}
+
+	s.messageCallbacks.add(method, fn)
+
+	return nil
+}
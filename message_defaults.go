@@ -0,0 +1,133 @@
+package steward
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// messageDefaults holds the default values to use for the fields of
+// a message that are not specified when the message enters the system
+// on this node. The defaults are stored in the database folder so
+// they survive a restart of the node.
+type messageDefaults struct {
+	defaults Message
+	filePath string
+	mu       sync.Mutex
+}
+
+func newMessageDefaults(c *Configuration) *messageDefaults {
+	m := messageDefaults{
+		filePath: filepath.Join(c.DatabaseFolder, "message_defaults.txt"),
+	}
+
+	err := m.loadFromFile()
+	if err != nil {
+		log.Printf("error: loading message defaults from file: %v\n", err)
+	}
+
+	return &m
+}
+
+// loadFromFile will try to load the currently stored message defaults
+// from file, and return the error if it fails.
+// If no file is found a nil error is returned.
+func (m *messageDefaults) loadFromFile() error {
+	if _, err := os.Stat(m.filePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	fh, err := os.OpenFile(m.filePath, os.O_RDONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error: failed to open message defaults file: %v", err)
+	}
+	defer fh.Close()
+
+	b, err := io.ReadAll(fh)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err = json.Unmarshal(b, &m.defaults)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// saveToFile will save the message defaults to file for persistent storage.
+// An error is returned if it fails.
+func (m *messageDefaults) saveToFile() error {
+	m.mu.Lock()
+	b, err := json.Marshal(m.defaults)
+	m.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error: failed to marshal message defaults: %v", err)
+	}
+
+	err = os.WriteFile(m.filePath, b, 0600)
+	if err != nil {
+		return fmt.Errorf("error: failed to write message defaults file: %v", err)
+	}
+
+	return nil
+}
+
+// set will replace the current defaults with the defaults given.
+func (m *messageDefaults) set(d Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.defaults = d
+}
+
+// apply will fill in the default values for all the fields in the
+// message that are not set, and return the updated message. Values
+// explicitly set in the message will always take precedence over
+// the defaults.
+func (m *messageDefaults) apply(msg Message) Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d := m.defaults
+
+	if msg.ACKTimeout == 0 {
+		msg.ACKTimeout = d.ACKTimeout
+	}
+	if msg.Retries == 0 {
+		msg.Retries = d.Retries
+	}
+	if msg.MethodTimeout == 0 {
+		msg.MethodTimeout = d.MethodTimeout
+	}
+	if msg.ReplyMethod == "" {
+		msg.ReplyMethod = d.ReplyMethod
+	}
+	if len(msg.ReplyMethodArgs) == 0 && len(d.ReplyMethodArgs) != 0 {
+		msg.ReplyMethodArgs = append([]string{}, d.ReplyMethodArgs...)
+	}
+	if msg.ReplyACKTimeout == 0 {
+		msg.ReplyACKTimeout = d.ReplyACKTimeout
+	}
+	if msg.ReplyRetries == 0 {
+		msg.ReplyRetries = d.ReplyRetries
+	}
+	if msg.ReplyMethodTimeout == 0 {
+		msg.ReplyMethodTimeout = d.ReplyMethodTimeout
+	}
+	if msg.Directory == "" {
+		msg.Directory = d.Directory
+	}
+	if msg.FileName == "" {
+		msg.FileName = d.FileName
+	}
+
+	return msg
+}
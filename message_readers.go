@@ -2,14 +2,19 @@ package steward
 
 import (
 	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -123,7 +128,7 @@ func (s *server) readStartupFolder() {
 func (s *server) getFilePaths(dirName string) ([]string, error) {
 	dirPath, err := os.Executable()
 	dirPath = filepath.Dir(dirPath)
-	fmt.Printf(" * DEBUG: dirPath=%v\n", dirPath)
+	s.logger.logf(logLevelDebug, logFields{Node: Node(s.nodeName)}, " * DEBUG: dirPath=%v\n", dirPath)
 	if err != nil {
 		return nil, fmt.Errorf("error: startup folder: unable to get the working directory %v: %v", dirPath, err)
 	}
@@ -162,34 +167,28 @@ func (s *server) readSocket() {
 	// Loop, and wait for new connections.
 	for {
 		conn, err := s.StewardSocket.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			er := fmt.Errorf("error: failed to accept conn on socket: %v", err)
 			s.errorKernel.errSend(s.processInitial, Message{}, er)
+		} else {
+			// Register the connection so it can be audited.
+			conn = s.connRegistry.track("socket", conn)
 		}
 
 		go func(conn net.Conn) {
 			defer conn.Close()
 
-			var readBytes []byte
-
-			for {
-				b := make([]byte, 1500)
-				_, err = conn.Read(b)
-				if err != nil && err != io.EOF {
-					er := fmt.Errorf("error: failed to read data from socket: %v", err)
-					s.errorKernel.errSend(s.processInitial, Message{}, er)
-					return
-				}
-
-				readBytes = append(readBytes, b...)
-
-				if err == io.EOF {
-					break
-				}
+			// Read until the writer closes the connection.
+			readBytes, err := io.ReadAll(conn)
+			if err != nil {
+				er := fmt.Errorf("error: failed to read data from socket: %v", err)
+				s.errorKernel.errSend(s.processInitial, Message{}, er)
+				return
 			}
 
-			readBytes = bytes.Trim(readBytes, "\x00")
-
 			// unmarshal the JSON into a struct
 			sams, err := s.convertBytesToSAMs(readBytes)
 			if err != nil {
@@ -224,42 +223,41 @@ func (s *server) readSocket() {
 func (s *server) readTCPListener() {
 	ln, err := net.Listen("tcp", s.configuration.TCPListener)
 	if err != nil {
-		log.Printf("error: readTCPListener: failed to start tcp listener: %v\n", err)
+		s.logger.logf(logLevelError, logFields{Node: Node(s.nodeName)}, "error: readTCPListener: failed to start tcp listener: %v\n", err)
 		os.Exit(1)
 	}
+
+	s.listenersMu.Lock()
+	s.tcpListener = ln
+	s.listenersMu.Unlock()
+
 	// Loop, and wait for new connections.
 	for {
 
 		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			er := fmt.Errorf("error: failed to accept conn on socket: %v", err)
 			s.errorKernel.errSend(s.processInitial, Message{}, er)
 			continue
 		}
 
+		// Register the connection so it can be audited.
+		conn = s.connRegistry.track("tcp", conn)
+
 		go func(conn net.Conn) {
 			defer conn.Close()
 
-			var readBytes []byte
-
-			for {
-				b := make([]byte, 1500)
-				_, err = conn.Read(b)
-				if err != nil && err != io.EOF {
-					er := fmt.Errorf("error: failed to read data from tcp listener: %v", err)
-					s.errorKernel.errSend(s.processInitial, Message{}, er)
-					return
-				}
-
-				readBytes = append(readBytes, b...)
-
-				if err == io.EOF {
-					break
-				}
+			// Read until the writer closes the connection.
+			readBytes, err := io.ReadAll(conn)
+			if err != nil {
+				er := fmt.Errorf("error: failed to read data from tcp listener: %v", err)
+				s.errorKernel.errSend(s.processInitial, Message{}, er)
+				return
 			}
 
-			readBytes = bytes.Trim(readBytes, "\x00")
-
 			// unmarshal the JSON into a struct
 			sam, err := s.convertBytesToSAMs(readBytes)
 			if err != nil {
@@ -283,26 +281,34 @@ func (s *server) readTCPListener() {
 }
 
 func (s *server) readHTTPlistenerHandler(w http.ResponseWriter, r *http.Request) {
+	// Register the request so it can be audited. The http server handles
+	// the connections, so we record each request when it is done.
+	rec := connRecord{
+		Listener:    "http",
+		RemoteAddr:  r.RemoteAddr,
+		ConnectTime: time.Now(),
+	}
+	defer func() {
+		rec.DisconnectTime = time.Now()
+		s.connRegistry.record(rec)
+	}()
 
-	var readBytes []byte
-
-	for {
-		b := make([]byte, 1500)
-		_, err := r.Body.Read(b)
-		if err != nil && err != io.EOF {
-			er := fmt.Errorf("error: failed to read data from tcp listener: %v", err)
-			s.errorKernel.errSend(s.processInitial, Message{}, er)
-			return
-		}
-
-		readBytes = append(readBytes, b...)
+	if err := s.httpListenerAuth.authorize(r); err != nil {
+		er := fmt.Errorf("info: rejected unauthenticated request on HTTPListener from %v: %v", r.RemoteAddr, err)
+		s.errorKernel.errSend(s.processInitial, Message{}, er)
 
-		if err == io.EOF {
-			break
-		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
 	}
 
-	readBytes = bytes.Trim(readBytes, "\x00")
+	readBytes, err := io.ReadAll(r.Body)
+	rec.BytesRead = int64(len(readBytes))
+	if err != nil {
+		er := fmt.Errorf("error: failed to read data from HTTPListener: %v", err)
+		s.errorKernel.errSend(s.processInitial, Message{}, er)
+		return
+	}
 
 	// unmarshal the JSON into a struct
 	sam, err := s.convertBytesToSAMs(readBytes)
@@ -328,15 +334,29 @@ func (s *server) readHttpListener() {
 	go func() {
 		n, err := net.Listen("tcp", s.configuration.HTTPListener)
 		if err != nil {
-			log.Printf("error: startMetrics: failed to open prometheus listen port: %v\n", err)
+			s.logger.logf(logLevelError, logFields{Node: Node(s.nodeName)}, "error: startMetrics: failed to open prometheus listen port: %v\n", err)
 			os.Exit(1)
 		}
 		mux := http.NewServeMux()
 		mux.HandleFunc("/", s.readHTTPlistenerHandler)
 
-		err = http.Serve(n, mux)
+		tc, err := s.httpListenerAuth.tlsConfig(s.configuration)
 		if err != nil {
-			log.Printf("error: startMetrics: failed to start http.Serve: %v\n", err)
+			s.logger.logf(logLevelError, logFields{Node: Node(s.nodeName)}, "error: readHttpListener: failed to prepare tls: %v\n", err)
+			os.Exit(1)
+		}
+		if tc != nil {
+			n = tls.NewListener(n, tc)
+		}
+
+		srv := &http.Server{Handler: mux}
+		s.listenersMu.Lock()
+		s.httpServer = srv
+		s.listenersMu.Unlock()
+
+		err = srv.Serve(n)
+		if err != nil && err != http.ErrServerClosed {
+			s.logger.logf(logLevelError, logFields{Node: Node(s.nodeName)}, "error: startMetrics: failed to start http.Serve: %v\n", err)
 			os.Exit(1)
 		}
 	}()
@@ -364,6 +384,13 @@ func (s *server) convertBytesToSAMs(b []byte) ([]subjectAndMessage, error) {
 		return nil, fmt.Errorf("error: unmarshal of file failed: %#v", err)
 	}
 
+	// Give each message a correlation ID and expiry before it is copied
+	// for each of its toNodes, so all the copies have the same.
+	for i := range MsgSlice {
+		MsgSlice[i] = s.setCorrelationID(MsgSlice[i])
+		MsgSlice[i] = setExpiresAt(MsgSlice[i], time.Now())
+	}
+
 	// Check for toNode and toNodes field.
 	MsgSlice = s.checkMessageToNodes(MsgSlice)
 	s.metrics.promUserMessagesTotal.Add(float64(len(MsgSlice)))
@@ -373,6 +400,18 @@ func (s *server) convertBytesToSAMs(b []byte) ([]subjectAndMessage, error) {
 	// Range over all the messages parsed from json, and create a subject for
 	// each message.
 	for _, m := range MsgSlice {
+		m, err := decodeMessageData(m)
+		if err != nil {
+			er := fmt.Errorf("error: convertBytesToSAMs: %v", err)
+			s.errorKernel.errSend(s.processInitial, m, er)
+
+			continue
+		}
+
+		// Fill in the node's default values for the fields not
+		// specified in the message.
+		m = s.messageDefaults.apply(m)
+
 		sm, err := newSubjectAndMessage(m)
 		if err != nil {
 			er := fmt.Errorf("error: newSubjectAndMessage: %v", err)
@@ -386,6 +425,94 @@ func (s *server) convertBytesToSAMs(b []byte) ([]subjectAndMessage, error) {
 	return sam, nil
 }
 
+// decodeMessageData will decode the base64 data given in the DataBase64
+// field of the message into the Data field. The field is cleared, so the
+// data is only sent once. Both fields can not be set.
+func decodeMessageData(m Message) (Message, error) {
+	if m.DataBase64 == "" {
+		return m, nil
+	}
+	if len(m.Data) > 0 {
+		return m, fmt.Errorf("both data and dataBase64 are set, only one of them can be used")
+	}
+
+	// Line breaks are allowed, so long data can be folded in yaml.
+	d := strings.Join(strings.Fields(m.DataBase64), "")
+	b, err := base64.StdEncoding.DecodeString(d)
+	if err != nil {
+		return m, fmt.Errorf("failed to decode dataBase64: %v", err)
+	}
+	m.Data = b
+	m.DataBase64 = ""
+
+	return m, nil
+}
+
+// SubmitMessages will put the messages given into the system from a Go
+// program embedding steward, without having to go through the socket,
+// TCP or HTTP readers. The messages are checked and prepared the same
+// way as the messages read from the readers, and the FromNode field is
+// set to the name of this node. The valid messages are always put on
+// the ringbuffer, and an error listing the messages that were not valid
+// is returned if any.
+func (s *server) SubmitMessages(msgs []Message) error {
+	errs := []string{}
+	sams := []subjectAndMessage{}
+
+	for i, v := range msgs {
+		v = s.setCorrelationID(v)
+		v = setExpiresAt(v, time.Now())
+
+		// Check for toNode and toNodes field, where a message with
+		// toNodes gives one message per node.
+		ms := s.checkMessageToNodes([]Message{v})
+		if len(ms) == 0 {
+			errs = append(errs, fmt.Sprintf("message %v: no valid toNode or toNodes specified", i))
+			continue
+		}
+		s.metrics.promUserMessagesTotal.Add(float64(len(ms)))
+
+		for _, m := range ms {
+			m.FromNode = Node(s.nodeName)
+
+			m, err := decodeMessageData(m)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("message %v to %v: %v", i, m.ToNode, err))
+				continue
+			}
+
+			// Fill in the node's default values for the fields not
+			// specified in the message.
+			m = s.messageDefaults.apply(m)
+
+			sm, err := newSubjectAndMessage(m)
+			if err != nil {
+				er := fmt.Errorf("error: newSubjectAndMessage: %v", err)
+				s.errorKernel.errSend(s.processInitial, m, er)
+
+				errs = append(errs, fmt.Sprintf("message %v to %v: %v", i, m.ToNode, err))
+				continue
+			}
+			sams = append(sams, sm)
+		}
+	}
+
+	if len(sams) > 0 {
+		// Send the SAM struct to be picked up by the ring buffer.
+		select {
+		case s.toRingBufferCh <- sams:
+		case <-s.ctx.Done():
+			return fmt.Errorf("error: SubmitMessages: server stopped before the messages were submitted")
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error: SubmitMessages: messages not valid were not submitted: %v", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
 // checkMessageToNodes will check that either toHost or toHosts are
 // specified in the message. If not specified it will drop the message
 // and send an error.
@@ -405,13 +532,22 @@ func (s *server) checkMessageToNodes(MsgSlice []Message) []Message {
 		// if toNodes specified, we use the original message, and
 		// create new node messages for each of the nodes specified.
 		case len(v.ToNodes) != 0:
-			for _, n := range v.ToNodes {
+			// Expand the node groups and "*" into the nodes they hold.
+			nodes, err := s.expandToNodes(v.ToNodes)
+			if err != nil {
+				er := fmt.Errorf("error: toNodes: %v, dropping message: %v", err, v)
+				s.errorKernel.errSend(s.processInitial, v, er)
+				continue
+			}
+
+			for _, n := range nodes {
 				m := v
 				// Set the toNodes field to nil since we're creating
 				// an individual toNode message for each of the toNodes
 				// found, and hence we no longer need that field.
 				m.ToNodes = nil
 				m.ToNode = n
+				m.Metadata = copyMetadata(v.Metadata)
 				msgs = append(msgs, m)
 			}
 			continue
@@ -428,6 +564,86 @@ func (s *server) checkMessageToNodes(MsgSlice []Message) []Message {
 	return msgs
 }
 
+// expandToNodes will expand the node groups, named grp_nodes_*, and the
+// "*" for all the nodes given in toNodes into the nodes they hold, so a
+// message can be sent to a whole group with one message. The nodes known
+// are the nodes with a public key, and the groups are the node groups of
+// the ACL's. A node found more than once is only returned once. An error
+// is returned if a group is not known.
+func (s *server) expandToNodes(toNodes []Node) ([]Node, error) {
+	nodes := []Node{}
+	found := make(map[Node]struct{})
+	add := func(n Node) {
+		if _, ok := found[n]; !ok {
+			found[n] = struct{}{}
+			nodes = append(nodes, n)
+		}
+	}
+
+	for _, n := range toNodes {
+		switch {
+		case n == "*":
+			known := s.knownNodes()
+			if len(known) == 0 {
+				return nil, fmt.Errorf("no nodes known to expand * into")
+			}
+			for _, kn := range known {
+				add(kn)
+			}
+
+		case strings.HasPrefix(string(n), "grp_nodes_"):
+			s.centralAuth.accessLists.schemaMain.mu.Lock()
+			members, ok := s.centralAuth.accessLists.schemaMain.NodeGroupMap[nodeGroup(n)]
+			group := make([]Node, 0, len(members))
+			for m := range members {
+				group = append(group, m)
+			}
+			s.centralAuth.accessLists.schemaMain.mu.Unlock()
+
+			if !ok {
+				return nil, fmt.Errorf("no such node group: %v", n)
+			}
+
+			sort.Slice(group, func(i, j int) bool { return group[i] < group[j] })
+			for _, m := range group {
+				add(m)
+			}
+
+		default:
+			add(n)
+		}
+	}
+
+	return nodes, nil
+}
+
+// knownNodes will return the nodes known to this node, sorted by name,
+// which are the nodes with a public key distributed by central, and on
+// central the nodes with an acknowledged public key.
+func (s *server) knownNodes() []Node {
+	found := make(map[Node]struct{})
+
+	s.nodeAuth.publicKeys.mu.Lock()
+	for n := range s.nodeAuth.publicKeys.keysAndHash.Keys {
+		found[n] = struct{}{}
+	}
+	s.nodeAuth.publicKeys.mu.Unlock()
+
+	s.centralAuth.pki.nodesAcked.mu.Lock()
+	for n := range s.centralAuth.pki.nodesAcked.keysAndHash.Keys {
+		found[n] = struct{}{}
+	}
+	s.centralAuth.pki.nodesAcked.mu.Unlock()
+
+	nodes := make([]Node, 0, len(found))
+	for n := range found {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	return nodes
+}
+
 // newSubjectAndMessage will look up the correct values and value types to
 // be used in a subject for a Message (sam), and return the a combined structure
 // of type subjectAndMessage.
@@ -448,6 +664,10 @@ func newSubjectAndMessage(m Message) (subjectAndMessage, error) {
 		return subjectAndMessage{}, fmt.Errorf("error: newSubjectAndMessage: Method empty: %v", m)
 	}
 
+	if err := checkMessageTimeouts(m); err != nil {
+		return subjectAndMessage{}, fmt.Errorf("error: newSubjectAndMessage: %v", err)
+	}
+
 	sub := Subject{
 		ToNode: string(m.ToNode),
 		Event:  tmpH.getKind(),
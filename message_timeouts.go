@@ -0,0 +1,141 @@
+package steward
+
+import "fmt"
+
+// maxMessageTimeout is the largest timeout in seconds a message can have.
+// A timeout of -1 is used for no timeout, so larger values are refused
+// since they are most likely a mistake, and would overflow the duration
+// of the context if large enough.
+const maxMessageTimeout = 60 * 60 * 24 * 365
+
+// checkMessageTimeouts will check that the timeouts of the message are
+// within the valid range, which is 1 to maxMessageTimeout seconds, or 0
+// for the default. -1 is also valid, and is no timeout for the method
+// timeouts, and the default for the ACK timeouts.
+func checkMessageTimeouts(m Message) error {
+	timeouts := []struct {
+		name  string
+		value int
+	}{
+		{"ACKTimeout", m.ACKTimeout},
+		{"methodTimeout", m.MethodTimeout},
+		{"replyACKTimeout", m.ReplyACKTimeout},
+		{"replyMethodTimeout", m.ReplyMethodTimeout},
+	}
+
+	for _, t := range timeouts {
+		if t.value < -1 || t.value > maxMessageTimeout {
+			return fmt.Errorf("%v %v out of range, want -1, 0 for the default, or 1 to %v seconds", t.name, t.value, maxMessageTimeout)
+		}
+	}
+
+	return nil
+}
+
+// setMessageTimeoutDefaults will set the timeout and retry values of the
+// message that are not set to the defaults of the configuration. It is
+// used when a message is put on the ringbuffer. A MethodTimeout of -1
+// means no timeout, and is kept.
+func setMessageTimeoutDefaults(m Message, c *Configuration) Message {
+	if m.ACKTimeout < 1 {
+		m.ACKTimeout = c.DefaultMessageTimeout
+	}
+	if m.Retries < 1 {
+		m.Retries = c.DefaultMessageRetries
+	}
+	if m.MethodTimeout < 1 && m.MethodTimeout != -1 {
+		m.MethodTimeout = c.DefaultMethodTimeout
+	}
+
+	return m
+}
+
+// timeoutValue is the resolved value of a timeout or retry field, and
+// where the value came from. The source is one of "message",
+// "messageDefaults" or "configuration".
+type timeoutValue struct {
+	Value  int    `json:"value"`
+	Source string `json:"source"`
+}
+
+// effectiveTimeouts holds the resolved timeout and retry values of a
+// message, and the worst case time in seconds the message can take.
+type effectiveTimeouts struct {
+	ACKTimeout         timeoutValue `json:"ACKTimeout"`
+	Retries            timeoutValue `json:"retries"`
+	MethodTimeout      timeoutValue `json:"methodTimeout"`
+	ReplyACKTimeout    timeoutValue `json:"replyACKTimeout"`
+	ReplyRetries       timeoutValue `json:"replyRetries"`
+	ReplyMethodTimeout timeoutValue `json:"replyMethodTimeout"`
+
+	// The worst case time for delivering the message, which is the
+	// ACKTimeout for each of the retries.
+	DeliveryWorstCase int `json:"deliveryWorstCase"`
+	// The worst case time for delivering the reply message.
+	ReplyDeliveryWorstCase int `json:"replyDeliveryWorstCase"`
+	// The worst case time for the whole message, which is the delivery,
+	// the method timeout, the reply delivery and the reply method
+	// timeout. Set to -1 if one of the method timeouts is -1, since the
+	// method is then allowed to run forever.
+	TotalWorstCase int `json:"totalWorstCase"`
+}
+
+// resolveTimeouts will resolve the timeout and retry values of the
+// message the same way they are resolved when the message enters the
+// system on this node. First the message defaults of the node are used
+// for the fields not set, and then the defaults of the configuration.
+// The reply message is created on the node receiving the message, so
+// the reply values are resolved using the configuration of this node.
+func resolveTimeouts(m Message, md *messageDefaults, c *Configuration) effectiveTimeouts {
+	withDefaults := md.apply(m)
+	resolved := setMessageTimeoutDefaults(withDefaults, c)
+
+	// The reply message get the reply values as its values.
+	reply := setMessageTimeoutDefaults(Message{
+		ACKTimeout:    withDefaults.ReplyACKTimeout,
+		Retries:       withDefaults.ReplyRetries,
+		MethodTimeout: withDefaults.ReplyMethodTimeout,
+	}, c)
+
+	source := func(given int, withDefaults int) string {
+		switch {
+		case given != 0:
+			return "message"
+		case withDefaults != 0:
+			return "messageDefaults"
+		default:
+			return "configuration"
+		}
+	}
+
+	// A value given in the message or the message defaults that is not
+	// valid is replaced by the configuration, so check the final value
+	// against it.
+	value := func(given int, withDefaults int, final int) timeoutValue {
+		if withDefaults != final {
+			return timeoutValue{Value: final, Source: "configuration"}
+		}
+		return timeoutValue{Value: final, Source: source(given, withDefaults)}
+	}
+
+	e := effectiveTimeouts{
+		ACKTimeout:         value(m.ACKTimeout, withDefaults.ACKTimeout, resolved.ACKTimeout),
+		Retries:            value(m.Retries, withDefaults.Retries, resolved.Retries),
+		MethodTimeout:      value(m.MethodTimeout, withDefaults.MethodTimeout, resolved.MethodTimeout),
+		ReplyACKTimeout:    value(m.ReplyACKTimeout, withDefaults.ReplyACKTimeout, reply.ACKTimeout),
+		ReplyRetries:       value(m.ReplyRetries, withDefaults.ReplyRetries, reply.Retries),
+		ReplyMethodTimeout: value(m.ReplyMethodTimeout, withDefaults.ReplyMethodTimeout, reply.MethodTimeout),
+	}
+
+	e.DeliveryWorstCase = resolved.ACKTimeout * resolved.Retries
+	e.ReplyDeliveryWorstCase = reply.ACKTimeout * reply.Retries
+
+	switch {
+	case resolved.MethodTimeout == -1 || reply.MethodTimeout == -1:
+		e.TotalWorstCase = -1
+	default:
+		e.TotalWorstCase = e.DeliveryWorstCase + resolved.MethodTimeout + e.ReplyDeliveryWorstCase + reply.MethodTimeout
+	}
+
+	return e
+}
@@ -0,0 +1,86 @@
+package steward
+
+// methodProbe is the result of probing if a node supports, and the
+// sender is allowed to use, a method.
+type methodProbe struct {
+	Node     Node   `json:"node"`
+	FromNode Node   `json:"fromNode"`
+	Method   Method `json:"method"`
+	// Supported is true if the node have a subscriber running for the
+	// method.
+	Supported bool `json:"supported"`
+	// Authorized is true if the sender is allowed to use the method
+	// with the arguments given by the acl's of the node.
+	Authorized bool `json:"authorized"`
+	// SignatureRequired is true if the message also need a valid
+	// signature, which can not be checked before the message is sent.
+	SignatureRequired bool `json:"signatureRequired"`
+	// The event kind of the method, ACK or NACK.
+	Kind     Event `json:"kind"`
+	ReadOnly bool  `json:"readOnly"`
+	// Reason tells why the method is not supported or authorized.
+	Reason string `json:"reason,omitempty"`
+}
+
+// probeMethod will check if the method given have a subscriber running
+// on this node, and if the fromNode is authorized to use it with the
+// methodArgs given, without calling the handler of the method. The
+// authorization follows the same rules as verifySigOrAclFlag, except
+// for the signature that is only reported as required.
+func (p process) probeMethod(fromNode Node, method Method, methodArgs []string) methodProbe {
+	mp := methodProbe{
+		Node:     Node(p.configuration.NodeName),
+		FromNode: fromNode,
+		Method:   method,
+	}
+
+	var mt Method
+	mh := mt.getHandler(method)
+	if mh == nil {
+		mp.Reason = "no such method"
+		return mp
+	}
+	mp.Kind = mh.getKind()
+	mp.ReadOnly = mh.isReadOnly()
+
+	sub := newSubject(method, p.configuration.NodeName)
+	pn := processNameGet(sub.name(), processKindSubscriber)
+
+	p.processes.active.mu.Lock()
+	_, mp.Supported = p.processes.active.procNames[pn]
+	p.processes.active.mu.Unlock()
+
+	if !mp.Supported {
+		mp.Reason = "no subscriber running for the method"
+		return mp
+	}
+
+	m := Message{
+		FromNode:   fromNode,
+		Method:     method,
+		MethodArgs: methodArgs,
+	}
+
+	sigCheck := p.configuration.EnableSignatureCheck
+	aclCheck := p.configuration.EnableAclCheck
+
+	mp.SignatureRequired = sigCheck && p.nodeAuth.signatureRequired(method)
+
+	switch {
+	case p.server.degradedMode.isEnabled() && !mp.ReadOnly && method != REQDegradedMode:
+		mp.Reason = "node is in degraded mode, only read-only methods are allowed"
+	case !sigCheck && !aclCheck:
+		mp.Authorized = true
+	case sigCheck && !aclCheck:
+		mp.Authorized = true
+	case sigCheck && aclCheck:
+		mp.Authorized = p.nodeAuth.verifyAcl(m)
+		if !mp.Authorized {
+			mp.Reason = "not allowed by the acl"
+		}
+	default:
+		mp.Reason = "acl checking is enabled without signature checking, so no messages are allowed"
+	}
+
+	return mp
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
@@ -32,6 +35,9 @@ type metrics struct {
 	promHelloNodesTotal prometheus.Gauge
 	// Prometheus metrics for the vector of hello nodes.
 	promHelloNodesContactLast *prometheus.GaugeVec
+	// Prometheus metrics for the number of nodes that have stopped
+	// saying hello.
+	promHelloNodesStale prometheus.Gauge
 
 	// --- Ringbuffer
 	// Prometheus metrics for the last processed DB id in key
@@ -51,6 +57,8 @@ type metrics struct {
 	promNatsMessagesFailedACKsTotal prometheus.Counter
 	// Metrics for messages that missed to get ack replies.
 	promNatsMessagesMissedACKsTotal prometheus.Counter
+	// Metrics for messages dropped because they expired.
+	promMessagesExpiredTotal prometheus.Counter
 	// Metrics for received error messages
 	promErrorMessagesReceivedTotal prometheus.Counter
 	// Metrics for sent error messages
@@ -59,10 +67,23 @@ type metrics struct {
 	promInfoMessagesSentTotal prometheus.Counter
 	// Metrics for the amount of messages currently in db.
 	promDBMessagesCurrent prometheus.Gauge
+	// Metrics for the number of messages currently being handled by the
+	// subscribers of each method.
+	promSubscriberHandlersInFlight *prometheus.GaugeVec
+	// Metrics for the execution time of the method handlers.
+	promMethodHandlerDuration *prometheus.HistogramVec
+	// Metrics for the method handlers that panicked.
+	promMethodHandlerPanicsTotal *prometheus.CounterVec
+	// Metrics for the round-trip latency of the pings to each node.
+	promPingRoundTripDuration *prometheus.HistogramVec
+	// Metrics for the messages dropped by the inbound rate limit of
+	// each node.
+	promInboundRateLimitedTotal *prometheus.CounterVec
 }
 
-// newMetrics will prepare and return a *metrics.
-func newMetrics(hostAndPort string) *metrics {
+// newMetrics will prepare and return a *metrics. The handlerBuckets are
+// the buckets of the histogram of the method handler execution time.
+func newMetrics(hostAndPort string, handlerBuckets []float64) *metrics {
 	reg := prometheus.NewRegistry()
 	//prometheus.Unregister(prometheus.NewGoCollector()).
 	reg.MustRegister(collectors.NewGoCollector())
@@ -104,6 +125,12 @@ func newMetrics(hostAndPort string) *metrics {
 	}, []string{"nodeName"})
 	m.promRegistry.MustRegister(m.promHelloNodesContactLast)
 
+	m.promHelloNodesStale = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "steward_hello_nodes_stale",
+		Help: "The current number of nodes whose hello is overdue",
+	})
+	m.promRegistry.MustRegister(m.promHelloNodesStale)
+
 	m.promMessagesProcessedIDLast = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "steward_messages_processed_id_last",
 		Help: "The last processed id in key value/store db",
@@ -141,6 +168,12 @@ func newMetrics(hostAndPort string) *metrics {
 	})
 	m.promRegistry.MustRegister(m.promNatsMessagesFailedACKsTotal)
 
+	m.promMessagesExpiredTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "steward_messages_expired_total",
+		Help: "Number of messages dropped because they expired before they were delivered or handled",
+	})
+	m.promRegistry.MustRegister(m.promMessagesExpiredTotal)
+
 	m.promNatsMessagesMissedACKsTotal = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "steward_nats_messages_missed_acks_total",
 		Help: "Number of messages missed receiving an ack total",
@@ -171,9 +204,68 @@ func newMetrics(hostAndPort string) *metrics {
 	})
 	m.promRegistry.MustRegister(m.promDBMessagesCurrent)
 
+	m.promSubscriberHandlersInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "steward_subscriber_handlers_in_flight",
+		Help: "The current number of messages being handled by the subscribers of a method",
+	}, []string{"method"})
+	m.promRegistry.MustRegister(m.promSubscriberHandlersInFlight)
+
+	m.promMethodHandlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "steward_method_handler_duration_seconds",
+		Help:    "The execution time of the method handlers",
+		Buckets: handlerBuckets,
+	}, []string{"method"})
+	m.promRegistry.MustRegister(m.promMethodHandlerDuration)
+
+	m.promMethodHandlerPanicsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "steward_method_handler_panics_total",
+		Help: "Number of method handlers that panicked total",
+	}, []string{"method"})
+	m.promRegistry.MustRegister(m.promMethodHandlerPanicsTotal)
+
+	m.promPingRoundTripDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "steward_ping_round_trip_seconds",
+		Help:    "The round-trip latency of REQPing to a node, until the REQPong is received",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+	}, []string{"node"})
+	m.promRegistry.MustRegister(m.promPingRoundTripDuration)
+
+	m.promInboundRateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "steward_inbound_messages_rate_limited_total",
+		Help: "Number of messages dropped by the inbound rate limit total, by the node that sent them, where the nodes without a known public key are counted as other",
+	}, []string{"node"})
+	m.promRegistry.MustRegister(m.promInboundRateLimitedTotal)
+
 	return &m
 }
 
+// parseHistogramBuckets will parse the comma separated list of bucket
+// upper bounds given. The default buckets of prometheus are returned if
+// the list is empty.
+func parseHistogramBuckets(list string) ([]float64, error) {
+	if strings.TrimSpace(list) == "" {
+		return prometheus.DefBuckets, nil
+	}
+
+	var buckets []float64
+	for _, v := range strings.Split(list, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || f <= 0 {
+			return nil, fmt.Errorf("invalid bucket %q, want a number of seconds > 0", v)
+		}
+		buckets = append(buckets, f)
+	}
+	sort.Float64s(buckets)
+
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] == buckets[i-1] {
+			return nil, fmt.Errorf("bucket %v given more than once", buckets[i])
+		}
+	}
+
+	return buckets, nil
+}
+
 // Start the http interface for Prometheus metrics.
 func (m *metrics) start() error {
 
@@ -2,7 +2,9 @@ package steward
 
 import (
 	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,8 +12,13 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
 )
 
 // nodeAuth is the structure that holds both keys and acl's
@@ -21,6 +28,11 @@ type nodeAuth struct {
 	// ACL that defines where a node is allowed to recieve from.
 	nodeAcl *nodeAcl
 
+	// The methods that require a valid signature.
+	signatureMethods map[Method]struct{}
+	// The nonces of the signed messages seen recently.
+	nonceCache *nonceCache
+
 	// All the public keys for nodes a node is allowed to receive from.
 	publicKeys *publicKeys
 
@@ -35,6 +47,13 @@ type nodeAuth struct {
 	SignPrivateKey []byte
 	// public key for ed25519 signing.
 	SignPublicKey []byte
+	// The public key replaced by the last rotation, and the time it is
+	// valid until, so messages signed before the rotation still verify.
+	previousSignPublicKey  []byte
+	previousSignKeyValidTo time.Time
+	// signKeysMu guards the signing keys, since they can be replaced by
+	// a rotation while messages are signed and verified.
+	signKeysMu sync.RWMutex
 
 	configuration *Configuration
 
@@ -43,12 +62,15 @@ type nodeAuth struct {
 
 func newNodeAuth(configuration *Configuration, errorKernel *errorKernel) *nodeAuth {
 	n := nodeAuth{
-		nodeAcl:       newNodeAcl(configuration),
-		publicKeys:    newPublicKeys(configuration),
+		nodeAcl:       newNodeAcl(configuration, errorKernel.logger),
+		publicKeys:    newPublicKeys(configuration, errorKernel.logger),
 		configuration: configuration,
 		errorKernel:   errorKernel,
 	}
 
+	n.signatureMethods = newSignatureMethods(configuration.SignatureCheckMethods)
+	n.nonceCache = newNonceCache(nonceCacheMaxEntries)
+
 	// Set the signing key paths.
 	n.SignKeyFolder = filepath.Join(configuration.ConfigFolder, "signing")
 	n.SignKeyPrivateKeyPath = filepath.Join(n.SignKeyFolder, "private.key")
@@ -56,7 +78,7 @@ func newNodeAuth(configuration *Configuration, errorKernel *errorKernel) *nodeAu
 
 	err := n.loadSigningKeys()
 	if err != nil {
-		log.Printf("%v\n", err)
+		errorKernel.logger.logf(logLevelError, logFields{}, "%v\n", err)
 		os.Exit(1)
 	}
 
@@ -82,18 +104,24 @@ type nodeAcl struct {
 	// allowed is a map for holding all the allowed signatures.
 	aclAndHash aclAndHash
 	filePath   string
+	// regexCache holds the compiled regular expressions of the commands
+	// flagged with aclRegexPrefix, so they are only compiled once.
+	regexCache map[command]*regexp.Regexp
 	mu         sync.Mutex
+	logger     logger
 }
 
-func newNodeAcl(c *Configuration) *nodeAcl {
+func newNodeAcl(c *Configuration, l logger) *nodeAcl {
 	n := nodeAcl{
 		aclAndHash: newAclAndHash(),
 		filePath:   filepath.Join(c.DatabaseFolder, "node_aclmap.txt"),
+		regexCache: make(map[command]*regexp.Regexp),
+		logger:     l,
 	}
 
 	err := n.loadFromFile()
 	if err != nil {
-		log.Printf("error: loading acl's from file: %v\n", err)
+		n.logger.logf(logLevelError, logFields{}, "error: loading acl's from file: %v\n", err)
 		// os.Exit(1)
 	}
 
@@ -107,7 +135,7 @@ func (n *nodeAcl) loadFromFile() error {
 	if _, err := os.Stat(n.filePath); os.IsNotExist(err) {
 		// Just logging the error since it is not crucial that a key file is missing,
 		// since a new one will be created on the next update.
-		log.Printf("no acl file found at %v\n", n.filePath)
+		n.logger.logf(logLevelInfo, logFields{}, "no acl file found at %v\n", n.filePath)
 		return nil
 	}
 
@@ -129,7 +157,7 @@ func (n *nodeAcl) loadFromFile() error {
 		return err
 	}
 
-	log.Printf("\n ***** DEBUG: Loaded existing acl's from file: %v\n\n", n.aclAndHash.Hash)
+	n.logger.logf(logLevelDebug, logFields{}, "\n ***** DEBUG: Loaded existing acl's from file: %v\n\n", n.aclAndHash.Hash)
 
 	return nil
 }
@@ -183,17 +211,19 @@ type publicKeys struct {
 	keysAndHash *keysAndHash
 	mu          sync.Mutex
 	filePath    string
+	logger      logger
 }
 
-func newPublicKeys(c *Configuration) *publicKeys {
+func newPublicKeys(c *Configuration, l logger) *publicKeys {
 	p := publicKeys{
 		keysAndHash: newKeysAndHash(),
 		filePath:    filepath.Join(c.DatabaseFolder, "publickeys.txt"),
+		logger:      l,
 	}
 
 	err := p.loadFromFile()
 	if err != nil {
-		log.Printf("error: loading public keys from file: %v\n", err)
+		p.logger.logf(logLevelError, logFields{}, "error: loading public keys from file: %v\n", err)
 		// os.Exit(1)
 	}
 
@@ -207,7 +237,7 @@ func (p *publicKeys) loadFromFile() error {
 	if _, err := os.Stat(p.filePath); os.IsNotExist(err) {
 		// Just logging the error since it is not crucial that a key file is missing,
 		// since a new one will be created on the next update.
-		log.Printf("no public keys file found at %v\n", p.filePath)
+		p.logger.logf(logLevelInfo, logFields{}, "no public keys file found at %v\n", p.filePath)
 		return nil
 	}
 
@@ -222,6 +252,13 @@ func (p *publicKeys) loadFromFile() error {
 		return err
 	}
 
+	// An empty file holds no keys, and the keys will be filled in on the
+	// next update.
+	if len(b) == 0 {
+		p.logger.logf(logLevelInfo, logFields{}, "public keys file %v is empty\n", p.filePath)
+		return nil
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	err = json.Unmarshal(b, &p.keysAndHash)
@@ -229,20 +266,15 @@ func (p *publicKeys) loadFromFile() error {
 		return err
 	}
 
-	log.Printf("\n ***** DEBUG: Loaded existing keys from file: %v\n\n", p.keysAndHash.Hash)
+	p.logger.logf(logLevelDebug, logFields{}, "\n ***** DEBUG: Loaded existing keys from file: %v\n\n", p.keysAndHash.Hash)
 
 	return nil
 }
 
 // saveToFile will save all the public kets to file for persistent storage.
-// An error is returned if it fails.
+// The file is replaced atomically, so it always holds either the previous
+// or the new keys. An error is returned if it fails.
 func (p *publicKeys) saveToFile() error {
-	fh, err := os.OpenFile(p.filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return fmt.Errorf("error: failed to open public keys file: %v", err)
-	}
-	defer fh.Close()
-
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	b, err := json.Marshal(p.keysAndHash)
@@ -250,14 +282,44 @@ func (p *publicKeys) saveToFile() error {
 		return err
 	}
 
-	_, err = fh.Write(b)
+	err = writeFileAtomic(p.filePath, b, 0600)
 	if err != nil {
-		return err
+		return fmt.Errorf("error: failed to write public keys file: %v", err)
 	}
 
 	return nil
 }
 
+// publicKeysList is the public keys known by a node, with the keys given
+// by their fingerprint.
+type publicKeysList struct {
+	// The base64 encoded sha256 fingerprint of the public key of each node.
+	Keys map[Node]string `json:"keys"`
+	// The hash of all the keys in hex.
+	Hash  string `json:"hash"`
+	Count int    `json:"count"`
+}
+
+// list will return the fingerprints of the public keys, the hash of the
+// keys and the number of keys.
+func (p *publicKeys) list() publicKeysList {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l := publicKeysList{
+		Keys:  make(map[Node]string),
+		Hash:  hex.EncodeToString(p.keysAndHash.Hash[:]),
+		Count: len(p.keysAndHash.Keys),
+	}
+
+	for node, key := range p.keysAndHash.Keys {
+		fp := sha256.Sum256(key)
+		l.Keys[node] = base64.StdEncoding.EncodeToString(fp[:])
+	}
+
+	return l
+}
+
 // loadSigningKeys will try to load the ed25519 signing keys. If the
 // files are not found new keys will be generated and written to disk.
 func (n *nodeAuth) loadSigningKeys() error {
@@ -310,7 +372,7 @@ func (n *nodeAuth) loadSigningKeys() error {
 		n.SignPrivateKey = priv
 
 		er := fmt.Errorf("info: no signing keys found, generating new keys")
-		log.Printf("%v\n", er)
+		n.errorKernel.logger.logf(logLevelInfo, logFields{}, "%v\n", er)
 
 		// We got the new generated keys now, so we can return.
 		return nil
@@ -333,24 +395,115 @@ func (n *nodeAuth) loadSigningKeys() error {
 	return nil
 }
 
-// writeSigningKey will write the base64 encoded signing key to file.
+// writeSigningKey will write the base64 encoded signing key to file,
+// replacing the file atomically.
 func (n *nodeAuth) writeSigningKey(realPath string, keyB64 string) error {
-	fh, err := os.OpenFile(realPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	err := writeFileAtomic(realPath, []byte(keyB64), 0600)
 	if err != nil {
-		er := fmt.Errorf("error: failed to open key file for writing: %v", err)
+		er := fmt.Errorf("error: failed to write key to file: %v", err)
 		return er
 	}
-	defer fh.Close()
 
-	_, err = fh.Write([]byte(keyB64))
+	return nil
+}
+
+// writeFileAtomic will write the data to a temporary file in the same
+// folder as the file, and rename it to the file, so the file is never
+// left half written if steward stops while writing. The file then holds
+// either the previous or the new content.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
 	if err != nil {
-		er := fmt.Errorf("error: failed to write key to file: %v", err)
-		return er
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %v", err)
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary file: %v", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close temporary file: %v", err)
+	}
+
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return fmt.Errorf("failed to set mode of temporary file: %v", err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("failed to replace file: %v", err)
 	}
 
 	return nil
 }
 
+// signingKeys will return the current ed25519 signing keys of the node.
+func (n *nodeAuth) signingKeys() (pub []byte, priv []byte) {
+	n.signKeysMu.RLock()
+	defer n.signKeysMu.RUnlock()
+
+	return n.SignPublicKey, n.SignPrivateKey
+}
+
+// rotateSigningKeys will generate a new ed25519 signing key pair, replace
+// the key files on disk, and start using the new keys. The old public key
+// is kept for the grace period given, so the messages signed before the
+// rotation can still be verified. The new public key is returned.
+func (n *nodeAuth) rotateSigningKeys(grace time.Duration) ([]byte, error) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error: failed to generate ed25519 keys for signing: %v", err)
+	}
+
+	n.signKeysMu.Lock()
+	defer n.signKeysMu.Unlock()
+
+	// Each file is written to a temporary file first and then renamed,
+	// so a key file is never left half written.
+	err = n.writeSigningKey(n.SignKeyPrivateKeyPath, base64.RawStdEncoding.EncodeToString(priv))
+	if err != nil {
+		return nil, err
+	}
+	err = n.writeSigningKey(n.SignKeyPublicKeyPath, base64.RawStdEncoding.EncodeToString(pub))
+	if err != nil {
+		return nil, err
+	}
+
+	n.previousSignPublicKey = n.SignPublicKey
+	n.previousSignKeyValidTo = time.Now().Add(grace)
+	n.SignPublicKey = pub
+	n.SignPrivateKey = priv
+
+	return pub, nil
+}
+
+// verifyOwnSignature will check the signature against the current public
+// signing key of the node, and against the key replaced by the last
+// rotation if it is still within the grace period.
+func (n *nodeAuth) verifyOwnSignature(signed []byte, sig []byte, now time.Time) bool {
+	n.signKeysMu.RLock()
+	defer n.signKeysMu.RUnlock()
+
+	if len(n.SignPublicKey) == ed25519.PublicKeySize && ed25519.Verify(n.SignPublicKey, signed, sig) {
+		return true
+	}
+
+	if len(n.previousSignPublicKey) == ed25519.PublicKeySize && now.Before(n.previousSignKeyValidTo) {
+		return ed25519.Verify(n.previousSignPublicKey, signed, sig)
+	}
+
+	return false
+}
+
 // readKeyFile will take the path of a key file as input, read the base64
 // encoded data, decode the data. It will return the raw data as []byte,
 // the base64 encoded data, and any eventual error.
@@ -379,77 +532,581 @@ func (n *nodeAuth) readKeyFile(keyFile string) (ed2519key []byte, b64Key []byte,
 
 // verifySignature
 func (n *nodeAuth) verifySignature(m Message) bool {
-	// NB: Only enable signature checking for REQCliCommand for now.
-	if m.Method != REQCliCommand {
-		log.Printf(" * DEBUG: verifySignature,not REQCliCommand and will not do signature check, method: %v\n", m.Method)
+	if !n.signatureRequired(m.Method) {
+		n.errorKernel.logger.logf(logLevelDebug, msgLogFields(Node(n.configuration.NodeName), m), " * DEBUG: verifySignature, signature not required and will not do signature check, method: %v\n", m.Method)
+		return true
+	}
+
+	return n.checkSignature(m)
+}
+
+// requireSignature will verify the signature of the message for the
+// handlers that must only act on signed messages, even when signature
+// checking is not enabled, or not required for the method. If the
+// signature was already verified when the message was received it is
+// not verified again, since the nonce would then be seen as a replay.
+func (n *nodeAuth) requireSignature(m Message) bool {
+	if n.configuration.EnableSignatureCheck && n.signatureRequired(m.Method) {
 		return true
 	}
 
+	return n.checkSignature(m)
+}
+
+// checkSignature will verify the signature of the message with the
+// public key of the node that sent it, and check that it is not a
+// replay.
+func (n *nodeAuth) checkSignature(m Message) bool {
 	// Verify if the signature matches.
-	argsStringified := argsToString(m.MethodArgs)
+	signed := signedString(m)
 	var ok bool
 
 	err := func() error {
 		n.publicKeys.mu.Lock()
+		defer n.publicKeys.mu.Unlock()
+
 		pubKey := n.publicKeys.keysAndHash.Keys[m.FromNode]
 		if len(pubKey) != 32 {
 			err := fmt.Errorf("DEBUG: Length of publicKey: %v", len(pubKey))
 			return err
 		}
 
-		ok = ed25519.Verify(pubKey, []byte(argsStringified), m.ArgSignature)
-		n.publicKeys.mu.Unlock()
+		ok = ed25519.Verify(pubKey, []byte(signed), m.ArgSignature)
 
 		return nil
 	}()
 
 	if err != nil {
-		log.Printf("%v\n", err)
+		n.errorKernel.logger.logf(logLevelError, msgLogFields(Node(n.configuration.NodeName), m), "%v\n", err)
+	}
+
+	// The node's own key in the public keys is only updated when central
+	// have acked a rotated key, so the messages from the node itself are
+	// also checked against its own keys.
+	if !ok && m.FromNode == Node(n.configuration.NodeName) {
+		ok = n.verifyOwnSignature([]byte(signed), m.ArgSignature, time.Now())
+	}
+
+	// Only check for replay when the signature is valid, so the nonces
+	// remembered can't be filled with nonces from forged messages.
+	if ok {
+		skew := time.Second * time.Duration(n.configuration.SignatureMaxSkew)
+		err := n.nonceCache.checkReplay(m.FromNode, m.SignNonce, m.SignedAt, time.Now(), skew)
+		if err != nil {
+			n.errorKernel.logger.logf(logLevelError, msgLogFields(Node(n.configuration.NodeName), m), "error: verifySignature: replay check failed: %v\n", err)
+			ok = false
+		}
 	}
 
-	log.Printf("info: verifySignature, result: %v, fromNode: %v, method: %v\n", ok, m.FromNode, m.Method)
+	n.errorKernel.logger.logf(logLevelInfo, msgLogFields(Node(n.configuration.NodeName), m), "info: verifySignature, result: %v, fromNode: %v, method: %v\n", ok, m.FromNode, m.Method)
 
 	return ok
 }
 
-// verifyAcl
+// defaultSignatureMethods are the methods that require a valid signature
+// when signature checking is enabled, and no methods are given with the
+// signatureCheckMethods flag. All the methods changing state on a node
+// are here, and the read-only methods that can read files or reach the
+// network from the node, so they can not be used to get data out of the
+// node without a signature. The signatureBootstrapMethods are left out.
+var defaultSignatureMethods = []Method{
+	// Methods changing state on the node.
+	REQAclAddCommand,
+	REQAclDeleteCommand,
+	REQAclDeleteSource,
+	REQAclGroupCommandsAddCommand,
+	REQAclGroupCommandsDeleteCommand,
+	REQAclGroupCommandsDeleteGroup,
+	REQAclGroupNodesAddNode,
+	REQAclGroupNodesDeleteGroup,
+	REQAclGroupNodesDeleteNode,
+	REQAclImport,
+	REQAdoptNodeName,
+	REQCentralChanged,
+	REQCentralReplicate,
+	REQChangeNodeName,
+	REQCliCommand,
+	REQCliCommandCont,
+	REQCloneNodeConfig,
+	REQCompressStoredReplies,
+	REQCopyDirTo,
+	REQCopyFileResume,
+	REQCopyFileTo,
+	REQDegradedMode,
+	REQExportAuditBundle,
+	REQFailover,
+	REQGenerateKeypairFor,
+	REQHttpPost,
+	REQKeysAllow,
+	REQKeysDelete,
+	REQKeysRotate,
+	REQLockAcquire,
+	REQLockRelease,
+	REQLockResult,
+	REQManageErrorSink,
+	REQOpProcessStart,
+	REQOpProcessStop,
+	REQPartialUpdateFile,
+	REQPing,
+	REQPong,
+	REQProcessRestart,
+	REQReconcileState,
+	REQReindexDataFolder,
+	REQReplicateTo,
+	REQReplicateToAck,
+	REQResourceLimitExec,
+	REQRunWithLock,
+	REQScheduled,
+	REQScheduledStop,
+	REQSetMessageDefaults,
+	REQSetPriorityPolicy,
+	REQShutdownScheduled,
+	REQStreamCommand,
+	REQStreamCommandClose,
+	REQStreamCommandInput,
+	REQSyncTime,
+	REQSyncTimeApply,
+	REQTailFileStop,
+	REQToFile,
+	REQToFileAppend,
+	REQToFileNACK,
+	REQWriteFileIfChanged,
+
+	// Read-only methods reading files or the network.
+	REQBulkFileFetch,
+	REQCopyDirFrom,
+	REQCopyFileFrom,
+	REQHttpGet,
+	REQHttpGetScheduled,
+	REQSearchDataFolder,
+	REQTailFile,
+}
+
+// signatureBootstrapMethods are the methods changing state that are not
+// in the defaultSignatureMethods, since they are used before the nodes
+// have the keys to verify them with. A new node sends its public key
+// with REQHello before central have acked it, and it gets the keys of
+// the other nodes with REQKeysDeliverUpdate and the acl with
+// REQAclDeliverUpdate before it can verify any signature. The error
+// log, the initial process and the relays carry messages from nodes
+// that might not be acked yet. The deliver updates are still checked
+// against the hash made by central before they are used.
+var signatureBootstrapMethods = []Method{
+	REQAclDeliverUpdate,
+	REQErrorLog,
+	REQHello,
+	REQInitial,
+	REQKeysDeliverUpdate,
+	REQRelay,
+	REQRelayInitial,
+}
+
+// newSignatureMethods will return the set of methods that require a
+// valid signature, from the comma separated list of methods given. If
+// the list is empty the defaultSignatureMethods are returned.
+func newSignatureMethods(list string) map[Method]struct{} {
+	methods := make(map[Method]struct{})
+
+	var mt Method
+	ma := mt.GetMethodsAvailable()
+
+	if strings.TrimSpace(list) == "" {
+		for _, m := range defaultSignatureMethods {
+			methods[m] = struct{}{}
+		}
+
+		return methods
+	}
+
+	for _, v := range strings.Split(list, ",") {
+		m := Method(strings.TrimSpace(v))
+		if _, ok := ma.CheckIfExists(m); !ok {
+			log.Printf("error: signatureCheckMethods: no such method: %v\n", m)
+			continue
+		}
+		methods[m] = struct{}{}
+	}
+
+	return methods
+}
+
+// signatureRequired will return true if the method requires a valid
+// signature.
+func (n *nodeAuth) signatureRequired(method Method) bool {
+	_, ok := n.signatureMethods[method]
+	return ok
+}
+
+// signedString will return the canonical string of the message that is
+// signed, made of the method, the node the message is sent to, the time
+// and nonce of the signature, the methodArgs, the sha256 hash of the data,
+// the directory and file name, and the reply method and its arguments.
+// Including the method and the node makes sure a signature can not be
+// reused for another method or node, and the time and nonce that it can
+// not be replayed. The rest makes sure the payload, where the result is
+// written, and where the reply goes can not be changed after signing.
+func signedString(m Message) string {
+	return fmt.Sprintf("%s\n%s\n%d\n%s\n%s\n%x\n%s\n%s\n%s\n%s", m.Method, m.ToNode, m.SignedAt, m.SignNonce, argsToString(m.MethodArgs), sha256.Sum256(m.Data), m.Directory, m.FileName, m.ReplyMethod, argsToString(m.ReplyMethodArgs))
+}
+
+// signatureInspection holds what was signed, the signature, and the
+// public key used when verifying the signature of a message.
+type signatureInspection struct {
+	FromNode Node   `json:"fromNode"`
+	Method   Method `json:"method"`
+	// The canonical string created from the message by signedString,
+	// which is what the signature is made of.
+	SignedString string `json:"signedString"`
+	// The signature of the message in base64.
+	Signature string `json:"signature"`
+	// The public key of the fromNode in base64, if we have it.
+	PublicKey string `json:"publicKey"`
+	// The result of verifying the signature with the public key.
+	Verified bool `json:"verified"`
+	// Tells why the verification failed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// inspectSignature will verify the signature of the message, and return
+// all the details used for the verification. Unlike verifySignature all
+// methods are checked, so it can be used to debug signature problems.
+func (n *nodeAuth) inspectSignature(m Message) signatureInspection {
+	si := signatureInspection{
+		FromNode:     m.FromNode,
+		Method:       m.Method,
+		SignedString: signedString(m),
+		Signature:    base64.StdEncoding.EncodeToString(m.ArgSignature),
+	}
+
+	n.publicKeys.mu.Lock()
+	pubKey := n.publicKeys.keysAndHash.Keys[m.FromNode]
+	n.publicKeys.mu.Unlock()
+
+	si.PublicKey = base64.StdEncoding.EncodeToString(pubKey)
+
+	switch {
+	case len(pubKey) == 0:
+		si.Reason = fmt.Sprintf("no public key found for node %v", m.FromNode)
+	case len(pubKey) != ed25519.PublicKeySize:
+		si.Reason = fmt.Sprintf("public key for node %v have wrong length %v, want %v", m.FromNode, len(pubKey), ed25519.PublicKeySize)
+	case len(m.ArgSignature) == 0:
+		si.Reason = "message have no signature"
+	case len(m.ArgSignature) != ed25519.SignatureSize:
+		si.Reason = fmt.Sprintf("signature have wrong length %v, want %v", len(m.ArgSignature), ed25519.SignatureSize)
+	default:
+		si.Verified = ed25519.Verify(pubKey, []byte(si.SignedString), m.ArgSignature)
+		if !si.Verified {
+			si.Reason = "signature do not match the signed string and public key"
+		}
+	}
+
+	return si
+}
+
+// allowedSigner is a node whose signatures are trusted, identified by
+// the fingerprint of its public key.
+type allowedSigner struct {
+	// The sha256 fingerprint of the public key as a hex string.
+	Fingerprint string `json:"fingerprint"`
+	Node        Node   `json:"node"`
+	// The public key in base64.
+	PublicKey string `json:"publicKey"`
+}
+
+// allowedSignatures holds the nodes whose signatures are trusted by the
+// node, and the hash of the public keys as received from central.
+type allowedSignatures struct {
+	Signers []allowedSigner `json:"signers"`
+	Hash    string          `json:"hash"`
+}
+
+// allowedSignatures will return the public keys the signatures of the
+// messages are verified with, sorted by node.
+func (n *nodeAuth) allowedSignatures() allowedSignatures {
+	n.publicKeys.mu.Lock()
+	defer n.publicKeys.mu.Unlock()
+
+	a := allowedSignatures{
+		Signers: []allowedSigner{},
+		Hash:    hex.EncodeToString(n.publicKeys.keysAndHash.Hash[:]),
+	}
+
+	for node, key := range n.publicKeys.keysAndHash.Keys {
+		fp := sha256.Sum256(key)
+		a.Signers = append(a.Signers, allowedSigner{
+			Fingerprint: hex.EncodeToString(fp[:]),
+			Node:        node,
+			PublicKey:   base64.StdEncoding.EncodeToString(key),
+		})
+	}
+
+	sort.Slice(a.Signers, func(i, j int) bool {
+		return a.Signers[i].Node < a.Signers[j].Node
+	})
+
+	return a
+}
+
+// aclCommands will return the commands the message will run on the
+// node, for the methods that run commands. ok is false if the method
+// do not run commands, and then the acl is not checked. The session id,
+// lock and limits given before the command in the methodArgs are not
+// part of the command.
+func aclCommands(m Message) (cmds [][]string, ok bool) {
+	switch m.Method {
+	case REQCliCommand, REQCliCommandCont:
+		return [][]string{m.MethodArgs}, true
+	case REQStreamCommand, REQResourceLimitExec:
+		if len(m.MethodArgs) < 2 {
+			return [][]string{}, true
+		}
+		return [][]string{m.MethodArgs[1:]}, true
+	case REQRunWithLock:
+		if len(m.MethodArgs) < 3 {
+			return [][]string{}, true
+		}
+		return [][]string{m.MethodArgs[2:]}, true
+	case REQReconcileState:
+		ds, err := parseDesiredState(m.Data)
+		if err != nil {
+			return [][]string{}, true
+		}
+		for _, s := range ds.Services {
+			c := serviceCommand(s)
+			cmds = append(cmds, c.Check, c.Apply)
+		}
+		for _, c := range ds.Commands {
+			cmds = append(cmds, c.Check, c.Apply)
+		}
+		return cmds, true
+	}
+
+	return nil, false
+}
+
+// verifyAcl will check that all the commands the message will run are
+// allowed for the node sending the message. Methods that do not run
+// commands are always allowed.
 func (n *nodeAuth) verifyAcl(m Message) bool {
-	// NB: Only enable acl checking for REQCliCommand for now.
-	if m.Method != REQCliCommand {
-		log.Printf(" * DEBUG: verifyAcl: not REQCliCommand and will not do acl check, method: %v\n", m.Method)
+	cmds, ok := aclCommands(m)
+	if !ok {
+		n.errorKernel.logger.logf(logLevelDebug, msgLogFields(Node(n.configuration.NodeName), m), " * DEBUG: verifyAcl: method do not run commands and will not do acl check, method: %v\n", m.Method)
 		return true
 	}
 
-	argsStringified := argsToString(m.MethodArgs)
-
 	// Verify if the command matches the one in the acl map.
 	n.nodeAcl.mu.Lock()
 	defer n.nodeAcl.mu.Unlock()
 
 	cmdMap, ok := n.nodeAcl.aclAndHash.Acl[m.FromNode]
 	if !ok {
-		log.Printf(" * DEBUG: verifyAcl: The fromNode=%v was not found in the acl\n", m.FromNode)
+		n.errorKernel.logger.logf(logLevelDebug, msgLogFields(Node(n.configuration.NodeName), m), " * DEBUG: verifyAcl: The fromNode=%v was not found in the acl\n", m.FromNode)
 		return false
 	}
 
 	_, ok = cmdMap[command("*")]
 	if ok {
-		log.Printf(" * DEBUG: verifyAcl: The acl said \"*\", all commands allowed from node=%v\n", m.FromNode)
+		n.errorKernel.logger.logf(logLevelDebug, msgLogFields(Node(n.configuration.NodeName), m), " * DEBUG: verifyAcl: The acl said \"*\", all commands allowed from node=%v\n", m.FromNode)
 		return true
 	}
 
-	_, ok = cmdMap[command(argsStringified)]
-	if !ok {
-		log.Printf(" * DEBUG: verifyAcl: The command=%v was NOT FOUND in the acl\n", m.MethodArgs)
+	// A message that should run commands, but where no commands were
+	// found is not allowed.
+	if len(cmds) == 0 {
+		n.errorKernel.logger.logf(logLevelDebug, msgLogFields(Node(n.configuration.NodeName), m), " * DEBUG: verifyAcl: no command found in the message, method: %v\n", m.Method)
 		return false
 	}
 
-	log.Printf(" * DEBUG: The command was FOUND in the acl, verifyAcl, result: %v, fromNode: %v, method: %v\n", ok, m.FromNode, m.Method)
+	for _, c := range cmds {
+		argsStringified := argsToString(c)
+
+		// Check for a literal match first, since that is just a map lookup,
+		// and then try the commands that are regular expressions.
+		_, ok = cmdMap[command(argsStringified)]
+		if !ok {
+			ok = n.nodeAcl.matchRegex(cmdMap, argsStringified)
+		}
+		if !ok {
+			n.errorKernel.logger.logf(logLevelDebug, msgLogFields(Node(n.configuration.NodeName), m), " * DEBUG: verifyAcl: The command=%v was NOT FOUND in the acl\n", c)
+			return false
+		}
+	}
+
+	n.errorKernel.logger.logf(logLevelDebug, msgLogFields(Node(n.configuration.NodeName), m), " * DEBUG: The command was FOUND in the acl, verifyAcl, result: %v, fromNode: %v, method: %v\n", ok, m.FromNode, m.Method)
 
 	return true
 }
 
+// matchRegex will check if the command matches any of the commands in
+// the map that are regular expressions. The caller must hold the lock.
+func (n *nodeAcl) matchRegex(cmdMap map[command]struct{}, cmd string) bool {
+	for c := range cmdMap {
+		re, ok := n.regexCache[c]
+		if !ok {
+			var isRegex bool
+			var err error
+			re, isRegex, err = aclRegex(c)
+			if !isRegex {
+				continue
+			}
+			if err != nil {
+				n.logger.logf(logLevelError, logFields{}, "error: verifyAcl: %v\n", err)
+				continue
+			}
+			n.regexCache[c] = re
+		}
+
+		if re.MatchString(cmd) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // argsToString takes args in the format of []string and returns a string.
 func argsToString(args []string) string {
 	return strings.Join(args, " ")
 }
+
+// hashPublicKeys will return the hash of the public keys, calculated
+// from the keys sorted by node name. This is the hash the central
+// delivers to the nodes together with the keys.
+func hashPublicKeys(keys map[Node][]byte) ([32]byte, error) {
+	type NodesAndKeys struct {
+		Node Node
+		Key  []byte
+	}
+
+	// Create a slice of all the map keys, and its value.
+	sortedNodesAndKeys := []NodesAndKeys{}
+	for k, v := range keys {
+		nk := NodesAndKeys{
+			Node: k,
+			Key:  v,
+		}
+
+		sortedNodesAndKeys = append(sortedNodesAndKeys, nk)
+	}
+
+	// sort the slice based on the node name.
+	sort.SliceStable(sortedNodesAndKeys, func(i, j int) bool {
+		return sortedNodesAndKeys[i].Node < sortedNodesAndKeys[j].Node
+	})
+
+	// Then create a hash based on the sorted slice.
+	b, err := cbor.Marshal(sortedNodesAndKeys)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	return sha256.Sum256(b), nil
+}
+
+// verifyPublicKeysHash will return an error if the hash delivered with
+// the public keys is not the hash of the keys, so keys changed on the
+// way are not used. No keys are delivered with a zero hash when central
+// have no keys.
+func verifyPublicKeysHash(kh keysAndHash) error {
+	if len(kh.Keys) == 0 && kh.Hash == [32]byte{} {
+		return nil
+	}
+
+	hash, err := hashPublicKeys(kh.Keys)
+	if err != nil {
+		return fmt.Errorf("failed to hash the public keys: %v", err)
+	}
+	if hash != kh.Hash {
+		return fmt.Errorf("the hash of the public keys %x does not match the hash delivered %x", hash, kh.Hash)
+	}
+
+	return nil
+}
+
+// verifyACLHash will return an error if the hash delivered with the ACL
+// of the host is not the hash of the ACL, so an ACL changed on the way
+// is not used. No ACL is delivered with a zero hash when central have
+// no ACL for the host.
+func verifyACLHash(host Node, acl map[Node]map[command]struct{}, delivered [32]byte) error {
+	if len(acl) == 0 && delivered == [32]byte{} {
+		return nil
+	}
+
+	hash, err := hashACL(host, acl)
+	if err != nil {
+		return fmt.Errorf("failed to hash the acl: %v", err)
+	}
+	if hash != delivered {
+		return fmt.Errorf("the hash of the acl %x does not match the hash delivered %x", hash, delivered)
+	}
+
+	return nil
+}
+
+// validateTrustStore will check the integrity of the stored trust state
+// of the node, and return the problems found. It checks that every key in
+// the public keys file is valid base64 of the right length, that the
+// stored hash matches the keys, and that the public signing key of the
+// node can be derived from the private signing key.
+func (n *nodeAuth) validateTrustStore() []error {
+	problems := []error{}
+
+	// Read the file directly instead of using the keys loaded in memory,
+	// so we also check the encoding of the stored keys.
+	b, err := os.ReadFile(n.publicKeys.filePath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		problems = append(problems, fmt.Errorf("error: validateTrustStore: failed to read public keys file: %v", err))
+	default:
+		var stored struct {
+			Keys map[Node]string
+			Hash [32]byte
+		}
+		err := json.Unmarshal(b, &stored)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("error: validateTrustStore: public keys file is not valid: %v", err))
+			break
+		}
+
+		keys := make(map[Node][]byte)
+		for node, k := range stored.Keys {
+			key, err := base64.StdEncoding.DecodeString(k)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("error: validateTrustStore: public key of node %v is not valid base64: %v", node, err))
+				continue
+			}
+			if len(key) != ed25519.PublicKeySize {
+				problems = append(problems, fmt.Errorf("error: validateTrustStore: public key of node %v has length %v, want %v", node, len(key), ed25519.PublicKeySize))
+			}
+			keys[node] = key
+		}
+
+		// A node that have not received any keys from central have no hash.
+		if len(stored.Keys) > 0 && len(keys) == len(stored.Keys) {
+			hash, err := hashPublicKeys(keys)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("error: validateTrustStore: failed to calculate hash of public keys: %v", err))
+			} else if hash != stored.Hash {
+				problems = append(problems, fmt.Errorf("error: validateTrustStore: stored public keys hash does not match the keys, stored: %x, calculated: %x", stored.Hash, hash))
+			}
+		}
+	}
+
+	n.signKeysMu.RLock()
+	defer n.signKeysMu.RUnlock()
+
+	switch {
+	case len(n.SignPrivateKey) != ed25519.PrivateKeySize:
+		problems = append(problems, fmt.Errorf("error: validateTrustStore: private signing key has length %v, want %v", len(n.SignPrivateKey), ed25519.PrivateKeySize))
+	case len(n.SignPublicKey) != ed25519.PublicKeySize:
+		problems = append(problems, fmt.Errorf("error: validateTrustStore: public signing key has length %v, want %v", len(n.SignPublicKey), ed25519.PublicKeySize))
+	default:
+		pub := ed25519.PrivateKey(n.SignPrivateKey).Public().(ed25519.PublicKey)
+		if !pub.Equal(ed25519.PublicKey(n.SignPublicKey)) {
+			problems = append(problems, fmt.Errorf("error: validateTrustStore: public signing key does not match the private signing key"))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,82 @@
+package steward
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// aclEnvInfo is the reply of REQEnvInfo, with the commands a source node
+// is allowed to run on a host. Only the ACL of the source node asking is
+// ever given, never the ACL of the other source nodes.
+type aclEnvInfo struct {
+	Host   Node `json:"host"`
+	Source Node `json:"source"`
+	// The commands explicitly granted, which must match the command
+	// exactly.
+	Explicit []command `json:"explicit"`
+	// The wildcard grants, which are "*" and the regular expressions
+	// flagged with aclRegexPrefix.
+	Wildcard []command `json:"wildcard"`
+	// AllCommands is true if "*" is granted, so any command is allowed.
+	AllCommands bool `json:"allCommands"`
+}
+
+// newAclEnvInfo will sort the commands of the ACL for the source node
+// into the explicit and the wildcard grants.
+func newAclEnvInfo(host Node, source Node, cmds map[command]struct{}) aclEnvInfo {
+	e := aclEnvInfo{
+		Host:     host,
+		Source:   source,
+		Explicit: []command{},
+		Wildcard: []command{},
+	}
+
+	for c := range cmds {
+		_, isRegex, _ := aclRegex(c)
+		switch {
+		case c == "*":
+			e.AllCommands = true
+			e.Wildcard = append(e.Wildcard, c)
+		case isRegex:
+			e.Wildcard = append(e.Wildcard, c)
+		default:
+			e.Explicit = append(e.Explicit, c)
+		}
+	}
+
+	sort.Slice(e.Explicit, func(i, j int) bool { return e.Explicit[i] < e.Explicit[j] })
+	sort.Slice(e.Wildcard, func(i, j int) bool { return e.Wildcard[i] < e.Wildcard[j] })
+
+	return e
+}
+
+// envInfo will return the commands the source node is allowed to run on
+// this node, from the ACL received from central.
+func (n *nodeAcl) envInfo(host Node, source Node) aclEnvInfo {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return newAclEnvInfo(host, source, n.aclAndHash.Acl[source])
+}
+
+// aclEnvInfo will return the commands the source node is allowed to run
+// on the host, from the ACL generated for the host, so the node and
+// command groups are expanded.
+func (c *centralAuth) aclEnvInfo(host Node, source Node) (aclEnvInfo, error) {
+	c.accessLists.schemaGenerated.mu.Lock()
+	generated, ok := c.accessLists.schemaGenerated.GeneratedACLsMap[host]
+	c.accessLists.schemaGenerated.mu.Unlock()
+	if !ok {
+		return newAclEnvInfo(host, source, nil), nil
+	}
+
+	sources := make(map[Node]map[command]struct{})
+	err := cbor.Unmarshal(generated.Data, &sources)
+	if err != nil {
+		return aclEnvInfo{}, fmt.Errorf("failed to unmarshal the generated acl for host %v: %v", host, err)
+	}
+
+	return newAclEnvInfo(host, source, sources[source]), nil
+}
@@ -0,0 +1,55 @@
+package steward
+
+import (
+	"os"
+	"runtime"
+	"time"
+)
+
+// nodeInfoSchemaVersion is the version of the nodeInfo schema. Fields are
+// only added to nodeInfo, so a reader should ignore the fields it does
+// not know, and the version is only increased if a field is changed or
+// removed.
+const nodeInfoSchemaVersion = 1
+
+// nodeInfo is a snapshot of the health of a node, replied by REQNodeInfo.
+type nodeInfo struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Node          Node      `json:"node"`
+	Hostname      string    `json:"hostname"`
+	OS            string    `json:"os"`
+	Arch          string    `json:"arch"`
+	Version       string    `json:"version"`
+	StartedAt     time.Time `json:"startedAt"`
+	// The uptime of steward in seconds.
+	Uptime     int64 `json:"uptime"`
+	Processes  int   `json:"processes"`
+	Goroutines int   `json:"goroutines"`
+}
+
+// newNodeInfo will gather the health of the node from the runtime and
+// the server.
+func newNodeInfo(s *server, now time.Time) nodeInfo {
+	// The hostname is left empty if not found, since the rest of the
+	// snapshot is still useful.
+	hostname, _ := os.Hostname()
+
+	s.processes.active.mu.Lock()
+	procs := len(s.processes.active.procNames)
+	s.processes.active.mu.Unlock()
+
+	n := nodeInfo{
+		SchemaVersion: nodeInfoSchemaVersion,
+		Node:          Node(s.configuration.NodeName),
+		Hostname:      hostname,
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		Version:       s.version,
+		StartedAt:     s.startedAt,
+		Uptime:        int64(now.Sub(s.startedAt).Seconds()),
+		Processes:     procs,
+		Goroutines:    runtime.NumGoroutine(),
+	}
+
+	return n
+}
@@ -0,0 +1,123 @@
+package steward
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The values of the cmp header of the nats messages, telling the
+// compression used for the payload.
+const (
+	compressionHeaderZstd = "z"
+	compressionHeaderGzip = "g"
+	compressionHeaderNone = "none"
+)
+
+// selectCompression will return the cmp header value for the compression
+// to use when publishing a message. The compression of the message, which
+// is "zstd", "gzip" or "none", takes precedence over the compression set
+// for the node with the compression flag, which is "z", "g" or empty. An
+// error is returned if the compression is not known.
+func selectCompression(msgCompression string, confCompression string) (string, error) {
+	switch msgCompression {
+	case "zstd":
+		return compressionHeaderZstd, nil
+	case "gzip":
+		return compressionHeaderGzip, nil
+	case "none":
+		return compressionHeaderNone, nil
+	case "":
+	default:
+		return compressionHeaderNone, fmt.Errorf("unknown compression %q in message, valid values are zstd, gzip and none", msgCompression)
+	}
+
+	switch confCompression {
+	case "z":
+		return compressionHeaderZstd, nil
+	case "g":
+		return compressionHeaderGzip, nil
+	case "":
+		return compressionHeaderNone, nil
+	}
+
+	return compressionHeaderNone, fmt.Errorf("compression type %q not defined", confCompression)
+}
+
+// compressPayload will compress the serialized message with the
+// compression given by the cmp header value. The zstd encoder of the
+// publisher is used if given, and else a new encoder is created.
+func compressPayload(data []byte, cmp string, zEnc *zstd.Encoder) ([]byte, error) {
+	switch cmp {
+	case compressionHeaderZstd:
+		if zEnc == nil {
+			enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			if err != nil {
+				return nil, fmt.Errorf("zstd new encoder failed: %v", err)
+			}
+			defer enc.Close()
+			zEnc = enc
+		}
+		return zEnc.EncodeAll(data, nil), nil
+
+	case compressionHeaderGzip:
+		var buf bytes.Buffer
+		gzipW := gzip.NewWriter(&buf)
+		_, err := gzipW.Write(data)
+		if err != nil {
+			gzipW.Close()
+			return nil, fmt.Errorf("failed to write gzip: %v", err)
+		}
+		err = gzipW.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to close gzip: %v", err)
+		}
+		return buf.Bytes(), nil
+
+	case compressionHeaderNone:
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("unknown compression %q", cmp)
+}
+
+// decompressPayload will decompress the payload of a nats message with
+// the compression given by the cmp header value, to get the serialized
+// message.
+func decompressPayload(data []byte, cmp string) ([]byte, error) {
+	switch cmp {
+	case compressionHeaderZstd:
+		zr, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, fmt.Errorf("zstd NewReader failed: %v", err)
+		}
+		defer zr.Close()
+
+		b, err := zr.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("zstd decoding failed: %v", err)
+		}
+		return b, nil
+
+	case compressionHeaderGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("gzip NewReader failed: %v", err)
+		}
+		defer gr.Close()
+
+		b, err := io.ReadAll(gr)
+		if err != nil {
+			return nil, fmt.Errorf("gzip ReadAll failed: %v", err)
+		}
+		return b, nil
+
+	case compressionHeaderNone:
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("unknown compression %q in message header", cmp)
+}
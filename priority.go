@@ -0,0 +1,324 @@
+package steward
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Priority is the priority tier of a message, deciding the order the
+// messages waiting in the ringbuffer are dispatched in.
+type Priority string
+
+const (
+	PriorityHigh   Priority = "high"
+	PriorityNormal Priority = "normal"
+	PriorityLow    Priority = "low"
+)
+
+// priorityTiers are the tiers in the order they are dispatched.
+var priorityTiers = []Priority{PriorityHigh, PriorityNormal, PriorityLow}
+
+// valid will return true if the priority is one of the known tiers.
+func (p Priority) valid() bool {
+	switch p {
+	case PriorityHigh, PriorityNormal, PriorityLow:
+		return true
+	}
+
+	return false
+}
+
+// defaultPriorityPolicy is the policy used until a policy is set with
+// REQSetPriorityPolicy. Control messages are put ahead of the bulk
+// file writing of replies.
+func defaultPriorityPolicy() map[Method]Priority {
+	return map[Method]Priority{
+		REQDegradedMode:       PriorityHigh,
+		REQFailover:           PriorityHigh,
+		REQCentralChanged:     PriorityHigh,
+		REQShutdownScheduled:  PriorityHigh,
+		REQStreamCommandClose: PriorityHigh,
+		REQLockRelease:        PriorityHigh,
+		REQToFileAppend:       PriorityLow,
+		REQToFile:             PriorityLow,
+		REQThroughputDiscard:  PriorityLow,
+	}
+}
+
+// priorityPolicy holds the priority tiers of the methods, used for
+// the messages that don't specify their own priority. Methods not in
+// the policy have normal priority. The policy is stored in the
+// database folder so it survive a restart of the node.
+type priorityPolicy struct {
+	tiers    map[Method]Priority
+	filePath string
+	mu       sync.Mutex
+}
+
+func newPriorityPolicy(c *Configuration) *priorityPolicy {
+	p := priorityPolicy{
+		tiers:    defaultPriorityPolicy(),
+		filePath: filepath.Join(c.DatabaseFolder, "priority_policy.txt"),
+	}
+
+	err := p.loadFromFile()
+	if err != nil {
+		log.Printf("error: loading priority policy from file: %v\n", err)
+	}
+
+	return &p
+}
+
+// loadFromFile will try to load the currently stored policy from file,
+// and return the error if it fails.
+// If no file is found a nil error is returned.
+func (p *priorityPolicy) loadFromFile() error {
+	if _, err := os.Stat(p.filePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	fh, err := os.OpenFile(p.filePath, os.O_RDONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error: failed to open priority policy file: %v", err)
+	}
+	defer fh.Close()
+
+	b, err := io.ReadAll(fh)
+	if err != nil {
+		return err
+	}
+
+	tiers := make(map[Method]Priority)
+	err = json.Unmarshal(b, &tiers)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tiers = tiers
+
+	return nil
+}
+
+// saveToFile will save the policy to file for persistent storage.
+// An error is returned if it fails.
+func (p *priorityPolicy) saveToFile() error {
+	p.mu.Lock()
+	b, err := json.Marshal(p.tiers)
+	p.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error: failed to marshal priority policy: %v", err)
+	}
+
+	err = os.WriteFile(p.filePath, b, 0600)
+	if err != nil {
+		return fmt.Errorf("error: failed to write priority policy file: %v", err)
+	}
+
+	return nil
+}
+
+// set will replace the current policy with the policy given. An error
+// is returned, and the policy left unchanged, if any of the methods or
+// tiers are not valid.
+func (p *priorityPolicy) set(tiers map[Method]Priority) error {
+	var mt Method
+	ma := mt.GetMethodsAvailable()
+	for m, t := range tiers {
+		if _, ok := ma.CheckIfExists(m); !ok {
+			return fmt.Errorf("no such method: %v", m)
+		}
+		if !t.valid() {
+			return fmt.Errorf("invalid priority %q for method %v, valid priorities are %v", t, m, priorityTiers)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.tiers = make(map[Method]Priority, len(tiers))
+	for m, t := range tiers {
+		p.tiers[m] = t
+	}
+
+	return nil
+}
+
+// get will return a copy of the current policy.
+func (p *priorityPolicy) get() map[Method]Priority {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tiers := make(map[Method]Priority, len(p.tiers))
+	for m, t := range p.tiers {
+		tiers[m] = t
+	}
+
+	return tiers
+}
+
+// resolve will return the priority of the message. The priority set in
+// the message always take precedence over the policy.
+func (p *priorityPolicy) resolve(m Message) Priority {
+	if m.Priority.valid() {
+		return m.Priority
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t, ok := p.tiers[m.Method]; ok {
+		return t
+	}
+
+	return PriorityNormal
+}
+
+// priorityQueue is the queue of the messages waiting in the ringbuffer
+// to be dispatched. Messages with a higher priority are taken from the
+// queue first, and messages with the same priority in the order they
+// were added. A message is aged up one tier for each aging interval it
+// has waited, so the messages with a lower priority are not starved.
+// The queue holds max messages, and adding more will block until there
+// is room.
+type priorityQueue struct {
+	tiers map[Priority][]queuedMessage
+	len   int
+	max   int
+	// The time a message waits before it is aged up one tier. 0
+	// disables the aging.
+	aging time.Duration
+	// now returns the current time, and can be replaced in tests.
+	now func() time.Time
+	mu  sync.Mutex
+	// added and removed are signaled when a message is added to, or
+	// removed from the queue.
+	added   chan struct{}
+	removed chan struct{}
+}
+
+// queuedMessage is a message in the priorityQueue, with the time it
+// was added.
+type queuedMessage struct {
+	value samDBValue
+	added time.Time
+}
+
+func newPriorityQueue(max int, aging time.Duration) *priorityQueue {
+	if max < 1 {
+		max = 1
+	}
+
+	q := priorityQueue{
+		tiers:   make(map[Priority][]queuedMessage),
+		max:     max,
+		aging:   aging,
+		now:     time.Now,
+		added:   make(chan struct{}, 1),
+		removed: make(chan struct{}, 1),
+	}
+
+	return &q
+}
+
+// notifyQueue will wake up the one waiting on the channel, if any.
+func notifyQueue(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// push will add the message to the queue with the priority given. It
+// will block while the queue is full, and return false if the context
+// is done before the message could be added.
+func (q *priorityQueue) push(ctx context.Context, v samDBValue, p Priority) bool {
+	if !p.valid() {
+		p = PriorityNormal
+	}
+
+	for {
+		q.mu.Lock()
+		if q.len < q.max {
+			q.tiers[p] = append(q.tiers[p], queuedMessage{value: v, added: q.now()})
+			q.len++
+			q.mu.Unlock()
+			notifyQueue(q.added)
+			return true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.removed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// pop will take the message with the highest priority from the queue,
+// where the time waited is counted in. It will block while the queue is
+// empty, and return false if the context is done before a message was
+// available.
+func (q *priorityQueue) pop(ctx context.Context) (samDBValue, bool) {
+	for {
+		q.mu.Lock()
+		if q.len > 0 {
+			now := q.now()
+			next := Priority("")
+			nextRank := 0
+			for i, p := range priorityTiers {
+				if len(q.tiers[p]) == 0 {
+					continue
+				}
+
+				// Only the first message of a tier have to be checked,
+				// since it has waited the longest.
+				rank := i
+				if q.aging > 0 {
+					rank -= int(now.Sub(q.tiers[p][0].added) / q.aging)
+				}
+				if rank < 0 {
+					rank = 0
+				}
+
+				// With the same rank the message that has waited the
+				// longest goes first.
+				if next == "" || rank < nextRank || (rank == nextRank && q.tiers[p][0].added.Before(q.tiers[next][0].added)) {
+					next = p
+					nextRank = rank
+				}
+			}
+
+			v := q.tiers[next][0].value
+			q.tiers[next] = q.tiers[next][1:]
+			q.len--
+			q.mu.Unlock()
+			notifyQueue(q.removed)
+			return v, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.added:
+		case <-ctx.Done():
+			return samDBValue{}, false
+		}
+	}
+}
+
+// length will return the number of messages in the queue.
+func (q *priorityQueue) length() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.len
+}
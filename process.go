@@ -2,20 +2,18 @@ package steward
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/ed25519"
 	"encoding/gob"
 	"fmt"
-	"io"
-	"log"
 	"os"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/klauspost/compress/zstd"
-	"github.com/nats-io/nats.go"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -36,6 +34,9 @@ type process struct {
 	server *server
 	// messageID
 	messageID int
+	// lastActivity is the time the process last sent or received a
+	// message.
+	lastActivity time.Time
 	// the subject used for the specific process. One process
 	// can contain only one sender on a message bus, hence
 	// also one subject
@@ -85,10 +86,11 @@ type process struct {
 	toRingbufferCh chan<- []subjectAndMessage
 	// The structure who holds all processes information
 	processes *processes
-	// nats connection
-	natsConn *nats.Conn
-	// natsSubscription returned when calling natsConn.Subscribe
-	natsSubscription *nats.Subscription
+	// The transport used to deliver the messages, which is nats by
+	// default.
+	transport transport
+	// subscription returned when calling transport.queueSubscribe
+	subscription subscription
 	// context
 	ctx context.Context
 	// context cancelFunc
@@ -107,6 +109,18 @@ type process struct {
 	errorKernel *errorKernel
 	// metrics
 	metrics *metrics
+	// handlersInFlight is the number of subscriber handler go routines
+	// currently running for the process. It is a pointer so it is
+	// shared between the copies of the process.
+	handlersInFlight *int64
+	// handlerWork is the background work started by the handler of the
+	// message currently being handled, which is counted against the
+	// concurrency limit of the method. Only set for the copy of the
+	// process given to a subscriber handler.
+	handlerWork *sync.WaitGroup
+	// publisherDone is closed when the publishMessages go routine of a
+	// publisher process have returned.
+	publisherDone chan struct{}
 }
 
 // prepareNewProcess will set the the provided values and the default
@@ -130,7 +144,7 @@ func newProcess(ctx context.Context, server *server, subject Subject, processKin
 		toRingbufferCh:   server.toRingBufferCh,
 		configuration:    server.configuration,
 		processes:        server.processes,
-		natsConn:         server.natsConn,
+		transport:        server.transport,
 		ctx:              ctx,
 		ctxCancel:        cancel,
 		startup:          newStartup(server),
@@ -138,6 +152,8 @@ func newProcess(ctx context.Context, server *server, subject Subject, processKin
 		centralAuth:      server.centralAuth,
 		errorKernel:      server.errorKernel,
 		metrics:          server.metrics,
+		handlersInFlight: new(int64),
+		publisherDone:    make(chan struct{}),
 	}
 
 	return proc
@@ -151,6 +167,19 @@ func newProcess(ctx context.Context, server *server, subject Subject, processKin
 // It will give the process the next available ID, and also add the
 // process to the processes map in the server structure.
 func (p process) spawnWorker() {
+	p = p.startWorker()
+
+	// Add information about the new process to the started processes map.
+	p.processes.active.mu.Lock()
+	p.processes.active.procNames[p.processName] = p
+	p.processes.active.mu.Unlock()
+}
+
+// startWorker will start the publisher or subscriber worker of the
+// process, and return the process with the values set when started.
+// The process is not added to the processes map, so the caller can
+// choose when and how to register it.
+func (p process) startWorker() process {
 	// We use the full name of the subject to identify a unique
 	// process. We can do that since a process can only handle
 	// one message queue.
@@ -188,7 +217,9 @@ func (p process) spawnWorker() {
 			}()
 		}
 
-		go p.publishMessages(p.natsConn)
+		// Use the same connection for all the messages of the publisher,
+		// so the ACK's are subscribed to on the connection publishing.
+		go p.publishMessages(pickTransport(p.transport))
 	}
 
 	// Start a subscriber worker, which will start a go routine (process)
@@ -211,31 +242,62 @@ func (p process) spawnWorker() {
 			}()
 		}
 
-		p.natsSubscription = p.subscribeMessages()
+		p.subscription = p.subscribeMessages()
 	}
 
 	p.processName = pn
 
-	// Add information about the new process to the started processes map.
-	p.processes.active.mu.Lock()
-	p.processes.active.procNames[pn] = p
-	p.processes.active.mu.Unlock()
+	return p
 }
 
-// messageDeliverNats will create the Nats message with headers and payload.
+// messageDeliver will create the transport message with headers and payload.
 // It will also take care of the delivering the message that is converted to
-// gob or cbor format as a nats.Message. It will also take care of checking
-// timeouts and retries specified for the message.
-func (p process) messageDeliverNats(natsMsgPayload []byte, natsMsgHeader nats.Header, natsConn *nats.Conn, message Message) {
-	retryAttempts := 0
+// gob or cbor format as a transportMsg. It will also take care of checking
+// timeouts and retries specified for the message. If resign is given it
+// is called for each retry to get the payload signed again.
+func (p process) messageDeliver(natsMsgPayload []byte, natsMsgHeader map[string][]string, t transport, message Message, resign func() ([]byte, error)) {
+	// Continue counting from the attempts done before a restart.
+	retryAttempts := message.deliveryAttempts
 
 	const publishTimer time.Duration = 5
 	const subscribeSyncTimer time.Duration = 5
 
+	// Keep track of the state of the delivery while retrying, so it can
+	// be inspected with REQInspectRetryState.
+	var retryID uint64
+	if p.subject.Event != EventNACK {
+		retryID = p.server.retryRegistry.add(message, string(p.subject.name()))
+		defer p.server.retryRegistry.remove(retryID)
+	}
+
+	deadline, expires := messageDeadline(message, time.Now())
+
 	// The for loop will run until the message is delivered successfully,
 	// or that retries are reached.
-	for {
-		msg := &nats.Msg{
+	for attempt := 0; ; attempt++ {
+		// Drop the message if it expired while waiting to be sent, or
+		// while retrying.
+		if expires && !time.Now().Before(deadline) {
+			er := fmt.Errorf("error: messageDeliver: message expired at %v, dropping it, toNode: %v, method: %v, attempts: %v", time.Unix(0, message.ExpiresAt).Format(time.RFC3339), message.ToNode, message.Method, retryAttempts)
+			p.errorKernel.errSend(p, message, er)
+			p.server.logger.logf(logLevelError, procLogFields(p, message), "%v\n", er)
+			p.metrics.promMessagesExpiredTotal.Inc()
+			return
+		}
+
+		// Sign the message again when retrying, so the time it was
+		// signed is not older than the receiver allows.
+		if attempt > 0 && resign != nil {
+			b, err := resign()
+			if err != nil {
+				er := fmt.Errorf("error: messageDeliver: failed to sign the message again, retrying with the previous signature: %v", err)
+				p.server.logger.logf(logLevelError, procLogFields(p, message), "%v\n", er)
+			} else {
+				natsMsgPayload = b
+			}
+		}
+
+		msg := &transportMsg{
 			Subject: string(p.subject.name()),
 			// Subject: fmt.Sprintf("%s.%s.%s", proc.node, "command", "CLICommandRequest"),
 			// Structure of the reply message are:
@@ -248,10 +310,10 @@ func (p process) messageDeliverNats(natsMsgPayload []byte, natsMsgHeader nats.He
 		// If it is a NACK message we just deliver the message and return
 		// here so we don't create a ACK message and then stop waiting for it.
 		if p.subject.Event == EventNACK {
-			err := natsConn.PublishMsg(msg)
+			err := t.publish(msg)
 			if err != nil {
 				er := fmt.Errorf("error: nats publish of hello failed: %v", err)
-				log.Printf("%v\n", er)
+				p.server.logger.logf(logLevelError, procLogFields(p, message), "%v\n", er)
 				return
 			}
 			p.metrics.promNatsDeliveredTotal.Inc()
@@ -262,24 +324,26 @@ func (p process) messageDeliverNats(natsMsgPayload []byte, natsMsgHeader nats.He
 		// are sent after it started subscribing.
 		//
 		// Create a subscriber for the ACK reply message.
-		subReply, err := natsConn.SubscribeSync(msg.Reply)
+		subReply, err := t.subscribeSync(msg.Reply)
 		if err != nil {
 			er := fmt.Errorf("error: nats SubscribeSync failed: failed to create reply message for subject: %v, error: %v", msg.Reply, err)
 			// sendErrorLogMessage(p.toRingbufferCh, node(p.node), er)
-			log.Printf("%v, waiting %ds before retrying\n", er, subscribeSyncTimer)
+			p.server.logger.logf(logLevelError, procLogFields(p, message), "%v, waiting %ds before retrying\n", er, subscribeSyncTimer)
+			p.server.retryRegistry.update(retryID, retryAttempts, time.Now().Add(time.Second*subscribeSyncTimer), er)
 			time.Sleep(time.Second * subscribeSyncTimer)
-			subReply.Unsubscribe()
+			subReply.unsubscribe()
 			continue
 		}
 
 		// Publish message
-		err = natsConn.PublishMsg(msg)
+		err = t.publish(msg)
 		if err != nil {
 			er := fmt.Errorf("error: nats publish failed: %v", err)
 			// sendErrorLogMessage(p.toRingbufferCh, node(p.node), er)
-			log.Printf("%v, waiting %ds before retrying\n", er, publishTimer)
+			p.server.logger.logf(logLevelError, procLogFields(p, message), "%v, waiting %ds before retrying\n", er, publishTimer)
+			p.server.retryRegistry.update(retryID, retryAttempts, time.Now().Add(time.Second*publishTimer), er)
 			time.Sleep(time.Second * publishTimer)
-			subReply.Unsubscribe()
+			subReply.unsubscribe()
 			continue
 		}
 
@@ -289,19 +353,31 @@ func (p process) messageDeliverNats(natsMsgPayload []byte, natsMsgHeader nats.He
 			// Wait up until ACKTimeout specified for a reply,
 			// continue and resend if no reply received,
 			// or exit if max retries for the message reached.
-			_, err := subReply.NextMsg(time.Second * time.Duration(message.ACKTimeout))
+			p.server.retryRegistry.update(retryID, retryAttempts, time.Now().Add(time.Second*time.Duration(message.ACKTimeout)), nil)
+			_, err := subReply.nextMsg(time.Second * time.Duration(message.ACKTimeout))
 			if err != nil {
 				er := fmt.Errorf("error: ack receive failed: subject=%v: %v", p.subject.name(), err)
 				// sendErrorLogMessage(p.toRingbufferCh, p.node, er)
 				p.errorKernel.logConsoleOnlyIfDebug(er, p.configuration)
 
-				if err == nats.ErrNoResponders {
+				// Register the failed attempt before waiting, so the error
+				// can be seen while waiting to retry.
+				nextRetry := time.Now()
+				if err == errNoResponders {
+					nextRetry = nextRetry.Add(time.Second * time.Duration(message.ACKTimeout))
+				}
+				p.server.retryRegistry.update(retryID, retryAttempts+1, nextRetry, er)
+
+				if err == errNoResponders {
 					// fmt.Printf(" * DEBUG: Waiting, ACKTimeout: %v\n", message.ACKTimeout)
 					time.Sleep(time.Second * time.Duration(message.ACKTimeout))
 				}
 
 				// did not receive a reply, decide what to do..
 				retryAttempts++
+				if message.attemptFailed != nil {
+					message.attemptFailed(retryAttempts)
+				}
 				er = fmt.Errorf("retry attempt:%v, retries: %v, ack timeout: %v, message.ID: %v", retryAttempts, message.Retries, message.ACKTimeout, message.ID)
 				p.errorKernel.logConsoleOnlyIfDebug(er, p.configuration)
 
@@ -315,11 +391,15 @@ func (p process) messageDeliverNats(natsMsgPayload []byte, natsMsgHeader nats.He
 
 					// We do not want to send errorLogs for REQErrorLog type since
 					// it will just cause an endless loop.
+					// The failed message is put in the dead letter store so it
+					// can be inspected later. Error logs are not stored since
+					// they would fill up the store when the central is down.
 					if message.Method != REQErrorLog {
 						p.errorKernel.infoSend(p, message, er)
+						p.server.deadLetters.add(message, retryAttempts, err.Error())
 					}
 
-					subReply.Unsubscribe()
+					subReply.unsubscribe()
 
 					p.metrics.promNatsMessagesFailedACKsTotal.Inc()
 					return
@@ -331,14 +411,14 @@ func (p process) messageDeliverNats(natsMsgPayload []byte, natsMsgHeader nats.He
 
 					p.metrics.promNatsMessagesMissedACKsTotal.Inc()
 
-					subReply.Unsubscribe()
+					subReply.unsubscribe()
 					continue
 				}
 			}
 			// REMOVED: log.Printf("<--- publisher: received ACK from:%v, for: %v, data: %s\n", message.ToNode, message.Method, msgReply.Data)
 		}
 
-		subReply.Unsubscribe()
+		subReply.unsubscribe()
 
 		p.metrics.promNatsDeliveredTotal.Inc()
 
@@ -346,6 +426,25 @@ func (p process) messageDeliverNats(natsMsgPayload []byte, natsMsgHeader nats.He
 	}
 }
 
+// recordActivity will count a message received by a subscriber process,
+// and set the time of its last activity, in the processes map. For a
+// subscriber the messageID is the number of messages received.
+func (p process) recordActivity() {
+	pn := processNameGet(p.subject.name(), processKindSubscriber)
+
+	p.processes.active.mu.Lock()
+	defer p.processes.active.mu.Unlock()
+
+	// Only update the process if it have not been replaced by a restart.
+	current, ok := p.processes.active.procNames[pn]
+	if !ok || current.processID != p.processID {
+		return
+	}
+	current.messageID++
+	current.lastActivity = time.Now()
+	p.processes.active.procNames[pn] = current
+}
+
 // messageSubscriberHandler will deserialize the message when a new message is
 // received, check the MessageType field in the message to decide what
 // kind of message it is and then it will check how to handle that message type,
@@ -356,7 +455,7 @@ func (p process) messageDeliverNats(natsMsgPayload []byte, natsMsgHeader nats.He
 // the state of the message being processed, and then reply back to the
 // correct sending process's reply, meaning so we ACK back to the correct
 // publisher.
-func (p process) messageSubscriberHandler(natsConn *nats.Conn, thisNode string, msg *nats.Msg, subject string) {
+func (p process) messageSubscriberHandler(t transport, thisNode string, msg *transportMsg, subject string) {
 
 	// Variable to hold a copy of the message data, so we don't mess with
 	// the original data since the original is a pointer value.
@@ -374,45 +473,15 @@ func (p process) messageSubscriberHandler(natsConn *nats.Conn, thisNode string,
 	// If compression is used, decompress it to get the gob data. If
 	// compression is not used it is the gob encoded data we already
 	// got in msgData so we do nothing with it.
-	if val, ok := msg.Header["cmp"]; ok {
-		switch val[0] {
-		case "z":
-			zr, err := zstd.NewReader(nil)
-			if err != nil {
-				er := fmt.Errorf("error: zstd NewReader failed: %v", err)
-				p.errorKernel.errSend(p, Message{}, er)
-				return
-			}
-			msgData, err = zr.DecodeAll(msg.Data, nil)
-			if err != nil {
-				er := fmt.Errorf("error: zstd decoding failed: %v", err)
-				p.errorKernel.errSend(p, Message{}, er)
-				zr.Close()
-				return
-			}
-
-			zr.Close()
-
-		case "g":
-			r := bytes.NewReader(msgData)
-			gr, err := gzip.NewReader(r)
-			if err != nil {
-				er := fmt.Errorf("error: gzip NewReader failed: %v", err)
-				p.errorKernel.errSend(p, Message{}, er)
-				return
-			}
-
-			b, err := io.ReadAll(gr)
-			if err != nil {
-				er := fmt.Errorf("error: gzip ReadAll failed: %v", err)
-				p.errorKernel.errSend(p, Message{}, er)
-				return
-			}
-
-			gr.Close()
-
-			msgData = b
+	if val, ok := msg.Header["cmp"]; ok && len(val) > 0 {
+		b, err := decompressPayload(msgData, val[0])
+		if err != nil {
+			er := fmt.Errorf("error: subscriberHandler: %v, subject: %v", err, subject)
+			p.errorKernel.errSend(p, Message{}, er)
+			return
 		}
+
+		msgData = b
 	}
 
 	message := Message{}
@@ -454,6 +523,8 @@ func (p process) messageSubscriberHandler(natsConn *nats.Conn, thisNode string,
 		}
 	}
 
+	p.recordActivity()
+
 	// Send final reply for a relayed message back to the originating node.
 	//
 	// Check if the previous message was a relayed message, and if true
@@ -520,14 +591,29 @@ func (p process) messageSubscriberHandler(natsConn *nats.Conn, thisNode string,
 			p.errorKernel.errSend(p, message, er)
 		}
 
-		//var err error
+		// If the message was already received the ACK was probably lost,
+		// and the publisher resent it. Resend the ACK instead of running
+		// the handler again.
+		key := dedupKey(message)
+		if ack, handled, dup := p.server.dedupCache.check(key, message.Method, time.Now()); dup {
+			er := fmt.Errorf("info: subscriberHandler: duplicate message %v from %v with method %v, not handled again", message.ID, message.FromNode, message.Method)
+			p.errorKernel.logConsoleOnlyIfDebug(er, p.configuration)
+
+			// If the first one is still being handled the ACK is sent
+			// when it is done.
+			if handled {
+				t.publish(&transportMsg{Subject: msg.Reply, Data: ack})
+			}
+			return
+		}
 
 		out := p.callHandler(message, mh, thisNode)
+		p.server.dedupCache.done(key, out)
 
 		// Send a confirmation message back to the publisher to ACK that the
 		// message was received by the subscriber. The reply should be sent
 		//no matter if the handler was executed successfully or not
-		natsConn.Publish(msg.Reply, out)
+		t.publish(&transportMsg{Subject: msg.Reply, Data: out})
 
 	case p.subject.Event == EventNACK:
 		mh, ok := p.methodsAvailable.CheckIfExists(message.Method)
@@ -553,24 +639,90 @@ func (p process) callHandler(message Message, mh methodHandler, thisNode string)
 	out := []byte{}
 	var err error
 
+	// The method was not found, and the error was already sent when
+	// looking it up.
+	if mh == nil {
+		return out
+	}
+
+	// Don't act on a message that expired before it got here, like an
+	// old command delivered after a network partition is healed.
+	if messageExpired(message, time.Now()) {
+		er := fmt.Errorf("error: subscriberHandler: message expired at %v, dropping it, fromNode: %v, method: %v", time.Unix(0, message.ExpiresAt).Format(time.RFC3339), message.FromNode, message.Method)
+		p.errorKernel.errSend(p, message, er)
+		p.server.logger.logf(logLevelError, procLogFields(p, message), "%v\n", er)
+		p.metrics.promMessagesExpiredTotal.Inc()
+
+		return out
+	}
+
+	// When the node is in degraded mode only the read-only methods, and
+	// the methods needed to stop what is running, are allowed.
+	if !p.server.degradedMode.allows(mh, message.Method) {
+		er := fmt.Errorf("error: subscriberHandler: node is in degraded mode, refusing method: %v", message.Method)
+		p.errorKernel.errSend(p, message, er)
+		p.server.logger.logf(logLevelError, procLogFields(p, message), "%v\n", er)
+
+		return out
+	}
+
+	// Drop the message if the node sending it is flooding us. Only the
+	// first drop is reported, so the flood is not passed on to central.
+	if ok, report := p.server.inboundRateLimits.allow(message.FromNode, time.Now()); !ok {
+		p.metrics.promInboundRateLimitedTotal.WithLabelValues(rateLimitedLabel(p.nodeAuth.publicKeys, message.FromNode)).Inc()
+		er := fmt.Errorf("error: subscriberHandler: rate limit exceeded for node %v, dropping messages until the rate is below the limit, method: %v", message.FromNode, message.Method)
+		if report {
+			p.errorKernel.errSend(p, message, er)
+			p.server.logger.logf(logLevelError, procLogFields(p, message), "%v\n", er)
+		}
+
+		return out
+	}
+
 	switch p.verifySigOrAclFlag(message) {
 	case true:
-		log.Printf("info: subscriberHandler: doHandler=true: %v\n", true)
-		out, err = mh.handler(p, message, thisNode)
+		p.server.logger.logf(logLevelInfo, procLogFields(p, message), "info: subscriberHandler: doHandler=true: %v\n", true)
+		out, err = p.runHandler(message, mh, thisNode)
 		if err != nil {
 			er := fmt.Errorf("error: subscriberHandler: handler method failed: %v", err)
 			p.errorKernel.errSend(p, message, er)
-			log.Printf("%v\n", er)
+			p.server.logger.logf(logLevelError, procLogFields(p, message), "%v\n", er)
+		}
+
+		if dropped := p.server.messageCallbacks.dispatch(message, out); dropped > 0 {
+			er := fmt.Errorf("error: subscriberHandler: message callback queue full, dropped %v callback calls for method: %v", dropped, message.Method)
+			p.errorKernel.errSend(p, message, er)
 		}
 	default:
 		er := fmt.Errorf("error: subscriberHandler: doHandler=false, doing nothing")
 		p.errorKernel.errSend(p, message, er)
-		log.Printf("%v\n", er)
+		p.server.logger.logf(logLevelError, procLogFields(p, message), "%v\n", er)
 	}
 
 	return out
 }
 
+// runHandler will run the handler of the method, and record its
+// execution time in the metrics. A panic in the handler is recovered,
+// counted in the metrics, and returned as an error with the stack trace,
+// so it does not take down the node. The output is then an error
+// message, so the publisher still gets a reply.
+func (p process) runHandler(message Message, mh methodHandler, thisNode string) (out []byte, err error) {
+	start := time.Now()
+
+	defer func() {
+		p.metrics.promMethodHandlerDuration.WithLabelValues(string(message.Method)).Observe(time.Since(start).Seconds())
+
+		if r := recover(); r != nil {
+			p.metrics.promMethodHandlerPanicsTotal.WithLabelValues(string(message.Method)).Inc()
+			out = []byte("error from: " + thisNode + ": " + fmt.Sprint(message.ID) + ": handler panicked")
+			err = fmt.Errorf("handler for method %v panicked: %v\n%s", message.Method, r, debug.Stack())
+		}
+	}()
+
+	return mh.handler(p, message, thisNode)
+}
+
 // verifySigOrAclFlag will do signature and/or acl checking based on which of
 // those features are enabled, and then call the handler.
 // The handler will also be called if neither signature or acl checking is enabled
@@ -583,14 +735,14 @@ func (p process) verifySigOrAclFlag(message Message) bool {
 
 	// If no checking enabled we should just allow the message.
 	case !p.nodeAuth.configuration.EnableSignatureCheck && !p.nodeAuth.configuration.EnableAclCheck:
-		log.Printf(" * DEBUG: verify acl/sig: no acl or signature checking at all is enabled, ALLOW the message, method=%v\n", message.Method)
+		p.server.logger.logf(logLevelDebug, procLogFields(p, message), " * DEBUG: verify acl/sig: no acl or signature checking at all is enabled, ALLOW the message, method=%v\n", message.Method)
 		doHandler = true
 
 	// If only sig check enabled, and sig OK, we should allow the message.
 	case p.nodeAuth.configuration.EnableSignatureCheck && !p.nodeAuth.configuration.EnableAclCheck:
 		sigOK := p.nodeAuth.verifySignature(message)
 
-		log.Printf(" * DEBUG: verify acl/sig: Only signature checking enabled, ALLOW the message if sigOK, sigOK=%v, method %v\n", sigOK, message.Method)
+		p.server.logger.logf(logLevelDebug, procLogFields(p, message), " * DEBUG: verify acl/sig: Only signature checking enabled, ALLOW the message if sigOK, sigOK=%v, method %v\n", sigOK, message.Method)
 
 		if sigOK {
 			doHandler = true
@@ -601,7 +753,7 @@ func (p process) verifySigOrAclFlag(message Message) bool {
 		sigOK := p.nodeAuth.verifySignature(message)
 		aclOK := p.nodeAuth.verifyAcl(message)
 
-		log.Printf(" * DEBUG: verify acl/sig:both signature and acl checking enabled, allow the message if sigOK and aclOK, or method is not REQCliCommand, sigOK=%v, aclOK=%v, method=%v\n", sigOK, aclOK, message.Method)
+		p.server.logger.logf(logLevelDebug, procLogFields(p, message), " * DEBUG: verify acl/sig:both signature and acl checking enabled, allow the message if sigOK and aclOK, or method do not run commands, sigOK=%v, aclOK=%v, method=%v\n", sigOK, aclOK, message.Method)
 
 		if sigOK && aclOK {
 			doHandler = true
@@ -610,36 +762,93 @@ func (p process) verifySigOrAclFlag(message Message) bool {
 		// none of the verification options matched, we should keep the default value
 		// of doHandler=false, so the handler is not done.
 	default:
-		log.Printf(" * DEBUG: verify acl/sig: None of the verify flags matched, not doing handler for message, method=%v\n", message.Method)
+		p.server.logger.logf(logLevelDebug, procLogFields(p, message), " * DEBUG: verify acl/sig: None of the verify flags matched, not doing handler for message, method=%v\n", message.Method)
 	}
 
 	return doHandler
 }
 
-// SubscribeMessage will register the Nats callback function for the specified
-// nats subject. This allows us to receive Nats messages for a given subject
-// on a node.
-func (p process) subscribeMessages() *nats.Subscription {
+// SubscribeMessage will register the callback function for the specified
+// subject on the transport. This allows us to receive messages for a given
+// subject on a node.
+func (p process) subscribeMessages() subscription {
 	subject := string(p.subject.name())
-	// natsSubscription, err := p.natsConn.Subscribe(subject, func(msg *nats.Msg) {
-	natsSubscription, err := p.natsConn.QueueSubscribe(subject, subject, func(msg *nats.Msg) {
-		//_, err := p.natsConn.Subscribe(subject, func(msg *nats.Msg) {
+	sub, err := p.transport.queueSubscribe(subject, subject, func(msg *transportMsg) {
+
+		// Wait for a free slot if the number of messages handled at the
+		// same time is limited for the method. Waiting here blocks the
+		// callback, so the messages above the limit are queued by the
+		// transport.
+		release, ok := p.server.concurrencyLimits.acquire(p.subject.Method, p.ctx.Done())
+		if !ok {
+			return
+		}
 
-		// Start up the subscriber handler.
-		go p.messageSubscriberHandler(p.natsConn, p.configuration.NodeName, msg, subject)
+		// Start up the subscriber handler, and keep track of how many
+		// are running at the same time.
+		atomic.AddInt64(p.handlersInFlight, 1)
+		inFlight := p.metrics.promSubscriberHandlersInFlight.With(prometheus.Labels{"method": string(p.subject.Method)})
+		inFlight.Inc()
+
+		pc := p
+		pc.handlerWork = &sync.WaitGroup{}
+		go func() {
+			defer release()
+			defer inFlight.Dec()
+
+			pc.messageSubscriberHandler(pc.transport, pc.configuration.NodeName, msg, subject)
+			atomic.AddInt64(p.handlersInFlight, -1)
+
+			// Keep the slot until the background work started by the
+			// handler is done.
+			pc.handlerWork.Wait()
+		}()
 	})
 	if err != nil {
-		log.Printf("error: Subscribe failed: %v\n", err)
+		p.server.logger.logf(logLevelError, procLogFields(p, Message{}), "error: Subscribe failed: %v\n", err)
 		return nil
 	}
 
-	return natsSubscription
+	return sub
+}
+
+// drained will return true if the subscriber have no handlers running,
+// or the publisher have stopped sending messages.
+func (p process) drained() bool {
+	switch p.processKind {
+	case processKindSubscriber:
+		return p.handlersInFlight == nil || atomic.LoadInt64(p.handlersInFlight) == 0
+	case processKindPublisher:
+		select {
+		case <-p.publisherDone:
+			return true
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// startHandlerWork will register background work started by the handler
+// of the current message, so it is counted against the concurrency limit
+// of the method until the returned func is called. It must be called
+// before the handler returns.
+func (p process) startHandlerWork() func() {
+	if p.handlerWork == nil {
+		return func() {}
+	}
+
+	p.handlerWork.Add(1)
+	return p.handlerWork.Done
 }
 
 // publishMessages will do the publishing of messages for one single
 // process. The function should be run as a goroutine, and will run
 // as long as the process it belongs to is running.
-func (p process) publishMessages(natsConn *nats.Conn) {
+func (p process) publishMessages(t transport) {
+	defer close(p.publisherDone)
+
 	var once sync.Once
 
 	var zEnc *zstd.Encoder
@@ -651,7 +860,7 @@ func (p process) publishMessages(natsConn *nats.Conn) {
 		// enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
 		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
 		if err != nil {
-			log.Printf("error: zstd new encoder failed: %v\n", err)
+			p.server.logger.logf(logLevelError, procLogFields(p, Message{}), "error: zstd new encoder failed: %v\n", err)
 			os.Exit(1)
 		}
 		zEnc = enc
@@ -669,64 +878,97 @@ func (p process) publishMessages(natsConn *nats.Conn) {
 		// exit this function if Cancel are received via ctx.
 		select {
 		case m := <-p.subject.messageCh:
-			// Sign the methodArgs, and add the signature to the message.
-			m.ArgSignature = p.addMethodArgSignature(m)
+			// Sign the message, and add the signature to the message.
+			err := p.signMessage(&m)
+			if err != nil {
+				er := fmt.Errorf("error: publishMessages: %v", err)
+				p.errorKernel.errSend(p, m, er)
+				continue
+			}
+			m.DeliveryID = m.SignNonce
+
+			// Stamp the time a ping is sent, so the round-trip latency
+			// can be found when the pong comes back.
+			if m.Method == REQPing {
+				m.PingSentAt = p.server.monotonicNow().UnixNano()
+			}
 			// fmt.Printf(" * DEBUG: add signature, fromNode: %v, method: %v,  len of signature: %v\n", m.FromNode, m.Method, len(m.ArgSignature))
 
-			go p.publishAMessage(m, zEnc, once, natsConn)
+			go p.publishAMessage(m, zEnc, once, t)
 		case <-p.ctx.Done():
 			er := fmt.Errorf("info: canceling publisher: %v", p.subject.name())
 			//sendErrorLogMessage(p.toRingbufferCh, Node(p.node), er)
-			log.Printf("%v\n", er)
+			p.server.logger.logf(logLevelInfo, procLogFields(p, Message{}), "%v\n", er)
 			return
 		}
 	}
 }
 
-func (p process) addMethodArgSignature(m Message) []byte {
-	argsString := argsToString(m.MethodArgs)
-	sign := ed25519.Sign(p.nodeAuth.SignPrivateKey, []byte(argsString))
+// signMessage will set the time and nonce of the message, and sign it.
+// The time and nonce are set first so they are part of the signature.
+func (p process) signMessage(m *Message) error {
+	nonce, err := newSignNonce()
+	if err != nil {
+		return err
+	}
 
-	return sign
+	m.SignedAt = time.Now().Unix()
+	m.SignNonce = nonce
+	m.ArgSignature = p.addMethodArgSignature(*m)
+
+	return nil
 }
 
-func (p process) publishAMessage(m Message, zEnc *zstd.Encoder, once sync.Once, natsConn *nats.Conn) {
-	// Create the initial header, and set values below depending on the
-	// various configuration options chosen.
-	natsMsgHeader := make(nats.Header)
-	natsMsgHeader["fromNode"] = []string{string(p.node)}
+func (p process) addMethodArgSignature(m Message) []byte {
+	_, priv := p.nodeAuth.signingKeys()
+	sign := ed25519.Sign(priv, []byte(signedString(m)))
 
-	// The serialized value of the nats message payload
-	var natsMsgPayloadSerialized []byte
+	return sign
+}
 
-	// encode the message structure into gob binary format before putting
-	// it into a nats message.
-	// Prepare a gob encoder with a buffer before we start the loop
+// serializeMessage will serialize the message with the serialization
+// chosen in the configuration, and return the serialized message and
+// the name of the serialization used.
+func (p process) serializeMessage(m Message) ([]byte, string, error) {
 	switch p.configuration.Serialization {
 	case "cbor":
 		b, err := cbor.Marshal(m)
 		if err != nil {
-			er := fmt.Errorf("error: messageDeliverNats: cbor encode message failed: %v", err)
-			p.errorKernel.errSend(p, m, er)
-			return
+			return nil, "", fmt.Errorf("cbor encode message failed: %v", err)
 		}
 
-		natsMsgPayloadSerialized = b
-		natsMsgHeader["serial"] = []string{p.configuration.Serialization}
+		return b, p.configuration.Serialization, nil
 
 	default:
 		var bufGob bytes.Buffer
 		gobEnc := gob.NewEncoder(&bufGob)
 		err := gobEnc.Encode(m)
 		if err != nil {
-			er := fmt.Errorf("error: messageDeliverNats: gob encode message failed: %v", err)
-			p.errorKernel.errSend(p, m, er)
-			return
+			return nil, "", fmt.Errorf("gob encode message failed: %v", err)
 		}
 
-		natsMsgPayloadSerialized = bufGob.Bytes()
-		natsMsgHeader["serial"] = []string{"gob"}
+		return bufGob.Bytes(), "gob", nil
+	}
+}
+
+func (p process) publishAMessage(m Message, zEnc *zstd.Encoder, once sync.Once, t transport) {
+	// Create the initial header, and set values below depending on the
+	// various configuration options chosen.
+	natsMsgHeader := make(map[string][]string)
+	natsMsgHeader["fromNode"] = []string{string(p.node)}
+
+	// The serialized value of the nats message payload
+	natsMsgPayloadSerialized, serial, err := p.serializeMessage(m)
+	if err != nil {
+		er := fmt.Errorf("error: messageDeliver: %v", err)
+		p.errorKernel.errSend(p, m, er)
+		return
 	}
+	natsMsgHeader["serial"] = []string{serial}
+
+	// Keep a copy of the message as it was serialized, so it can be
+	// signed and serialized again when retrying.
+	signed := m
 
 	// Get the process name so we can look up the process in the
 	// processes map, and increment the message counter.
@@ -735,59 +977,62 @@ func (p process) publishAMessage(m Message, zEnc *zstd.Encoder, once sync.Once,
 
 	// The compressed value of the nats message payload. The content
 	// can either be compressed or in it's original form depening on
-	// the outcome of the switch below, and if compression were chosen
-	// or not.
+	// the compression selected below.
 	var natsMsgPayloadCompressed []byte
 
-	// Compress the data payload if selected with configuration flag.
+	// Compress the data payload if selected with the compression field
+	// of the message, or with the configuration flag.
 	// The compression chosen is later set in the nats msg header when
-	// calling p.messageDeliverNats below.
-	switch p.configuration.Compression {
-	case "z": // zstd
-		natsMsgPayloadCompressed = zEnc.EncodeAll(natsMsgPayloadSerialized, nil)
-		natsMsgHeader["cmp"] = []string{p.configuration.Compression}
-
-		// p.zEncMutex.Lock()
-		// zEnc.Reset(nil)
-		// p.zEncMutex.Unlock()
-
-	case "g": // gzip
-		var buf bytes.Buffer
-		gzipW := gzip.NewWriter(&buf)
-		_, err := gzipW.Write(natsMsgPayloadSerialized)
-		if err != nil {
-			log.Printf("error: failed to write gzip: %v\n", err)
-			gzipW.Close()
-			return
+	// calling p.messageDeliver below.
+	cmp, err := selectCompression(m.Compression, p.configuration.Compression)
+	if err != nil {
+		er := fmt.Errorf("error: publishing: %v, setting default to no compression", err)
+
+		switch m.Compression {
+		case "":
+			// Allways log the error to console.
+			p.server.logger.logf(logLevelError, procLogFields(p, m), "%v\n", er)
+
+			// The configuration is the same for all the messages, so we
+			// only wan't to send the error message to errorCentral once.
+			once.Do(func() {
+				p.errorKernel.errSend(p, m, er)
+			})
+		default:
+			p.errorKernel.errSend(p, m, er)
 		}
-		gzipW.Close()
-
-		natsMsgPayloadCompressed = buf.Bytes()
-		natsMsgHeader["cmp"] = []string{p.configuration.Compression}
-
-	case "": // no compression
-		natsMsgPayloadCompressed = natsMsgPayloadSerialized
-		natsMsgHeader["cmp"] = []string{"none"}
-
-	default: // no compression
-		// Allways log the error to console.
-		er := fmt.Errorf("error: publishing: compression type not defined, setting default to no compression")
-		log.Printf("%v\n", er)
+	}
 
-		// We only wan't to send the error message to errorCentral once.
-		once.Do(func() {
-			p.errorKernel.errSend(p, m, er)
-		})
+	natsMsgPayloadCompressed, err = compressPayload(natsMsgPayloadSerialized, cmp, zEnc)
+	if err != nil {
+		// Send the message uncompressed instead of dropping it.
+		er := fmt.Errorf("error: publishing: %v, sending message without compression", err)
+		p.errorKernel.errSend(p, m, er)
 
-		// No compression, so we just assign the value of the serialized
-		// data directly to the variable used with messageDeliverNats.
+		cmp = compressionHeaderNone
 		natsMsgPayloadCompressed = natsMsgPayloadSerialized
-		natsMsgHeader["cmp"] = []string{"none"}
+	}
+	natsMsgHeader["cmp"] = []string{cmp}
+
+	// The message is signed again for each attempt to deliver it, so a
+	// message retried for longer than the signatureMaxSkew is not refused
+	// as too old by the receiver. The ID and content are kept, so the
+	// receiver still finds a retry as a duplicate.
+	resign := func() ([]byte, error) {
+		err := p.signMessage(&signed)
+		if err != nil {
+			return nil, err
+		}
+		b, _, err := p.serializeMessage(signed)
+		if err != nil {
+			return nil, err
+		}
+		return compressPayload(b, cmp, zEnc)
 	}
 
-	// Create the Nats message with headers and payload, and do the
+	// Create the transport message with headers and payload, and do the
 	// sending of the message.
-	p.messageDeliverNats(natsMsgPayloadCompressed, natsMsgHeader, natsConn, m)
+	p.messageDeliver(natsMsgPayloadCompressed, natsMsgHeader, t, m, resign)
 
 	select {
 	case m.done <- struct{}{}:
@@ -799,10 +1044,15 @@ func (p process) publishAMessage(m Message, zEnc *zstd.Encoder, once sync.Once,
 
 	// Increment the counter for the next message to be sent.
 	p.messageID++
+	p.lastActivity = time.Now()
 
 	{
 		p.processes.active.mu.Lock()
-		p.processes.active.procNames[pn] = p
+		// Only update the process if it have not been replaced by a
+		// restart while we were delivering the message.
+		if current, ok := p.processes.active.procNames[pn]; ok && current.processID == p.processID {
+			p.processes.active.procNames[pn] = p
+		}
 		p.processes.active.mu.Unlock()
 	}
 
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package steward
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup will make the command start in its own process group,
+// so the command and all its child processes can be killed together.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killProcessGroup will kill the process group of a command started with
+// setProcessGroup.
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	// A negative pid sends the signal to all the processes in the group.
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
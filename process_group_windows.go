@@ -0,0 +1,18 @@
+package steward
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup is not supported on windows, so only the command
+// itself is killed.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup will kill the command.
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	return cmd.Process.Kill()
+}
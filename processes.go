@@ -2,8 +2,11 @@ package steward
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -114,6 +117,27 @@ func (p *processes) Start(proc process) {
 		go proc.spawnWorker()
 	}
 
+	{
+		log.Printf("Starting REQProcessRestart subscriber: %#v\n", proc.node)
+		sub := newSubject(REQProcessRestart, string(proc.node))
+		proc := newProcess(proc.ctx, p.server, sub, processKindSubscriber, nil)
+		go proc.spawnWorker()
+	}
+
+	{
+		log.Printf("Starting REQProcessMetrics subscriber: %#v\n", proc.node)
+		sub := newSubject(REQProcessMetrics, string(proc.node))
+		proc := newProcess(proc.ctx, p.server, sub, processKindSubscriber, nil)
+		go proc.spawnWorker()
+	}
+
+	{
+		log.Printf("Starting REQSubjectSubscribeList subscriber: %#v\n", proc.node)
+		sub := newSubject(REQSubjectSubscribeList, string(proc.node))
+		proc := newProcess(proc.ctx, p.server, sub, processKindSubscriber, nil)
+		go proc.spawnWorker()
+	}
+
 	{
 		log.Printf("Starting REQTest subscriber: %#v\n", proc.node)
 		sub := newSubject(REQTest, string(proc.node))
@@ -133,12 +157,25 @@ func (p *processes) Start(proc process) {
 		proc.startup.subREQToFileNACK(proc)
 	}
 
+	if proc.configuration.StartSubREQWriteFileIfChanged {
+		proc.startup.subREQWriteFileIfChanged(proc)
+	}
+
 	if proc.configuration.StartSubREQCopyFileFrom {
 		proc.startup.subREQCopyFileFrom(proc)
 	}
 
 	if proc.configuration.StartSubREQCopyFileTo {
 		proc.startup.subREQCopyFileTo(proc)
+		proc.startup.subREQCopyFileResume(proc)
+	}
+
+	if proc.configuration.StartSubREQCopyDirFrom {
+		proc.startup.subREQCopyDirFrom(proc)
+	}
+
+	if proc.configuration.StartSubREQCopyDirTo {
+		proc.startup.subREQCopyDirTo(proc)
 	}
 
 	if proc.configuration.StartSubREQHello {
@@ -184,23 +221,27 @@ func (p *processes) Start(proc process) {
 	}
 
 	if proc.configuration.IsCentralAuth {
-		proc.startup.subREQKeysRequestUpdate(proc)
-		proc.startup.subREQKeysAllow(proc)
-		proc.startup.subREQKeysDelete(proc)
+		proc.startup.subCentralAuth(proc)
+
+		if proc.configuration.StandbyCentralNode != "" {
+			proc.startup.pubREQCentralReplicate(proc)
+		}
 
-		proc.startup.subREQAclRequestUpdate(proc)
+		if proc.configuration.ReplicateToNodes != "" {
+			for _, n := range strings.Split(proc.configuration.ReplicateToNodes, ",") {
+				n = strings.TrimSpace(n)
+				if n != "" {
+					proc.startup.pubREQReplicateTo(proc, Node(n))
+				}
+			}
+			proc.startup.subREQReplicateToAck(proc)
+		}
+	}
 
-		proc.startup.subREQAclAddCommand(proc)
-		proc.startup.subREQAclDeleteCommand(proc)
-		proc.startup.subREQAclDeleteSource(proc)
-		proc.startup.subREQAclGroupNodesAddNode(proc)
-		proc.startup.subREQAclGroupNodesDeleteNode(proc)
-		proc.startup.subREQAclGroupNodesDeleteGroup(proc)
-		proc.startup.subREQAclGroupCommandsAddCommand(proc)
-		proc.startup.subREQAclGroupCommandsDeleteCommand(proc)
-		proc.startup.subREQAclGroupCommandsDeleteGroup(proc)
-		proc.startup.subREQAclExport(proc)
-		proc.startup.subREQAclImport(proc)
+	if proc.configuration.IsStandbyCentral {
+		proc.startup.subREQCentralReplicate(proc)
+		proc.startup.subREQReplicateTo(proc)
+		proc.startup.subREQFailover(proc)
 	}
 
 	// Moved this together with proc.configuration.StartPubREQKeysRequestUpdate since they belong together.
@@ -216,18 +257,199 @@ func (p *processes) Start(proc process) {
 		proc.startup.subREQHttpGetScheduled(proc)
 	}
 
+	if proc.configuration.StartSubREQHttpPost {
+		proc.startup.subREQHttpPost(proc)
+	}
+
 	if proc.configuration.StartSubREQTailFile {
 		proc.startup.subREQTailFile(proc)
+		proc.startup.subREQTailFileStop(proc)
+	}
+
+	if proc.configuration.StartSubREQScheduled {
+		proc.startup.subREQScheduled(proc)
+		proc.startup.subREQScheduledStop(proc)
 	}
 
 	if proc.configuration.StartSubREQCliCommandCont {
 		proc.startup.subREQCliCommandCont(proc)
 	}
 
+	if proc.configuration.StartSubREQStreamCommand {
+		proc.startup.subREQStreamCommand(proc)
+		proc.startup.subREQStreamCommandInput(proc)
+		proc.startup.subREQStreamCommandClose(proc)
+	}
+
+	if proc.configuration.StartSubREQListActiveSessions {
+		proc.startup.subREQListActiveSessions(proc)
+	}
+
 	if proc.configuration.StartSubREQRelay {
 		proc.startup.subREQRelay(proc)
 	}
 
+	if proc.configuration.StartSubREQSetMessageDefaults {
+		proc.startup.subREQSetMessageDefaults(proc)
+	}
+
+	if proc.configuration.StartSubREQSetPriorityPolicy {
+		proc.startup.subREQSetPriorityPolicy(proc)
+	}
+
+	if proc.configuration.StartSubREQReindexDataFolder {
+		proc.startup.subREQReindexDataFolder(proc)
+	}
+
+	if proc.configuration.StartSubREQSearchDataFolder {
+		proc.startup.subREQSearchDataFolder(proc)
+	}
+
+	if proc.configuration.StartSubREQDegradedMode {
+		proc.startup.subREQDegradedMode(proc)
+	}
+
+	if proc.configuration.StartSubREQVerifyDataIntegrity {
+		proc.startup.subREQVerifyDataIntegrity(proc)
+	}
+
+	if proc.configuration.StartSubREQCompressStoredReplies {
+		proc.startup.subREQCompressStoredReplies(proc)
+	}
+
+	if proc.configuration.StartSubREQSubscribeMetrics {
+		proc.startup.subREQSubscribeMetrics(proc)
+	}
+
+	if proc.configuration.StartSubREQPartialUpdateFile {
+		proc.startup.subREQPartialUpdateFile(proc)
+	}
+
+	if proc.configuration.StartSubREQConnectionAudit {
+		proc.startup.subREQConnectionAudit(proc)
+	}
+
+	if proc.configuration.StartSubREQCentralChanged {
+		proc.startup.subREQCentralChanged(proc)
+	}
+
+	if proc.configuration.StartSubREQInspectSignature {
+		proc.startup.subREQInspectSignature(proc)
+	}
+
+	if proc.configuration.StartSubREQInspectAllowedSignatures {
+		proc.startup.subREQInspectAllowedSignatures(proc)
+	}
+
+	if proc.configuration.StartSubREQEnvInfo {
+		proc.startup.subREQEnvInfo(proc)
+	}
+
+	if proc.configuration.StartSubREQPublicKeysList {
+		proc.startup.subREQPublicKeysList(proc)
+	}
+
+	if proc.configuration.StartSubREQReachabilityProbe {
+		proc.startup.subREQReachabilityProbe(proc)
+		proc.startup.subREQReachabilityPing(proc)
+		proc.startup.subREQReachabilityResult(proc)
+	}
+
+	if proc.configuration.StartSubREQResourceLimitExec {
+		proc.startup.subREQResourceLimitExec(proc)
+	}
+
+	if proc.configuration.StartSubREQSyncTime {
+		proc.startup.subREQSyncTime(proc)
+		proc.startup.subREQSyncTimeApply(proc)
+	}
+
+	if proc.configuration.StartSubREQTimeNow {
+		proc.startup.subREQTimeNow(proc)
+	}
+
+	if proc.configuration.StartSubREQNodeInfo {
+		proc.startup.subREQNodeInfo(proc)
+	}
+
+	if proc.configuration.StartSubREQListErrorSinks {
+		proc.startup.subREQListErrorSinks(proc)
+	}
+
+	if proc.configuration.StartSubREQManageErrorSink {
+		proc.startup.subREQManageErrorSink(proc)
+	}
+
+	if proc.configuration.StartSubREQMeasureThroughput {
+		proc.startup.subREQMeasureThroughput(proc)
+		proc.startup.subREQThroughputAck(proc)
+	}
+
+	if proc.configuration.StartSubREQThroughputDiscard {
+		proc.startup.subREQThroughputDiscard(proc)
+	}
+
+	if proc.configuration.StartSubREQQuery {
+		proc.startup.subREQQuery(proc)
+	}
+
+	if proc.configuration.StartSubREQBulkFileFetch {
+		proc.startup.subREQBulkFileFetch(proc)
+	}
+
+	if proc.configuration.StartSubREQFileTemplateRenderPreview {
+		proc.startup.subREQFileTemplateRenderPreview(proc)
+	}
+
+	if proc.configuration.StartSubREQAttachMetadata {
+		proc.startup.subREQAttachMetadata(proc)
+	}
+
+	if proc.configuration.StartSubREQReconcileState {
+		proc.startup.subREQReconcileState(proc)
+	}
+
+	if proc.configuration.StartSubREQShutdownScheduled {
+		proc.startup.subREQShutdownScheduled(proc)
+	}
+
+	if proc.configuration.StartSubREQValidateTrustStore {
+		proc.startup.subREQValidateTrustStore(proc)
+	}
+
+	if proc.configuration.StartSubREQInspectProcessGoroutines {
+		proc.startup.subREQInspectProcessGoroutines(proc)
+	}
+
+	if proc.configuration.StartSubREQListFailedMessages {
+		proc.startup.subREQListFailedMessages(proc)
+	}
+
+	if proc.configuration.StartSubREQInspectRetryState {
+		proc.startup.subREQInspectRetryState(proc)
+	}
+
+	if proc.configuration.StartSubREQInspectTimeouts {
+		proc.startup.subREQInspectTimeouts(proc)
+	}
+
+	if proc.configuration.StartSubREQRunWithLock {
+		proc.startup.subREQRunWithLock(proc)
+		proc.startup.subREQLockResult(proc)
+	}
+
+	if proc.configuration.StartSubREQProbeMethod {
+		proc.startup.subREQProbeMethod(proc)
+	}
+
+	if proc.configuration.StartSubREQAdoptNodeName {
+		proc.startup.subREQAdoptNodeName(proc)
+	}
+
+	if proc.configuration.StartSubREQKeysRotate {
+		proc.startup.subREQKeysRotate(proc)
+	}
+
 	proc.startup.subREQRelayInitial(proc)
 
 	proc.startup.subREQPublicKey(proc)
@@ -242,6 +464,69 @@ func (p *processes) Stop() {
 
 }
 
+// drain will stop the subscribers from receiving new messages, and the
+// publishers after the message they are sending, and wait for the
+// messages being handled to finish or the context to be done. The names
+// of the processes that did not finish in time are returned.
+func (p *processes) drain(ctx context.Context) []string {
+	p.active.mu.Lock()
+	procs := make([]process, 0, len(p.active.procNames))
+	for _, proc := range p.active.procNames {
+		procs = append(procs, proc)
+	}
+	p.active.mu.Unlock()
+
+	for _, proc := range procs {
+		switch proc.processKind {
+		case processKindSubscriber:
+			if proc.subscription != nil {
+				err := proc.subscription.unsubscribe()
+				if err != nil {
+					log.Printf("error: drain: failed to stop subscription for %v: %v\n", proc.processName, err)
+				}
+			}
+		case processKindPublisher:
+			proc.ctxCancel()
+		}
+	}
+
+	// The work started in the background by the handlers is tracked
+	// by the wait group of the processes.
+	workDone := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(workDone)
+	}()
+
+	ticker := time.NewTicker(time.Millisecond * 50)
+	defer ticker.Stop()
+
+	for {
+		var pending []string
+		for _, proc := range procs {
+			if !proc.drained() {
+				pending = append(pending, string(proc.processName))
+			}
+		}
+		select {
+		case <-workDone:
+		default:
+			pending = append(pending, "background work of the handlers")
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			sort.Strings(pending)
+			return pending
+		case <-ticker.C:
+		}
+	}
+}
+
 // ---------------------------------------------------------------------------------------
 
 // Startup holds all the startup methods for subscribers.
@@ -261,6 +546,37 @@ func newStartup(server *server) *startup {
 	return &s
 }
 
+// subCentralAuth will start all the subscribers for the central auth
+// services. It is used at startup if the node is central auth, and when
+// a standby node takes over as central with REQFailover.
+func (s startup) subCentralAuth(p process) {
+	s.subREQKeysRequestUpdate(p)
+	s.subREQKeysAllow(p)
+	s.subREQKeysDelete(p)
+
+	s.subREQAclRequestUpdate(p)
+
+	s.subREQAclAddCommand(p)
+	s.subREQAclDeleteCommand(p)
+	s.subREQAclDeleteSource(p)
+	s.subREQAclGroupNodesAddNode(p)
+	s.subREQAclGroupNodesDeleteNode(p)
+	s.subREQAclGroupNodesDeleteGroup(p)
+	s.subREQAclGroupCommandsAddCommand(p)
+	s.subREQAclGroupCommandsDeleteCommand(p)
+	s.subREQAclGroupCommandsDeleteGroup(p)
+	s.subREQAclExport(p)
+	s.subREQAclImport(p)
+	s.subREQAclWhoCan(p)
+	s.subREQCloneNodeConfig(p)
+	s.subREQChangeNodeName(p)
+	s.subREQLockAcquire(p)
+	s.subREQLockRelease(p)
+	s.subREQExportAuditBundle(p)
+	s.subREQValidateReachability(p)
+	s.subREQGenerateKeypairFor(p)
+}
+
 func (s startup) subREQHttpGet(p process) {
 
 	log.Printf("Starting Http Get subscriber: %#v\n", p.node)
@@ -281,10 +597,18 @@ func (s startup) subREQHttpGetScheduled(p process) {
 
 }
 
+func (s startup) subREQHttpPost(p process) {
+	log.Printf("Starting http post subscriber: %#v\n", p.node)
+	sub := newSubject(REQHttpPost, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
 func (s startup) pubREQHello(p process) {
 	log.Printf("Starting Hello Publisher: %#v\n", p.node)
 
-	sub := newSubject(REQHello, p.configuration.CentralNodeName)
+	sub := newSubject(REQHello, p.configuration.centralNode())
 	proc := newProcess(p.ctx, s.server, sub, processKindPublisher, nil)
 
 	// Define the procFunc to be used for the process.
@@ -294,12 +618,12 @@ func (s startup) pubREQHello(p process) {
 
 			// d := fmt.Sprintf("Hello from %v\n", p.node)
 			// Send the ed25519 public key used for signing as the payload of the message.
-			d := s.server.nodeAuth.SignPublicKey
+			d, _ := s.server.nodeAuth.signingKeys()
 
 			m := Message{
 				FileName:   "hello.log",
 				Directory:  "hello-messages",
-				ToNode:     Node(p.configuration.CentralNodeName),
+				ToNode:     Node(p.configuration.centralNode()),
 				FromNode:   Node(p.node),
 				Data:       []byte(d),
 				Method:     REQHello,
@@ -334,7 +658,7 @@ func (s startup) pubREQHello(p process) {
 func (s startup) pubREQKeysRequestUpdate(p process) {
 	log.Printf("Starting PublicKeysGet Publisher: %#v\n", p.node)
 
-	sub := newSubject(REQKeysRequestUpdate, p.configuration.CentralNodeName)
+	sub := newSubject(REQKeysRequestUpdate, p.configuration.centralNode())
 	proc := newProcess(p.ctx, s.server, sub, processKindPublisher, nil)
 
 	// Define the procFunc to be used for the process.
@@ -352,7 +676,7 @@ func (s startup) pubREQKeysRequestUpdate(p process) {
 			m := Message{
 				FileName:    "publickeysget.log",
 				Directory:   "publickeysget",
-				ToNode:      Node(p.configuration.CentralNodeName),
+				ToNode:      Node(p.configuration.centralNode()),
 				FromNode:    Node(p.node),
 				Data:        []byte(proc.nodeAuth.publicKeys.keysAndHash.Hash[:]),
 				Method:      REQKeysRequestUpdate,
@@ -389,7 +713,7 @@ func (s startup) pubREQKeysRequestUpdate(p process) {
 func (s startup) pubREQAclRequestUpdate(p process) {
 	log.Printf("Starting REQAclRequestUpdate Publisher: %#v\n", p.node)
 
-	sub := newSubject(REQAclRequestUpdate, p.configuration.CentralNodeName)
+	sub := newSubject(REQAclRequestUpdate, p.configuration.centralNode())
 	proc := newProcess(p.ctx, s.server, sub, processKindPublisher, nil)
 
 	// Define the procFunc to be used for the process.
@@ -407,7 +731,7 @@ func (s startup) pubREQAclRequestUpdate(p process) {
 			m := Message{
 				FileName:    "aclRequestUpdate.log",
 				Directory:   "aclRequestUpdate",
-				ToNode:      Node(p.configuration.CentralNodeName),
+				ToNode:      Node(p.configuration.centralNode()),
 				FromNode:    Node(p.node),
 				Data:        []byte(proc.nodeAuth.nodeAcl.aclAndHash.Hash[:]),
 				Method:      REQAclRequestUpdate,
@@ -438,6 +762,168 @@ func (s startup) pubREQAclRequestUpdate(p process) {
 	go proc.spawnWorker()
 }
 
+// pubREQCentralReplicate defines the startup of a publisher that will send
+// a snapshot of the central auth state to the standby central node at the
+// interval given in REQCentralReplicateInterval. The snapshot is sent as
+// a replication event, the same as with REQReplicateTo.
+func (s startup) pubREQCentralReplicate(p process) {
+	log.Printf("Starting REQCentralReplicate Publisher: %#v\n", p.node)
+
+	sub := newSubject(REQCentralReplicate, p.configuration.StandbyCentralNode)
+	proc := newProcess(p.ctx, s.server, sub, processKindPublisher, nil)
+
+	// Define the procFunc to be used for the process.
+	proc.procFunc = func(ctx context.Context, procFuncCh chan Message) error {
+		ticker := time.NewTicker(time.Second * time.Duration(p.configuration.REQCentralReplicateInterval))
+		for {
+			js, err := s.centralAuth.exportState()
+			if err != nil {
+				p.errorKernel.errSend(p, Message{}, err)
+			}
+
+			var evJs []byte
+			if err == nil {
+				evJs, err = json.Marshal(newReplicationEvent(time.Now().UnixNano(), js))
+				if err != nil {
+					er := fmt.Errorf("error: pubREQCentralReplicate: failed to marshal replication event: %v", err)
+					p.errorKernel.errSend(p, Message{}, er)
+				}
+			}
+
+			if err == nil {
+				m := Message{
+					ToNode:      Node(p.configuration.StandbyCentralNode),
+					FromNode:    Node(p.node),
+					Data:        evJs,
+					Method:      REQCentralReplicate,
+					ReplyMethod: REQNone,
+					ACKTimeout:  proc.configuration.DefaultMessageTimeout,
+					Retries:     1,
+				}
+
+				sam, err := newSubjectAndMessage(m)
+				if err != nil {
+					// In theory the system should drop the message before it reaches here.
+					p.errorKernel.errSend(p, m, err)
+					log.Printf("error: ProcessesStart: %v\n", err)
+				}
+				proc.toRingbufferCh <- []subjectAndMessage{sam}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				er := fmt.Errorf("info: stopped handleFunc for: publisher %v", proc.subject.name())
+				log.Printf("%v\n", er)
+				return nil
+			}
+		}
+	}
+	go proc.spawnWorker()
+}
+
+// pubREQReplicateTo defines the startup of a publisher that will send
+// the central auth state to a standby node each time the state changes.
+// The state are also sent again at the interval given in
+// REQCentralReplicateInterval if the standby have not acknowledged the
+// current state, so a standby that was unreachable will resync.
+func (s startup) pubREQReplicateTo(p process, toNode Node) {
+	log.Printf("Starting REQReplicateTo Publisher for %v: %#v\n", toNode, p.node)
+
+	sub := newSubject(REQReplicateTo, string(toNode))
+	proc := newProcess(p.ctx, s.server, sub, processKindPublisher, nil)
+
+	notifyCh := s.centralAuth.replication.subscribe(toNode)
+
+	// Define the procFunc to be used for the process.
+	proc.procFunc = func(ctx context.Context, procFuncCh chan Message) error {
+		ticker := time.NewTicker(time.Second * time.Duration(p.configuration.REQCentralReplicateInterval))
+		defer ticker.Stop()
+
+		for {
+			js, err := s.centralAuth.exportState()
+			if err != nil {
+				p.errorKernel.errSend(p, Message{}, err)
+			}
+
+			ev := newReplicationEvent(time.Now().UnixNano(), js)
+
+			var evJs []byte
+			if err == nil {
+				evJs, err = json.Marshal(ev)
+				if err != nil {
+					er := fmt.Errorf("error: pubREQReplicateTo: failed to marshal replication event: %v", err)
+					p.errorKernel.errSend(p, Message{}, er)
+				}
+			}
+
+			// Only send if the standby have not already acknowledged the
+			// current state.
+			if err == nil && s.centralAuth.replication.acked(toNode) != ev.Hash {
+				m := Message{
+					ToNode:      toNode,
+					FromNode:    Node(p.node),
+					Data:        evJs,
+					Method:      REQReplicateTo,
+					ReplyMethod: REQReplicateToAck,
+					ACKTimeout:  proc.configuration.DefaultMessageTimeout,
+					Retries:     1,
+				}
+
+				sam, err := newSubjectAndMessage(m)
+				if err != nil {
+					// In theory the system should drop the message before it reaches here.
+					p.errorKernel.errSend(p, m, err)
+					log.Printf("error: ProcessesStart: %v\n", err)
+				}
+				proc.toRingbufferCh <- []subjectAndMessage{sam}
+			}
+
+			select {
+			case <-notifyCh:
+			case <-ticker.C:
+			case <-ctx.Done():
+				er := fmt.Errorf("info: stopped handleFunc for: publisher %v", proc.subject.name())
+				log.Printf("%v\n", er)
+				return nil
+			}
+		}
+	}
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQReplicateTo(p process) {
+	log.Printf("Starting REQReplicateTo subscriber: %#v\n", p.node)
+	sub := newSubject(REQReplicateTo, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQReplicateToAck(p process) {
+	log.Printf("Starting REQReplicateToAck subscriber: %#v\n", p.node)
+	sub := newSubject(REQReplicateToAck, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQCentralReplicate(p process) {
+	log.Printf("Starting REQCentralReplicate subscriber: %#v\n", p.node)
+	sub := newSubject(REQCentralReplicate, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQFailover(p process) {
+	log.Printf("Starting REQFailover subscriber: %#v\n", p.node)
+	sub := newSubject(REQFailover, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
 func (s startup) subREQKeysRequestUpdate(p process) {
 	log.Printf("Starting Public keys request update subscriber: %#v\n", p.node)
 	sub := newSubject(REQKeysRequestUpdate, string(p.node))
@@ -559,6 +1045,20 @@ func (s startup) subREQAclImport(p process) {
 	go proc.spawnWorker()
 }
 
+func (s startup) subREQAclWhoCan(p process) {
+	log.Printf("Starting Acl who can subscriber: %#v\n", p.node)
+	sub := newSubject(REQAclWhoCan, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQCloneNodeConfig(p process) {
+	log.Printf("Starting clone node config subscriber: %#v\n", p.node)
+	sub := newSubject(REQCloneNodeConfig, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+	go proc.spawnWorker()
+}
+
 func (s startup) subREQToConsole(p process) {
 	log.Printf("Starting Text To Console subscriber: %#v\n", p.node)
 	sub := newSubject(REQToConsole, string(p.node))
@@ -618,7 +1118,20 @@ func (s startup) subREQHello(p process) {
 	// of the nodes we've received hello's from in the sayHelloNodes map,
 	// which is the information we pass along to generate metrics.
 	proc.procFunc = func(ctx context.Context, procFuncCh chan Message) error {
-		// sayHelloNodes := make(map[Node]struct{})
+		sayHelloNodes := newHelloNodes()
+
+		// The hello interval of the nodes is expected to be the same as
+		// the one of central, and a node is stale when it have not said
+		// hello for HelloStaleMultiplier intervals. The check is disabled
+		// if either is 0.
+		interval := time.Second * time.Duration(proc.configuration.StartPubREQHello)
+		timeout := interval * time.Duration(proc.configuration.HelloStaleMultiplier)
+		var checkCh <-chan time.Time
+		if timeout > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			checkCh = ticker.C
+		}
 
 		for {
 			// Receive a copy of the message sent from the method handler.
@@ -626,6 +1139,13 @@ func (s startup) subREQHello(p process) {
 
 			select {
 			case m = <-procFuncCh:
+			case now := <-checkCh:
+				for _, node := range sayHelloNodes.check(now, timeout) {
+					er := fmt.Errorf("error: hello from node %v is overdue, last seen %v ago, want a hello every %v", node, now.Sub(sayHelloNodes.lastSeen[node]).Round(time.Second), interval)
+					proc.errorKernel.errSend(proc, Message{}, er)
+				}
+				s.metrics.promHelloNodesStale.Set(float64(sayHelloNodes.staleCount()))
+				continue
 			case <-ctx.Done():
 				er := fmt.Errorf("info: stopped handleFunc for: subscriber %v", proc.subject.name())
 				// sendErrorLogMessage(proc.toRingbufferCh, proc.node, er)
@@ -635,6 +1155,12 @@ func (s startup) subREQHello(p process) {
 
 			s.centralAuth.addPublicKey(proc, m)
 
+			if sayHelloNodes.seen(m.FromNode, time.Now()) {
+				er := fmt.Errorf("info: node %v is saying hello again", m.FromNode)
+				proc.errorKernel.infoSend(proc, m, er)
+				s.metrics.promHelloNodesStale.Set(float64(sayHelloNodes.staleCount()))
+			}
+
 			// update the prometheus metrics
 
 			s.server.centralAuth.pki.nodesAcked.mu.Lock()
@@ -664,6 +1190,14 @@ func (s startup) subREQToFileNACK(p process) {
 	go proc.spawnWorker()
 }
 
+func (s startup) subREQWriteFileIfChanged(p process) {
+	log.Printf("Starting write file if changed subscriber: %#v\n", p.node)
+	sub := newSubject(REQWriteFileIfChanged, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
 func (s startup) subREQCopyFileFrom(p process) {
 	log.Printf("Starting copy file from subscriber: %#v\n", p.node)
 	sub := newSubject(REQCopyFileFrom, string(p.node))
@@ -680,6 +1214,30 @@ func (s startup) subREQCopyFileTo(p process) {
 	go proc.spawnWorker()
 }
 
+func (s startup) subREQCopyFileResume(p process) {
+	log.Printf("Starting copy file resume subscriber: %#v\n", p.node)
+	sub := newSubject(REQCopyFileResume, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQCopyDirFrom(p process) {
+	log.Printf("Starting REQCopyDirFrom subscriber: %#v\n", p.node)
+	sub := newSubject(REQCopyDirFrom, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQCopyDirTo(p process) {
+	log.Printf("Starting REQCopyDirTo subscriber: %#v\n", p.node)
+	sub := newSubject(REQCopyDirTo, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
 func (s startup) subREQToFileAppend(p process) {
 	log.Printf("Starting text logging subscriber: %#v\n", p.node)
 	sub := newSubject(REQToFileAppend, string(p.node))
@@ -696,6 +1254,33 @@ func (s startup) subREQTailFile(p process) {
 	go proc.spawnWorker()
 }
 
+func (s startup) subREQTailFileStop(p process) {
+	log.Printf("Starting tail file stop subscriber: %#v\n", p.node)
+	sub := newSubject(REQTailFileStop, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQScheduled(p process) {
+	log.Printf("Starting scheduled messages subscriber: %#v\n", p.node)
+	sub := newSubject(REQScheduled, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+
+	// Start the schedules that were running when the node was stopped.
+	s.server.scheduledMessages.restore(proc)
+}
+
+func (s startup) subREQScheduledStop(p process) {
+	log.Printf("Starting scheduled messages stop subscriber: %#v\n", p.node)
+	sub := newSubject(REQScheduledStop, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
 func (s startup) subREQCliCommandCont(p process) {
 	log.Printf("Starting cli command with continous delivery: %#v\n", p.node)
 	sub := newSubject(REQCliCommandCont, string(p.node))
@@ -704,8 +1289,40 @@ func (s startup) subREQCliCommandCont(p process) {
 	go proc.spawnWorker()
 }
 
-func (s startup) subREQRelay(p process) {
-	nodeWithRelay := fmt.Sprintf("*.%v", p.node)
+func (s startup) subREQStreamCommand(p process) {
+	log.Printf("Starting stream command: %#v\n", p.node)
+	sub := newSubject(REQStreamCommand, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQStreamCommandInput(p process) {
+	log.Printf("Starting stream command input: %#v\n", p.node)
+	sub := newSubject(REQStreamCommandInput, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQStreamCommandClose(p process) {
+	log.Printf("Starting stream command close: %#v\n", p.node)
+	sub := newSubject(REQStreamCommandClose, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQListActiveSessions(p process) {
+	log.Printf("Starting REQListActiveSessions subscriber: %#v\n", p.node)
+	sub := newSubject(REQListActiveSessions, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQRelay(p process) {
+	nodeWithRelay := fmt.Sprintf("*.%v", p.node)
 	log.Printf("Starting Relay: %#v\n", nodeWithRelay)
 	sub := newSubject(REQRelay, string(nodeWithRelay))
 	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
@@ -713,6 +1330,406 @@ func (s startup) subREQRelay(p process) {
 	go proc.spawnWorker()
 }
 
+func (s startup) subREQSetMessageDefaults(p process) {
+	log.Printf("Starting set message defaults subscriber: %#v\n", p.node)
+	sub := newSubject(REQSetMessageDefaults, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQSetPriorityPolicy(p process) {
+	log.Printf("Starting set priority policy subscriber: %#v\n", p.node)
+	sub := newSubject(REQSetPriorityPolicy, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQReindexDataFolder(p process) {
+	log.Printf("Starting reindex data folder subscriber: %#v\n", p.node)
+	sub := newSubject(REQReindexDataFolder, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQSearchDataFolder(p process) {
+	log.Printf("Starting search data folder subscriber: %#v\n", p.node)
+	sub := newSubject(REQSearchDataFolder, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQDegradedMode(p process) {
+	log.Printf("Starting degraded mode subscriber: %#v\n", p.node)
+	sub := newSubject(REQDegradedMode, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQVerifyDataIntegrity(p process) {
+	log.Printf("Starting verify data integrity subscriber: %#v\n", p.node)
+	sub := newSubject(REQVerifyDataIntegrity, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQCompressStoredReplies(p process) {
+	log.Printf("Starting REQCompressStoredReplies subscriber: %#v\n", p.node)
+	sub := newSubject(REQCompressStoredReplies, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQSubscribeMetrics(p process) {
+	log.Printf("Starting subscribe metrics subscriber: %#v\n", p.node)
+	sub := newSubject(REQSubscribeMetrics, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQPartialUpdateFile(p process) {
+	log.Printf("Starting partial update file subscriber: %#v\n", p.node)
+	sub := newSubject(REQPartialUpdateFile, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQConnectionAudit(p process) {
+	log.Printf("Starting REQConnectionAudit subscriber: %#v\n", p.node)
+	sub := newSubject(REQConnectionAudit, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQCentralChanged(p process) {
+	log.Printf("Starting REQCentralChanged subscriber: %#v\n", p.node)
+	sub := newSubject(REQCentralChanged, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQInspectAllowedSignatures(p process) {
+	log.Printf("Starting REQInspectAllowedSignatures subscriber: %#v\n", p.node)
+	sub := newSubject(REQInspectAllowedSignatures, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQEnvInfo(p process) {
+	log.Printf("Starting REQEnvInfo subscriber: %#v\n", p.node)
+	sub := newSubject(REQEnvInfo, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQPublicKeysList(p process) {
+	log.Printf("Starting REQPublicKeysList subscriber: %#v\n", p.node)
+	sub := newSubject(REQPublicKeysList, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQReachabilityProbe(p process) {
+	log.Printf("Starting reachability probe subscriber: %#v\n", p.node)
+	sub := newSubject(REQReachabilityProbe, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQReachabilityPing(p process) {
+	log.Printf("Starting reachability ping subscriber: %#v\n", p.node)
+	sub := newSubject(REQReachabilityPing, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQReachabilityResult(p process) {
+	log.Printf("Starting reachability result subscriber: %#v\n", p.node)
+	sub := newSubject(REQReachabilityResult, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQValidateReachability(p process) {
+	log.Printf("Starting validate reachability subscriber: %#v\n", p.node)
+	sub := newSubject(REQValidateReachability, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQGenerateKeypairFor(p process) {
+	log.Printf("Starting generate keypair for subscriber: %#v\n", p.node)
+	sub := newSubject(REQGenerateKeypairFor, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQInspectSignature(p process) {
+	log.Printf("Starting REQInspectSignature subscriber: %#v\n", p.node)
+	sub := newSubject(REQInspectSignature, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQResourceLimitExec(p process) {
+	log.Printf("Starting REQResourceLimitExec subscriber: %#v\n", p.node)
+	sub := newSubject(REQResourceLimitExec, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQSyncTime(p process) {
+	log.Printf("Starting REQSyncTime subscriber: %#v\n", p.node)
+	sub := newSubject(REQSyncTime, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQSyncTimeApply(p process) {
+	log.Printf("Starting REQSyncTimeApply subscriber: %#v\n", p.node)
+	sub := newSubject(REQSyncTimeApply, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQTimeNow(p process) {
+	log.Printf("Starting REQTimeNow subscriber: %#v\n", p.node)
+	sub := newSubject(REQTimeNow, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQNodeInfo(p process) {
+	log.Printf("Starting REQNodeInfo subscriber: %#v\n", p.node)
+	sub := newSubject(REQNodeInfo, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQListErrorSinks(p process) {
+	log.Printf("Starting REQListErrorSinks subscriber: %#v\n", p.node)
+	sub := newSubject(REQListErrorSinks, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQManageErrorSink(p process) {
+	log.Printf("Starting REQManageErrorSink subscriber: %#v\n", p.node)
+	sub := newSubject(REQManageErrorSink, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQMeasureThroughput(p process) {
+	log.Printf("Starting REQMeasureThroughput subscriber: %#v\n", p.node)
+	sub := newSubject(REQMeasureThroughput, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQThroughputAck(p process) {
+	log.Printf("Starting REQThroughputAck subscriber: %#v\n", p.node)
+	sub := newSubject(REQThroughputAck, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQThroughputDiscard(p process) {
+	log.Printf("Starting REQThroughputDiscard subscriber: %#v\n", p.node)
+	sub := newSubject(REQThroughputDiscard, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQQuery(p process) {
+	log.Printf("Starting REQQuery subscriber: %#v\n", p.node)
+	sub := newSubject(REQQuery, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQBulkFileFetch(p process) {
+	log.Printf("Starting REQBulkFileFetch subscriber: %#v\n", p.node)
+	sub := newSubject(REQBulkFileFetch, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQFileTemplateRenderPreview(p process) {
+	log.Printf("Starting REQFileTemplateRenderPreview subscriber: %#v\n", p.node)
+	sub := newSubject(REQFileTemplateRenderPreview, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQAttachMetadata(p process) {
+	log.Printf("Starting REQAttachMetadata subscriber: %#v\n", p.node)
+	sub := newSubject(REQAttachMetadata, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQReconcileState(p process) {
+	log.Printf("Starting REQReconcileState subscriber: %#v\n", p.node)
+	sub := newSubject(REQReconcileState, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQShutdownScheduled(p process) {
+	log.Printf("Starting REQShutdownScheduled subscriber: %#v\n", p.node)
+	sub := newSubject(REQShutdownScheduled, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQValidateTrustStore(p process) {
+	log.Printf("Starting REQValidateTrustStore subscriber: %#v\n", p.node)
+	sub := newSubject(REQValidateTrustStore, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQInspectProcessGoroutines(p process) {
+	log.Printf("Starting REQInspectProcessGoroutines subscriber: %#v\n", p.node)
+	sub := newSubject(REQInspectProcessGoroutines, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQListFailedMessages(p process) {
+	log.Printf("Starting REQListFailedMessages subscriber: %#v\n", p.node)
+	sub := newSubject(REQListFailedMessages, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQInspectRetryState(p process) {
+	log.Printf("Starting REQInspectRetryState subscriber: %#v\n", p.node)
+	sub := newSubject(REQInspectRetryState, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQInspectTimeouts(p process) {
+	log.Printf("Starting REQInspectTimeouts subscriber: %#v\n", p.node)
+	sub := newSubject(REQInspectTimeouts, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQProbeMethod(p process) {
+	log.Printf("Starting REQProbeMethod subscriber: %#v\n", p.node)
+	sub := newSubject(REQProbeMethod, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQRunWithLock(p process) {
+	log.Printf("Starting REQRunWithLock subscriber: %#v\n", p.node)
+	sub := newSubject(REQRunWithLock, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQLockResult(p process) {
+	log.Printf("Starting REQLockResult subscriber: %#v\n", p.node)
+	sub := newSubject(REQLockResult, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQLockAcquire(p process) {
+	log.Printf("Starting REQLockAcquire subscriber: %#v\n", p.node)
+	sub := newSubject(REQLockAcquire, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQLockRelease(p process) {
+	log.Printf("Starting REQLockRelease subscriber: %#v\n", p.node)
+	sub := newSubject(REQLockRelease, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQExportAuditBundle(p process) {
+	log.Printf("Starting export audit bundle subscriber: %#v\n", p.node)
+	sub := newSubject(REQExportAuditBundle, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQChangeNodeName(p process) {
+	log.Printf("Starting change node name subscriber: %#v\n", p.node)
+	sub := newSubject(REQChangeNodeName, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQAdoptNodeName(p process) {
+	log.Printf("Starting adopt node name subscriber: %#v\n", p.node)
+	sub := newSubject(REQAdoptNodeName, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
+func (s startup) subREQKeysRotate(p process) {
+	log.Printf("Starting keys rotate subscriber: %#v\n", p.node)
+	sub := newSubject(REQKeysRotate, string(p.node))
+	proc := newProcess(p.ctx, s.server, sub, processKindSubscriber, nil)
+
+	go proc.spawnWorker()
+}
+
 func (s startup) subREQRelayInitial(p process) {
 	log.Printf("Starting Relay Initial: %#v\n", p.node)
 	sub := newSubject(REQRelayInitial, string(p.node))
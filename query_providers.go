@@ -0,0 +1,128 @@
+package steward
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// queryProviderFunc is a read-only provider of data for REQQuery. The
+// value returned is replied back as JSON.
+type queryProviderFunc func() (any, error)
+
+// queryProviders holds the providers that can be queried with REQQuery.
+type queryProviders struct {
+	providers map[string]queryProviderFunc
+	mu        sync.Mutex
+}
+
+func newQueryProviders() *queryProviders {
+	q := queryProviders{
+		providers: make(map[string]queryProviderFunc),
+	}
+
+	return &q
+}
+
+// add will register the provider with the name given. An already
+// registered provider with the same name is replaced.
+func (q *queryProviders) add(name string, fn queryProviderFunc) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.providers[name] = fn
+}
+
+// get will return the provider with the name given.
+func (q *queryProviders) get(name string) (queryProviderFunc, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	fn, ok := q.providers[name]
+	return fn, ok
+}
+
+// names will return the sorted names of all the providers.
+func (q *queryProviders) names() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	names := []string{}
+	for n := range q.providers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// RegisterQueryProvider will register a read-only provider that can be
+// queried by name with REQQuery. The value returned by the provider is
+// replied back as JSON. Providers should be registered before the server
+// is started.
+func (s *server) RegisterQueryProvider(name string, fn func() (any, error)) error {
+	if name == "" {
+		return fmt.Errorf("error: RegisterQueryProvider: name can not be empty")
+	}
+	if fn == nil {
+		return fmt.Errorf("error: RegisterQueryProvider: provider for %v can not be nil", name)
+	}
+
+	s.queryProviders.add(name, fn)
+
+	return nil
+}
+
+// queryProcess is the information about a process returned by the
+// processes query provider.
+type queryProcess struct {
+	Kind    processKind `json:"kind"`
+	ID      int         `json:"id"`
+	Subject subjectName `json:"subject"`
+}
+
+// registerBuiltinQueryProviders will register the providers that are
+// always available for REQQuery.
+func (s *server) registerBuiltinQueryProviders() {
+	s.queryProviders.add("processes", func() (any, error) {
+		s.processes.active.mu.Lock()
+		defer s.processes.active.mu.Unlock()
+
+		procs := []queryProcess{}
+		for _, p := range s.processes.active.procNames {
+			procs = append(procs, queryProcess{
+				Kind:    p.processKind,
+				ID:      p.processID,
+				Subject: p.subject.name(),
+			})
+		}
+		sort.Slice(procs, func(i, j int) bool {
+			return procs[i].Subject < procs[j].Subject
+		})
+
+		return procs, nil
+	})
+
+	s.queryProviders.add("config", func() (any, error) {
+		return s.configuration, nil
+	})
+
+	s.queryProviders.add("nodes", func() (any, error) {
+		s.nodeAuth.publicKeys.mu.Lock()
+		defer s.nodeAuth.publicKeys.mu.Unlock()
+
+		nodes := []Node{}
+		for n := range s.nodeAuth.publicKeys.keysAndHash.Keys {
+			nodes = append(nodes, n)
+		}
+		sort.Slice(nodes, func(i, j int) bool {
+			return nodes[i] < nodes[j]
+		})
+
+		return nodes, nil
+	})
+
+	s.queryProviders.add("errorSinks", func() (any, error) {
+		return s.errorKernel.sinks.list(), nil
+	})
+}
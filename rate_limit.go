@@ -0,0 +1,193 @@
+package steward
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bucketSweepInterval is how often the buckets of the nodes that have
+// been idle long enough for the bucket to be full again are removed.
+const bucketSweepInterval = time.Minute
+
+// rateLimitedOtherNodes is the node label used in the metrics for the
+// messages dropped from the nodes we have no public key for, so messages
+// with made up node names can't create a new label for each name.
+const rateLimitedOtherNodes = "other"
+
+// rateLimit is the rate in messages per second, and the burst of
+// messages allowed above the rate.
+type rateLimit struct {
+	rate  int
+	burst int
+}
+
+// tokenBucket is the state of the rate limiting of one node.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// limited is true while the messages from the node are dropped, so
+	// only the first drop is reported.
+	limited bool
+}
+
+// inboundRateLimits holds the token buckets limiting the rate of the
+// messages handled from each node, as set with the InboundRateLimit,
+// InboundRateBurst and InboundRateLimitNodes configuration.
+type inboundRateLimits struct {
+	// The limit of the nodes not in overrides.
+	limit rateLimit
+	// The limits of the nodes with their own limit.
+	overrides map[Node]rateLimit
+	buckets   map[Node]*tokenBucket
+	// lastSweep is the last time the idle buckets were removed.
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// newInboundRateLimits will prepare the rate limits from the
+// configuration. The overrides are a comma separated list of
+// node=rate or node=rate:burst. A rate of 0 is unlimited. An error is
+// returned if the overrides are not valid.
+func newInboundRateLimits(c *Configuration) (*inboundRateLimits, error) {
+	r := inboundRateLimits{
+		limit:     rateLimit{rate: c.InboundRateLimit, burst: c.InboundRateBurst},
+		overrides: make(map[Node]rateLimit),
+		buckets:   make(map[Node]*tokenBucket),
+	}
+
+	if r.limit.rate < 0 || r.limit.burst < 0 {
+		return nil, fmt.Errorf("inboundRateLimit and inboundRateBurst can not be negative")
+	}
+
+	if strings.TrimSpace(c.InboundRateLimitNodes) == "" {
+		return &r, nil
+	}
+
+	for _, v := range strings.Split(c.InboundRateLimitNodes, ",") {
+		kv := strings.SplitN(strings.TrimSpace(v), "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("inboundRateLimitNodes: %q is not in the format node=rate or node=rate:burst", v)
+		}
+
+		l := rateLimit{burst: r.limit.burst}
+		rb := strings.SplitN(strings.TrimSpace(kv[1]), ":", 2)
+
+		rate, err := strconv.Atoi(rb[0])
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("inboundRateLimitNodes: the rate for %v must be a number of 0 or above, got: %v", kv[0], rb[0])
+		}
+		l.rate = rate
+
+		if len(rb) == 2 {
+			burst, err := strconv.Atoi(rb[1])
+			if err != nil || burst < 0 {
+				return nil, fmt.Errorf("inboundRateLimitNodes: the burst for %v must be a number of 0 or above, got: %v", kv[0], rb[1])
+			}
+			l.burst = burst
+		}
+
+		r.overrides[Node(strings.TrimSpace(kv[0]))] = l
+	}
+
+	return &r, nil
+}
+
+// allow will take a token from the bucket of the node, and return true
+// if the message from the node is allowed. If not allowed, report is
+// true for the first message dropped since the node was last allowed,
+// so the flood of drops is only reported once.
+func (r *inboundRateLimits) allow(node Node, now time.Time) (ok bool, report bool) {
+	l := r.limitFor(node)
+	if l.rate == 0 {
+		return true, false
+	}
+
+	size := l.size()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.lastSweep) >= bucketSweepInterval {
+		r.sweep(now)
+	}
+
+	b, found := r.buckets[node]
+	if !found {
+		b = &tokenBucket{tokens: size, last: now}
+		r.buckets[node] = b
+	}
+
+	// Fill the bucket with the tokens for the time since last.
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * float64(l.rate)
+		if b.tokens > size {
+			b.tokens = size
+		}
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = false
+		return true, false
+	}
+
+	report = !b.limited
+	b.limited = true
+	return false, report
+}
+
+// limitFor will return the limit of the node.
+func (r *inboundRateLimits) limitFor(node Node) rateLimit {
+	l, found := r.overrides[node]
+	if !found {
+		l = r.limit
+	}
+
+	return l
+}
+
+// size will return the number of tokens the bucket holds when full. The
+// bucket holds the rate and the burst, so a node can send a second worth
+// of messages and the burst at once.
+func (l rateLimit) size() float64 {
+	return float64(l.rate + l.burst)
+}
+
+// sweep will remove the buckets of the nodes that have been idle long
+// enough for the bucket to be full again, since they are the same as a
+// new bucket. This keeps the buckets of the nodes no longer sending from
+// adding up. The lock must be held by the caller.
+func (r *inboundRateLimits) sweep(now time.Time) {
+	for node, b := range r.buckets {
+		l := r.limitFor(node)
+		if l.rate == 0 || b.tokens+now.Sub(b.last).Seconds()*float64(l.rate) >= l.size() {
+			delete(r.buckets, node)
+		}
+	}
+
+	r.lastSweep = now
+}
+
+// rateLimitedLabel will return the node label to use in the metrics for
+// the messages dropped from the node. Only the nodes we have the public
+// key for get their own label, and the rest are counted as
+// rateLimitedOtherNodes.
+func rateLimitedLabel(keys *publicKeys, node Node) string {
+	if keys == nil {
+		return rateLimitedOtherNodes
+	}
+
+	keys.mu.Lock()
+	_, ok := keys.keysAndHash.Keys[node]
+	keys.mu.Unlock()
+
+	if !ok {
+		return rateLimitedOtherNodes
+	}
+
+	return string(node)
+}
@@ -0,0 +1,134 @@
+package steward
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// The default and the max time to build a reachability matrix.
+	reachabilityDefaultTimeout = time.Second * 10
+	reachabilityMaxTimeout     = time.Second * 60
+)
+
+// reachabilityReply is a reply received for a reachability check,
+// either a pong from a node pinged, or the result of the probes done
+// by a node.
+type reachabilityReply struct {
+	fromNode Node
+	data     []byte
+}
+
+// reachabilityRow is the result of a node probing the other nodes.
+type reachabilityRow struct {
+	Node Node `json:"node"`
+	// Reachable tells for each of the nodes probed if a pong was
+	// received before the probe timed out.
+	Reachable map[Node]bool `json:"reachable"`
+}
+
+// reachabilityMatrix is the result of REQValidateReachability.
+type reachabilityMatrix struct {
+	Nodes []Node `json:"nodes"`
+	// Matrix tells if the first node was able to reach the second node.
+	Matrix map[Node]map[Node]bool `json:"matrix"`
+	// Nodes that did not reply with the result of their probes within
+	// the timeout. All the pairs from these nodes are unreachable.
+	Unresponsive []Node `json:"unresponsive"`
+	// Pairs where the first node could not reach the second node.
+	Unreachable [][2]Node `json:"unreachable"`
+}
+
+// newReachabilityMatrix will build the matrix from the rows received.
+// Pairs with no result are set as unreachable.
+func newReachabilityMatrix(nodes []Node, rows map[Node]reachabilityRow) reachabilityMatrix {
+	sorted := append([]Node{}, nodes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	m := reachabilityMatrix{
+		Nodes:        sorted,
+		Matrix:       make(map[Node]map[Node]bool),
+		Unresponsive: []Node{},
+		Unreachable:  [][2]Node{},
+	}
+
+	for _, from := range sorted {
+		row, ok := rows[from]
+		if !ok {
+			m.Unresponsive = append(m.Unresponsive, from)
+		}
+
+		m.Matrix[from] = make(map[Node]bool)
+		for _, to := range sorted {
+			if from == to {
+				continue
+			}
+			m.Matrix[from][to] = row.Reachable[to]
+			if !row.Reachable[to] {
+				m.Unreachable = append(m.Unreachable, [2]Node{from, to})
+			}
+		}
+	}
+
+	return m
+}
+
+// reachabilityWaits holds the reachability checks waiting for replies,
+// by the id of the check.
+type reachabilityWaits struct {
+	waits map[string]chan reachabilityReply
+	mu    sync.Mutex
+}
+
+func newReachabilityWaits() *reachabilityWaits {
+	r := reachabilityWaits{
+		waits: make(map[string]chan reachabilityReply),
+	}
+
+	return &r
+}
+
+// add will register a new check with the number of replies expected,
+// and return the id of the check, and the channel the replies will be
+// delivered on.
+func (r *reachabilityWaits) add(prefix string, replies int) (string, chan reachabilityReply) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := fmt.Sprintf("%v-%v", prefix, time.Now().UnixNano())
+	ch := make(chan reachabilityReply, replies)
+	r.waits[id] = ch
+
+	return id, ch
+}
+
+// deliver will deliver a reply to the check with the id given. Replies
+// for checks no longer waiting, or more replies than expected, are
+// returned as an error.
+func (r *reachabilityWaits) deliver(id string, reply reachabilityReply) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch, ok := r.waits[id]
+	if !ok {
+		return fmt.Errorf("no reachability check waiting with id %v", id)
+	}
+
+	select {
+	case ch <- reply:
+	default:
+		return fmt.Errorf("reachability check %v got more replies than expected", id)
+	}
+
+	return nil
+}
+
+// remove will stop waiting for replies for the check with the id given.
+func (r *reachabilityWaits) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.waits, id)
+}
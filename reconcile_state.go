@@ -0,0 +1,209 @@
+package steward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// desiredState is a declarative document describing the state a node
+// should be in, used with REQReconcileState.
+type desiredState struct {
+	// Files that should exist with the given content and mode.
+	Files []desiredFile `json:"files"`
+	// Services that should be running or stopped.
+	Services []desiredService `json:"services"`
+	// Commands to check a state, with the command to run to apply the
+	// state if the check fails. Can be used for things like packages.
+	Commands []desiredCommand `json:"commands"`
+}
+
+type desiredFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+	// The file mode in octal, like "0644". Defaults to 0600.
+	Mode string `json:"mode"`
+}
+
+type desiredService struct {
+	Name string `json:"name"`
+	// The state of the service, "running" or "stopped".
+	State string `json:"state"`
+}
+
+type desiredCommand struct {
+	// The command to check the state. An exit code of 0 means the node
+	// is in the wanted state.
+	Check []string `json:"check"`
+	// The command to run to get the node into the wanted state.
+	Apply []string `json:"apply"`
+}
+
+// parseDesiredState will parse a desired state document in JSON.
+func parseDesiredState(js []byte) (desiredState, error) {
+	var ds desiredState
+	err := json.Unmarshal(js, &ds)
+	if err != nil {
+		return ds, fmt.Errorf("error: failed to parse desired state document: %v", err)
+	}
+
+	for _, f := range ds.Files {
+		if f.Path == "" {
+			return ds, fmt.Errorf("error: file in desired state is missing path")
+		}
+	}
+	for _, s := range ds.Services {
+		if s.Name == "" || (s.State != "running" && s.State != "stopped") {
+			return ds, fmt.Errorf("error: service in desired state must have a name, and a state of running or stopped: %+v", s)
+		}
+	}
+	for _, c := range ds.Commands {
+		if len(c.Check) == 0 || len(c.Apply) == 0 {
+			return ds, fmt.Errorf("error: command in desired state must have both a check and an apply command: %+v", c)
+		}
+	}
+
+	return ds, nil
+}
+
+// reconcileState will make the changes needed to get the node into the
+// desired state, and return the actions taken. Only the parts where the
+// current state differs from the desired state are changed, so running
+// it again with the same document will take no actions. Files must be
+// within the allowed roots.
+func reconcileState(ctx context.Context, ds desiredState, allowedRoots string) ([]string, error) {
+	actions := []string{}
+
+	for _, f := range ds.Files {
+		action, err := reconcileFile(f, allowedRoots)
+		if err != nil {
+			return actions, err
+		}
+		if action != "" {
+			actions = append(actions, action)
+		}
+	}
+
+	for _, s := range ds.Services {
+		action, err := reconcileCommand(ctx, serviceCommand(s))
+		if err != nil {
+			return actions, fmt.Errorf("error: service %v: %v", s.Name, err)
+		}
+		if action != "" {
+			actions = append(actions, action)
+		}
+	}
+
+	for _, c := range ds.Commands {
+		action, err := reconcileCommand(ctx, c)
+		if err != nil {
+			return actions, err
+		}
+		if action != "" {
+			actions = append(actions, action)
+		}
+	}
+
+	return actions, nil
+}
+
+// serviceCommand will return the commands to check and apply the state
+// of the service.
+func serviceCommand(s desiredService) desiredCommand {
+	c := desiredCommand{
+		Check: []string{"systemctl", "is-active", "--quiet", s.Name},
+		Apply: []string{"systemctl", "start", s.Name},
+	}
+	if s.State == "stopped" {
+		c.Check = []string{"/bin/sh", "-c", `! systemctl is-active --quiet "$0"`, s.Name}
+		c.Apply = []string{"systemctl", "stop", s.Name}
+	}
+
+	return c
+}
+
+// reconcileFile will write the file if it does not exist, or if the
+// content or mode differs, and return the action taken.
+func reconcileFile(f desiredFile, allowedRoots string) (string, error) {
+	fp, err := checkAllowedFileRoot(allowedRoots, f.Path)
+	if err != nil {
+		return "", err
+	}
+
+	mode := os.FileMode(0600)
+	if f.Mode != "" {
+		m, err := strconv.ParseUint(f.Mode, 8, 32)
+		if err != nil {
+			return "", fmt.Errorf("error: file %v: mode is not a valid octal number: %v", f.Path, f.Mode)
+		}
+		mode = os.FileMode(m)
+	}
+
+	fi, err := os.Stat(fp)
+	switch {
+	case os.IsNotExist(err):
+		err := os.WriteFile(fp, []byte(f.Content), mode)
+		if err != nil {
+			return "", fmt.Errorf("error: failed to create file %v: %v", fp, err)
+		}
+		// Set the mode explicitly, since WriteFile is affected by umask.
+		err = os.Chmod(fp, mode)
+		if err != nil {
+			return "", fmt.Errorf("error: failed to set mode of file %v: %v", fp, err)
+		}
+		return fmt.Sprintf("created file %v", fp), nil
+	case err != nil:
+		return "", fmt.Errorf("error: failed to stat file %v: %v", fp, err)
+	}
+
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return "", fmt.Errorf("error: failed to read file %v: %v", fp, err)
+	}
+
+	var action string
+	if !bytes.Equal(b, []byte(f.Content)) {
+		err := os.WriteFile(fp, []byte(f.Content), fi.Mode().Perm())
+		if err != nil {
+			return "", fmt.Errorf("error: failed to update file %v: %v", fp, err)
+		}
+		action = fmt.Sprintf("updated content of file %v", fp)
+	}
+
+	if fi.Mode().Perm() != mode.Perm() {
+		err := os.Chmod(fp, mode)
+		if err != nil {
+			return "", fmt.Errorf("error: failed to set mode of file %v: %v", fp, err)
+		}
+		if action == "" {
+			action = fmt.Sprintf("changed mode of file %v to %v", fp, mode.Perm())
+		} else {
+			action += fmt.Sprintf(", and changed mode to %v", mode.Perm())
+		}
+	}
+
+	return action, nil
+}
+
+// reconcileCommand will run the check command, and run the apply command
+// if the check fails. The action taken is returned.
+func reconcileCommand(ctx context.Context, c desiredCommand) (string, error) {
+	err := exec.CommandContext(ctx, c.Check[0], c.Check[1:]...).Run()
+	if err == nil {
+		return "", nil
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		return "", fmt.Errorf("error: failed to run check command %v: %v", c.Check, err)
+	}
+
+	out, err := exec.CommandContext(ctx, c.Apply[0], c.Apply[1:]...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("error: apply command %v failed: %v: %s", c.Apply, err, out)
+	}
+
+	return fmt.Sprintf("ran %v since check %v failed", c.Apply, c.Check), nil
+}
@@ -0,0 +1,97 @@
+package steward
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// nonceCacheMaxEntries is the max number of nonces remembered. When
+// full the oldest nonce is evicted, so a flood of signed messages can
+// not make the node refuse all signed messages. A message whose nonce
+// was evicted while still within the allowed skew could be replayed, so
+// the max should be well above the number of signed messages received
+// within twice the skew.
+const nonceCacheMaxEntries = 100000
+
+// newSignNonce will return a new random nonce to sign a message with.
+func newSignNonce() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to read random bytes for nonce: %v", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// nonceEntry is a nonce seen, and the time it was seen.
+type nonceEntry struct {
+	key  string
+	seen time.Time
+}
+
+// nonceCache holds the nonces of the signed messages seen recently, so a
+// signed message can not be replayed. A message is accepted until its
+// time is older than the allowed skew, so the nonces are remembered for
+// twice the skew, and older messages are rejected by their time.
+type nonceCache struct {
+	seen map[string]struct{}
+	// order holds the nonces in the order they were seen, so the oldest
+	// can be evicted first.
+	order      []nonceEntry
+	maxEntries int
+	mu         sync.Mutex
+}
+
+func newNonceCache(maxEntries int) *nonceCache {
+	c := nonceCache{
+		seen:       make(map[string]struct{}),
+		maxEntries: maxEntries,
+	}
+
+	return &c
+}
+
+// checkReplay will check that the time the message was signed is within
+// the skew allowed of now, and that the nonce of the node have not been
+// seen within the skew. If both are ok the nonce is remembered.
+func (c *nonceCache) checkReplay(fromNode Node, nonce string, signedAt int64, now time.Time, skew time.Duration) error {
+	if nonce == "" {
+		return fmt.Errorf("message have no nonce")
+	}
+
+	t := time.Unix(signedAt, 0)
+	if t.Before(now.Add(-skew)) || t.After(now.Add(skew)) {
+		return fmt.Errorf("message signed at %v, which is outside the allowed skew of %v", t.Format(time.RFC3339), skew)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Evict the nonces that are too old to be needed.
+	i := 0
+	for i < len(c.order) && c.order[i].seen.Before(now.Add(-skew*2)) {
+		delete(c.seen, c.order[i].key)
+		i++
+	}
+	c.order = c.order[i:]
+
+	key := string(fromNode) + "/" + nonce
+	if _, ok := c.seen[key]; ok {
+		return fmt.Errorf("nonce %v from %v already seen", nonce, fromNode)
+	}
+
+	// Evict the oldest nonces if the cache is full.
+	for len(c.order) >= c.maxEntries && len(c.order) > 0 {
+		delete(c.seen, c.order[0].key)
+		c.order = c.order[1:]
+	}
+
+	c.seen[key] = struct{}{}
+	c.order = append(c.order, nonceEntry{key: key, seen: now})
+
+	return nil
+}
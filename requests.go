@@ -1,8 +1,9 @@
 // The structure of how to add new method types to the system.
 // -----------------------------------------------------------
-// All methods need 3 things:
+// All methods need 4 things:
 //  - A type definition
 //  - The type needs a getKind method
+//  - The type needs an isReadOnly method
 //  - The type needs a handler method
 // Overall structure example shown below.
 //
@@ -35,7 +36,6 @@ package steward
 import (
 	"context"
 	"fmt"
-	"path/filepath"
 	"time"
 )
 
@@ -55,6 +55,13 @@ const (
 	REQOpProcessStart Method = "REQOpProcessStart"
 	// Stop up a process.
 	REQOpProcessStop Method = "REQOpProcessStop"
+	// Restart a running process in place.
+	REQProcessRestart Method = "REQProcessRestart"
+	// Get the message counts and last activity of the running
+	// processes as JSON.
+	REQProcessMetrics Method = "REQProcessMetrics"
+	// Get the subjects the node is subscribing to as JSON.
+	REQSubjectSubscribeList Method = "REQSubjectSubscribeList"
 	// Execute a CLI command in for example bash or cmd.
 	// This is an event type, where a message will be sent to a
 	// node with the command to execute and an ACK will be replied
@@ -69,6 +76,20 @@ const (
 	// to send the output of the command continually back as it is
 	// generated, and not wait until the command is finished.
 	REQCliCommandCont Method = "REQCliCommandCont"
+	// REQStreamCommand will start an interactive command session. The
+	// first element of the MethodArgs is the id of the session, and the
+	// rest is the command and its arguments. The output is sent back
+	// continually as it is generated.
+	REQStreamCommand Method = "REQStreamCommand"
+	// REQStreamCommandInput will write the MethodArgs following the
+	// session id as a line to the stdin of the command of the session.
+	REQStreamCommandInput Method = "REQStreamCommandInput"
+	// REQStreamCommandClose will end the session with the id given in
+	// the first element of the MethodArgs, and kill the command.
+	REQStreamCommandClose Method = "REQStreamCommandClose"
+	// REQListActiveSessions will reply with the active sessions of the node,
+	// like the ones started with REQStreamCommand.
+	REQListActiveSessions Method = "REQListActiveSessions"
 	// Send text to be logged to the console.
 	// The data field is a slice of strings where the first string
 	// value should be the command, and the following the arguments.
@@ -91,10 +112,23 @@ const (
 	REQToFile Method = "REQToFile"
 	// REQToFileNACK same as REQToFile but NACK.
 	REQToFileNACK Method = "REQToFileNACK"
+	// REQWriteFileIfChanged same as REQToFile, but the file is only
+	// written if the content differs from the existing file, and the
+	// reply tells if the file was written.
+	REQWriteFileIfChanged Method = "REQWriteFileIfChanged"
 	// Read the source file to be copied to some node.
 	REQCopyFileFrom Method = "REQCopyFileFrom"
 	// Write the destination copied to some node.
 	REQCopyFileTo Method = "REQCopyFileTo"
+	// Resume a copy of a file to this node from the bytes already
+	// received.
+	REQCopyFileResume Method = "REQCopyFileResume"
+	// REQCopyDirFrom will copy a directory recursively to another node, by
+	// sending each directory and each chunk of the files with REQCopyDirTo.
+	REQCopyDirFrom Method = "REQCopyDirFrom"
+	// REQCopyDirTo will create a directory, or write a chunk of a file, sent
+	// by REQCopyDirFrom.
+	REQCopyDirTo Method = "REQCopyDirTo"
 	// Send Hello I'm here message.
 	REQHello Method = "REQHello"
 	// Error log methods to centralError node.
@@ -110,8 +144,21 @@ const (
 	// Http Get Scheduled
 	// The second element of the MethodArgs slice holds the timer defined in seconds.
 	REQHttpGetScheduled Method = "REQHttpGetScheduled"
+	// Http Post
+	// The first element of the MethodArgs slice holds the URL, and the
+	// optional second the Content-Type. The rest are headers on the form
+	// "Name: value". The Data of the message is the body.
+	REQHttpPost Method = "REQHttpPost"
 	// Tail file
 	REQTailFile Method = "REQTailFile"
+	// Stop a tail started with REQTailFile. The first methodArg is the
+	// ID of the REQTailFile message.
+	REQTailFileStop Method = "REQTailFileStop"
+	// REQScheduled will publish the message given on an interval, until
+	// the count given is reached or it is stopped with REQScheduledStop.
+	REQScheduled Method = "REQScheduled"
+	// REQScheduledStop will stop a schedule started with REQScheduled.
+	REQScheduledStop Method = "REQScheduledStop"
 	// Write to steward socket
 	REQRelay Method = "REQRelay"
 	// The method handler for the first step in a relay chain.
@@ -160,6 +207,219 @@ const (
 	REQAclExport = "REQAclExport"
 	// REQAclImport
 	REQAclImport = "REQAclImport"
+	// REQAclWhoCan will reply with the source nodes allowed to run a
+	// command on a host node, with the node and command groups expanded.
+	// The MethodArgs are the host node, and the command to check.
+	REQAclWhoCan Method = "REQAclWhoCan"
+	// REQCloneNodeConfig will copy the acl's and node group memberships
+	// of a source node to a target node on central.
+	// The MethodArgs are the source node, the target node, and optionally
+	// overwrite or merge for how to handle existing entries for the target.
+	REQCloneNodeConfig Method = "REQCloneNodeConfig"
+
+	// REQSetMessageDefaults will set the default values to use for the
+	// fields of a message that are not specified when a message enters
+	// the system on the node.
+	// The first element of the MethodArgs holds the defaults given as
+	// a JSON message, e.g. {"ACKTimeout":10,"retries":3}.
+	REQSetMessageDefaults Method = "REQSetMessageDefaults"
+	// REQSetPriorityPolicy will set the priority tiers of the methods, used
+	// for the messages that do not specify their own priority. The policy is
+	// given as JSON in the first element of the MethodArgs, like
+	// {"REQCliCommand":"high"}.
+	REQSetPriorityPolicy Method = "REQSetPriorityPolicy"
+	// REQGenerateKeypairFor will generate a keypair on central for the node
+	// given, allow the public key, and reply with the private key encrypted
+	// for the operator that sent the request.
+	REQGenerateKeypairFor Method = "REQGenerateKeypairFor"
+	// REQKeysRotate will generate a new ed25519 signing key pair on the node,
+	// replace the old keys, and send the new public key to central. The old
+	// public key is still accepted for SignKeysRotateGrace seconds.
+	REQKeysRotate Method = "REQKeysRotate"
+	// REQReindexDataFolder will walk the SubscribersDataFolder and rebuild
+	// the index of all the stored reply files found.
+	REQReindexDataFolder Method = "REQReindexDataFolder"
+	// REQSearchDataFolder will search the index of stored reply files.
+	// The first element of the MethodArgs holds the query given as a
+	// JSON message, e.g. {"node":"ship1","method":"REQCliCommand"}.
+	REQSearchDataFolder Method = "REQSearchDataFolder"
+	// REQDegradedMode will enable or disable degraded mode on a node.
+	// When in degraded mode only the read-only methods are allowed.
+	// The first element of the MethodArgs is true to enable, or false to
+	// disable degraded mode.
+	REQDegradedMode Method = "REQDegradedMode"
+	// REQVerifyDataIntegrity will re-read the stored reply files in the
+	// data index, and compare them with the checksums recorded when the
+	// files were written.
+	// The first element of the MethodArgs can hold a JSON query to limit
+	// the files to verify, e.g. {"node":"ship1"}.
+	REQVerifyDataIntegrity Method = "REQVerifyDataIntegrity"
+	// REQCompressStoredReplies will gzip the stored reply files in the data
+	// folder older than the age given in the first element of the MethodArgs,
+	// like "72h". The default age is 24 hours. Compressed files are read
+	// transparently by the methods retrieving files when asked for by the
+	// original name.
+	REQCompressStoredReplies Method = "REQCompressStoredReplies"
+	// REQSubscribeMetrics will continually send the current values of the
+	// metrics named back to the requester until the method times out.
+	// The first element of the MethodArgs is the interval in seconds, and
+	// the following elements are the names of the metrics to send.
+	REQSubscribeMetrics Method = "REQSubscribeMetrics"
+	// REQPartialUpdateFile will do an in place edit of some of the lines of
+	// a file, like replacing the lines matching a regexp, inserting a line
+	// after a marker, or setting a key in a section of an ini or yaml file.
+	// The MethodArgs are the file path, the operation, and the arguments
+	// for the operation. The file must be within the AllowedFileRoots.
+	REQPartialUpdateFile Method = "REQPartialUpdateFile"
+	// REQConnectionAudit will reply with the active and recent connections
+	// made to the local unix socket, tcp and http listeners of the node.
+	REQConnectionAudit Method = "REQConnectionAudit"
+	// REQCentralReplicate are sent from the central to the standby central
+	// node with a snapshot of the central auth state in the Data field, so
+	// the standby are ready to take over with REQFailover.
+	REQCentralReplicate Method = "REQCentralReplicate"
+	// REQFailover will promote a standby node to central. The replicated
+	// central auth state are loaded, the central services are started, and
+	// all the known nodes are told about the new central with REQCentralChanged.
+	REQFailover Method = "REQFailover"
+	// REQCentralChanged tells a node that a new node are serving as central.
+	// The first element of the MethodArgs is the name of the new central.
+	REQCentralChanged Method = "REQCentralChanged"
+	// REQInspectSignature will verify the signature of the message given as
+	// JSON in the first element of the MethodArgs without executing it, and
+	// reply with the signed string, the signature, the public key used, and
+	// the result of the verification.
+	REQInspectSignature Method = "REQInspectSignature"
+	// REQInspectAllowedSignatures will reply with the nodes whose
+	// signatures are trusted, and the fingerprints of their public keys.
+	REQInspectAllowedSignatures Method = "REQInspectAllowedSignatures"
+	// REQEnvInfo will reply with the commands the node sending the
+	// message is allowed to run on a node, from the ACL of the node.
+	// When sent to central the MethodArgs can be the host node to check.
+	REQEnvInfo Method = "REQEnvInfo"
+	// REQPublicKeysList will reply with the fingerprints of the public keys
+	// known by the node, the hash of the keys and the number of keys.
+	REQPublicKeysList Method = "REQPublicKeysList"
+	// REQValidateReachability will ask the nodes given in the MethodArgs to
+	// ping each other, and reply with a matrix showing which of the nodes are
+	// able to reach each other. If no nodes are given all the nodes with an
+	// allowed public key are checked. Only available on central.
+	REQValidateReachability Method = "REQValidateReachability"
+	// REQReachabilityProbe will ping the nodes given, and reply with which
+	// of them replied within the timeout. Used by REQValidateReachability.
+	REQReachabilityProbe Method = "REQReachabilityProbe"
+	// REQReachabilityPing is the ping sent by REQReachabilityProbe.
+	REQReachabilityPing Method = "REQReachabilityPing"
+	// REQReachabilityResult will receive the replies of the pings, and the
+	// results of the probes for a reachability check.
+	REQReachabilityResult Method = "REQReachabilityResult"
+	// REQResourceLimitExec will run a CLI command like REQCliCommand, but
+	// with resource limits. The first element of the MethodArgs is the limits
+	// like "memory=64M,cpu=10,nofile=128", and the rest is the command and
+	// its arguments. The command is killed if it exceeds any of the limits.
+	REQResourceLimitExec Method = "REQResourceLimitExec"
+	// REQSyncTime will set the system clock of the node from the time of the
+	// central, adjusted for the round trip time. The time is requested from
+	// the central with REQTimeNow, and set when the reply REQSyncTimeApply is
+	// received. Corrections larger than TimeSyncMaxJump are refused unless
+	// the first element of the MethodArgs is "force".
+	REQSyncTime Method = "REQSyncTime"
+	// REQTimeNow will reply with the current time of the node in RFC3339
+	// format with nanoseconds.
+	REQTimeNow Method = "REQTimeNow"
+	// REQSyncTimeApply is the reply to REQTimeNow when syncing the time with
+	// REQSyncTime, and will apply the time correction to the system clock.
+	REQSyncTimeApply Method = "REQSyncTimeApply"
+	// REQNodeInfo will reply with a snapshot of the health of the node as
+	// JSON, like the host, the version of steward, the uptime, and the number
+	// of processes and goroutines.
+	REQNodeInfo Method = "REQNodeInfo"
+	// REQListErrorSinks will reply with the sinks the errors are forwarded
+	// to, and the health of each sink as JSON.
+	REQListErrorSinks Method = "REQListErrorSinks"
+	// REQManageErrorSink will enable, disable or change the settings of an
+	// error sink while running. The arguments are the name of the sink, the
+	// action which is enable, disable or setURL, and the value if needed.
+	REQManageErrorSink Method = "REQManageErrorSink"
+	// REQMeasureThroughput will measure the throughput to a node by sending
+	// it random data, and reply with the bytes per second achieved.
+	REQMeasureThroughput Method = "REQMeasureThroughput"
+	// REQThroughputDiscard will discard the data of a throughput test, and
+	// reply with the number of bytes received.
+	REQThroughputDiscard Method = "REQThroughputDiscard"
+	// REQThroughputAck will register the reply of REQThroughputDiscard for
+	// a running throughput test.
+	REQThroughputAck Method = "REQThroughputAck"
+	// REQQuery will query one of the registered read-only providers by the
+	// name given in the first argument, and reply with the result as JSON.
+	REQQuery Method = "REQQuery"
+	// REQBulkFileFetch will fetch the files given in the MethodArgs, and reply
+	// with them as a gzipped tar archive. The files must be within the
+	// AllowedFileRoots.
+	REQBulkFileFetch Method = "REQBulkFileFetch"
+	// REQFileTemplateRenderPreview will render the file template given in the
+	// MethodArgs with the variables given, and reply with the result without
+	// writing any file.
+	REQFileTemplateRenderPreview Method = "REQFileTemplateRenderPreview"
+	// REQAttachMetadata will reply with the metadata attached to the message
+	// as JSON.
+	REQAttachMetadata Method = "REQAttachMetadata"
+	// REQReconcileState will make the node match the desired state document
+	// given in the MethodArgs, and reply with the actions taken.
+	REQReconcileState Method = "REQReconcileState"
+	// REQShutdownScheduled will schedule a graceful shutdown of the node at
+	// the time given, or cancel a scheduled shutdown.
+	REQShutdownScheduled Method = "REQShutdownScheduled"
+	// REQValidateTrustStore will check the integrity of the stored public
+	// keys, their hash, and the signing keys of the node.
+	REQValidateTrustStore Method = "REQValidateTrustStore"
+	// REQReplicateTo are sent from the central to the standby nodes with
+	// the central auth state each time it changes.
+	REQReplicateTo Method = "REQReplicateTo"
+	// REQReplicateToAck are the acknowledgement from a standby node of the
+	// state received with REQReplicateTo.
+	REQReplicateToAck Method = "REQReplicateToAck"
+	// REQInspectProcessGoroutines will reply with the number of subscriber
+	// handler go routines in flight for each process, and the total number
+	// of go routines.
+	REQInspectProcessGoroutines Method = "REQInspectProcessGoroutines"
+	// REQListFailedMessages will list the messages in the dead letter store,
+	// filtered by the JSON query given in the first methodArgs.
+	REQListFailedMessages Method = "REQListFailedMessages"
+	// REQInspectRetryState will reply with the state of the messages being
+	// delivered and retried by the publishers of the node.
+	REQInspectRetryState Method = "REQInspectRetryState"
+	// REQInspectTimeouts will reply with the effective timeout and retry
+	// values of the message given in the first methodArgs, after the
+	// defaults are applied, and the worst case time the message can take.
+	REQInspectTimeouts Method = "REQInspectTimeouts"
+	// REQRunWithLock will run the command given in methodArgs while
+	// holding the named lock on central, so only one node at a time
+	// runs a command with the same lock name.
+	REQRunWithLock Method = "REQRunWithLock"
+	// REQLockAcquire will on central give a named lock to the holder
+	// if it is free, or renew the lease of the lock.
+	REQLockAcquire Method = "REQLockAcquire"
+	// REQLockRelease will on central release a named lock.
+	REQLockRelease Method = "REQLockRelease"
+	// REQLockResult is the reply method used to deliver the reply from
+	// central about a lock to the REQRunWithLock waiting for it.
+	REQLockResult Method = "REQLockResult"
+	// REQProbeMethod will reply with if the node supports the method
+	// given in methodArgs, and if the sender is authorized to use it,
+	// without doing the method.
+	REQProbeMethod Method = "REQProbeMethod"
+	// REQChangeNodeName will on the central migrate all the references
+	// to a node from the old name to the new name given in methodArgs, and
+	// tell the node to adopt the new name with REQAdoptNodeName.
+	REQChangeNodeName Method = "REQChangeNodeName"
+	// REQAdoptNodeName will write the new node name given in methodArgs
+	// to the config file of the node, to be used after the next restart.
+	REQAdoptNodeName Method = "REQAdoptNodeName"
+	// REQExportAuditBundle will on the central write a bundle with the
+	// acl's and public keys signed with the key of the central, and reply
+	// with the path and the signature.
+	REQExportAuditBundle Method = "REQExportAuditBundle"
 )
 
 // The mapping of all the method constants specified, what type
@@ -189,12 +449,33 @@ func (m Method) GetMethodsAvailable() MethodsAvailable {
 			REQOpProcessStop: methodREQOpProcessStop{
 				event: EventACK,
 			},
+			REQProcessRestart: methodREQProcessRestart{
+				event: EventACK,
+			},
+			REQProcessMetrics: methodREQProcessMetrics{
+				event: EventACK,
+			},
+			REQSubjectSubscribeList: methodREQSubjectSubscribeList{
+				event: EventACK,
+			},
 			REQCliCommand: methodREQCliCommand{
 				event: EventACK,
 			},
 			REQCliCommandCont: methodREQCliCommandCont{
 				event: EventACK,
 			},
+			REQStreamCommand: methodREQStreamCommand{
+				event: EventACK,
+			},
+			REQStreamCommandInput: methodREQStreamCommandInput{
+				event: EventACK,
+			},
+			REQStreamCommandClose: methodREQStreamCommandClose{
+				event: EventACK,
+			},
+			REQListActiveSessions: methodREQListActiveSessions{
+				event: EventACK,
+			},
 			REQToConsole: methodREQToConsole{
 				event: EventACK,
 			},
@@ -210,12 +491,24 @@ func (m Method) GetMethodsAvailable() MethodsAvailable {
 			REQToFileNACK: methodREQToFile{
 				event: EventNACK,
 			},
+			REQWriteFileIfChanged: methodREQWriteFileIfChanged{
+				event: EventACK,
+			},
 			REQCopyFileFrom: methodREQCopyFileFrom{
 				event: EventACK,
 			},
 			REQCopyFileTo: methodREQCopyFileTo{
 				event: EventACK,
 			},
+			REQCopyFileResume: methodREQCopyFileResume{
+				event: EventACK,
+			},
+			REQCopyDirFrom: methodREQCopyDirFrom{
+				event: EventACK,
+			},
+			REQCopyDirTo: methodREQCopyDirTo{
+				event: EventACK,
+			},
 			REQHello: methodREQHello{
 				event: EventNACK,
 			},
@@ -234,9 +527,21 @@ func (m Method) GetMethodsAvailable() MethodsAvailable {
 			REQHttpGetScheduled: methodREQHttpGetScheduled{
 				event: EventACK,
 			},
+			REQHttpPost: methodREQHttpPost{
+				event: EventACK,
+			},
 			REQTailFile: methodREQTailFile{
 				event: EventACK,
 			},
+			REQTailFileStop: methodREQTailFileStop{
+				event: EventACK,
+			},
+			REQScheduled: methodREQScheduled{
+				event: EventACK,
+			},
+			REQScheduledStop: methodREQScheduledStop{
+				event: EventACK,
+			},
 			REQRelay: methodREQRelay{
 				event: EventACK,
 			},
@@ -299,6 +604,174 @@ func (m Method) GetMethodsAvailable() MethodsAvailable {
 			REQAclImport: methodREQAclImport{
 				event: EventACK,
 			},
+			REQAclWhoCan: methodREQAclWhoCan{
+				event: EventACK,
+			},
+			REQCloneNodeConfig: methodREQCloneNodeConfig{
+				event: EventACK,
+			},
+			REQSetMessageDefaults: methodREQSetMessageDefaults{
+				event: EventACK,
+			},
+			REQSetPriorityPolicy: methodREQSetPriorityPolicy{
+				event: EventACK,
+			},
+			REQGenerateKeypairFor: methodREQGenerateKeypairFor{
+				event: EventACK,
+			},
+			REQKeysRotate: methodREQKeysRotate{
+				event: EventACK,
+			},
+			REQReindexDataFolder: methodREQReindexDataFolder{
+				event: EventACK,
+			},
+			REQSearchDataFolder: methodREQSearchDataFolder{
+				event: EventACK,
+			},
+			REQDegradedMode: methodREQDegradedMode{
+				event: EventACK,
+			},
+			REQVerifyDataIntegrity: methodREQVerifyDataIntegrity{
+				event: EventACK,
+			},
+			REQCompressStoredReplies: methodREQCompressStoredReplies{
+				event: EventACK,
+			},
+			REQSubscribeMetrics: methodREQSubscribeMetrics{
+				event: EventACK,
+			},
+			REQPartialUpdateFile: methodREQPartialUpdateFile{
+				event: EventACK,
+			},
+			REQConnectionAudit: methodREQConnectionAudit{
+				event: EventACK,
+			},
+			REQCentralReplicate: methodREQCentralReplicate{
+				event: EventACK,
+			},
+			REQFailover: methodREQFailover{
+				event: EventACK,
+			},
+			REQCentralChanged: methodREQCentralChanged{
+				event: EventACK,
+			},
+			REQInspectSignature: methodREQInspectSignature{
+				event: EventACK,
+			},
+			REQInspectAllowedSignatures: methodREQInspectAllowedSignatures{
+				event: EventACK,
+			},
+			REQEnvInfo: methodREQEnvInfo{
+				event: EventACK,
+			},
+			REQPublicKeysList: methodREQPublicKeysList{
+				event: EventACK,
+			},
+			REQValidateReachability: methodREQValidateReachability{
+				event: EventACK,
+			},
+			REQReachabilityProbe: methodREQReachabilityProbe{
+				event: EventACK,
+			},
+			REQReachabilityPing: methodREQReachabilityPing{
+				event: EventACK,
+			},
+			REQReachabilityResult: methodREQReachabilityResult{
+				event: EventACK,
+			},
+			REQResourceLimitExec: methodREQResourceLimitExec{
+				event: EventACK,
+			},
+			REQSyncTime: methodREQSyncTime{
+				event: EventACK,
+			},
+			REQTimeNow: methodREQTimeNow{
+				event: EventACK,
+			},
+			REQSyncTimeApply: methodREQSyncTimeApply{
+				event: EventACK,
+			},
+			REQNodeInfo: methodREQNodeInfo{
+				event: EventACK,
+			},
+			REQListErrorSinks: methodREQListErrorSinks{
+				event: EventACK,
+			},
+			REQManageErrorSink: methodREQManageErrorSink{
+				event: EventACK,
+			},
+			REQMeasureThroughput: methodREQMeasureThroughput{
+				event: EventACK,
+			},
+			REQThroughputDiscard: methodREQThroughputDiscard{
+				event: EventACK,
+			},
+			REQThroughputAck: methodREQThroughputAck{
+				event: EventACK,
+			},
+			REQQuery: methodREQQuery{
+				event: EventACK,
+			},
+			REQBulkFileFetch: methodREQBulkFileFetch{
+				event: EventACK,
+			},
+			REQFileTemplateRenderPreview: methodREQFileTemplateRenderPreview{
+				event: EventACK,
+			},
+			REQAttachMetadata: methodREQAttachMetadata{
+				event: EventACK,
+			},
+			REQReconcileState: methodREQReconcileState{
+				event: EventACK,
+			},
+			REQShutdownScheduled: methodREQShutdownScheduled{
+				event: EventACK,
+			},
+			REQValidateTrustStore: methodREQValidateTrustStore{
+				event: EventACK,
+			},
+			REQReplicateTo: methodREQReplicateTo{
+				event: EventACK,
+			},
+			REQReplicateToAck: methodREQReplicateToAck{
+				event: EventACK,
+			},
+			REQInspectProcessGoroutines: methodREQInspectProcessGoroutines{
+				event: EventACK,
+			},
+			REQListFailedMessages: methodREQListFailedMessages{
+				event: EventACK,
+			},
+			REQInspectRetryState: methodREQInspectRetryState{
+				event: EventACK,
+			},
+			REQInspectTimeouts: methodREQInspectTimeouts{
+				event: EventACK,
+			},
+			REQRunWithLock: methodREQRunWithLock{
+				event: EventACK,
+			},
+			REQLockAcquire: methodREQLockAcquire{
+				event: EventACK,
+			},
+			REQLockRelease: methodREQLockRelease{
+				event: EventACK,
+			},
+			REQLockResult: methodREQLockResult{
+				event: EventACK,
+			},
+			REQProbeMethod: methodREQProbeMethod{
+				event: EventACK,
+			},
+			REQChangeNodeName: methodREQChangeNodeName{
+				event: EventACK,
+			},
+			REQAdoptNodeName: methodREQAdoptNodeName{
+				event: EventACK,
+			},
+			REQExportAuditBundle: methodREQExportAuditBundle{
+				event: EventACK,
+			},
 			REQTest: methodREQTest{
 				event: EventACK,
 			},
@@ -312,7 +785,7 @@ func (m Method) GetMethodsAvailable() MethodsAvailable {
 // the Stew client for knowing what of the req types are generally
 // used as reply methods.
 func (m Method) GetReplyMethods() []Method {
-	rm := []Method{REQToConsole, REQTuiToConsole, REQCliCommand, REQCliCommandCont, REQToFile, REQToFileAppend, REQNone}
+	rm := []Method{REQToConsole, REQTuiToConsole, REQCliCommand, REQCliCommandCont, REQToFile, REQToFileAppend, REQWriteFileIfChanged, REQNone}
 	return rm
 }
 
@@ -351,6 +824,10 @@ func (m methodREQInitial) getKind() Event {
 	return m.event
 }
 
+func (m methodREQInitial) isReadOnly() bool {
+	return false
+}
+
 func (m methodREQInitial) handler(proc process, message Message, node string) ([]byte, error) {
 	// proc.procFuncCh <- message
 	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
@@ -412,6 +889,7 @@ func newReplyMessage(proc process, message Message, outData []byte) {
 	// we don't need to for the reply message.
 	thisMsg := message
 	thisMsg.Data = nil
+	thisMsg.Metadata = copyMetadata(message.Metadata)
 
 	// Create a new message for the reply, and put it on the
 	// ringbuffer to be published.
@@ -428,6 +906,11 @@ func newReplyMessage(proc process, message Message, outData []byte) {
 		Retries:       message.ReplyRetries,
 		Directory:     message.Directory,
 		FileName:      message.FileName,
+		Metadata:      copyMetadata(message.Metadata),
+		Compression:   message.Compression,
+		ConsoleLevel:  message.ConsoleLevel,
+		ConsolePrefix: message.ConsolePrefix,
+		CorrelationID: message.CorrelationID,
 
 		// Put in a copy of the initial request message, so we can use it's properties if
 		// needed to for example create the file structure naming on the subscriber.
@@ -447,26 +930,63 @@ func newReplyMessage(proc process, message Message, outData []byte) {
 // selectFileNaming will figure out the correct naming of the file
 // structure to use for the reply data.
 // It will return the filename, and the tree structure for the folders
-// to create.
-func selectFileNaming(message Message, proc process) (string, string) {
+// to create. An error is returned if the file would not be within the
+// subscribers data folder.
+func selectFileNaming(message Message, proc process) (string, string, error) {
 	var fileName string
-	var folderTree string
+	var directory string
+	var node Node
+	request := message
 
 	switch {
 	case message.PreviousMessage == nil:
 		// If this was a direct request there are no previous message to take
 		// information from, so we use the one that are in the current mesage.
 		fileName = message.FileName
-		folderTree = filepath.Join(proc.configuration.SubscribersDataFolder, message.Directory, string(message.ToNode))
+		directory = message.Directory
+		node = message.ToNode
 	case message.PreviousMessage.ToNode != "":
 		fileName = message.PreviousMessage.FileName
-		folderTree = filepath.Join(proc.configuration.SubscribersDataFolder, message.PreviousMessage.Directory, string(message.PreviousMessage.ToNode))
+		directory = message.PreviousMessage.Directory
+		node = message.PreviousMessage.ToNode
+		request = *message.PreviousMessage
 	case message.PreviousMessage.ToNode == "":
 		fileName = message.PreviousMessage.FileName
-		folderTree = filepath.Join(proc.configuration.SubscribersDataFolder, message.PreviousMessage.Directory, string(message.FromNode))
+		directory = message.PreviousMessage.Directory
+		node = message.FromNode
+		request = *message.PreviousMessage
+	}
+
+	// Use the folder naming template of the node if one is set.
+	if proc.server != nil && proc.server.replyFolderTemplate != nil {
+		d := newReplyFolderData(node, directory, request, time.Now())
+		folder, err := executeReplyFolderTemplate(proc.server.replyFolderTemplate, d)
+		if err == nil {
+			return checkFileNaming(proc, fileName, folder)
+		}
+
+		er := fmt.Errorf("error: selectFileNaming: replyFolderTemplate: %v, using the default folder naming", err)
+		proc.errorKernel.errSend(proc, message, er)
+	}
+
+	return checkFileNaming(proc, fileName, directory, string(node))
+}
+
+// checkFileNaming will return the file name, and the folder tree made
+// from the folder elements within the subscribers data folder. An error
+// is returned if the folder tree or the file would be outside of the
+// subscribers data folder.
+func checkFileNaming(proc process, fileName string, folder ...string) (string, string, error) {
+	folderTree, err := dataFolderPath(proc.configuration.SubscribersDataFolder, folder...)
+	if err != nil {
+		return "", "", err
+	}
+	_, err = dataFolderPath(folderTree, fileName)
+	if err != nil {
+		return "", "", err
 	}
 
-	return fileName, folderTree
+	return fileName, folderTree, nil
 }
 
 // ------------------------------------------------------------
@@ -477,4 +997,8 @@ func selectFileNaming(message Message, proc process) (string, string) {
 type methodHandler interface {
 	handler(proc process, message Message, node string) ([]byte, error)
 	getKind() Event
+	// isReadOnly should return true if the method do not change any
+	// state on the node, like executing commands or writing files.
+	// Only read-only methods are allowed when in degraded mode.
+	isReadOnly() bool
 }
@@ -19,6 +19,10 @@ func (m methodREQAclRequestUpdate) getKind() Event {
 	return m.event
 }
 
+func (m methodREQAclRequestUpdate) isReadOnly() bool {
+	return true
+}
+
 // Handler to get all acl's from a central server.
 func (m methodREQAclRequestUpdate) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- subscriber methodREQAclRequestUpdate received from: %v, hash data = %v", message.FromNode, message.Data)
@@ -113,6 +117,10 @@ func (m methodREQAclDeliverUpdate) getKind() Event {
 	return m.event
 }
 
+func (m methodREQAclDeliverUpdate) isReadOnly() bool {
+	return false
+}
+
 // Handler to receive the acls from a central server.
 func (m methodREQAclDeliverUpdate) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- subscriber methodREQAclDeliverUpdate received from: %v, containing: %v", message.FromNode, message.Data)
@@ -168,6 +176,15 @@ func (m methodREQAclDeliverUpdate) handler(proc process, message Message, node s
 				}
 			}
 
+			// Only use the acl if it is the one central hashed.
+			err = verifyACLHash(Node(node), mapOfFromNodeCommands, hdh.Hash)
+			if err != nil {
+				proc.nodeAuth.nodeAcl.mu.Unlock()
+				er := fmt.Errorf("error: subscriber REQAclDeliverUpdate : rejected the acl delivered from %v: %v", message.FromNode, err)
+				proc.errorKernel.errSend(proc, message, er)
+				return
+			}
+
 			proc.nodeAuth.nodeAcl.aclAndHash.Hash = hdh.Hash
 			proc.nodeAuth.nodeAcl.aclAndHash.Acl = mapOfFromNodeCommands
 
@@ -202,6 +219,10 @@ func (m methodREQAclAddCommand) getKind() Event {
 	return m.event
 }
 
+func (m methodREQAclAddCommand) isReadOnly() bool {
+	return false
+}
+
 func (m methodREQAclAddCommand) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- methodREQAclAddCommand received from: %v, containing: %v", message.FromNode, message.MethodArgs)
 	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
@@ -229,7 +250,11 @@ func (m methodREQAclAddCommand) handler(proc process, message Message, node stri
 			source := message.MethodArgs[1]
 			cmd := message.MethodArgs[2]
 
-			proc.centralAuth.aclAddCommand(Node(host), Node(source), command(cmd))
+			err := proc.centralAuth.aclAddCommand(Node(host), Node(source), command(cmd))
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQAclAddAccessList: %v", err)
+				return
+			}
 
 			outString := fmt.Sprintf("acl added: host=%v, source=%v, command=%v\n", host, source, cmd)
 			out := []byte(outString)
@@ -272,6 +297,10 @@ func (m methodREQAclDeleteCommand) getKind() Event {
 	return m.event
 }
 
+func (m methodREQAclDeleteCommand) isReadOnly() bool {
+	return false
+}
+
 func (m methodREQAclDeleteCommand) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- methodREQAclDeleteCommand received from: %v, containing: %v", message.FromNode, message.MethodArgs)
 	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
@@ -342,6 +371,10 @@ func (m methodREQAclDeleteSource) getKind() Event {
 	return m.event
 }
 
+func (m methodREQAclDeleteSource) isReadOnly() bool {
+	return false
+}
+
 func (m methodREQAclDeleteSource) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- methodREQAclDeleteSource received from: %v, containing: %v", message.FromNode, message.MethodArgs)
 	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
@@ -411,6 +444,10 @@ func (m methodREQAclGroupNodesAddNode) getKind() Event {
 	return m.event
 }
 
+func (m methodREQAclGroupNodesAddNode) isReadOnly() bool {
+	return false
+}
+
 func (m methodREQAclGroupNodesAddNode) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- methodREQAclGroupNodesAddNode received from: %v, containing: %v", message.FromNode, message.MethodArgs)
 	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
@@ -480,6 +517,10 @@ func (m methodREQAclGroupNodesDeleteNode) getKind() Event {
 	return m.event
 }
 
+func (m methodREQAclGroupNodesDeleteNode) isReadOnly() bool {
+	return false
+}
+
 func (m methodREQAclGroupNodesDeleteNode) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- methodREQAclGroupNodesDeleteNode received from: %v, containing: %v", message.FromNode, message.MethodArgs)
 	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
@@ -549,6 +590,10 @@ func (m methodREQAclGroupNodesDeleteGroup) getKind() Event {
 	return m.event
 }
 
+func (m methodREQAclGroupNodesDeleteGroup) isReadOnly() bool {
+	return false
+}
+
 func (m methodREQAclGroupNodesDeleteGroup) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- methodREQAclGroupNodesDeleteGroup received from: %v, containing: %v", message.FromNode, message.MethodArgs)
 	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
@@ -617,6 +662,10 @@ func (m methodREQAclGroupCommandsAddCommand) getKind() Event {
 	return m.event
 }
 
+func (m methodREQAclGroupCommandsAddCommand) isReadOnly() bool {
+	return false
+}
+
 func (m methodREQAclGroupCommandsAddCommand) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- methodREQAclGroupCommandsAddCommand received from: %v, containing: %v", message.FromNode, message.MethodArgs)
 	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
@@ -686,6 +735,10 @@ func (m methodREQAclGroupCommandsDeleteCommand) getKind() Event {
 	return m.event
 }
 
+func (m methodREQAclGroupCommandsDeleteCommand) isReadOnly() bool {
+	return false
+}
+
 func (m methodREQAclGroupCommandsDeleteCommand) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- methodREQAclGroupCommandsDeleteCommand received from: %v, containing: %v", message.FromNode, message.MethodArgs)
 	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
@@ -755,6 +808,10 @@ func (m methodREQAclGroupCommandsDeleteGroup) getKind() Event {
 	return m.event
 }
 
+func (m methodREQAclGroupCommandsDeleteGroup) isReadOnly() bool {
+	return false
+}
+
 func (m methodREQAclGroupCommandsDeleteGroup) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- methodREQAclGroupCommandsDeleteGroup received from: %v, containing: %v", message.FromNode, message.MethodArgs)
 	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
@@ -823,6 +880,10 @@ func (m methodREQAclExport) getKind() Event {
 	return m.event
 }
 
+func (m methodREQAclExport) isReadOnly() bool {
+	return true
+}
+
 func (m methodREQAclExport) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- methodREQAclExport received from: %v, containing: %v", message.FromNode, message.MethodArgs)
 	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
@@ -840,7 +901,17 @@ func (m methodREQAclExport) handler(proc process, message Message, node string)
 		go func() {
 			defer proc.processes.wg.Done()
 
-			out, err := proc.centralAuth.exportACLs()
+			var out []byte
+			var err error
+
+			// With a format given the acl's and the groups are exported as
+			// a document that can be edited and imported again.
+			switch {
+			case len(message.MethodArgs) > 0 && message.MethodArgs[0] != "":
+				out, err = proc.centralAuth.exportACLDocument(message.MethodArgs[0])
+			default:
+				out, err = proc.centralAuth.exportACLs()
+			}
 			if err != nil {
 				errCh <- fmt.Errorf("error: methodREQAclExport failed: %v", err)
 				return
@@ -879,9 +950,6 @@ func (m methodREQAclExport) handler(proc process, message Message, node string)
 
 // ---
 
-// TODO: IMPORTANT: We also need to add importing and exporting of the groups to the
-//  import and export methods.
-
 type methodREQAclImport struct {
 	event Event
 }
@@ -890,6 +958,10 @@ func (m methodREQAclImport) getKind() Event {
 	return m.event
 }
 
+func (m methodREQAclImport) isReadOnly() bool {
+	return false
+}
+
 func (m methodREQAclImport) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- methodREQAclImport received from: %v, containing: %v", message.FromNode, message.MethodArgs)
 	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
@@ -915,7 +987,25 @@ func (m methodREQAclImport) handler(proc process, message Message, node string)
 			}
 
 			js := []byte(message.MethodArgs[0])
-			err := proc.centralAuth.importACLs(js)
+
+			var err error
+			switch {
+			case isACLDocument(js):
+				var replace bool
+				if len(message.MethodArgs) > 1 {
+					switch message.MethodArgs[1] {
+					case "merge":
+					case "replace":
+						replace = true
+					default:
+						errCh <- fmt.Errorf("error: methodREQAclImport: unknown mode %q, want merge or replace", message.MethodArgs[1])
+						return
+					}
+				}
+				err = proc.centralAuth.importACLDocument(js, replace)
+			default:
+				err = proc.centralAuth.importACLs(js)
+			}
 			if err != nil {
 				errCh <- fmt.Errorf("error: methodREQAclImport failed: %v", err)
 				return
@@ -951,3 +1041,427 @@ func (m methodREQAclImport) handler(proc process, message Message, node string)
 	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
 	return ackMsg, nil
 }
+
+// ---
+
+type methodREQAclWhoCan struct {
+	event Event
+}
+
+func (m methodREQAclWhoCan) getKind() Event {
+	return m.event
+}
+
+func (m methodREQAclWhoCan) isReadOnly() bool {
+	return true
+}
+
+// Handler to find the source nodes allowed to run a command on a host
+// node. The first methodArg is the host node, and the rest are the
+// command, given the same way as the methodArgs of a REQCliCommand.
+// The reply is the source nodes in JSON, with the commands of the ACL
+// that allowed each of them.
+func (m methodREQAclWhoCan) handler(proc process, message Message, node string) ([]byte, error) {
+	inf := fmt.Errorf("<--- methodREQAclWhoCan received from: %v, containing: %v", message.FromNode, message.MethodArgs)
+	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
+
+	if len(message.MethodArgs) < 2 {
+		er := fmt.Errorf("error: methodREQAclWhoCan: got <2 number methodArgs, want the host node and the command")
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	host := Node(message.MethodArgs[0])
+	cmd := argsToString(message.MethodArgs[1:])
+
+	r, err := proc.centralAuth.aclWhoCan(host, cmd)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQAclWhoCan: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	out, err := json.Marshal(r)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQAclWhoCan: failed to marshal result: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	newReplyMessage(proc, message, out)
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ---
+
+type methodREQEnvInfo struct {
+	event Event
+}
+
+func (m methodREQEnvInfo) getKind() Event {
+	return m.event
+}
+
+func (m methodREQEnvInfo) isReadOnly() bool {
+	return true
+}
+
+// Handler to reply with the commands the node sending the message is
+// allowed to run, as JSON, with the explicit and the wildcard grants
+// given separately. Only the ACL of the node sending the message is
+// given. When sent to a node the ACL received from central is used.
+// When sent to central the first methodArg can be the host node to
+// check, and the ACL generated for that host is used.
+func (m methodREQEnvInfo) handler(proc process, message Message, node string) ([]byte, error) {
+	inf := fmt.Errorf("<--- methodREQEnvInfo received from: %v, containing: %v", message.FromNode, message.MethodArgs)
+	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
+
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		var r aclEnvInfo
+
+		switch {
+		case len(message.MethodArgs) == 0 || message.MethodArgs[0] == "" || message.MethodArgs[0] == node:
+			r = proc.nodeAuth.nodeAcl.envInfo(Node(node), message.FromNode)
+
+		case proc.configuration.isCentralAuth():
+			var err error
+			r, err = proc.centralAuth.aclEnvInfo(Node(message.MethodArgs[0]), message.FromNode)
+			if err != nil {
+				er := fmt.Errorf("error: methodREQEnvInfo: %v", err)
+				proc.errorKernel.errSend(proc, message, er)
+				return
+			}
+
+		default:
+			er := fmt.Errorf("error: methodREQEnvInfo: the acl for host %v can only be given by central, send the message to %v instead", message.MethodArgs[0], message.MethodArgs[0])
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		out, err := json.Marshal(r)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQEnvInfo: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ---
+
+type methodREQCloneNodeConfig struct {
+	event Event
+}
+
+func (m methodREQCloneNodeConfig) getKind() Event {
+	return m.event
+}
+
+func (m methodREQCloneNodeConfig) isReadOnly() bool {
+	return false
+}
+
+// Handler to copy the acl's and node group memberships of a source node
+// to a target node. The reply is a JSON report of what was copied.
+func (m methodREQCloneNodeConfig) handler(proc process, message Message, node string) ([]byte, error) {
+	inf := fmt.Errorf("<--- methodREQCloneNodeConfig received from: %v, containing: %v", message.FromNode, message.MethodArgs)
+	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
+
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		// Get a context with the timeout specified in message.MethodTimeout.
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+
+		outCh := make(chan []byte)
+		errCh := make(chan error)
+
+		proc.processes.wg.Add(1)
+		go func() {
+			defer proc.processes.wg.Done()
+
+			switch {
+			case len(message.MethodArgs) < 2:
+				errCh <- fmt.Errorf("error: methodREQCloneNodeConfig: got <2 number methodArgs, want source node, target node, and optionally overwrite or merge")
+				return
+			}
+
+			source := message.MethodArgs[0]
+			target := message.MethodArgs[1]
+
+			overwrite := false
+			if len(message.MethodArgs) > 2 {
+				switch message.MethodArgs[2] {
+				case "overwrite":
+					overwrite = true
+				case "merge":
+				default:
+					errCh <- fmt.Errorf("error: methodREQCloneNodeConfig: third methodArg must be overwrite or merge, got: %v", message.MethodArgs[2])
+					return
+				}
+			}
+
+			report, err := proc.centralAuth.cloneNodeConfig(Node(source), Node(target), overwrite)
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQCloneNodeConfig failed: %v", err)
+				return
+			}
+
+			out, err := json.Marshal(report)
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQCloneNodeConfig: failed to marshal report: %v", err)
+				return
+			}
+
+			select {
+			case outCh <- out:
+			case <-ctx.Done():
+				return
+			}
+		}()
+
+		select {
+		case err := <-errCh:
+			proc.errorKernel.errSend(proc, message, err)
+
+		case <-ctx.Done():
+			cancel()
+			er := fmt.Errorf("error: methodREQCloneNodeConfig: method timed out")
+			proc.errorKernel.errSend(proc, message, er)
+
+		case out := <-outCh:
+			// Prepare and queue for sending a new message with the output
+			// of the action executed.
+			newReplyMessage(proc, message, out)
+		}
+
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ---
+
+type methodREQChangeNodeName struct {
+	event Event
+}
+
+func (m methodREQChangeNodeName) getKind() Event {
+	return m.event
+}
+
+func (m methodREQChangeNodeName) isReadOnly() bool {
+	return false
+}
+
+// Handler to rename a node on the central. All the acl's, node group
+// memberships, the public key and the data folders of the old name are
+// migrated to the new name, and the node is told to adopt the new name.
+// The reply is a JSON report of what was migrated.
+func (m methodREQChangeNodeName) handler(proc process, message Message, node string) ([]byte, error) {
+	inf := fmt.Errorf("<--- methodREQChangeNodeName received from: %v, containing: %v", message.FromNode, message.MethodArgs)
+	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
+
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		switch {
+		case len(message.MethodArgs) < 2:
+			er := fmt.Errorf("error: methodREQChangeNodeName: got <2 number methodArgs, want old node name and new node name")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		oldName := Node(message.MethodArgs[0])
+		newName := Node(message.MethodArgs[1])
+
+		report, err := proc.server.changeNodeName(oldName, newName)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQChangeNodeName failed: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		out, err := json.Marshal(report)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQChangeNodeName: failed to marshal report: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		// Tell the node to adopt the new name. The node is still running
+		// with the old name, so we send it to the old name.
+		msg := Message{
+			ToNode:        oldName,
+			FromNode:      Node(proc.node),
+			Method:        REQAdoptNodeName,
+			MethodArgs:    []string{string(oldName), string(newName)},
+			ACKTimeout:    message.ACKTimeout,
+			Retries:       message.Retries,
+			CorrelationID: message.CorrelationID,
+		}
+		sam, err := newSubjectAndMessage(msg)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQChangeNodeName: newSubjectAndMessage: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+		} else {
+			proc.toRingbufferCh <- []subjectAndMessage{sam}
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ---
+
+type methodREQAdoptNodeName struct {
+	event Event
+}
+
+func (m methodREQAdoptNodeName) getKind() Event {
+	return m.event
+}
+
+func (m methodREQAdoptNodeName) isReadOnly() bool {
+	return false
+}
+
+// Handler to adopt the new node name given by the central when the node
+// was renamed with REQChangeNodeName. The new name is written to the
+// config file, and is used when the node is restarted, since all the
+// subjects of the running processes are using the current name. Only
+// the central are allowed to rename a node, and the message must have
+// a valid signature from it, even if signature checking is not enabled.
+func (m methodREQAdoptNodeName) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		switch {
+		case len(message.MethodArgs) < 2:
+			er := fmt.Errorf("error: methodREQAdoptNodeName: got <2 number methodArgs, want old node name and new node name")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		case message.FromNode != Node(proc.configuration.centralNode()):
+			er := fmt.Errorf("error: methodREQAdoptNodeName: rename received from %v, but only the central %v can rename the node", message.FromNode, proc.configuration.centralNode())
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		case !proc.nodeAuth.requireSignature(message):
+			er := fmt.Errorf("error: methodREQAdoptNodeName: the rename from %v do not have a valid signature", message.FromNode)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		case message.MethodArgs[0] != proc.configuration.NodeName:
+			er := fmt.Errorf("error: methodREQAdoptNodeName: old node name %v is not the name of this node %v", message.MethodArgs[0], proc.configuration.NodeName)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newName := message.MethodArgs[1]
+		if err := validateNodeName(newName); err != nil {
+			er := fmt.Errorf("error: methodREQAdoptNodeName: invalid new node name: the name %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		// Use the config file if we got one, so we do not write the
+		// values given as flags to the config file.
+		conf, err := proc.configuration.ReadConfigFile(proc.configuration.ConfigFolder)
+		if err != nil {
+			conf = *proc.configuration
+		}
+		conf.ConfigFolder = proc.configuration.ConfigFolder
+		conf.NodeName = newName
+
+		err = conf.WriteConfigFile()
+		if err != nil {
+			er := fmt.Errorf("error: methodREQAdoptNodeName: failed to write config file: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		out := []byte(fmt.Sprintf("node name changed from %v to %v in the config file, restart the node to use the new name\n", message.MethodArgs[0], newName))
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ---
+
+type methodREQExportAuditBundle struct {
+	event Event
+}
+
+func (m methodREQExportAuditBundle) getKind() Event {
+	return m.event
+}
+
+func (m methodREQExportAuditBundle) isReadOnly() bool {
+	return false
+}
+
+// Handler to export a signed audit bundle of the acl's and public keys
+// on central. The bundle is written to the folder given in the first
+// methodArgs within the data folder, or to the audit folder in the data
+// folder if not given.
+// The reply is a JSON with the paths of the files, the signature, and
+// the public key to verify the signature with.
+func (m methodREQExportAuditBundle) handler(proc process, message Message, node string) ([]byte, error) {
+	inf := fmt.Errorf("<--- methodREQExportAuditBundle received from: %v, containing: %v", message.FromNode, message.MethodArgs)
+	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
+
+	workDone := proc.startHandlerWork()
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+		defer workDone()
+
+		dir := "audit"
+		if len(message.MethodArgs) > 0 && message.MethodArgs[0] != "" {
+			dir = message.MethodArgs[0]
+		}
+
+		folder, err := dataFolderPath(proc.configuration.SubscribersDataFolder, dir)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQExportAuditBundle: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		r, err := proc.server.exportAuditBundle(folder)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQExportAuditBundle: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		out, err := json.Marshal(r)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQExportAuditBundle: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
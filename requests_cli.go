@@ -3,9 +3,15 @@ package steward
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"sort"
 	"strings"
+	"time"
 )
 
 type methodREQCliCommand struct {
@@ -16,6 +22,10 @@ func (m methodREQCliCommand) getKind() Event {
 	return m.event
 }
 
+func (m methodREQCliCommand) isReadOnly() bool {
+	return false
+}
+
 // handler to run a CLI command with timeout context. The handler will
 // return the output of the command run back to the calling publisher
 // as a new message.
@@ -27,9 +37,11 @@ func (m methodREQCliCommand) handler(proc process, message Message, node string)
 	// to return immediately with an ack reply that the messag was
 	// received, and we create a new message to send back to the calling
 	// node for the out put of the actual command.
+	workDone := proc.startHandlerWork()
 	proc.processes.wg.Add(1)
 	go func() {
 		defer proc.processes.wg.Done()
+		defer workDone()
 
 		var a []string
 
@@ -48,7 +60,10 @@ func (m methodREQCliCommand) handler(proc process, message Message, node string)
 		// Get a context with the timeout specified in message.MethodTimeout.
 		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
 
-		outCh := make(chan []byte)
+		// The command is killed when the method timeout is reached, and
+		// the output is always delivered, so the channel is buffered for
+		// the result to be delivered after the timeout.
+		outCh := make(chan cliCommandResult, 1)
 
 		proc.processes.wg.Add(1)
 		go func() {
@@ -70,7 +85,16 @@ func (m methodREQCliCommand) handler(proc process, message Message, node string)
 				}
 			}
 
-			cmd := exec.CommandContext(ctx, c, a...)
+			cmd := exec.Command(c, a...)
+
+			err := setCommandEnvAndDir(cmd, message)
+			if err != nil {
+				er := fmt.Errorf("error: methodREQCliCommand: %v, methodArgs: %v", err, message.MethodArgs)
+				proc.errorKernel.errSend(proc, message, er)
+
+				outCh <- cliCommandResult{out: []byte(er.Error() + "\n")}
+				return
+			}
 
 			// Check for the use of env variable for STEWARD_DATA, and set env if found.
 			if foundEnvData {
@@ -78,32 +102,65 @@ func (m methodREQCliCommand) handler(proc process, message Message, node string)
 				cmd.Env = append(cmd.Env, envData)
 			}
 
+			if message.DryRun {
+				out, err := cliCommandDryRunOutput(cmd)
+				if err != nil {
+					er := fmt.Errorf("error: methodREQCliCommand: dry run failed: %v, methodArgs: %v", err, message.MethodArgs)
+					proc.errorKernel.errSend(proc, message, er)
+					out = []byte(er.Error() + "\n")
+				}
+
+				outCh <- cliCommandResult{out: out}
+				return
+			}
+
 			var out bytes.Buffer
 			var stderr bytes.Buffer
 			cmd.Stdout = &out
 			cmd.Stderr = &stderr
+			setCommandStdin(cmd, message)
 
-			err := cmd.Run()
-			if err != nil {
+			killed, err := runCommandGroup(ctx, cmd)
+			switch {
+			case killed:
+				er := fmt.Errorf("error: methodREQCliCommand: method timed out after %v seconds, killed the command and its child processes: %v", message.MethodTimeout, message.MethodArgs)
+				proc.errorKernel.errSend(proc, message, er)
+				out.WriteString(er.Error() + "\n")
+			case err != nil:
 				er := fmt.Errorf("error: methodREQCliCommand: cmd.Run failed : %v, methodArgs: %v, error_output: %v", err, message.MethodArgs, stderr.String())
 				proc.errorKernel.errSend(proc, message, er)
 			}
 
-			select {
-			case outCh <- out.Bytes():
-			case <-ctx.Done():
-				return
+			// The exit status is not known if the command was not started.
+			r := cliCommandResult{out: out.Bytes()}
+			switch {
+			case killed:
+				r.exitStatus = cliCommandKilled
+				r.exitCode = -1
+			case cmd.ProcessState != nil:
+				r.exitStatus = cliCommandExited
+				r.exitCode = cmd.ProcessState.ExitCode()
 			}
+
+			outCh <- r
 		}()
 
 		select {
-		case <-ctx.Done():
+		case <-proc.ctx.Done():
 			cancel()
-			er := fmt.Errorf("error: methodREQCliCommand: method timed out: %v", message.MethodArgs)
-			proc.errorKernel.errSend(proc, message, er)
-		case out := <-outCh:
+		case r := <-outCh:
 			cancel()
 
+			// Tell in the metadata of the reply how the command ended.
+			if r.exitStatus != "" {
+				message.Metadata = copyMetadata(message.Metadata)
+				if message.Metadata == nil {
+					message.Metadata = make(map[string]string)
+				}
+				message.Metadata["exitStatus"] = r.exitStatus
+				message.Metadata["exitCode"] = fmt.Sprint(r.exitCode)
+			}
+
 			// NB: Not quite sure what is the best way to handle the below
 			// isReply right now. Implementing as send to central for now.
 			//
@@ -115,7 +172,7 @@ func (m methodREQCliCommand) handler(proc process, message Message, node string)
 
 			// Prepare and queue for sending a new message with the output
 			// of the action executed.
-			newReplyMessage(proc, message, out)
+			newReplyMessage(proc, message, r.out)
 		}
 
 	}()
@@ -124,6 +181,136 @@ func (m methodREQCliCommand) handler(proc process, message Message, node string)
 	return ackMsg, nil
 }
 
+// How a command run by REQCliCommand ended, as given in the exitStatus
+// metadata of the reply.
+const (
+	cliCommandExited = "exited"
+	cliCommandKilled = "killed"
+)
+
+// cliCommandResult is the output of a command run by REQCliCommand, and
+// how the command ended. exitStatus is empty if the command was not run.
+type cliCommandResult struct {
+	out        []byte
+	exitStatus string
+	exitCode   int
+}
+
+// runCommandGroup will run the command in its own process group, and
+// kill the whole group when the context is done, so the child processes
+// started by the command are killed too, and not just the command as
+// with exec.CommandContext. killed is true if the command was killed
+// because the context was done. On windows process groups are not
+// supported, so only the command itself is killed.
+func runCommandGroup(ctx context.Context, cmd *exec.Cmd) (killed bool, err error) {
+	setProcessGroup(cmd)
+
+	err = cmd.Start()
+	if err != nil {
+		return false, err
+	}
+
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-waitCh:
+		return false, err
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		return true, <-waitCh
+	}
+}
+
+// setCommandEnvAndDir will set the environment variables and the working
+// directory of the message for the command. The environment variables
+// are merged over the environment of steward. An error is returned if
+// the working directory is not an existing directory, or a name of an
+// environment variable is not valid, so the command is not run.
+func setCommandEnvAndDir(cmd *exec.Cmd, message Message) error {
+	if message.WorkDir != "" {
+		fi, err := os.Stat(message.WorkDir)
+		switch {
+		case err != nil:
+			return fmt.Errorf("invalid working directory %v: %v", message.WorkDir, err)
+		case !fi.IsDir():
+			return fmt.Errorf("invalid working directory %v: not a directory", message.WorkDir)
+		}
+		cmd.Dir = message.WorkDir
+	}
+
+	if len(message.Env) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(message.Env))
+	for k := range message.Env {
+		if k == "" || strings.ContainsAny(k, "=\x00") {
+			return fmt.Errorf("invalid name of environment variable: %q", k)
+		}
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	// When the same variable is given more than once the last one is
+	// used, so the variables of the message override the inherited.
+	cmd.Env = os.Environ()
+	for _, k := range names {
+		cmd.Env = append(cmd.Env, k+"="+message.Env[k])
+	}
+
+	return nil
+}
+
+// setCommandStdin will give the stdin of the message to the command.
+// The stdin is written by exec in its own go routine while the output
+// is read, so a large stdin does not block on an unread stdout, and
+// stdin is closed when all is written.
+func setCommandStdin(cmd *exec.Cmd, message Message) {
+	if message.Stdin != "" {
+		cmd.Stdin = strings.NewReader(message.Stdin)
+	}
+}
+
+// cliCommandDryRun is the reply of REQCliCommand in dry run mode.
+type cliCommandDryRun struct {
+	// The path of the executable, resolved from the PATH of the node.
+	Path string `json:"path"`
+	// The argv of the command, with the command itself as the first
+	// element.
+	Args []string `json:"args"`
+	// The working directory the command would be run in.
+	Dir string `json:"dir"`
+	// Tells why the executable was not found, if it was not.
+	Error string `json:"error,omitempty"`
+}
+
+// cliCommandDryRunOutput will return the command as it would be executed
+// as JSON, without executing it.
+func cliCommandDryRunOutput(cmd *exec.Cmd) ([]byte, error) {
+	d := cliCommandDryRun{
+		Path: cmd.Path,
+		Args: cmd.Args,
+		Dir:  cmd.Dir,
+	}
+
+	if d.Dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get working directory: %v", err)
+		}
+		d.Dir = wd
+	}
+
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		d.Error = err.Error()
+	}
+
+	return json.Marshal(d)
+}
+
 // ---
 
 type methodREQCliCommandCont struct {
@@ -134,6 +321,10 @@ func (m methodREQCliCommandCont) getKind() Event {
 	return m.event
 }
 
+func (m methodREQCliCommandCont) isReadOnly() bool {
+	return false
+}
+
 // Handler to run REQCliCommandCont, which is the same as normal
 // Cli command, but can be used when running a command that will take
 // longer time and you want to send the output of the command continually
@@ -146,9 +337,11 @@ func (m methodREQCliCommandCont) handler(proc process, message Message, node str
 	// to return immediately with an ack reply that the message was
 	// received, and we create a new message to send back to the calling
 	// node for the out put of the actual command.
+	workDone := proc.startHandlerWork()
 	proc.processes.wg.Add(1)
 	go func() {
 		defer proc.processes.wg.Done()
+		defer workDone()
 
 		defer func() {
 			// fmt.Printf(" * DONE *\n")
@@ -168,19 +361,52 @@ func (m methodREQCliCommandCont) handler(proc process, message Message, node str
 
 		c := message.MethodArgs[0]
 
+		outputMode := message.OutputMode
+		switch outputMode {
+		case "":
+			outputMode = cliCommandContOutputLine
+		case cliCommandContOutputLine, cliCommandContOutputChunk:
+		default:
+			er := fmt.Errorf("error: methodREQCliCommandCont: unknown outputMode %q, want %v or %v", message.OutputMode, cliCommandContOutputLine, cliCommandContOutputChunk)
+			proc.errorKernel.errSend(proc, message, er)
+
+			return
+		}
+
+		maxOutput := message.MaxOutputBytes
+		if maxOutput == 0 {
+			maxOutput = proc.configuration.CliCommandContMaxOutputBytes
+		}
+
 		// Get a context with the timeout specified in message.MethodTimeout.
 		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
 		// deadline, _ := ctx.Deadline()
 		// fmt.Printf(" * DEBUG * deadline : %v\n", deadline)
 
 		outCh := make(chan []byte)
-		errCh := make(chan string)
+		errCh := make(chan []byte)
 
 		proc.processes.wg.Add(1)
 		go func() {
 			defer proc.processes.wg.Done()
 
 			cmd := exec.CommandContext(ctx, c, a...)
+			// Start the command in its own process group, so the child
+			// processes of a shell are also killed when done.
+			setProcessGroup(cmd)
+
+			err := setCommandEnvAndDir(cmd, message)
+			if err != nil {
+				er := fmt.Errorf("error: methodREQCliCommandCont: %v, methodArgs: %v", err, message.MethodArgs)
+				proc.errorKernel.errSend(proc, message, er)
+
+				select {
+				case errCh <- []byte(er.Error()):
+				case <-ctx.Done():
+				}
+				cancel()
+				return
+			}
 
 			// Using cmd.StdoutPipe here so we are continuosly
 			// able to read the out put of the command.
@@ -196,35 +422,26 @@ func (m methodREQCliCommandCont) handler(proc process, message Message, node str
 				proc.errorKernel.errSend(proc, message, er)
 			}
 
+			setCommandStdin(cmd, message)
+
 			if err := cmd.Start(); err != nil {
 				er := fmt.Errorf("error: methodREQCliCommandCont: cmd.Start failed : %v, methodArgs: %v", err, message.MethodArgs)
 				proc.errorKernel.errSend(proc, message, er)
 			}
 
-			go func() {
-				scanner := bufio.NewScanner(ErrorReader)
-				for scanner.Scan() {
-					errCh <- scanner.Text()
-				}
-			}()
-
-			go func() {
-				scanner := bufio.NewScanner(outReader)
-				for scanner.Scan() {
-					outCh <- []byte(scanner.Text() + "\n")
-				}
-			}()
+			go streamCommandOutput(ctx, ErrorReader, outputMode, errCh)
+			go streamCommandOutput(ctx, outReader, outputMode, outCh)
 
 			// NB: sending cancel to command context, so processes are killed.
 			// A github issue is filed on not killing all child processes when using pipes:
 			// https://github.com/golang/go/issues/23019
-			// TODO: Check in later if there are any progress on the issue.
-			// When testing the problem seems to appear when using sudo, or tcpdump without
-			// the -l option. So for now, don't use sudo, and remember to use -l with tcpdump
-			// which makes stdout line buffered.
+			// The command context only kills the command itself, so we also
+			// kill the process group of the command to kill the child
+			// processes.
 
 			<-ctx.Done()
 			cancel()
+			killProcessGroup(cmd)
 
 			if err := cmd.Wait(); err != nil {
 				er := fmt.Errorf("info: methodREQCliCommandCont: method timeout reached, canceled: methodArgs: %v, %v", message.MethodArgs, err)
@@ -233,6 +450,34 @@ func (m methodREQCliCommandCont) handler(proc process, message Message, node str
 
 		}()
 
+		// The number of bytes of output delivered.
+		var delivered int
+
+		// deliver will send the output back, and return false if the max
+		// output size was reached. Then the output up to the max is
+		// delivered, the command is killed, and a truncation notice is
+		// sent as the final reply.
+		deliver := func(out []byte) bool {
+			if maxOutput > 0 && delivered+len(out) > maxOutput {
+				if n := maxOutput - delivered; n > 0 {
+					newReplyMessage(proc, message, out[:n])
+					delivered += n
+				}
+				cancel()
+
+				notice := fmt.Sprintf("output truncated: max output of %v bytes reached, the command was killed\n", maxOutput)
+				newReplyMessage(proc, message, []byte(notice))
+
+				er := fmt.Errorf("info: methodREQCliCommandCont: max output of %v bytes reached, command killed: methodArgs: %v", maxOutput, message.MethodArgs)
+				proc.errorKernel.infoSend(proc, message, er)
+				return false
+			}
+
+			newReplyMessage(proc, message, out)
+			delivered += len(out)
+			return true
+		}
+
 		// Check if context timer or command output were received.
 		for {
 			select {
@@ -243,13 +488,451 @@ func (m methodREQCliCommandCont) handler(proc process, message Message, node str
 				return
 			case out := <-outCh:
 				// fmt.Printf(" * out: %v\n", string(out))
-				newReplyMessage(proc, message, out)
+				if !deliver(out) {
+					return
+				}
 			case out := <-errCh:
-				newReplyMessage(proc, message, []byte(out))
+				if !deliver(out) {
+					return
+				}
+			}
+		}
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+const (
+	// The output of REQCliCommandCont is replied line by line, which is
+	// the default.
+	cliCommandContOutputLine = "line"
+	// The output of REQCliCommandCont is replied as it is read, in
+	// chunks flushed by size or time.
+	cliCommandContOutputChunk = "chunk"
+
+	// The max length of a line in line mode. A longer line is replied
+	// in pieces of this length, so a line is never buffered unbounded.
+	cliCommandContMaxLineBytes = 64 * 1024
+	// In chunk mode the output read is replied when this many bytes are
+	// read, or when cliCommandContChunkInterval have passed since the
+	// last reply.
+	cliCommandContChunkBytes    = 32 * 1024
+	cliCommandContChunkInterval = time.Millisecond * 500
+)
+
+// streamCommandOutput will read the output of a command, and send it on
+// outCh in the output mode given, until the output is closed or the
+// context is done. In line mode every line is sent with its newline,
+// and in chunk mode the bytes are sent as they are read.
+func streamCommandOutput(ctx context.Context, r io.Reader, mode string, outCh chan<- []byte) {
+	send := func(b []byte) bool {
+		select {
+		case outCh <- b:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if mode != cliCommandContOutputChunk {
+		br := bufio.NewReaderSize(r, cliCommandContMaxLineBytes)
+		for {
+			line, err := br.ReadSlice('\n')
+			// The slice is only valid until the next read, so it is copied.
+			out := append([]byte(nil), line...)
+			if err == io.EOF && len(out) > 0 {
+				out = append(out, '\n')
+			}
+			if len(out) > 0 && !send(out) {
+				return
+			}
+			// A line longer than the buffer is sent in pieces.
+			if err != nil && err != bufio.ErrBufferFull {
+				return
+			}
+		}
+	}
+
+	readCh := make(chan []byte)
+	go func() {
+		defer close(readCh)
+		buf := make([]byte, cliCommandContChunkBytes)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				select {
+				case readCh <- append([]byte(nil), buf[:n]...):
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				return
 			}
 		}
 	}()
 
+	ticker := time.NewTicker(cliCommandContChunkInterval)
+	defer ticker.Stop()
+
+	var pending []byte
+	for {
+		select {
+		case b, ok := <-readCh:
+			if !ok {
+				if len(pending) > 0 {
+					send(pending)
+				}
+				return
+			}
+			pending = append(pending, b...)
+			for len(pending) >= cliCommandContChunkBytes {
+				if !send(pending[:cliCommandContChunkBytes]) {
+					return
+				}
+				pending = append([]byte(nil), pending[cliCommandContChunkBytes:]...)
+			}
+		case <-ticker.C:
+			if len(pending) > 0 {
+				if !send(pending) {
+					return
+				}
+				pending = nil
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ---
+
+type methodREQResourceLimitExec struct {
+	event Event
+}
+
+func (m methodREQResourceLimitExec) getKind() Event {
+	return m.event
+}
+
+func (m methodREQResourceLimitExec) isReadOnly() bool {
+	return false
+}
+
+// Handler to run a CLI command with resource limits. The first element
+// of the methodArgs holds the limits, and the rest are the command and
+// its arguments. If the command is killed for exceeding a limit, the
+// reason is sent as an error, and added to the output replied back.
+func (m methodREQResourceLimitExec) handler(proc process, message Message, node string) ([]byte, error) {
+	inf := fmt.Errorf("<--- REQResourceLimitExec received from: %v, containing: %v", message.FromNode, message.MethodArgs)
+	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
+
+	workDone := proc.startHandlerWork()
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+		defer workDone()
+
+		switch {
+		case len(message.MethodArgs) < 2:
+			er := fmt.Errorf("error: methodREQResourceLimitExec: got <2 number methodArgs, want limits and command")
+			proc.errorKernel.errSend(proc, message, er)
+
+			return
+		}
+
+		limits, err := parseResourceLimits(message.MethodArgs[0])
+		if err != nil {
+			er := fmt.Errorf("error: methodREQResourceLimitExec: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+
+			return
+		}
+
+		c := message.MethodArgs[1]
+		a := message.MethodArgs[2:]
+
+		// Get a context with the timeout specified in message.MethodTimeout.
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+
+		outCh := make(chan []byte)
+
+		proc.processes.wg.Add(1)
+		go func() {
+			defer proc.processes.wg.Done()
+
+			out, stderr, limitExceeded, err := runWithResourceLimits(ctx, limits, c, a)
+			switch {
+			case limitExceeded != "":
+				er := fmt.Errorf("error: methodREQResourceLimitExec: command stopped by resource limit: %v, methodArgs: %v", limitExceeded, message.MethodArgs)
+				proc.errorKernel.errSend(proc, message, er)
+
+				out = append(out, []byte("stopped by resource limit: "+limitExceeded+"\n")...)
+			case err != nil:
+				er := fmt.Errorf("error: methodREQResourceLimitExec: command failed : %v, methodArgs: %v, error_output: %s", err, message.MethodArgs, stderr)
+				proc.errorKernel.errSend(proc, message, er)
+			}
+
+			select {
+			case outCh <- out:
+			case <-ctx.Done():
+				return
+			}
+		}()
+
+		select {
+		case <-ctx.Done():
+			cancel()
+			er := fmt.Errorf("error: methodREQResourceLimitExec: method timed out: %v", message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+		case out := <-outCh:
+			cancel()
+
+			// Prepare and queue for sending a new message with the output
+			// of the action executed.
+			newReplyMessage(proc, message, out)
+		}
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ---
+
+type methodREQStreamCommand struct {
+	event Event
+}
+
+func (m methodREQStreamCommand) getKind() Event {
+	return m.event
+}
+
+func (m methodREQStreamCommand) isReadOnly() bool {
+	return false
+}
+
+// Handler to run an interactive command. The methodArgs are the id of
+// the session, and then the command and its arguments. The output of the
+// command is sent back continually as it is generated, and input can be
+// written to the command with REQStreamCommandInput using the same
+// session id. The session ends when the command exits, the method timeout
+// is reached, or it is closed with REQStreamCommandClose, and the command
+// is killed if still running.
+func (m methodREQStreamCommand) handler(proc process, message Message, node string) ([]byte, error) {
+	inf := fmt.Errorf("<--- REQStreamCommand received from: %v, containing: %v", message.FromNode, message.MethodArgs)
+	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
+
+	workDone := proc.startHandlerWork()
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+		defer workDone()
+
+		switch {
+		case len(message.MethodArgs) < 2:
+			er := fmt.Errorf("error: methodREQStreamCommand: got <2 number methodArgs, want session id and command")
+			proc.errorKernel.errSend(proc, message, er)
+
+			return
+		}
+
+		id := message.MethodArgs[0]
+		c := message.MethodArgs[1]
+		a := message.MethodArgs[2:]
+
+		// Get a context with the timeout specified in message.MethodTimeout,
+		// which is the max time the session can be open.
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, c, a...)
+
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			er := fmt.Errorf("error: methodREQStreamCommand: cmd.StdinPipe failed : %v, methodArgs: %v", err, message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		// Both stdout and stderr are sent back to the operator.
+		outReader, outWriter := io.Pipe()
+		cmd.Stdout = outWriter
+		cmd.Stderr = outWriter
+
+		ss := streamSession{
+			fromNode: message.FromNode,
+			stdin:    stdin,
+			cancel:   cancel,
+			kind:     sessionKindStreamCommand,
+			method:   message.Method,
+			started:  time.Now(),
+		}
+		err = proc.server.streamSessions.add(id, &ss)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQStreamCommand: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+		defer proc.server.streamSessions.remove(id)
+
+		if err := cmd.Start(); err != nil {
+			er := fmt.Errorf("error: methodREQStreamCommand: cmd.Start failed : %v, methodArgs: %v", err, message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		go func() {
+			err := cmd.Wait()
+			if err != nil && ctx.Err() == nil {
+				er := fmt.Errorf("error: methodREQStreamCommand: command failed: %v, methodArgs: %v", err, message.MethodArgs)
+				proc.errorKernel.errSend(proc, message, er)
+			}
+			outWriter.Close()
+		}()
+
+		// Put the session id in the metadata so it follows the replies.
+		message.Metadata = copyMetadata(message.Metadata)
+		if message.Metadata == nil {
+			message.Metadata = make(map[string]string)
+		}
+		message.Metadata["streamSession"] = id
+
+		// Read what is available instead of lines, so prompts that are
+		// not ended with a new line are also sent back.
+		buf := make([]byte, 4096)
+		for {
+			n, err := outReader.Read(buf)
+			if n > 0 {
+				out := make([]byte, n)
+				copy(out, buf[:n])
+				newReplyMessage(proc, message, out)
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		if ctx.Err() == context.DeadlineExceeded {
+			er := fmt.Errorf("info: methodREQStreamCommand: session timeout reached, command killed: session: %v, methodArgs: %v", id, message.MethodArgs)
+			proc.errorKernel.infoSend(proc, message, er)
+		}
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ---
+
+type methodREQStreamCommandInput struct {
+	event Event
+}
+
+func (m methodREQStreamCommandInput) getKind() Event {
+	return m.event
+}
+
+func (m methodREQStreamCommandInput) isReadOnly() bool {
+	return false
+}
+
+// Handler to write input to the command of a stream session. The
+// methodArgs are the id of the session, and the input that are joined
+// with spaces and written as one line to the stdin of the command.
+func (m methodREQStreamCommandInput) handler(proc process, message Message, node string) ([]byte, error) {
+	func() {
+		if len(message.MethodArgs) < 1 {
+			er := fmt.Errorf("error: methodREQStreamCommandInput: got <1 number methodArgs, want session id")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		ss, err := proc.server.streamSessions.get(message.MethodArgs[0], message.FromNode)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQStreamCommandInput: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		_, err = io.WriteString(ss.stdin, strings.Join(message.MethodArgs[1:], " ")+"\n")
+		if err != nil {
+			er := fmt.Errorf("error: methodREQStreamCommandInput: failed to write to session %v: %v", message.MethodArgs[0], err)
+			proc.errorKernel.errSend(proc, message, er)
+		}
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ---
+
+type methodREQStreamCommandClose struct {
+	event Event
+}
+
+func (m methodREQStreamCommandClose) getKind() Event {
+	return m.event
+}
+
+func (m methodREQStreamCommandClose) isReadOnly() bool {
+	return false
+}
+
+// Handler to close a stream session, where the command of the session is
+// killed if it is still running. The methodArgs is the id of the session.
+func (m methodREQStreamCommandClose) handler(proc process, message Message, node string) ([]byte, error) {
+	if len(message.MethodArgs) < 1 {
+		er := fmt.Errorf("error: methodREQStreamCommandClose: got <1 number methodArgs, want session id")
+		proc.errorKernel.errSend(proc, message, er)
+	} else {
+		ss, err := proc.server.streamSessions.get(message.MethodArgs[0], message.FromNode)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQStreamCommandClose: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+		} else {
+			ss.cancel()
+		}
+	}
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ---
+
+type methodREQListActiveSessions struct {
+	event Event
+}
+
+func (m methodREQListActiveSessions) getKind() Event {
+	return m.event
+}
+
+func (m methodREQListActiveSessions) isReadOnly() bool {
+	return true
+}
+
+// Handler to reply with the active sessions of the node as JSON, with
+// the id, kind, originating node, method and start time of each
+// session. A session can be ended with REQStreamCommandClose.
+func (m methodREQListActiveSessions) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		js, err := json.Marshal(proc.server.streamSessions.list())
+		if err != nil {
+			er := fmt.Errorf("error: methodREQListActiveSessions: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, js)
+	}()
+
 	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
 	return ackMsg, nil
 }
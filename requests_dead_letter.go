@@ -0,0 +1,88 @@
+package steward
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type methodREQListFailedMessages struct {
+	event Event
+}
+
+func (m methodREQListFailedMessages) getKind() Event {
+	return m.event
+}
+
+func (m methodREQListFailedMessages) isReadOnly() bool {
+	return true
+}
+
+// Handler to list the messages in the dead letter store. The first
+// methodArgs can hold a JSON query to filter on destination node,
+// method and time range. The result is replied as JSON with the
+// matching entries and the counts per node and method.
+func (m methodREQListFailedMessages) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		var q deadLetterQuery
+		if len(message.MethodArgs) > 0 && message.MethodArgs[0] != "" {
+			err := json.Unmarshal([]byte(message.MethodArgs[0]), &q)
+			if err != nil {
+				er := fmt.Errorf("error: methodREQListFailedMessages: failed to unmarshal query: %v", err)
+				proc.errorKernel.errSend(proc, message, er)
+				return
+			}
+		}
+
+		js, err := json.Marshal(proc.server.deadLetters.list(q))
+		if err != nil {
+			er := fmt.Errorf("error: methodREQListFailedMessages: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, js)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- InspectRetryState
+
+type methodREQInspectRetryState struct {
+	event Event
+}
+
+func (m methodREQInspectRetryState) getKind() Event {
+	return m.event
+}
+
+func (m methodREQInspectRetryState) isReadOnly() bool {
+	return true
+}
+
+// Handler to reply with the state of the messages the node is currently
+// delivering and waiting for an ACK for, or retrying, as JSON. For each
+// message the destination, the attempts done, the time of the next
+// attempt, and the last error are given.
+func (m methodREQInspectRetryState) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		js, err := json.Marshal(proc.server.retryRegistry.list())
+		if err != nil {
+			er := fmt.Errorf("error: methodREQInspectRetryState: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, js)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
@@ -0,0 +1,315 @@
+package steward
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- CentralReplicate
+
+type methodREQCentralReplicate struct {
+	event Event
+}
+
+func (m methodREQCentralReplicate) getKind() Event {
+	return m.event
+}
+
+func (m methodREQCentralReplicate) isReadOnly() bool {
+	return false
+}
+
+// Handler to store the central auth state replicated from the central
+// on the standby node. The state is sent as a replication event, the same
+// as with REQReplicateTo, and is stored in the same way. Only state sent
+// from the current central is accepted.
+func (m methodREQCentralReplicate) handler(proc process, message Message, node string) ([]byte, error) {
+	inf := fmt.Errorf("<--- REQCentralReplicate received from: %v, containing: %v bytes", message.FromNode, len(message.Data))
+	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
+
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		_, err := applyReplicationEvent(proc, message)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQCentralReplicate: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, []byte("central state replicated to "+node))
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// applyReplicationEvent will check that the replication event in the
+// message is sent from the current central, and that the hash matches
+// the state, before storing the state on the standby node. Events older
+// than the last one applied are not stored, so REQCentralReplicate and
+// REQReplicateTo can't overwrite a newer state with an older one.
+func applyReplicationEvent(proc process, message Message) (replicationEvent, error) {
+	if message.FromNode != Node(proc.configuration.centralNode()) {
+		return replicationEvent{}, fmt.Errorf("state received from %v, but the central is %v", message.FromNode, proc.configuration.centralNode())
+	}
+
+	var ev replicationEvent
+	err := json.Unmarshal(message.Data, &ev)
+	if err != nil {
+		return replicationEvent{}, fmt.Errorf("failed to unmarshal replication event: %v", err)
+	}
+
+	err = ev.verify()
+	if err != nil {
+		return replicationEvent{}, err
+	}
+
+	_, err = proc.server.replicaApplied.apply(ev, func() error {
+		return saveCentralReplica(proc.configuration, ev.State)
+	})
+	if err != nil {
+		return replicationEvent{}, err
+	}
+
+	return ev, nil
+}
+
+// --- Failover
+
+type methodREQFailover struct {
+	event Event
+}
+
+func (m methodREQFailover) getKind() Event {
+	return m.event
+}
+
+func (m methodREQFailover) isReadOnly() bool {
+	return false
+}
+
+// Handler to promote a standby node to central. The replicated central
+// auth state are loaded, the central subscribers are started, and all
+// the nodes we have public keys for are told that this node is now the
+// central so they start sending to it. The reply is sent when the node
+// is serving as central.
+func (m methodREQFailover) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		if !proc.configuration.IsStandbyCentral {
+			er := fmt.Errorf("error: methodREQFailover: node %v is not configured as a standby central", node)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		js, err := loadCentralReplica(proc.configuration)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQFailover: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		err = proc.server.centralAuth.importState(js)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQFailover: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		// Start the central subscribers tied to the context of all the
+		// processes, and not to the context of this subscriber.
+		p := proc.server.processInitial
+		p.ctx = proc.processes.ctx
+
+		errorLogger, hello, centralAuth := proc.configuration.promoteToCentral(node)
+		if errorLogger {
+			p.startup.subREQErrorLog(p)
+		}
+		if hello {
+			p.startup.subREQHello(p)
+		}
+		if centralAuth {
+			p.startup.subCentralAuth(p)
+		}
+
+		// Announce the new central to all the nodes we know of.
+		proc.server.centralAuth.pki.nodesAcked.mu.Lock()
+		nodes := []Node{}
+		for n := range proc.server.centralAuth.pki.nodesAcked.keysAndHash.Keys {
+			if n != Node(node) {
+				nodes = append(nodes, n)
+			}
+		}
+		proc.server.centralAuth.pki.nodesAcked.mu.Unlock()
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+		sams := []subjectAndMessage{}
+		for _, n := range nodes {
+			msg := Message{
+				ToNode:        n,
+				FromNode:      Node(node),
+				Method:        REQCentralChanged,
+				MethodArgs:    []string{node},
+				ReplyMethod:   REQNone,
+				ACKTimeout:    proc.configuration.DefaultMessageTimeout,
+				Retries:       proc.configuration.DefaultMessageRetries,
+				CorrelationID: message.CorrelationID,
+			}
+
+			sam, err := newSubjectAndMessage(msg)
+			if err != nil {
+				er := fmt.Errorf("error: methodREQFailover: newSubjectAndMessage failed: %v", err)
+				proc.errorKernel.errSend(proc, message, er)
+				continue
+			}
+			sams = append(sams, sam)
+		}
+		if len(sams) > 0 {
+			proc.toRingbufferCh <- sams
+		}
+
+		er := fmt.Errorf("info: methodREQFailover: %v is now serving as central", node)
+		proc.errorKernel.infoSend(proc, message, er)
+
+		out := fmt.Sprintf("%v is now serving as central, announced to %v nodes: %v", node, len(nodes), nodes)
+		newReplyMessage(proc, message, []byte(out))
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- CentralChanged
+
+type methodREQCentralChanged struct {
+	event Event
+}
+
+func (m methodREQCentralChanged) getKind() Event {
+	return m.event
+}
+
+func (m methodREQCentralChanged) isReadOnly() bool {
+	return false
+}
+
+// Handler to change what node we use as the central. Only the standby
+// central configured for the node are allowed to announce that it is
+// the new central, and the announcement must have a valid signature
+// from it, even if signature checking is not enabled.
+func (m methodREQCentralChanged) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		standby := Node(proc.configuration.StandbyCentralNode)
+
+		switch {
+		case len(message.MethodArgs) < 1:
+			er := fmt.Errorf("error: methodREQCentralChanged: got <1 number methodArgs, want the name of the new central")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		case standby == "":
+			er := fmt.Errorf("error: methodREQCentralChanged: %v announced %v as central, but no standbyCentralNode is configured", message.FromNode, message.MethodArgs[0])
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		case message.FromNode != standby || Node(message.MethodArgs[0]) != standby:
+			er := fmt.Errorf("error: methodREQCentralChanged: %v announced %v as central, only the standby central %v can announce itself", message.FromNode, message.MethodArgs[0], standby)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		case !proc.nodeAuth.requireSignature(message):
+			er := fmt.Errorf("error: methodREQCentralChanged: the announcement from %v do not have a valid signature", message.FromNode)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		proc.configuration.setCentralNode(message.MethodArgs[0])
+
+		er := fmt.Errorf("info: methodREQCentralChanged: %v is now using %v as central", node, message.MethodArgs[0])
+		proc.errorKernel.infoSend(proc, message, er)
+
+		newReplyMessage(proc, message, []byte("central changed to "+message.MethodArgs[0]))
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- ReplicateTo
+
+type methodREQReplicateTo struct {
+	event Event
+}
+
+func (m methodREQReplicateTo) getKind() Event {
+	return m.event
+}
+
+func (m methodREQReplicateTo) isReadOnly() bool {
+	return false
+}
+
+// Handler to apply a replication event with the central auth state on
+// the standby node. Events older than the last one applied are ignored,
+// and the sequence number and the hash of the event are replied back as
+// the acknowledgement. Only events sent from the current central, and
+// where the hash matches the state, are accepted.
+func (m methodREQReplicateTo) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		ev, err := applyReplicationEvent(proc, message)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQReplicateTo: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		// Ack also the events that were not applied since they were old,
+		// so the central know we got them.
+		newReplyMessage(proc, message, []byte(fmt.Sprintf("%v %v", ev.Seq, ev.Hash)))
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- ReplicateToAck
+
+type methodREQReplicateToAck struct {
+	event Event
+}
+
+func (m methodREQReplicateToAck) getKind() Event {
+	return m.event
+}
+
+func (m methodREQReplicateToAck) isReadOnly() bool {
+	return false
+}
+
+// Handler to register the acknowledgement of a replication event from a
+// standby node. The data is the sequence number and the hash of the event.
+func (m methodREQReplicateToAck) handler(proc process, message Message, node string) ([]byte, error) {
+	fields := strings.Fields(string(message.Data))
+	if len(fields) != 2 {
+		er := fmt.Errorf("error: methodREQReplicateToAck: want sequence number and hash, got: %q", message.Data)
+		proc.errorKernel.errSend(proc, message, er)
+	} else if _, err := strconv.ParseInt(fields[0], 10, 64); err != nil {
+		er := fmt.Errorf("error: methodREQReplicateToAck: sequence number is not valid: %v", fields[0])
+		proc.errorKernel.errSend(proc, message, er)
+	} else {
+		proc.centralAuth.replication.ack(message.FromNode, fields[1])
+	}
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
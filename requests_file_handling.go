@@ -2,12 +2,17 @@ package steward
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/hpcloud/tail"
 )
@@ -20,28 +25,61 @@ func (m methodREQToFileAppend) getKind() Event {
 	return m.event
 }
 
+func (m methodREQToFileAppend) isReadOnly() bool {
+	return false
+}
+
 // Handle appending data to file.
 func (m methodREQToFileAppend) handler(proc process, message Message, node string) ([]byte, error) {
 
 	// If it was a request type message we want to check what the initial messages
 	// method, so we can use that in creating the file name to store the data.
-	fileName, folderTree := selectFileNaming(message, proc)
+	fileName, folderTree, err := selectFileNaming(message, proc)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQToFileAppend: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
 
-	// Check if folder structure exist, if not create it.
-	if _, err := os.Stat(folderTree); os.IsNotExist(err) {
-		err := os.MkdirAll(folderTree, 0700)
-		if err != nil {
-			er := fmt.Errorf("error: methodREQToFileAppend: failed to create toFileAppend directory tree:%v, subject: %v, %v", folderTree, proc.subject, err)
-			proc.errorKernel.errSend(proc, message, er)
-		}
+	fileMode, dirMode, err := selectFileModes(message, proc, 0600)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQToFileAppend: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
 
+	// Check if folder structure exist, if not create it.
+	created, err := createFolderTree(folderTree, dirMode)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQToFileAppend: failed to create toFileAppend directory tree:%v, subject: %v, %v", folderTree, proc.subject, err)
+		proc.errorKernel.errSend(proc, message, er)
+	}
+	if created {
 		er := fmt.Errorf("info: Creating subscribers data folder at %v", folderTree)
 		proc.errorKernel.logConsoleOnlyIfDebug(er, proc.configuration)
 	}
 
-	// Open file and write data.
 	file := filepath.Join(folderTree, fileName)
-	f, err := os.OpenFile(file, os.O_APPEND|os.O_RDWR|os.O_CREATE|os.O_SYNC, 0600)
+
+	// Hold the lock of the file while it is rotated and written to, so
+	// handlers appending to the same file at the same time don't write
+	// to a file being renamed.
+	unlock := proc.server.fileLocks.lock(file)
+	defer unlock()
+
+	rotateSize, rotateKeep := selectFileRotation(message, proc)
+	rotated, err := rotateFile(file, int64(len(message.Data)), rotateSize, rotateKeep)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQToFileAppend.handler: failed to rotate file: %v, %v", file, err)
+		proc.errorKernel.errSend(proc, message, er)
+	}
+	if rotated {
+		er := fmt.Errorf("info: methodREQToFileAppend.handler: rotated file: %v", file)
+		proc.errorKernel.logConsoleOnlyIfDebug(er, proc.configuration)
+	}
+
+	// Open file and write data.
+	f, created, err := openAppendFile(file, fileMode)
 	if err != nil {
 		er := fmt.Errorf("error: methodREQToFileAppend.handler: failed to open file: %v, %v", file, err)
 		proc.errorKernel.errSend(proc, message, er)
@@ -49,17 +87,77 @@ func (m methodREQToFileAppend) handler(proc process, message Message, node strin
 	}
 	defer f.Close()
 
-	_, err = f.Write(message.Data)
+	err = f.Chmod(fileMode)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQToFileAppend.handler: failed to set mode of file: %v, %v", file, err)
+		proc.errorKernel.errSend(proc, message, er)
+	}
+
+	// The header is only written to a new file, together with the data
+	// so they are written in one go.
+	data := message.Data
+	if header := selectFileHeader(message); created && header != "" {
+		data = append([]byte(header), message.Data...)
+	}
+
+	_, err = f.Write(data)
 	f.Sync()
 	if err != nil {
 		er := fmt.Errorf("error: methodEventTextLogging.handler: failed to write to file : %v, %v", file, err)
 		proc.errorKernel.errSend(proc, message, er)
 	}
 
+	indexDataFile(proc, message, file)
+
 	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
 	return ackMsg, nil
 }
 
+// openAppendFile will open the file for appending, and create it if it
+// does not exist. created is true if the file was created by this call.
+// The file is created with O_EXCL, so only one of several opening the
+// same new file at the same time, even in other processes, gets created
+// as true.
+func openAppendFile(file string, fileMode os.FileMode) (f *os.File, created bool, err error) {
+	for {
+		f, err = os.OpenFile(file, os.O_APPEND|os.O_RDWR|os.O_CREATE|os.O_EXCL|os.O_SYNC, fileMode)
+		if err == nil {
+			return f, true, nil
+		}
+		if !os.IsExist(err) {
+			return nil, false, err
+		}
+
+		f, err = os.OpenFile(file, os.O_APPEND|os.O_RDWR|os.O_SYNC, fileMode)
+		// The file was removed after we found it existed, so try to
+		// create it again.
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		return f, false, nil
+	}
+}
+
+// selectFileHeader will return the header to write first in a new file
+// appended to, from the message or else from the request message. A
+// newline is added to the header if it does not end with one.
+func selectFileHeader(message Message) string {
+	header := message.FileHeader
+	if header == "" && message.PreviousMessage != nil {
+		header = message.PreviousMessage.FileHeader
+	}
+
+	if header != "" && !strings.HasSuffix(header, "\n") {
+		header += "\n"
+	}
+
+	return header
+}
+
 // -----
 
 type methodREQToFile struct {
@@ -70,31 +168,47 @@ func (m methodREQToFile) getKind() Event {
 	return m.event
 }
 
+func (m methodREQToFile) isReadOnly() bool {
+	return false
+}
+
 // Handle writing to a file. Will truncate any existing data if the file did already
 // exist.
 func (m methodREQToFile) handler(proc process, message Message, node string) ([]byte, error) {
 
 	// If it was a request type message we want to check what the initial messages
 	// method, so we can use that in creating the file name to store the data.
-	fileName, folderTree := selectFileNaming(message, proc)
+	fileName, folderTree, err := selectFileNaming(message, proc)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQToFile: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
 
-	// Check if folder structure exist, if not create it.
-	if _, err := os.Stat(folderTree); os.IsNotExist(err) {
-		err := os.MkdirAll(folderTree, 0700)
-		if err != nil {
-			er := fmt.Errorf("error: methodREQToFile failed to create toFile directory tree: subject:%v, folderTree: %v, %v", proc.subject, folderTree, err)
-			proc.errorKernel.errSend(proc, message, er)
+	fileMode, dirMode, err := selectFileModes(message, proc, 0755)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQToFile: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
 
-			return nil, er
-		}
+		return nil, er
+	}
+
+	// Check if folder structure exist, if not create it.
+	created, err := createFolderTree(folderTree, dirMode)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQToFile failed to create toFile directory tree: subject:%v, folderTree: %v, %v", proc.subject, folderTree, err)
+		proc.errorKernel.errSend(proc, message, er)
 
+		return nil, er
+	}
+	if created {
 		er := fmt.Errorf("info: Creating subscribers data folder at %v", folderTree)
 		proc.errorKernel.logConsoleOnlyIfDebug(er, proc.configuration)
 	}
 
 	// Open file and write data.
 	file := filepath.Join(folderTree, fileName)
-	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0755)
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fileMode)
 	if err != nil {
 		er := fmt.Errorf("error: methodREQToFile.handler: failed to open file, check that you've specified a value for fileName in the message: directory: %v, fileName: %v, %v", message.Directory, message.FileName, err)
 		proc.errorKernel.errSend(proc, message, er)
@@ -103,6 +217,12 @@ func (m methodREQToFile) handler(proc process, message Message, node string) ([]
 	}
 	defer f.Close()
 
+	err = f.Chmod(fileMode)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQToFile.handler: failed to set mode of file: %v, %v", file, err)
+		proc.errorKernel.errSend(proc, message, er)
+	}
+
 	_, err = f.Write(message.Data)
 	f.Sync()
 	if err != nil {
@@ -110,6 +230,110 @@ func (m methodREQToFile) handler(proc process, message Message, node string) ([]
 		proc.errorKernel.errSend(proc, message, er)
 	}
 
+	indexDataFile(proc, message, file)
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ----
+
+type methodREQWriteFileIfChanged struct {
+	event Event
+}
+
+func (m methodREQWriteFileIfChanged) getKind() Event {
+	return m.event
+}
+
+func (m methodREQWriteFileIfChanged) isReadOnly() bool {
+	return false
+}
+
+// writeIfChangedResult is the reply of REQWriteFileIfChanged.
+type writeIfChangedResult struct {
+	File string `json:"file"`
+	// Written is false if the file already had the same content, and
+	// was left untouched.
+	Written bool `json:"written"`
+	// The sha256 checksum of the content as a hex string.
+	Checksum string `json:"checksum"`
+}
+
+// Handle writing to a file like REQToFile, but only if the content of
+// the existing file differs from the data of the message, so writing the
+// same content again do not change the modification time of the file.
+// The reply tells if the file was written.
+func (m methodREQWriteFileIfChanged) handler(proc process, message Message, node string) ([]byte, error) {
+	fileName, folderTree, err := selectFileNaming(message, proc)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQWriteFileIfChanged: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+	file := filepath.Join(folderTree, fileName)
+
+	h := sha256.Sum256(message.Data)
+	r := writeIfChangedResult{
+		File:     file,
+		Checksum: hex.EncodeToString(h[:]),
+	}
+
+	// Only write the file if it does not exist, or the checksum differs.
+	existing, err := fileChecksum(file)
+	if err != nil || existing != r.Checksum {
+		fileMode, dirMode, err := selectFileModes(message, proc, 0755)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQWriteFileIfChanged: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+
+			return nil, er
+		}
+
+		_, err = createFolderTree(folderTree, dirMode)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQWriteFileIfChanged failed to create directory tree: subject:%v, folderTree: %v, %v", proc.subject, folderTree, err)
+			proc.errorKernel.errSend(proc, message, er)
+
+			return nil, er
+		}
+
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fileMode)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQWriteFileIfChanged: failed to open file, check that you've specified a value for fileName in the message: directory: %v, fileName: %v, %v", message.Directory, message.FileName, err)
+			proc.errorKernel.errSend(proc, message, er)
+
+			return nil, err
+		}
+		defer f.Close()
+
+		err = f.Chmod(fileMode)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQWriteFileIfChanged: failed to set mode of file: %v, %v", file, err)
+			proc.errorKernel.errSend(proc, message, er)
+		}
+
+		_, err = f.Write(message.Data)
+		f.Sync()
+		if err != nil {
+			er := fmt.Errorf("error: methodREQWriteFileIfChanged: failed to write to file: file: %v, %v", file, err)
+			proc.errorKernel.errSend(proc, message, er)
+
+			return nil, er
+		}
+
+		r.Written = true
+		indexDataFile(proc, message, file)
+	}
+
+	js, err := json.Marshal(r)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQWriteFileIfChanged: failed to marshal result: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+	} else {
+		newReplyMessage(proc, message, js)
+	}
+
 	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
 	return ackMsg, nil
 }
@@ -124,13 +348,21 @@ func (m methodREQCopyFileFrom) getKind() Event {
 	return m.event
 }
 
-// Handle writing to a file. Will truncate any existing data if the file did already
-// exist.
+func (m methodREQCopyFileFrom) isReadOnly() bool {
+	return true
+}
+
+// Handle reading a file to be copied to another node. The file is
+// sent in chunks with REQCopyFileTo, and the destination node asks for
+// the next chunk with REQCopyFileFrom with the offset to read from as
+// the 4th methodArg, so only one chunk is read for each message.
 func (m methodREQCopyFileFrom) handler(proc process, message Message, node string) ([]byte, error) {
 
+	workDone := proc.startHandlerWork()
 	proc.processes.wg.Add(1)
 	go func() {
 		defer proc.processes.wg.Done()
+		defer workDone()
 
 		switch {
 		case len(message.MethodArgs) < 3:
@@ -144,16 +376,28 @@ func (m methodREQCopyFileFrom) handler(proc process, message Message, node strin
 		DstNode := message.MethodArgs[1]
 		DstFilePath := message.MethodArgs[2]
 
+		var offset int64
+		if len(message.MethodArgs) > 3 {
+			var err error
+			offset, err = strconv.ParseInt(message.MethodArgs[3], 10, 64)
+			if err != nil || offset < 0 {
+				er := fmt.Errorf("error: methodREQCopyFileFrom: invalid offset: %v", message.MethodArgs[3])
+				proc.errorKernel.errSend(proc, message, er)
+				return
+			}
+		}
+
 		// Get a context with the timeout specified in message.MethodTimeout.
 		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
 		defer cancel()
 
-		outCh := make(chan []byte)
+		outCh := make(chan copyFileChunk)
 		errCh := make(chan error)
 
-		// Read the file, and put the result on the out channel to be sent when done reading.
+		// Read the chunk of the file, and put the result on the out
+		// channel to be sent when done reading.
 		proc.processes.wg.Add(1)
-		go copyFileFrom(ctx, &proc.processes.wg, SrcFilePath, errCh, outCh)
+		go copyFileFrom(ctx, &proc.processes.wg, SrcFilePath, offset, errCh, outCh)
 
 		// Wait here until we got the data to send, then create a new message
 		// and send it.
@@ -169,9 +413,11 @@ func (m methodREQCopyFileFrom) handler(proc process, message Message, node strin
 			proc.errorKernel.errSend(proc, message, er)
 
 			return
-		case out := <-outCh:
-			dstDir := filepath.Dir(DstFilePath)
-			dstFile := filepath.Base(DstFilePath)
+		case c := <-outCh:
+			c.srcPath = SrcFilePath
+			c.dstNode = Node(DstNode)
+			c.dstPath = DstFilePath
+			c.srcNode = proc.node
 
 			// Prepare for sending a new message with the output
 
@@ -181,9 +427,10 @@ func (m methodREQCopyFileFrom) handler(proc process, message Message, node strin
 			msg.ToNode = Node(DstNode)
 			//msg.Method = REQToFile
 			msg.Method = REQCopyFileTo
-			msg.Data = out
-			msg.Directory = dstDir
-			msg.FileName = dstFile
+			msg.MethodArgs = c.methodArgs()
+			msg.Data = c.data
+			msg.Directory = filepath.Dir(DstFilePath)
+			msg.FileName = filepath.Base(DstFilePath)
 
 			// Create SAM and put the message on the send new message channel.
 
@@ -195,9 +442,12 @@ func (m methodREQCopyFileFrom) handler(proc process, message Message, node strin
 
 			proc.toRingbufferCh <- []subjectAndMessage{sam}
 
-			replyData := fmt.Sprintf("info: succesfully read the file %v, and sent the content to %v\n", SrcFilePath, DstNode)
-
-			newReplyMessage(proc, message, []byte(replyData))
+			// Only reply when the whole file is sent, and not for every
+			// chunk.
+			if c.done {
+				replyData := fmt.Sprintf("info: succesfully read the file %v, and sent the content to %v\n", SrcFilePath, DstNode)
+				newReplyMessage(proc, message, []byte(replyData))
+			}
 		}
 
 	}()
@@ -206,41 +456,23 @@ func (m methodREQCopyFileFrom) handler(proc process, message Message, node strin
 	return ackMsg, nil
 }
 
-// copyFileFrom will read a file to be copied from the specified SrcFilePath.
-// The result of be delivered on the provided outCh.
-func copyFileFrom(ctx context.Context, wg *sync.WaitGroup, SrcFilePath string, errCh chan error, outCh chan []byte) {
+// copyFileFrom will read the chunk of the file to be copied from the
+// specified SrcFilePath, starting at the offset. The result will be
+// delivered on the provided outCh.
+func copyFileFrom(ctx context.Context, wg *sync.WaitGroup, SrcFilePath string, offset int64, errCh chan error, outCh chan copyFileChunk) {
 	defer wg.Done()
 
-	const natsMaxMsgSize = 1000000
-
-	fi, err := os.Stat(SrcFilePath)
-
-	// Check if the src file exists, and that it is not bigger than
-	// the default limit used by nats which is 1MB.
-	switch {
-	case os.IsNotExist(err):
-		errCh <- fmt.Errorf("error: methodREQCopyFile: src file not found: %v", SrcFilePath)
-		return
-	case fi.Size() > natsMaxMsgSize:
-		errCh <- fmt.Errorf("error: methodREQCopyFile: src file to big. max size: %v", natsMaxMsgSize)
-		return
-	}
-
-	fh, err := os.Open(SrcFilePath)
-	if err != nil {
-		errCh <- fmt.Errorf("error: methodREQCopyFile: failed to open file: %v, %v", SrcFilePath, err)
-		return
-	}
-
-	b, err := io.ReadAll(fh)
+	b, done, err := readCopyFileChunk(SrcFilePath, offset)
 	if err != nil {
-		errCh <- fmt.Errorf("error: methodREQCopyFile: failed to read file: %v, %v", SrcFilePath, err)
+		select {
+		case errCh <- fmt.Errorf("error: methodREQCopyFile: %v", err):
+		case <-ctx.Done():
+		}
 		return
 	}
 
 	select {
-	case outCh <- b:
-		// fmt.Printf(" * DEBUG: after io.ReadAll: outCh <- b\n")
+	case outCh <- copyFileChunk{offset: offset, done: done, data: b}:
 	case <-ctx.Done():
 		return
 	}
@@ -256,13 +488,19 @@ func (m methodREQCopyFileTo) getKind() Event {
 	return m.event
 }
 
-// Handle writing to a file. Will truncate any existing data if the file did already
-// exist.
+func (m methodREQCopyFileTo) isReadOnly() bool {
+	return false
+}
+
+// Handle writing a chunk of a file copied with REQCopyFileFrom.
 // Same as the REQToFile, but this requst type don't use the default data folder path
 // for where to store files or add information about node names.
 // This method also sends a msgReply back to the publisher if the method was done
 // successfully, where REQToFile do not.
-// This method will truncate and overwrite any existing files.
+// The chunks are written to a partial file, and when a chunk is written
+// the next chunk is asked for from the source node. When the last chunk
+// is written the partial file is renamed to the destination path, so
+// any existing file is replaced at once.
 func (m methodREQCopyFileTo) handler(proc process, message Message, node string) ([]byte, error) {
 
 	proc.processes.wg.Add(1)
@@ -274,7 +512,8 @@ func (m methodREQCopyFileTo) handler(proc process, message Message, node string)
 		defer cancel()
 
 		// Put data that should be the result of the action done in the inner
-		// go routine on the outCh.
+		// go routine on the outCh. An empty value is put on the outCh when
+		// there is nothing to reply.
 		outCh := make(chan []byte)
 		// Put errors from the inner go routine on the errCh.
 		errCh := make(chan error)
@@ -284,9 +523,9 @@ func (m methodREQCopyFileTo) handler(proc process, message Message, node string)
 			defer proc.processes.wg.Done()
 
 			// ---
-			switch {
-			case len(message.MethodArgs) < 3:
-				er := fmt.Errorf("error: methodREQCopyFileTo: got <3 number methodArgs: want srcfilePath,dstNode,dstFilePath")
+			c, err := parseCopyFileChunk(message.MethodArgs, message.Data)
+			if err != nil {
+				er := fmt.Errorf("error: methodREQCopyFileTo: %v", err)
 				proc.errorKernel.errSend(proc, message, er)
 
 				return
@@ -294,46 +533,63 @@ func (m methodREQCopyFileTo) handler(proc process, message Message, node string)
 
 			// Pick up the values for the directory and filename for where
 			// to store the file.
-			DstFilePath := message.MethodArgs[2]
-			dstDir := filepath.Dir(DstFilePath)
-			dstFile := filepath.Base(DstFilePath)
+			dstDir := filepath.Dir(c.dstPath)
+			dstFile := filepath.Base(c.dstPath)
 
 			fileRealPath := path.Join(dstDir, dstFile)
 
-			// Check if folder structure exist, if not create it.
-			if _, err := os.Stat(dstDir); os.IsNotExist(err) {
-				err := os.MkdirAll(dstDir, 0700)
-				if err != nil {
-					er := fmt.Errorf("failed to create toFile directory tree: subject:%v, folderTree: %v, %v", proc.subject, dstDir, err)
-					errCh <- er
-					return
-				}
-
-				{
-					er := fmt.Errorf("info: MethodREQCopyFileTo: Creating folders %v", dstDir)
-					proc.errorKernel.logConsoleOnlyIfDebug(er, proc.configuration)
-				}
+			fileMode, dirMode, err := selectFileModes(message, proc, 0755)
+			if err != nil {
+				errCh <- err
+				return
 			}
 
-			// Open file and write data. Truncate and overwrite any existing files.
-			file := filepath.Join(dstDir, dstFile)
-			f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0755)
+			// Check if folder structure exist, if not create it.
+			created, err := createFolderTree(dstDir, dirMode)
 			if err != nil {
-				er := fmt.Errorf("failed to open file, check that you've specified a value for fileName in the message: directory: %v, fileName: %v, %v", message.Directory, message.FileName, err)
+				er := fmt.Errorf("failed to create toFile directory tree: subject:%v, folderTree: %v, %v", proc.subject, dstDir, err)
 				errCh <- er
 				return
 			}
-			defer f.Close()
+			if created {
+				er := fmt.Errorf("info: MethodREQCopyFileTo: Creating folders %v", dstDir)
+				proc.errorKernel.logConsoleOnlyIfDebug(er, proc.configuration)
+			}
 
-			_, err = f.Write(message.Data)
-			f.Sync()
+			received, written, err := writeCopyFileChunk(c, fileMode)
 			if err != nil {
-				er := fmt.Errorf("failed to write to file: file: %v, error: %v", file, err)
-				errCh <- er
+				errCh <- err
+				return
 			}
 
-			// All went ok, send a signal to the outer select statement.
-			outCh <- []byte(fileRealPath)
+			switch {
+			case !written:
+				// The chunk was already received, and the next chunk was
+				// already asked for.
+				er := fmt.Errorf("info: methodREQCopyFileTo: chunk at offset %v of %v already received", c.offset, c.dstPath)
+				proc.errorKernel.logConsoleOnlyIfDebug(er, proc.configuration)
+				outCh <- nil
+
+			case !c.done:
+				// Ask the source node for the next chunk.
+				msg := message
+				msg.ToNode = c.srcNode
+				msg.Method = REQCopyFileFrom
+				msg.MethodArgs = []string{c.srcPath, string(c.dstNode), c.dstPath, strconv.FormatInt(received, 10)}
+				msg.Data = nil
+
+				sam, err := newSubjectAndMessage(msg)
+				if err != nil {
+					errCh <- fmt.Errorf("newSubjectAndMessage : %v, message: %v", err, msg)
+					return
+				}
+				proc.toRingbufferCh <- []subjectAndMessage{sam}
+				outCh <- nil
+
+			default:
+				// All went ok, send a signal to the outer select statement.
+				outCh <- []byte(fileRealPath)
+			}
 
 			// ---
 
@@ -352,6 +608,9 @@ func (m methodREQCopyFileTo) handler(proc process, message Message, node string)
 			return
 
 		case out := <-outCh:
+			if out == nil {
+				return
+			}
 			replyData := fmt.Sprintf("info: succesfully created and wrote the file %v\n", out)
 			newReplyMessage(proc, message, []byte(replyData))
 			return
@@ -363,94 +622,866 @@ func (m methodREQCopyFileTo) handler(proc process, message Message, node string)
 	return ackMsg, nil
 }
 
-// --- methodREQTailFile
+// --- CopyFileResume
 
-type methodREQTailFile struct {
+type methodREQCopyFileResume struct {
 	event Event
 }
 
-func (m methodREQTailFile) getKind() Event {
+func (m methodREQCopyFileResume) getKind() Event {
 	return m.event
 }
 
-// handler to run a tailing of files with timeout context. The handler will
-// return the output of the command run back to the calling publisher
-// as a new message.
-func (m methodREQTailFile) handler(proc process, message Message, node string) ([]byte, error) {
-	inf := fmt.Errorf("<--- TailFile REQUEST received from: %v, containing: %v", message.FromNode, message.Data)
-	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
+func (m methodREQCopyFileResume) isReadOnly() bool {
+	return false
+}
 
+// Handle resuming a copy of a file to this node that stopped before
+// all the chunks were received. The methodArgs are the source node, the
+// source file and the destination file. The source node is asked with
+// REQCopyFileFrom to send the file from the number of bytes already
+// received in the partial file, or from the start if there is none.
+func (m methodREQCopyFileResume) handler(proc process, message Message, node string) ([]byte, error) {
 	proc.processes.wg.Add(1)
 	go func() {
 		defer proc.processes.wg.Done()
 
-		switch {
-		case len(message.MethodArgs) < 1:
-			er := fmt.Errorf("error: methodREQTailFile: got <1 number methodArgs")
+		if len(message.MethodArgs) < 3 {
+			er := fmt.Errorf("error: methodREQCopyFileResume: got <3 number methodArgs: want srcNode,srcFilePath,dstFilePath")
 			proc.errorKernel.errSend(proc, message, er)
-
 			return
 		}
 
-		fp := message.MethodArgs[0]
-
-		// var ctx context.Context
-		// var cancel context.CancelFunc
+		srcNode := Node(message.MethodArgs[0])
+		srcFilePath := message.MethodArgs[1]
+		dstFilePath := message.MethodArgs[2]
 
-		// Get a context with the timeout specified in message.MethodTimeout.
-		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		offset, err := copyFileReceived(dstFilePath)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQCopyFileResume: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
 
-		// Note: Replacing the 0 timeout with specific timeout.
-		// if message.MethodTimeout != 0 {
-		// 	ctx, cancel = context.WithTimeout(proc.ctx, time.Second*time.Duration(message.MethodTimeout))
-		// } else {
-		// 	ctx, cancel = context.WithCancel(proc.ctx)
-		// }
+		msg := message
+		msg.ToNode = srcNode
+		msg.Method = REQCopyFileFrom
+		msg.MethodArgs = []string{srcFilePath, string(proc.node), dstFilePath, strconv.FormatInt(offset, 10)}
 
-		outCh := make(chan []byte)
-		t, err := tail.TailFile(fp, tail.Config{Follow: true, Location: &tail.SeekInfo{
-			Offset: 0,
-			Whence: os.SEEK_END,
-		}})
+		sam, err := newSubjectAndMessage(msg)
 		if err != nil {
-			er := fmt.Errorf("error: methodREQToTailFile: tailFile: %v", err)
+			er := fmt.Errorf("error: methodREQCopyFileResume: newSubjectAndMessage : %v, message: %v", err, msg)
 			proc.errorKernel.errSend(proc, message, er)
+			return
 		}
+		proc.toRingbufferCh <- []subjectAndMessage{sam}
 
-		proc.processes.wg.Add(1)
-		go func() {
-			defer proc.processes.wg.Done()
+		replyData := fmt.Sprintf("info: resuming the copy of %v from %v at offset %v\n", dstFilePath, srcNode, offset)
+		newReplyMessage(proc, message, []byte(replyData))
+	}()
 
-			for {
-				select {
-				case line := <-t.Lines:
-					outCh <- []byte(line.Text + "\n")
-				case <-ctx.Done():
-					return
-				}
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
 
-			}
-		}()
+// --- CopyDirFrom
 
-		for {
-			select {
-			case <-ctx.Done():
-				cancel()
-				// Close the lines channel so we exit the reading lines
-				// go routine.
-				// close(t.Lines)
-				er := fmt.Errorf("info: method timeout reached REQTailFile, canceling: %v", message.MethodArgs)
-				proc.errorKernel.infoSend(proc, message, er)
+type methodREQCopyDirFrom struct {
+	event Event
+}
 
-				return
-			case out := <-outCh:
+func (m methodREQCopyDirFrom) getKind() Event {
+	return m.event
+}
 
-				// Prepare and queue for sending a new message with the output
-				// of the action executed.
-				newReplyMessage(proc, message, out)
-			}
-		}
+func (m methodREQCopyDirFrom) isReadOnly() bool {
+	return true
+}
+
+// Handler to copy a directory recursively to another node. The
+// methodArgs are the source directory, the destination node, and the
+// destination directory. Each directory, and each chunk of the files
+// are sent to the destination node with REQCopyDirTo, keeping the
+// relative paths and the file modes. Symlinks are skipped. When all is
+// sent a summary with the number of files and bytes are replied back.
+func (m methodREQCopyDirFrom) handler(proc process, message Message, node string) ([]byte, error) {
+	workDone := proc.startHandlerWork()
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+		defer workDone()
 
+		switch {
+		case len(message.MethodArgs) < 3:
+			er := fmt.Errorf("error: methodREQCopyDirFrom: got <3 number methodArgs: want srcDir,dstNode,dstDir")
+			proc.errorKernel.errSend(proc, message, er)
+
+			return
+		}
+
+		srcDir := message.MethodArgs[0]
+		dstNode := Node(message.MethodArgs[1])
+		dstDir := message.MethodArgs[2]
+
+		// Get a context with the timeout specified in message.MethodTimeout.
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		defer cancel()
+
+		send := func(e copyDirEntry) error {
+			// Copy the original message to get the defaults for timeouts
+			// etc, and set new values for fields to change. There is no
+			// reply for each entry, and errors go to the error log.
+			msg := message
+			msg.ToNode = dstNode
+			msg.Method = REQCopyDirTo
+			msg.MethodArgs = e.methodArgs()
+			msg.Data = e.data
+			msg.ReplyMethod = REQNone
+
+			sam, err := newSubjectAndMessage(msg)
+			if err != nil {
+				return fmt.Errorf("newSubjectAndMessage : %v", err)
+			}
+
+			select {
+			case proc.toRingbufferCh <- []subjectAndMessage{sam}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			return nil
+		}
+
+		report, err := walkCopyDir(ctx, srcDir, dstDir, send)
+		for _, l := range report.SkippedSymlinks {
+			er := fmt.Errorf("info: methodREQCopyDirFrom: skipped symlink %v in %v", l, srcDir)
+			proc.errorKernel.infoSend(proc, message, er)
+		}
+		if err != nil {
+			er := fmt.Errorf("error: methodREQCopyDirFrom: copy of %v aborted after %v files and %v bytes: %v", srcDir, report.Files, report.Bytes, err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		out, err := json.Marshal(report)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQCopyDirFrom: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- CopyDirTo
+
+type methodREQCopyDirTo struct {
+	event Event
+}
+
+func (m methodREQCopyDirTo) getKind() Event {
+	return m.event
+}
+
+func (m methodREQCopyDirTo) isReadOnly() bool {
+	return false
+}
+
+// Handler to create a directory, or write a chunk of a file, sent by
+// REQCopyDirFrom. The methodArgs are the kind of entry, the path, the
+// mode in octal, and for files the offset of the chunk and the size of
+// the file. The content of the chunk is in the data of the message.
+func (m methodREQCopyDirTo) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		e, err := parseCopyDirEntry(message.MethodArgs, message.Data)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQCopyDirTo: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		_, dirMode, err := selectFileModes(message, proc, e.mode)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQCopyDirTo: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		err = writeCopyDirEntry(e, dirMode)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQCopyDirTo: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- methodREQTailFile
+
+type methodREQTailFile struct {
+	event Event
+}
+
+func (m methodREQTailFile) getKind() Event {
+	return m.event
+}
+
+func (m methodREQTailFile) isReadOnly() bool {
+	return true
+}
+
+// handler to run a tailing of files with timeout context. The handler will
+// return the output of the command run back to the calling publisher
+// as a new message.
+func (m methodREQTailFile) handler(proc process, message Message, node string) ([]byte, error) {
+	inf := fmt.Errorf("<--- TailFile REQUEST received from: %v, containing: %v", message.FromNode, message.Data)
+	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
+
+	workDone := proc.startHandlerWork()
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+		defer workDone()
+
+		switch {
+		case len(message.MethodArgs) < 1:
+			er := fmt.Errorf("error: methodREQTailFile: got <1 number methodArgs")
+			proc.errorKernel.errSend(proc, message, er)
+
+			return
+		}
+
+		fp := message.MethodArgs[0]
+
+		// var ctx context.Context
+		// var cancel context.CancelFunc
+
+		// Get a context with the timeout specified in message.MethodTimeout.
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+
+		// Note: Replacing the 0 timeout with specific timeout.
+		// if message.MethodTimeout != 0 {
+		// 	ctx, cancel = context.WithTimeout(proc.ctx, time.Second*time.Duration(message.MethodTimeout))
+		// } else {
+		// 	ctx, cancel = context.WithCancel(proc.ctx)
+		// }
+
+		// Register the tail so it can be stopped with REQTailFileStop.
+		ctx, stop := context.WithCancel(ctx)
+		defer stop()
+		err := proc.server.tailSessions.add(message.ID, &tailSession{
+			fromNode: message.FromNode,
+			path:     fp,
+			cancel:   stop,
+		})
+		if err != nil {
+			cancel()
+			er := fmt.Errorf("error: methodREQTailFile: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		outCh := make(chan []byte)
+		t, err := tail.TailFile(fp, tail.Config{Follow: true, Location: &tail.SeekInfo{
+			Offset: 0,
+			Whence: os.SEEK_END,
+		}})
+		if err != nil {
+			cancel()
+			proc.server.tailSessions.remove(message.ID)
+			er := fmt.Errorf("error: methodREQToTailFile: tailFile: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		proc.processes.wg.Add(1)
+		go func() {
+			defer proc.processes.wg.Done()
+
+			for {
+				select {
+				case line := <-t.Lines:
+					select {
+					case outCh <- []byte(line.Text + "\n"):
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+
+			}
+		}()
+
+		var lines int
+		for {
+			select {
+			case <-ctx.Done():
+				cancel()
+				// Stop the tail and release the inotify watches or
+				// polling of the file.
+				t.Stop()
+				t.Cleanup()
+
+				if proc.server.tailSessions.remove(message.ID) {
+					out := fmt.Sprintf("tail of %v stopped, %v lines delivered\n", fp, lines)
+					newReplyMessage(proc, message, []byte(out))
+					return
+				}
+
+				er := fmt.Errorf("info: method timeout reached REQTailFile, canceling: %v", message.MethodArgs)
+				proc.errorKernel.infoSend(proc, message, er)
+
+				return
+			case out := <-outCh:
+
+				// Prepare and queue for sending a new message with the output
+				// of the action executed.
+				newReplyMessage(proc, message, out)
+				lines++
+			}
+		}
+
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- methodREQTailFileStop
+
+type methodREQTailFileStop struct {
+	event Event
+}
+
+func (m methodREQTailFileStop) getKind() Event {
+	return m.event
+}
+
+func (m methodREQTailFileStop) isReadOnly() bool {
+	return false
+}
+
+// Handler to stop a tail started with REQTailFile. The first methodArg
+// is the ID of the REQTailFile message. The tail will send a final reply
+// with the number of lines delivered when stopped.
+func (m methodREQTailFileStop) handler(proc process, message Message, node string) ([]byte, error) {
+	if len(message.MethodArgs) < 1 {
+		er := fmt.Errorf("error: methodREQTailFileStop: got <1 number methodArgs, want the message id of the tail")
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	id, err := strconv.Atoi(message.MethodArgs[0])
+	if err != nil {
+		er := fmt.Errorf("error: methodREQTailFileStop: invalid message id %v: %v", message.MethodArgs[0], err)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	err = proc.server.tailSessions.stop(id, message.FromNode)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQTailFileStop: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// indexDataFile will queue the file written for the message to be
+// added to the data index. The method recorded is the method of the initial
+// request if the message is a reply.
+func indexDataFile(proc process, message Message, file string) {
+	method := message.Method
+	if message.PreviousMessage != nil {
+		method = message.PreviousMessage.Method
+	}
+
+	err := proc.server.dataIndex.queueUpdate(file, method)
+	if err != nil {
+		proc.errorKernel.logConsoleOnlyIfDebug(err, proc.configuration)
+	}
+}
+
+// ----
+
+type methodREQReindexDataFolder struct {
+	event Event
+}
+
+func (m methodREQReindexDataFolder) getKind() Event {
+	return m.event
+}
+
+func (m methodREQReindexDataFolder) isReadOnly() bool {
+	return false
+}
+
+// Handler to rebuild the index of the files stored in the data folder.
+func (m methodREQReindexDataFolder) handler(proc process, message Message, node string) ([]byte, error) {
+	workDone := proc.startHandlerWork()
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+		defer workDone()
+
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		outCh := make(chan []byte)
+		errCh := make(chan error, 1)
+
+		go func() {
+			err := proc.server.dataIndex.reindex()
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQReindexDataFolder: %v", err)
+				return
+			}
+
+			n := len(proc.server.dataIndex.search(dataIndexQuery{}))
+
+			select {
+			case outCh <- []byte(fmt.Sprintf("indexed %v files", n)):
+			case <-ctx.Done():
+			}
+		}()
+
+		select {
+		case err := <-errCh:
+			proc.errorKernel.errSend(proc, message, err)
+		case <-ctx.Done():
+			cancel()
+			er := fmt.Errorf("error: methodREQReindexDataFolder: method timed out: %v", message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+		case out := <-outCh:
+			newReplyMessage(proc, message, out)
+		}
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ----
+
+type methodREQSearchDataFolder struct {
+	event Event
+}
+
+func (m methodREQSearchDataFolder) getKind() Event {
+	return m.event
+}
+
+func (m methodREQSearchDataFolder) isReadOnly() bool {
+	return true
+}
+
+// Handler to search the index of the files stored in the data folder.
+// The result is replied as a JSON array of the matching entries.
+func (m methodREQSearchDataFolder) handler(proc process, message Message, node string) ([]byte, error) {
+	workDone := proc.startHandlerWork()
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+		defer workDone()
+
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		outCh := make(chan []byte)
+		errCh := make(chan error, 1)
+
+		go func() {
+			var q dataIndexQuery
+			if len(message.MethodArgs) > 0 && message.MethodArgs[0] != "" {
+				err := json.Unmarshal([]byte(message.MethodArgs[0]), &q)
+				if err != nil {
+					errCh <- fmt.Errorf("error: methodREQSearchDataFolder: failed to unmarshal query: %v", err)
+					return
+				}
+			}
+
+			js, err := json.Marshal(proc.server.dataIndex.search(q))
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQSearchDataFolder: failed to marshal result: %v", err)
+				return
+			}
+
+			select {
+			case outCh <- js:
+			case <-ctx.Done():
+			}
+		}()
+
+		select {
+		case err := <-errCh:
+			proc.errorKernel.errSend(proc, message, err)
+		case <-ctx.Done():
+			cancel()
+			er := fmt.Errorf("error: methodREQSearchDataFolder: method timed out: %v", message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+		case out := <-outCh:
+			newReplyMessage(proc, message, out)
+		}
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ----
+
+type methodREQVerifyDataIntegrity struct {
+	event Event
+}
+
+func (m methodREQVerifyDataIntegrity) getKind() Event {
+	return m.event
+}
+
+func (m methodREQVerifyDataIntegrity) isReadOnly() bool {
+	return true
+}
+
+// Handler to verify the checksums of the files stored in the data folder.
+// The result is replied as a JSON report with the files found to be
+// corrupted or missing.
+func (m methodREQVerifyDataIntegrity) handler(proc process, message Message, node string) ([]byte, error) {
+	workDone := proc.startHandlerWork()
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+		defer workDone()
+
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		outCh := make(chan []byte)
+		errCh := make(chan error, 1)
+
+		go func() {
+			var q dataIndexQuery
+			if len(message.MethodArgs) > 0 && message.MethodArgs[0] != "" {
+				err := json.Unmarshal([]byte(message.MethodArgs[0]), &q)
+				if err != nil {
+					errCh <- fmt.Errorf("error: methodREQVerifyDataIntegrity: failed to unmarshal query: %v", err)
+					return
+				}
+			}
+
+			r := proc.server.dataIndex.verify(q)
+			js, err := json.Marshal(r)
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQVerifyDataIntegrity: failed to marshal result: %v", err)
+				return
+			}
+
+			if len(r.Corrupted) > 0 || len(r.Missing) > 0 {
+				er := fmt.Errorf("error: methodREQVerifyDataIntegrity: found corrupted files: %v, missing files: %v", r.Corrupted, r.Missing)
+				proc.errorKernel.errSend(proc, message, er)
+			}
+
+			select {
+			case outCh <- js:
+			case <-ctx.Done():
+			}
+		}()
+
+		select {
+		case err := <-errCh:
+			proc.errorKernel.errSend(proc, message, err)
+		case <-ctx.Done():
+			cancel()
+			er := fmt.Errorf("error: methodREQVerifyDataIntegrity: method timed out: %v", message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+		case out := <-outCh:
+			newReplyMessage(proc, message, out)
+		}
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ----
+
+type methodREQCompressStoredReplies struct {
+	event Event
+}
+
+func (m methodREQCompressStoredReplies) getKind() Event {
+	return m.event
+}
+
+func (m methodREQCompressStoredReplies) isReadOnly() bool {
+	return false
+}
+
+// Handler to gzip the reply files stored in the data folder that are
+// older than the age given in the first element of the MethodArgs, like
+// "72h". If no age is given the default of 24 hours is used. The result
+// is replied as a JSON report with the files compressed and the bytes
+// saved.
+func (m methodREQCompressStoredReplies) handler(proc process, message Message, node string) ([]byte, error) {
+	workDone := proc.startHandlerWork()
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+		defer workDone()
+
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		outCh := make(chan []byte)
+		errCh := make(chan error, 1)
+
+		go func() {
+			age := compressStoredRepliesDefaultAge
+			if len(message.MethodArgs) > 0 && message.MethodArgs[0] != "" {
+				var err error
+				age, err = time.ParseDuration(message.MethodArgs[0])
+				if err != nil || age < 0 {
+					errCh <- fmt.Errorf("error: methodREQCompressStoredReplies: invalid age: %v", message.MethodArgs[0])
+					return
+				}
+			}
+
+			r, err := proc.server.dataIndex.compressStoredReplies(time.Now().Add(-age))
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQCompressStoredReplies: %v", err)
+				return
+			}
+
+			if len(r.Failed) > 0 {
+				er := fmt.Errorf("error: methodREQCompressStoredReplies: failed to compress files: %v", r.Failed)
+				proc.errorKernel.errSend(proc, message, er)
+			}
+
+			js, err := json.Marshal(r)
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQCompressStoredReplies: failed to marshal result: %v", err)
+				return
+			}
+
+			select {
+			case outCh <- js:
+			case <-ctx.Done():
+			}
+		}()
+
+		select {
+		case err := <-errCh:
+			proc.errorKernel.errSend(proc, message, err)
+		case <-ctx.Done():
+			cancel()
+			er := fmt.Errorf("error: methodREQCompressStoredReplies: method timed out: %v", message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+		case out := <-outCh:
+			newReplyMessage(proc, message, out)
+		}
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ----
+
+type methodREQPartialUpdateFile struct {
+	event Event
+}
+
+func (m methodREQPartialUpdateFile) getKind() Event {
+	return m.event
+}
+
+func (m methodREQPartialUpdateFile) isReadOnly() bool {
+	return false
+}
+
+// Handler to do an in place edit of some of the lines of a file.
+// The first element of the MethodArgs is the path of the file, the
+// second is the operation, and the rest are the arguments for the
+// operation. A backup of the original file is made before the file
+// is updated, and the diff of the changes are sent back as the reply.
+func (m methodREQPartialUpdateFile) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		outCh := make(chan []byte)
+		errCh := make(chan error, 1)
+
+		go func() {
+			switch {
+			case len(message.MethodArgs) < 2:
+				errCh <- fmt.Errorf("error: methodREQPartialUpdateFile: got <2 number methodArgs, want file path, operation, and the arguments for the operation")
+				return
+			}
+
+			fp, err := checkAllowedFileRoot(proc.configuration.AllowedFileRoots, message.MethodArgs[0])
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQPartialUpdateFile: %v", err)
+				return
+			}
+
+			diff, err := partialUpdateFile(fp, partialUpdateOp(message.MethodArgs[1]), message.MethodArgs[2:])
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQPartialUpdateFile: %v", err)
+				return
+			}
+
+			out := fmt.Sprintf("updated file %v\n%v", fp, diff)
+			if diff == "" {
+				out = fmt.Sprintf("no changes made to file %v\n", fp)
+			}
+
+			select {
+			case outCh <- []byte(out):
+			case <-ctx.Done():
+			}
+		}()
+
+		select {
+		case err := <-errCh:
+			proc.errorKernel.errSend(proc, message, err)
+		case <-ctx.Done():
+			cancel()
+			er := fmt.Errorf("error: methodREQPartialUpdateFile: method timed out: %v", message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+		case out := <-outCh:
+			newReplyMessage(proc, message, out)
+		}
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- FileTemplateRenderPreview
+
+type methodREQFileTemplateRenderPreview struct {
+	event Event
+}
+
+func (m methodREQFileTemplateRenderPreview) getKind() Event {
+	return m.event
+}
+
+func (m methodREQFileTemplateRenderPreview) isReadOnly() bool {
+	return true
+}
+
+// Handler to render a file template without writing any file, so the
+// result can be checked before the file is distributed. The first
+// element of the MethodArgs is the template, and the second are the
+// variables as a JSON object. The rendered content, or the error if
+// the template failed, are replied back as JSON.
+func (m methodREQFileTemplateRenderPreview) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		if len(message.MethodArgs) < 1 {
+			er := fmt.Errorf("error: methodREQFileTemplateRenderPreview: got <1 number methodArgs, want the template, and the variables as JSON")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		var preview fileTemplatePreview
+
+		varsJSON := ""
+		if len(message.MethodArgs) > 1 {
+			varsJSON = message.MethodArgs[1]
+		}
+		vars, err := parseFileTemplateVars(varsJSON)
+		if err != nil {
+			preview.Error = err.Error()
+		} else {
+			b, err := renderFileTemplate(message.MethodArgs[0], vars, message.ToNode)
+			if err != nil {
+				preview.Error = err.Error()
+			}
+			preview.Rendered = string(b)
+		}
+
+		out, err := json.Marshal(preview)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQFileTemplateRenderPreview: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- BulkFileFetch
+
+type methodREQBulkFileFetch struct {
+	event Event
+}
+
+func (m methodREQBulkFileFetch) getKind() Event {
+	return m.event
+}
+
+func (m methodREQBulkFileFetch) isReadOnly() bool {
+	return true
+}
+
+// Handler to fetch several files from a node in one request. The
+// MethodArgs are the paths of the files, which must be within the
+// AllowedFileRoots. The files are replied back as a gzipped tar archive,
+// where the files that could not be fetched are listed in an errors entry.
+func (m methodREQBulkFileFetch) handler(proc process, message Message, node string) ([]byte, error) {
+	workDone := proc.startHandlerWork()
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+		defer workDone()
+
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		outCh := make(chan []byte)
+		errCh := make(chan error, 1)
+
+		go func() {
+			if len(message.MethodArgs) < 1 {
+				errCh <- fmt.Errorf("error: methodREQBulkFileFetch: got <1 number methodArgs, want the paths of the files to fetch")
+				return
+			}
+
+			out, err := bulkFileFetch(proc.configuration.AllowedFileRoots, message.MethodArgs, bulkFileFetchMaxBytes)
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQBulkFileFetch: %v", err)
+				return
+			}
+
+			select {
+			case outCh <- out:
+			case <-ctx.Done():
+			}
+		}()
+
+		select {
+		case err := <-errCh:
+			proc.errorKernel.errSend(proc, message, err)
+		case <-ctx.Done():
+			cancel()
+			er := fmt.Errorf("error: methodREQBulkFileFetch: method timed out: %v", message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+		case out := <-outCh:
+			cancel()
+			newReplyMessage(proc, message, out)
+		}
 	}()
 
 	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
@@ -1,11 +1,14 @@
 package steward
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,14 +20,20 @@ func (m methodREQHttpGet) getKind() Event {
 	return m.event
 }
 
+func (m methodREQHttpGet) isReadOnly() bool {
+	return true
+}
+
 // handler to do a Http Get.
 func (m methodREQHttpGet) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- REQHttpGet received from: %v, containing: %v", message.FromNode, message.Data)
 	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
 
+	workDone := proc.startHandlerWork()
 	proc.processes.wg.Add(1)
 	go func() {
 		defer proc.processes.wg.Done()
+		defer workDone()
 
 		switch {
 		case len(message.MethodArgs) < 1:
@@ -36,52 +45,59 @@ func (m methodREQHttpGet) handler(proc process, message Message, node string) ([
 
 		url := message.MethodArgs[0]
 
-		client := http.Client{
-			Timeout: time.Second * time.Duration(message.MethodTimeout),
-		}
+		// The request is cancelled with the context when the method
+		// times out, so the client have no timeout of its own.
+		client := http.Client{}
 
 		// Get a context with the timeout specified in message.MethodTimeout.
 		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		defer cancel()
 
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 		if err != nil {
 			er := fmt.Errorf("error: methodREQHttpGet: NewRequest failed: %v, bailing out: %v", err, message.MethodArgs)
 			proc.errorKernel.errSend(proc, message, er)
-			cancel()
 			return
 		}
 
 		outCh := make(chan []byte)
+		errCh := make(chan error)
+		start := time.Now()
 
 		proc.processes.wg.Add(1)
 		go func() {
 			defer proc.processes.wg.Done()
 
+			// sendErr will give the error to the select below, unless
+			// the method have timed out where the timeout is reported
+			// instead.
+			sendErr := func(er error) {
+				select {
+				case errCh <- er:
+				case <-ctx.Done():
+				}
+			}
+
 			resp, err := client.Do(req)
 			if err != nil {
-				er := fmt.Errorf("error: methodREQHttpGet: client.Do failed: %v, bailing out: %v", err, message.MethodArgs)
-				proc.errorKernel.errSend(proc, message, er)
+				sendErr(fmt.Errorf("error: methodREQHttpGet: client.Do failed: %v, bailing out: %v", err, message.MethodArgs))
 				return
 			}
 			defer resp.Body.Close()
 
 			if resp.StatusCode != 200 {
-				cancel()
-				er := fmt.Errorf("error: methodREQHttpGet: not 200, were %#v, bailing out: %v", resp.StatusCode, message)
-				proc.errorKernel.errSend(proc, message, er)
+				sendErr(fmt.Errorf("error: methodREQHttpGet: not 200, were %#v, bailing out: %v", resp.StatusCode, message))
 				return
 			}
 
 			body, err := io.ReadAll(resp.Body)
 			if err != nil {
-				er := fmt.Errorf("error: methodREQHttpGet: io.ReadAll failed : %v, methodArgs: %v", err, message.MethodArgs)
-				proc.errorKernel.errSend(proc, message, er)
+				sendErr(fmt.Errorf("error: methodREQHttpGet: io.ReadAll failed : %v, methodArgs: %v", err, message.MethodArgs))
+				return
 			}
 
-			out := body
-
 			select {
-			case outCh <- out:
+			case outCh <- body:
 			case <-ctx.Done():
 				return
 			}
@@ -89,12 +105,11 @@ func (m methodREQHttpGet) handler(proc process, message Message, node string) ([
 
 		select {
 		case <-ctx.Done():
-			cancel()
-			er := fmt.Errorf("error: methodREQHttpGet: method timed out: %v", message.MethodArgs)
+			er := fmt.Errorf("error: methodREQHttpGet: timed out after %v getting %v, methodTimeout: %v", time.Since(start).Round(time.Millisecond), url, message.MethodTimeout)
+			proc.errorKernel.errSend(proc, message, er)
+		case er := <-errCh:
 			proc.errorKernel.errSend(proc, message, er)
 		case out := <-outCh:
-			cancel()
-
 			// Prepare and queue for sending a new message with the output
 			// of the action executed.
 			newReplyMessage(proc, message, out)
@@ -116,15 +131,21 @@ func (m methodREQHttpGetScheduled) getKind() Event {
 	return m.event
 }
 
+func (m methodREQHttpGetScheduled) isReadOnly() bool {
+	return true
+}
+
 // handler to do a Http Get Scheduled.
 // The second element of the MethodArgs slice holds the timer defined in seconds.
 func (m methodREQHttpGetScheduled) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- REQHttpGetScheduled received from: %v, containing: %v", message.FromNode, message.Data)
 	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
 
+	workDone := proc.startHandlerWork()
 	proc.processes.wg.Add(1)
 	go func() {
 		defer proc.processes.wg.Done()
+		defer workDone()
 
 		// --- Check and prepare the methodArgs
 
@@ -255,3 +276,131 @@ func (m methodREQHttpGetScheduled) handler(proc process, message Message, node s
 	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
 	return ackMsg, nil
 }
+
+// ---
+
+// httpPostReply is the reply of REQHttpPost.
+type httpPostReply struct {
+	StatusCode int `json:"statusCode"`
+	// Error is set if the status code is not 2xx.
+	Error bool   `json:"error"`
+	Body  string `json:"body"`
+}
+
+// parseHttpPostArgs will return the url and the headers of a REQHttpPost
+// from the methodArgs. The first element is the url, the optional second
+// the Content-Type, and the rest are headers on the form "Name: value".
+func parseHttpPostArgs(methodArgs []string) (string, http.Header, error) {
+	if len(methodArgs) < 1 || methodArgs[0] == "" {
+		return "", nil, fmt.Errorf("got <1 number methodArgs, want url, and optionally content type and headers")
+	}
+
+	header := http.Header{}
+	if len(methodArgs) < 2 {
+		return methodArgs[0], header, nil
+	}
+	if methodArgs[1] != "" {
+		header.Set("Content-Type", methodArgs[1])
+	}
+
+	for _, h := range methodArgs[2:] {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return "", nil, fmt.Errorf("header %q is not on the form \"Name: value\"", h)
+		}
+		header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	return methodArgs[0], header, nil
+}
+
+type methodREQHttpPost struct {
+	event Event
+}
+
+func (m methodREQHttpPost) getKind() Event {
+	return m.event
+}
+
+func (m methodREQHttpPost) isReadOnly() bool {
+	return false
+}
+
+// handler to do a Http Post with the Data of the message as the body.
+// The status code and the body of the response is sent back as the
+// reply. If the status code is not 2xx the reply is marked as an error,
+// and an error is also sent to the error logger.
+func (m methodREQHttpPost) handler(proc process, message Message, node string) ([]byte, error) {
+	inf := fmt.Errorf("<--- REQHttpPost received from: %v, containing: %v", message.FromNode, message.Data)
+	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
+
+	workDone := proc.startHandlerWork()
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+		defer workDone()
+
+		url, header, err := parseHttpPostArgs(message.MethodArgs)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQHttpPost: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		client := http.Client{}
+		if !proc.configuration.HttpPostFollowRedirects {
+			client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+		}
+
+		// Get a context with the timeout specified in message.MethodTimeout.
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(message.Data))
+		if err != nil {
+			er := fmt.Errorf("error: methodREQHttpPost: NewRequest failed: %v, bailing out: %v", err, message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+		req.Header = header
+
+		resp, err := client.Do(req)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQHttpPost: client.Do failed: %v, bailing out: %v", err, message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQHttpPost: io.ReadAll failed : %v, methodArgs: %v", err, message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		reply := httpPostReply{
+			StatusCode: resp.StatusCode,
+			Error:      resp.StatusCode < 200 || resp.StatusCode > 299,
+			Body:       string(body),
+		}
+		if reply.Error {
+			er := fmt.Errorf("error: methodREQHttpPost: not 2xx, were %v, methodArgs: %v", resp.StatusCode, message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+		}
+
+		out, err := json.Marshal(reply)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQHttpPost: failed to marshal reply: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
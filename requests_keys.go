@@ -2,9 +2,12 @@ package steward
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 )
 
 // ---
@@ -17,6 +20,10 @@ func (m methodREQPublicKey) getKind() Event {
 	return m.event
 }
 
+func (m methodREQPublicKey) isReadOnly() bool {
+	return true
+}
+
 // Handler to get the public ed25519 key from a node.
 func (m methodREQPublicKey) handler(proc process, message Message, node string) ([]byte, error) {
 	// Get a context with the timeout specified in message.MethodTimeout.
@@ -27,6 +34,8 @@ func (m methodREQPublicKey) handler(proc process, message Message, node string)
 		defer proc.processes.wg.Done()
 		outCh := make(chan []byte)
 
+		pub, _ := proc.nodeAuth.signingKeys()
+
 		go func() {
 			// Normally we would do some logic here, where the result is passed to outCh when done,
 			// so we can split up the working logic, and f.ex. sending a reply logic.
@@ -34,7 +43,7 @@ func (m methodREQPublicKey) handler(proc process, message Message, node string)
 			// structure is the same as the other handlers.
 			select {
 			case <-ctx.Done():
-			case outCh <- proc.nodeAuth.SignPublicKey:
+			case outCh <- pub:
 			}
 		}()
 
@@ -64,6 +73,10 @@ func (m methodREQKeysRequestUpdate) getKind() Event {
 	return m.event
 }
 
+func (m methodREQKeysRequestUpdate) isReadOnly() bool {
+	return true
+}
+
 // Handler to get all the public ed25519 keys from a central server.
 func (m methodREQKeysRequestUpdate) handler(proc process, message Message, node string) ([]byte, error) {
 	// Get a context with the timeout specified in message.MethodTimeout.
@@ -142,6 +155,10 @@ func (m methodREQKeysDeliverUpdate) getKind() Event {
 	return m.event
 }
 
+func (m methodREQKeysDeliverUpdate) isReadOnly() bool {
+	return false
+}
+
 // Handler to receive the public keys from a central server.
 func (m methodREQKeysDeliverUpdate) handler(proc process, message Message, node string) ([]byte, error) {
 	// Get a context with the timeout specified in message.MethodTimeout.
@@ -174,18 +191,27 @@ func (m methodREQKeysDeliverUpdate) handler(proc process, message Message, node
 		case <-ctx.Done():
 		case <-outCh:
 
-			proc.nodeAuth.publicKeys.mu.Lock()
-
 			var keysAndHash keysAndHash
 
 			err := json.Unmarshal(message.Data, &keysAndHash)
 			if err != nil {
 				er := fmt.Errorf("error: REQKeysDeliverUpdate : json unmarshal failed: %v, message: %v", err, message)
 				proc.errorKernel.errSend(proc, message, er)
+				return
 			}
 
 			fmt.Printf("\n <---- REQKeysDeliverUpdate: after unmarshal, nodeAuth keysAndhash contains: %+v\n\n", keysAndHash)
 
+			// Only use the keys if they are the ones central hashed.
+			err = verifyPublicKeysHash(keysAndHash)
+			if err != nil {
+				er := fmt.Errorf("error: REQKeysDeliverUpdate : rejected the keys delivered from %v: %v", message.FromNode, err)
+				proc.errorKernel.errSend(proc, message, er)
+				return
+			}
+
+			proc.nodeAuth.publicKeys.mu.Lock()
+
 			// If the received map was empty we also want to delete all the locally stored keys,
 			// else we copy the marshaled keysAndHash we received from central into our map.
 			if len(keysAndHash.Keys) < 1 {
@@ -196,11 +222,6 @@ func (m methodREQKeysDeliverUpdate) handler(proc process, message Message, node
 
 			proc.nodeAuth.publicKeys.mu.Unlock()
 
-			if err != nil {
-				er := fmt.Errorf("error: REQKeysDeliverUpdate : json unmarshal failed: %v, message: %v", err, message)
-				proc.errorKernel.errSend(proc, message, er)
-			}
-
 			// We need to also persist the hash on the receiving nodes. We can then load
 			// that key upon startup.
 
@@ -233,6 +254,10 @@ func (m methodREQKeysAllow) getKind() Event {
 	return m.event
 }
 
+func (m methodREQKeysAllow) isReadOnly() bool {
+	return false
+}
+
 // Handler to allow new public keys into the database on central auth.
 // Nodes will send the public key in the REQHello messages. When they
 // are recived on the central server they will be put into a temp key
@@ -354,9 +379,10 @@ func pushKeys(proc process, message Message, nodes []Node) error {
 	for n := range proc.centralAuth.pki.nodeNotAckedPublicKeys.KeyMap {
 		fmt.Printf("\n\n\n ************** DEBUG: node to send REQKeysDeliverUpdate to:%v\n ", n)
 		msg := Message{
-			ToNode:      n,
-			Method:      REQKeysDeliverUpdate,
-			ReplyMethod: REQNone,
+			ToNode:        n,
+			Method:        REQKeysDeliverUpdate,
+			ReplyMethod:   REQNone,
+			CorrelationID: message.CorrelationID,
 		}
 
 		sam, err := newSubjectAndMessage(msg)
@@ -397,10 +423,11 @@ func pushKeys(proc process, message Message, nodes []Node) error {
 	for n := range nodeMap {
 		fmt.Printf("\n\n\n ************** DEBUG: node to send REQKeysDeliverUpdate to:%v\n ", n)
 		msg := Message{
-			ToNode:      n,
-			Method:      REQKeysDeliverUpdate,
-			Data:        b,
-			ReplyMethod: REQNone,
+			ToNode:        n,
+			Method:        REQKeysDeliverUpdate,
+			Data:          b,
+			ReplyMethod:   REQNone,
+			CorrelationID: message.CorrelationID,
 		}
 
 		sam, err := newSubjectAndMessage(msg)
@@ -427,6 +454,10 @@ func (m methodREQKeysDelete) getKind() Event {
 	return m.event
 }
 
+func (m methodREQKeysDelete) isReadOnly() bool {
+	return false
+}
+
 func (m methodREQKeysDelete) handler(proc process, message Message, node string) ([]byte, error) {
 	inf := fmt.Errorf("<--- methodREQKeysDelete received from: %v, containing: %v", message.FromNode, message.MethodArgs)
 	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
@@ -507,3 +538,356 @@ func (m methodREQKeysDelete) handler(proc process, message Message, node string)
 	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
 	return ackMsg, nil
 }
+
+// ----
+
+type methodREQInspectSignature struct {
+	event Event
+}
+
+func (m methodREQInspectSignature) getKind() Event {
+	return m.event
+}
+
+func (m methodREQInspectSignature) isReadOnly() bool {
+	return true
+}
+
+// Handler to inspect the signature of the message given as JSON in the
+// first element of the methodArgs. The message is only verified and not
+// executed, and the details of the verification are replied back as JSON.
+func (m methodREQInspectSignature) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		switch {
+		case len(message.MethodArgs) < 1:
+			er := fmt.Errorf("error: methodREQInspectSignature: got <1 number methodArgs, want the message to inspect as JSON")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		var msg Message
+		err := json.Unmarshal([]byte(message.MethodArgs[0]), &msg)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQInspectSignature: failed to unmarshal message to inspect: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		if msg.FromNode == "" {
+			er := fmt.Errorf("error: methodREQInspectSignature: the message to inspect have no fromNode")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		out, err := json.Marshal(proc.nodeAuth.inspectSignature(msg))
+		if err != nil {
+			er := fmt.Errorf("error: methodREQInspectSignature: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- InspectAllowedSignatures
+
+type methodREQInspectAllowedSignatures struct {
+	event Event
+}
+
+func (m methodREQInspectAllowedSignatures) getKind() Event {
+	return m.event
+}
+
+func (m methodREQInspectAllowedSignatures) isReadOnly() bool {
+	return true
+}
+
+// Handler to reply with the nodes whose signatures are trusted by this
+// node, with the fingerprint of the public key used to verify them, as
+// JSON.
+func (m methodREQInspectAllowedSignatures) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		out, err := json.Marshal(proc.nodeAuth.allowedSignatures())
+		if err != nil {
+			er := fmt.Errorf("error: methodREQInspectAllowedSignatures: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- PublicKeysList
+
+type methodREQPublicKeysList struct {
+	event Event
+}
+
+func (m methodREQPublicKeysList) getKind() Event {
+	return m.event
+}
+
+func (m methodREQPublicKeysList) isReadOnly() bool {
+	return true
+}
+
+// Handler to reply with the fingerprints of the public keys known by the
+// node, together with the hash and the number of keys, as JSON. On
+// central these are the keys of the nodes registered.
+func (m methodREQPublicKeysList) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		out, err := json.Marshal(proc.nodeAuth.publicKeys.list())
+		if err != nil {
+			er := fmt.Errorf("error: methodREQPublicKeysList: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- ValidateTrustStore
+
+type methodREQValidateTrustStore struct {
+	event Event
+}
+
+func (m methodREQValidateTrustStore) getKind() Event {
+	return m.event
+}
+
+func (m methodREQValidateTrustStore) isReadOnly() bool {
+	return true
+}
+
+// Handler to check the integrity of the stored public keys, their hash,
+// and the signing keys of the node. The problems found are sent to the
+// error log, and replied back.
+func (m methodREQValidateTrustStore) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		problems := proc.nodeAuth.validateTrustStore()
+
+		out := fmt.Sprintf("trust store of %v is valid\n", node)
+		if len(problems) > 0 {
+			out = fmt.Sprintf("found %v problems in the trust store of %v:\n", len(problems), node)
+			for _, er := range problems {
+				proc.errorKernel.errSend(proc, message, er)
+				out += er.Error() + "\n"
+			}
+		}
+
+		newReplyMessage(proc, message, []byte(out))
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- GenerateKeypairFor
+
+type methodREQGenerateKeypairFor struct {
+	event Event
+}
+
+func (m methodREQGenerateKeypairFor) getKind() Event {
+	return m.event
+}
+
+func (m methodREQGenerateKeypairFor) isReadOnly() bool {
+	return false
+}
+
+// Handler to generate a keypair on central for the node given in the
+// methodArgs, for onboarding nodes that are not able to reach central
+// themselves. The public key is registered as allowed, and the private
+// key is replied back encrypted with the public key of the node that
+// sent the request, so only that operator is able to hand it off to the
+// new node. The private key is never stored on central.
+func (m methodREQGenerateKeypairFor) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		if len(message.MethodArgs) < 1 || message.MethodArgs[0] == "" {
+			er := fmt.Errorf("error: methodREQGenerateKeypairFor: got <1 number methodArgs, want the name of the node")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+		n := Node(message.MethodArgs[0])
+		if err := validateNodeName(string(n)); err != nil {
+			er := fmt.Errorf("error: methodREQGenerateKeypairFor: invalid node name: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		pk := proc.centralAuth.pki.nodesAcked
+		pk.mu.Lock()
+		operatorKey, operatorOK := pk.keysAndHash.Keys[message.FromNode]
+		pk.mu.Unlock()
+
+		if !operatorOK {
+			er := fmt.Errorf("error: methodREQGenerateKeypairFor: no allowed public key found for %v to encrypt the private key with", message.FromNode)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQGenerateKeypairFor: failed to generate keypair: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		sealed, err := sealProvisionedKey(priv, operatorKey)
+		// Clear the private key from memory as soon as it is encrypted.
+		for i := range priv {
+			priv[i] = 0
+		}
+		if err != nil {
+			er := fmt.Errorf("error: methodREQGenerateKeypairFor: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		// The check for an existing key and the insert are done under the
+		// same lock, so two requests for the same node can't both add a
+		// key.
+		exists := func() bool {
+			pk.mu.Lock()
+			defer pk.mu.Unlock()
+
+			if _, ok := pk.keysAndHash.Keys[n]; ok {
+				return true
+			}
+			pk.keysAndHash.Keys[n] = pub
+			return false
+		}()
+		if exists {
+			er := fmt.Errorf("error: methodREQGenerateKeypairFor: node %v already have an allowed public key, delete it with REQKeysDelete first", n)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		err = proc.centralAuth.pki.dbUpdatePublicKey(string(n), pub)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQGenerateKeypairFor: failed to store public key: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+		}
+
+		// Remove any key the node have sent itself, since it is replaced
+		// by the key generated here.
+		proc.centralAuth.pki.nodeNotAckedPublicKeys.mu.Lock()
+		delete(proc.centralAuth.pki.nodeNotAckedPublicKeys.KeyMap, n)
+		proc.centralAuth.pki.nodeNotAckedPublicKeys.mu.Unlock()
+
+		// The nodes will get the new key with their next periodic update.
+		proc.centralAuth.updateHash(proc, message)
+
+		er := fmt.Errorf("info: methodREQGenerateKeypairFor: generated keypair for %v, and allowed the public key", n)
+		proc.errorKernel.infoSend(proc, message, er)
+
+		out, err := json.Marshal(provisionedKeypair{
+			Node:                n,
+			PublicKey:           pub,
+			EncryptedPrivateKey: sealed,
+		})
+		if err != nil {
+			er := fmt.Errorf("error: methodREQGenerateKeypairFor: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- KeysRotate
+
+type methodREQKeysRotate struct {
+	event Event
+}
+
+func (m methodREQKeysRotate) getKind() Event {
+	return m.event
+}
+
+func (m methodREQKeysRotate) isReadOnly() bool {
+	return false
+}
+
+// Handler to rotate the ed25519 signing keys of the node. The new public
+// key is sent to central with a hello message, the same way the node
+// publishes its key at startup, and must be allowed on central with
+// REQKeysAllow before the other nodes will accept it. The old public key
+// is still accepted by the node itself for SignKeysRotateGrace seconds.
+func (m methodREQKeysRotate) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		grace := time.Second * time.Duration(proc.configuration.SignKeysRotateGrace)
+		pub, err := proc.nodeAuth.rotateSigningKeys(grace)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQKeysRotate: failed to rotate signing keys: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		m := Message{
+			FileName:      "hello.log",
+			Directory:     "hello-messages",
+			ToNode:        Node(proc.configuration.centralNode()),
+			FromNode:      Node(proc.node),
+			Data:          pub,
+			Method:        REQHello,
+			ACKTimeout:    10,
+			Retries:       1,
+			CorrelationID: message.CorrelationID,
+		}
+
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQKeysRotate: failed to create message with the new public key: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+		proc.toRingbufferCh <- []subjectAndMessage{sam}
+
+		er := fmt.Errorf("info: methodREQKeysRotate: rotated signing keys, and sent the new public key to central")
+		proc.errorKernel.infoSend(proc, message, er)
+
+		out := fmt.Sprintf("signing keys rotated, new public key: %v\n", base64.StdEncoding.EncodeToString(pub))
+		newReplyMessage(proc, message, []byte(out))
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
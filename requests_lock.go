@@ -0,0 +1,346 @@
+package steward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// sendLockMessage will send a lock message with the method and arguments
+// given to central, using the ack timeout and retries of the message.
+func sendLockMessage(proc process, message Message, node string, method Method, replyMethod Method, args []string) error {
+	msg := Message{
+		ToNode:        Node(proc.configuration.centralNode()),
+		FromNode:      Node(node),
+		Method:        method,
+		MethodArgs:    args,
+		ReplyMethod:   replyMethod,
+		ACKTimeout:    message.ACKTimeout,
+		Retries:       message.Retries,
+		CorrelationID: message.CorrelationID,
+	}
+
+	sam, err := newSubjectAndMessage(msg)
+	if err != nil {
+		return fmt.Errorf("newSubjectAndMessage failed: %v", err)
+	}
+	proc.toRingbufferCh <- []subjectAndMessage{sam}
+
+	return nil
+}
+
+// --- RunWithLock
+
+type methodREQRunWithLock struct {
+	event Event
+}
+
+func (m methodREQRunWithLock) getKind() Event {
+	return m.event
+}
+
+func (m methodREQRunWithLock) isReadOnly() bool {
+	return false
+}
+
+// Handler to run a command while holding a named lock on central, so
+// only one node at a time runs a command with the same lock name. The
+// methodArgs are the lock name, the lease in seconds, and then the
+// command and its arguments like with REQCliCommand. The lock is asked
+// for until it is granted or the method timeout is reached. The lease
+// is renewed while the command is running, and the lock is released
+// when the command is done. If the node dies the lease will expire on
+// central, and the lock can be given to someone else.
+func (m methodREQRunWithLock) handler(proc process, message Message, node string) ([]byte, error) {
+	workDone := proc.startHandlerWork()
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+		defer workDone()
+
+		if len(message.MethodArgs) < 3 {
+			er := fmt.Errorf("error: methodREQRunWithLock: got <3 number methodArgs, want lock name, lease in seconds, and the command")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		name := message.MethodArgs[0]
+		leaseSec, err := strconv.Atoi(message.MethodArgs[1])
+		if err != nil || leaseSec < 1 {
+			er := fmt.Errorf("error: methodREQRunWithLock: lease is not a valid number of seconds: %v", message.MethodArgs[1])
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+		lease := time.Second * time.Duration(leaseSec)
+
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		defer cancel()
+
+		// The holder is unique for each request, so several requests
+		// on the same node also are serialized by the lock.
+		holder := fmt.Sprintf("%v-%v-%v", node, message.ID, time.Now().UnixNano())
+		resultCh := proc.server.distributedLocks.wait(holder)
+		defer proc.server.distributedLocks.stopWaiting(holder)
+
+		acquireArgs := []string{name, holder, message.MethodArgs[1]}
+
+		// Ask for the lock until it is granted. The expiry of the lease
+		// is tracked locally from when the request was sent, which is
+		// never later than the expiry set on central.
+		granted := false
+		var expires time.Time
+		for !granted {
+			sentAt := time.Now()
+			err := sendLockMessage(proc, message, node, REQLockAcquire, REQLockResult, acquireArgs)
+			if err != nil {
+				er := fmt.Errorf("error: methodREQRunWithLock: %v", err)
+				proc.errorKernel.errSend(proc, message, er)
+				return
+			}
+
+			select {
+			case r := <-resultCh:
+				if r.Granted {
+					granted = true
+					expires = sentAt.Add(lease)
+					continue
+				}
+
+				inf := fmt.Errorf("info: methodREQRunWithLock: lock %v held by node %v until %v, waiting", name, r.Lease.Node, r.Lease.Expires)
+				proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
+
+				select {
+				case <-time.After(lockRetryInterval):
+				case <-ctx.Done():
+				}
+			case <-ctx.Done():
+			}
+
+			if ctx.Err() != nil {
+				er := fmt.Errorf("error: methodREQRunWithLock: lock %v was not granted before the method timed out", name)
+				proc.errorKernel.errSend(proc, message, er)
+				return
+			}
+		}
+
+		// Renew the lease while the command is running. If the lease is
+		// lost, or it expires without a renewal being granted, the lock
+		// can be given to someone else, so the command is killed to keep
+		// only one at a time running with the lock.
+		runCtx, runCancel := context.WithCancel(ctx)
+		defer runCancel()
+		lostCh := make(chan error, 1)
+		proc.processes.wg.Add(1)
+		go func() {
+			defer proc.processes.wg.Done()
+
+			ticker := time.NewTicker(lease / 2)
+			defer ticker.Stop()
+			expiry := time.NewTimer(time.Until(expires))
+			defer expiry.Stop()
+
+			// The time the first renewal not yet granted was sent.
+			var pendingSince time.Time
+
+			for {
+				select {
+				case <-ticker.C:
+					if pendingSince.IsZero() {
+						pendingSince = time.Now()
+					}
+					err := sendLockMessage(proc, message, node, REQLockAcquire, REQLockResult, append(acquireArgs, "renew"))
+					if err != nil {
+						er := fmt.Errorf("error: methodREQRunWithLock: failed to renew lease: %v", err)
+						proc.errorKernel.errSend(proc, message, er)
+					}
+				case r := <-resultCh:
+					if !r.Granted {
+						lostCh <- fmt.Errorf("lease for lock %v was lost, now held by node %v, the command was killed", name, r.Lease.Node)
+						runCancel()
+						return
+					}
+					if !pendingSince.IsZero() && pendingSince.Add(lease).After(expires) {
+						expires = pendingSince.Add(lease)
+						if !expiry.Stop() {
+							<-expiry.C
+						}
+						expiry.Reset(time.Until(expires))
+					}
+					pendingSince = time.Time{}
+				case <-expiry.C:
+					lostCh <- fmt.Errorf("lease for lock %v expired without a renewal granted by central, the command was killed", name)
+					runCancel()
+					return
+				case <-runCtx.Done():
+					return
+				}
+			}
+		}()
+
+		cmd := exec.Command(message.MethodArgs[2], message.MethodArgs[3:]...)
+		var out bytes.Buffer
+		var stderr bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &stderr
+
+		_, err = runCommandGroup(runCtx, cmd)
+		runCancel()
+
+		errRelease := sendLockMessage(proc, message, node, REQLockRelease, REQNone, []string{name, holder})
+		if errRelease != nil {
+			er := fmt.Errorf("error: methodREQRunWithLock: failed to release lock %v: %v", name, errRelease)
+			proc.errorKernel.errSend(proc, message, er)
+		}
+
+		select {
+		case lost := <-lostCh:
+			er := fmt.Errorf("error: methodREQRunWithLock: %v", lost)
+			proc.errorKernel.errSend(proc, message, er)
+			newReplyMessage(proc, message, []byte(er.Error()+"\n"))
+			return
+		default:
+		}
+
+		if err != nil {
+			er := fmt.Errorf("error: methodREQRunWithLock: cmd.Run failed : %v, methodArgs: %v, error_output: %v", err, message.MethodArgs, stderr.String())
+			proc.errorKernel.errSend(proc, message, er)
+		}
+
+		newReplyMessage(proc, message, out.Bytes())
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- LockAcquire
+
+type methodREQLockAcquire struct {
+	event Event
+}
+
+func (m methodREQLockAcquire) getKind() Event {
+	return m.event
+}
+
+func (m methodREQLockAcquire) isReadOnly() bool {
+	return false
+}
+
+// Handler on central to give a lock to a holder. The methodArgs are the
+// lock name, the holder, the lease in seconds, and optionally "renew" if
+// the lease of a lock already held should be renewed. The holder is
+// bound to the node the message came from. The result is replied as
+// JSON.
+func (m methodREQLockAcquire) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		if len(message.MethodArgs) < 3 {
+			er := fmt.Errorf("error: methodREQLockAcquire: got <3 number methodArgs, want lock name, holder and lease in seconds")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		leaseSec, err := strconv.Atoi(message.MethodArgs[2])
+		if err != nil || leaseSec < 1 {
+			er := fmt.Errorf("error: methodREQLockAcquire: lease is not a valid number of seconds: %v", message.MethodArgs[2])
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		renew := len(message.MethodArgs) > 3 && message.MethodArgs[3] == "renew"
+
+		r := proc.server.distributedLocks.acquire(message.MethodArgs[0], message.MethodArgs[1], message.FromNode, time.Second*time.Duration(leaseSec), renew)
+
+		out, err := json.Marshal(r)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQLockAcquire: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- LockRelease
+
+type methodREQLockRelease struct {
+	event Event
+}
+
+func (m methodREQLockRelease) getKind() Event {
+	return m.event
+}
+
+func (m methodREQLockRelease) isReadOnly() bool {
+	return false
+}
+
+// Handler on central to release a lock. The methodArgs are the lock name
+// and the holder, and the lock is only released if it is held by the
+// holder on the node the message came from.
+func (m methodREQLockRelease) handler(proc process, message Message, node string) ([]byte, error) {
+	if len(message.MethodArgs) < 2 {
+		er := fmt.Errorf("error: methodREQLockRelease: got <2 number methodArgs, want lock name and holder")
+		proc.errorKernel.errSend(proc, message, er)
+	} else {
+		out := "released"
+		if !proc.server.distributedLocks.release(message.MethodArgs[0], message.MethodArgs[1], message.FromNode) {
+			out = "not held"
+		}
+
+		inf := fmt.Errorf("info: methodREQLockRelease: lock %v from node %v: %v", message.MethodArgs[0], message.FromNode, out)
+		proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
+
+		newReplyMessage(proc, message, []byte(out))
+	}
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- LockResult
+
+type methodREQLockResult struct {
+	event Event
+}
+
+func (m methodREQLockResult) getKind() Event {
+	return m.event
+}
+
+func (m methodREQLockResult) isReadOnly() bool {
+	return false
+}
+
+// Handler to deliver the reply from central about a lock to the
+// REQRunWithLock waiting for it. Replies for requests no longer waiting
+// are dropped.
+func (m methodREQLockResult) handler(proc process, message Message, node string) ([]byte, error) {
+	if message.PreviousMessage == nil || len(message.PreviousMessage.MethodArgs) < 2 {
+		er := fmt.Errorf("error: methodREQLockResult: no lock holder found in the previous message")
+		proc.errorKernel.errSend(proc, message, er)
+	} else {
+		var r lockResult
+		err := json.Unmarshal(message.Data, &r)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQLockResult: failed to unmarshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+		} else {
+			proc.server.distributedLocks.deliver(message.PreviousMessage.MethodArgs[1], r)
+		}
+	}
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
@@ -1,10 +1,21 @@
 package steward
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
 )
 
 // --- OpProcessList
@@ -16,20 +27,88 @@ func (m methodREQOpProcessList) getKind() Event {
 	return m.event
 }
 
-// Handle Op Process List
+func (m methodREQOpProcessList) isReadOnly() bool {
+	return true
+}
+
+// processListFilter is the filter given in the methodArgs of
+// REQOpProcessList, where an empty field matches all processes.
+type processListFilter struct {
+	kind processKind
+	// subject is a glob pattern if it contains any of the glob
+	// characters, else a substring of the subject name.
+	subject string
+}
+
+// newProcessListFilter will create the filter from the methodArgs, where
+// the first is the kind of the processes, publisher or subscriber, and
+// the second is a glob pattern or substring of the subject name.
+func newProcessListFilter(methodArgs []string) (processListFilter, error) {
+	var f processListFilter
+
+	if len(methodArgs) > 0 {
+		f.kind = processKind(methodArgs[0])
+		switch f.kind {
+		case "", processKindPublisher, processKindSubscriber:
+		default:
+			return f, fmt.Errorf("unknown process kind %q, want %v or %v", methodArgs[0], processKindPublisher, processKindSubscriber)
+		}
+	}
+
+	if len(methodArgs) > 1 {
+		f.subject = methodArgs[1]
+		if _, err := path.Match(f.subject, ""); err != nil {
+			return f, fmt.Errorf("invalid subject pattern %q: %v", f.subject, err)
+		}
+	}
+
+	return f, nil
+}
+
+// match will return true if the process matches the filter.
+func (f processListFilter) match(p process) bool {
+	if f.kind != "" && p.processKind != f.kind {
+		return false
+	}
+	if f.subject == "" {
+		return true
+	}
+
+	name := string(p.subject.name())
+	if strings.ContainsAny(f.subject, "*?[") {
+		ok, _ := path.Match(f.subject, name)
+		return ok
+	}
+
+	return strings.Contains(name, f.subject)
+}
+
+// Handle Op Process List. The processes listed can be filtered by kind
+// and subject with the methodArgs, and all are listed if none are given.
 func (m methodREQOpProcessList) handler(proc process, message Message, node string) ([]byte, error) {
 
 	proc.processes.wg.Add(1)
 	go func() {
 		defer proc.processes.wg.Done()
 
+		filter, err := newProcessListFilter(message.MethodArgs)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQOpProcessList: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
 		out := []byte{}
 
-		// Loop the the processes map, and find all that is active to
-		// be returned in the reply message.
+		// Loop the the processes map, and find all that is active and
+		// matching the filter to be returned in the reply message.
 
 		proc.processes.active.mu.Lock()
 		for _, pTmp := range proc.processes.active.procNames {
+			if !filter.match(pTmp) {
+				continue
+			}
+
 			s := fmt.Sprintf("%v, process: %v, id: %v, name: %v\n", time.Now().Format("Mon Jan _2 15:04:05 2006"), pTmp.processKind, pTmp.processID, pTmp.subject.name())
 			sb := []byte(s)
 			out = append(out, sb...)
@@ -44,6 +123,144 @@ func (m methodREQOpProcessList) handler(proc process, message Message, node stri
 	return ackMsg, nil
 }
 
+// --- ProcessMetrics
+
+type methodREQProcessMetrics struct {
+	event Event
+}
+
+func (m methodREQProcessMetrics) getKind() Event {
+	return m.event
+}
+
+func (m methodREQProcessMetrics) isReadOnly() bool {
+	return true
+}
+
+// processMetrics is the state of one process in the reply of
+// REQProcessMetrics.
+type processMetrics struct {
+	Subject   subjectName `json:"subject"`
+	Kind      processKind `json:"kind"`
+	ProcessID int         `json:"processID"`
+	// The number of messages sent by a publisher, or received by a
+	// subscriber.
+	Messages int `json:"messages"`
+	// The time the process last sent or received a message, which is
+	// the zero time if no message have been seen.
+	LastActivity time.Time `json:"lastActivity"`
+}
+
+// Handle getting the message counts and the last activity of all the
+// running processes. The reply is JSON, sorted by subject and kind.
+func (m methodREQProcessMetrics) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		pms := []processMetrics{}
+
+		proc.processes.active.mu.Lock()
+		for _, pTmp := range proc.processes.active.procNames {
+			pms = append(pms, processMetrics{
+				Subject:      pTmp.subject.name(),
+				Kind:         pTmp.processKind,
+				ProcessID:    pTmp.processID,
+				Messages:     pTmp.messageID,
+				LastActivity: pTmp.lastActivity,
+			})
+		}
+		proc.processes.active.mu.Unlock()
+
+		sort.Slice(pms, func(i, j int) bool {
+			if pms[i].Subject != pms[j].Subject {
+				return pms[i].Subject < pms[j].Subject
+			}
+			return pms[i].Kind < pms[j].Kind
+		})
+
+		out, err := json.Marshal(pms)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQProcessMetrics: failed to marshal reply: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- SubjectSubscribeList
+
+type methodREQSubjectSubscribeList struct {
+	event Event
+}
+
+func (m methodREQSubjectSubscribeList) getKind() Event {
+	return m.event
+}
+
+func (m methodREQSubjectSubscribeList) isReadOnly() bool {
+	return true
+}
+
+// subjectSubscription is a subject a node is subscribing to, in the
+// reply of REQSubjectSubscribeList.
+type subjectSubscription struct {
+	// The full subject name, which is also the name of the queue.
+	Subject   subjectName `json:"subject"`
+	Node      string      `json:"node"`
+	Method    Method      `json:"method"`
+	Event     Event       `json:"event"`
+	ProcessID int         `json:"processID"`
+}
+
+// Handle getting the subjects of all the running subscriber processes,
+// which are the subjects the node receives messages on. A message is
+// only received if the subject it is published to is in the list, so
+// it can be used to find a subject that does not match between the
+// publisher and the subscriber.
+func (m methodREQSubjectSubscribeList) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		subs := []subjectSubscription{}
+
+		proc.processes.active.mu.Lock()
+		for _, pTmp := range proc.processes.active.procNames {
+			if pTmp.processKind != processKindSubscriber {
+				continue
+			}
+			subs = append(subs, subjectSubscription{
+				Subject:   pTmp.subject.name(),
+				Node:      pTmp.subject.ToNode,
+				Method:    pTmp.subject.Method,
+				Event:     pTmp.subject.Event,
+				ProcessID: pTmp.processID,
+			})
+		}
+		proc.processes.active.mu.Unlock()
+
+		sort.Slice(subs, func(i, j int) bool { return subs[i].Subject < subs[j].Subject })
+
+		out, err := json.Marshal(subs)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQSubjectSubscribeList: failed to marshal reply: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
 // --- OpProcessStart
 
 type methodREQOpProcessStart struct {
@@ -54,6 +271,10 @@ func (m methodREQOpProcessStart) getKind() Event {
 	return m.event
 }
 
+func (m methodREQOpProcessStart) isReadOnly() bool {
+	return false
+}
+
 // Handle Op Process Start
 func (m methodREQOpProcessStart) handler(proc process, message Message, node string) ([]byte, error) {
 	proc.processes.wg.Add(1)
@@ -109,6 +330,10 @@ func (m methodREQOpProcessStop) getKind() Event {
 	return m.event
 }
 
+func (m methodREQOpProcessStop) isReadOnly() bool {
+	return false
+}
+
 // RecevingNode Node        `json:"receivingNode"`
 // Method       Method      `json:"method"`
 // Kind         processKind `json:"kind"`
@@ -167,10 +392,12 @@ func (m methodREQOpProcessStop) handler(proc process, message Message, node stri
 			// Stop started go routines that belong to the process.
 			toStopProc.ctxCancel()
 			// Stop subscribing for messages on the process's subject.
-			err := toStopProc.natsSubscription.Unsubscribe()
-			if err != nil {
-				er := fmt.Errorf("error: methodREQOpStopProcess failed to stop nats.Subscription: %v, methodArgs: %v", err, message.MethodArgs)
-				proc.errorKernel.errSend(proc, message, er)
+			if toStopProc.subscription != nil {
+				err := toStopProc.subscription.unsubscribe()
+				if err != nil {
+					er := fmt.Errorf("error: methodREQOpStopProcess failed to stop subscription: %v, methodArgs: %v", err, message.MethodArgs)
+					proc.errorKernel.errSend(proc, message, er)
+				}
 			}
 
 			// Remove the prometheus label
@@ -201,4 +428,890 @@ func (m methodREQOpProcessStop) handler(proc process, message Message, node stri
 
 }
 
+// --- ProcessRestart
+
+// processRestartTimeout is the max time to wait for the worker of a
+// process to exit when restarting it.
+const processRestartTimeout = time.Second * 10
+
+type methodREQProcessRestart struct {
+	event Event
+}
+
+func (m methodREQProcessRestart) getKind() Event {
+	return m.event
+}
+
+func (m methodREQProcessRestart) isReadOnly() bool {
+	return false
+}
+
+// processRestartReply is the reply of REQProcessRestart.
+type processRestartReply struct {
+	Name  processName `json:"name"`
+	OldID int         `json:"oldID"`
+	NewID int         `json:"newID"`
+}
+
+// Handle restarting a running process in place. The first argument is
+// the subject name of the process, like ship1.REQHttpGet.EventACK, and
+// the optional second argument is the kind which defaults to subscriber.
+// The processes map is locked for the whole restart, so the process is
+// never seen as missing or half stopped by others using the map.
+func (m methodREQProcessRestart) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		if len(message.MethodArgs) < 1 {
+			er := fmt.Errorf("error: methodREQProcessRestart: got <1 number methodArgs, want the subject name of the process")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		kind := processKindSubscriber
+		if len(message.MethodArgs) > 1 {
+			kind = processKind(message.MethodArgs[1])
+		}
+		if kind != processKindSubscriber && kind != processKindPublisher {
+			er := fmt.Errorf("error: methodREQProcessRestart: unknown process kind: %v", kind)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		pn := processNameGet(subjectName(message.MethodArgs[0]), kind)
+
+		proc.processes.active.mu.Lock()
+		newProc, oldID, err := restartProcess(proc.processes, pn)
+		proc.processes.active.mu.Unlock()
+
+		if err != nil {
+			er := fmt.Errorf("error: methodREQProcessRestart: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		er := fmt.Errorf("info: methodREQProcessRestart: restarted process %v, old id: %v, new id: %v", pn, oldID, newProc.processID)
+		proc.errorKernel.infoSend(proc, message, er)
+
+		out, err := json.Marshal(processRestartReply{Name: pn, OldID: oldID, NewID: newProc.processID})
+		if err != nil {
+			er := fmt.Errorf("error: methodREQProcessRestart: failed to marshal reply: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// restartProcess will stop the process with the name given, wait for
+// its worker to exit or processRestartTimeout, and start a new process with the same subject and
+// procFunc in its place. The new process and the id of the old process
+// are returned. The caller must hold the lock of the processes map.
+func restartProcess(p *processes, pn processName) (process, int, error) {
+	oldProc, ok := p.active.procNames[pn]
+	if !ok {
+		return process{}, 0, fmt.Errorf("did not find process to restart: %v", pn)
+	}
+
+	oldProc.ctxCancel()
+
+	switch oldProc.processKind {
+	case processKindSubscriber:
+		// Stop receiving new messages, and wait for the handlers already
+		// running to finish.
+		if oldProc.subscription != nil {
+			err := oldProc.subscription.unsubscribe()
+			if err != nil {
+				return process{}, 0, fmt.Errorf("failed to stop subscription for %v: %v", pn, err)
+			}
+		}
+
+		// The handlers are independent of each other, so if they are not
+		// done within the timeout they are left running and we continue
+		// the restart, to not leave the subject without a subscriber.
+		deadline := time.Now().Add(processRestartTimeout)
+		for atomic.LoadInt64(oldProc.handlersInFlight) > 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond * 50)
+		}
+
+	case processKindPublisher:
+		select {
+		case <-oldProc.publisherDone:
+		case <-time.After(processRestartTimeout):
+		}
+	}
+
+	// Publishers are started with the server context, and subscribers
+	// with the context of the processes.
+	ctx := p.ctx
+	if oldProc.processKind == processKindPublisher {
+		ctx = p.server.ctx
+	}
+
+	// The subject is reused so a publisher keeps reading from the same
+	// message channel.
+	newProc := newProcess(ctx, p.server, oldProc.subject, oldProc.processKind, nil)
+	newProc.procFunc = oldProc.procFunc
+	newProc = newProc.startWorker()
+
+	p.active.procNames[pn] = newProc
+
+	return newProc, oldProc.processID, nil
+}
+
 // ----
+
+// --- DegradedMode
+
+// degradedMode holds the state of if the node is in degraded mode,
+// where only the read-only methods are allowed to be executed.
+type degradedMode struct {
+	enabled bool
+	mu      sync.Mutex
+}
+
+func newDegradedMode() *degradedMode {
+	return &degradedMode{}
+}
+
+func (d *degradedMode) set(enabled bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.enabled = enabled
+}
+
+func (d *degradedMode) isEnabled() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.enabled
+}
+
+// allows will check if a message with the method given is allowed to be
+// handled. All methods are allowed in normal mode. In degraded mode only
+// the read-only methods are allowed, and REQDegradedMode so we are able
+// to get the node back into normal mode. REQTailFileStop and
+// REQScheduledStop are also allowed, so what is running on the node can
+// be stopped while it is in degraded mode.
+func (d *degradedMode) allows(mh methodHandler, method Method) bool {
+	if !d.isEnabled() || mh.isReadOnly() {
+		return true
+	}
+
+	switch method {
+	case REQDegradedMode, REQTailFileStop, REQScheduledStop:
+		return true
+	}
+
+	return false
+}
+
+type methodREQDegradedMode struct {
+	event Event
+}
+
+func (m methodREQDegradedMode) getKind() Event {
+	return m.event
+}
+
+func (m methodREQDegradedMode) isReadOnly() bool {
+	return false
+}
+
+// Handle enabling or disabling degraded mode on the node.
+func (m methodREQDegradedMode) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		switch {
+		case len(message.MethodArgs) < 1:
+			er := fmt.Errorf("error: methodREQDegradedMode: got <1 number methodArgs, want 1")
+			proc.errorKernel.errSend(proc, message, er)
+
+			return
+		}
+
+		enabled, err := strconv.ParseBool(message.MethodArgs[0])
+		if err != nil {
+			er := fmt.Errorf("error: methodREQDegradedMode: methodArgs[0] is not true or false: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+
+			return
+		}
+
+		proc.server.degradedMode.set(enabled)
+
+		out := []byte("degraded mode disabled")
+		if enabled {
+			out = []byte("degraded mode enabled")
+		}
+
+		er := fmt.Errorf("info: methodREQDegradedMode: %s on node %v", out, node)
+		proc.errorKernel.infoSend(proc, message, er)
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- SubscribeMetrics
+
+// subscribeMetricsMaxDuration is the max time the metrics are sent for,
+// so a subscription with no method timeout does not run forever.
+var subscribeMetricsMaxDuration = time.Hour
+
+type methodREQSubscribeMetrics struct {
+	event Event
+}
+
+func (m methodREQSubscribeMetrics) getKind() Event {
+	return m.event
+}
+
+func (m methodREQSubscribeMetrics) isReadOnly() bool {
+	return true
+}
+
+// Handle continually sending the values of the metrics specified in the
+// methodArgs. The values are read from the prometheus registry for each
+// tick of the interval, and sent as replies in the prometheus text format
+// until the method timeout is reached, or at most for
+// subscribeMetricsMaxDuration.
+func (m methodREQSubscribeMetrics) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		switch {
+		case len(message.MethodArgs) < 2:
+			er := fmt.Errorf("error: methodREQSubscribeMetrics: got <2 number methodArgs, want interval in seconds, and at least one metric name")
+			proc.errorKernel.errSend(proc, message, er)
+
+			return
+		}
+
+		interval, err := strconv.Atoi(message.MethodArgs[0])
+		if err != nil || interval < 1 {
+			er := fmt.Errorf("error: methodREQSubscribeMetrics: interval value is not a valid int number of seconds: %v, methodArgs: %v", err, message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+
+			return
+		}
+
+		names := make(map[string]struct{})
+		for _, n := range message.MethodArgs[1:] {
+			names[n] = struct{}{}
+		}
+
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		defer cancel()
+		ctx, cancelMax := context.WithTimeout(ctx, subscribeMetricsMaxDuration)
+		defer cancelMax()
+
+		ticker := time.NewTicker(time.Second * time.Duration(interval))
+		defer ticker.Stop()
+
+		for {
+			mfs, err := proc.metrics.promRegistry.Gather()
+			if err != nil {
+				er := fmt.Errorf("error: methodREQSubscribeMetrics: failed to gather metrics: %v", err)
+				proc.errorKernel.errSend(proc, message, er)
+
+				return
+			}
+
+			var buf bytes.Buffer
+			for _, mf := range mfs {
+				if _, ok := names[mf.GetName()]; !ok {
+					continue
+				}
+
+				_, err := expfmt.MetricFamilyToText(&buf, mf)
+				if err != nil {
+					er := fmt.Errorf("error: methodREQSubscribeMetrics: failed to convert metric %v to text: %v", mf.GetName(), err)
+					proc.errorKernel.errSend(proc, message, er)
+				}
+			}
+
+			if buf.Len() == 0 {
+				er := fmt.Errorf("error: methodREQSubscribeMetrics: no metrics found with the names: %v", message.MethodArgs[1:])
+				proc.errorKernel.errSend(proc, message, er)
+
+				return
+			}
+
+			newReplyMessage(proc, message, buf.Bytes())
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				er := fmt.Errorf("info: method timeout or max duration of %v reached REQSubscribeMetrics, canceling: %v", subscribeMetricsMaxDuration, message.MethodArgs)
+				proc.errorKernel.infoSend(proc, message, er)
+
+				return
+			}
+		}
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- ConnectionAudit
+
+type methodREQConnectionAudit struct {
+	event Event
+}
+
+func (m methodREQConnectionAudit) getKind() Event {
+	return m.event
+}
+
+func (m methodREQConnectionAudit) isReadOnly() bool {
+	return true
+}
+
+// Handle replying with the active and recent connections made to the
+// local unix socket, tcp and http listeners as JSON.
+func (m methodREQConnectionAudit) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		out, err := json.Marshal(proc.server.connRegistry.audit())
+		if err != nil {
+			er := fmt.Errorf("error: methodREQConnectionAudit: failed to marshal connection audit: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- SyncTime
+
+type methodREQSyncTime struct {
+	event Event
+}
+
+func (m methodREQSyncTime) getKind() Event {
+	return m.event
+}
+
+func (m methodREQSyncTime) isReadOnly() bool {
+	return false
+}
+
+// Handle syncing the system clock of the node with the central. The
+// time is requested from the central with a REQTimeNow message that
+// carries the local time it was sent, and the correction is applied by
+// REQSyncTimeApply when the reply is received.
+func (m methodREQSyncTime) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		force := len(message.MethodArgs) > 0 && message.MethodArgs[0] == "force"
+
+		msg := Message{
+			ToNode:        Node(proc.configuration.centralNode()),
+			FromNode:      Node(node),
+			Method:        REQTimeNow,
+			MethodArgs:    []string{time.Now().Format(time.RFC3339Nano), strconv.FormatBool(force)},
+			ReplyMethod:   REQSyncTimeApply,
+			ACKTimeout:    proc.configuration.DefaultMessageTimeout,
+			Retries:       proc.configuration.DefaultMessageRetries,
+			Directory:     "synctime",
+			FileName:      node + ".log",
+			CorrelationID: message.CorrelationID,
+		}
+
+		sam, err := newSubjectAndMessage(msg)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQSyncTime: newSubjectAndMessage failed: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+		proc.toRingbufferCh <- []subjectAndMessage{sam}
+
+		out := fmt.Sprintf("time requested from central %v", proc.configuration.centralNode())
+		newReplyMessage(proc, message, []byte(out))
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- TimeNow
+
+type methodREQTimeNow struct {
+	event Event
+}
+
+func (m methodREQTimeNow) getKind() Event {
+	return m.event
+}
+
+func (m methodREQTimeNow) isReadOnly() bool {
+	return true
+}
+
+// Handle replying with the current time of the node.
+func (m methodREQTimeNow) handler(proc process, message Message, node string) ([]byte, error) {
+	out := []byte(time.Now().Format(time.RFC3339Nano))
+	newReplyMessage(proc, message, out)
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- NodeInfo
+
+type methodREQNodeInfo struct {
+	event Event
+}
+
+func (m methodREQNodeInfo) getKind() Event {
+	return m.event
+}
+
+func (m methodREQNodeInfo) isReadOnly() bool {
+	return true
+}
+
+// Handle replying with the host diagnostics of the node as JSON.
+func (m methodREQNodeInfo) handler(proc process, message Message, node string) ([]byte, error) {
+	out, err := json.Marshal(newNodeInfo(proc.server, time.Now()))
+	if err != nil {
+		er := fmt.Errorf("error: methodREQNodeInfo: failed to marshal node info: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	newReplyMessage(proc, message, out)
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- SyncTimeApply
+
+type methodREQSyncTimeApply struct {
+	event Event
+}
+
+func (m methodREQSyncTimeApply) getKind() Event {
+	return m.event
+}
+
+func (m methodREQSyncTimeApply) isReadOnly() bool {
+	return false
+}
+
+// Handle the reply from the central to REQTimeNow. The correction is
+// calculated from the time the request was sent, the time of the central,
+// and the time the reply was received, and applied to the system clock
+// if it is not larger than the allowed max jump.
+func (m methodREQSyncTimeApply) handler(proc process, message Message, node string) ([]byte, error) {
+	received := time.Now()
+
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		switch {
+		case message.FromNode != Node(proc.configuration.centralNode()):
+			er := fmt.Errorf("error: methodREQSyncTimeApply: time received from %v, but the central is %v", message.FromNode, proc.configuration.centralNode())
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		case message.PreviousMessage == nil || len(message.PreviousMessage.MethodArgs) < 2:
+			er := fmt.Errorf("error: methodREQSyncTimeApply: no time sync request found in the previous message")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		sent, err := time.Parse(time.RFC3339Nano, message.PreviousMessage.MethodArgs[0])
+		if err != nil {
+			er := fmt.Errorf("error: methodREQSyncTimeApply: failed to parse the time the request was sent: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+		force, _ := strconv.ParseBool(message.PreviousMessage.MethodArgs[1])
+
+		centralTime, err := time.Parse(time.RFC3339Nano, string(message.Data))
+		if err != nil {
+			er := fmt.Errorf("error: methodREQSyncTimeApply: failed to parse the time of the central: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		correction, rtt := timeCorrection(sent, centralTime, received)
+
+		err = checkTimeCorrection(correction, time.Second*time.Duration(proc.configuration.TimeSyncMaxJump), force)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQSyncTimeApply: refusing to set clock on %v: %v", node, err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		err = setSystemClock(time.Now().Add(correction))
+		if err != nil {
+			er := fmt.Errorf("error: methodREQSyncTimeApply: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		out := fmt.Sprintf("applied time correction of %v on %v, round trip time was %v", correction, node, rtt)
+
+		er := fmt.Errorf("info: methodREQSyncTimeApply: %v", out)
+		proc.errorKernel.infoSend(proc, message, er)
+
+		newReplyMessage(proc, message, []byte(out))
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- ListErrorSinks
+
+type methodREQListErrorSinks struct {
+	event Event
+}
+
+func (m methodREQListErrorSinks) getKind() Event {
+	return m.event
+}
+
+func (m methodREQListErrorSinks) isReadOnly() bool {
+	return true
+}
+
+// Handle replying with the error sinks of the error kernel, and the
+// health of each sink as JSON.
+func (m methodREQListErrorSinks) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		out, err := json.Marshal(proc.errorKernel.sinks.list())
+		if err != nil {
+			er := fmt.Errorf("error: methodREQListErrorSinks: failed to marshal error sinks: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- ManageErrorSink
+
+type methodREQManageErrorSink struct {
+	event Event
+}
+
+func (m methodREQManageErrorSink) getKind() Event {
+	return m.event
+}
+
+func (m methodREQManageErrorSink) isReadOnly() bool {
+	return false
+}
+
+// Handle enabling, disabling or changing the settings of an error sink
+// while running. The first argument is the name of the sink, the second
+// is the action which is enable, disable or setURL, and the third is the
+// value for the actions that need one. The reply is the new state of the
+// sink as JSON.
+func (m methodREQManageErrorSink) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		if len(message.MethodArgs) < 2 {
+			er := fmt.Errorf("error: methodREQManageErrorSink: got <2 number methodArgs, want the name of the sink and the action")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		name := message.MethodArgs[0]
+		action := message.MethodArgs[1]
+		var value string
+		if len(message.MethodArgs) > 2 {
+			value = message.MethodArgs[2]
+		}
+
+		state, err := proc.errorKernel.sinks.manage(name, action, value)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQManageErrorSink: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		// Log the change before replying, so the change itself is also
+		// seen by the sinks still enabled.
+		er := fmt.Errorf("info: methodREQManageErrorSink: error sink %v changed with action %v", name, action)
+		proc.errorKernel.infoSend(proc, message, er)
+
+		out, err := json.Marshal(state)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQManageErrorSink: failed to marshal error sink: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- Query
+
+type methodREQQuery struct {
+	event Event
+}
+
+func (m methodREQQuery) getKind() Event {
+	return m.event
+}
+
+func (m methodREQQuery) isReadOnly() bool {
+	return true
+}
+
+// Handle querying one of the registered read-only providers by the name
+// given in the first argument, and reply with the result as JSON.
+func (m methodREQQuery) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		if len(message.MethodArgs) < 1 {
+			er := fmt.Errorf("error: methodREQQuery: got <1 number methodArgs, want the name of the query, available queries are: %v", proc.server.queryProviders.names())
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		fn, ok := proc.server.queryProviders.get(message.MethodArgs[0])
+		if !ok {
+			er := fmt.Errorf("error: methodREQQuery: no query named %v, available queries are: %v", message.MethodArgs[0], proc.server.queryProviders.names())
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		v, err := fn()
+		if err != nil {
+			er := fmt.Errorf("error: methodREQQuery: query %v failed: %v", message.MethodArgs[0], err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		out, err := json.Marshal(v)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQQuery: failed to marshal result of query %v: %v", message.MethodArgs[0], err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- AttachMetadata
+
+type methodREQAttachMetadata struct {
+	event Event
+}
+
+func (m methodREQAttachMetadata) getKind() Event {
+	return m.event
+}
+
+func (m methodREQAttachMetadata) isReadOnly() bool {
+	return true
+}
+
+// Handle replying with the metadata attached to the message as JSON,
+// to check what metadata reaches a node. The metadata are also carried
+// over to the reply message.
+func (m methodREQAttachMetadata) handler(proc process, message Message, node string) ([]byte, error) {
+	out, err := json.Marshal(message.Metadata)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQAttachMetadata: failed to marshal metadata: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+	} else {
+		newReplyMessage(proc, message, out)
+	}
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- ShutdownScheduled
+
+type methodREQShutdownScheduled struct {
+	event Event
+}
+
+func (m methodREQShutdownScheduled) getKind() Event {
+	return m.event
+}
+
+func (m methodREQShutdownScheduled) isReadOnly() bool {
+	return false
+}
+
+// Handle scheduling a graceful shutdown of the node. The first argument
+// is the time to shut down at, given as an RFC3339 time or as a duration
+// from now like "30m", or "cancel" to cancel a scheduled shutdown. The
+// shutdown are announced to the central when scheduled, and when it fires.
+func (m methodREQShutdownScheduled) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		if len(message.MethodArgs) < 1 {
+			er := fmt.Errorf("error: methodREQShutdownScheduled: got <1 number methodArgs, want the time to shut down at, or cancel")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		if message.MethodArgs[0] == "cancel" {
+			at, err := proc.server.scheduledShutdown.cancel()
+			if err != nil {
+				er := fmt.Errorf("error: methodREQShutdownScheduled: %v", err)
+				proc.errorKernel.errSend(proc, message, er)
+				return
+			}
+
+			out := fmt.Sprintf("cancelled shutdown of %v scheduled at %v", node, at.Format(time.RFC3339))
+			er := fmt.Errorf("info: methodREQShutdownScheduled: %v", out)
+			proc.errorKernel.infoSend(proc, message, er)
+
+			newReplyMessage(proc, message, []byte(out))
+			return
+		}
+
+		at, err := parseShutdownTime(message.MethodArgs[0], time.Now())
+		if err != nil {
+			er := fmt.Errorf("error: methodREQShutdownScheduled: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		s := proc.server
+		s.scheduledShutdown.schedule(at, func() {
+			er := fmt.Errorf("info: methodREQShutdownScheduled: node %v is going down now for the scheduled shutdown", node)
+			proc.errorKernel.infoSend(proc, message, er)
+
+			shutdownNodeFunc(s)
+		})
+
+		out := fmt.Sprintf("shutdown of %v scheduled at %v", node, at.Format(time.RFC3339))
+		er := fmt.Errorf("info: methodREQShutdownScheduled: node %v is going down, %v", node, out)
+		proc.errorKernel.infoSend(proc, message, er)
+
+		newReplyMessage(proc, message, []byte(out))
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- InspectProcessGoroutines
+
+type methodREQInspectProcessGoroutines struct {
+	event Event
+}
+
+func (m methodREQInspectProcessGoroutines) getKind() Event {
+	return m.event
+}
+
+func (m methodREQInspectProcessGoroutines) isReadOnly() bool {
+	return true
+}
+
+// processGoroutines is the number of handler go routines in flight for
+// a process.
+type processGoroutines struct {
+	Name             processName `json:"name"`
+	Kind             processKind `json:"kind"`
+	ID               int         `json:"id"`
+	HandlersInFlight int64       `json:"handlersInFlight"`
+}
+
+// goroutineReport is the reply of REQInspectProcessGoroutines.
+type goroutineReport struct {
+	// The total number of go routines of the server.
+	Goroutines int                 `json:"goroutines"`
+	Processes  []processGoroutines `json:"processes"`
+}
+
+// Handle replying with the number of subscriber handler go routines in
+// flight for each process, and the total number of go routines, as JSON.
+func (m methodREQInspectProcessGoroutines) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		report := goroutineReport{
+			Goroutines: runtime.NumGoroutine(),
+			Processes:  []processGoroutines{},
+		}
+
+		proc.processes.active.mu.Lock()
+		for pn, p := range proc.processes.active.procNames {
+			pg := processGoroutines{
+				Name: pn,
+				Kind: p.processKind,
+				ID:   p.processID,
+			}
+			if p.handlersInFlight != nil {
+				pg.HandlersInFlight = atomic.LoadInt64(p.handlersInFlight)
+			}
+			report.Processes = append(report.Processes, pg)
+		}
+		proc.processes.active.mu.Unlock()
+
+		sort.Slice(report.Processes, func(i, j int) bool {
+			return report.Processes[i].Name < report.Processes[j].Name
+		})
+
+		out, err := json.Marshal(report)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQInspectProcessGoroutines: failed to marshal report: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
@@ -0,0 +1,50 @@
+package steward
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type methodREQProbeMethod struct {
+	event Event
+}
+
+func (m methodREQProbeMethod) getKind() Event {
+	return m.event
+}
+
+func (m methodREQProbeMethod) isReadOnly() bool {
+	return true
+}
+
+// Handler to probe if this node supports a method, and if the node the
+// probe came from is authorized to use it, without doing the method.
+// The first methodArgs is the method to probe, and the rest are the
+// methodArgs the method would be called with, which are used for the
+// acl check. The result is replied as JSON.
+func (m methodREQProbeMethod) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		if len(message.MethodArgs) < 1 {
+			er := fmt.Errorf("error: methodREQProbeMethod: got <1 number methodArgs, want the method to probe")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		mp := proc.probeMethod(message.FromNode, Method(message.MethodArgs[0]), message.MethodArgs[1:])
+
+		js, err := json.Marshal(mp)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQProbeMethod: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, js)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
@@ -0,0 +1,307 @@
+package steward
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// --- ValidateReachability
+
+type methodREQValidateReachability struct {
+	event Event
+}
+
+func (m methodREQValidateReachability) getKind() Event {
+	return m.event
+}
+
+func (m methodREQValidateReachability) isReadOnly() bool {
+	return true
+}
+
+// Handler to build a matrix of which nodes are able to reach each
+// other. Each node is asked with REQReachabilityProbe to ping all the
+// other nodes, and the results are replied back as a JSON matrix. The
+// methodArgs are the nodes to check, and if none are given all the
+// nodes with an allowed public key are checked. The method timeout is
+// the max time to build the matrix, where the nodes are given half of
+// the time to do their probes.
+func (m methodREQValidateReachability) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		nodes := []Node{}
+		seen := map[Node]bool{}
+		for _, n := range message.MethodArgs {
+			if n != "" && !seen[Node(n)] {
+				nodes = append(nodes, Node(n))
+				seen[Node(n)] = true
+			}
+		}
+		if len(nodes) == 0 {
+			pk := proc.server.centralAuth.pki.nodesAcked
+			pk.mu.Lock()
+			for n := range pk.keysAndHash.Keys {
+				nodes = append(nodes, n)
+			}
+			pk.mu.Unlock()
+			sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+		}
+
+		if len(nodes) < 2 {
+			er := fmt.Errorf("error: methodREQValidateReachability: need at least 2 nodes to check, got: %v", nodes)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		// Bound the total time of the check.
+		timeout := time.Second * time.Duration(message.MethodTimeout)
+		if message.MethodTimeout < 1 {
+			timeout = reachabilityDefaultTimeout
+		}
+		if timeout > reachabilityMaxTimeout {
+			timeout = reachabilityMaxTimeout
+		}
+		probeTimeout := timeout / 2
+		if probeTimeout < time.Second {
+			probeTimeout = time.Second
+		}
+
+		id, replyCh := proc.server.reachabilityWaits.add("matrix", len(nodes))
+		defer proc.server.reachabilityWaits.remove(id)
+
+		// Send the probes to all the nodes at once, so they run
+		// concurrently.
+		sams := []subjectAndMessage{}
+		for _, n := range nodes {
+			args := []string{id, probeTimeout.String()}
+			for _, other := range nodes {
+				if other != n {
+					args = append(args, string(other))
+				}
+			}
+
+			msg := Message{
+				ToNode:        n,
+				FromNode:      Node(node),
+				Method:        REQReachabilityProbe,
+				MethodArgs:    args,
+				MethodTimeout: int(math.Ceil(timeout.Seconds())),
+				ReplyMethod:   REQReachabilityResult,
+				ACKTimeout:    int(math.Ceil(probeTimeout.Seconds())),
+				Retries:       1,
+				CorrelationID: message.CorrelationID,
+			}
+			sam, err := newSubjectAndMessage(msg)
+			if err != nil {
+				er := fmt.Errorf("error: methodREQValidateReachability: newSubjectAndMessage failed: %v", err)
+				proc.errorKernel.errSend(proc, message, er)
+				return
+			}
+			sams = append(sams, sam)
+		}
+
+		ctx, cancel := context.WithTimeout(proc.ctx, timeout)
+		defer cancel()
+
+		proc.toRingbufferCh <- sams
+
+		rows := make(map[Node]reachabilityRow)
+	wait:
+		for len(rows) < len(nodes) {
+			select {
+			case r := <-replyCh:
+				var row reachabilityRow
+				err := json.Unmarshal(r.data, &row)
+				if err != nil {
+					er := fmt.Errorf("error: methodREQValidateReachability: failed to unmarshal result from %v: %v", r.fromNode, err)
+					proc.errorKernel.errSend(proc, message, er)
+					continue
+				}
+				rows[r.fromNode] = row
+			case <-ctx.Done():
+				break wait
+			}
+		}
+
+		mx := newReachabilityMatrix(nodes, rows)
+
+		out, err := json.Marshal(mx)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQValidateReachability: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- ReachabilityProbe
+
+type methodREQReachabilityProbe struct {
+	event Event
+}
+
+func (m methodREQReachabilityProbe) getKind() Event {
+	return m.event
+}
+
+func (m methodREQReachabilityProbe) isReadOnly() bool {
+	return true
+}
+
+// Handler to ping the nodes given, and reply back with which of them
+// replied. The methodArgs are the id of the check, the time to wait
+// for the pongs like "5s", and then the nodes to ping. All the nodes
+// are pinged at once.
+func (m methodREQReachabilityProbe) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		if len(message.MethodArgs) < 2 {
+			er := fmt.Errorf("error: methodREQReachabilityProbe: got <2 number methodArgs, want id, timeout, and the nodes to ping")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		probeTimeout, err := time.ParseDuration(message.MethodArgs[1])
+		if err != nil || probeTimeout <= 0 || probeTimeout > reachabilityMaxTimeout {
+			er := fmt.Errorf("error: methodREQReachabilityProbe: invalid timeout: %v", message.MethodArgs[1])
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		targets := message.MethodArgs[2:]
+		row := reachabilityRow{
+			Node:      message.ToNode,
+			Reachable: make(map[Node]bool),
+		}
+
+		id, pongCh := proc.server.reachabilityWaits.add("ping", len(targets))
+		defer proc.server.reachabilityWaits.remove(id)
+
+		sams := []subjectAndMessage{}
+		for _, t := range targets {
+			row.Reachable[Node(t)] = false
+
+			msg := Message{
+				ToNode:        Node(t),
+				FromNode:      message.ToNode,
+				Method:        REQReachabilityPing,
+				MethodArgs:    []string{id},
+				ReplyMethod:   REQReachabilityResult,
+				ACKTimeout:    int(math.Ceil(probeTimeout.Seconds())),
+				Retries:       1,
+				CorrelationID: message.CorrelationID,
+			}
+			sam, err := newSubjectAndMessage(msg)
+			if err != nil {
+				er := fmt.Errorf("error: methodREQReachabilityProbe: newSubjectAndMessage failed: %v", err)
+				proc.errorKernel.errSend(proc, message, er)
+				return
+			}
+			sams = append(sams, sam)
+		}
+
+		ctx, cancel := context.WithTimeout(proc.ctx, probeTimeout)
+		defer cancel()
+
+		proc.toRingbufferCh <- sams
+
+		for got := 0; got < len(targets); {
+			select {
+			case r := <-pongCh:
+				if _, ok := row.Reachable[r.fromNode]; ok && !row.Reachable[r.fromNode] {
+					row.Reachable[r.fromNode] = true
+					got++
+				}
+			case <-ctx.Done():
+				got = len(targets)
+			}
+		}
+
+		out, err := json.Marshal(row)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQReachabilityProbe: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- ReachabilityPing
+
+type methodREQReachabilityPing struct {
+	event Event
+}
+
+func (m methodREQReachabilityPing) getKind() Event {
+	return m.event
+}
+
+func (m methodREQReachabilityPing) isReadOnly() bool {
+	return true
+}
+
+// Handler to reply to a ping from a node doing a reachability probe.
+func (m methodREQReachabilityPing) handler(proc process, message Message, node string) ([]byte, error) {
+	newReplyMessage(proc, message, nil)
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- ReachabilityResult
+
+type methodREQReachabilityResult struct {
+	event Event
+}
+
+func (m methodREQReachabilityResult) getKind() Event {
+	return m.event
+}
+
+func (m methodREQReachabilityResult) isReadOnly() bool {
+	return true
+}
+
+// Handler to receive the replies of a reachability check, which are
+// the pongs for a node doing a probe, and the results of the probes
+// for central building the matrix. The id of the check is found in
+// the previous message.
+func (m methodREQReachabilityResult) handler(proc process, message Message, node string) ([]byte, error) {
+	if message.PreviousMessage == nil || len(message.PreviousMessage.MethodArgs) < 1 {
+		er := fmt.Errorf("error: methodREQReachabilityResult: no reachability check id found in the previous message")
+		proc.errorKernel.errSend(proc, message, er)
+	} else {
+		r := reachabilityReply{
+			fromNode: message.FromNode,
+			data:     message.Data,
+		}
+		// Replies arriving after the check timed out are expected for
+		// nodes that are slow to reach, so they are only logged.
+		err := proc.server.reachabilityWaits.deliver(message.PreviousMessage.MethodArgs[0], r)
+		if err != nil {
+			er := fmt.Errorf("info: methodREQReachabilityResult: %v", err)
+			proc.errorKernel.logConsoleOnlyIfDebug(er, proc.configuration)
+		}
+	}
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
@@ -0,0 +1,80 @@
+package steward
+
+import (
+	"fmt"
+	"strings"
+)
+
+// --- ReconcileState
+
+type methodREQReconcileState struct {
+	event Event
+}
+
+func (m methodREQReconcileState) getKind() Event {
+	return m.event
+}
+
+func (m methodREQReconcileState) isReadOnly() bool {
+	return false
+}
+
+// Handler to make the node match a desired state document given as JSON
+// in the first argument. Only the parts of the node that differ from the
+// desired state are changed, and the actions taken are replied back.
+func (m methodREQReconcileState) handler(proc process, message Message, node string) ([]byte, error) {
+	workDone := proc.startHandlerWork()
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+		defer workDone()
+
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		outCh := make(chan []byte)
+		errCh := make(chan error, 1)
+
+		go func() {
+			if len(message.MethodArgs) < 1 {
+				errCh <- fmt.Errorf("error: methodREQReconcileState: got <1 number methodArgs, want the desired state document")
+				return
+			}
+
+			ds, err := parseDesiredState([]byte(message.MethodArgs[0]))
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQReconcileState: %v", err)
+				return
+			}
+
+			actions, err := reconcileState(ctx, ds, proc.configuration.AllowedFileRoots)
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQReconcileState: %v, actions taken before the error: %v", err, actions)
+				return
+			}
+
+			out := "no changes needed\n"
+			if len(actions) > 0 {
+				out = strings.Join(actions, "\n") + "\n"
+			}
+
+			select {
+			case outCh <- []byte(out):
+			case <-ctx.Done():
+			}
+		}()
+
+		select {
+		case err := <-errCh:
+			proc.errorKernel.errSend(proc, message, err)
+		case <-ctx.Done():
+			cancel()
+			er := fmt.Errorf("error: methodREQReconcileState: method timed out: %v", message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+		case out := <-outCh:
+			cancel()
+			newReplyMessage(proc, message, out)
+		}
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
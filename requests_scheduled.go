@@ -0,0 +1,168 @@
+package steward
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// --- methodREQScheduled
+
+type methodREQScheduled struct {
+	event Event
+}
+
+func (m methodREQScheduled) getKind() Event {
+	return m.event
+}
+
+func (m methodREQScheduled) isReadOnly() bool {
+	return false
+}
+
+// Handler to publish a message on an interval. The first methodArg is
+// the message to publish as json, the second the interval in seconds,
+// and the optional third the number of times to publish it. If no count
+// is given, or it is 0, the message is published until stopped with
+// REQScheduledStop. The message is published from the node receiving
+// the REQScheduled, and only to that node. The reply is the ID to stop
+// the schedule with.
+func (m methodREQScheduled) handler(proc process, message Message, node string) ([]byte, error) {
+	inf := fmt.Errorf("<--- REQScheduled received from: %v, containing: %v", message.FromNode, message.MethodArgs)
+	proc.errorKernel.logConsoleOnlyIfDebug(inf, proc.configuration)
+
+	if len(message.MethodArgs) < 2 {
+		er := fmt.Errorf("error: methodREQScheduled: got <2 number methodArgs, want the message and the interval")
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	var inner Message
+	err := json.Unmarshal([]byte(message.MethodArgs[0]), &inner)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQScheduled: failed to unmarshal the message to schedule: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	var mt Method
+	if _, ok := mt.GetMethodsAvailable().CheckIfExists(inner.Method); !ok {
+		er := fmt.Errorf("error: methodREQScheduled: no such method to schedule: %v", inner.Method)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+	if inner.Method == REQScheduled {
+		er := fmt.Errorf("error: methodREQScheduled: a REQScheduled message can not be scheduled")
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	interval, err := strconv.Atoi(message.MethodArgs[1])
+	if err != nil || interval < 1 {
+		er := fmt.Errorf("error: methodREQScheduled: invalid interval %v, want seconds > 0", message.MethodArgs[1])
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	remaining := -1
+	if len(message.MethodArgs) > 2 && message.MethodArgs[2] != "" {
+		count, err := strconv.Atoi(message.MethodArgs[2])
+		if err != nil || count < 0 {
+			er := fmt.Errorf("error: methodREQScheduled: invalid count %v", message.MethodArgs[2])
+			proc.errorKernel.errSend(proc, message, er)
+			return nil, er
+		}
+		if count > 0 {
+			remaining = count
+		}
+	}
+
+	// The message is signed by this node when published, so it could be
+	// used to act with the rights of this node on other nodes. It can
+	// therefore only be sent to this node, where it is checked against
+	// the acl of the node that started the schedule before each publish.
+	for _, n := range append([]Node{inner.ToNode}, inner.ToNodes...) {
+		if n != "" && n != Node(proc.node) {
+			er := fmt.Errorf("error: methodREQScheduled: the scheduled message can only be sent to %v, got toNode %v", proc.node, n)
+			proc.errorKernel.errSend(proc, message, er)
+			return nil, er
+		}
+	}
+
+	// A message that requires a signature can only be scheduled if the
+	// REQScheduled was also required to be signed by the node that
+	// started it.
+	if proc.configuration.EnableSignatureCheck && proc.nodeAuth.signatureRequired(inner.Method) && !proc.nodeAuth.signatureRequired(REQScheduled) {
+		er := fmt.Errorf("error: methodREQScheduled: method %v requires a signature, and can not be scheduled when REQScheduled do not", inner.Method)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	inner.FromNode = Node(proc.node)
+	inner.ToNode = Node(proc.node)
+	inner.ToNodes = nil
+
+	sm := scheduledMessage{
+		ID:        message.ID,
+		FromNode:  message.FromNode,
+		Message:   inner,
+		Interval:  interval,
+		Remaining: remaining,
+	}
+
+	err = proc.server.scheduledMessages.start(proc, &sm)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQScheduled: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	newReplyMessage(proc, message, []byte(fmt.Sprint(message.ID)))
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- methodREQScheduledStop
+
+type methodREQScheduledStop struct {
+	event Event
+}
+
+func (m methodREQScheduledStop) getKind() Event {
+	return m.event
+}
+
+func (m methodREQScheduledStop) isReadOnly() bool {
+	return false
+}
+
+// Handler to stop a schedule started with REQScheduled. The first
+// methodArg is the ID of the REQScheduled message. Only the node that
+// started the schedule can stop it.
+func (m methodREQScheduledStop) handler(proc process, message Message, node string) ([]byte, error) {
+	if len(message.MethodArgs) < 1 {
+		er := fmt.Errorf("error: methodREQScheduledStop: got <1 number methodArgs, want the message id of the schedule")
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	id, err := strconv.Atoi(message.MethodArgs[0])
+	if err != nil {
+		er := fmt.Errorf("error: methodREQScheduledStop: invalid message id %v: %v", message.MethodArgs[0], err)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	err = proc.server.scheduledMessages.stop(id, message.FromNode)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQScheduledStop: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+		return nil, er
+	}
+
+	newReplyMessage(proc, message, []byte(fmt.Sprintf("schedule %v stopped", id)))
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
@@ -1,10 +1,14 @@
 package steward
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // -----
@@ -17,12 +21,21 @@ func (m methodREQHello) getKind() Event {
 	return m.event
 }
 
+func (m methodREQHello) isReadOnly() bool {
+	return false
+}
+
 // Handler for receiving hello messages.
 func (m methodREQHello) handler(proc process, message Message, node string) ([]byte, error) {
 	data := fmt.Sprintf("%v, Received hello from %#v\n", time.Now().Format("Mon Jan _2 15:04:05 2006"), message.FromNode)
 
-	fileName := message.FileName
-	folderTree := filepath.Join(proc.configuration.SubscribersDataFolder, message.Directory, string(message.FromNode))
+	fileName, folderTree, err := checkFileNaming(proc, message.FileName, message.Directory, string(message.FromNode))
+	if err != nil {
+		er := fmt.Errorf("error: methodREQHello.handler: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+
+		return nil, er
+	}
 
 	// Check if folder structure exist, if not create it.
 	if _, err := os.Stat(folderTree); os.IsNotExist(err) {
@@ -73,13 +86,20 @@ func (m methodREQErrorLog) getKind() Event {
 	return m.event
 }
 
+func (m methodREQErrorLog) isReadOnly() bool {
+	return false
+}
+
 // Handle the writing of error logs.
 func (m methodREQErrorLog) handler(proc process, message Message, node string) ([]byte, error) {
 	proc.metrics.promErrorMessagesReceivedTotal.Inc()
 
 	// If it was a request type message we want to check what the initial messages
 	// method, so we can use that in creating the file name to store the data.
-	fileName, folderTree := selectFileNaming(message, proc)
+	fileName, folderTree, err := selectFileNaming(message, proc)
+	if err != nil {
+		return nil, fmt.Errorf("error: methodREQErrorLog: %v", err)
+	}
 
 	// Check if folder structure exist, if not create it.
 	if _, err := os.Stat(folderTree); os.IsNotExist(err) {
@@ -122,13 +142,23 @@ func (m methodREQPing) getKind() Event {
 	return m.event
 }
 
+func (m methodREQPing) isReadOnly() bool {
+	return false
+}
+
 // Handle receving a ping.
 func (m methodREQPing) handler(proc process, message Message, node string) ([]byte, error) {
 	// Write to file that we received a ping
 
 	// If it was a request type message we want to check what the initial messages
 	// method, so we can use that in creating the file name to store the data.
-	fileName, folderTree := selectFileNaming(message, proc)
+	fileName, folderTree, err := selectFileNaming(message, proc)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQPing.handler: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+
+		return nil, er
+	}
 
 	// Check if folder structure exist, if not create it.
 	if _, err := os.Stat(folderTree); os.IsNotExist(err) {
@@ -185,13 +215,23 @@ func (m methodREQPong) getKind() Event {
 	return m.event
 }
 
+func (m methodREQPong) isReadOnly() bool {
+	return false
+}
+
 // Handle receiving a pong.
 func (m methodREQPong) handler(proc process, message Message, node string) ([]byte, error) {
 	// Write to file that we received a pong
 
 	// If it was a request type message we want to check what the initial messages
 	// method, so we can use that in creating the file name to store the data.
-	fileName, folderTree := selectFileNaming(message, proc)
+	fileName, folderTree, err := selectFileNaming(message, proc)
+	if err != nil {
+		er := fmt.Errorf("error: methodREQPong.handler: %v", err)
+		proc.errorKernel.errSend(proc, message, er)
+
+		return nil, er
+	}
 
 	// Check if folder structure exist, if not create it.
 	if _, err := os.Stat(folderTree); os.IsNotExist(err) {
@@ -218,8 +258,14 @@ func (m methodREQPong) handler(proc process, message Message, node string) ([]by
 	}
 	defer f.Close()
 
-	// And write the data
-	d := fmt.Sprintf("%v, pong received from %v\n", time.Now().Format("Mon Jan _2 15:04:05 2006"), message.PreviousMessage.ToNode)
+	// And write the data, with the round-trip latency if the ping was
+	// stamped with the time it was sent.
+	d := fmt.Sprintf("%v, pong received from %v", time.Now().Format("Mon Jan _2 15:04:05 2006"), message.PreviousMessage.ToNode)
+	if rtt, ok := pingRoundTrip(proc, message); ok {
+		d += fmt.Sprintf(", round-trip latency %v", rtt)
+		proc.metrics.promPingRoundTripDuration.With(prometheus.Labels{"node": string(message.FromNode)}).Observe(rtt.Seconds())
+	}
+	d += "\n"
 	_, err = f.Write([]byte(d))
 	f.Sync()
 	if err != nil {
@@ -231,6 +277,23 @@ func (m methodREQPong) handler(proc process, message Message, node string) ([]by
 	return ackMsg, nil
 }
 
+// pingRoundTrip will return the round-trip latency of the ping that the
+// pong given is a reply to. False is returned if the ping was not
+// stamped with the time it was sent, or if this node was restarted
+// since it was sent so the latency can't be trusted.
+func pingRoundTrip(proc process, message Message) (time.Duration, bool) {
+	if message.PreviousMessage == nil || message.PreviousMessage.PingSentAt == 0 {
+		return 0, false
+	}
+
+	sent := time.Unix(0, message.PreviousMessage.PingSentAt)
+	if sent.Before(proc.server.startedAt) {
+		return 0, false
+	}
+
+	return proc.server.monotonicNow().Sub(sent), true
+}
+
 // ---
 
 type methodREQRelayInitial struct {
@@ -241,17 +304,28 @@ func (m methodREQRelayInitial) getKind() Event {
 	return m.event
 }
 
+func (m methodREQRelayInitial) isReadOnly() bool {
+	return false
+}
+
 // Handler to relay messages via a host.
 func (m methodREQRelayInitial) handler(proc process, message Message, node string) ([]byte, error) {
 	proc.processes.wg.Add(1)
 	go func() {
 		defer proc.processes.wg.Done()
 
+		err := relayHop(&message, Node(node), proc.configuration.RelayMaxHops)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQRelayInitial: %v, message dropped: method: %v, relayToNode: %v", err, message.RelayOriginalMethod, message.RelayToNode)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
 		// Get a context with the timeout specified in message.MethodTimeout.
 		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
 		defer cancel()
 
-		outCh := make(chan []byte)
+		outCh := make(chan copyFileChunk)
 		errCh := make(chan error)
 		nothingCh := make(chan struct{}, 1)
 
@@ -275,7 +349,7 @@ func (m methodREQRelayInitial) handler(proc process, message Message, node strin
 
 			// Read the file, and put the result on the out channel to be sent when done reading.
 			proc.processes.wg.Add(1)
-			go copyFileFrom(ctx, &proc.processes.wg, SrcFilePath, errCh, outCh)
+			go copyFileFrom(ctx, &proc.processes.wg, SrcFilePath, 0, errCh, outCh)
 
 			// Since we now have read the source file we don't need the REQCopyFileFrom
 			// request method anymore, so we change the original method of the message
@@ -307,8 +381,16 @@ func (m methodREQRelayInitial) handler(proc process, message Message, node strin
 			return
 		case <-nothingCh:
 			// Do nothing.
-		case out = <-outCh:
+		case c := <-outCh:
+			// A relayed copy is sent in one message, since the chunks
+			// can't be asked for through the relay.
+			if !c.done {
+				er := fmt.Errorf("error: methodREQRelayInitial: src file to big to be relayed. max size: %v", copyFileChunkSize)
+				proc.errorKernel.errSend(proc, message, er)
 
+				return
+			}
+			out = c.data
 		}
 
 		// relay the message to the actual host here by prefixing the the RelayToNode
@@ -344,6 +426,10 @@ func (m methodREQRelay) getKind() Event {
 	return m.event
 }
 
+func (m methodREQRelay) isReadOnly() bool {
+	return false
+}
+
 // Handler to relay messages via a host.
 func (m methodREQRelay) handler(proc process, message Message, node string) ([]byte, error) {
 	// relay the message here to the actual host here.
@@ -352,6 +438,13 @@ func (m methodREQRelay) handler(proc process, message Message, node string) ([]b
 	go func() {
 		defer proc.processes.wg.Done()
 
+		err := relayHop(&message, Node(node), proc.configuration.RelayMaxHops)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQRelay: %v, message dropped: method: %v, relayToNode: %v", err, message.RelayOriginalMethod, message.RelayToNode)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
 		message.ToNode = message.RelayToNode
 		message.FromNode = Node(node)
 		message.Method = message.RelayOriginalMethod
@@ -375,6 +468,28 @@ func (m methodREQRelay) handler(proc process, message Message, node string) ([]b
 	return ackMsg, nil
 }
 
+// relayHop will record that the message is relayed by the node. An
+// error is returned if the node have relayed the message before, so the
+// message is relayed in a loop, or if the message have been relayed by
+// more than maxHops nodes. A maxHops of 0 is no limit.
+func relayHop(m *Message, node Node, maxHops int) error {
+	for _, n := range m.RelayVisited {
+		if n == node {
+			return fmt.Errorf("relay loop detected, %v have already relayed the message, relay path: %v", node, m.RelayVisited)
+		}
+	}
+
+	// Copy the path, so it is not shared with the copies of the message.
+	m.RelayVisited = append(append([]Node(nil), m.RelayVisited...), node)
+	m.RelayHops++
+
+	if maxHops > 0 && m.RelayHops > maxHops {
+		return fmt.Errorf("relayed by more than the max %v nodes, relay path: %v", maxHops, m.RelayVisited)
+	}
+
+	return nil
+}
+
 // ---
 
 type methodREQToConsole struct {
@@ -385,6 +500,10 @@ func (m methodREQToConsole) getKind() Event {
 	return m.event
 }
 
+func (m methodREQToConsole) isReadOnly() bool {
+	return true
+}
+
 // Handler to write directly to console.
 // This handler handles the writing to console both for TUI and shell clients.
 func (m methodREQToConsole) handler(proc process, message Message, node string) ([]byte, error) {
@@ -398,14 +517,80 @@ func (m methodREQToConsole) handler(proc process, message Message, node string)
 			proc.errorKernel.errSend(proc, message, er)
 		}
 	default:
-		fmt.Fprintf(os.Stdout, "%v", string(message.Data))
-		fmt.Println()
+		level, ok := consoleLevels[message.ConsoleLevel]
+		if !ok {
+			er := fmt.Errorf("error: methodREQToConsole: unknown consoleLevel %q, using info", message.ConsoleLevel)
+			proc.errorKernel.errSend(proc, message, er)
+			level = consoleLevels[consoleLevelInfo]
+		}
+
+		w := os.Stdout
+		if level.stderr {
+			w = os.Stderr
+		}
+
+		color := ""
+		if isTerminal(w) {
+			color = level.color
+		}
+
+		fmt.Fprintln(w, formatConsole(message.Data, message.ConsolePrefix, color))
 	}
 
 	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
 	return ackMsg, nil
 }
 
+const (
+	consoleLevelInfo  = "info"
+	consoleLevelWarn  = "warn"
+	consoleLevelError = "error"
+
+	consoleColorReset = "\033[0m"
+)
+
+// consoleLevel is how the data of a level is written by REQToConsole.
+type consoleLevel struct {
+	// The ANSI color code used when writing to a terminal.
+	color string
+	// Write to stderr instead of stdout.
+	stderr bool
+}
+
+// consoleLevels are the levels of REQToConsole. No level is the same
+// as info.
+var consoleLevels = map[string]consoleLevel{
+	"":                {},
+	consoleLevelInfo:  {},
+	consoleLevelWarn:  {color: "\033[33m", stderr: true},
+	consoleLevelError: {color: "\033[31m", stderr: true},
+}
+
+// formatConsole will put the prefix in front of every line of the data,
+// and wrap every line in the color if one is given.
+func formatConsole(data []byte, prefix string, color string) string {
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for i, l := range lines {
+		l = prefix + l
+		if color != "" {
+			l = color + l + consoleColorReset
+		}
+		lines[i] = l
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// isTerminal will return true if the file is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
 // ---
 
 type methodREQTuiToConsole struct {
@@ -416,6 +601,10 @@ func (m methodREQTuiToConsole) getKind() Event {
 	return m.event
 }
 
+func (m methodREQTuiToConsole) isReadOnly() bool {
+	return true
+}
+
 // Handler to write directly to console.
 // DEPRECATED
 func (m methodREQTuiToConsole) handler(proc process, message Message, node string) ([]byte, error) {
@@ -433,6 +622,154 @@ func (m methodREQTuiToConsole) handler(proc process, message Message, node strin
 
 // ---
 
+type methodREQSetMessageDefaults struct {
+	event Event
+}
+
+func (m methodREQSetMessageDefaults) getKind() Event {
+	return m.event
+}
+
+func (m methodREQSetMessageDefaults) isReadOnly() bool {
+	return false
+}
+
+// Handler to set the default values to use for the fields of messages
+// entering the system on this node that don't specify them.
+// The defaults are given as JSON in the first element of MethodArgs,
+// and will replace any previously set defaults.
+func (m methodREQSetMessageDefaults) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		outCh := make(chan []byte)
+		errCh := make(chan error, 1)
+
+		go func() {
+			switch {
+			case len(message.MethodArgs) < 1:
+				errCh <- fmt.Errorf("error: methodREQSetMessageDefaults: got <1 number methodArgs, want 1")
+				return
+			}
+
+			var d Message
+			err := json.Unmarshal([]byte(message.MethodArgs[0]), &d)
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQSetMessageDefaults: failed to unmarshal defaults: %v", err)
+				return
+			}
+
+			proc.server.messageDefaults.set(d)
+			err = proc.server.messageDefaults.saveToFile()
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQSetMessageDefaults: %v", err)
+				return
+			}
+
+			select {
+			case outCh <- []byte("message defaults updated"):
+			case <-ctx.Done():
+			}
+		}()
+
+		select {
+		case err := <-errCh:
+			proc.errorKernel.errSend(proc, message, err)
+		case <-ctx.Done():
+			cancel()
+			er := fmt.Errorf("error: methodREQSetMessageDefaults: method timed out: %v", message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+		case out := <-outCh:
+			newReplyMessage(proc, message, out)
+		}
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ---
+
+type methodREQSetPriorityPolicy struct {
+	event Event
+}
+
+func (m methodREQSetPriorityPolicy) getKind() Event {
+	return m.event
+}
+
+func (m methodREQSetPriorityPolicy) isReadOnly() bool {
+	return false
+}
+
+// Handler to set the priority tiers of the methods, used for messages
+// entering the ringbuffer on this node that don't specify their own
+// priority. The policy is given as JSON in the first element of
+// MethodArgs, like {"REQCliCommand":"high"}, and will replace the current
+// policy. If no policy is given the current policy is replied back
+// without changing it.
+func (m methodREQSetPriorityPolicy) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		ctx, cancel := getContextForMethodTimeout(proc.ctx, message)
+		outCh := make(chan []byte)
+		errCh := make(chan error, 1)
+
+		go func() {
+			if len(message.MethodArgs) > 0 && message.MethodArgs[0] != "" {
+				tiers := make(map[Method]Priority)
+				err := json.Unmarshal([]byte(message.MethodArgs[0]), &tiers)
+				if err != nil {
+					errCh <- fmt.Errorf("error: methodREQSetPriorityPolicy: failed to unmarshal policy: %v", err)
+					return
+				}
+
+				err = proc.server.priorityPolicy.set(tiers)
+				if err != nil {
+					errCh <- fmt.Errorf("error: methodREQSetPriorityPolicy: %v", err)
+					return
+				}
+				err = proc.server.priorityPolicy.saveToFile()
+				if err != nil {
+					errCh <- fmt.Errorf("error: methodREQSetPriorityPolicy: %v", err)
+					return
+				}
+			}
+
+			js, err := json.Marshal(proc.server.priorityPolicy.get())
+			if err != nil {
+				errCh <- fmt.Errorf("error: methodREQSetPriorityPolicy: failed to marshal policy: %v", err)
+				return
+			}
+
+			select {
+			case outCh <- js:
+			case <-ctx.Done():
+			}
+		}()
+
+		select {
+		case err := <-errCh:
+			proc.errorKernel.errSend(proc, message, err)
+		case <-ctx.Done():
+			cancel()
+			er := fmt.Errorf("error: methodREQSetPriorityPolicy: method timed out: %v", message.MethodArgs)
+			proc.errorKernel.errSend(proc, message, er)
+		case out := <-outCh:
+			newReplyMessage(proc, message, out)
+		}
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// ---
+
 type methodREQTest struct {
 	event Event
 }
@@ -441,6 +778,10 @@ func (m methodREQTest) getKind() Event {
 	return m.event
 }
 
+func (m methodREQTest) isReadOnly() bool {
+	return true
+}
+
 // handler to be used as a reply method when testing requests.
 // We can then within the test listen on the testCh for received
 // data and validate it.
@@ -1,22 +1,48 @@
 package steward
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/fxamacker/cbor/v2"
 	natsserver "github.com/nats-io/nats-server/v2/server"
 )
 
@@ -50,7 +76,12 @@ func TestMain(m *testing.M) {
 
 	exitCode := m.Run()
 
-	tstSrv.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	err := tstSrv.Stop(ctx)
+	if err != nil {
+		log.Printf("%v\n", err)
+	}
+	cancel()
 	tstNats.Shutdown()
 
 	os.Exit(exitCode)
@@ -79,6 +110,7 @@ func newServerForTesting(addressAndPort string, testFolder string) (*server, *Co
 	conf.IsCentralErrorLogger = true
 	conf.IsCentralAuth = true
 	conf.EnableDebug = true
+	conf.StartSubREQSyncTime = true
 
 	stewardServer, err := NewServer(&conf, "test")
 	if err != nil {
@@ -336,6 +368,99 @@ func TestRequest(t *testing.T) {
 	checkREQTailFileTest(tstSrv, tstConf, t, tstTempDir)
 	checkMetricValuesTest(tstSrv, tstConf, t, tstTempDir)
 	checkErrorKernelMalformedJSONtest(tstSrv, tstConf, t, tstTempDir)
+	checkREQSetMessageDefaultsTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQReindexAndSearchDataFolderTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQDegradedModeTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQVerifyDataIntegrityTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQSubscribeMetricsTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQPartialUpdateFileTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQConnectionAuditTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQInspectSignatureTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQResourceLimitExecTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQSyncTimeTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQManageErrorSinkTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQMeasureThroughputTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQQueryTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQBulkFileFetchTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQAttachMetadataTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQReconcileStateTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQShutdownScheduledTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQValidateTrustStoreTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQReplicateToTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQInspectProcessGoroutinesTest(tstSrv, tstConf, t, tstTempDir)
+	checkFileModesOnWriteTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQListFailedMessagesTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQChangeNodeNameTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQAdoptNodeNameTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQInspectTimeoutsTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQRunWithLockTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQExportAuditBundleTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQProbeMethodTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQWriteFileIfChangedTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQInspectAllowedSignaturesTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQStreamCommandTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQCompressStoredRepliesTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQValidateReachabilityTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQSetPriorityPolicyTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQGenerateKeypairForTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQInspectRetryStateTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQFileTemplateRenderPreviewTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQListActiveSessionsTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQCopyDirFromTest(tstSrv, tstConf, t, tstTempDir)
+	checkMessageCompressionTest(tstSrv, tstConf, t, tstTempDir)
+	checkSubmitMessagesTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQProcessRestartTest(tstSrv, tstConf, t, tstTempDir)
+	checkConcurrencyLimitsTest(tstSrv, tstConf, t, tstTempDir)
+	checkVerifySignatureTest(tstSrv, tstConf, t, tstTempDir)
+	checkSignatureBootstrapTest(tstSrv, tstConf, t, tstTempDir)
+	checkRingBufferPersistTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQTailFileStopTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQHttpPostTest(tstSrv, tstConf, t, tstTempDir)
+	checkHTTPListenerAuthTest(tstSrv, tstConf, t, tstTempDir)
+	checkListenerReadTest(tstSrv, tstConf, t, tstTempDir)
+	checkDedupTest(tstSrv, tstConf, t, tstTempDir)
+	checkMessageResignTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQCliCommandContMaxOutputTest(tstSrv, tstConf, t, tstTempDir)
+	checkJSONLoggerTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQKeysRotateTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQPublicKeysListTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQCliCommandDryRunTest(tstSrv, tstConf, t, tstTempDir)
+	checkReplyFolderTemplateTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQToFileAppendRotateTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQToFileBinaryTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQScheduledTest(tstSrv, tstConf, t, tstTempDir)
+	checkMethodHandlerMetricsTest(tstSrv, tstConf, t, tstTempDir)
+	checkHandlerPanicTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQNodeInfoTest(tstSrv, tstConf, t, tstTempDir)
+	checkMessageTimeoutsTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQCliCommandEnvTest(tstSrv, tstConf, t, tstTempDir)
+	checkMemoryTransportTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQPingRoundTripTest(tstSrv, tstConf, t, tstTempDir)
+	checkInboundRateLimitTest(tstSrv, tstConf, t, tstTempDir)
+	checkToNodesExpandTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQProcessMetricsTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQToConsoleLevelTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQCopyFileResumeTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQHttpGetTimeoutTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQSubjectSubscribeListTest(tstSrv, tstConf, t, tstTempDir)
+	checkErrorAggregateTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQCliCommandStdinTest(tstSrv, tstConf, t, tstTempDir)
+	checkHelloNodesStaleTest(tstSrv, tstConf, t, tstTempDir)
+	checkCorrelationIDTest(tstSrv, tstConf, t, tstTempDir)
+	checkDeliverUpdateHashTest(tstSrv, tstConf, t, tstTempDir)
+	checkPublicKeysAtomicWriteTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQCliCommandKillGroupTest(tstSrv, tstConf, t, tstTempDir)
+	checkRelayLoopTest(tstSrv, tstConf, t, tstTempDir)
+	checkMessageCallbackTest(tstSrv, tstConf, t, tstTempDir)
+	checkTransportPoolTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQOpProcessListFilterTest(tstSrv, tstConf, t, tstTempDir)
+	checkMessageTTLTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQToFileAppendHeaderTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQCliCommandContOutputModeTest(tstSrv, tstConf, t, tstTempDir)
+	checkConfigurationValidateTest(tstSrv, tstConf, t, tstTempDir)
+	checkPathTraversalTest(tstSrv, tstConf, t, tstTempDir)
+	checkREQEnvInfoTest(tstSrv, tstConf, t, tstTempDir)
+	checkDrainTest(tstSrv, tstConf, t, tstTempDir)
 }
 
 // Check the tailing of files type.
@@ -511,87 +636,6717 @@ func checkErrorKernelMalformedJSONtest(stewardServer *server, conf *Configuratio
 	}
 }
 
-// Check if file are getting updated with new content.
-func checkFileUpdated(fileRealPath string, fileUpdated chan bool) {
-	watcher, err := fsnotify.NewWatcher()
+// Check that message defaults set on a node are used for the fields
+// not specified in messages entering the system.
+func checkREQSetMessageDefaultsTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQSetMessageDefaults,
+		MethodArgs:    []string{`{"retries":3}`},
+		MethodTimeout: 5,
+		ReplyMethod:   REQTest,
+	}
+
+	sam, err := newSubjectAndMessage(m)
 	if err != nil {
-		log.Println("Failed fsnotify.NewWatcher")
-		return
+		t.Fatalf(" \U0001F631  [FAILED]	: newSubjectAndMessage : %v\n", err)
 	}
-	defer watcher.Close()
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
 
-	done := make(chan bool)
-	go func() {
-		//Give a true value to updated so it reads the file the first time.
-		fileUpdated <- true
+	result := <-stewardServer.errorKernel.testCh
+	if string(result) != "message defaults updated" {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQSetMessageDefaultsTest: got unexpected reply: %v\n", string(result))
+	}
+
+	defer func() {
+		stewardServer.messageDefaults.set(Message{})
+		os.Remove(stewardServer.messageDefaults.filePath)
+	}()
+
+	js := []byte(`[{"toNode":"central","method":"REQHello"},{"toNode":"central","method":"REQHello","retries":1}]`)
+	sams, err := stewardServer.convertBytesToSAMs(js)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]	: convertBytesToSAMs : %v\n", err)
+	}
+
+	if len(sams) != 2 {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQSetMessageDefaultsTest: want 2 messages, got %v\n", len(sams))
+	}
+	if sams[0].Message.Retries != 3 {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQSetMessageDefaultsTest: want default retries 3, got %v\n", sams[0].Message.Retries)
+	}
+	if sams[1].Message.Retries != 1 {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQSetMessageDefaultsTest: want explicit retries 1, got %v\n", sams[1].Message.Retries)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]	: checkREQSetMessageDefaultsTest\n")
+
+	return nil
+}
+
+// Check indexing of a seeded data folder tree, and searching the index.
+func checkREQReindexAndSearchDataFolderTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	seed := map[string]string{
+		filepath.Join(conf.SubscribersDataFolder, "indextest", "ship1", "a.result"): "data a",
+		filepath.Join(conf.SubscribersDataFolder, "indextest", "ship2", "b.result"): "data b",
+	}
+	for fp, data := range seed {
+		err := os.MkdirAll(filepath.Dir(fp), 0700)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]	: failed to create seed folder: %v\n", err)
+		}
+		err = os.WriteFile(fp, []byte(data), 0600)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]	: failed to write seed file: %v\n", err)
+		}
+	}
+	defer os.RemoveAll(filepath.Join(conf.SubscribersDataFolder, "indextest"))
+
+	// Record the method for one of the files the same way as it is done
+	// when a reply is written to the data folder.
+	err := stewardServer.dataIndex.update(filepath.Join(conf.SubscribersDataFolder, "indextest", "ship1", "a.result"), REQCliCommand)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]	: dataIndex.update: %v\n", err)
+	}
+
+	send := func(m Message) []byte {
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]	: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		return <-stewardServer.errorKernel.testCh
+	}
+
+	result := send(Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQReindexDataFolder,
+		MethodTimeout: 5,
+		ReplyMethod:   REQTest,
+	})
+	if !strings.HasPrefix(string(result), "indexed") {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQReindexAndSearchDataFolderTest: got unexpected reply: %v\n", string(result))
+	}
+
+	result = send(Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQSearchDataFolder,
+		MethodArgs:    []string{`{"method":"REQCliCommand"}`},
+		MethodTimeout: 5,
+		ReplyMethod:   REQTest,
+	})
+
+	entries := []dataIndexEntry{}
+	err = json.Unmarshal(result, &entries)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQReindexAndSearchDataFolderTest: failed to unmarshal result: %v\n", err)
+	}
+
+	var foundA, foundB bool
+	for _, e := range entries {
+		if e.Directory != "indextest" {
+			continue
+		}
+		switch e.FileName {
+		case "a.result":
+			foundA = e.Node == "ship1" && e.Size == int64(len("data a"))
+		case "b.result":
+			foundB = true
+		}
+	}
+	if !foundA || foundB {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQReindexAndSearchDataFolderTest: search by method returned wrong entries: %v\n", string(result))
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]	: checkREQReindexAndSearchDataFolderTest\n")
+
+	return nil
+}
+
+// Check that only read-only methods are allowed when in degraded mode.
+func checkREQDegradedModeTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	send := func(m Message) {
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]	: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+	}
+
+	degraded := func(enabled string, want string) {
+		send(Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQDegradedMode,
+			MethodArgs:    []string{enabled},
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		})
+
+		result := <-stewardServer.errorKernel.testCh
+		if string(result) != want {
+			t.Fatalf(" \U0001F631  [FAILED]	: checkREQDegradedModeTest: want: %v, got: %v\n", want, string(result))
+		}
+	}
+
+	// Methods writing files should not be allowed in degraded mode,
+	// while the methods stopping what is running on the node should.
+	var mt Method
+	ma := mt.GetMethodsAvailable()
+	dm := newDegradedMode()
+	dm.set(true)
+	for _, m := range []Method{REQPing, REQPong, REQScheduledStop, REQTailFileStop, REQExportAuditBundle} {
+		mh, _ := ma.CheckIfExists(m)
+		if mh.isReadOnly() {
+			t.Fatalf(" \U0001F631  [FAILED]	: checkREQDegradedModeTest: %v should not be read-only\n", m)
+		}
+		want := m == REQScheduledStop || m == REQTailFileStop
+		if dm.allows(mh, m) != want {
+			t.Fatalf(" \U0001F631  [FAILED]	: checkREQDegradedModeTest: %v allowed in degraded mode: %v, want: %v\n", m, !want, want)
+		}
+	}
+
+	// A message with a method not found should not be handled.
+	if out := stewardServer.processInitial.callHandler(Message{ToNode: "central", FromNode: "central", Method: "REQNoSuchMethod"}, nil, "central"); len(out) != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQDegradedModeTest: message with unknown method handled: %s\n", out)
+	}
+
+	degraded("true", "degraded mode enabled")
+	defer degraded("false", "degraded mode disabled")
+
+	// A write method should be refused.
+	send(Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQToFile,
+		MethodTimeout: 5,
+		Data:          []byte("should not be written"),
+		Directory:     "degradedtest",
+		FileName:      "write.result",
+	})
+
+	// A read method should work.
+	send(Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQOpProcessList,
+		MethodTimeout: 5,
+		ReplyMethod:   REQTest,
+	})
+
+	result := <-stewardServer.errorKernel.testCh
+	if !strings.Contains(string(result), "central.REQOpProcessList.EventACK") {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQDegradedModeTest: read method did not work: %v\n", string(result))
+	}
+
+	time.Sleep(time.Second * 1)
+	resultFile := filepath.Join(conf.SubscribersDataFolder, "degradedtest", "central", "write.result")
+	if _, err := os.Stat(resultFile); !os.IsNotExist(err) {
+		os.RemoveAll(filepath.Join(conf.SubscribersDataFolder, "degradedtest"))
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQDegradedModeTest: write method was not refused\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]	: checkREQDegradedModeTest\n")
+
+	return nil
+}
+
+// Check that corrupted and missing files in the data folder are found.
+func checkREQVerifyDataIntegrityTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	folder := filepath.Join(conf.SubscribersDataFolder, "integritytest", "ship1")
+	err := os.MkdirAll(folder, 0700)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]	: failed to create folder: %v\n", err)
+	}
+	defer func() {
+		os.RemoveAll(filepath.Join(conf.SubscribersDataFolder, "integritytest"))
+		stewardServer.dataIndex.reindex()
+	}()
+
+	// Write the files, and queue them to the index the same way as it
+	// is done when a reply is written to the data folder.
+	files := []string{"ok.result", "corrupt.result", "missing.result"}
+	for _, f := range files {
+		fp := filepath.Join(folder, f)
+		err := os.WriteFile(fp, []byte("some data in "+f), 0600)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]	: failed to write file: %v\n", err)
+		}
+		err = stewardServer.dataIndex.queueUpdate(fp, REQCliCommand)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]	: dataIndex.queueUpdate: %v\n", err)
+		}
+	}
+
+	// The queued files are added to the index in the background.
+	indexed := false
+	for i := 0; i < 50; i++ {
+		if len(stewardServer.dataIndex.search(dataIndexQuery{Directory: "integritytest"})) == len(files) {
+			indexed = true
+			break
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if !indexed {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQVerifyDataIntegrityTest: the queued files were not added to the index\n")
+	}
+
+	verify := func() (dataIntegrityReport, string) {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQVerifyDataIntegrity,
+			MethodArgs:    []string{`{"directory":"integritytest"}`},
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]	: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		result := <-stewardServer.errorKernel.testCh
+
+		r := dataIntegrityReport{}
+		err = json.Unmarshal(result, &r)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]	: checkREQVerifyDataIntegrityTest: failed to unmarshal result: %v\n", err)
+		}
+
+		return r, string(result)
+	}
+
+	// The checksums are recorded when the files are added to the index,
+	// so a corruption keeping the modification time and size is found
+	// by the first verification.
+	corruptFile := filepath.Join(folder, "corrupt.result")
+	fi, err := os.Stat(corruptFile)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]	: failed to stat file: %v\n", err)
+	}
+	err = os.WriteFile(corruptFile, []byte("some data in CORRUPT.result"), 0600)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]	: failed to corrupt file: %v\n", err)
+	}
+	os.Chtimes(corruptFile, fi.ModTime(), fi.ModTime())
+	os.Remove(filepath.Join(folder, "missing.result"))
+
+	r, result := verify()
+	corrupt := filepath.Join("integritytest", "ship1", "corrupt.result")
+	missing := filepath.Join("integritytest", "ship1", "missing.result")
+	if r.Checked != 3 || len(r.NoChecksum) != 0 || len(r.Corrupted) != 1 || r.Corrupted[0] != corrupt || len(r.Missing) != 1 || r.Missing[0] != missing {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQVerifyDataIntegrityTest: got unexpected report: %v\n", result)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]	: checkREQVerifyDataIntegrityTest\n")
+
+	return nil
+}
+
+// Check that the streamed metric values reflect changes to a metric.
+func checkREQSubscribeMetricsTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	const metricName = "steward_ringbuffer_stalled_messages_total"
+
+	// Drain any streamed values left when the method times out, so they
+	// are not read by other tests.
+	defer func() {
 		for {
 			select {
-			case event := <-watcher.Events:
-				log.Println("event:", event)
-				if event.Op&fsnotify.Write == fsnotify.Write {
-					log.Println("modified file:", event.Name)
-					//testing with an update chan to get updates
-					fileUpdated <- true
-				}
-			case err := <-watcher.Errors:
-				log.Println("error:", err)
+			case <-stewardServer.errorKernel.testCh:
+			case <-time.After(time.Second * 2):
+				return
 			}
 		}
 	}()
 
-	err = watcher.Add(fileRealPath)
-	if err != nil {
-		log.Fatal(err)
+	// A subscription without a method timeout should stop when the max
+	// duration is reached.
+	ch := make(chan []subjectAndMessage, 10)
+	proc := stewardServer.processInitial
+	proc.toRingbufferCh = ch
+
+	maxDuration := subscribeMetricsMaxDuration
+	subscribeMetricsMaxDuration = time.Second * 2
+	nm := Message{ID: 9501, ToNode: "central", FromNode: "central", Method: REQSubscribeMetrics, MethodArgs: []string{"1", metricName}, MethodTimeout: -1, ReplyMethod: REQTest}
+	if _, err := (methodREQSubscribeMetrics{}).handler(proc, nm, "central"); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQSubscribeMetricsTest: %v\n", err)
 	}
-	<-done
-}
 
-// Check if a file contains the given string.
-func findStringInFileTest(want string, fileName string, conf *Configuration, t *testing.T) (bool, error) {
-	// Wait n seconds for the results file to be created
-	n := 5
+	// The stream is done when no reply have been received for longer
+	// than the interval.
+	replies := 0
+	deadline := time.After(time.Second * 10)
+	for done := false; !done; {
+		select {
+		case sams := <-ch:
+			if sams[0].Message.PreviousMessage != nil && sams[0].Message.PreviousMessage.ID == 9501 {
+				replies++
+			}
+		case <-time.After(time.Second * 3):
+			done = true
+		case <-deadline:
+			t.Fatalf(" \U0001F631  [FAILED]	: checkREQSubscribeMetricsTest: stream without a method timeout did not stop at the max duration\n")
+		}
+	}
+	subscribeMetricsMaxDuration = maxDuration
+	if replies < 1 || replies > 3 {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQSubscribeMetricsTest: want the stream stopped at the max duration, got %v replies\n", replies)
+	}
 
-	for i := 0; i <= n; i++ {
-		_, err := os.Stat(fileName)
-		if os.IsNotExist(err) {
-			time.Sleep(time.Millisecond * 500)
-			continue
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQSubscribeMetrics,
+		MethodArgs:    []string{"1", metricName},
+		MethodTimeout: 4,
+		ReplyMethod:   REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]	: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	// Get the value of the metric from the streamed text format.
+	value := func(b []byte) float64 {
+		for _, line := range strings.Split(string(b), "\n") {
+			if strings.HasPrefix(line, metricName+" ") {
+				var v float64
+				fmt.Sscanf(strings.TrimPrefix(line, metricName+" "), "%g", &v)
+				return v
+			}
 		}
 
-		if os.IsNotExist(err) && i >= n {
-			return false, fmt.Errorf(" * failed: no result file created for request within the given time\n")
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQSubscribeMetricsTest: metric not found in: %v\n", string(b))
+		return 0
+	}
+
+	first := value(<-stewardServer.errorKernel.testCh)
+	stewardServer.metrics.promRingbufferStalledMessagesTotal.Add(5)
+
+	timeout := time.After(time.Second * 3)
+	for {
+		select {
+		case b := <-stewardServer.errorKernel.testCh:
+			if value(b) >= first+5 {
+				t.Logf(" \U0001f600 [SUCCESS]	: checkREQSubscribeMetricsTest\n")
+				return nil
+			}
+		case <-timeout:
+			t.Fatalf(" \U0001F631  [FAILED]	: checkREQSubscribeMetricsTest: streamed value did not reflect the change\n")
 		}
 	}
+}
 
-	fh, err := os.Open(fileName)
+// Check that a partial update of a file only changes the matched line.
+func checkREQPartialUpdateFileTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	folder := filepath.Join(tmpDir, "partialupdate")
+	err := os.MkdirAll(folder, 0700)
 	if err != nil {
-		return false, fmt.Errorf(" * failed: could not open result file: %v", err)
+		t.Fatalf(" \U0001F631  [FAILED]	: failed to create folder: %v\n", err)
 	}
+	defer os.RemoveAll(folder)
 
-	result, err := io.ReadAll(fh)
+	conf.AllowedFileRoots = folder
+	defer func() { conf.AllowedFileRoots = "" }()
+
+	fp := filepath.Join(folder, "app.conf")
+	err = os.WriteFile(fp, []byte("# app config\nhost=localhost\nport=8080\ndebug=false\n"), 0600)
 	if err != nil {
-		return false, fmt.Errorf(" * failed: could not read result file: %v", err)
+		t.Fatalf(" \U0001F631  [FAILED]	: failed to write file: %v\n", err)
 	}
 
-	found := strings.Contains(string(result), want)
-	if !found {
-		return false, nil
+	// Files outside of the allowed roots should not be allowed.
+	if _, err := checkAllowedFileRoot(conf.AllowedFileRoots, filepath.Join(folder, "..", "test.file")); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQPartialUpdateFileTest: file outside allowed roots was allowed\n")
 	}
 
-	return true, nil
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQPartialUpdateFile,
+		MethodArgs:    []string{fp, "replaceLines", "^port=", "port=9090"},
+		MethodTimeout: 5,
+		ReplyMethod:   REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]	: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	result := <-stewardServer.errorKernel.testCh
+	if !strings.Contains(string(result), "-port=8080\n+port=9090") {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQPartialUpdateFileTest: unexpected diff: %v\n", string(result))
+	}
+
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]	: failed to read file: %v\n", err)
+	}
+	want := "# app config\nhost=localhost\nport=9090\ndebug=false\n"
+	if string(b) != want {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQPartialUpdateFileTest: want: %q, got: %q\n", want, string(b))
+	}
+
+	backups, _ := filepath.Glob(fp + ".*.bak")
+	if len(backups) != 1 {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQPartialUpdateFileTest: want 1 backup file, got: %v\n", backups)
+	}
+
+	// Updates done right after each other should each get their own
+	// backup, and not overwrite the backup of the other.
+	for _, port := range []string{"port=9091", "port=9092"} {
+		_, err := partialUpdateFile(fp, partialUpdateReplaceLines, []string{"^port=", port})
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]	: checkREQPartialUpdateFileTest: partialUpdateFile: %v\n", err)
+		}
+	}
+	backups, _ = filepath.Glob(fp + ".*.bak")
+	if len(backups) != 3 {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQPartialUpdateFileTest: want 3 backup files, got: %v\n", backups)
+	}
+
+	// The lines not changed at the start and end of a large file should
+	// not be part of the diff, and a large change should not build the
+	// whole table for the longest common subsequence.
+	large := make([]string, 100000)
+	for i := range large {
+		large[i] = fmt.Sprintf("line %v", i)
+	}
+	changed := append([]string{}, large...)
+	changed[50000] = "changed"
+	if d := lineDiff(large, changed); d != "@@ line 50001\n-line 50000\n+changed\n" {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQPartialUpdateFileTest: unexpected diff of large file: %q\n", d)
+	}
+	reversed := make([]string, len(large))
+	for i := range large {
+		reversed[i] = large[len(large)-1-i]
+	}
+	if d := lineDiff(large, reversed); strings.Count(d, "\n") != 2*len(large)+1 {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQPartialUpdateFileTest: unexpected number of lines in diff of large change: %v\n", strings.Count(d, "\n"))
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]	: checkREQPartialUpdateFileTest\n")
+
+	return nil
 }
 
-// Write message to socket for testing purposes.
-func writeToSocketTest(conf *Configuration, messageText string, t *testing.T) {
-	socket, err := net.Dial("unix", filepath.Join(conf.SocketFolder, "steward.sock"))
+// Check that a connection to the tcp listener shows up in the
+// connection audit with the correct remote address.
+func checkREQConnectionAuditTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	// Find a free port for the tcp listener.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
-		t.Fatalf(" * failed: could to open socket file for writing: %v\n", err)
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQConnectionAuditTest: failed to find free port: %v\n", err)
 	}
-	defer socket.Close()
+	conf.TCPListener = ln.Addr().String()
+	ln.Close()
 
-	_, err = socket.Write([]byte(messageText))
+	go stewardServer.readTCPListener()
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		conn, err = net.Dial("tcp", conf.TCPListener)
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
 	if err != nil {
-		t.Fatalf(" * failed: could not write to socket: %v\n", err)
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQConnectionAuditTest: failed to connect to tcp listener: %v\n", err)
+	}
+	defer conn.Close()
+
+	remoteAddr := conn.LocalAddr().String()
+
+	// The listener registers the connection when it is accepted, so we
+	// try a few times to give it time to do that.
+	for i := 0; i < 10; i++ {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQConnectionAudit,
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]	: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		result := <-stewardServer.errorKernel.testCh
+
+		var audit connAudit
+		err = json.Unmarshal(result, &audit)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]	: checkREQConnectionAuditTest: failed to unmarshal audit: %v\n", err)
+		}
+
+		for _, c := range audit.Active {
+			if c.Listener == "tcp" && c.RemoteAddr == remoteAddr {
+				t.Logf(" \U0001f600 [SUCCESS]	: checkREQConnectionAuditTest\n")
+				return nil
+			}
+		}
+
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	t.Fatalf(" \U0001F631  [FAILED]	: checkREQConnectionAuditTest: connection from %v not found in the audit\n", remoteAddr)
+
+	return nil
+}
+
+// Check that the signed string reported when inspecting a signature
+// matches what was signed, and that the signature is verified.
+func checkREQInspectSignatureTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectSignatureTest: failed to generate keys: %v\n", err)
+	}
+
+	// Add the public key for a node, so the node handler can verify the
+	// signature with it.
+	const fromNode = "inspectsignaturenode"
+	stewardServer.nodeAuth.publicKeys.mu.Lock()
+	stewardServer.nodeAuth.publicKeys.keysAndHash.Keys[fromNode] = pub
+	stewardServer.nodeAuth.publicKeys.mu.Unlock()
+	defer func() {
+		stewardServer.nodeAuth.publicKeys.mu.Lock()
+		delete(stewardServer.nodeAuth.publicKeys.keysAndHash.Keys, fromNode)
+		stewardServer.nodeAuth.publicKeys.mu.Unlock()
+	}()
+
+	// The message has no data, file or reply method, so the end of the
+	// signed string is the hash of the empty data and empty fields.
+	const emptyRest = "\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n\n\n\n"
+	signed := "REQCliCommand\nship1\n1700000000\nabc\nls -l /tmp" + emptyRest
+
+	inspect := func(method Method, args []string) signatureInspection {
+		js, err := json.Marshal(Message{
+			ToNode:       "ship1",
+			FromNode:     fromNode,
+			Method:       method,
+			MethodArgs:   args,
+			SignedAt:     1700000000,
+			SignNonce:    "abc",
+			ArgSignature: ed25519.Sign(priv, []byte(signed)),
+		})
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectSignatureTest: failed to marshal message: %v\n", err)
+		}
+
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQInspectSignature,
+			MethodArgs:    []string{string(js)},
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		result := <-stewardServer.errorKernel.testCh
+
+		var si signatureInspection
+		err = json.Unmarshal(result, &si)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectSignatureTest: failed to unmarshal result: %v, %s\n", err, result)
+		}
+
+		return si
+	}
+
+	si := inspect(REQCliCommand, []string{"ls", "-l", "/tmp"})
+	if si.SignedString != signed || !si.Verified {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectSignatureTest: want signed string %q and verified, got: %+v\n", signed, si)
+	}
+	if si.PublicKey != base64.StdEncoding.EncodeToString(pub) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectSignatureTest: wrong public key reported: %v\n", si.PublicKey)
+	}
+
+	// Changing the arguments should make the verification fail.
+	si = inspect(REQCliCommand, []string{"ls", "-l", "/etc"})
+	if si.SignedString != "REQCliCommand\nship1\n1700000000\nabc\nls -l /etc"+emptyRest || si.Verified {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectSignatureTest: tampered message should not verify, got: %+v\n", si)
+	}
+
+	// Changing the method should also make the verification fail, so a
+	// signature can't be replayed against another method.
+	si = inspect(REQCliCommandCont, []string{"ls", "-l", "/tmp"})
+	if si.Verified {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectSignatureTest: message with tampered method should not verify, got: %+v\n", si)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQInspectSignatureTest\n")
+
+	return nil
+}
+
+// Check that commands exceeding the resource limits are killed, and
+// that the reason is reported.
+func checkREQResourceLimitExecTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	if runtime.GOOS != "linux" {
+		t.Logf(" * skipping checkREQResourceLimitExecTest, resource limits are only supported on linux\n")
+		return nil
 	}
 
+	// Call the handler directly and catch the replies, so the reply to
+	// each command can be matched to it by the message id.
+	ch := make(chan []subjectAndMessage, 10)
+	proc := stewardServer.processInitial
+	proc.toRingbufferCh = ch
+
+	run := func(id int, args []string, want string) string {
+		m := Message{
+			ID:            id,
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQResourceLimitExec,
+			MethodArgs:    args,
+			MethodTimeout: 10,
+			ReplyMethod:   REQTest,
+		}
+		if _, err := (methodREQResourceLimitExec{}).handler(proc, m, "central"); err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQResourceLimitExecTest: %v\n", err)
+		}
+
+		select {
+		case sams := <-ch:
+			reply := sams[0].Message
+			if reply.PreviousMessage == nil || reply.PreviousMessage.ID != id {
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkREQResourceLimitExecTest: want reply to message %v, got: %+v\n", id, reply)
+			}
+			if !strings.Contains(string(reply.Data), want) {
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkREQResourceLimitExecTest: want %q, got: %v\n", want, string(reply.Data))
+			}
+			return string(reply.Data)
+		case <-time.After(time.Second * 15):
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQResourceLimitExecTest: no reply to %v\n", args)
+		}
+		return ""
+	}
+
+	// tail will keep reading /dev/zero into memory looking for a newline,
+	// until it is refused more memory.
+	run(1, []string{"memory=20M", "tail", "/dev/zero"}, "stopped by resource limit: the command was refused more memory")
+	run(2, []string{"cpu=1", "/bin/sh", "-c", "while :; do :; done"}, "stopped by resource limit: cpu time")
+	// A command ignoring the SIGXCPU is killed at the hard limit.
+	run(3, []string{"cpu=1", "/bin/sh", "-c", "trap '' XCPU; while :; do :; done"}, "stopped by resource limit: cpu time")
+	if out := run(4, []string{"memory=20M,cpu=5", "echo", "within limits"}, "within limits"); strings.Contains(out, "resource limit") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQResourceLimitExecTest: want a command within the limits not reported, got: %v\n", out)
+	}
+
+	// A command failing for another reason while its memory is limited
+	// should not be reported as stopped by the limit, only that the
+	// limit might have caused it.
+	_, _, limitExceeded, err := runWithResourceLimits(context.Background(), resourceLimits{MemoryBytes: 20 * 1024 * 1024}, "/bin/sh", []string{"-c", "echo failed >&2; exit 3"})
+	if limitExceeded != "" || err == nil || !strings.Contains(err.Error(), "might have caused the failure") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQResourceLimitExecTest: want failure reported as possibly caused by the limit, got limitExceeded: %q, err: %v\n", limitExceeded, err)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQResourceLimitExecTest\n")
+
+	return nil
+}
+
+// Check the time correction computation, that large jumps are refused,
+// and that the correction is applied with the clock-set call stubbed.
+func checkREQSyncTimeTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	// The request sent at T, the central clock at T+10m, and the reply
+	// received at T+2s, should give a correction of 10m minus half the
+	// round trip time.
+	sent := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	correction, rtt := timeCorrection(sent, sent.Add(time.Minute*10), sent.Add(time.Second*2))
+	if correction != time.Minute*10-time.Second || rtt != time.Second*2 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSyncTimeTest: wrong correction: %v, rtt: %v\n", correction, rtt)
+	}
+
+	if err := checkTimeCorrection(correction, time.Minute, false); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSyncTimeTest: large jump was not refused\n")
+	}
+	if err := checkTimeCorrection(-correction, time.Minute, false); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSyncTimeTest: large negative jump was not refused\n")
+	}
+	if err := checkTimeCorrection(correction, time.Minute, true); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSyncTimeTest: forced jump was refused: %v\n", err)
+	}
+
+	// Stub the call setting the clock, and sync the time of central
+	// with itself.
+	setCh := make(chan time.Time, 1)
+	setSystemClock = func(tm time.Time) error {
+		setCh <- tm
+		return nil
+	}
+	defer func() { setSystemClock = setSystemClockOS }()
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQSyncTime,
+		MethodTimeout: 5,
+		ReplyMethod:   REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	result := <-stewardServer.errorKernel.testCh
+	if !strings.Contains(string(result), "time requested from central") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSyncTimeTest: got: %v\n", string(result))
+	}
+
+	select {
+	case tm := <-setCh:
+		if d := time.Since(tm); d > time.Second || d < -time.Second {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSyncTimeTest: clock set %v away from the time of the central\n", d)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSyncTimeTest: clock was not set\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQSyncTimeTest\n")
+
+	return nil
+}
+
+func checkREQManageErrorSinkTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	// Start a webhook that counts the errors posted to it.
+	var mu sync.Mutex
+	var webhookErrors int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		webhookErrors++
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	webhookCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return webhookErrors
+	}
+
+	sinkSent := func(name string) int {
+		for _, st := range stewardServer.errorKernel.sinks.list() {
+			if st.Name == name {
+				return st.Sent
+			}
+		}
+		return 0
+	}
+
+	manageSink := func(args ...string) {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQManageErrorSink,
+			MethodArgs:    args,
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]	: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		result := <-stewardServer.errorKernel.testCh
+
+		var st errorSinkState
+		err = json.Unmarshal(result, &st)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]	: checkREQManageErrorSinkTest: failed to unmarshal sink state: %v\n", err)
+		}
+	}
+
+	// waitFor will wait until the check returns true, or fail the test.
+	waitFor := func(what string, check func() bool) {
+		for i := 0; i < 50; i++ {
+			if check() {
+				return
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQManageErrorSinkTest: %v\n", what)
+	}
+
+	manageSink("webhook", "setURL", ts.URL)
+	manageSink("webhook", "enable")
+
+	// An error should reach both the central and the webhook sink.
+	centralBefore := sinkSent("central")
+	webhookBefore := webhookCount()
+	stewardServer.errorKernel.errSend(stewardServer.processInitial, Message{}, fmt.Errorf("error: checkREQManageErrorSinkTest: first"))
+	waitFor("error did not reach the webhook sink", func() bool { return webhookCount() > webhookBefore })
+	waitFor("error did not reach the central sink", func() bool { return sinkSent("central") > centralBefore })
+
+	manageSink("webhook", "disable")
+
+	// Wait for the info message about the change to be delivered before
+	// counting, then send a new error that should only reach central.
+	time.Sleep(time.Millisecond * 500)
+	centralBefore = sinkSent("central")
+	webhookBefore = webhookCount()
+	stewardServer.errorKernel.errSend(stewardServer.processInitial, Message{}, fmt.Errorf("error: checkREQManageErrorSinkTest: second"))
+	waitFor("error did not reach the central sink after disabling the webhook", func() bool { return sinkSent("central") > centralBefore })
+
+	time.Sleep(time.Millisecond * 200)
+	if webhookCount() != webhookBefore {
+		t.Fatalf(" \U0001F631  [FAILED]	: checkREQManageErrorSinkTest: error reached the disabled webhook sink\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]	: checkREQManageErrorSinkTest\n")
+	return nil
+}
+
+func checkREQMeasureThroughputTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQMeasureThroughput,
+		MethodArgs:    []string{"central", "300000"},
+		MethodTimeout: 20,
+		ReplyMethod:   REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	result := <-stewardServer.errorKernel.testCh
+
+	var r throughputResult
+	err = json.Unmarshal(result, &r)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQMeasureThroughputTest: failed to unmarshal result: %v, %s\n", err, result)
+	}
+
+	if !r.Complete || r.Bytes != 300000 || r.Duration <= 0 || r.BytesPerSec <= 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQMeasureThroughputTest: result not plausible: %+v\n", r)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQMeasureThroughputTest: %.0f bytes/sec\n", r.BytesPerSec)
+	return nil
+}
+
+func checkREQQueryTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	err := stewardServer.RegisterQueryProvider("testQuery", func() (any, error) {
+		return map[string]string{"hello": "world"}, nil
+	})
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQQueryTest: RegisterQueryProvider failed: %v\n", err)
+	}
+
+	query := func(name string) []byte {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQQuery,
+			MethodArgs:    []string{name},
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		return <-stewardServer.errorKernel.testCh
+	}
+
+	// Query the custom provider.
+	custom := map[string]string{}
+	err = json.Unmarshal(query("testQuery"), &custom)
+	if err != nil || custom["hello"] != "world" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQQueryTest: custom provider got: %v, err: %v\n", custom, err)
+	}
+
+	// Query the built-in processes provider, where the REQQuery subscriber
+	// itself should be found.
+	procs := []queryProcess{}
+	err = json.Unmarshal(query("processes"), &procs)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQQueryTest: failed to unmarshal processes: %v\n", err)
+	}
+
+	found := false
+	for _, p := range procs {
+		if p.Subject == newSubject(REQQuery, "central").name() {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQQueryTest: REQQuery subscriber not found in processes: %v\n", procs)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQQueryTest\n")
+	return nil
+}
+
+// Check that several files can be fetched in one archive, and that the
+// files that could not be fetched are listed in the errors entry.
+func checkREQBulkFileFetchTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	// Use the absolute path since the files are named by their absolute
+	// path in the archive.
+	folder, err := filepath.Abs(filepath.Join(tmpDir, "bulkfetch"))
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: failed to get absolute path: %v\n", err)
+	}
+	err = os.MkdirAll(folder, 0700)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: failed to create folder: %v\n", err)
+	}
+	defer os.RemoveAll(folder)
+
+	conf.AllowedFileRoots = folder
+	defer func() { conf.AllowedFileRoots = "" }()
+
+	files := map[string]string{
+		filepath.Join(folder, "app.log"):  "some log lines\n",
+		filepath.Join(folder, "app.conf"): "port=8080\n",
+	}
+	for fp, content := range files {
+		err := os.WriteFile(fp, []byte(content), 0600)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: failed to write file: %v\n", err)
+		}
+	}
+	missing := filepath.Join(folder, "missing.txt")
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQBulkFileFetch,
+		MethodArgs:    []string{filepath.Join(folder, "app.log"), missing, filepath.Join(folder, "app.conf")},
+		MethodTimeout: 5,
+		ReplyMethod:   REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	result := <-stewardServer.errorKernel.testCh
+
+	gz, err := gzip.NewReader(bytes.NewReader(result))
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQBulkFileFetchTest: reply is not gzip: %v\n", err)
+	}
+	tr := tar.NewReader(gz)
+
+	got := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQBulkFileFetchTest: failed to read tar: %v\n", err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQBulkFileFetchTest: failed to read tar entry: %v\n", err)
+		}
+		got[hdr.Name] = string(b)
+	}
+
+	if len(got) != 3 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQBulkFileFetchTest: want 3 entries, got: %v\n", got)
+	}
+	for fp, content := range files {
+		if got[strings.TrimPrefix(fp, "/")] != content {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQBulkFileFetchTest: wrong content for %v: %q\n", fp, got[strings.TrimPrefix(fp, "/")])
+		}
+	}
+	if !strings.Contains(got[bulkFileFetchErrorsEntry], missing) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQBulkFileFetchTest: missing file not in errors entry: %q\n", got[bulkFileFetchErrorsEntry])
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQBulkFileFetchTest\n")
+	return nil
+}
+
+// Check that the metadata set on a message are seen by the handler, and
+// carried over to the reply.
+func checkREQAttachMetadataTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	md := map[string]string{"change-ticket": "CHG-1234"}
+
+	// The metadata of the messages created from toNodes should be copies.
+	msgs := stewardServer.checkMessageToNodes([]Message{{
+		ToNodes:       []Node{"central"},
+		FromNode:      "central",
+		Method:        REQAttachMetadata,
+		MethodTimeout: 5,
+		ReplyMethod:   REQTest,
+		Metadata:      md,
+	}})
+	if len(msgs) != 1 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQAttachMetadataTest: want 1 message, got: %v\n", len(msgs))
+	}
+	md["change-ticket"] = "changed"
+	if msgs[0].Metadata["change-ticket"] != "CHG-1234" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQAttachMetadataTest: metadata shared with the original message\n")
+	}
+
+	// The metadata should survive gob encoding.
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(msgs[0])
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQAttachMetadataTest: gob encode failed: %v\n", err)
+	}
+	var decoded Message
+	err = gob.NewDecoder(&buf).Decode(&decoded)
+	if err != nil || decoded.Metadata["change-ticket"] != "CHG-1234" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQAttachMetadataTest: metadata lost in gob encoding: %v, err: %v\n", decoded.Metadata, err)
+	}
+
+	// The handler should see the metadata.
+	sam, err := newSubjectAndMessage(msgs[0])
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	result := <-stewardServer.errorKernel.testCh
+	seen := map[string]string{}
+	err = json.Unmarshal(result, &seen)
+	if err != nil || seen["change-ticket"] != "CHG-1234" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQAttachMetadataTest: handler got metadata: %s, err: %v\n", result, err)
+	}
+
+	// The reply should carry the metadata.
+	proc := stewardServer.processInitial
+	ch := make(chan []subjectAndMessage, 1)
+	proc.toRingbufferCh = ch
+	newReplyMessage(proc, msgs[0], []byte("reply"))
+	reply := <-ch
+	if reply[0].Message.Metadata["change-ticket"] != "CHG-1234" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQAttachMetadataTest: metadata not in reply: %v\n", reply[0].Message.Metadata)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQAttachMetadataTest\n")
+	return nil
+}
+
+// Check that reconciling a desired state twice only makes changes the
+// first time.
+func checkREQReconcileStateTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	folder, err := filepath.Abs(filepath.Join(tmpDir, "reconcile"))
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: failed to get absolute path: %v\n", err)
+	}
+	err = os.MkdirAll(folder, 0700)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: failed to create folder: %v\n", err)
+	}
+	defer os.RemoveAll(folder)
+
+	conf.AllowedFileRoots = folder
+	defer func() { conf.AllowedFileRoots = "" }()
+
+	fp := filepath.Join(folder, "motd")
+	ds := desiredState{
+		Files: []desiredFile{{Path: fp, Content: "welcome\n", Mode: "0640"}},
+	}
+	js, err := json.Marshal(ds)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: failed to marshal desired state: %v\n", err)
+	}
+
+	reconcile := func() string {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQReconcileState,
+			MethodArgs:    []string{string(js)},
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		return string(<-stewardServer.errorKernel.testCh)
+	}
+
+	result := reconcile()
+	if !strings.Contains(result, "created file "+fp) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQReconcileStateTest: first run got: %v\n", result)
+	}
+
+	fi, err := os.Stat(fp)
+	if err != nil || fi.Mode().Perm() != 0640 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQReconcileStateTest: file not created with mode 0640: %v, err: %v\n", fi, err)
+	}
+
+	result = reconcile()
+	if result != "no changes needed\n" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQReconcileStateTest: second run was not a no-op: %v\n", result)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQReconcileStateTest\n")
+	return nil
+}
+
+// Check that a scheduled shutdown runs at the time given, and that a
+// scheduled shutdown can be cancelled.
+func checkREQShutdownScheduledTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	shutdownCh := make(chan time.Time, 1)
+	shutdownNodeFunc = func(s *server) {
+		shutdownCh <- time.Now()
+	}
+	defer func() { shutdownNodeFunc = shutdownNode }()
+
+	schedule := func(arg string) string {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQShutdownScheduled,
+			MethodArgs:    []string{arg},
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		return string(<-stewardServer.errorKernel.testCh)
+	}
+
+	// Schedule and cancel, and check that the shutdown does not run.
+	result := schedule("1s")
+	if !strings.Contains(result, "shutdown of central scheduled at") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQShutdownScheduledTest: schedule got: %v\n", result)
+	}
+	result = schedule("cancel")
+	if !strings.Contains(result, "cancelled shutdown") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQShutdownScheduledTest: cancel got: %v\n", result)
+	}
+	select {
+	case <-shutdownCh:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQShutdownScheduledTest: cancelled shutdown was run\n")
+	case <-time.After(time.Millisecond * 1500):
+	}
+
+	// Schedule, and check that the shutdown runs at the right time.
+	scheduled := time.Now()
+	schedule("2s")
+	select {
+	case at := <-shutdownCh:
+		if d := at.Sub(scheduled); d < time.Second*2 || d > time.Second*4 {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQShutdownScheduledTest: shutdown ran after %v, want 2s\n", d)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQShutdownScheduledTest: scheduled shutdown did not run\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQShutdownScheduledTest\n")
+	return nil
+}
+
+// Check that a stored public keys hash that does not match the keys is
+// detected by the trust store validation.
+func checkREQValidateTrustStoreTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	pk := stewardServer.nodeAuth.publicKeys
+
+	// Keep the current keys and file so they can be restored.
+	pk.mu.Lock()
+	origKeysAndHash := pk.keysAndHash
+	pk.mu.Unlock()
+	origFile, origFileErr := os.ReadFile(pk.filePath)
+	defer func() {
+		pk.mu.Lock()
+		pk.keysAndHash = origKeysAndHash
+		pk.mu.Unlock()
+		if origFileErr == nil {
+			os.WriteFile(pk.filePath, origFile, 0600)
+		} else {
+			os.Remove(pk.filePath)
+		}
+	}()
+
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQValidateTrustStoreTest: failed to generate key: %v\n", err)
+	}
+	kh := newKeysAndHash()
+	kh.Keys["ship1"] = pub
+	kh.Hash, err = hashPublicKeys(kh.Keys)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQValidateTrustStoreTest: failed to hash keys: %v\n", err)
+	}
+
+	validate := func() string {
+		pk.mu.Lock()
+		pk.keysAndHash = kh
+		pk.mu.Unlock()
+		err := pk.saveToFile()
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQValidateTrustStoreTest: failed to save keys: %v\n", err)
+		}
+
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQValidateTrustStore,
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		return string(<-stewardServer.errorKernel.testCh)
+	}
+
+	result := validate()
+	if !strings.Contains(result, "trust store of central is valid") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQValidateTrustStoreTest: valid trust store got: %v\n", result)
+	}
+
+	// Corrupt the stored hash.
+	kh.Hash[0] ^= 0xff
+	result = validate()
+	if !strings.Contains(result, "stored public keys hash does not match the keys") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQValidateTrustStoreTest: corrupted hash was not detected: %v\n", result)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQValidateTrustStoreTest\n")
+	return nil
+}
+
+// Check that acl changes on the central are replicated to the standby,
+// and that the standby acknowledges the state.
+func checkREQReplicateToTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	// Use the test node as both the central and the standby.
+	p := stewardServer.processInitial
+	p.startup.subREQReplicateTo(p)
+	p.startup.subREQReplicateToAck(p)
+	p.startup.pubREQReplicateTo(p, "central")
+
+	// hasACL checks if the replicated state on the standby have the acl.
+	hasACL := func(node Node, command command) bool {
+		js, err := loadCentralReplica(conf)
+		if err != nil {
+			return false
+		}
+		var st centralAuthState
+		err = json.Unmarshal(js, &st)
+		if err != nil {
+			return false
+		}
+		_, ok := st.ACLMap[node]["admin"][command]
+		return ok
+	}
+
+	converged := func(what string, check func() bool) {
+		for i := 0; i < 50; i++ {
+			if check() {
+				return
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQReplicateToTest: %v\n", what)
+	}
+
+	stewardServer.centralAuth.aclAddCommand("ship500", "admin", "df")
+	converged("first acl change not replicated", func() bool { return hasACL("ship500", "df") })
+
+	stewardServer.centralAuth.aclAddCommand("ship500", "admin", "uptime")
+	converged("second acl change not replicated", func() bool { return hasACL("ship500", "uptime") })
+
+	// The standby should have acknowledged the current state.
+	converged("current state not acknowledged by the standby", func() bool {
+		js, err := stewardServer.centralAuth.exportState()
+		if err != nil {
+			return false
+		}
+		return stewardServer.centralAuth.replication.acked("central") == newReplicationEvent(0, js).Hash
+	})
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQReplicateToTest\n")
+	return nil
+}
+
+// methodBlockingTest is a handler that will block until the release
+// channel is closed, used to keep subscriber handlers in flight.
+type methodBlockingTest struct {
+	event   Event
+	release chan struct{}
+}
+
+func (m methodBlockingTest) getKind() Event {
+	return m.event
+}
+
+func (m methodBlockingTest) isReadOnly() bool {
+	return true
+}
+
+func (m methodBlockingTest) handler(proc process, message Message, node string) ([]byte, error) {
+	<-m.release
+	return []byte("released"), nil
+}
+
+// Check that the handlers in flight for a process are reported while
+// they are running, and that the count goes back to zero when done.
+func checkREQInspectProcessGoroutinesTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	release := make(chan struct{})
+
+	// Start a subscriber with a handler that blocks until released.
+	p := stewardServer.processInitial
+	sub := newSubject(REQTest, "inflight")
+	proc := newProcess(p.ctx, stewardServer, sub, processKindSubscriber, nil)
+	proc.methodsAvailable.Methodhandlers[REQTest] = methodBlockingTest{
+		event:   EventACK,
+		release: release,
+	}
+	go proc.spawnWorker()
+
+	// Give the subscriber time to start.
+	time.Sleep(time.Millisecond * 500)
+
+	for i := 0; i < 3; i++ {
+		m := Message{
+			ToNode:     "inflight",
+			FromNode:   "central",
+			Method:     REQTest,
+			Data:       []byte("block"),
+			ACKTimeout: 20,
+			Retries:    1,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+	}
+
+	pn := processNameGet(sub.name(), processKindSubscriber)
+
+	inFlight := func() int64 {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQInspectProcessGoroutines,
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		var report goroutineReport
+		err = json.Unmarshal(<-stewardServer.errorKernel.testCh, &report)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectProcessGoroutinesTest: failed to unmarshal report: %v\n", err)
+		}
+		if report.Goroutines == 0 {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectProcessGoroutinesTest: no total go routines reported\n")
+		}
+
+		for _, pg := range report.Processes {
+			if pg.Name == pn {
+				return pg.HandlersInFlight
+			}
+		}
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectProcessGoroutinesTest: process %v not in report\n", pn)
+		return 0
+	}
+
+	converged := func(what string, check func(n int64) bool) {
+		var n int64
+		for i := 0; i < 50; i++ {
+			n = inFlight()
+			if check(n) {
+				return
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectProcessGoroutinesTest: %v, got %v\n", what, n)
+	}
+
+	converged("no handlers reported in flight", func(n int64) bool { return n > 0 })
+
+	close(release)
+
+	converged("handlers in flight did not go back to zero", func(n int64) bool { return n == 0 })
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQInspectProcessGoroutinesTest\n")
+	return nil
+}
+
+// Check that the configured default file and directory modes are used
+// by the file handlers, and that the modes of the message take precedence.
+func checkFileModesOnWriteTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	stewardServer.configuration.DefaultFileMode = "0644"
+	stewardServer.configuration.DefaultDirMode = "0755"
+	defer func() {
+		stewardServer.configuration.DefaultFileMode = ""
+		stewardServer.configuration.DefaultDirMode = ""
+	}()
+	defer os.RemoveAll(filepath.Join(conf.SubscribersDataFolder, "filemodetest"))
+
+	checkMode := func(fileName string, fileMode string, wantFile os.FileMode, wantDir os.FileMode) {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQToFile,
+			MethodTimeout: 5,
+			Data:          []byte("some data"),
+			Directory:     "filemodetest",
+			FileName:      fileName,
+			FileMode:      fileMode,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		dir := filepath.Join(conf.SubscribersDataFolder, "filemodetest", "central")
+		file := filepath.Join(dir, fileName)
+		_, err = findStringInFileTest("some data", file, conf, t)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkFileModesOnWriteTest: %v\n", err)
+		}
+
+		fi, err := os.Stat(file)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkFileModesOnWriteTest: %v\n", err)
+		}
+		if fi.Mode().Perm() != wantFile {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkFileModesOnWriteTest: file %v: want mode %o, got %o\n", fileName, wantFile, fi.Mode().Perm())
+		}
+
+		di, err := os.Stat(dir)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkFileModesOnWriteTest: %v\n", err)
+		}
+		if di.Mode().Perm() != wantDir {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkFileModesOnWriteTest: directory: want mode %o, got %o\n", wantDir, di.Mode().Perm())
+		}
+	}
+
+	// The configured defaults should be used.
+	checkMode("default.result", "", 0644, 0755)
+
+	// The mode of the message should take precedence.
+	checkMode("override.result", "0640", 0640, 0755)
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkFileModesOnWriteTest\n")
+	return nil
+}
+
+// Check that the entries of the dead letter store are listed, and
+// filtered on node, method and time.
+func checkREQListFailedMessagesTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	dl := stewardServer.deadLetters
+	start := time.Now()
+
+	dl.add(Message{ToNode: "ship1", Method: REQCliCommand}, 3, "timeout")
+	dl.add(Message{ToNode: "ship1", Method: REQHello}, 3, "timeout")
+	dl.add(Message{ToNode: "ship2", Method: REQCliCommand}, 2, "no responders")
+	time.Sleep(time.Millisecond * 10)
+	middle := time.Now()
+	time.Sleep(time.Millisecond * 10)
+	dl.add(Message{ToNode: "ship2", Method: REQCliCommand}, 1, "timeout")
+
+	list := func(q string) deadLetterList {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQListFailedMessages,
+			MethodArgs:    []string{q},
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		var l deadLetterList
+		err = json.Unmarshal(<-stewardServer.errorKernel.testCh, &l)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQListFailedMessagesTest: failed to unmarshal result: %v\n", err)
+		}
+		return l
+	}
+
+	after, _ := json.Marshal(start)
+
+	l := list(fmt.Sprintf(`{"toNode":"ship1","after":%s}`, after))
+	if l.Total != 2 || len(l.Entries) != 2 || l.ByMethod[REQCliCommand] != 1 || l.ByMethod[REQHello] != 1 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQListFailedMessagesTest: filter on node got: %+v\n", l)
+	}
+
+	l = list(fmt.Sprintf(`{"method":"REQCliCommand","after":%s}`, after))
+	if l.Total != 3 || l.ByNode["ship1"] != 1 || l.ByNode["ship2"] != 2 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQListFailedMessagesTest: filter on method got: %+v\n", l)
+	}
+
+	mid, _ := json.Marshal(middle)
+	l = list(fmt.Sprintf(`{"toNode":"ship2","after":%s}`, mid))
+	if l.Total != 1 || l.Entries[0].Attempts != 1 || l.Entries[0].LastError != "timeout" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQListFailedMessagesTest: filter on time got: %+v\n", l)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQListFailedMessagesTest\n")
+	return nil
+}
+
+// Check that renaming a node migrates the acl's, group memberships,
+// public key and data folders to the new name, and that a rename to a
+// name already in use is refused without changing anything.
+func checkREQChangeNodeNameTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	ca := stewardServer.centralAuth
+
+	ca.aclAddCommand("renameold", "admin", "ls")
+	ca.aclAddCommand("ship600", "renameold", "uptime")
+	ca.groupNodesAddNode("grp_nodes_rename", "renameold")
+	ca.aclAddCommand("renametaken", "admin", "ls")
+
+	ca.pki.nodesAcked.mu.Lock()
+	ca.pki.nodesAcked.keysAndHash.Keys["renameold"] = []byte("renameold-key")
+	ca.pki.nodesAcked.mu.Unlock()
+	err := ca.pki.dbUpdatePublicKey("renameold", []byte("renameold-key"))
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQChangeNodeNameTest: %v\n", err)
+	}
+
+	dataDir := filepath.Join(conf.SubscribersDataFolder, "renametest")
+	os.RemoveAll(dataDir)
+	defer os.RemoveAll(dataDir)
+	err = os.MkdirAll(filepath.Join(dataDir, "renameold"), 0700)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQChangeNodeNameTest: %v\n", err)
+	}
+	err = os.WriteFile(filepath.Join(dataDir, "renameold", "data.result"), []byte("some data"), 0600)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQChangeNodeNameTest: %v\n", err)
+	}
+
+	rename := func(oldName string, newName string) {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQChangeNodeName,
+			MethodArgs:    []string{oldName, newName},
+			MethodTimeout: 5,
+			ACKTimeout:    1,
+			Retries:       1,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+	}
+
+	// stateContains will check if the exported state or the data folder
+	// have any references to the node name.
+	stateContains := func(n Node) []string {
+		found := []string{}
+
+		js, err := ca.exportState()
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQChangeNodeNameTest: %v\n", err)
+		}
+		var st centralAuthState
+		err = json.Unmarshal(js, &st)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQChangeNodeNameTest: %v\n", err)
+		}
+
+		if _, ok := st.ACLMap[n]["admin"]["ls"]; ok {
+			found = append(found, "acl host")
+		}
+		if _, ok := st.ACLMap["ship600"][n]["uptime"]; ok {
+			found = append(found, "acl source")
+		}
+		if _, ok := st.NodeGroupMap["grp_nodes_rename"][n]; ok {
+			found = append(found, "node group")
+		}
+		if _, ok := st.PublicKeys[n]; ok {
+			found = append(found, "public key")
+		}
+		if _, err := os.Stat(filepath.Join(dataDir, string(n), "data.result")); err == nil {
+			found = append(found, "data folder")
+		}
+
+		return found
+	}
+
+	// A rename to a name already in use should be refused.
+	_, err = stewardServer.changeNodeName("renameold", "renametaken")
+	if err == nil || !strings.Contains(err.Error(), "already have acl's as a host") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQChangeNodeNameTest: rename to used name not refused: %v\n", err)
+	}
+	if f := stateContains("renameold"); len(f) != 5 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQChangeNodeNameTest: refused rename changed the state, old name found in: %v\n", f)
+	}
+
+	rename("renameold", "renamenew")
+	result := string(<-stewardServer.errorKernel.testCh)
+	var r nodeRenameReport
+	err = json.Unmarshal([]byte(result), &r)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQChangeNodeNameTest: failed to unmarshal report: %v: %v\n", err, result)
+	}
+
+	if f := stateContains("renamenew"); len(f) != 5 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQChangeNodeNameTest: new name only found in: %v\n", f)
+	}
+	if f := stateContains("renameold"); len(f) != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQChangeNodeNameTest: old name still found in: %v\n", f)
+	}
+
+	ca.pki.nodesAcked.mu.Lock()
+	hash, _ := hashPublicKeys(ca.pki.nodesAcked.keysAndHash.Keys)
+	hashOK := hash == ca.pki.nodesAcked.keysAndHash.Hash
+	ca.pki.nodesAcked.mu.Unlock()
+	if !hashOK {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQChangeNodeNameTest: public keys hash not updated\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQChangeNodeNameTest\n")
+	return nil
+}
+
+// Check that the effective timeouts are resolved from the message, the
+// message defaults and the configuration, in that order, for a message
+// with some of the fields unset.
+func checkREQInspectTimeoutsTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	stewardServer.messageDefaults.set(Message{Retries: 3, ReplyACKTimeout: 4})
+	defer stewardServer.messageDefaults.set(Message{})
+
+	inspect := func(template string) effectiveTimeouts {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQInspectTimeouts,
+			MethodArgs:    []string{template},
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		var e effectiveTimeouts
+		err = json.Unmarshal(<-stewardServer.errorKernel.testCh, &e)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectTimeoutsTest: failed to unmarshal result: %v\n", err)
+		}
+		return e
+	}
+
+	e := inspect(`{"ACKTimeout":2,"replyRetries":2}`)
+
+	want := effectiveTimeouts{
+		ACKTimeout:         timeoutValue{Value: 2, Source: "message"},
+		Retries:            timeoutValue{Value: 3, Source: "messageDefaults"},
+		MethodTimeout:      timeoutValue{Value: conf.DefaultMethodTimeout, Source: "configuration"},
+		ReplyACKTimeout:    timeoutValue{Value: 4, Source: "messageDefaults"},
+		ReplyRetries:       timeoutValue{Value: 2, Source: "message"},
+		ReplyMethodTimeout: timeoutValue{Value: conf.DefaultMethodTimeout, Source: "configuration"},
+
+		DeliveryWorstCase:      2 * 3,
+		ReplyDeliveryWorstCase: 4 * 2,
+		TotalWorstCase:         2*3 + conf.DefaultMethodTimeout + 4*2 + conf.DefaultMethodTimeout,
+	}
+	if e != want {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectTimeoutsTest: want: %+v, got: %+v\n", want, e)
+	}
+
+	// A method timeout of -1 means no timeout, so there is no worst case.
+	e = inspect(`{"methodTimeout":-1}`)
+	if e.MethodTimeout.Value != -1 || e.TotalWorstCase != -1 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectTimeoutsTest: no method timeout got: %+v\n", e)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQInspectTimeoutsTest\n")
+	return nil
+}
+
+// Check that two requests contending for the same lock are run one at a
+// time, and that the lease of a lock expires if it is not renewed.
+func checkREQRunWithLockTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	logFile := filepath.Join(tmpDir, "runwithlock.log")
+	os.Remove(logFile)
+	defer os.Remove(logFile)
+
+	run := func(id string) {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQRunWithLock,
+			MethodArgs:    []string{"migration", "5", "sh", "-c", fmt.Sprintf("echo start-%[1]v >> %[2]v; sleep 1; echo end-%[1]v >> %[2]v", id, logFile)},
+			MethodTimeout: 10,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+	}
+
+	run("a")
+	run("b")
+	<-stewardServer.errorKernel.testCh
+	<-stewardServer.errorKernel.testCh
+
+	b, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: %v\n", err)
+	}
+	lines := strings.Fields(string(b))
+	if len(lines) != 4 || lines[0][len("start-"):] != lines[1][len("end-"):] || lines[2][len("start-"):] != lines[3][len("end-"):] {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: commands were not run one at a time: %v\n", lines)
+	}
+
+	// The lock should be released when the commands are done.
+	if r := stewardServer.distributedLocks.acquire("migration", "test", "ship1", time.Second, false); !r.Granted {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: lock not released: %+v\n", r)
+	}
+	stewardServer.distributedLocks.release("migration", "test", "ship1")
+
+	// A lock not renewed should be given to someone else when the lease
+	// expires, and a renewal after that should not take it back.
+	dl := newDistributedLocks()
+	if r := dl.acquire("lease", "holder1", "ship1", time.Millisecond*100, false); !r.Granted {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: free lock not granted: %+v\n", r)
+	}
+	if r := dl.acquire("lease", "holder2", "ship2", time.Second, false); r.Granted || r.Lease.Node != "ship1" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: held lock granted: %+v\n", r)
+	}
+	time.Sleep(time.Millisecond * 150)
+	if r := dl.acquire("lease", "holder2", "ship2", time.Second, false); !r.Granted {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: expired lock not granted: %+v\n", r)
+	}
+	if r := dl.acquire("lease", "holder1", "ship1", time.Second, true); r.Granted {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: lost lock renewed: %+v\n", r)
+	}
+
+	// The holder is bound to the node holding the lock, so another node
+	// can't renew or release it with the same holder, and the holder is
+	// not told to the nodes refused the lock.
+	if r := dl.acquire("lease", "holder2", "ship1", time.Second, true); r.Granted || r.Lease.Holder != "" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: lock renewed from another node, or holder sent: %+v\n", r)
+	}
+	if r := dl.acquire("lease", "holder2", "ship1", time.Second, false); r.Granted || r.Lease.Holder != "" || r.Lease.Node != "ship2" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: lock taken from another node, or holder sent: %+v\n", r)
+	}
+	if dl.release("lease", "holder2", "ship1") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: lock released from another node\n")
+	}
+	if !dl.release("lease", "holder2", "ship2") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: lock not released by its holder\n")
+	}
+
+	// The command should be killed if the lease is lost, so it is not
+	// running while someone else holds the lock.
+	lostFile := filepath.Join(tmpDir, "runwithlock-lost.log")
+	os.Remove(lostFile)
+	defer os.Remove(lostFile)
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQRunWithLock,
+		MethodArgs:    []string{"stolen", "1", "sh", "-c", fmt.Sprintf("sleep 3; echo done >> %v", lostFile)},
+		MethodTimeout: 10,
+		ReplyMethod:   REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	stolen := false
+	for i := 0; i < 20 && !stolen; i++ {
+		time.Sleep(time.Millisecond * 100)
+		dl := stewardServer.distributedLocks
+		dl.mu.Lock()
+		if _, ok := dl.leases["stolen"]; ok {
+			dl.leases["stolen"] = lockLease{Holder: "thief", Node: "ship2", Expires: time.Now().Add(time.Minute)}
+			stolen = true
+		}
+		dl.mu.Unlock()
+	}
+	if !stolen {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: lock stolen was never granted\n")
+	}
+	defer stewardServer.distributedLocks.release("stolen", "thief", "ship2")
+
+	select {
+	case out := <-stewardServer.errorKernel.testCh:
+		if !strings.Contains(string(out), "was lost, now held by node ship2") {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: want reply with the lease lost, got: %s\n", out)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: no reply when the lease was lost\n")
+	}
+	time.Sleep(time.Second * 3)
+	if _, err := os.Stat(lostFile); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: command kept running after the lease was lost\n")
+	}
+
+	// The command should also be killed if the lease expires without
+	// central granting a renewal, like when central can't be reached.
+	expiredFile := filepath.Join(tmpDir, "runwithlock-expired.log")
+	os.Remove(expiredFile)
+	defer os.Remove(expiredFile)
+
+	ch := make(chan []subjectAndMessage, 10)
+	proc := stewardServer.processInitial
+	proc.toRingbufferCh = ch
+
+	m = Message{
+		ID:            9301,
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQRunWithLock,
+		MethodArgs:    []string{"unreachable", "1", "sh", "-c", fmt.Sprintf("sleep 3; echo done >> %v", expiredFile)},
+		MethodTimeout: 10,
+		ReplyMethod:   REQTest,
+	}
+	if _, err := (methodREQRunWithLock{}).handler(proc, m, "central"); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: %v\n", err)
+	}
+
+	// Grant the first request for the lock, and drop the renewals.
+	timeout := time.After(time.Second * 5)
+	var reply string
+	for reply == "" {
+		select {
+		case sams := <-ch:
+			msg := sams[0].Message
+			switch {
+			case msg.Method == REQLockAcquire && len(msg.MethodArgs) == 3:
+				stewardServer.distributedLocks.deliver(msg.MethodArgs[1], lockResult{Granted: true, Lease: lockLease{Node: "central", Expires: time.Now().Add(time.Second)}})
+			case msg.PreviousMessage != nil && msg.PreviousMessage.ID == 9301:
+				reply = string(msg.Data)
+			}
+		case <-timeout:
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: no reply when the lease expired\n")
+		}
+	}
+	if !strings.Contains(reply, "expired without a renewal granted") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: want reply with the lease expired, got: %v\n", reply)
+	}
+	time.Sleep(time.Second * 3)
+	if _, err := os.Stat(expiredFile); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQRunWithLockTest: command kept running after the lease expired\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQRunWithLockTest\n")
+	return nil
+}
+
+// Check that the audit bundle exported is signed, and that the signature
+// is no longer valid if a byte of the bundle is altered.
+func checkREQExportAuditBundleTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	stewardServer.centralAuth.aclAddCommand("auditship", "admin", "ls")
+
+	defer os.RemoveAll(filepath.Join(conf.SubscribersDataFolder, "auditbundle"))
+
+	ch := make(chan []subjectAndMessage, 10)
+	proc := stewardServer.processInitial
+	proc.toRingbufferCh = ch
+
+	export := func(id int, folder string) {
+		m := Message{ID: id, ToNode: "central", FromNode: "central", Method: REQExportAuditBundle, MethodArgs: []string{folder}, ReplyMethod: REQTest}
+		if _, err := (methodREQExportAuditBundle{}).handler(proc, m, "central"); err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQExportAuditBundleTest: %v\n", err)
+		}
+	}
+
+	// A folder outside of the data folder should be refused.
+	export(1, "../auditbundle")
+	export(2, "auditbundle")
+
+	var r auditBundleResult
+	select {
+	case sams := <-ch:
+		if id := sams[0].Message.PreviousMessage.ID; id != 2 {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQExportAuditBundleTest: want only the bundle within the data folder exported, got reply to message %v: %s\n", id, sams[0].Message.Data)
+		}
+		err := json.Unmarshal(sams[0].Message.Data, &r)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQExportAuditBundleTest: failed to unmarshal result: %v\n", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQExportAuditBundleTest: no reply to the export\n")
+	}
+	select {
+	case sams := <-ch:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQExportAuditBundleTest: want the folder outside the data folder refused, got: %s\n", sams[0].Message.Data)
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	if !strings.HasPrefix(r.Path, filepath.Join(conf.SubscribersDataFolder, "auditbundle")+string(filepath.Separator)) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQExportAuditBundleTest: bundle not written within the data folder: %v\n", r.Path)
+	}
+
+	b, err := os.ReadFile(r.Path)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQExportAuditBundleTest: %v\n", err)
+	}
+	if !strings.Contains(string(b), "auditship") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQExportAuditBundleTest: acl not found in bundle: %v\n", string(b))
+	}
+
+	err = verifyAuditBundle(b, r.Signature, r.PublicKey)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQExportAuditBundleTest: signature not valid: %v\n", err)
+	}
+
+	b[len(b)/2] ^= 0x01
+	err = verifyAuditBundle(b, r.Signature, r.PublicKey)
+	if err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQExportAuditBundleTest: signature valid for altered bundle\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQExportAuditBundleTest\n")
+	return nil
+}
+
+// Check probing a method that is supported and authorized, one that is
+// not supported, and one that is not authorized by the acl.
+func checkREQProbeMethodTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	conf.EnableSignatureCheck = true
+	conf.EnableAclCheck = true
+	defer func() {
+		conf.EnableSignatureCheck = false
+		conf.EnableAclCheck = false
+	}()
+
+	na := stewardServer.nodeAuth.nodeAcl
+	na.mu.Lock()
+	na.aclAndHash.Acl["central"] = map[command]struct{}{"ls -l": {}}
+	na.mu.Unlock()
+	defer func() {
+		na.mu.Lock()
+		delete(na.aclAndHash.Acl, "central")
+		na.mu.Unlock()
+	}()
+
+	probe := func(args ...string) methodProbe {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQProbeMethod,
+			MethodArgs:    args,
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		var mp methodProbe
+		err = json.Unmarshal(<-stewardServer.errorKernel.testCh, &mp)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQProbeMethodTest: failed to unmarshal result: %v\n", err)
+		}
+		return mp
+	}
+
+	mp := probe(string(REQCliCommand), "ls", "-l")
+	if !mp.Supported || !mp.Authorized || !mp.SignatureRequired || mp.Kind != EventACK {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQProbeMethodTest: supported and authorized got: %+v\n", mp)
+	}
+
+	// REQRelay is not started by default.
+	mp = probe(string(REQRelay))
+	if mp.Supported || mp.Authorized || mp.Kind != EventACK {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQProbeMethodTest: not supported got: %+v\n", mp)
+	}
+
+	mp = probe("REQDoesNotExist")
+	if mp.Supported || mp.Authorized || mp.Reason != "no such method" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQProbeMethodTest: no such method got: %+v\n", mp)
+	}
+
+	mp = probe(string(REQCliCommand), "rm", "-rf", "/")
+	if !mp.Supported || mp.Authorized {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQProbeMethodTest: not authorized got: %+v\n", mp)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQProbeMethodTest\n")
+	return nil
+}
+
+// Check that writing the same content twice only writes the file the
+// first time, and that the modification time is kept.
+func checkREQWriteFileIfChangedTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	defer os.RemoveAll(filepath.Join(conf.SubscribersDataFolder, "writeifchanged"))
+
+	write := func(data string) writeIfChangedResult {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQWriteFileIfChanged,
+			MethodTimeout: 5,
+			Data:          []byte(data),
+			Directory:     "writeifchanged",
+			FileName:      "config.conf",
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		var r writeIfChangedResult
+		err = json.Unmarshal(<-stewardServer.errorKernel.testCh, &r)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQWriteFileIfChangedTest: failed to unmarshal result: %v\n", err)
+		}
+		return r
+	}
+
+	r := write("setting=1\n")
+	if !r.Written {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQWriteFileIfChangedTest: new file not written: %+v\n", r)
+	}
+
+	// Set the modification time back, so we can see if the file is
+	// written again.
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	err := os.Chtimes(r.File, mtime, mtime)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQWriteFileIfChangedTest: %v\n", err)
+	}
+
+	r = write("setting=1\n")
+	if r.Written {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQWriteFileIfChangedTest: file with same content written: %+v\n", r)
+	}
+	fi, err := os.Stat(r.File)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQWriteFileIfChangedTest: %v\n", err)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQWriteFileIfChangedTest: want mtime %v, got %v\n", mtime, fi.ModTime())
+	}
+
+	r = write("setting=2\n")
+	if !r.Written {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQWriteFileIfChangedTest: changed file not written: %+v\n", r)
+	}
+	b, err := os.ReadFile(r.File)
+	if err != nil || string(b) != "setting=2\n" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQWriteFileIfChangedTest: want new content, got: %q, %v\n", b, err)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQWriteFileIfChangedTest\n")
+	return nil
+}
+
+// Check that the nodes added to the public keys are reported as trusted
+// signers with the fingerprint of their key.
+func checkREQInspectAllowedSignaturesTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	keys := map[Node][]byte{
+		"signer1": []byte("signer1-public-key-0123456789abc"),
+		"signer2": []byte("signer2-public-key-0123456789abc"),
+	}
+
+	pk := stewardServer.nodeAuth.publicKeys
+	pk.mu.Lock()
+	for n, k := range keys {
+		pk.keysAndHash.Keys[n] = k
+	}
+	pk.mu.Unlock()
+	defer func() {
+		pk.mu.Lock()
+		for n := range keys {
+			delete(pk.keysAndHash.Keys, n)
+		}
+		pk.mu.Unlock()
+	}()
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQInspectAllowedSignatures,
+		MethodTimeout: 5,
+		ReplyMethod:   REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	var a allowedSignatures
+	err = json.Unmarshal(<-stewardServer.errorKernel.testCh, &a)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectAllowedSignaturesTest: failed to unmarshal result: %v\n", err)
+	}
+
+	found := map[Node]string{}
+	for _, s := range a.Signers {
+		found[s.Node] = s.Fingerprint
+	}
+	for n, k := range keys {
+		fp := sha256.Sum256(k)
+		if found[n] != hex.EncodeToString(fp[:]) {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectAllowedSignaturesTest: want fingerprint %x for %v, got: %+v\n", fp, n, a)
+		}
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQInspectAllowedSignaturesTest\n")
+	return nil
+}
+
+// Check that an interactive script can be given input in a stream
+// session, and that a closed session kills the command.
+func checkREQStreamCommandTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	send := func(method Method, methodArgs ...string) {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        method,
+			MethodArgs:    methodArgs,
+			MethodTimeout: 10,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+	}
+
+	// Read the output of the session until want is found.
+	readUntil := func(want string) {
+		var out string
+		for !strings.Contains(out, want) {
+			select {
+			case b := <-stewardServer.errorKernel.testCh:
+				out += string(b)
+			case <-time.After(time.Second * 5):
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkREQStreamCommandTest: want %q, got: %q\n", want, out)
+			}
+		}
+	}
+
+	send(REQStreamCommand, "session1", "sh", "-c", `printf "name? "; read n; echo "hello $n"`)
+	readUntil("name? ")
+	send(REQStreamCommandInput, "session1", "world")
+	readUntil("hello world")
+
+	// Wait until the session is registered or removed.
+	waitSession := func(id string, running bool) {
+		for i := 0; ; i++ {
+			_, err := stewardServer.streamSessions.get(id, "central")
+			if (err == nil) == running {
+				return
+			}
+			if i > 50 {
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkREQStreamCommandTest: session %v, want running=%v\n", id, running)
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+	}
+
+	// A command that never exits should be gone when the session is closed.
+	send(REQStreamCommand, "session2", "cat")
+	waitSession("session2", true)
+	send(REQStreamCommandInput, "session2", "ping")
+	readUntil("ping")
+	send(REQStreamCommandClose, "session2")
+	waitSession("session2", false)
+
+	// Only the node that started a session can use it.
+	ss := &streamSession{fromNode: "ship1", cancel: func() {}}
+	stewardServer.streamSessions.add("session3", ss)
+	defer stewardServer.streamSessions.remove("session3")
+	if _, err := stewardServer.streamSessions.get("session3", "ship2"); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQStreamCommandTest: session given to a node not owning it\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQStreamCommandTest\n")
+	return nil
+}
+
+// Check that only the old reply files are compressed, and that a
+// compressed file is decompressed when copied with the original name.
+func checkREQCompressStoredRepliesTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	folder := filepath.Join(conf.SubscribersDataFolder, "compresstest", "ship1")
+	err := os.MkdirAll(folder, 0700)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: failed to create folder: %v\n", err)
+	}
+	dstFile := filepath.Join(tmpDir, "compresstest.copy")
+	defer func() {
+		os.RemoveAll(filepath.Join(conf.SubscribersDataFolder, "compresstest"))
+		os.Remove(dstFile)
+		stewardServer.dataIndex.reindex()
+	}()
+
+	content := []byte(strings.Repeat("some reply data that compress well\n", 100))
+	oldFile := filepath.Join(folder, "old.result")
+	newFile := filepath.Join(folder, "new.result")
+	for _, fp := range []string{oldFile, newFile} {
+		err := os.WriteFile(fp, content, 0600)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: failed to write file: %v\n", err)
+		}
+	}
+	old := time.Now().Add(-time.Hour * 48)
+	os.Chtimes(oldFile, old, old)
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQCompressStoredReplies,
+		MethodArgs:    []string{"24h"},
+		MethodTimeout: 5,
+		ReplyMethod:   REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	var r compressionReport
+	err = json.Unmarshal(<-stewardServer.errorKernel.testCh, &r)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCompressStoredRepliesTest: failed to unmarshal result: %v\n", err)
+	}
+
+	want := filepath.Join("compresstest", "ship1", "old.result")
+	if len(r.Compressed) != 1 || r.Compressed[0] != want || r.BytesSaved <= 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCompressStoredRepliesTest: want only %v compressed with bytes saved, got: %+v\n", want, r)
+	}
+	if _, err := os.Stat(oldFile + ".gz"); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCompressStoredRepliesTest: compressed file not found: %v\n", err)
+	}
+	if _, err := os.Stat(newFile); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCompressStoredRepliesTest: new file should not be compressed: %v\n", err)
+	}
+
+	// Copying the file with the original name should give the
+	// decompressed content.
+	m = Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQCopyFileFrom,
+		MethodArgs:    []string{oldFile, "central", dstFile},
+		MethodTimeout: 5,
+	}
+	sam, err = newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	for i := 0; ; i++ {
+		b, err := os.ReadFile(dstFile)
+		if err == nil && bytes.Equal(b, content) {
+			break
+		}
+		if i > 50 {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCompressStoredRepliesTest: copied file not decompressed: %v, %q\n", err, b)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQCompressStoredRepliesTest\n")
+	return nil
+}
+
+// methodDropPingsTest is a ping handler that will not reply to the
+// pings from the node given, to simulate a partition between two nodes.
+type methodDropPingsTest struct {
+	event Event
+	from  Node
+}
+
+func (m methodDropPingsTest) getKind() Event {
+	return m.event
+}
+
+func (m methodDropPingsTest) isReadOnly() bool {
+	return true
+}
+
+func (m methodDropPingsTest) handler(proc process, message Message, node string) ([]byte, error) {
+	if message.FromNode != m.from {
+		newReplyMessage(proc, message, nil)
+	}
+	return []byte("ok"), nil
+}
+
+// Check that the reachability matrix of three nodes shows the pair of
+// nodes that can not reach each other.
+func checkREQValidateReachabilityTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	// Run the subscribers of two more nodes on the test node, where
+	// reach2 will not reply to pings from reach1.
+	p := stewardServer.processInitial
+	for _, n := range []string{"reach1", "reach2"} {
+		for _, method := range []Method{REQReachabilityProbe, REQReachabilityPing, REQReachabilityResult} {
+			proc := newProcess(p.ctx, stewardServer, newSubject(method, n), processKindSubscriber, nil)
+			if n == "reach2" && method == REQReachabilityPing {
+				proc.methodsAvailable.Methodhandlers[REQReachabilityPing] = methodDropPingsTest{
+					event: EventACK,
+					from:  "reach1",
+				}
+			}
+			go proc.spawnWorker()
+		}
+	}
+
+	// Give the subscribers time to start.
+	time.Sleep(time.Millisecond * 500)
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQValidateReachability,
+		MethodArgs:    []string{"central", "reach1", "reach2"},
+		MethodTimeout: 4,
+		ReplyMethod:   REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	var mx reachabilityMatrix
+	err = json.Unmarshal(<-stewardServer.errorKernel.testCh, &mx)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQValidateReachabilityTest: failed to unmarshal result: %v\n", err)
+	}
+
+	if len(mx.Unresponsive) != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQValidateReachabilityTest: want no unresponsive nodes, got: %+v\n", mx)
+	}
+	if len(mx.Unreachable) != 1 || mx.Unreachable[0] != [2]Node{"reach1", "reach2"} {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQValidateReachabilityTest: want only reach1 -> reach2 unreachable, got: %+v\n", mx)
+	}
+	if !mx.Matrix["reach2"]["reach1"] || !mx.Matrix["central"]["reach2"] {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQValidateReachabilityTest: reachable pairs missing in matrix: %+v\n", mx)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQValidateReachabilityTest\n")
+	return nil
+}
+
+// Check that the priority policy can be set, and that a message in the
+// high tier is dispatched ahead of the messages already queued in lower
+// tiers.
+func checkREQSetPriorityPolicyTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	defer stewardServer.priorityPolicy.set(defaultPriorityPolicy())
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQSetPriorityPolicy,
+		MethodArgs:    []string{`{"REQCliCommand":"high","REQToFileAppend":"low"}`},
+		MethodTimeout: 5,
+		ReplyMethod:   REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	tiers := map[Method]Priority{}
+	err = json.Unmarshal(<-stewardServer.errorKernel.testCh, &tiers)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSetPriorityPolicyTest: failed to unmarshal result: %v\n", err)
+	}
+	if len(tiers) != 2 || tiers[REQCliCommand] != PriorityHigh {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSetPriorityPolicyTest: policy not set: %v\n", tiers)
+	}
+
+	pp := stewardServer.priorityPolicy
+	if p := pp.resolve(Message{Method: REQToFileAppend, Priority: PriorityHigh}); p != PriorityHigh {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSetPriorityPolicyTest: priority of message not used, got: %v\n", p)
+	}
+	if p := pp.resolve(Message{Method: REQHello}); p != PriorityNormal {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSetPriorityPolicyTest: want normal for method not in policy, got: %v\n", p)
+	}
+
+	// Queue two low tier messages and a normal one, and then a high tier
+	// message that should be dispatched first.
+	q := newPriorityQueue(10, 0)
+	ctx := context.Background()
+	for i, method := range []Method{REQToFileAppend, REQToFileAppend, REQHello, REQCliCommand} {
+		v := samDBValue{ID: i, Data: subjectAndMessage{Message: Message{Method: method}}}
+		q.push(ctx, v, pp.resolve(v.Data.Message))
+	}
+
+	order := []int{}
+	for i := 0; i < 4; i++ {
+		v, _ := q.pop(ctx)
+		order = append(order, v.ID)
+	}
+	if fmt.Sprint(order) != "[3 2 0 1]" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSetPriorityPolicyTest: want dispatch order [3 2 0 1], got: %v\n", order)
+	}
+
+	// A low tier message that has waited more than one aging interval
+	// should be dispatched ahead of a newer normal tier message.
+	now := time.Now()
+	q = newPriorityQueue(10, time.Second*10)
+	q.now = func() time.Time { return now }
+	q.push(ctx, samDBValue{ID: 0}, PriorityLow)
+	now = now.Add(time.Second * 15)
+	q.push(ctx, samDBValue{ID: 1}, PriorityHigh)
+	q.push(ctx, samDBValue{ID: 2}, PriorityNormal)
+
+	order = []int{}
+	for i := 0; i < 3; i++ {
+		v, _ := q.pop(ctx)
+		order = append(order, v.ID)
+	}
+	if fmt.Sprint(order) != "[1 0 2]" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSetPriorityPolicyTest: want aged dispatch order [1 0 2], got: %v\n", order)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQSetPriorityPolicyTest\n")
+	return nil
+}
+
+// Check that a keypair generated for a node have the public key
+// allowed, and that the private key replied can be decrypted by the
+// operator and matches the public key.
+func checkREQGenerateKeypairForTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	ca := stewardServer.centralAuth
+
+	// The operator sending the request must have an allowed key to
+	// encrypt the private key with.
+	ca.pki.nodesAcked.mu.Lock()
+	ca.pki.nodesAcked.keysAndHash.Keys["central"] = stewardServer.nodeAuth.SignPublicKey
+	ca.pki.nodesAcked.mu.Unlock()
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQGenerateKeypairFor,
+		MethodArgs:    []string{"keygen1"},
+		MethodTimeout: 5,
+		ACKTimeout:    1,
+		Retries:       1,
+		ReplyMethod:   REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	result := <-stewardServer.errorKernel.testCh
+	var kp provisionedKeypair
+	err = json.Unmarshal(result, &kp)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQGenerateKeypairForTest: failed to unmarshal result: %v: %s\n", err, result)
+	}
+
+	ca.pki.nodesAcked.mu.Lock()
+	allowed := ca.pki.nodesAcked.keysAndHash.Keys["keygen1"]
+	hash, _ := hashPublicKeys(ca.pki.nodesAcked.keysAndHash.Keys)
+	hashOK := hash == ca.pki.nodesAcked.keysAndHash.Hash
+	ca.pki.nodesAcked.mu.Unlock()
+	if !bytes.Equal(allowed, kp.PublicKey) || len(allowed) != ed25519.PublicKeySize {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQGenerateKeypairForTest: public key not allowed, got: %v\n", allowed)
+	}
+	if !hashOK {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQGenerateKeypairForTest: public keys hash not updated\n")
+	}
+
+	priv, err := openProvisionedKey(kp.EncryptedPrivateKey, stewardServer.nodeAuth.SignPrivateKey)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQGenerateKeypairForTest: %v\n", err)
+	}
+	if !bytes.Equal(priv.Public().(ed25519.PublicKey), kp.PublicKey) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQGenerateKeypairForTest: private key does not match the public key\n")
+	}
+	sig := ed25519.Sign(priv, []byte("keygen1"))
+	if !ed25519.Verify(kp.PublicKey, []byte("keygen1"), sig) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQGenerateKeypairForTest: signature made with the private key not verified\n")
+	}
+
+	// The private key should only be possible to open for the operator.
+	_, other, _ := ed25519.GenerateKey(nil)
+	if _, err := openProvisionedKey(kp.EncryptedPrivateKey, other); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQGenerateKeypairForTest: private key opened with another key\n")
+	}
+
+	// Call the handler directly and catch the replies, so we can check
+	// that no keypair is replied for the requests refused.
+	ch := make(chan []subjectAndMessage, 10)
+	proc := stewardServer.processInitial
+	proc.toRingbufferCh = ch
+
+	generate := func(n string) {
+		m := Message{ID: 9401, ToNode: "central", FromNode: "central", Method: REQGenerateKeypairFor, MethodArgs: []string{n}, ReplyMethod: REQTest}
+		if _, err := (methodREQGenerateKeypairFor{}).handler(proc, m, "central"); err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQGenerateKeypairForTest: %v\n", err)
+		}
+	}
+	replies := func() int {
+		n := 0
+		for {
+			select {
+			case sams := <-ch:
+				if sams[0].Message.PreviousMessage != nil && sams[0].Message.PreviousMessage.ID == 9401 {
+					n++
+				}
+			case <-time.After(time.Second * 2):
+				return n
+			}
+		}
+	}
+
+	// An invalid node name, or a node that already have a key, should
+	// be refused.
+	generate("bad/name")
+	generate("keygen1")
+	if n := replies(); n != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQGenerateKeypairForTest: want invalid name and existing key refused, got %v replies\n", n)
+	}
+	ca.pki.nodesAcked.mu.Lock()
+	_, badAdded := ca.pki.nodesAcked.keysAndHash.Keys["bad/name"]
+	keygen1 := ca.pki.nodesAcked.keysAndHash.Keys["keygen1"]
+	ca.pki.nodesAcked.mu.Unlock()
+	if badAdded || !bytes.Equal(keygen1, kp.PublicKey) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQGenerateKeypairForTest: key added for invalid name, or existing key replaced\n")
+	}
+
+	// Only one of two requests for the same node at the same time should
+	// get a keypair.
+	generate("keygen2")
+	generate("keygen2")
+	if n := replies(); n != 1 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQGenerateKeypairForTest: want 1 keypair for two requests for the same node, got %v\n", n)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQGenerateKeypairForTest\n")
+	return nil
+}
+
+// Check that a message retrying against a node that is not running is
+// reported with its attempts, next retry time and last error.
+func checkREQInspectRetryStateTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	m := Message{
+		ToNode:     "retryunreachable",
+		FromNode:   "central",
+		Method:     REQCliCommand,
+		MethodArgs: []string{"bash", "-c", "true"},
+		ACKTimeout: 1,
+		Retries:    5,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	inspect := func() []retryState {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQInspectRetryState,
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		var states []retryState
+		err = json.Unmarshal(<-stewardServer.errorKernel.testCh, &states)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectRetryStateTest: failed to unmarshal result: %v\n", err)
+		}
+		return states
+	}
+
+	var found *retryState
+	for i := 0; i < 20 && found == nil; i++ {
+		for _, s := range inspect() {
+			if s.ToNode == "retryunreachable" && s.Attempts >= 1 {
+				s := s
+				found = &s
+			}
+		}
+		if found == nil {
+			time.Sleep(time.Millisecond * 200)
+		}
+	}
+
+	switch {
+	case found == nil:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectRetryStateTest: no retry state with failed attempts found for the message\n")
+	case found.Method != REQCliCommand || found.Retries != 5:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectRetryStateTest: wrong message in retry state: %+v\n", *found)
+	case found.LastError == "" || found.NextRetry.IsZero() || found.NextRetry.Before(found.Started):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQInspectRetryStateTest: missing error or next retry time: %+v\n", *found)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQInspectRetryStateTest\n")
+	return nil
+}
+
+// Check that a file template is rendered with the variables given and
+// replied back, and that missing variables are reported as errors.
+func checkREQFileTemplateRenderPreviewTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	preview := func(tmpl string, vars string) fileTemplatePreview {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQFileTemplateRenderPreview,
+			MethodArgs:    []string{tmpl, vars},
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		var p fileTemplatePreview
+		err = json.Unmarshal(<-stewardServer.errorKernel.testCh, &p)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQFileTemplateRenderPreviewTest: failed to unmarshal result: %v\n", err)
+		}
+		return p
+	}
+
+	p := preview(`listen {{.Vars.port}} on {{.Node}} as {{upper .Vars.user}}`, `{"port":"8080","user":"admin"}`)
+	if p.Error != "" || p.Rendered != "listen 8080 on central as ADMIN" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQFileTemplateRenderPreviewTest: wrong rendered output: %+v\n", p)
+	}
+
+	p = preview(`listen {{.Vars.port}}`, `{}`)
+	if p.Error == "" || p.Rendered != "" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQFileTemplateRenderPreviewTest: missing variable not reported: %+v\n", p)
+	}
+
+	p = preview(`{{exec "ls"}}`, ``)
+	if !strings.Contains(p.Error, "failed to parse template") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQFileTemplateRenderPreviewTest: unknown function not reported: %+v\n", p)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQFileTemplateRenderPreviewTest\n")
+	return nil
+}
+
+// Check that the active stream sessions are listed with their details,
+// and that a session closed is no longer listed.
+func checkREQListActiveSessionsTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	send := func(method Method, methodArgs ...string) {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        method,
+			MethodArgs:    methodArgs,
+			MethodTimeout: 10,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+	}
+
+	// list will ask for the sessions, and skip any output from the
+	// sessions received while waiting for the reply.
+	list := func() map[string]sessionInfo {
+		send(REQListActiveSessions)
+
+		for {
+			select {
+			case b := <-stewardServer.errorKernel.testCh:
+				var infos []sessionInfo
+				if err := json.Unmarshal(b, &infos); err != nil {
+					continue
+				}
+				sessions := make(map[string]sessionInfo)
+				for _, si := range infos {
+					sessions[si.ID] = si
+				}
+				return sessions
+			case <-time.After(time.Second * 5):
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkREQListActiveSessionsTest: no reply with the sessions\n")
+			}
+		}
+	}
+
+	// waitSessions will list the sessions until the check is true.
+	waitSessions := func(check func(map[string]sessionInfo) bool) map[string]sessionInfo {
+		var sessions map[string]sessionInfo
+		for i := 0; i < 50; i++ {
+			sessions = list()
+			if check(sessions) {
+				return sessions
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQListActiveSessionsTest: wrong sessions listed: %+v\n", sessions)
+		return nil
+	}
+
+	start := time.Now()
+	send(REQStreamCommand, "listsession1", "cat")
+	send(REQStreamCommand, "listsession2", "cat")
+
+	sessions := waitSessions(func(s map[string]sessionInfo) bool {
+		_, ok1 := s["listsession1"]
+		_, ok2 := s["listsession2"]
+		return ok1 && ok2
+	})
+	for _, id := range []string{"listsession1", "listsession2"} {
+		si := sessions[id]
+		if si.Kind != sessionKindStreamCommand || si.FromNode != "central" || si.Method != REQStreamCommand || si.Started.Before(start.Add(-time.Second)) {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQListActiveSessionsTest: wrong details for session: %+v\n", si)
+		}
+	}
+
+	send(REQStreamCommandClose, "listsession1")
+	waitSessions(func(s map[string]sessionInfo) bool {
+		_, ok1 := s["listsession1"]
+		_, ok2 := s["listsession2"]
+		return !ok1 && ok2
+	})
+
+	send(REQStreamCommandClose, "listsession2")
+	waitSessions(func(s map[string]sessionInfo) bool {
+		_, ok := s["listsession2"]
+		return !ok
+	})
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQListActiveSessionsTest\n")
+	return nil
+}
+
+// Check that a directory is copied recursively with the relative paths,
+// file modes and empty directories kept, that files bigger than one
+// chunk are copied, and that symlinks are skipped.
+func checkREQCopyDirFromTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	srcDir := filepath.Join(tmpDir, "copydirsrc")
+	dstDir := filepath.Join(tmpDir, "copydirdst")
+	defer os.RemoveAll(srcDir)
+	defer os.RemoveAll(dstDir)
+
+	big := []byte(strings.Repeat("0123456789", copyDirChunkSize/4))
+	files := map[string][]byte{
+		"top.txt":          []byte("top"),
+		"sub/big.bin":      big,
+		"sub/deep/empty.t": {},
+	}
+	for name, content := range files {
+		fp := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyDirFromTest: %v\n", err)
+		}
+		if err := os.WriteFile(fp, content, 0640); err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyDirFromTest: %v\n", err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(srcDir, "top.txt"), 0600); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyDirFromTest: %v\n", err)
+	}
+	if err := os.MkdirAll(filepath.Join(srcDir, "emptydir"), 0750); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyDirFromTest: %v\n", err)
+	}
+	if err := os.Symlink(filepath.Join(srcDir, "top.txt"), filepath.Join(srcDir, "link.txt")); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyDirFromTest: %v\n", err)
+	}
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQCopyDirFrom,
+		MethodArgs:    []string{srcDir, "central", dstDir},
+		MethodTimeout: 10,
+		ReplyMethod:   REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	var r copyDirReport
+	err = json.Unmarshal(<-stewardServer.errorKernel.testCh, &r)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyDirFromTest: failed to unmarshal report: %v\n", err)
+	}
+	wantBytes := int64(len(big) + len("top"))
+	if r.Files != 3 || r.Directories != 4 || r.Bytes != wantBytes || len(r.SkippedSymlinks) != 1 || r.SkippedSymlinks[0] != "link.txt" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyDirFromTest: wrong report: %+v\n", r)
+	}
+
+	// Wait for all the entries to be written on the destination.
+	copied := func() bool {
+		for name, content := range files {
+			b, err := os.ReadFile(filepath.Join(dstDir, name))
+			if err != nil || !bytes.Equal(b, content) {
+				return false
+			}
+		}
+		_, err := os.Stat(filepath.Join(dstDir, "emptydir"))
+		return err == nil
+	}
+	for i := 0; !copied(); i++ {
+		if i > 50 {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyDirFromTest: directory not copied\n")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	modes := map[string]os.FileMode{
+		"top.txt":     0600,
+		"sub/big.bin": 0640,
+		"emptydir":    0750,
+	}
+	for name, mode := range modes {
+		fi, err := os.Stat(filepath.Join(dstDir, name))
+		if err != nil || fi.Mode().Perm() != mode {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyDirFromTest: want mode %v for %v, got: %v, %v\n", mode, name, fi, err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dstDir, "link.txt")); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyDirFromTest: symlink was copied\n")
+	}
+
+	// The walk should be aborted when the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = walkCopyDir(ctx, srcDir, dstDir, func(copyDirEntry) error { return nil })
+	if err != context.Canceled {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyDirFromTest: want walk aborted, got: %v\n", err)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQCopyDirFromTest\n")
+	return nil
+}
+
+// Check that the payloads are the same after compressing and
+// decompressing for all the compressions, and that messages with the
+// compression set in the message are delivered.
+func checkMessageCompressionTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	big := make([]byte, 3*1024*1024)
+	_, err := rand.Read(big)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCompressionTest: %v\n", err)
+	}
+
+	for _, c := range []string{"zstd", "gzip", "none"} {
+		cmp, err := selectCompression(c, "")
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCompressionTest: %v\n", err)
+		}
+
+		for _, data := range [][]byte{{}, big} {
+			compressed, err := compressPayload(data, cmp, nil)
+			if err != nil {
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCompressionTest: %v: compress: %v\n", c, err)
+			}
+			decompressed, err := decompressPayload(compressed, cmp)
+			if err != nil {
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCompressionTest: %v: decompress: %v\n", c, err)
+			}
+			if !bytes.Equal(data, decompressed) {
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCompressionTest: %v: payload of %v bytes changed after round-trip\n", c, len(data))
+			}
+		}
+	}
+
+	// The compression of the message takes precedence over the node.
+	if cmp, _ := selectCompression("none", "z"); cmp != compressionHeaderNone {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCompressionTest: compression of message not used, got: %v\n", cmp)
+	}
+	if cmp, _ := selectCompression("", "g"); cmp != compressionHeaderGzip {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCompressionTest: compression of node not used, got: %v\n", cmp)
+	}
+	if _, err := selectCompression("lz4", ""); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCompressionTest: unknown compression not reported\n")
+	}
+	if _, err := decompressPayload([]byte("data"), "lz4"); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCompressionTest: unknown compression header not reported\n")
+	}
+
+	// Send messages through nats, where a message with an unknown
+	// compression is sent uncompressed. The multi-megabyte payload must
+	// be compressible to fit within the max message size of nats.
+	compressible := []byte(strings.Repeat("some cli output that compress well\n", 100000))
+	for _, c := range []string{"zstd", "gzip", "lz4"} {
+		for _, data := range [][]byte{{}, compressible} {
+			if c == "lz4" && len(data) > 0 {
+				continue
+			}
+
+			m := Message{
+				ToNode:      "central",
+				FromNode:    "central",
+				Method:      REQTest,
+				Data:        data,
+				Compression: c,
+			}
+			sam, err := newSubjectAndMessage(m)
+			if err != nil {
+				t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+			}
+			stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+			select {
+			case b := <-stewardServer.errorKernel.testCh:
+				if !bytes.Equal(b, data) {
+					t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCompressionTest: %v: got %v bytes, want %v bytes\n", c, len(b), len(data))
+				}
+			case <-time.After(time.Second * 10):
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCompressionTest: %v: message of %v bytes not delivered\n", c, len(data))
+			}
+		}
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkMessageCompressionTest\n")
+	return nil
+}
+
+// Check that the valid messages given to SubmitMessages are delivered,
+// and that the messages not valid are reported in the error returned.
+func checkSubmitMessagesTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	msgs := []Message{
+		{ToNode: "central", Method: REQTest, Data: []byte("submit1")},
+		{Method: REQTest, Data: []byte("no node")},
+		{ToNode: "central", Method: "REQNoSuchMethod"},
+		{ToNodes: []Node{"central"}, Method: REQTest, Data: []byte("submit2")},
+	}
+
+	// Read the data received in the background, since the messages are
+	// delivered while SubmitMessages is running.
+	gotCh := make(chan []string)
+	go func() {
+		got := []string{}
+		for len(got) < 2 {
+			select {
+			case b := <-stewardServer.errorKernel.testCh:
+				got = append(got, string(b))
+			case <-time.After(time.Second * 5):
+				gotCh <- got
+				return
+			}
+		}
+		gotCh <- got
+	}()
+
+	err := stewardServer.SubmitMessages(msgs)
+	switch {
+	case err == nil:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSubmitMessagesTest: want error for the messages not valid\n")
+	case !strings.Contains(err.Error(), "message 1:") || !strings.Contains(err.Error(), "message 2 to central:"):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSubmitMessagesTest: wrong messages reported: %v\n", err)
+	case strings.Contains(err.Error(), "message 0") || strings.Contains(err.Error(), "message 3"):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSubmitMessagesTest: valid messages reported: %v\n", err)
+	}
+
+	got := <-gotCh
+	sort.Strings(got)
+	if len(got) != 2 || got[0] != "submit1" || got[1] != "submit2" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSubmitMessagesTest: want the valid messages delivered, got: %v\n", got)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkSubmitMessagesTest\n")
+	return nil
+}
+
+// Check that a process restarted with REQProcessRestart is replaced in
+// the processes map with a new id, and that the new process is handling
+// messages for the subject.
+func checkREQProcessRestartTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	sub := newSubject(REQTest, "central")
+	pn := processNameGet(sub.name(), processKindSubscriber)
+
+	stewardServer.processes.active.mu.Lock()
+	oldID := stewardServer.processes.active.procNames[pn].processID
+	stewardServer.processes.active.mu.Unlock()
+
+	// The reply is sent to the REQTest subscriber being restarted, so
+	// receiving it shows that the new process is subscribing.
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQProcessRestart,
+		MethodArgs:    []string{string(sub.name())},
+		ReplyMethod:   REQTest,
+		MethodTimeout: 5,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	var reply processRestartReply
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		err := json.Unmarshal(b, &reply)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQProcessRestartTest: failed to unmarshal reply: %v, %s\n", err, b)
+		}
+	case <-time.After(time.Second * 15):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQProcessRestartTest: no reply received\n")
+	}
+
+	stewardServer.processes.active.mu.Lock()
+	newProc, ok := stewardServer.processes.active.procNames[pn]
+	stewardServer.processes.active.mu.Unlock()
+
+	switch {
+	case reply.Name != pn || reply.OldID != oldID:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQProcessRestartTest: wrong process restarted: %+v\n", reply)
+	case !ok || newProc.processID != reply.NewID || reply.NewID == oldID:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQProcessRestartTest: process not replaced in map, reply: %+v\n", reply)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQProcessRestartTest\n")
+	return nil
+}
+
+// Check that the maxConcurrent configuration is parsed, and that the
+// slots above the limit of a method are waited for.
+func checkConcurrencyLimitsTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	for _, v := range []string{"REQCliCommand", "REQCliCommand=0", "REQNoSuchMethod=1", "REQCliCommand=x"} {
+		if _, err := newConcurrencyLimits(v); err == nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkConcurrencyLimitsTest: want error for %q\n", v)
+		}
+	}
+
+	c, err := newConcurrencyLimits("REQCliCommand=2, REQHttpGet=1")
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkConcurrencyLimitsTest: %v\n", err)
+	}
+
+	done := make(chan struct{})
+
+	release1, _ := c.acquire(REQCliCommand, done)
+	_, _ = c.acquire(REQCliCommand, done)
+
+	// The third slot must wait until one of the others are released.
+	acquired := make(chan struct{})
+	go func() {
+		if _, ok := c.acquire(REQCliCommand, done); ok {
+			close(acquired)
+		}
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkConcurrencyLimitsTest: got slot above the limit\n")
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	release1()
+	release1()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second * 2):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkConcurrencyLimitsTest: did not get slot after release\n")
+	}
+
+	// Methods without a limit always get a slot, and waiting is aborted
+	// when done is closed.
+	for i := 0; i < 10; i++ {
+		if _, ok := c.acquire(REQTest, done); !ok {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkConcurrencyLimitsTest: unlimited method did not get slot\n")
+		}
+	}
+	close(done)
+	if _, ok := c.acquire(REQCliCommand, done); ok {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkConcurrencyLimitsTest: got slot above the limit after done\n")
+	}
+
+	// A handler doing its work in the background, like REQHttpGet, holds
+	// the slot until the work is done, and not only until the handler
+	// returns.
+	release := make(chan struct{})
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, "ok")
+	}))
+	defer httpSrv.Close()
+
+	proc := stewardServer.processInitial
+	proc.handlerWork = &sync.WaitGroup{}
+	proc.toRingbufferCh = make(chan []subjectAndMessage, 10)
+	m := Message{ID: 1, ToNode: "central", FromNode: "central", Method: REQHttpGet, MethodArgs: []string{httpSrv.URL}, MethodTimeout: 5, ReplyMethod: REQTest}
+	if _, err := (methodREQHttpGet{}).handler(proc, m, "central"); err != nil {
+		close(release)
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkConcurrencyLimitsTest: %v\n", err)
+	}
+
+	workDone := make(chan struct{})
+	go func() {
+		proc.handlerWork.Wait()
+		close(workDone)
+	}()
+
+	select {
+	case <-workDone:
+		close(release)
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkConcurrencyLimitsTest: slot released before the http get was done\n")
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	close(release)
+	select {
+	case <-workDone:
+	case <-time.After(time.Second * 5):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkConcurrencyLimitsTest: slot not released when the http get was done\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkConcurrencyLimitsTest\n")
+	return nil
+}
+
+// Check that signatures are required for the methods configured, and
+// that a message where the method, toNode, data, file or reply is changed
+// after signing is not verified.
+func checkVerifySignatureTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: failed to generate keys: %v\n", err)
+	}
+
+	na := nodeAuth{
+		publicKeys:       &publicKeys{keysAndHash: newKeysAndHash()},
+		signatureMethods: newSignatureMethods(""),
+		nonceCache:       newNonceCache(nonceCacheMaxEntries),
+		configuration:    &Configuration{SignatureMaxSkew: 300},
+		errorKernel:      stewardServer.errorKernel,
+	}
+	na.publicKeys.keysAndHash.Keys["signer"] = pub
+
+	if !na.signatureRequired(REQToFile) || !na.signatureRequired(REQOpProcessStop) || na.signatureRequired(REQOpProcessList) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: default methods should be the ones changing state\n")
+	}
+	for _, rm := range []Method{REQCopyFileFrom, REQCopyDirFrom, REQBulkFileFetch, REQTailFile, REQHttpGet} {
+		if !na.signatureRequired(rm) {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: want the method reading files or the network signed by default: %v\n", rm)
+		}
+	}
+	// A new method changing state must be added to the default list,
+	// unless it is needed before the keys are known.
+	bootstrap := make(map[Method]struct{})
+	for _, m := range signatureBootstrapMethods {
+		if na.signatureRequired(m) {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: bootstrap method signed by default: %v\n", m)
+		}
+		bootstrap[m] = struct{}{}
+	}
+	var mt Method
+	for method, mh := range mt.GetMethodsAvailable().Methodhandlers {
+		if _, ok := bootstrap[method]; ok {
+			continue
+		}
+		if !mh.isReadOnly() && !na.signatureRequired(method) {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: method changing state not in defaultSignatureMethods: %v\n", method)
+		}
+	}
+	if m := newSignatureMethods("REQHttpGet, REQNoSuchMethod"); len(m) != 1 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: want only the known methods configured, got: %v\n", m)
+	}
+
+	m := Message{
+		ToNode:     "ship1",
+		FromNode:   "signer",
+		Method:     REQToFile,
+		MethodArgs: []string{"a", "b"},
+		SignedAt:   time.Now().Unix(),
+		SignNonce:  "nonce1",
+	}
+	sign := func(m Message) Message {
+		m.ArgSignature = ed25519.Sign(priv, []byte(signedString(m)))
+		return m
+	}
+	m = sign(m)
+
+	if !na.verifySignature(m) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: valid signature not verified\n")
+	}
+
+	// The same message again is a replay.
+	if na.verifySignature(m) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: replayed message verified\n")
+	}
+
+	old := m
+	old.SignedAt = time.Now().Add(-time.Minute * 10).Unix()
+	old.SignNonce = "nonce2"
+	if na.verifySignature(sign(old)) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: message outside the skew verified\n")
+	}
+
+	m.SignNonce = "nonce3"
+	m = sign(m)
+
+	tampered := m
+	tampered.Method = REQToFileAppend
+	if na.verifySignature(tampered) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: message with tampered method verified\n")
+	}
+
+	tampered = m
+	tampered.ToNode = "ship2"
+	if na.verifySignature(tampered) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: message with tampered toNode verified\n")
+	}
+
+	// Changing the data, where the result is written, or where the reply
+	// goes should also make the verification fail.
+	tamper := map[string]func(*Message){
+		"data":            func(m *Message) { m.Data = []byte("tampered") },
+		"directory":       func(m *Message) { m.Directory = "/etc" },
+		"fileName":        func(m *Message) { m.FileName = "passwd" },
+		"replyMethod":     func(m *Message) { m.ReplyMethod = REQToFile },
+		"replyMethodArgs": func(m *Message) { m.ReplyMethodArgs = []string{"tampered"} },
+	}
+	for field, fn := range tamper {
+		tampered = m
+		fn(&tampered)
+		if na.verifySignature(tampered) {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: message with tampered %v verified\n", field)
+		}
+	}
+
+	// When the nonce cache is full the oldest nonce is evicted, so new
+	// messages are still accepted.
+	nc := newNonceCache(2)
+	now := time.Now()
+	skew := time.Minute
+	for _, nonce := range []string{"a", "b", "c"} {
+		if err := nc.checkReplay("signer", nonce, now.Unix(), now, skew); err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: nonce %v refused: %v\n", nonce, err)
+		}
+	}
+	if len(nc.order) != 2 || nc.order[0].key != "signer/b" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: want the oldest nonce evicted, got: %v\n", nc.order)
+	}
+	if err := nc.checkReplay("signer", "c", now.Unix(), now, skew); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkVerifySignatureTest: want a nonce still in the full cache refused\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkVerifySignatureTest\n")
+	return nil
+}
+
+// Check that a fresh node can join central with signature checking
+// enabled. The hello with the key of the new node must reach central
+// before central have acked the key, and the new node must accept the
+// first keys delivered from central before it knows the key of central.
+func checkSignatureBootstrapTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	centralPub, centralPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSignatureBootstrapTest: failed to generate keys: %v\n", err)
+	}
+	freshPub, freshPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSignatureBootstrapTest: failed to generate keys: %v\n", err)
+	}
+
+	newProc := func(nodeName string) process {
+		c := Configuration{NodeName: nodeName, EnableSignatureCheck: true, SignatureMaxSkew: 300}
+		proc := stewardServer.processInitial
+		proc.nodeAuth = &nodeAuth{
+			publicKeys:       &publicKeys{keysAndHash: newKeysAndHash()},
+			signatureMethods: newSignatureMethods(""),
+			nonceCache:       newNonceCache(nonceCacheMaxEntries),
+			configuration:    &c,
+			errorKernel:      stewardServer.errorKernel,
+		}
+		return proc
+	}
+	signed := func(m Message, key ed25519.PrivateKey) Message {
+		m.SignedAt = time.Now().Unix()
+		m.SignNonce = fmt.Sprint(time.Now().UnixNano())
+		m.ArgSignature = ed25519.Sign(key, []byte(signedString(m)))
+		return m
+	}
+
+	centralProc := newProc("central")
+	centralProc.nodeAuth.publicKeys.keysAndHash.Keys["central"] = centralPub
+	freshProc := newProc("freshnode")
+
+	// The hello from the fresh node is signed with a key central do
+	// not know yet, and should still be handled so the key is
+	// registered as not acked.
+	hello := signed(Message{ToNode: "central", FromNode: "freshnode", Method: REQHello, Data: freshPub}, freshPriv)
+	if !centralProc.verifySigOrAclFlag(hello) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSignatureBootstrapTest: hello from the fresh node was refused\n")
+	}
+	stewardServer.centralAuth.addPublicKey(centralProc, hello)
+	defer func() {
+		stewardServer.centralAuth.pki.nodeNotAckedPublicKeys.mu.Lock()
+		delete(stewardServer.centralAuth.pki.nodeNotAckedPublicKeys.KeyMap, "freshnode")
+		stewardServer.centralAuth.pki.nodeNotAckedPublicKeys.mu.Unlock()
+	}()
+	stewardServer.centralAuth.pki.nodeNotAckedPublicKeys.mu.Lock()
+	_, registered := stewardServer.centralAuth.pki.nodeNotAckedPublicKeys.KeyMap["freshnode"]
+	stewardServer.centralAuth.pki.nodeNotAckedPublicKeys.mu.Unlock()
+	if !registered {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSignatureBootstrapTest: key of the fresh node not registered as not acked\n")
+	}
+
+	// A command from the fresh node is refused until the key is acked.
+	cmd := Message{ToNode: "central", FromNode: "freshnode", Method: REQCliCommand, MethodArgs: []string{"ls"}}
+	if centralProc.verifySigOrAclFlag(signed(cmd, freshPriv)) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSignatureBootstrapTest: command from a node not acked was allowed\n")
+	}
+
+	// The fresh node do not know the key of central when the first keys
+	// are delivered.
+	deliver := signed(Message{ToNode: "freshnode", FromNode: "central", Method: REQKeysDeliverUpdate}, centralPriv)
+	if !freshProc.verifySigOrAclFlag(deliver) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSignatureBootstrapTest: first key update from central was refused\n")
+	}
+
+	// When the key is acked and delivered, the messages between the
+	// nodes are verified.
+	centralProc.nodeAuth.publicKeys.keysAndHash.Keys["freshnode"] = freshPub
+	freshProc.nodeAuth.publicKeys.keysAndHash.Keys["central"] = centralPub
+	if !centralProc.verifySigOrAclFlag(signed(cmd, freshPriv)) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSignatureBootstrapTest: command from the acked node was refused\n")
+	}
+	toFresh := Message{ToNode: "freshnode", FromNode: "central", Method: REQCliCommand, MethodArgs: []string{"ls"}}
+	if !freshProc.verifySigOrAclFlag(signed(toFresh, centralPriv)) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSignatureBootstrapTest: command from central was refused\n")
+	}
+	if freshProc.verifySigOrAclFlag(signed(toFresh, freshPriv)) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSignatureBootstrapTest: command with the wrong key was allowed\n")
+	}
+
+	// A hello signed with a rotated key not yet acked is also handled.
+	_, rotatedPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSignatureBootstrapTest: failed to generate keys: %v\n", err)
+	}
+	if !centralProc.verifySigOrAclFlag(signed(hello, rotatedPriv)) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkSignatureBootstrapTest: hello signed with a rotated key was refused\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkSignatureBootstrapTest\n")
+	return nil
+}
+
+// Check that the ringbuffer stores the messages on disk with their
+// delivery attempts, and waits for space when the max is reached.
+func checkRingBufferPersistTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	c := *conf
+	c.RingBufferPersist = true
+	c.RingBufferPersistPath = filepath.Join(tmpDir, "ringbufferpersist", "rb.db")
+	c.RingBufferMaxPersisted = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := newringBuffer(ctx, stewardServer.metrics, &c, 10, "", "central", nil, "samValueBucket", "indexValueBucket", stewardServer.errorKernel, stewardServer.processInitial, stewardServer.priorityPolicy)
+	defer r.db.Close()
+
+	if _, err := os.Stat(c.RingBufferPersistPath); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkRingBufferPersistTest: database not created at the path given: %v\n", err)
+	}
+
+	for _, id := range []int{2, 1} {
+		if !r.waitForPersistSpace(ctx) {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkRingBufferPersistTest: waitForPersistSpace failed\n")
+		}
+		if id == 2 {
+			// Make room for the next one, as if the message was delivered.
+			r.persistedCond.L.Lock()
+			r.persisted--
+			r.persistedCond.L.Unlock()
+		}
+		v := samDBValue{ID: id, Data: subjectAndMessage{Message: Message{ID: id}}, Attempts: id}
+		if err := r.persist(v); err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkRingBufferPersistTest: persist failed: %v\n", err)
+		}
+	}
+
+	s, err := r.dumpBucket(r.samValueBucket)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkRingBufferPersistTest: dumpBucket failed: %v\n", err)
+	}
+	if len(s) != 2 || s[0].ID != 1 || s[1].ID != 2 || s[0].Attempts != 1 || s[1].Attempts != 2 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkRingBufferPersistTest: want the messages in order with attempts, got: %v\n", s)
+	}
+
+	// The max is reached, so we should wait until the context is done.
+	waitCtx, waitCancel := context.WithTimeout(ctx, time.Millisecond*200)
+	defer waitCancel()
+	go func() {
+		<-waitCtx.Done()
+		r.persistedCond.L.Lock()
+		r.persistedCond.Broadcast()
+		r.persistedCond.L.Unlock()
+	}()
+	if r.waitForPersistSpace(waitCtx) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkRingBufferPersistTest: got space when the max was reached\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkRingBufferPersistTest\n")
+	return nil
+}
+
+// Check that a tail can be stopped with REQTailFileStop, and that a
+// final reply with the number of lines delivered is sent.
+func checkREQTailFileStopTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	fp := filepath.Join(tmpDir, "tailstop.file")
+	fh, err := os.OpenFile(fp, os.O_APPEND|os.O_RDWR|os.O_CREATE|os.O_SYNC, 0600)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQTailFileStopTest: unable to open file: %v\n", err)
+	}
+	defer fh.Close()
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQTailFile,
+		MethodArgs:    []string{fp},
+		ReplyMethod:   REQTest,
+		MethodTimeout: 60,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	// Find the ID of the tail started.
+	findTail := func() (int, bool) {
+		stewardServer.tailSessions.mu.Lock()
+		defer stewardServer.tailSessions.mu.Unlock()
+		for id, ts := range stewardServer.tailSessions.tails {
+			if ts.path == fp {
+				return id, true
+			}
+		}
+		return 0, false
+	}
+
+	var id int
+	var ok bool
+	for i := 0; i < 50; i++ {
+		if id, ok = findTail(); ok {
+			break
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if !ok {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQTailFileStopTest: tail not registered\n")
+	}
+
+	// Give the tail some time to start watching the file.
+	time.Sleep(time.Second * 1)
+	fh.Write([]byte("some line\n"))
+
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		if string(b) != "some line\n" {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQTailFileStopTest: want the line written, got: %s\n", b)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQTailFileStopTest: no line received\n")
+	}
+
+	m = Message{
+		ToNode:     "central",
+		FromNode:   "central",
+		Method:     REQTailFileStop,
+		MethodArgs: []string{strconv.Itoa(id)},
+	}
+	sam, err = newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	want := fmt.Sprintf("tail of %v stopped, 1 lines delivered\n", fp)
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		if string(b) != want {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQTailFileStopTest: want %q, got: %q\n", want, b)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQTailFileStopTest: no final reply received\n")
+	}
+
+	if _, ok := findTail(); ok {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQTailFileStopTest: tail still registered after stop\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQTailFileStopTest\n")
+	return nil
+}
+
+// Check that REQHttpPost sends the body and headers, and replies with
+// the status code and body of the response.
+func checkREQHttpPostTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+		fmt.Fprintf(w, "%s %s %s", r.Header.Get("Content-Type"), r.Header.Get("X-Test"), body)
+	}))
+	defer ts.Close()
+
+	post := func(url string) httpPostReply {
+		m := Message{
+			ToNode:        "central",
+			FromNode:      "central",
+			Method:        REQHttpPost,
+			MethodArgs:    []string{url, "text/plain", "X-Test: hello"},
+			Data:          []byte("some body"),
+			MethodTimeout: 5,
+			ReplyMethod:   REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		var reply httpPostReply
+		select {
+		case b := <-stewardServer.errorKernel.testCh:
+			err := json.Unmarshal(b, &reply)
+			if err != nil {
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkREQHttpPostTest: failed to unmarshal reply: %v, %s\n", err, b)
+			}
+		case <-time.After(time.Second * 10):
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQHttpPostTest: no reply received\n")
+		}
+		return reply
+	}
+
+	want := httpPostReply{StatusCode: http.StatusCreated, Body: "text/plain hello some body"}
+	if r := post(ts.URL + "/ok"); r != want {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQHttpPostTest: want %v, got %v\n", want, r)
+	}
+
+	want = httpPostReply{StatusCode: http.StatusInternalServerError, Error: true, Body: "text/plain hello some body"}
+	if r := post(ts.URL + "/fail"); r != want {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQHttpPostTest: want %v, got %v\n", want, r)
+	}
+
+	if _, _, err := parseHttpPostArgs([]string{ts.URL, "", "no header"}); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQHttpPostTest: invalid header accepted\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQHttpPostTest\n")
+	return nil
+}
+
+// Check that the HTTP listener rejects the requests without a valid
+// token or client certificate when authentication is configured.
+func checkHTTPListenerAuthTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	tokenFile := filepath.Join(tmpDir, "httplistener.token")
+	err := os.WriteFile(tokenFile, []byte("secrettoken\n"), 0600)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkHTTPListenerAuthTest: failed to write token file: %v\n", err)
+	}
+
+	c := *conf
+	c.HTTPListenerTokenFile = tokenFile
+	a, err := newHTTPListenerAuth(&c)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkHTTPListenerAuthTest: newHTTPListenerAuth failed: %v\n", err)
+	}
+
+	orig := stewardServer.httpListenerAuth
+	stewardServer.httpListenerAuth = a
+	defer func() { stewardServer.httpListenerAuth = orig }()
+
+	body := `[{"toNode":"central","fromNode":"central","method":"REQTest","data":[104,116,116,112,32,108,105,115,116,101,110,101,114]}]`
+	post := func(auth string) int {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		stewardServer.readHTTPlistenerHandler(w, r)
+		return w.Code
+	}
+
+	for _, auth := range []string{"", "Bearer wrongtoken", "secrettoken"} {
+		if code := post(auth); code != http.StatusUnauthorized {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkHTTPListenerAuthTest: want 401 with authorization %q, got %v\n", auth, code)
+		}
+	}
+
+	if code := post("Bearer secrettoken"); code != http.StatusOK {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkHTTPListenerAuthTest: want 200 with valid token, got %v\n", code)
+	}
+
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		if string(b) != "http listener" {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkHTTPListenerAuthTest: want the message posted, got: %s\n", b)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkHTTPListenerAuthTest: message posted was not received\n")
+	}
+
+	// A verified client certificate is accepted without a token.
+	a.clientCAs = x509.NewCertPool()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{}}}
+	if err := a.authorize(r); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkHTTPListenerAuthTest: verified client certificate rejected: %v\n", err)
+	}
+	r.TLS = &tls.ConnectionState{}
+	if err := a.authorize(r); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkHTTPListenerAuthTest: request without client certificate accepted\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkHTTPListenerAuthTest\n")
+	return nil
+}
+
+// Check that messages larger than one read, and with data holding
+// null bytes, are read unchanged from the socket and the HTTP listener.
+func checkListenerReadTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	// The data is larger than 1500 bytes, and starts and ends with null
+	// bytes.
+	data := make([]byte, 2000)
+	for i := range data {
+		data[i] = byte(i % 256)
+	}
+	// Write the data as a list of numbers, which is how the listeners
+	// expect the data of a message.
+	nums := make([]string, len(data))
+	for i, b := range data {
+		nums[i] = strconv.Itoa(int(b))
+	}
+	msg := `[{"toNode":"central","fromNode":"central","method":"REQTest","data":[` + strings.Join(nums, ",") + `]}]`
+
+	checkReceived := func(from string) {
+		select {
+		case b := <-stewardServer.errorKernel.testCh:
+			if !bytes.Equal(b, data) {
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkListenerReadTest: data read from %v was changed, got %v bytes\n", from, len(b))
+			}
+		case <-time.After(time.Second * 10):
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkListenerReadTest: message written to %v was not received\n", from)
+		}
+	}
+
+	writeToSocketTest(conf, msg, t)
+	checkReceived("socket")
+
+	// Read the body one byte at a time, so the message is read with many
+	// short reads.
+	r := httptest.NewRequest(http.MethodPost, "/", iotest.OneByteReader(strings.NewReader(msg)))
+	w := httptest.NewRecorder()
+	stewardServer.readHTTPlistenerHandler(w, r)
+	checkReceived("http listener")
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkListenerReadTest\n")
+	return nil
+}
+
+// Check that a message delivered twice is only handled once.
+func checkDedupTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	c := *conf
+	c.DedupWindow = 60
+	c.DedupMethods = "REQTest"
+
+	orig := stewardServer.dedupCache
+	stewardServer.dedupCache = newDedupCache(&c)
+	defer func() { stewardServer.dedupCache = orig }()
+
+	sub := newSubject(REQTest, "central")
+	stewardServer.processes.active.mu.Lock()
+	proc := stewardServer.processes.active.procNames[processNameGet(sub.name(), processKindSubscriber)]
+	stewardServer.processes.active.mu.Unlock()
+
+	m := Message{
+		ID:         1000000,
+		ToNode:     "central",
+		FromNode:   "central",
+		Method:     REQTest,
+		Data:       []byte("dedup"),
+		DeliveryID: "dedupdelivery",
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDedupTest: gob encode failed: %v\n", err)
+	}
+
+	// Deliver the same message twice, as if the publisher resent it.
+	for i := 0; i < 2; i++ {
+		msg := &transportMsg{Subject: string(sub.name()), Data: buf.Bytes()}
+		proc.messageSubscriberHandler(stewardServer.transport, "central", msg, string(sub.name()))
+	}
+
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		if string(b) != "dedup" {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkDedupTest: want the message data, got: %s\n", b)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDedupTest: the message was not handled\n")
+	}
+
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDedupTest: the duplicate was handled: %s\n", b)
+	case <-time.After(time.Second * 1):
+	}
+
+	// A retry is signed again by the publisher with a new time and
+	// nonce, and should still be found as a duplicate.
+	resigned := m
+	resigned.SignedAt = time.Now().Unix()
+	resigned.SignNonce = "dedupnonce"
+	resigned.ArgSignature = []byte("new signature")
+	buf.Reset()
+	gob.NewEncoder(&buf).Encode(resigned)
+	proc.messageSubscriberHandler(stewardServer.transport, "central", &transportMsg{Subject: string(sub.name()), Data: buf.Bytes()}, string(sub.name()))
+
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDedupTest: the signed again duplicate was handled: %s\n", b)
+	case <-time.After(time.Second * 1):
+	}
+
+	// A message with the same content, but published again with a new
+	// delivery ID, is not a duplicate.
+	m.DeliveryID = "dedupdelivery2"
+	buf.Reset()
+	gob.NewEncoder(&buf).Encode(m)
+	proc.messageSubscriberHandler(stewardServer.transport, "central", &transportMsg{Subject: string(sub.name()), Data: buf.Bytes()}, string(sub.name()))
+
+	select {
+	case <-stewardServer.errorKernel.testCh:
+	case <-time.After(time.Second * 5):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDedupTest: message with a new delivery ID was not handled\n")
+	}
+
+	// A message with another ID is not a duplicate.
+	m.ID++
+	buf.Reset()
+	gob.NewEncoder(&buf).Encode(m)
+	msg := &transportMsg{Subject: string(sub.name()), Data: buf.Bytes()}
+	proc.messageSubscriberHandler(stewardServer.transport, "central", msg, string(sub.name()))
+
+	select {
+	case <-stewardServer.errorKernel.testCh:
+	case <-time.After(time.Second * 5):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDedupTest: message with a new ID was not handled\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkDedupTest\n")
+	return nil
+}
+
+// Check that REQCliCommandCont delivers the output up to the max output
+// size, sends a truncation notice, and kills the child processes of the
+// command.
+func checkREQCliCommandContMaxOutputTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	m := Message{
+		ToNode:         "central",
+		FromNode:       "central",
+		Method:         REQCliCommandCont,
+		MethodArgs:     []string{"bash", "-c", `sleep 60 & echo $!; while true; do echo aaaaaaaaaaaaaaaaaaaaaaaaaaaaa; sleep 0.1; done`},
+		ReplyMethod:    REQTest,
+		MethodTimeout:  30,
+		MaxOutputBytes: 100,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	var out []byte
+	var noticed bool
+	for !noticed || len(out) < 100 {
+		select {
+		case b := <-stewardServer.errorKernel.testCh:
+			if strings.HasPrefix(string(b), "output truncated") {
+				noticed = true
+				continue
+			}
+			out = append(out, b...)
+		case <-time.After(time.Second * 10):
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandContMaxOutputTest: got %v bytes of output, truncation notice received: %v\n", len(out), noticed)
+		}
+	}
+
+	if len(out) != 100 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandContMaxOutputTest: want 100 bytes of output, got %v\n", len(out))
+	}
+
+	// The first line is the pid of the sleep started by the shell, which
+	// should be killed with the process group.
+	pid := strings.SplitN(string(out), "\n", 2)[0]
+	killed := func() bool {
+		b, err := os.ReadFile(filepath.Join("/proc", pid, "stat"))
+		if err != nil {
+			return true
+		}
+		// A zombie waiting to be reaped is also killed.
+		f := strings.Fields(string(b))
+		return len(f) > 2 && f[2] == "Z"
+	}
+	for i := 0; i < 50 && !killed(); i++ {
+		time.Sleep(time.Millisecond * 100)
+	}
+	if !killed() {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandContMaxOutputTest: child process %v of the command was not killed\n", pid)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQCliCommandContMaxOutputTest\n")
+	return nil
+}
+
+// Check that the json logger writes the log entries as json objects
+// with the fields of the message, and that the console error sink
+// writes through the logger.
+func checkJSONLoggerTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	buf := bytes.Buffer{}
+	l := newJSONLogger(&buf)
+
+	m := Message{ID: 42, Method: REQCliCommand}
+	f := msgLogFields("central", m)
+	f.Subject = "central.REQCliCommand.EventACK"
+	l.logf(logLevelError, f, "error: something failed: %v\n", "boom")
+
+	consoleErrorSink{logger: l}.send("info: hello", errorEvent{errorType: errTypeSendInfo})
+
+	dec := json.NewDecoder(&buf)
+
+	var e jsonLogEntry
+	if err := dec.Decode(&e); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkJSONLoggerTest: failed to decode log entry: %v\n", err)
+	}
+	want := jsonLogEntry{Time: e.Time, Level: logLevelError, Node: "central", Subject: "central.REQCliCommand.EventACK", MessageID: 42, Method: REQCliCommand, Msg: "error: something failed: boom"}
+	if e != want {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkJSONLoggerTest: want %+v, got %+v\n", want, e)
+	}
+
+	var e2 jsonLogEntry
+	if err := dec.Decode(&e2); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkJSONLoggerTest: failed to decode console sink entry: %v\n", err)
+	}
+	if e2.Level != logLevelInfo || !strings.Contains(e2.Msg, "hello") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkJSONLoggerTest: got console sink entry %+v\n", e2)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkJSONLoggerTest\n")
+	return nil
+}
+
+// Check that REQKeysRotate replaces the signing keys in memory and on
+// disk, and that the old public key is only accepted within the grace
+// period.
+func checkREQKeysRotateTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	oldPub, oldPriv := stewardServer.nodeAuth.signingKeys()
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQKeysRotate,
+		ReplyMethod:   REQTest,
+		MethodTimeout: 5,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		if !strings.HasPrefix(string(b), "signing keys rotated") {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQKeysRotateTest: got unexpected reply: %s\n", b)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQKeysRotateTest: no reply received\n")
+	}
+
+	newPub, newPriv := stewardServer.nodeAuth.signingKeys()
+	if bytes.Equal(oldPub, newPub) || bytes.Equal(oldPriv, newPriv) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQKeysRotateTest: the signing keys were not replaced\n")
+	}
+
+	filePub, _, err := stewardServer.nodeAuth.readKeyFile(stewardServer.nodeAuth.SignKeyPublicKeyPath)
+	if err != nil || !bytes.Equal(filePub, newPub) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQKeysRotateTest: the public key file was not replaced, err: %v\n", err)
+	}
+	filePriv, _, err := stewardServer.nodeAuth.readKeyFile(stewardServer.nodeAuth.SignKeyPrivateKeyPath)
+	if err != nil || !bytes.Equal(filePriv, newPriv) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQKeysRotateTest: the private key file was not replaced, err: %v\n", err)
+	}
+
+	signed := []byte("some signed data")
+	oldSig := ed25519.Sign(oldPriv, signed)
+	newSig := ed25519.Sign(newPriv, signed)
+	now := time.Now()
+	grace := time.Second * time.Duration(conf.SignKeysRotateGrace)
+
+	if !stewardServer.nodeAuth.verifyOwnSignature(signed, newSig, now) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQKeysRotateTest: signature with the new key was not accepted\n")
+	}
+	if !stewardServer.nodeAuth.verifyOwnSignature(signed, oldSig, now) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQKeysRotateTest: signature with the old key was not accepted within the grace period\n")
+	}
+	if stewardServer.nodeAuth.verifyOwnSignature(signed, oldSig, now.Add(grace+time.Second)) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQKeysRotateTest: signature with the old key was accepted after the grace period\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQKeysRotateTest\n")
+	return nil
+}
+
+// Check that REQPublicKeysList replies with the fingerprints of the
+// public keys, the hash and the number of keys.
+func checkREQPublicKeysListTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	pk := publicKeys{keysAndHash: newKeysAndHash()}
+	pk.keysAndHash.Keys["ship1"] = []byte("ship1-key")
+	pk.keysAndHash.Keys["ship2"] = []byte("ship2-key")
+	pk.keysAndHash.Hash = [32]byte{1, 2, 3}
+
+	l := pk.list()
+	fp := sha256.Sum256([]byte("ship1-key"))
+	switch {
+	case l.Count != 2 || len(l.Keys) != 2:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQPublicKeysListTest: want 2 keys, got %+v\n", l)
+	case l.Keys["ship1"] != base64.StdEncoding.EncodeToString(fp[:]):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQPublicKeysListTest: wrong fingerprint for ship1: %v\n", l.Keys["ship1"])
+	case l.Hash != hex.EncodeToString(pk.keysAndHash.Hash[:]):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQPublicKeysListTest: wrong hash: %v\n", l.Hash)
+	}
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQPublicKeysList,
+		ReplyMethod:   REQTest,
+		MethodTimeout: 5,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		var got publicKeysList
+		err := json.Unmarshal(b, &got)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQPublicKeysListTest: failed to unmarshal reply: %v, %s\n", err, b)
+		}
+		if want := stewardServer.nodeAuth.publicKeys.list(); got.Count != want.Count || got.Hash != want.Hash {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQPublicKeysListTest: want %+v, got %+v\n", want, got)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQPublicKeysListTest: no reply received\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQPublicKeysListTest\n")
+	return nil
+}
+
+// Check that REQCliCommand in dry run mode replies with the resolved
+// command, and that the command is not executed.
+func checkREQCliCommandDryRunTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	file := filepath.Join(tmpDir, "dryrun.txt")
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQCliCommand,
+		MethodArgs:    []string{"bash", "-c", "touch " + file},
+		ReplyMethod:   REQTest,
+		MethodTimeout: 5,
+		DryRun:        true,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	var got cliCommandDryRun
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		err := json.Unmarshal(b, &got)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandDryRunTest: failed to unmarshal reply: %v, %s\n", err, b)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandDryRunTest: no reply received\n")
+	}
+
+	wd, _ := os.Getwd()
+	want := []string{"bash", "-c", "touch " + file}
+	switch {
+	case !filepath.IsAbs(got.Path) || filepath.Base(got.Path) != "bash":
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandDryRunTest: want the resolved path of bash, got %v\n", got.Path)
+	case strings.Join(got.Args, " ") != strings.Join(want, " "):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandDryRunTest: want args %v, got %v\n", want, got.Args)
+	case got.Dir != wd:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandDryRunTest: want dir %v, got %v\n", wd, got.Dir)
+	}
+
+	// Give a command that was executed time to create the file.
+	time.Sleep(time.Millisecond * 500)
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandDryRunTest: the command was executed in dry run mode\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQCliCommandDryRunTest\n")
+	return nil
+}
+
+// Check that the folder of the reply files is created from the
+// ReplyFolderTemplate, and that invalid templates fail at startup.
+func checkReplyFolderTemplateTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	for _, tmpl := range []string{"{{.Node", "{{.NoSuchField}}", "../{{.Node}}"} {
+		_, err := newReplyFolderTemplate(&Configuration{ReplyFolderTemplate: tmpl})
+		if err == nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkReplyFolderTemplateTest: no error for template %q\n", tmpl)
+		}
+	}
+
+	tmpl, err := newReplyFolderTemplate(&Configuration{ReplyFolderTemplate: "{{.Directory}}/{{.Date}}/{{.Node}}/{{.Method}}-{{.MessageID}}"})
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkReplyFolderTemplateTest: failed to parse template: %v\n", err)
+	}
+
+	proc := process{
+		configuration: conf,
+		errorKernel:   stewardServer.errorKernel,
+		server:        &server{replyFolderTemplate: tmpl},
+	}
+	m := Message{
+		FromNode: "central",
+		Method:   REQToFile,
+		PreviousMessage: &Message{
+			ID:        7,
+			ToNode:    "ship101",
+			Method:    REQCliCommand,
+			FileName:  "dmesg.log",
+			Directory: "logs",
+		},
+	}
+
+	fileName, folderTree, err := selectFileNaming(m, proc)
+	want := filepath.Join(conf.SubscribersDataFolder, "logs", time.Now().Format("2006-01-02"), "ship101", "REQCliCommand-7")
+	if err != nil || fileName != "dmesg.log" || folderTree != want {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkReplyFolderTemplateTest: want %v and dmesg.log, got %v and %v\n", want, folderTree, fileName)
+	}
+
+	// Without a template the default naming is used.
+	proc.server = &server{}
+	_, folderTree, err = selectFileNaming(m, proc)
+	if want := filepath.Join(conf.SubscribersDataFolder, "logs", "ship101"); err != nil || folderTree != want {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkReplyFolderTemplateTest: want default folder %v, got %v\n", want, folderTree)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkReplyFolderTemplateTest\n")
+	return nil
+}
+
+// Check that REQToFileAppend rotates the file when it gets larger than
+// the rotate size, keeps the number of rotated copies given, and that
+// concurrent appends to the same file are not lost or interleaved.
+func checkREQToFileAppendRotateTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	proc := process{
+		configuration: conf,
+		errorKernel:   stewardServer.errorKernel,
+		server:        stewardServer,
+	}
+
+	const writes = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m := Message{
+				ToNode:     "central",
+				Method:     REQToFileAppend,
+				Directory:  "rotate",
+				FileName:   "rotate.log",
+				Data:       []byte(fmt.Sprintf("line %02d\n", i)),
+				RotateSize: 16,
+				RotateKeep: 3,
+			}
+			_, err := methodREQToFileAppend{}.handler(proc, m, "central")
+			if err != nil {
+				t.Errorf(" \U0001F631  [FAILED]\t: checkREQToFileAppendRotateTest: handler failed: %v\n", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	file := filepath.Join(conf.SubscribersDataFolder, "rotate", "central", "rotate.log")
+	for _, f := range []string{file, file + ".1", file + ".2", file + ".3"} {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToFileAppendRotateTest: failed to read %v: %v\n", f, err)
+		}
+		// Each line is 8 bytes, so each file should hold two whole lines.
+		if len(b) != 16 || !strings.HasPrefix(string(b), "line ") || !strings.HasPrefix(string(b[8:]), "line ") {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToFileAppendRotateTest: want two whole lines in %v, got %q\n", f, b)
+		}
+	}
+	if _, err := os.Stat(file + ".4"); !os.IsNotExist(err) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToFileAppendRotateTest: more rotated copies than asked for were kept\n")
+	}
+	if n := len(stewardServer.fileLocks.locks); n != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToFileAppendRotateTest: want no file locks left, got %v\n", n)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQToFileAppendRotateTest\n")
+	return nil
+}
+
+// Check that draining the processes waits for the subscriber handlers
+// and the publishers to finish, and returns the processes that did not
+// finish in time, and that stopping the input closes the listeners.
+func checkDrainTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	p := &processes{active: *newProcsMap()}
+
+	sub := process{
+		processName:      "central.REQCliCommand.EventACK_subscriber",
+		processKind:      processKindSubscriber,
+		handlersInFlight: new(int64),
+	}
+	*sub.handlersInFlight = 1
+
+	pubDone := make(chan struct{})
+	var pubOnce sync.Once
+	pub := process{
+		processName:   "central.REQToFile.EventACK_publisher",
+		processKind:   processKindPublisher,
+		publisherDone: pubDone,
+		// The publisher stops after the current message when canceled.
+		ctxCancel: func() { pubOnce.Do(func() { close(pubDone) }) },
+	}
+
+	p.active.procNames[sub.processName] = sub
+	p.active.procNames[pub.processName] = pub
+	p.wg.Add(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	pending := p.drain(ctx)
+	cancel()
+	want := []string{"background work of the handlers", string(sub.processName)}
+	if strings.Join(pending, ",") != strings.Join(want, ",") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDrainTest: want pending %v, got %v\n", want, pending)
+	}
+
+	// Finish the work while draining.
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		atomic.StoreInt64(sub.handlersInFlight, 0)
+		p.wg.Done()
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	pending = p.drain(ctx)
+	cancel()
+	if len(pending) != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDrainTest: want nothing pending, got %v\n", pending)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDrainTest: failed to listen: %v\n", err)
+	}
+	s := &server{tcpListener: ln}
+	s.stopInput(context.Background())
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDrainTest: want the tcp listener closed, got %v\n", err)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkDrainTest\n")
+	return nil
+}
+
+// Check that REQScheduled publishes the message given until the count
+// is reached, that REQScheduledStop stops a schedule, and that the
+// schedules are stored to file.
+func checkREQScheduledTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	inner := `{"toNode":"central","method":"REQCliCommand","methodArgs":["bash","-c","echo scheduled"],"replyMethod":"REQTest","methodTimeout":5}`
+
+	schedule := func(count string, interval string) int {
+		m := Message{
+			ToNode:      "central",
+			FromNode:    "central",
+			Method:      REQScheduled,
+			MethodArgs:  []string{inner, interval, count},
+			ReplyMethod: REQTest,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		select {
+		case b := <-stewardServer.errorKernel.testCh:
+			id, err := strconv.Atoi(string(b))
+			if err != nil {
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: want the id of the schedule, got: %s\n", b)
+			}
+			return id
+		case <-time.After(time.Second * 10):
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: no reply with the id of the schedule received\n")
+		}
+		return 0
+	}
+
+	// The message should be published twice, and the schedule removed.
+	id := schedule("2", "1")
+	for i := 0; i < 2; i++ {
+		select {
+		case b := <-stewardServer.errorKernel.testCh:
+			if strings.TrimSpace(string(b)) != "scheduled" {
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: want the output of the scheduled command, got: %s\n", b)
+			}
+		case <-time.After(time.Second * 10):
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: got %v replies from the scheduled message, want 2\n", i)
+		}
+	}
+
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: got reply after the count was reached: %s\n", b)
+	case <-time.After(time.Second * 2):
+	}
+
+	stewardServer.scheduledMessages.mu.Lock()
+	_, ok := stewardServer.scheduledMessages.schedules[scheduleKey{fromNode: "central", id: id}]
+	stewardServer.scheduledMessages.mu.Unlock()
+	if ok {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: schedule %v not removed when the count was reached\n", id)
+	}
+
+	// A schedule without a count should run until stopped.
+	id = schedule("", "60")
+
+	m := Message{
+		ToNode:      "central",
+		FromNode:    "central",
+		Method:      REQScheduledStop,
+		MethodArgs:  []string{strconv.Itoa(id)},
+		ReplyMethod: REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		if string(b) != fmt.Sprintf("schedule %v stopped", id) {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: want schedule stopped, got: %s\n", b)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: no reply from REQScheduledStop received\n")
+	}
+
+	stewardServer.scheduledMessages.mu.Lock()
+	n := len(stewardServer.scheduledMessages.schedules)
+	stewardServer.scheduledMessages.mu.Unlock()
+	if n != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: want no schedules running, got %v\n", n)
+	}
+
+	// The schedules should be stored to file when persistence is
+	// enabled, with the number of times left to publish.
+	c := *conf
+	c.DatabaseFolder = tmpDir
+	c.RingBufferPersist = true
+	sms := newScheduledMessages(&c)
+	sms.schedules[scheduleKey{fromNode: "central", id: 1}] = &scheduledMessage{ID: 1, FromNode: "central", Message: Message{ToNode: "central", Method: REQHello}, Interval: 10, Remaining: 3}
+	if err := sms.saveToFile(); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: saveToFile: %v\n", err)
+	}
+
+	stored, err := newScheduledMessages(&c).loadFromFile()
+	switch {
+	case err != nil:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: loadFromFile: %v\n", err)
+	case len(stored) != 1 || stored[0].ID != 1 || stored[0].Remaining != 3 || stored[0].Message.Method != REQHello:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: want the stored schedule, got %+v\n", stored)
+	}
+
+	// The message ids are only unique for the node sending them, so
+	// schedules with the same id from two nodes should both run, and
+	// each node should only be able to stop its own.
+	sms = newScheduledMessages(conf)
+	for _, n := range []Node{"ship1", "ship2"} {
+		err := sms.start(stewardServer.processInitial, &scheduledMessage{ID: 5, FromNode: n, Message: Message{ToNode: "central", Method: REQHello}, Interval: 60, Remaining: -1})
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: schedule with the same id from %v refused: %v\n", n, err)
+		}
+	}
+	if err := sms.stop(5, "ship3"); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: want stop of a schedule from another node refused\n")
+	}
+	if err := sms.stop(5, "ship1"); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: stop of own schedule failed: %v\n", err)
+	}
+	if _, ok := sms.schedules[scheduleKey{fromNode: "ship2", id: 5}]; !ok || len(sms.schedules) != 1 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: want only the schedule from ship2 left, got %v\n", sms.schedules)
+	}
+	sms.stop(5, "ship2")
+
+	// A scheduled message should only be sent to the node receiving the
+	// REQScheduled, since it is signed by that node.
+	other := Message{
+		ToNode:     "central",
+		FromNode:   "ship1",
+		Method:     REQScheduled,
+		MethodArgs: []string{`{"toNode":"ship2","method":"REQCliCommand","methodArgs":["bash","-c","echo scheduled"]}`, "60"},
+	}
+	if _, err := (methodREQScheduled{}).handler(stewardServer.processInitial, other, "central"); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: want a schedule to another node refused\n")
+	}
+
+	// The scheduled message should be checked against the acl for the
+	// node that started the schedule, and not the node publishing it.
+	aclConf := *conf
+	aclConf.EnableSignatureCheck = true
+	aclConf.EnableAclCheck = true
+	proc := process{
+		configuration: &aclConf,
+		nodeAuth: &nodeAuth{
+			nodeAcl: &nodeAcl{
+				aclAndHash: newAclAndHash(),
+				regexCache: make(map[command]*regexp.Regexp),
+				logger:     stewardServer.logger,
+			},
+			configuration: &aclConf,
+			errorKernel:   stewardServer.errorKernel,
+		},
+	}
+	proc.nodeAuth.nodeAcl.aclAndHash.Acl["central"] = map[command]struct{}{"*": {}}
+	proc.nodeAuth.nodeAcl.aclAndHash.Acl["ship1"] = map[command]struct{}{"uptime": {}}
+
+	sm := scheduledMessage{FromNode: "ship1", Message: Message{FromNode: "central", Method: REQCliCommand, MethodArgs: []string{"uptime"}}}
+	if !scheduledAllowed(proc, &sm) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: want command allowed for ship1 to be scheduled\n")
+	}
+	sm.Message.MethodArgs = []string{"rm", "-rf", "/"}
+	if scheduledAllowed(proc, &sm) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQScheduledTest: want command not allowed for ship1 refused, even if allowed for the node publishing it\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQScheduledTest\n")
+	return nil
+}
+
+// methodPanicTest is a method handler that panics, used to check that
+// the panics of the handlers are recovered.
+type methodPanicTest struct{}
+
+func (m methodPanicTest) getKind() Event { return EventACK }
+
+func (m methodPanicTest) isReadOnly() bool { return true }
+
+func (m methodPanicTest) handler(proc process, message Message, node string) ([]byte, error) {
+	panic("methodPanicTest")
+}
+
+// Check that the execution time of the method handlers is recorded by
+// method, and that a handler that panics is recovered and counted.
+func checkMethodHandlerMetricsTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	m := Message{Method: "REQPanicTest"}
+	_, err := stewardServer.processInitial.runHandler(m, methodPanicTest{}, "central")
+	if err == nil || !strings.Contains(err.Error(), "panicked") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMethodHandlerMetricsTest: want an error for the panic, got %v\n", err)
+	}
+
+	mfs, err := stewardServer.metrics.promRegistry.Gather()
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMethodHandlerMetricsTest: gather: %v\n", err)
+	}
+
+	durations := map[string]uint64{}
+	panics := map[string]float64{}
+	for _, mf := range mfs {
+		for _, metric := range mf.GetMetric() {
+			var method string
+			for _, l := range metric.GetLabel() {
+				if l.GetName() == "method" {
+					method = l.GetValue()
+				}
+			}
+
+			switch mf.GetName() {
+			case "steward_method_handler_duration_seconds":
+				durations[method] = metric.GetHistogram().GetSampleCount()
+			case "steward_method_handler_panics_total":
+				panics[method] = metric.GetCounter().GetValue()
+			}
+		}
+	}
+
+	switch {
+	case durations[string(REQCliCommand)] == 0:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMethodHandlerMetricsTest: no execution time recorded for REQCliCommand: %v\n", durations)
+	case durations["REQPanicTest"] != 1:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMethodHandlerMetricsTest: want the execution time of the handler that panicked recorded once, got %v\n", durations["REQPanicTest"])
+	case panics["REQPanicTest"] != 1:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMethodHandlerMetricsTest: want 1 panic counted, got %v\n", panics["REQPanicTest"])
+	}
+
+	buckets, err := parseHistogramBuckets("1, 0.5,10")
+	if err != nil || len(buckets) != 3 || buckets[0] != 0.5 || buckets[2] != 10 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMethodHandlerMetricsTest: want the buckets sorted, got %v, %v\n", buckets, err)
+	}
+	for _, list := range []string{"1,x", "1,1", "0"} {
+		if _, err := parseHistogramBuckets(list); err == nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkMethodHandlerMetricsTest: want an error for the buckets %q\n", list)
+		}
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkMethodHandlerMetricsTest\n")
+	return nil
+}
+
+// Check that a handler that panics is recovered, that the publisher gets
+// an error as the reply, and that the error with the stack trace is
+// sent to central.
+func checkHandlerPanicTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	m := Message{
+		ID:       4711,
+		ToNode:   "central",
+		FromNode: "central",
+		Method:   "REQPanicTest",
+	}
+	out := stewardServer.processInitial.callHandler(m, methodPanicTest{}, "central")
+
+	want := "error from: central: 4711: handler panicked"
+	if string(out) != want {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkHandlerPanicTest: want reply %q, got %q\n", want, out)
+	}
+
+	resultFile := filepath.Join(conf.SubscribersDataFolder, "errorLog", "errorCentral", "error.log")
+	for _, want := range []string{"handler for method REQPanicTest panicked: methodPanicTest", "runtime/debug.Stack"} {
+		var found bool
+		for i := 0; i < 10 && !found; i++ {
+			found, _ = findStringInFileTest(want, resultFile, conf, t)
+			if !found {
+				time.Sleep(time.Millisecond * 500)
+			}
+		}
+		if !found {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkHandlerPanicTest: did not find %q in the error log\n", want)
+		}
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkHandlerPanicTest\n")
+	return nil
+}
+
+// Check that REQNodeInfo replies with the host diagnostics of the node.
+func checkREQNodeInfoTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	m := Message{
+		ToNode:      "central",
+		FromNode:    "central",
+		Method:      REQNodeInfo,
+		ReplyMethod: REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	var got nodeInfo
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		err := json.Unmarshal(b, &got)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQNodeInfoTest: failed to unmarshal reply: %v, %s\n", err, b)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQNodeInfoTest: no reply received\n")
+	}
+
+	hostname, _ := os.Hostname()
+	switch {
+	case got.SchemaVersion != nodeInfoSchemaVersion:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQNodeInfoTest: want schema version %v, got %v\n", nodeInfoSchemaVersion, got.SchemaVersion)
+	case got.Node != "central" || got.Hostname != hostname:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQNodeInfoTest: want node central on host %v, got %v on host %v\n", hostname, got.Node, got.Hostname)
+	case got.OS != runtime.GOOS || got.Arch != runtime.GOARCH:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQNodeInfoTest: want %v/%v, got %v/%v\n", runtime.GOOS, runtime.GOARCH, got.OS, got.Arch)
+	case got.Version != stewardServer.version:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQNodeInfoTest: want version %v, got %v\n", stewardServer.version, got.Version)
+	case got.Uptime <= 0 || !got.StartedAt.Equal(stewardServer.startedAt):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQNodeInfoTest: want the uptime since %v, got %v seconds since %v\n", stewardServer.startedAt, got.Uptime, got.StartedAt)
+	case got.Processes <= 0 || got.Goroutines <= 0:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQNodeInfoTest: want the processes and goroutines counted, got %v and %v\n", got.Processes, got.Goroutines)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQNodeInfoTest\n")
+	return nil
+}
+
+// Check that the messages with timeouts out of range are refused before
+// they are put on the ringbuffer.
+func checkMessageTimeoutsTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	tests := []struct {
+		methodTimeout int
+		ackTimeout    int
+		valid         bool
+	}{
+		{methodTimeout: -1, valid: true},
+		{methodTimeout: 0, ackTimeout: 0, valid: true},
+		{methodTimeout: 10, ackTimeout: 5, valid: true},
+		{methodTimeout: maxMessageTimeout, valid: true},
+		{methodTimeout: -2, valid: false},
+		{ackTimeout: -2, valid: false},
+		{methodTimeout: math.MaxInt64 / 1000, valid: false},
+		{ackTimeout: maxMessageTimeout + 1, valid: false},
+	}
+
+	for _, tt := range tests {
+		m := Message{
+			ToNode:        "central",
+			Method:        REQHello,
+			MethodTimeout: tt.methodTimeout,
+			ACKTimeout:    tt.ackTimeout,
+		}
+		_, err := newSubjectAndMessage(m)
+		if (err == nil) != tt.valid {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageTimeoutsTest: methodTimeout %v, ACKTimeout %v: want valid %v, got error %v\n", tt.methodTimeout, tt.ackTimeout, tt.valid, err)
+		}
+	}
+
+	// A message read with a timeout out of range should be dropped, and
+	// the error sent to the error log.
+	sams, err := stewardServer.convertBytesToSAMs([]byte(`[{"toNode":"central","method":"REQHello","methodTimeout":-2}]`))
+	if err != nil || len(sams) != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageTimeoutsTest: want the message dropped, got %v messages, %v\n", len(sams), err)
+	}
+
+	resultFile := filepath.Join(conf.SubscribersDataFolder, "errorLog", "errorCentral", "error.log")
+	found, _ := findStringInFileTest("methodTimeout -2 out of range", resultFile, conf, t)
+	for i := 0; i < 10 && !found; i++ {
+		time.Sleep(time.Millisecond * 500)
+		found, _ = findStringInFileTest("methodTimeout -2 out of range", resultFile, conf, t)
+	}
+	if !found {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageTimeoutsTest: the error was not found in the error log\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkMessageTimeoutsTest\n")
+	return nil
+}
+
+// Check that REQCliCommand runs the command with the environment
+// variables and the working directory of the message, that an invalid
+// working directory is replied as an error, and that the values of the
+// environment variables are not printed when the message is logged.
+func checkREQCliCommandEnvTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	send := func(m Message) string {
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		select {
+		case b := <-stewardServer.errorKernel.testCh:
+			return string(b)
+		case <-time.After(time.Second * 10):
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandEnvTest: no reply received\n")
+		}
+		return ""
+	}
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQCliCommand,
+		MethodArgs:    []string{"bash", "-c", `echo "$STEWARD_TEST_SECRET,$(pwd),${PATH:+path}"`},
+		ReplyMethod:   REQTest,
+		MethodTimeout: 5,
+		Env:           commandEnv{"STEWARD_TEST_SECRET": "s3cret"},
+		WorkDir:       tmpDir,
+	}
+
+	wd, _ := filepath.Abs(tmpDir)
+	wd, _ = filepath.EvalSymlinks(wd)
+	want := "s3cret," + wd + ",path"
+	if got := strings.TrimSpace(send(m)); got != want {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandEnvTest: want %q, got %q\n", want, got)
+	}
+
+	for _, format := range []string{"%v", "%+v", "%#v"} {
+		if s := fmt.Sprintf(format, m); strings.Contains(s, "s3cret") {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandEnvTest: the value of the environment variable printed with %v: %v\n", format, s)
+		}
+	}
+
+	m.Env = nil
+	m.WorkDir = filepath.Join(tmpDir, "no-such-dir")
+	if got := send(m); !strings.Contains(got, "invalid working directory") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandEnvTest: want an invalid working directory error, got %q\n", got)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQCliCommandEnvTest\n")
+	return nil
+}
+
+// Check that the stdin of the message is given to the command run by
+// REQCliCommand and REQCliCommandCont.
+func checkREQCliCommandStdinTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	send := func(m Message) string {
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+		select {
+		case b := <-stewardServer.errorKernel.testCh:
+			return string(b)
+		case <-time.After(time.Second * 10):
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandStdinTest: no reply received for %v\n", m.Method)
+		}
+		return ""
+	}
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQCliCommand,
+		MethodArgs:    []string{"cat"},
+		ReplyMethod:   REQTest,
+		MethodTimeout: 5,
+		Stdin:         "piped through cat",
+	}
+	if got := strings.TrimSpace(send(m)); got != "piped through cat" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandStdinTest: REQCliCommand: want %q, got %q\n", "piped through cat", got)
+	}
+
+	// A stdin larger than the pipe buffers must not block the command.
+	m.MethodArgs = []string{"wc", "-c"}
+	m.Stdin = strings.Repeat("x", 256*1024)
+	if got := strings.TrimSpace(send(m)); got != "262144" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandStdinTest: large stdin: want %q, got %q\n", "262144", got)
+	}
+
+	m.Method = REQCliCommandCont
+	m.MethodArgs = []string{"cat"}
+	m.Stdin = "piped through cat cont\n"
+	if got := strings.TrimSpace(send(m)); got != "piped through cat cont" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandStdinTest: REQCliCommandCont: want %q, got %q\n", "piped through cat cont", got)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQCliCommandStdinTest\n")
+	return nil
+}
+
+// Check that a message can be published and handled using the in-memory
+// transport, without a nats server.
+func checkMemoryTransportTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	mt := newMemoryTransport()
+	defer mt.close()
+
+	sub := newSubject(REQTest, "central")
+	stewardServer.processes.active.mu.Lock()
+	proc := stewardServer.processes.active.procNames[processNameGet(sub.name(), processKindSubscriber)]
+	stewardServer.processes.active.mu.Unlock()
+
+	proc.transport = mt
+	subscription := proc.subscribeMessages()
+	if subscription == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMemoryTransportTest: failed to subscribe\n")
+	}
+
+	m := Message{
+		ID:         2000000,
+		ToNode:     "central",
+		FromNode:   "central",
+		Method:     REQTest,
+		Data:       []byte("memory transport"),
+		ACKTimeout: 10,
+		Retries:    1,
+		done:       make(chan struct{}, 1),
+	}
+	go proc.publishAMessage(m, nil, sync.Once{}, mt)
+
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		if string(b) != "memory transport" {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkMemoryTransportTest: want the message data, got: %s\n", b)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMemoryTransportTest: the message was not handled\n")
+	}
+
+	// The publisher is done when the ACK is received.
+	select {
+	case <-m.done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMemoryTransportTest: no ACK received\n")
+	}
+
+	// With no subscribers the publisher should be told there are no
+	// responders, instead of waiting for the ACK timeout.
+	err := subscription.unsubscribe()
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMemoryTransportTest: unsubscribe: %v\n", err)
+	}
+	reply, err := mt.subscribeSync("memory.reply")
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMemoryTransportTest: subscribeSync: %v\n", err)
+	}
+	err = mt.publish(&transportMsg{Subject: "memory", Reply: "memory.reply"})
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMemoryTransportTest: publish: %v\n", err)
+	}
+	if _, err := reply.nextMsg(time.Second); err != errNoResponders {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMemoryTransportTest: want errNoResponders, got: %v\n", err)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkMemoryTransportTest\n")
+	return nil
+}
+
+// Check that binary data given in a message, here a png image, is
+// written verbatim to file by REQToFile, both when the data is given
+// as a json array of bytes and as base64 with the dataBase64 field.
+func checkREQToFileBinaryTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	defer os.RemoveAll(filepath.Join(conf.SubscribersDataFolder, "binary"))
+
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < 16; i++ {
+		img.Set(i, i, color.RGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToFileBinaryTest: png encode failed: %v\n", err)
+	}
+	want := buf.Bytes()
+
+	jsonData, err := json.Marshal(func() []int {
+		ints := make([]int, len(want))
+		for i, b := range want {
+			ints[i] = int(b)
+		}
+		return ints
+	}())
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToFileBinaryTest: %v\n", err)
+	}
+
+	tests := []struct {
+		fileName string
+		msg      string
+	}{
+		{
+			fileName: "json.png",
+			msg:      fmt.Sprintf(`[{"toNode":"central","fromNode":"central","method":"REQToFile","directory":"binary","fileName":"json.png","data":%s}]`, jsonData),
+		},
+		{
+			fileName: "yaml.png",
+			msg:      fmt.Sprintf("- toNode: central\n  fromNode: central\n  method: REQToFile\n  directory: binary\n  fileName: yaml.png\n  dataBase64: %s\n", base64.StdEncoding.EncodeToString(want)),
+		},
+	}
+
+	for _, tt := range tests {
+		sams, err := stewardServer.convertBytesToSAMs([]byte(tt.msg))
+		if err != nil || len(sams) != 1 {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToFileBinaryTest: %v: failed to parse message: %v\n", tt.fileName, err)
+		}
+		stewardServer.toRingBufferCh <- sams
+
+		file := filepath.Join(conf.SubscribersDataFolder, "binary", "central", tt.fileName)
+		var got []byte
+		for i := 0; i < 50; i++ {
+			got, err = os.ReadFile(file)
+			if err == nil && len(got) == len(want) {
+				break
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+
+		if !bytes.Equal(got, want) {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToFileBinaryTest: %v: the bytes on disk differ from the png sent, got %v bytes, want %v bytes\n", tt.fileName, len(got), len(want))
+		}
+		if _, err := png.Decode(bytes.NewReader(got)); err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToFileBinaryTest: %v: the file written is not a valid png: %v\n", tt.fileName, err)
+		}
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQToFileBinaryTest\n")
+	return nil
+}
+
+// Check that the pong reply to a ping gives the round-trip latency,
+// both in the file written and in the metrics.
+func checkREQPingRoundTripTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	defer os.RemoveAll(filepath.Join(conf.SubscribersDataFolder, "ping"))
+
+	m := Message{
+		ToNode:      "central",
+		FromNode:    "central",
+		Method:      REQPing,
+		ReplyMethod: REQPong,
+		Directory:   "ping",
+		FileName:    "ping.result",
+		ACKTimeout:  5,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQPingRoundTripTest: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	// The ping and the pong are written to the same file, so wait for
+	// the pong.
+	file := filepath.Join(conf.SubscribersDataFolder, "ping", "central", "ping.result")
+	var b []byte
+	for i := 0; i < 50; i++ {
+		b, _ = os.ReadFile(file)
+		if strings.Contains(string(b), "pong received") {
+			break
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if !strings.Contains(string(b), "pong received from central, round-trip latency ") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQPingRoundTripTest: want the round-trip latency in the pong file, got: %q\n", b)
+	}
+
+	mfs, err := stewardServer.metrics.promRegistry.Gather()
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQPingRoundTripTest: gather metrics: %v\n", err)
+	}
+	var count uint64
+	for _, mf := range mfs {
+		if mf.GetName() != "steward_ping_round_trip_seconds" {
+			continue
+		}
+		for _, metric := range mf.GetMetric() {
+			for _, l := range metric.GetLabel() {
+				if l.GetName() == "node" && l.GetValue() == "central" {
+					count = metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	if count == 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQPingRoundTripTest: no round-trip latency observed for central\n")
+	}
+
+	// A pong to a ping without the time it was sent, or sent before
+	// the node was started, gives no latency.
+	proc := process{server: stewardServer}
+	if _, ok := pingRoundTrip(proc, Message{PreviousMessage: &Message{}}); ok {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQPingRoundTripTest: got a latency for a ping without a send time\n")
+	}
+	old := stewardServer.startedAt.Add(-time.Hour).UnixNano()
+	if _, ok := pingRoundTrip(proc, Message{PreviousMessage: &Message{PingSentAt: old}}); ok {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQPingRoundTripTest: got a latency for a ping sent before the node was started\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQPingRoundTripTest\n")
+	return nil
+}
+
+// methodCountTest is a method handler counting the messages handled.
+type methodCountTest struct {
+	n *int32
+}
+
+func (m methodCountTest) getKind() Event { return EventACK }
+
+func (m methodCountTest) isReadOnly() bool { return true }
+
+func (m methodCountTest) handler(proc process, message Message, node string) ([]byte, error) {
+	atomic.AddInt32(m.n, 1)
+	return []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID)), nil
+}
+
+// Check that the messages from a node above its rate limit are dropped,
+// counted by node, and reported once to central.
+func checkInboundRateLimitTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	c := *conf
+	c.InboundRateLimit = 0
+	c.InboundRateLimitNodes = "flooder=1:1,idle=5"
+	limits, err := newInboundRateLimits(&c)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkInboundRateLimitTest: %v\n", err)
+	}
+
+	// The bucket of a node holds a second worth of messages and the
+	// burst, and is filled up again with the rate.
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := limits.allow("flooder", now); !ok {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkInboundRateLimitTest: message %v within the burst was dropped\n", i)
+		}
+	}
+	if ok, report := limits.allow("flooder", now); ok || !report {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkInboundRateLimitTest: want the first message above the limit dropped and reported, got ok=%v, report=%v\n", ok, report)
+	}
+	if ok, report := limits.allow("flooder", now); ok || report {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkInboundRateLimitTest: want the next message dropped without a report, got ok=%v, report=%v\n", ok, report)
+	}
+	if ok, _ := limits.allow("flooder", now.Add(time.Second)); !ok {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkInboundRateLimitTest: message dropped after the bucket was filled again\n")
+	}
+	for i := 0; i < 100; i++ {
+		if ok, _ := limits.allow("unlimited", now); !ok {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkInboundRateLimitTest: message from a node without a limit was dropped\n")
+		}
+	}
+
+	// The buckets of the nodes idle long enough for the bucket to be full
+	// again are removed, while the bucket of a node still limited is kept.
+	limits.allow("flooder", now.Add(time.Second))
+	limits.allow("flooder", now.Add(time.Second))
+	limits.allow("idle", now.Add(time.Second))
+	limits.allow("flooder", now.Add(bucketSweepInterval))
+	limits.mu.Lock()
+	_, flooderFound := limits.buckets["flooder"]
+	_, idleFound := limits.buckets["idle"]
+	limits.mu.Unlock()
+	if !flooderFound || idleFound {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkInboundRateLimitTest: want only the idle buckets removed, got flooder=%v, idle=%v\n", flooderFound, idleFound)
+	}
+
+	for _, v := range []string{"flooder", "flooder=x", "flooder=1:-1"} {
+		c.InboundRateLimitNodes = v
+		if _, err := newInboundRateLimits(&c); err == nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkInboundRateLimitTest: want error for inboundRateLimitNodes %q\n", v)
+		}
+	}
+
+	// Messages from the flooding node should be dropped before the
+	// handler is called.
+	c.InboundRateLimitNodes = "flooder=1:1"
+	limits, _ = newInboundRateLimits(&c)
+	orig := stewardServer.inboundRateLimits
+	stewardServer.inboundRateLimits = limits
+	defer func() { stewardServer.inboundRateLimits = orig }()
+
+	var handled int32
+	flood := func(n int) {
+		for i := 0; i < n; i++ {
+			m := Message{ID: 5000 + i, ToNode: "central", FromNode: "flooder", Method: "REQCountTest"}
+			stewardServer.processInitial.callHandler(m, methodCountTest{n: &handled}, "central")
+		}
+	}
+	dropped := func(label string) float64 {
+		mfs, err := stewardServer.metrics.promRegistry.Gather()
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkInboundRateLimitTest: gather metrics: %v\n", err)
+		}
+		for _, mf := range mfs {
+			if mf.GetName() != "steward_inbound_messages_rate_limited_total" {
+				continue
+			}
+			for _, metric := range mf.GetMetric() {
+				for _, l := range metric.GetLabel() {
+					if l.GetName() == "node" && l.GetValue() == label {
+						return metric.GetCounter().GetValue()
+					}
+				}
+			}
+		}
+		return 0
+	}
+
+	flood(5)
+	if handled != 2 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkInboundRateLimitTest: want 2 messages handled, got %v\n", handled)
+	}
+
+	// We have no public key for the flooder, so the drops are counted as
+	// other, and not with a label of their own.
+	if n := dropped(rateLimitedOtherNodes); n != 3 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkInboundRateLimitTest: want 3 dropped messages counted for other, got %v\n", n)
+	}
+	if n := dropped("flooder"); n != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkInboundRateLimitTest: want no label for a node without a public key, got %v\n", n)
+	}
+
+	// When we have the public key the drops are counted for the node.
+	stewardServer.nodeAuth.publicKeys.mu.Lock()
+	stewardServer.nodeAuth.publicKeys.keysAndHash.Keys["flooder"] = []byte("flooderkey")
+	stewardServer.nodeAuth.publicKeys.mu.Unlock()
+	defer func() {
+		stewardServer.nodeAuth.publicKeys.mu.Lock()
+		delete(stewardServer.nodeAuth.publicKeys.keysAndHash.Keys, "flooder")
+		stewardServer.nodeAuth.publicKeys.mu.Unlock()
+	}()
+	flood(1)
+	if n := dropped("flooder"); n != 1 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkInboundRateLimitTest: want 1 dropped message counted for flooder, got %v\n", n)
+	}
+
+	resultFile := filepath.Join(conf.SubscribersDataFolder, "errorLog", "errorCentral", "error.log")
+	var found bool
+	for i := 0; i < 10 && !found; i++ {
+		found, _ = findStringInFileTest("rate limit exceeded for node flooder", resultFile, conf, t)
+		if !found {
+			time.Sleep(time.Millisecond * 500)
+		}
+	}
+	if !found {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkInboundRateLimitTest: the drop was not reported in the error log\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkInboundRateLimitTest\n")
+	return nil
+}
+
+// Check that the node groups and "*" in toNodes are expanded into the
+// nodes they hold, and that an unknown group drops the message.
+func checkToNodesExpandTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	ca := stewardServer.centralAuth
+	ca.groupNodesAddNode("grp_nodes_expand1", "expandship1")
+	ca.groupNodesAddNode("grp_nodes_expand1", "expandship2")
+	ca.groupNodesAddNode("grp_nodes_expand2", "expandship2")
+	ca.groupNodesAddNode("grp_nodes_expand2", "expandship3")
+	defer ca.groupNodesDeleteGroup("grp_nodes_expand1")
+	defer ca.groupNodesDeleteGroup("grp_nodes_expand2")
+
+	m := Message{
+		ToNodes: []Node{"grp_nodes_expand1", "grp_nodes_expand2", "expandship4", "expandship1"},
+		Method:  REQTest,
+	}
+	msgs := stewardServer.checkMessageToNodes([]Message{m})
+	got := []Node{}
+	for _, v := range msgs {
+		got = append(got, v.ToNode)
+	}
+	if fmt.Sprint(got) != "[expandship1 expandship2 expandship3 expandship4]" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkToNodesExpandTest: want each node once, got: %v\n", got)
+	}
+
+	// "*" is all the nodes with a public key.
+	ca.pki.nodesAcked.mu.Lock()
+	ca.pki.nodesAcked.keysAndHash.Keys["expandkeynode"] = []byte("key")
+	ca.pki.nodesAcked.mu.Unlock()
+	defer func() {
+		ca.pki.nodesAcked.mu.Lock()
+		delete(ca.pki.nodesAcked.keysAndHash.Keys, "expandkeynode")
+		ca.pki.nodesAcked.mu.Unlock()
+	}()
+
+	nodes, err := stewardServer.expandToNodes([]Node{"*"})
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkToNodesExpandTest: %v\n", err)
+	}
+	var found bool
+	for _, n := range nodes {
+		if n == "expandkeynode" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkToNodesExpandTest: want the node with a key in *, got: %v\n", nodes)
+	}
+
+	m.ToNodes = []Node{"expandship4", "grp_nodes_expandnosuch"}
+	msgs = stewardServer.checkMessageToNodes([]Message{m})
+	if len(msgs) != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkToNodesExpandTest: want the message with an unknown group dropped, got: %v\n", msgs)
+	}
+
+	resultFile := filepath.Join(conf.SubscribersDataFolder, "errorLog", "errorCentral", "error.log")
+	found = false
+	for i := 0; i < 10 && !found; i++ {
+		found, _ = findStringInFileTest("no such node group: grp_nodes_expandnosuch", resultFile, conf, t)
+		if !found {
+			time.Sleep(time.Millisecond * 500)
+		}
+	}
+	if !found {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkToNodesExpandTest: the unknown group was not reported in the error log\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkToNodesExpandTest\n")
+	return nil
+}
+
+func checkREQProcessMetricsTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	defer os.RemoveAll(filepath.Join(conf.SubscribersDataFolder, "processmetrics"))
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQProcessMetrics,
+		MethodTimeout: 5,
+		ReplyMethod:   REQToFile,
+		Directory:     "processmetrics",
+		FileName:      "metrics.json",
+		ACKTimeout:    5,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQProcessMetricsTest: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	file := filepath.Join(conf.SubscribersDataFolder, "processmetrics", "central", "metrics.json")
+	var pms []processMetrics
+	for i := 0; i < 50; i++ {
+		b, _ := os.ReadFile(file)
+		if json.Unmarshal(b, &pms) == nil && len(pms) > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	// The subscriber have received at least the message asking for
+	// the metrics.
+	var found bool
+	for _, pm := range pms {
+		if pm.Subject == "central.REQProcessMetrics.EventACK" && pm.Kind == processKindSubscriber {
+			found = true
+			if pm.Messages < 1 || pm.LastActivity.IsZero() {
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkREQProcessMetricsTest: want the message received counted, got: %+v\n", pm)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQProcessMetricsTest: no REQProcessMetrics subscriber in reply: %+v\n", pms)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQProcessMetricsTest\n")
+	return nil
+}
+
+func checkREQToConsoleLevelTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	if got := formatConsole([]byte("a\nb\n"), "ship1: ", ""); got != "ship1: a\nship1: b" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToConsoleLevelTest: want the prefix on every line, got: %q\n", got)
+	}
+
+	warn := consoleLevels[consoleLevelWarn]
+	want := warn.color + "x" + consoleColorReset
+	if got := formatConsole([]byte("x"), "", warn.color); got != want {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToConsoleLevelTest: want %q, got: %q\n", want, got)
+	}
+
+	if consoleLevels[""].stderr || consoleLevels[consoleLevelInfo].stderr || !warn.stderr || !consoleLevels[consoleLevelError].stderr {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToConsoleLevelTest: want only warn and error written to stderr\n")
+	}
+
+	// The output of the tests is not a terminal, so no colors are used.
+	f, err := os.Create(filepath.Join(tmpDir, "console.out"))
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToConsoleLevelTest: %v\n", err)
+	}
+	defer f.Close()
+	if isTerminal(f) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToConsoleLevelTest: want a file not seen as a terminal\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQToConsoleLevelTest\n")
+	return nil
+}
+
+func checkREQCopyFileResumeTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	srcFile := filepath.Join(tmpDir, "resumesrc.bin")
+	dstFile := filepath.Join(tmpDir, "resumedst", "resume.bin")
+
+	// Big enough to be sent in more than one chunk.
+	content := make([]byte, copyFileChunkSize*2+copyFileChunkSize/2)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	err := os.WriteFile(srcFile, content, 0600)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyFileResumeTest: %v\n", err)
+	}
+	err = os.MkdirAll(filepath.Dir(dstFile), 0700)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyFileResumeTest: %v\n", err)
+	}
+
+	// Receive the first half of the file, as if the transfer was
+	// stopped there.
+	half := int64(len(content) / 2)
+	c := copyFileChunk{
+		srcPath: srcFile,
+		dstNode: "central",
+		dstPath: dstFile,
+		srcNode: "central",
+		data:    content[:half],
+	}
+	received, written, err := writeCopyFileChunk(c, 0600)
+	if err != nil || !written || received != half {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyFileResumeTest: write first half: received %v, written %v, %v\n", received, written, err)
+	}
+	if _, err := os.Stat(dstFile); !os.IsNotExist(err) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyFileResumeTest: want no destination file before all is received, got: %v\n", err)
+	}
+
+	// A chunk after a missing chunk is not written.
+	c.offset = half + 10
+	if _, _, err := writeCopyFileChunk(c, 0600); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyFileResumeTest: want an error for a chunk after a missing chunk\n")
+	}
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQCopyFileResume,
+		MethodArgs:    []string{"central", srcFile, dstFile},
+		MethodTimeout: 5,
+		ReplyMethod:   REQNone,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyFileResumeTest: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	for i := 0; ; i++ {
+		b, err := os.ReadFile(dstFile)
+		if err == nil && bytes.Equal(b, content) {
+			break
+		}
+		if i > 100 {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyFileResumeTest: resumed file not equal to the source: %v, got %v bytes\n", err, len(b))
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if _, err := os.Stat(dstFile + copyFilePartialSuffix); !os.IsNotExist(err) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCopyFileResumeTest: want the partial file renamed, got: %v\n", err)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQCopyFileResumeTest\n")
+	return nil
+}
+
+func checkREQHttpGetTimeoutTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	// A slow server that only returns when the request is cancelled.
+	cancelled := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(time.Second * 10):
+		}
+	}))
+	defer ts.Close()
+
+	url := ts.URL + "/slow"
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQHttpGet,
+		MethodArgs:    []string{url},
+		MethodTimeout: 1,
+		ReplyMethod:   REQNone,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQHttpGetTimeoutTest: newSubjectAndMessage : %v\n", err)
+	}
+	start := time.Now()
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second * 5):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQHttpGetTimeoutTest: the request was not cancelled at the method timeout\n")
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQHttpGetTimeoutTest: the request was cancelled before the method timeout, after %v\n", elapsed)
+	}
+
+	resultFile := filepath.Join(conf.SubscribersDataFolder, "errorLog", "errorCentral", "error.log")
+	var found bool
+	for i := 0; i < 10 && !found; i++ {
+		found, _ = findStringInFileTest("methodREQHttpGet: timed out after", resultFile, conf, t)
+		if found {
+			found, _ = findStringInFileTest("getting "+url, resultFile, conf, t)
+		}
+		if !found {
+			time.Sleep(time.Millisecond * 500)
+		}
+	}
+	if !found {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQHttpGetTimeoutTest: no timeout error with the url in the error log\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQHttpGetTimeoutTest\n")
+	return nil
+}
+
+func checkREQSubjectSubscribeListTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQSubjectSubscribeList,
+		MethodTimeout: 5,
+		ReplyMethod:   REQTest,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSubjectSubscribeListTest: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	result := <-stewardServer.errorKernel.testCh
+
+	var subs []subjectSubscription
+	err = json.Unmarshal(result, &subs)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSubjectSubscribeListTest: failed to unmarshal reply: %v, %s\n", err, result)
+	}
+
+	var found bool
+	for _, s := range subs {
+		if s.Subject == "central.REQHttpGet.EventACK" {
+			found = s.Node == "central" && s.Method == REQHttpGet && s.Event == EventACK
+		}
+	}
+	if !found {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSubjectSubscribeListTest: want the REQHttpGet subscriber listed, got: %+v\n", subs)
+	}
+
+	// Only the subscribers are listed.
+	stewardServer.processes.active.mu.Lock()
+	defer stewardServer.processes.active.mu.Unlock()
+	for _, s := range subs {
+		if _, ok := stewardServer.processes.active.procNames[processNameGet(s.Subject, processKindSubscriber)]; !ok {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQSubjectSubscribeListTest: %v is not a subscriber\n", s.Subject)
+		}
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQSubjectSubscribeListTest\n")
+	return nil
+}
+
+func checkErrorAggregateTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	sent := make(chan errorEvent, 10)
+	a := newErrorAggregator(time.Millisecond*300, func(ev errorEvent) { sent <- ev })
+
+	ev := func(node Node, er string) errorEvent {
+		return errorEvent{
+			err:       fmt.Errorf("%v", er),
+			errorType: errTypeSendError,
+			process:   process{node: node},
+		}
+	}
+
+	if !a.first(ev("ship1", "error: delivery of message 1 failed")) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkErrorAggregateTest: want the first error sent\n")
+	}
+	// Errors only differing in the numbers are the same.
+	if a.first(ev("ship1", "error: delivery of message 2 failed")) || a.first(ev("ship1", "error: delivery of message 3 failed")) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkErrorAggregateTest: want the repeated errors counted, not sent\n")
+	}
+	if !a.first(ev("ship2", "error: delivery of message 1 failed")) || !a.first(ev("ship1", "error: other")) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkErrorAggregateTest: want the errors from other nodes, and other errors, sent\n")
+	}
+
+	select {
+	case got := <-sent:
+		want := "error: delivery of message 3 failed (repeated 2 times in the last 300ms)"
+		if got.err.Error() != want || got.process.node != "ship1" {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkErrorAggregateTest: want %q from ship1, got %q from %v\n", want, got.err, got.process.node)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkErrorAggregateTest: no aggregated error sent when the window closed\n")
+	}
+
+	// Only the repeated error is sent again when the windows close.
+	select {
+	case got := <-sent:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkErrorAggregateTest: want no more errors sent, got %v\n", got.err)
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	// A new window starts after the last one closed.
+	if !a.first(ev("ship1", "error: delivery of message 4 failed")) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkErrorAggregateTest: want the error sent after the window closed\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkErrorAggregateTest\n")
+	return nil
+}
+
+// Check that central finds the nodes that have stopped saying hello,
+// and that they are only reported once until they say hello again.
+func checkHelloNodesStaleTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	h := newHelloNodes()
+	now := time.Now()
+	timeout := time.Second * 90
+
+	h.seen("ship1", now.Add(-time.Second*100))
+	h.seen("ship2", now.Add(-time.Second*30))
+	h.seen("ship3", now.Add(-time.Second*120))
+
+	if got := h.check(now, timeout); fmt.Sprint(got) != "[ship1 ship3]" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkHelloNodesStaleTest: want ship1 and ship3 stale, got %v\n", got)
+	}
+	if got := h.check(now, timeout); len(got) != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkHelloNodesStaleTest: want the stale nodes only reported once, got %v\n", got)
+	}
+	if h.staleCount() != 2 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkHelloNodesStaleTest: want 2 stale nodes, got %v\n", h.staleCount())
+	}
+
+	if !h.seen("ship1", now) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkHelloNodesStaleTest: want ship1 to be back\n")
+	}
+	if h.seen("ship2", now) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkHelloNodesStaleTest: want ship2 not to be back, since it was never stale\n")
+	}
+	if h.staleCount() != 1 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkHelloNodesStaleTest: want 1 stale node, got %v\n", h.staleCount())
+	}
+
+	if got := h.check(now.Add(time.Second*91), timeout); fmt.Sprint(got) != "[ship1 ship2]" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkHelloNodesStaleTest: want ship1 and ship2 stale again, got %v\n", got)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkHelloNodesStaleTest\n")
+	return nil
+}
+
+// Check that a message read is given a correlation ID shared by the
+// copies for each of its toNodes, and that it is copied to the reply
+// and put in the errors of the message.
+func checkCorrelationIDTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	js := `[{"toNodes":["ship1","ship2"],"method":"REQHello"},{"toNode":"ship1","method":"REQHello","correlationID":"given-id"}]`
+	sams, err := stewardServer.convertBytesToSAMs([]byte(js))
+	if err != nil || len(sams) != 3 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkCorrelationIDTest: want 3 messages, got %v, err: %v\n", len(sams), err)
+	}
+	if id := sams[0].Message.CorrelationID; id == "" || id != sams[1].Message.CorrelationID {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkCorrelationIDTest: want the same correlation ID for all toNodes, got %q and %q\n", id, sams[1].Message.CorrelationID)
+	}
+	if id := sams[2].Message.CorrelationID; id != "given-id" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkCorrelationIDTest: want the given correlation ID kept, got %q\n", id)
+	}
+
+	proc := stewardServer.processInitial
+	replyCh := make(chan []subjectAndMessage, 1)
+	proc.toRingbufferCh = replyCh
+	newReplyMessage(proc, Message{ToNode: "central", FromNode: "central", Method: REQCliCommand, ReplyMethod: REQTest, CorrelationID: "reply-id"}, nil)
+	reply := <-replyCh
+	if id := reply[0].Message.CorrelationID; id != "reply-id" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkCorrelationIDTest: want the correlation ID copied to the reply, got %q\n", id)
+	}
+
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQCliCommand,
+		MethodArgs:    []string{"true"},
+		MethodTimeout: 5,
+		WorkDir:       filepath.Join(tmpDir, "no-such-correlation-dir"),
+		CorrelationID: "error-id-4711",
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	errLog := filepath.Join(conf.SubscribersDataFolder, "errorLog", "errorCentral", "error.log")
+	want := "correlationID: error-id-4711, error: methodREQCliCommand: invalid working directory"
+	var found bool
+	for i := 0; i < 10 && !found; i++ {
+		found, _ = findStringInFileTest(want, errLog, conf, t)
+		if !found {
+			time.Sleep(time.Millisecond * 500)
+		}
+	}
+	if !found {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkCorrelationIDTest: want %q in the error log\n", want)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkCorrelationIDTest\n")
+	return nil
+}
+
+// Check that the keys and acl delivered with a hash not matching them
+// are rejected and not persisted.
+func checkDeliverUpdateHashTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	errLog := filepath.Join(conf.SubscribersDataFolder, "errorLog", "errorCentral", "error.log")
+	waitForError := func(want string) {
+		var found bool
+		for i := 0; i < 10 && !found; i++ {
+			found, _ = findStringInFileTest(want, errLog, conf, t)
+			if !found {
+				time.Sleep(time.Millisecond * 500)
+			}
+		}
+		if !found {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkDeliverUpdateHashTest: want %q in the error log\n", want)
+		}
+	}
+	send := func(method Method, data []byte) {
+		m := Message{
+			ToNode:   "central",
+			FromNode: "central",
+			Method:   method,
+			Data:     data,
+		}
+		sam, err := newSubjectAndMessage(m)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+		}
+		stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+	}
+
+	// Keys with a wrong hash.
+	pk := stewardServer.nodeAuth.publicKeys
+	pk.mu.Lock()
+	origKeys := pk.keysAndHash
+	pk.mu.Unlock()
+	origFile, origFileErr := os.ReadFile(pk.filePath)
+
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDeliverUpdateHashTest: failed to generate key: %v\n", err)
+	}
+	kh := newKeysAndHash()
+	kh.Keys["tampered"] = pub
+	kh.Hash = sha256.Sum256([]byte("not the hash of the keys"))
+	js, _ := json.Marshal(kh)
+	send(REQKeysDeliverUpdate, js)
+	waitForError("error: REQKeysDeliverUpdate : rejected the keys delivered from central: the hash of the public keys")
+
+	pk.mu.Lock()
+	_, found := pk.keysAndHash.Keys["tampered"]
+	keysChanged := pk.keysAndHash != origKeys
+	pk.mu.Unlock()
+	if found || keysChanged {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDeliverUpdateHashTest: the keys with a wrong hash were used\n")
+	}
+	file, fileErr := os.ReadFile(pk.filePath)
+	if !bytes.Equal(file, origFile) || (fileErr == nil) != (origFileErr == nil) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDeliverUpdateHashTest: the keys with a wrong hash were persisted\n")
+	}
+
+	// Acl with a wrong hash.
+	na := stewardServer.nodeAuth.nodeAcl
+	na.mu.Lock()
+	origACLHash := na.aclAndHash.Hash
+	na.mu.Unlock()
+
+	acl := map[Node]map[command]struct{}{"tampered": {"*": struct{}{}}}
+	cb, err := cbor.Marshal(acl)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDeliverUpdateHashTest: failed to marshal acl: %v\n", err)
+	}
+	js, _ = json.Marshal(HostACLsSerializedWithHash{Data: cb, Hash: sha256.Sum256([]byte("not the hash of the acl"))})
+	send(REQAclDeliverUpdate, js)
+	waitForError("error: subscriber REQAclDeliverUpdate : rejected the acl delivered from central: the hash of the acl")
+
+	na.mu.Lock()
+	_, found = na.aclAndHash.Acl["tampered"]
+	aclChanged := na.aclAndHash.Hash != origACLHash
+	na.mu.Unlock()
+	if found || aclChanged {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDeliverUpdateHashTest: the acl with a wrong hash was used\n")
+	}
+
+	// The hash calculated by central for an acl is valid for the acl
+	// received by the host.
+	hash, err := hashACL("central", acl)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDeliverUpdateHashTest: failed to hash acl: %v\n", err)
+	}
+	received := make(map[Node]map[command]struct{})
+	if err := cbor.Unmarshal(cb, &received); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDeliverUpdateHashTest: failed to unmarshal acl: %v\n", err)
+	}
+	if err := verifyACLHash("central", received, hash); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkDeliverUpdateHashTest: want the acl verified, got: %v\n", err)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkDeliverUpdateHashTest\n")
+	return nil
+}
+
+// Check that the public keys file is replaced atomically, so a write
+// stopped halfway leaves the previous keys, and that an empty file is
+// loaded as no keys.
+func checkPublicKeysAtomicWriteTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	c := *conf
+	c.DatabaseFolder = filepath.Join(tmpDir, "atomic-keys")
+	err := os.MkdirAll(c.DatabaseFolder, 0700)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkPublicKeysAtomicWriteTest: failed to create folder: %v\n", err)
+	}
+
+	pk := newPublicKeys(&c, stewardServer.logger)
+	pk.keysAndHash.Keys["ship1"] = []byte("key of ship1")
+	pk.keysAndHash.Hash, _ = hashPublicKeys(pk.keysAndHash.Keys)
+	err = pk.saveToFile()
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkPublicKeysAtomicWriteTest: failed to save keys: %v\n", err)
+	}
+
+	// A write stopped halfway leaves a partial temporary file, and the
+	// keys file is not touched.
+	full, _ := json.Marshal(pk.keysAndHash)
+	err = os.WriteFile(filepath.Join(c.DatabaseFolder, ".publickeys.txt.tmp123"), full[:len(full)/2], 0600)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkPublicKeysAtomicWriteTest: failed to write partial file: %v\n", err)
+	}
+
+	loaded := newPublicKeys(&c, stewardServer.logger)
+	if string(loaded.keysAndHash.Keys["ship1"]) != "key of ship1" || loaded.keysAndHash.Hash != pk.keysAndHash.Hash {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkPublicKeysAtomicWriteTest: want the previous keys loaded, got %+v\n", loaded.keysAndHash)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(c.DatabaseFolder, ".publickeys.txt.tmp*"))
+	err = pk.saveToFile()
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkPublicKeysAtomicWriteTest: failed to save keys: %v\n", err)
+	}
+	if after, _ := filepath.Glob(filepath.Join(c.DatabaseFolder, ".publickeys.txt.tmp*")); len(after) != len(files) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkPublicKeysAtomicWriteTest: want no temporary file left after saving, got %v\n", after)
+	}
+
+	err = os.WriteFile(pk.filePath, nil, 0600)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkPublicKeysAtomicWriteTest: failed to empty file: %v\n", err)
+	}
+	err = loaded.loadFromFile()
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkPublicKeysAtomicWriteTest: want an empty file loaded without error, got: %v\n", err)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkPublicKeysAtomicWriteTest\n")
+	return nil
+}
+
+// Check that REQCliCommand kills the child processes of the command
+// when the method timeout is reached, and replies that it was killed.
+func checkREQCliCommandKillGroupTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	m := Message{
+		ToNode:        "central",
+		FromNode:      "central",
+		Method:        REQCliCommand,
+		MethodArgs:    []string{"bash", "-c", "sleep 60 & echo $!; wait"},
+		ReplyMethod:   REQTest,
+		MethodTimeout: 1,
+	}
+	sam, err := newSubjectAndMessage(m)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: newSubjectAndMessage : %v\n", err)
+	}
+	stewardServer.toRingBufferCh <- []subjectAndMessage{sam}
+
+	var reply string
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		reply = string(b)
+	case <-time.After(time.Second * 10):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandKillGroupTest: no reply received\n")
+	}
+
+	lines := strings.Split(strings.TrimSpace(reply), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], "killed the command and its child processes") {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandKillGroupTest: want the output and a killed line, got %q\n", reply)
+	}
+
+	// The sleep started in the background by the shell must be killed
+	// too, and not be left running. A killed process not yet reaped is a
+	// zombie.
+	var alive bool
+	for i := 0; i < 10; i++ {
+		stat, err := os.ReadFile(filepath.Join("/proc", lines[0], "stat"))
+		alive = err == nil && !strings.Contains(string(stat), ") Z ")
+		if !alive {
+			break
+		}
+		time.Sleep(time.Millisecond * 200)
+	}
+	if alive {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandKillGroupTest: the child process %v of the command is still running\n", lines[0])
+	}
+
+	cmd := exec.Command("bash", "-c", "exit 3")
+	killed, err := runCommandGroup(context.Background(), cmd)
+	if killed || err == nil || cmd.ProcessState.ExitCode() != 3 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandKillGroupTest: want exit code 3 and not killed, got killed %v, err %v\n", killed, err)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQCliCommandKillGroupTest\n")
+	return nil
+}
+
+// Check that a message relayed in a loop between 3 nodes is dropped
+// when it gets back to the first node, and that a normal relay is not.
+func checkRelayLoopTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	relayCh := make(chan []subjectAndMessage, 1)
+	proc := stewardServer.processInitial
+	proc.toRingbufferCh = relayCh
+
+	// The relayed message is misconfigured to be relayed again, so it
+	// goes from relay1 to relay2 to relay3 and back to relay1.
+	m := Message{
+		ToNode:              "relay1",
+		FromNode:            "central",
+		Method:              REQRelay,
+		RelayToNode:         "relay2",
+		RelayOriginalMethod: REQRelay,
+		ACKTimeout:          1,
+	}
+	for _, n := range []string{"relay1", "relay2", "relay3"} {
+		methodREQRelay{}.handler(proc, m, n)
+
+		select {
+		case sams := <-relayCh:
+			m = sams[0].Message
+		case <-time.After(time.Second * 5):
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkRelayLoopTest: message not relayed by %v\n", n)
+		}
+	}
+
+	if m.RelayHops != 3 || fmt.Sprint(m.RelayVisited) != "[relay1 relay2 relay3]" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkRelayLoopTest: want 3 hops via relay1, relay2 and relay3, got %v %v\n", m.RelayHops, m.RelayVisited)
+	}
+
+	methodREQRelay{}.handler(proc, m, "relay1")
+	select {
+	case sams := <-relayCh:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkRelayLoopTest: want the message in a loop dropped, got it relayed to %v\n", sams[0].Message.ToNode)
+	case <-time.After(time.Second * 1):
+	}
+
+	errLog := filepath.Join(conf.SubscribersDataFolder, "errorLog", "errorCentral", "error.log")
+	want := "error: methodREQRelay: relay loop detected, relay1 have already relayed the message, relay path: [relay1 relay2 relay3]"
+	var found bool
+	for i := 0; i < 10 && !found; i++ {
+		found, _ = findStringInFileTest(want, errLog, conf, t)
+		if !found {
+			time.Sleep(time.Millisecond * 500)
+		}
+	}
+	if !found {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkRelayLoopTest: want %q in the error log\n", want)
+	}
+
+	// A normal relay from the node where it originated via one node.
+	n := Message{}
+	if err := relayHop(&n, "node1", 2); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkRelayLoopTest: want the initial relay allowed, got: %v\n", err)
+	}
+	if err := relayHop(&n, "central", 2); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkRelayLoopTest: want the relay via central allowed, got: %v\n", err)
+	}
+	if err := relayHop(&n, "central2", 2); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkRelayLoopTest: want more than 2 hops dropped\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkRelayLoopTest\n")
+	return nil
+}
+
+func checkMessageCallbackTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	if err := stewardServer.RegisterMessageCallback(Method("REQNoSuchMethod"), func(Message, []byte) {}); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCallbackTest: want an error registering an unknown method\n")
+	}
+
+	type called struct {
+		m   Message
+		out []byte
+	}
+	calledCh := make(chan called, 1)
+
+	// The first callback panics, which should not stop the second
+	// from being called.
+	err := stewardServer.RegisterMessageCallback(REQTest, func(m Message, out []byte) {
+		m.Data[0] = 'X'
+		panic("callback test panic")
+	})
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCallbackTest: failed to register callback: %v\n", err)
+	}
+	err = stewardServer.RegisterMessageCallback(REQTest, func(m Message, out []byte) {
+		calledCh <- called{m: m, out: out}
+	})
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCallbackTest: failed to register callback: %v\n", err)
+	}
+
+	proc := stewardServer.processInitial
+	m := Message{
+		ID:       42,
+		ToNode:   "central",
+		FromNode: "central",
+		Method:   REQTest,
+		Data:     []byte("callback data"),
+	}
+	mh, _ := proc.methodsAvailable.CheckIfExists(REQTest)
+	proc.callHandler(m, mh, "central")
+
+	select {
+	case c := <-calledCh:
+		if string(c.m.Data) != "callback data" || c.m.ID != 42 {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCallbackTest: want an unchanged copy of the message, got %v %q\n", c.m.ID, c.m.Data)
+		}
+		if string(c.out) != "confirmed from: central: 42" {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCallbackTest: want the output of the handler, got %q\n", c.out)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCallbackTest: callback not called\n")
+	}
+
+	errLog := filepath.Join(conf.SubscribersDataFolder, "errorLog", "errorCentral", "error.log")
+	want := "callback for method REQTest panicked: callback test panic"
+	var found bool
+	for i := 0; i < 10 && !found; i++ {
+		found, _ = findStringInFileTest(want, errLog, conf, t)
+		if !found {
+			time.Sleep(time.Millisecond * 500)
+		}
+	}
+	if !found {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageCallbackTest: want %q in the error log\n", want)
+	}
+
+	// Remove the callbacks so they are not called by the later tests.
+	stewardServer.messageCallbacks.mu.Lock()
+	delete(stewardServer.messageCallbacks.callbacks, REQTest)
+	stewardServer.messageCallbacks.mu.Unlock()
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkMessageCallbackTest\n")
+	return nil
+}
+
+// Check that the transport pool spreads the messages round robin over
+// its transports, and that a publisher gets one of the transports.
+func checkTransportPoolTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	mt1 := newMemoryTransport()
+	mt2 := newMemoryTransport()
+	pool := newTransportPool([]transport{mt1, mt2})
+
+	if pickTransport(pool) != mt1 || pickTransport(pool) != mt2 || pickTransport(pool) != mt1 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkTransportPoolTest: want the transports picked round robin\n")
+	}
+	if pickTransport(mt1) != mt1 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkTransportPoolTest: want a single transport picked as is\n")
+	}
+
+	var subs []syncSubscription
+	for _, mt := range []*memoryTransport{mt1, mt2} {
+		sub, err := mt.subscribeSync("pool")
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkTransportPoolTest: subscribeSync: %v\n", err)
+		}
+		subs = append(subs, sub)
+	}
+
+	// The next transport picked is mt2, so the messages should go to
+	// mt2 and then mt1.
+	for _, data := range []string{"second", "first"} {
+		err := pool.publish(&transportMsg{Subject: "pool", Data: []byte(data)})
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkTransportPoolTest: publish: %v\n", err)
+		}
+	}
+	for i, want := range []string{"first", "second"} {
+		msg, err := subs[i].nextMsg(time.Second)
+		if err != nil || string(msg.Data) != want {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkTransportPoolTest: want %q on transport %v, got %v, %v\n", want, i+1, msg, err)
+		}
+	}
+
+	pool.close()
+	for i, mt := range []*memoryTransport{mt1, mt2} {
+		if err := mt.publish(&transportMsg{Subject: "pool"}); err != errMemoryTransportClosed {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkTransportPoolTest: want transport %v closed, got %v\n", i+1, err)
+		}
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkTransportPoolTest\n")
+	return nil
+}
+
+// Check that the processes listed with REQOpProcessList can be filtered
+// by kind and subject.
+func checkREQOpProcessListFilterTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	replyCh := make(chan []subjectAndMessage, 1)
+	proc := stewardServer.processInitial
+	proc.toRingbufferCh = replyCh
+
+	list := func(methodArgs ...string) []string {
+		m := Message{
+			ToNode:      "central",
+			FromNode:    "central",
+			Method:      REQOpProcessList,
+			MethodArgs:  methodArgs,
+			ReplyMethod: REQTest,
+		}
+		methodREQOpProcessList{}.handler(proc, m, "central")
+
+		select {
+		case sams := <-replyCh:
+			return strings.Split(strings.TrimSpace(string(sams[0].Message.Data)), "\n")
+		case <-time.After(time.Second * 5):
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQOpProcessListFilterTest: no reply for methodArgs %v\n", methodArgs)
+		}
+		return nil
+	}
+
+	all := list()
+	if len(all) < 2 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQOpProcessListFilterTest: want all the processes without methodArgs, got %v\n", all)
+	}
+
+	tests := []struct {
+		methodArgs []string
+		want       string
+	}{
+		{[]string{"subscriber"}, "process: subscriber,"},
+		{[]string{"", "REQHello"}, "REQHello"},
+		{[]string{"subscriber", "central.REQOpProcess*.EventACK"}, "name: central.REQOpProcess"},
+	}
+	for _, tt := range tests {
+		lines := list(tt.methodArgs...)
+		if len(lines) == 0 || len(lines) >= len(all) {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQOpProcessListFilterTest: want fewer processes than all for %v, got %v\n", tt.methodArgs, lines)
+		}
+		for _, l := range lines {
+			if !strings.Contains(l, tt.want) {
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkREQOpProcessListFilterTest: want %q for %v, got %v\n", tt.want, tt.methodArgs, l)
+			}
+		}
+	}
+
+	// An unknown kind is an error, and no reply is sent.
+	methodREQOpProcessList{}.handler(proc, Message{Method: REQOpProcessList, MethodArgs: []string{"worker"}}, "central")
+	select {
+	case sams := <-replyCh:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQOpProcessListFilterTest: want no reply for an unknown kind, got %s\n", sams[0].Message.Data)
+	case <-time.After(time.Second * 1):
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQOpProcessListFilterTest\n")
+	return nil
+}
+
+// Check that expired messages are dropped by the subscriber before
+// the handler is called, and by the publisher before and while retrying.
+func checkMessageTTLTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	now := time.Now()
+	if m := setExpiresAt(Message{TTL: 10}, now); m.ExpiresAt != now.Add(time.Second*10).UnixNano() {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageTTLTest: want ExpiresAt set from the TTL, got %v\n", m.ExpiresAt)
+	}
+	if m := setExpiresAt(Message{TTL: 10, ExpiresAt: 1}, now); m.ExpiresAt != 1 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageTTLTest: want ExpiresAt given kept, got %v\n", m.ExpiresAt)
+	}
+
+	errLog := filepath.Join(conf.SubscribersDataFolder, "errorLog", "errorCentral", "error.log")
+	findErr := func(want string) {
+		var found bool
+		for i := 0; i < 10 && !found; i++ {
+			found, _ = findStringInFileTest(want, errLog, conf, t)
+			if !found {
+				time.Sleep(time.Millisecond * 500)
+			}
+		}
+		if !found {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageTTLTest: want %q in the error log\n", want)
+		}
+	}
+
+	// An already expired message should not be handled.
+	proc := stewardServer.processInitial
+	m := Message{
+		ToNode:    "central",
+		FromNode:  "ttlnode1",
+		Method:    REQTest,
+		Data:      []byte("expired"),
+		ExpiresAt: time.Now().Add(-time.Minute).UnixNano(),
+	}
+	mh, _ := proc.methodsAvailable.CheckIfExists(REQTest)
+	proc.callHandler(m, mh, "central")
+	select {
+	case b := <-stewardServer.errorKernel.testCh:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageTTLTest: want the expired message dropped, got it handled: %s\n", b)
+	case <-time.After(time.Second * 1):
+	}
+	findErr("error: subscriberHandler: message expired at")
+
+	// A publisher with no one subscribing, so every attempt fails.
+	mt := newMemoryTransport()
+	defer mt.close()
+	pub := newProcess(stewardServer.ctx, stewardServer, newSubject(REQTest, "ttlnode2"), processKindPublisher, nil)
+
+	deliver := func(m Message) time.Duration {
+		start := time.Now()
+		doneCh := make(chan struct{})
+		go func() {
+			pub.messageDeliver([]byte("ttl"), nil, mt, m, nil)
+			close(doneCh)
+		}()
+
+		select {
+		case <-doneCh:
+		case <-time.After(time.Second * 10):
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageTTLTest: want the delivery of message %v stopped when it expired\n", m.ID)
+		}
+		return time.Since(start)
+	}
+
+	// An already expired message should not be sent.
+	if d := deliver(Message{ID: 1, ToNode: "ttlnode2", Method: REQTest, ACKTimeout: 1, Retries: 100, ExpiresAt: time.Now().Add(-time.Minute).UnixNano()}); d > time.Second {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageTTLTest: want the expired message dropped at once, took %v\n", d)
+	}
+	findErr("toNode: ttlnode2, method: REQTest, attempts: 0")
+
+	// A message expiring while retrying should be dropped at the next
+	// attempt, long before the retries are used.
+	if d := deliver(Message{ID: 2, ToNode: "ttlnode2", Method: REQTest, ACKTimeout: 1, Retries: 100, ExpiresAt: time.Now().Add(time.Millisecond * 1500).UnixNano()}); d < time.Millisecond*1500 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageTTLTest: want the message retried until it expired, took %v\n", d)
+	}
+	findErr("toNode: ttlnode2, method: REQTest, attempts: 2")
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkMessageTTLTest\n")
+	return nil
+}
+
+// Check that a message is signed again for each retry, so a message
+// retried for longer than the signature skew is not refused as too old.
+func checkMessageResignTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	mt := newMemoryTransport()
+	defer mt.close()
+	pub := newProcess(stewardServer.ctx, stewardServer, newSubject(REQTest, "resignnode"), processKindPublisher, nil)
+
+	// A subscriber that never replies with an ACK, so every attempt is
+	// retried.
+	payloads := make(chan string, 10)
+	_, err := mt.queueSubscribe(string(pub.subject.name()), string(pub.subject.name()), func(msg *transportMsg) {
+		payloads <- string(msg.Data)
+	})
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageResignTest: subscribe failed: %v\n", err)
+	}
+
+	signed := 0
+	resign := func() ([]byte, error) {
+		signed++
+		return []byte(fmt.Sprintf("signed %v", signed)), nil
+	}
+	pub.messageDeliver([]byte("first"), nil, mt, Message{ID: 1, ToNode: "resignnode", Method: REQErrorLog, ACKTimeout: 1, Retries: 3}, resign)
+
+	for _, want := range []string{"first", "signed 1", "signed 2"} {
+		select {
+		case got := <-payloads:
+			if got != want {
+				t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageResignTest: want payload %q, got %q\n", want, got)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkMessageResignTest: no payload %q delivered\n", want)
+		}
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkMessageResignTest\n")
+	return nil
+}
+
+// Check that REQToFileAppend writes the header only when the file is
+// created, also when appending to the same new file concurrently.
+func checkREQToFileAppendHeaderTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	proc := process{
+		configuration: conf,
+		errorKernel:   stewardServer.errorKernel,
+		server:        stewardServer,
+	}
+
+	const writes = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m := Message{
+				ToNode:     "central",
+				Method:     REQToFileAppend,
+				Directory:  "header",
+				FileName:   "rows.csv",
+				Data:       []byte(fmt.Sprintf("%v,row\n", i)),
+				FileHeader: "id,name",
+			}
+			// Every other message is a reply, with the header given in
+			// the request message.
+			if i%2 == 1 {
+				m.FileHeader = ""
+				m.PreviousMessage = &Message{ToNode: "central", Directory: "header", FileName: "rows.csv", FileHeader: "id,name"}
+			}
+			_, err := methodREQToFileAppend{}.handler(proc, m, "central")
+			if err != nil {
+				t.Errorf(" \U0001F631  [FAILED]\t: checkREQToFileAppendHeaderTest: handler failed: %v\n", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	file := filepath.Join(conf.SubscribersDataFolder, "header", "central", "rows.csv")
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToFileAppendHeaderTest: failed to read %v: %v\n", file, err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != writes+1 || lines[0] != "id,name" || strings.Count(string(b), "id,name") != 1 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToFileAppendHeaderTest: want the header once on the first line and %v rows, got %q\n", writes, b)
+	}
+
+	// Only one of the opens racing to create the same file should be
+	// told it created the file.
+	raceFile := filepath.Join(tmpDir, "header-race.csv")
+	var created int64
+	for i := 0; i < writes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, c, err := openAppendFile(raceFile, 0600)
+			if err != nil {
+				t.Errorf(" \U0001F631  [FAILED]\t: checkREQToFileAppendHeaderTest: openAppendFile failed: %v\n", err)
+				return
+			}
+			f.Close()
+			if c {
+				atomic.AddInt64(&created, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQToFileAppendHeaderTest: want the file created once, got %v\n", created)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQToFileAppendHeaderTest\n")
+	return nil
+}
+
+// Check the line and chunk output modes of REQCliCommandCont.
+func checkREQCliCommandContOutputModeTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	collect := func(outCh chan []byte, done chan struct{}) [][]byte {
+		var outs [][]byte
+		for {
+			select {
+			case b := <-outCh:
+				outs = append(outs, b)
+			case <-done:
+				return outs
+			}
+		}
+	}
+
+	// In line mode every line is sent by itself, a line longer than the
+	// max is sent in pieces, and the last line gets a newline.
+	long := strings.Repeat("x", cliCommandContMaxLineBytes+10)
+	outCh := make(chan []byte)
+	done := make(chan struct{})
+	go func() {
+		streamCommandOutput(ctx, strings.NewReader("one\n"+long+"\nlast"), cliCommandContOutputLine, outCh)
+		close(done)
+	}()
+	outs := collect(outCh, done)
+	want := []string{"one\n", long[:cliCommandContMaxLineBytes], long[cliCommandContMaxLineBytes:] + "\n", "last\n"}
+	if len(outs) != len(want) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandContOutputModeTest: want %v outputs in line mode, got %v\n", len(want), len(outs))
+	}
+	for i := range want {
+		if string(outs[i]) != want[i] {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandContOutputModeTest: want output %v in line mode of %v bytes, got %v bytes\n", i, len(want[i]), len(outs[i]))
+		}
+	}
+
+	// In chunk mode the output without a newline, like a progress bar,
+	// is sent when the interval have passed.
+	pr, pw := io.Pipe()
+	outCh = make(chan []byte)
+	done = make(chan struct{})
+	go func() {
+		streamCommandOutput(ctx, pr, cliCommandContOutputChunk, outCh)
+		close(done)
+	}()
+
+	pw.Write([]byte("10%.."))
+	select {
+	case b := <-outCh:
+		if string(b) != "10%.." {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandContOutputModeTest: want the partial output in chunk mode, got %q\n", b)
+		}
+	case <-time.After(cliCommandContChunkInterval * 4):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandContOutputModeTest: partial output not sent in chunk mode\n")
+	}
+
+	// A full chunk is sent at once, and the rest when the output closes.
+	go func() {
+		pw.Write(bytes.Repeat([]byte("y"), cliCommandContChunkBytes+5))
+		pw.Close()
+	}()
+	outs = collect(outCh, done)
+	if len(outs) != 2 || len(outs[0]) != cliCommandContChunkBytes || string(outs[1]) != "yyyyy" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQCliCommandContOutputModeTest: want a full chunk and the rest in chunk mode, got %v outputs\n", len(outs))
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQCliCommandContOutputModeTest\n")
+	return nil
+}
+
+// Check that the configuration validation finds the problems, and that
+// the server is not created with an invalid configuration.
+func checkConfigurationValidateTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	c := *conf
+	c.TCPListener = "localhost:8888"
+	c.ProfilingPort = "6060"
+	if err := c.Validate(); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkConfigurationValidateTest: want the test configuration valid, got: %v\n", err)
+	}
+
+	// A file where a folder is wanted can't be created as a folder.
+	notFolder := filepath.Join(tmpDir, "validate-not-a-folder")
+	if err := os.WriteFile(notFolder, nil, 0600); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkConfigurationValidateTest: %v\n", err)
+	}
+
+	c.CentralNodeName = ""
+	c.TCPListener = "localhost"
+	c.HTTPListener = "localhost:99999"
+	c.ProfilingPort = "profiling"
+	c.DatabaseFolder = notFolder
+	c.IsStandbyCentral = true
+	c.HTTPListenerCertFile = "cert.pem"
+	err := c.Validate()
+	if err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkConfigurationValidateTest: want the invalid configuration found\n")
+	}
+	for _, want := range []string{"centralNodeName is not set", "tcpListener: invalid address", "httpListener: invalid port", "profilingPort: invalid port", "databaseFolder: folder", "isCentralAuth and isStandbyCentral", "httpListenerCertFile and httpListenerKeyFile"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkConfigurationValidateTest: want %q in the error, got: %v\n", want, err)
+		}
+	}
+
+	if s, err := NewServer(&c, "test"); err == nil || s != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkConfigurationValidateTest: want NewServer to return the validation error\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkConfigurationValidateTest\n")
+	return nil
+}
+
+// Check that the file handlers refuse to write outside of the data
+// folder, and that the copy handlers refuse paths climbing out of a
+// folder or holding null bytes.
+func checkPathTraversalTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	proc := process{
+		configuration: conf,
+		errorKernel:   stewardServer.errorKernel,
+		server:        &server{},
+	}
+
+	tests := []struct {
+		directory string
+		fileName  string
+		toNode    Node
+		ok        bool
+	}{
+		{"logs", "ok.log", "ship1", true},
+		// An absolute directory is joined within the data folder.
+		{"/etc", "passwd", "ship1", true},
+		{"logs", "sub/ok.log", "ship1", true},
+		{"../../etc", "passwd", "ship1", false},
+		{"logs/../../..", "passwd", "ship1", false},
+		{"logs", "../../../passwd", "ship1", false},
+		{"logs", "evil\x00.log", "ship1", false},
+		{"lo\x00gs", "ok.log", "ship1", false},
+		{"logs", "ok.log", "../../..", false},
+	}
+	for _, tt := range tests {
+		m := Message{ToNode: tt.toNode, Directory: tt.directory, FileName: tt.fileName}
+		fileName, folderTree, err := selectFileNaming(m, proc)
+		if (err == nil) != tt.ok {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkPathTraversalTest: directory %q, fileName %q, toNode %q: want ok %v, got error: %v\n", tt.directory, tt.fileName, tt.toNode, tt.ok, err)
+		}
+		if tt.ok && !strings.HasPrefix(filepath.Join(folderTree, fileName), filepath.Clean(conf.SubscribersDataFolder)+string(filepath.Separator)) {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkPathTraversalTest: want %v within the data folder\n", filepath.Join(folderTree, fileName))
+		}
+	}
+
+	// The handler should refuse the message, and not create the file.
+	escaped := filepath.Join(conf.SubscribersDataFolder, "..", "traversal.log")
+	m := Message{ToNode: "central", Method: REQToFile, Directory: "..", FileName: "../traversal.log", Data: []byte("escaped")}
+	if _, err := (methodREQToFile{}).handler(proc, m, "central"); err == nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkPathTraversalTest: want REQToFile to refuse the message\n")
+	}
+	if _, err := os.Stat(escaped); !os.IsNotExist(err) {
+		os.Remove(escaped)
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkPathTraversalTest: file written outside of the data folder\n")
+	}
+
+	for _, dst := range []string{"/tmp/../etc/passwd", "/tmp/evil\x00"} {
+		if _, err := parseCopyFileChunk([]string{"/src", "node1", dst}, nil); err == nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkPathTraversalTest: want REQCopyFileTo to refuse %q\n", dst)
+		}
+		if _, err := parseCopyDirEntry([]string{copyDirKindDir, dst, "755", "0", "0"}, nil); err == nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkPathTraversalTest: want REQCopyDirTo to refuse %q\n", dst)
+		}
+	}
+	if _, err := parseCopyFileChunk([]string{"/src", "node1", "/tmp/dst..file"}, nil); err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkPathTraversalTest: want a file name with dots allowed, got: %v\n", err)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkPathTraversalTest\n")
+	return nil
+}
+
+// Check that REQEnvInfo replies with the commands of the ACL for the
+// node asking only, with the explicit and wildcard grants separated.
+func checkREQEnvInfoTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	proc := stewardServer.processInitial
+	ch := make(chan []subjectAndMessage, 1)
+	proc.toRingbufferCh = ch
+
+	envInfo := func(m Message) aclEnvInfo {
+		_, err := (methodREQEnvInfo{}).handler(proc, m, "central")
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQEnvInfoTest: handler failed: %v\n", err)
+		}
+
+		var reply []subjectAndMessage
+		select {
+		case reply = <-ch:
+		case <-time.After(time.Second * 5):
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQEnvInfoTest: no reply\n")
+		}
+
+		var r aclEnvInfo
+		err = json.Unmarshal(reply[0].Message.Data, &r)
+		if err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQEnvInfoTest: failed to unmarshal reply: %v\n", err)
+		}
+		return r
+	}
+
+	// The ACL received from central by the node.
+	acl := stewardServer.nodeAuth.nodeAcl
+	acl.mu.Lock()
+	saved := acl.aclAndHash.Acl
+	acl.aclAndHash.Acl = map[Node]map[command]struct{}{
+		"operator": {"ls -l": {}, "regex:systemctl status .*": {}, "df -h": {}},
+		"admin":    {"*": {}},
+	}
+	acl.mu.Unlock()
+	defer func() {
+		acl.mu.Lock()
+		acl.aclAndHash.Acl = saved
+		acl.mu.Unlock()
+	}()
+
+	r := envInfo(Message{ToNode: "central", FromNode: "operator", Method: REQEnvInfo, ReplyMethod: REQToConsole})
+	want := aclEnvInfo{
+		Host:     "central",
+		Source:   "operator",
+		Explicit: []command{"df -h", "ls -l"},
+		Wildcard: []command{"regex:systemctl status .*"},
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQEnvInfoTest: want %+v, got %+v\n", want, r)
+	}
+
+	r = envInfo(Message{ToNode: "central", FromNode: "admin", Method: REQEnvInfo, ReplyMethod: REQToConsole})
+	if !r.AllCommands || !reflect.DeepEqual(r.Wildcard, []command{"*"}) || len(r.Explicit) != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQEnvInfoTest: want all commands for admin, got %+v\n", r)
+	}
+
+	r = envInfo(Message{ToNode: "central", FromNode: "stranger", Method: REQEnvInfo, ReplyMethod: REQToConsole})
+	if r.AllCommands || len(r.Explicit) != 0 || len(r.Wildcard) != 0 {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQEnvInfoTest: want nothing for a node not in the acl, got %+v\n", r)
+	}
+
+	// The ACL generated on central for another host.
+	stewardServer.centralAuth.aclAddCommand("envinfohost", "operator", "whoami")
+	stewardServer.centralAuth.aclAddCommand("envinfohost", "admin", "*")
+	defer stewardServer.centralAuth.aclDeleteSource("envinfohost", "operator")
+	defer stewardServer.centralAuth.aclDeleteSource("envinfohost", "admin")
+
+	r = envInfo(Message{ToNode: "central", FromNode: "operator", Method: REQEnvInfo, MethodArgs: []string{"envinfohost"}, ReplyMethod: REQToConsole})
+	want = aclEnvInfo{
+		Host:     "envinfohost",
+		Source:   "operator",
+		Explicit: []command{"whoami"},
+		Wildcard: []command{},
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQEnvInfoTest: want %+v, got %+v\n", want, r)
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQEnvInfoTest\n")
+	return nil
+}
+
+// Check that a node only adopts a new name given by the central with a
+// valid signature, and that the new name is valid.
+func checkREQAdoptNodeNameTest(stewardServer *server, conf *Configuration, t *testing.T, tmpDir string) error {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQAdoptNodeNameTest: failed to generate keys: %v\n", err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQAdoptNodeNameTest: failed to generate keys: %v\n", err)
+	}
+
+	configFolder := filepath.Join(tmpDir, "adoptnodename")
+	os.RemoveAll(configFolder)
+	defer os.RemoveAll(configFolder)
+
+	nodeConf := Configuration{NodeName: "adoptold", CentralNodeName: "central", ConfigFolder: configFolder, SignatureMaxSkew: 300}
+	ch := make(chan []subjectAndMessage, 10)
+	proc := stewardServer.processInitial
+	proc.configuration = &nodeConf
+	proc.toRingbufferCh = ch
+	proc.nodeAuth = &nodeAuth{
+		publicKeys:       &publicKeys{keysAndHash: newKeysAndHash()},
+		signatureMethods: newSignatureMethods(""),
+		nonceCache:       newNonceCache(nonceCacheMaxEntries),
+		configuration:    &nodeConf,
+		errorKernel:      stewardServer.errorKernel,
+	}
+	proc.nodeAuth.publicKeys.keysAndHash.Keys["central"] = pub
+	proc.nodeAuth.publicKeys.keysAndHash.Keys["ship2"] = pub
+
+	adopt := func(id int, from Node, newName string, key ed25519.PrivateKey) {
+		m := Message{ID: id, ToNode: "adoptold", FromNode: from, Method: REQAdoptNodeName, MethodArgs: []string{"adoptold", newName}, ReplyMethod: REQTest, SignedAt: time.Now().Unix(), SignNonce: fmt.Sprint("adoptnonce", id)}
+		m.ArgSignature = ed25519.Sign(key, []byte(signedString(m)))
+		if _, err := (methodREQAdoptNodeName{}).handler(proc, m, "adoptold"); err != nil {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQAdoptNodeNameTest: %v\n", err)
+		}
+	}
+
+	// A rename from another node than the central, a rename without a
+	// valid signature, and renames to invalid names should be refused.
+	adopt(1, "ship2", "adoptnew", priv)
+	adopt(2, "central", "adoptnew", otherPriv)
+	adopt(3, "central", "../adoptnew", priv)
+	adopt(4, "central", "", priv)
+	adopt(5, "central", "adopt new", priv)
+	adopt(6, "central", "adoptnew", priv)
+
+	select {
+	case sams := <-ch:
+		if id := sams[0].Message.PreviousMessage.ID; id != 6 {
+			t.Fatalf(" \U0001F631  [FAILED]\t: checkREQAdoptNodeNameTest: want only the valid rename done, got reply to message %v: %s\n", id, sams[0].Message.Data)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQAdoptNodeNameTest: no reply to the valid rename\n")
+	}
+	select {
+	case sams := <-ch:
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQAdoptNodeNameTest: want the invalid renames refused, got: %s\n", sams[0].Message.Data)
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	written, err := nodeConf.ReadConfigFile(configFolder)
+	if err != nil || written.NodeName != "adoptnew" {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQAdoptNodeNameTest: want the new name in the config file, got: %v, %v\n", written.NodeName, err)
+	}
+
+	if d := newConfigurationDefaults(); d.StartSubREQAdoptNodeName {
+		t.Fatalf(" \U0001F631  [FAILED]\t: checkREQAdoptNodeNameTest: want the subscriber disabled by default\n")
+	}
+
+	t.Logf(" \U0001f600 [SUCCESS]\t: checkREQAdoptNodeNameTest\n")
+	return nil
+}
+
+// Check if file are getting updated with new content.
+func checkFileUpdated(fileRealPath string, fileUpdated chan bool) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Println("Failed fsnotify.NewWatcher")
+		return
+	}
+	defer watcher.Close()
+
+	done := make(chan bool)
+	go func() {
+		//Give a true value to updated so it reads the file the first time.
+		fileUpdated <- true
+		for {
+			select {
+			case event := <-watcher.Events:
+				log.Println("event:", event)
+				if event.Op&fsnotify.Write == fsnotify.Write {
+					log.Println("modified file:", event.Name)
+					//testing with an update chan to get updates
+					fileUpdated <- true
+				}
+			case err := <-watcher.Errors:
+				log.Println("error:", err)
+			}
+		}
+	}()
+
+	err = watcher.Add(fileRealPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	<-done
+}
+
+// Check if a file contains the given string.
+func findStringInFileTest(want string, fileName string, conf *Configuration, t *testing.T) (bool, error) {
+	// Wait n seconds for the results file to be created
+	n := 5
+
+	for i := 0; i <= n; i++ {
+		_, err := os.Stat(fileName)
+		if os.IsNotExist(err) {
+			time.Sleep(time.Millisecond * 500)
+			continue
+		}
+
+		if os.IsNotExist(err) && i >= n {
+			return false, fmt.Errorf(" * failed: no result file created for request within the given time\n")
+		}
+	}
+
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return false, fmt.Errorf(" * failed: could not open result file: %v", err)
+	}
+
+	result, err := io.ReadAll(fh)
+	if err != nil {
+		return false, fmt.Errorf(" * failed: could not read result file: %v", err)
+	}
+
+	found := strings.Contains(string(result), want)
+	if !found {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Write message to socket for testing purposes.
+func writeToSocketTest(conf *Configuration, messageText string, t *testing.T) {
+	socket, err := net.Dial("unix", filepath.Join(conf.SocketFolder, "steward.sock"))
+	if err != nil {
+		t.Fatalf(" * failed: could to open socket file for writing: %v\n", err)
+	}
+	defer socket.Close()
+
+	_, err = socket.Write([]byte(messageText))
+	if err != nil {
+		t.Fatalf(" * failed: could not write to socket: %v\n", err)
+	}
+
+}
+
+// BenchmarkVerifySignature verifies the signature of the same command
+// over and over with verifySignature, as with a hot repeated
+// REQCliCommand. Each message is signed with a new nonce, as done by the
+// publisher, so the replay check is passed.
+func BenchmarkVerifySignature(b *testing.B) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		b.Fatalf("failed to generate keys: %v", err)
+	}
+
+	na := nodeAuth{
+		publicKeys:       &publicKeys{keysAndHash: newKeysAndHash()},
+		signatureMethods: newSignatureMethods(""),
+		nonceCache:       newNonceCache(nonceCacheMaxEntries),
+		configuration:    &Configuration{SignatureMaxSkew: 300},
+		errorKernel:      tstSrv.errorKernel,
+	}
+	na.publicKeys.keysAndHash.Keys["central"] = pub
+
+	msgs := make([]Message, b.N)
+	for i := range msgs {
+		m := Message{
+			ToNode:     "ship1",
+			FromNode:   "central",
+			Method:     REQCliCommand,
+			MethodArgs: []string{"bash", "-c", "uptime"},
+			SignedAt:   time.Now().Unix(),
+			SignNonce:  fmt.Sprint("nonce", i),
+		}
+		m.ArgSignature = ed25519.Sign(priv, []byte(signedString(m)))
+		msgs[i] = m
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !na.verifySignature(msgs[i]) {
+			b.Fatalf("signature not valid")
+		}
+	}
 }
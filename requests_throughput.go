@@ -0,0 +1,193 @@
+package steward
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// --- MeasureThroughput
+
+type methodREQMeasureThroughput struct {
+	event Event
+}
+
+func (m methodREQMeasureThroughput) getKind() Event {
+	return m.event
+}
+
+func (m methodREQMeasureThroughput) isReadOnly() bool {
+	return true
+}
+
+// Handler to measure the throughput to a node. Random data is sent to
+// the node in chunks with REQThroughputDiscard, and each chunk are
+// acknowledged back with REQThroughputAck. The result is replied when
+// all the chunks are acknowledged, or when the method timeout is reached.
+// The first argument is the node to test, and the optional second
+// argument is the number of bytes to send.
+func (m methodREQMeasureThroughput) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		if len(message.MethodArgs) < 1 {
+			er := fmt.Errorf("error: methodREQMeasureThroughput: got <1 number methodArgs, want the node to test")
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		toNode := Node(message.MethodArgs[0])
+		size := throughputDefaultBytes
+		if len(message.MethodArgs) > 1 {
+			n, err := strconv.Atoi(message.MethodArgs[1])
+			if err != nil || n < 1 {
+				er := fmt.Errorf("error: methodREQMeasureThroughput: size is not a valid number of bytes: %v", message.MethodArgs[1])
+				proc.errorKernel.errSend(proc, message, er)
+				return
+			}
+			size = n
+		}
+		if size > throughputMaxBytes {
+			size = throughputMaxBytes
+		}
+
+		chunks := (size + throughputChunkBytes - 1) / throughputChunkBytes
+
+		id, tt := proc.server.throughputTests.add(toNode, chunks)
+
+		// Prepare all the chunks before the test start, so creating the
+		// random data is not part of the measurement.
+		sams := []subjectAndMessage{}
+		for i := 0; i < chunks; i++ {
+			n := throughputChunkBytes
+			if i == chunks-1 {
+				n = size - i*throughputChunkBytes
+			}
+
+			data := make([]byte, n)
+			_, err := rand.Read(data)
+			if err != nil {
+				er := fmt.Errorf("error: methodREQMeasureThroughput: failed to create random data: %v", err)
+				proc.errorKernel.errSend(proc, message, er)
+				proc.server.throughputTests.remove(id)
+				return
+			}
+
+			msg := Message{
+				ToNode:        toNode,
+				FromNode:      Node(node),
+				Method:        REQThroughputDiscard,
+				MethodArgs:    []string{id},
+				Data:          data,
+				ReplyMethod:   REQThroughputAck,
+				ACKTimeout:    proc.configuration.DefaultMessageTimeout,
+				Retries:       proc.configuration.DefaultMessageRetries,
+				CorrelationID: message.CorrelationID,
+			}
+
+			sam, err := newSubjectAndMessage(msg)
+			if err != nil {
+				er := fmt.Errorf("error: methodREQMeasureThroughput: newSubjectAndMessage failed: %v", err)
+				proc.errorKernel.errSend(proc, message, er)
+				proc.server.throughputTests.remove(id)
+				return
+			}
+			sams = append(sams, sam)
+		}
+
+		// Cap the duration of the test.
+		timeout := time.Second * time.Duration(message.MethodTimeout)
+		if message.MethodTimeout < 1 || timeout > throughputMaxDuration {
+			timeout = throughputMaxDuration
+		}
+		ctx, cancel := context.WithTimeout(proc.ctx, timeout)
+		defer cancel()
+
+		tt.start = time.Now()
+		proc.toRingbufferCh <- sams
+
+		select {
+		case <-tt.done:
+		case <-ctx.Done():
+		}
+
+		r := proc.server.throughputTests.remove(id)
+
+		out, err := json.Marshal(r)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQMeasureThroughput: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		if !r.Complete {
+			er := fmt.Errorf("error: methodREQMeasureThroughput: test to %v timed out after %v, %v bytes of %v acknowledged", toNode, timeout, r.Bytes, size)
+			proc.errorKernel.errSend(proc, message, er)
+		}
+
+		newReplyMessage(proc, message, out)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- ThroughputDiscard
+
+type methodREQThroughputDiscard struct {
+	event Event
+}
+
+func (m methodREQThroughputDiscard) getKind() Event {
+	return m.event
+}
+
+func (m methodREQThroughputDiscard) isReadOnly() bool {
+	return true
+}
+
+// Handler to receive the data of a throughput test. The data is
+// discarded, and the number of bytes received are replied back.
+func (m methodREQThroughputDiscard) handler(proc process, message Message, node string) ([]byte, error) {
+	newReplyMessage(proc, message, []byte(strconv.Itoa(len(message.Data))))
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
+
+// --- ThroughputAck
+
+type methodREQThroughputAck struct {
+	event Event
+}
+
+func (m methodREQThroughputAck) getKind() Event {
+	return m.event
+}
+
+func (m methodREQThroughputAck) isReadOnly() bool {
+	return true
+}
+
+// Handler to register that a chunk of a throughput test was received
+// by the node tested.
+func (m methodREQThroughputAck) handler(proc process, message Message, node string) ([]byte, error) {
+	if message.PreviousMessage == nil || len(message.PreviousMessage.MethodArgs) < 1 {
+		er := fmt.Errorf("error: methodREQThroughputAck: no throughput test id found in the previous message")
+		proc.errorKernel.errSend(proc, message, er)
+	} else {
+		n, _ := strconv.Atoi(string(message.Data))
+		err := proc.server.throughputTests.ack(message.PreviousMessage.MethodArgs[0], n)
+		if err != nil {
+			er := fmt.Errorf("error: methodREQThroughputAck: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+		}
+	}
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
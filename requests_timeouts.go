@@ -0,0 +1,52 @@
+package steward
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type methodREQInspectTimeouts struct {
+	event Event
+}
+
+func (m methodREQInspectTimeouts) getKind() Event {
+	return m.event
+}
+
+func (m methodREQInspectTimeouts) isReadOnly() bool {
+	return true
+}
+
+// Handler to report the effective timeout and retry values of a message.
+// The first methodArgs can hold the message in JSON format, where only
+// the fields of interest needs to be set. The values are resolved with
+// the message defaults and the configuration of the node receiving the
+// request, and the result is replied as JSON.
+func (m methodREQInspectTimeouts) handler(proc process, message Message, node string) ([]byte, error) {
+	proc.processes.wg.Add(1)
+	go func() {
+		defer proc.processes.wg.Done()
+
+		var msg Message
+		if len(message.MethodArgs) > 0 && message.MethodArgs[0] != "" {
+			err := json.Unmarshal([]byte(message.MethodArgs[0]), &msg)
+			if err != nil {
+				er := fmt.Errorf("error: methodREQInspectTimeouts: failed to unmarshal message: %v", err)
+				proc.errorKernel.errSend(proc, message, er)
+				return
+			}
+		}
+
+		js, err := json.Marshal(resolveTimeouts(msg, proc.server.messageDefaults, proc.configuration))
+		if err != nil {
+			er := fmt.Errorf("error: methodREQInspectTimeouts: failed to marshal result: %v", err)
+			proc.errorKernel.errSend(proc, message, er)
+			return
+		}
+
+		newReplyMessage(proc, message, js)
+	}()
+
+	ackMsg := []byte("confirmed from: " + node + ": " + fmt.Sprint(message.ID))
+	return ackMsg, nil
+}
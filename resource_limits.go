@@ -0,0 +1,187 @@
+package steward
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// resourceLimits are the limits to run a command with. A zero value
+// means no limit.
+type resourceLimits struct {
+	// The max virtual memory of the command in bytes.
+	MemoryBytes int64
+	// The max cpu time of the command in seconds.
+	CPUSeconds int
+	// The max number of open file descriptors of the command.
+	OpenFiles int
+}
+
+// parseResourceLimits will parse the limits given as a comma separated
+// list of key=value pairs, like "memory=64M,cpu=10,nofile=128". The
+// memory value can be given with a K, M or G suffix.
+func parseResourceLimits(s string) (resourceLimits, error) {
+	var r resourceLimits
+
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return r, fmt.Errorf("error: resource limit not in the format key=value: %v", kv)
+		}
+		k = strings.TrimSpace(k)
+		v = strings.TrimSpace(v)
+
+		switch k {
+		case "memory":
+			n, err := parseByteSize(v)
+			if err != nil {
+				return r, fmt.Errorf("error: memory limit: %v", err)
+			}
+			r.MemoryBytes = n
+		case "cpu":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return r, fmt.Errorf("error: cpu limit is not a valid number of seconds: %v", v)
+			}
+			r.CPUSeconds = n
+		case "nofile":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return r, fmt.Errorf("error: nofile limit is not a valid number: %v", v)
+			}
+			r.OpenFiles = n
+		default:
+			return r, fmt.Errorf("error: unknown resource limit: %v", k)
+		}
+	}
+
+	return r, nil
+}
+
+// parseByteSize will parse a size given in bytes with an optional
+// K, M or G suffix.
+func parseByteSize(s string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("not a valid size: %v", s)
+	}
+
+	return n * multiplier, nil
+}
+
+// command will prepare the command to run with the limits. The limits
+// are set with setrlimit by a shell that execs the command, so the limits
+// are in place before the command starts. The memory limit is set on the
+// virtual memory of the command, so the kernel refuses to give it more
+// memory than the limit. The cpu limit is set as the soft limit, so the
+// command gets a SIGXCPU when it is reached, and the hard limit one
+// second later kills a command ignoring the signal.
+func (r resourceLimits) command(ctx context.Context, c string, args []string) *exec.Cmd {
+	ulimits := []string{}
+	if r.MemoryBytes > 0 {
+		kb := r.MemoryBytes / 1024
+		if kb < 1 {
+			kb = 1
+		}
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -v %v", kb))
+	}
+	if r.CPUSeconds > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -t %v && ulimit -St %v", r.CPUSeconds+1, r.CPUSeconds))
+	}
+	if r.OpenFiles > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -n %v", r.OpenFiles))
+	}
+
+	if len(ulimits) == 0 {
+		return exec.CommandContext(ctx, c, args...)
+	}
+
+	script := strings.Join(ulimits, " && ") + ` && exec "$0" "$@"`
+	return exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", script, c}, args...)...)
+}
+
+// runWithResourceLimits will run the command with the limits given, and
+// return the stdout and stderr of the command. If the command was stopped
+// for exceeding one of the limits the reason is returned in limitExceeded.
+func runWithResourceLimits(ctx context.Context, r resourceLimits, c string, args []string) (stdout []byte, stderr []byte, limitExceeded string, err error) {
+	if runtime.GOOS != "linux" {
+		return nil, nil, "", fmt.Errorf("error: resource limits are only supported on linux")
+	}
+
+	cmd := r.command(ctx, c, args)
+
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+
+	err = cmd.Run()
+	if cmd.ProcessState == nil {
+		return nil, nil, "", fmt.Errorf("error: failed to start command: %v", err)
+	}
+
+	// The kernel do not tell if an allocation was refused because of the
+	// memory limit, so the limit is only reported when the command said
+	// it ran out of memory, or was killed by a signal a failed allocation
+	// usually ends with. Any other failure is only told to have possibly
+	// been caused by the limit.
+	if r.MemoryBytes > 0 && err != nil {
+		ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+		switch {
+		case outOfMemoryOutput(errBuf.Bytes()):
+			limitExceeded = fmt.Sprintf("the command was refused more memory than the limit of %v bytes", r.MemoryBytes)
+		case ok && ws.Signaled() && (ws.Signal() == syscall.SIGSEGV || ws.Signal() == syscall.SIGABRT || ws.Signal() == syscall.SIGBUS):
+			limitExceeded = fmt.Sprintf("the command was killed by signal %v while its memory was limited to %v bytes, and was likely refused more memory", ws.Signal(), r.MemoryBytes)
+		default:
+			err = fmt.Errorf("%v, the memory of the command was limited to %v bytes, which might have caused the failure", err, r.MemoryBytes)
+		}
+	}
+
+	// The command gets a SIGXCPU when the soft cpu limit is reached, or
+	// a SIGKILL if it ignored the signal until the hard limit.
+	if r.CPUSeconds > 0 {
+		ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+		used := cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+		if ok && ws.Signaled() && (ws.Signal() == syscall.SIGXCPU || ws.Signal() == syscall.SIGKILL && used >= time.Duration(r.CPUSeconds)*time.Second) {
+			limitExceeded = fmt.Sprintf("cpu time of %v exceeded the limit of %v seconds, and the command was killed", used, r.CPUSeconds)
+		}
+	}
+
+	return outBuf.Bytes(), errBuf.Bytes(), limitExceeded, err
+}
+
+// outOfMemoryOutput will check if the error output of a command holds
+// one of the messages commonly written when an allocation fails.
+func outOfMemoryOutput(stderr []byte) bool {
+	s := strings.ToLower(string(stderr))
+	for _, m := range []string{"cannot allocate memory", "memory exhausted", "out of memory", "memoryerror", "bad_alloc"} {
+		if strings.Contains(s, m) {
+			return true
+		}
+	}
+
+	return false
+}
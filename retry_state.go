@@ -0,0 +1,111 @@
+package steward
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// retryState is the state of a message being delivered by a publisher,
+// and waiting for an ACK or retrying.
+type retryState struct {
+	// The id of the delivery in the retry registry.
+	ID uint64 `json:"id"`
+	// The id of the message.
+	MessageID int `json:"messageID"`
+	// The node the message is sent to.
+	ToNode Node `json:"toNode"`
+	// The method of the message.
+	Method Method `json:"method"`
+	// The subject the message is published on.
+	Subject string `json:"subject"`
+	// The number of failed delivery attempts done so far.
+	Attempts int `json:"attempts"`
+	// The max number of attempts before the message is given up.
+	Retries int `json:"retries"`
+	// The time the delivery started.
+	Started time.Time `json:"started"`
+	// The time of the next delivery attempt if no ACK is received.
+	NextRetry time.Time `json:"nextRetry"`
+	// The last error when delivering the message. Empty if no attempts
+	// have failed yet.
+	LastError string `json:"lastError"`
+}
+
+// retryRegistry keeps track of the messages currently being delivered
+// in messageDeliver, so the state of the retries can be inspected.
+type retryRegistry struct {
+	states map[uint64]*retryState
+	nextID uint64
+	mu     sync.Mutex
+}
+
+func newRetryRegistry() *retryRegistry {
+	r := retryRegistry{
+		states: make(map[uint64]*retryState),
+	}
+
+	return &r
+}
+
+// add will register a new delivery of the message on the subject given,
+// and return the id of the delivery.
+func (r *retryRegistry) add(message Message, subject string) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	r.states[r.nextID] = &retryState{
+		ID:        r.nextID,
+		MessageID: message.ID,
+		ToNode:    message.ToNode,
+		Method:    message.Method,
+		Subject:   subject,
+		Retries:   message.Retries,
+		Started:   time.Now(),
+	}
+
+	return r.nextID
+}
+
+// update will set the attempts done, the time of the next attempt, and
+// the last error for the delivery with the id given. A nil error will
+// keep the last error already registered.
+func (r *retryRegistry) update(id uint64, attempts int, nextRetry time.Time, lastErr error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.states[id]
+	if !ok {
+		return
+	}
+
+	s.Attempts = attempts
+	s.NextRetry = nextRetry
+	if lastErr != nil {
+		s.LastError = lastErr.Error()
+	}
+}
+
+// remove will remove the delivery with the id given from the registry.
+func (r *retryRegistry) remove(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.states, id)
+}
+
+// list will return a copy of the state of all the deliveries in
+// progress, ordered by the time they were started.
+func (r *retryRegistry) list() []retryState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	states := make([]retryState, 0, len(r.states))
+	for _, s := range r.states {
+		states = append(states, *s)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ID < states[j].ID })
+
+	return states
+}
@@ -30,6 +30,8 @@ import (
 type samDBValue struct {
 	ID   int
 	Data subjectAndMessage
+	// The number of failed delivery attempts done for the message.
+	Attempts int
 }
 
 // ringBuffer holds the data of the buffer,
@@ -56,10 +58,17 @@ type ringBuffer struct {
 	configuration      *Configuration
 	errorKernel        *errorKernel
 	processInitial     process
+	// The priority policy used to order the messages dispatched from
+	// the buffer.
+	priorityPolicy *priorityPolicy
+	// The number of messages currently stored on disk, and the cond
+	// used to wait for space when RingBufferMaxPersisted is reached.
+	persisted     int
+	persistedCond *sync.Cond
 }
 
 // newringBuffer returns a push/pop storage for values.
-func newringBuffer(ctx context.Context, metrics *metrics, configuration *Configuration, size int, dbFileName string, nodeName Node, ringBufferBulkInCh chan []subjectAndMessage, samValueBucket string, indexValueBucket string, errorKernel *errorKernel, processInitial process) *ringBuffer {
+func newringBuffer(ctx context.Context, metrics *metrics, configuration *Configuration, size int, dbFileName string, nodeName Node, ringBufferBulkInCh chan []subjectAndMessage, samValueBucket string, indexValueBucket string, errorKernel *errorKernel, processInitial process, priorityPolicy *priorityPolicy) *ringBuffer {
 
 	// Check if socket folder exists, if not create it
 	if _, err := os.Stat(configuration.DatabaseFolder); os.IsNotExist(err) {
@@ -71,6 +80,15 @@ func newringBuffer(ctx context.Context, metrics *metrics, configuration *Configu
 	}
 
 	DatabaseFilepath := filepath.Join(configuration.DatabaseFolder, dbFileName)
+	if configuration.RingBufferPersistPath != "" {
+		DatabaseFilepath = configuration.RingBufferPersistPath
+
+		err := os.MkdirAll(filepath.Dir(DatabaseFilepath), 0700)
+		if err != nil {
+			log.Printf("error: failed to create directory for ringbuffer database %v: %v\n", DatabaseFilepath, err)
+			os.Exit(1)
+		}
+	}
 
 	// ---
 
@@ -91,6 +109,8 @@ func newringBuffer(ctx context.Context, metrics *metrics, configuration *Configu
 		metrics:            metrics,
 		configuration:      configuration,
 		processInitial:     processInitial,
+		priorityPolicy:     priorityPolicy,
+		persistedCond:      sync.NewCond(&sync.Mutex{}),
 	}
 }
 
@@ -104,6 +124,15 @@ func (r *ringBuffer) start(ctx context.Context, inCh chan subjectAndMessage, out
 
 	r.totalMessagesIndex = r.getIndexValue()
 
+	// Wake up the ones waiting for space on disk when done, so they can
+	// return.
+	go func() {
+		<-ctx.Done()
+		r.persistedCond.L.Lock()
+		r.persistedCond.Broadcast()
+		r.persistedCond.L.Unlock()
+	}()
+
 	// Fill the buffer when new data arrives into the system
 	go r.fillBuffer(ctx, inCh)
 
@@ -137,6 +166,10 @@ func (r *ringBuffer) fillBuffer(ctx context.Context, inCh chan subjectAndMessage
 	// if there where previously unhandled messages that need to be handled first.
 
 	func() {
+		if !r.configuration.RingBufferPersist {
+			return
+		}
+
 		s, err := r.dumpBucket(r.samValueBucket)
 		if err != nil {
 			er := fmt.Errorf("info: fillBuffer: retreival of values from k/v store failed, probaly empty database, and no previous entries in db to process: %v", err)
@@ -144,6 +177,12 @@ func (r *ringBuffer) fillBuffer(ctx context.Context, inCh chan subjectAndMessage
 			return
 		}
 
+		// The stored messages are counted without waiting for space, since
+		// they are already on disk.
+		r.persistedCond.L.Lock()
+		r.persisted += len(s)
+		r.persistedCond.L.Unlock()
+
 		for _, v := range s {
 			r.bufData <- v
 		}
@@ -175,15 +214,7 @@ func (r *ringBuffer) fillBuffer(ctx context.Context, inCh chan subjectAndMessage
 
 			// Check if default message values for timers are set, and if
 			// not then set default message values.
-			if v.Message.ACKTimeout < 1 {
-				v.Message.ACKTimeout = r.configuration.DefaultMessageTimeout
-			}
-			if v.Message.Retries < 1 {
-				v.Message.Retries = r.configuration.DefaultMessageRetries
-			}
-			if v.Message.MethodTimeout < 1 && v.Message.MethodTimeout != -1 {
-				v.Message.MethodTimeout = r.configuration.DefaultMethodTimeout
-			}
+			v.Message = setMessageTimeoutDefaults(v.Message, r.configuration)
 
 			// --- Store the incomming message in the k/v store ---
 
@@ -199,18 +230,18 @@ func (r *ringBuffer) fillBuffer(ctx context.Context, inCh chan subjectAndMessage
 				Data: v,
 			}
 
-			js, err := json.Marshal(samV)
-			if err != nil {
-				er := fmt.Errorf("error:fillBuffer: json marshaling: %v", err)
-				r.errorKernel.errSend(r.processInitial, Message{}, er)
-			}
-
 			// Store the incomming message in key/value store
-			err = r.dbUpdate(r.db, r.samValueBucket, strconv.Itoa(dbID), js)
-			if err != nil {
-				er := fmt.Errorf("error: dbUpdate samValue failed: %v", err)
-				r.errorKernel.errSend(r.processInitial, Message{}, er)
+			if r.configuration.RingBufferPersist {
+				if !r.waitForPersistSpace(ctx) {
+					close(r.bufData)
+					return
+				}
 
+				err := r.persist(samV)
+				if err != nil {
+					er := fmt.Errorf("error: fillBuffer: %v", err)
+					r.errorKernel.errSend(r.processInitial, Message{}, er)
+				}
 			}
 
 			// Put the message on the inmemory buffer.
@@ -234,158 +265,210 @@ func (r *ringBuffer) fillBuffer(ctx context.Context, inCh chan subjectAndMessage
 // one by one. The messages will be delivered on the outCh, and it will wait
 // until a signal is received on the done channel before it continues with the
 // next message.
+// The messages waiting to be delivered are ordered by their priority, so
+// a message with a higher priority is delivered ahead of the messages
+// with a lower priority that are already waiting. Messages that have
+// waited long are aged up to a higher priority, so they are not starved.
 func (r *ringBuffer) processBufferMessages(ctx context.Context, outCh chan samDBValueAndDelivered) {
+	queue := newPriorityQueue(cap(r.bufData), time.Second*time.Duration(r.configuration.PriorityAgingInterval))
+
+	go func() {
+		for {
+			select {
+			case v, ok := <-r.bufData:
+				if !ok {
+					return
+				}
+				if !queue.push(ctx, v, r.priorityPolicy.resolve(v.Data.Message)) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
 	// Range over the buffer of messages to pass on to processes.
 	for {
-		select {
-		case v := <-r.bufData:
-			r.metrics.promInMemoryBufferMessagesCurrent.Set(float64(len(r.bufData)))
-
-			// Create a done channel per message. A process started by the
-			// spawnProcess function will handle incomming messages sequentaly.
-			// So in the spawnProcess function we put a struct{} value when a
-			// message is processed on the "done" channel and an ack is received
-			// for a message, and we wait here for the "done" to be received.
-
-			// We start the actual processing of an individual message here within
-			// it's own go routine. Reason is that we don't want to block other
-			// messages to be processed while waiting for the done signal, or if an
-			// error with an individual message occurs.
-			go func(v samDBValue) {
-				// Create a copy of the message that we can use to write to the
-				// perm store without causing a race since the REQ handler for the
-				// message might not yet be done when message is written to the
-				// perm store.
-				// We also need a copy to be able to remove the data from the message
-				// when writing it to the store, so we don't mess up to actual data
-				// that might be in use in the handler.
-
-				msgForPermStore := Message{}
-				copier.Copy(&msgForPermStore, v.Data.Message)
-				// Remove the content of the data field.
-				msgForPermStore.Data = nil
-
-				v.Data.Message.done = make(chan struct{})
-				delivredCh := make(chan struct{})
-
-				// Prepare the structure with the data, and a function that can
-				// be called when the data is received for signaling back.
-				sd := samDBValueAndDelivered{
-					samDBValue: v,
-					delivered: func() {
-						delivredCh <- struct{}{}
-					},
-				}
+		v, ok := queue.pop(ctx)
+		if !ok {
+			//close(outCh)
+			return
+		}
 
-				outCh <- sd
-				// Just to confirm here that the message was picked up, to know if the
-				// the read process have stalled or not.
-				// For now it will not do anything,
-				select {
-				case <-delivredCh:
-					// OK.
-				case <-time.After(time.Second * 5):
-					// TODO: Check out if more logic should be made here if messages are stuck etc.
-					// Testing with a timeout here to figure out if messages are stuck
-					// waiting for done signal.
-					log.Printf("Error: *** message %v seems to be stuck, did not receive delivered signal from reading process\n", v.ID)
-
-					r.metrics.promRingbufferStalledMessagesTotal.Inc()
+		r.metrics.promInMemoryBufferMessagesCurrent.Set(float64(len(r.bufData) + queue.length()))
+
+		// Create a done channel per message. A process started by the
+		// spawnProcess function will handle incomming messages sequentaly.
+		// So in the spawnProcess function we put a struct{} value when a
+		// message is processed on the "done" channel and an ack is received
+		// for a message, and we wait here for the "done" to be received.
+
+		// We start the actual processing of an individual message here within
+		// it's own go routine. Reason is that we don't want to block other
+		// messages to be processed while waiting for the done signal, or if an
+		// error with an individual message occurs.
+		// We do wait for the message to be picked up on the outCh before
+		// taking the next message from the queue, so the messages are
+		// delivered in the order of their priority.
+		sent := make(chan struct{})
+		go func(v samDBValue) {
+			// Create a copy of the message that we can use to write to the
+			// perm store without causing a race since the REQ handler for the
+			// message might not yet be done when message is written to the
+			// perm store.
+			// We also need a copy to be able to remove the data from the message
+			// when writing it to the store, so we don't mess up to actual data
+			// that might be in use in the handler.
+
+			msgForPermStore := Message{}
+			copier.Copy(&msgForPermStore, v.Data.Message)
+			// Remove the content of the data field.
+			msgForPermStore.Data = nil
+
+			v.Data.Message.done = make(chan struct{})
+			v.Data.Message.deliveryAttempts = v.Attempts
+			v.Data.Message.attemptFailed = func(attempts int) {
+				v.Attempts = attempts
+				if !r.configuration.RingBufferPersist {
+					return
+				}
+				err := r.persist(v)
+				if err != nil {
+					er := fmt.Errorf("error: processBufferMessages: failed to store delivery attempts: %v", err)
+					r.errorKernel.errSend(r.processInitial, Message{}, er)
 				}
-				// Listen on the done channel here , so a go routine handling the
-				// message will be able to signal back here that the message have
-				// been processed, and that we then can delete it out of the K/V Store.
+			}
+			delivredCh := make(chan struct{})
+
+			// Prepare the structure with the data, and a function that can
+			// be called when the data is received for signaling back.
+			sd := samDBValueAndDelivered{
+				samDBValue: v,
+				delivered: func() {
+					delivredCh <- struct{}{}
+				},
+			}
+
+			outCh <- sd
+			close(sent)
+			// Just to confirm here that the message was picked up, to know if the
+			// the read process have stalled or not.
+			// For now it will not do anything,
+			select {
+			case <-delivredCh:
+				// OK.
+			case <-time.After(time.Second * 5):
+				// TODO: Check out if more logic should be made here if messages are stuck etc.
+				// Testing with a timeout here to figure out if messages are stuck
+				// waiting for done signal.
+				log.Printf("Error: *** message %v seems to be stuck, did not receive delivered signal from reading process\n", v.ID)
+
+				r.metrics.promRingbufferStalledMessagesTotal.Inc()
+			}
+			// Listen on the done channel here , so a go routine handling the
+			// message will be able to signal back here that the message have
+			// been processed, and that we then can delete it out of the K/V Store.
 
-				<-v.Data.done
-				// log.Printf("info: processBufferMessages: done with message, deleting key from bucket, %v\n", v.ID)
-				r.metrics.promMessagesProcessedIDLast.Set(float64(v.ID))
+			<-v.Data.done
+			// log.Printf("info: processBufferMessages: done with message, deleting key from bucket, %v\n", v.ID)
+			r.metrics.promMessagesProcessedIDLast.Set(float64(v.ID))
 
-				// Since we are now done with the specific message we can delete
-				// it out of the K/V Store.
+			// Since we are now done with the specific message we can delete
+			// it out of the K/V Store.
+			if r.configuration.RingBufferPersist {
 				r.deleteKeyFromBucket(r.samValueBucket, strconv.Itoa(v.ID))
 
-				//m := v.Data.Message
-				//t := time.Now().Format("Mon Jan _2 15:04:05 2006")
-
-				//tmpout := os.Stdout
-
-				//_ = fmt.Sprintf("%v\n", t)
-				//_ = fmt.Sprintf("%v\n", m.ID)
-				//_ = fmt.Sprintf("%v\n", m.ToNode)
-				//_ = fmt.Sprintf("%v\n", m.ToNodes)
-				//_ = fmt.Sprintf("%v\n", m.Data)
-				//_ = fmt.Sprintf("%v\n", m.Method)
-				//_ = fmt.Sprintf("%v\n", m.MethodArgs)
-				//_ = fmt.Sprintf("%v\n", m.ArgSignature)
-				//_ = fmt.Sprintf("%v\n", m.ReplyMethod)
-				//_ = fmt.Sprintf("%v\n", m.ReplyMethodArgs)
-				//_ = fmt.Sprintf("%v\n", m.IsReply)
-				//_ = fmt.Sprintf("%v\n", m.FromNode)
-				//_ = fmt.Sprintf("%v\n", m.ACKTimeout)
-				//_ = fmt.Sprintf("%v\n", m.Retries)
-				//_ = fmt.Sprintf("%v\n", m.ReplyACKTimeout)
-				//_ = fmt.Sprintf("%v\n", m.ReplyRetries)
-				//_ = fmt.Sprintf("%v\n", m.MethodTimeout)
-				//_ = fmt.Sprintf("%v\n", m.ReplyMethodTimeout)
-				//_ = fmt.Sprintf("%v\n", m.Directory)
-				//_ = fmt.Sprintf("%v\n", m.FileName)
-				//_ = fmt.Sprintf("%v\n", m.PreviousMessage)
-				//_ = fmt.Sprintf("%v\n", m.RelayViaNode)
-				//_ = fmt.Sprintf("%v\n", m.RelayOriginalViaNode)
-				//_ = fmt.Sprintf("%v\n", m.RelayFromNode)
-				//_ = fmt.Sprintf("%v\n", m.RelayToNode)
-				//_ = fmt.Sprintf("%v\n", m.RelayOriginalMethod)
-				//_ = fmt.Sprintf("%v\n", m.RelayReplyMethod)
-				//_ = fmt.Sprintf("%v\n", m.done)
-
-				//str := fmt.Sprintln(
-				//	t,
-				//	m.ID,
-				//	m.ToNode,
-				//	m.ToNodes,
-				//	m.Data,
-				//	m.Method,
-				//	m.MethodArgs,
-				//	m.ArgSignature,
-				//	m.ReplyMethod,
-				//	m.ReplyMethodArgs,
-				//	m.IsReply,
-				//	m.FromNode,
-				//	m.ACKTimeout,
-				//	m.Retries,
-				//	m.ReplyACKTimeout,
-				//	m.ReplyRetries,
-				//	m.MethodTimeout,
-				//	m.ReplyMethodTimeout,
-				//	m.Directory,
-				//	m.FileName,
-				//	m.PreviousMessage,
-				//	m.RelayViaNode,
-				//	m.RelayOriginalViaNode,
-				//	m.RelayFromNode,
-				//	m.RelayToNode,
-				//	m.RelayOriginalMethod,
-				//	m.RelayReplyMethod,
-				//	m.done,
-				//)
-
-				//r.permStore <- fmt.Sprintf("%v\n", str)
-
-				// NB: Removed this one since it creates a data race with the storing of the hash value in
-				// the methodREQKeysDeliverUpdate. Sorted by splitting up the sprint below with the sprint
-				// above for now, but should investigate further what might be the case here, since the
-				// message have no reference to the proc and should in theory not create a race.
-				//
-				js, err := json.Marshal(msgForPermStore)
-				if err != nil {
-					er := fmt.Errorf("error:fillBuffer: json marshaling: %v", err)
-					r.errorKernel.errSend(r.processInitial, Message{}, er)
-				}
-				r.permStore <- time.Now().Format("Mon Jan _2 15:04:05 2006") + ", " + string(js) + "\n"
+				r.persistedCond.L.Lock()
+				r.persisted--
+				r.persistedCond.L.Unlock()
+				r.persistedCond.Signal()
+			}
+
+			//m := v.Data.Message
+			//t := time.Now().Format("Mon Jan _2 15:04:05 2006")
+
+			//tmpout := os.Stdout
+
+			//_ = fmt.Sprintf("%v\n", t)
+			//_ = fmt.Sprintf("%v\n", m.ID)
+			//_ = fmt.Sprintf("%v\n", m.ToNode)
+			//_ = fmt.Sprintf("%v\n", m.ToNodes)
+			//_ = fmt.Sprintf("%v\n", m.Data)
+			//_ = fmt.Sprintf("%v\n", m.Method)
+			//_ = fmt.Sprintf("%v\n", m.MethodArgs)
+			//_ = fmt.Sprintf("%v\n", m.ArgSignature)
+			//_ = fmt.Sprintf("%v\n", m.ReplyMethod)
+			//_ = fmt.Sprintf("%v\n", m.ReplyMethodArgs)
+			//_ = fmt.Sprintf("%v\n", m.IsReply)
+			//_ = fmt.Sprintf("%v\n", m.FromNode)
+			//_ = fmt.Sprintf("%v\n", m.ACKTimeout)
+			//_ = fmt.Sprintf("%v\n", m.Retries)
+			//_ = fmt.Sprintf("%v\n", m.ReplyACKTimeout)
+			//_ = fmt.Sprintf("%v\n", m.ReplyRetries)
+			//_ = fmt.Sprintf("%v\n", m.MethodTimeout)
+			//_ = fmt.Sprintf("%v\n", m.ReplyMethodTimeout)
+			//_ = fmt.Sprintf("%v\n", m.Directory)
+			//_ = fmt.Sprintf("%v\n", m.FileName)
+			//_ = fmt.Sprintf("%v\n", m.PreviousMessage)
+			//_ = fmt.Sprintf("%v\n", m.RelayViaNode)
+			//_ = fmt.Sprintf("%v\n", m.RelayOriginalViaNode)
+			//_ = fmt.Sprintf("%v\n", m.RelayFromNode)
+			//_ = fmt.Sprintf("%v\n", m.RelayToNode)
+			//_ = fmt.Sprintf("%v\n", m.RelayOriginalMethod)
+			//_ = fmt.Sprintf("%v\n", m.RelayReplyMethod)
+			//_ = fmt.Sprintf("%v\n", m.done)
+
+			//str := fmt.Sprintln(
+			//	t,
+			//	m.ID,
+			//	m.ToNode,
+			//	m.ToNodes,
+			//	m.Data,
+			//	m.Method,
+			//	m.MethodArgs,
+			//	m.ArgSignature,
+			//	m.ReplyMethod,
+			//	m.ReplyMethodArgs,
+			//	m.IsReply,
+			//	m.FromNode,
+			//	m.ACKTimeout,
+			//	m.Retries,
+			//	m.ReplyACKTimeout,
+			//	m.ReplyRetries,
+			//	m.MethodTimeout,
+			//	m.ReplyMethodTimeout,
+			//	m.Directory,
+			//	m.FileName,
+			//	m.PreviousMessage,
+			//	m.RelayViaNode,
+			//	m.RelayOriginalViaNode,
+			//	m.RelayFromNode,
+			//	m.RelayToNode,
+			//	m.RelayOriginalMethod,
+			//	m.RelayReplyMethod,
+			//	m.done,
+			//)
+
+			//r.permStore <- fmt.Sprintf("%v\n", str)
+
+			// NB: Removed this one since it creates a data race with the storing of the hash value in
+			// the methodREQKeysDeliverUpdate. Sorted by splitting up the sprint below with the sprint
+			// above for now, but should investigate further what might be the case here, since the
+			// message have no reference to the proc and should in theory not create a race.
+			//
+			js, err := json.Marshal(msgForPermStore)
+			if err != nil {
+				er := fmt.Errorf("error:fillBuffer: json marshaling: %v", err)
+				r.errorKernel.errSend(r.processInitial, Message{}, er)
+			}
+			r.permStore <- time.Now().Format("Mon Jan _2 15:04:05 2006") + ", " + string(js) + "\n"
 
-			}(v)
+		}(v)
+
+		select {
+		case <-sent:
 		case <-ctx.Done():
-			//close(outCh)
 			return
 		}
 	}
@@ -413,9 +496,10 @@ func (r *ringBuffer) dumpBucket(bucket string) ([]samDBValue, error) {
 			return nil
 		})
 
-		// Sort the order of the slice items based on ID, since they where retreived from a map.
+		// Sort the order of the slice items based on ID, since they where retreived from a map,
+		// so the oldest messages are resent first.
 		sort.SliceStable(samDBValues, func(i, j int) bool {
-			return samDBValues[i].ID > samDBValues[j].ID
+			return samDBValues[i].ID < samDBValues[j].ID
 		})
 
 		for _, v := range samDBValues {
@@ -471,10 +555,47 @@ func (r *ringBuffer) deleteKeyFromBucket(bucket string, key string) error {
 	return err
 }
 
+// waitForPersistSpace will wait until there is space to store another
+// message on disk, if RingBufferMaxPersisted is set, and count the message
+// as stored. False is returned if the context is done while waiting.
+func (r *ringBuffer) waitForPersistSpace(ctx context.Context) bool {
+	r.persistedCond.L.Lock()
+	defer r.persistedCond.L.Unlock()
+
+	max := r.configuration.RingBufferMaxPersisted
+	for max > 0 && r.persisted >= max {
+		if ctx.Err() != nil {
+			return false
+		}
+		r.persistedCond.Wait()
+	}
+
+	r.persisted++
+	return true
+}
+
+// persist will store the message with its delivery attempts on disk.
+func (r *ringBuffer) persist(v samDBValue) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("json marshaling: %v", err)
+	}
+
+	err = r.dbUpdate(r.db, r.samValueBucket, strconv.Itoa(v.ID), js)
+	if err != nil {
+		return fmt.Errorf("dbUpdate samValue failed: %v", err)
+	}
+
+	return nil
+}
+
 // db update metrics.
 func (r *ringBuffer) dbUpdateMetrics(bucket string) error {
 	err := r.db.Update(func(tx *bolt.Tx) error {
 		bu := tx.Bucket([]byte(bucket))
+		if bu == nil {
+			return nil
+		}
 
 		r.metrics.promDBMessagesCurrent.Set(float64(bu.Stats().KeyN))
 
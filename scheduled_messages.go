@@ -0,0 +1,282 @@
+package steward
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// scheduledMessage is a message published on an interval, started with
+// REQScheduled.
+type scheduledMessage struct {
+	// The ID of the REQScheduled message, which is used to stop it.
+	ID int `json:"id"`
+	// The node that started the schedule, and the only node allowed to
+	// stop it.
+	FromNode Node `json:"fromNode"`
+	// The message published on each tick.
+	Message Message `json:"message"`
+	// The interval in seconds between each publish.
+	Interval int `json:"interval"`
+	// The number of times left to publish the message, or -1 if it is
+	// published until stopped.
+	Remaining int `json:"remaining"`
+
+	cancel context.CancelFunc
+}
+
+// scheduleKey is the key of a schedule. The ID of the REQScheduled
+// message is only unique for the node that sent it, so the schedules
+// are kept by both the node and the ID.
+type scheduleKey struct {
+	fromNode Node
+	id       int
+}
+
+func (sm *scheduledMessage) key() scheduleKey {
+	return scheduleKey{fromNode: sm.FromNode, id: sm.ID}
+}
+
+// scheduledMessages holds the running schedules of a node, by the node
+// that started them and the ID of the REQScheduled message that started
+// them. The schedules are stored
+// to file when the ringbuffer persistence is enabled, so they can be
+// started again when the node is restarted.
+type scheduledMessages struct {
+	schedules map[scheduleKey]*scheduledMessage
+	filePath  string
+	persist   bool
+	mu        sync.Mutex
+}
+
+func newScheduledMessages(c *Configuration) *scheduledMessages {
+	s := scheduledMessages{
+		schedules: make(map[scheduleKey]*scheduledMessage),
+		filePath:  filepath.Join(c.DatabaseFolder, "scheduled_messages.txt"),
+		persist:   c.RingBufferPersist,
+	}
+
+	return &s
+}
+
+// start will register the schedule and start publishing the message
+// with the process given. An error is returned if a schedule with the
+// same id from the same node is already running.
+func (s *scheduledMessages) start(proc process, sm *scheduledMessage) error {
+	ctx, cancel := context.WithCancel(proc.ctx)
+	sm.cancel = cancel
+
+	s.mu.Lock()
+	if _, ok := s.schedules[sm.key()]; ok {
+		s.mu.Unlock()
+		cancel()
+		return fmt.Errorf("a schedule with message id %v from node %v is already running", sm.ID, sm.FromNode)
+	}
+	s.schedules[sm.key()] = sm
+	err := s.saveToFile()
+	s.mu.Unlock()
+
+	if err != nil {
+		proc.errorKernel.errSend(proc, Message{}, err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Second * time.Duration(sm.Interval))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			if !scheduledAllowed(proc, sm) {
+				er := fmt.Errorf("error: scheduledMessages: node %v that started schedule %v is not allowed to run the message with method %v, the schedule is stopped", sm.FromNode, sm.ID, sm.Message.Method)
+				proc.errorKernel.errSend(proc, sm.Message, er)
+				s.remove(sm.key())
+				return
+			}
+
+			sam, err := newSubjectAndMessage(sm.Message)
+			if err != nil {
+				er := fmt.Errorf("error: scheduledMessages: failed to create message for schedule %v: %v", sm.ID, err)
+				proc.errorKernel.errSend(proc, sm.Message, er)
+				s.remove(sm.key())
+				return
+			}
+
+			select {
+			case proc.toRingbufferCh <- []subjectAndMessage{sam}:
+			case <-ctx.Done():
+				return
+			}
+
+			if s.tick(sm.key()) {
+				er := fmt.Errorf("info: scheduledMessages: schedule %v is done", sm.ID)
+				proc.errorKernel.infoSend(proc, sm.Message, er)
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// scheduledAllowed will check the message of the schedule against the
+// acl for the node that started the schedule, since the message is
+// published and signed by this node on its behalf. The signature of the
+// node that started it was verified when the REQScheduled was received,
+// and the acl is checked before each publish so a change of the acl
+// also applies to the schedules already running.
+func scheduledAllowed(proc process, sm *scheduledMessage) bool {
+	if !proc.configuration.EnableSignatureCheck || !proc.configuration.EnableAclCheck {
+		return true
+	}
+
+	m := sm.Message
+	m.FromNode = sm.FromNode
+
+	return proc.nodeAuth.verifyAcl(m)
+}
+
+// tick will count down the number of times left to publish the message
+// of the schedule with the key given, and return true if the schedule
+// is done and was removed.
+func (s *scheduledMessages) tick(key scheduleKey) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sm, ok := s.schedules[key]
+	if !ok || sm.Remaining < 0 {
+		return false
+	}
+
+	sm.Remaining--
+	if sm.Remaining == 0 {
+		sm.cancel()
+		delete(s.schedules, key)
+	}
+
+	err := s.saveToFile()
+	if err != nil {
+		log.Printf("%v\n", err)
+	}
+
+	return sm.Remaining == 0
+}
+
+// stop will stop and remove the schedule with the message id given
+// started by the node given.
+func (s *scheduledMessages) stop(id int, fromNode Node) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := scheduleKey{fromNode: fromNode, id: id}
+	sm, ok := s.schedules[key]
+	if !ok {
+		return fmt.Errorf("no schedule running with message id %v started by node %v", id, fromNode)
+	}
+
+	sm.cancel()
+	delete(s.schedules, key)
+
+	return s.saveToFile()
+}
+
+// remove will stop and remove the schedule with the key given.
+func (s *scheduledMessages) remove(key scheduleKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sm, ok := s.schedules[key]; ok {
+		sm.cancel()
+		delete(s.schedules, key)
+	}
+
+	err := s.saveToFile()
+	if err != nil {
+		log.Printf("%v\n", err)
+	}
+}
+
+// restore will start the schedules stored to file, if the persistence
+// is enabled.
+func (s *scheduledMessages) restore(proc process) {
+	if !s.persist {
+		return
+	}
+
+	stored, err := s.loadFromFile()
+	if err != nil {
+		er := fmt.Errorf("error: scheduledMessages: loading schedules from file: %v", err)
+		proc.errorKernel.errSend(proc, Message{}, er)
+		return
+	}
+
+	for _, sm := range stored {
+		err := s.start(proc, sm)
+		if err != nil {
+			er := fmt.Errorf("error: scheduledMessages: failed to restore schedule %v: %v", sm.ID, err)
+			proc.errorKernel.errSend(proc, Message{}, er)
+		}
+	}
+}
+
+// loadFromFile will read the schedules stored to file. If no file is
+// found no schedules and a nil error is returned.
+func (s *scheduledMessages) loadFromFile() ([]*scheduledMessage, error) {
+	if _, err := os.Stat(s.filePath); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	fh, err := os.OpenFile(s.filePath, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error: failed to open scheduled messages file: %v", err)
+	}
+	defer fh.Close()
+
+	b, err := io.ReadAll(fh)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored []*scheduledMessage
+	err = json.Unmarshal(b, &stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return stored, nil
+}
+
+// saveToFile will save the schedules to file for persistent storage, if
+// the persistence is enabled. The caller must hold the lock.
+func (s *scheduledMessages) saveToFile() error {
+	if !s.persist {
+		return nil
+	}
+
+	stored := make([]*scheduledMessage, 0, len(s.schedules))
+	for _, sm := range s.schedules {
+		stored = append(stored, sm)
+	}
+
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("error: failed to marshal scheduled messages: %v", err)
+	}
+
+	err = os.WriteFile(s.filePath, b, 0600)
+	if err != nil {
+		return fmt.Errorf("error: failed to write scheduled messages file: %v", err)
+	}
+
+	return nil
+}
@@ -4,11 +4,16 @@ package steward
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -32,10 +37,16 @@ type server struct {
 	cancel context.CancelFunc
 	// Configuration options used for running the server
 	configuration *Configuration
-	// The nats connection to the broker
-	natsConn *nats.Conn
+	// The transport used to deliver the messages, which is a nats
+	// connection to the broker.
+	transport transport
 	// net listener for communicating via the steward socket
 	StewardSocket net.Listener
+	// The tcp listener and the http server for new messages, if
+	// started, so they can be closed when the server is stopped.
+	tcpListener net.Listener
+	httpServer  *http.Server
+	listenersMu sync.Mutex
 	// processes holds all the information about running processes
 	processes *processes
 	// The name of the node
@@ -49,12 +60,17 @@ type server struct {
 	// errorKernel is doing all the error handling like what to do if
 	// an error occurs.
 	errorKernel *errorKernel
+	// logger is where the log entries of the server, its processes and
+	// the error kernel are written.
+	logger logger
 	// Ring buffer
 	ringBuffer *ringBuffer
 	// metric exporter
 	metrics *metrics
 	// Version of package
 	version string
+	// startedAt is the time the server was created, used for the uptime.
+	startedAt time.Time
 	// tui client
 	tui *tui
 	// processInitial is the initial process that all other processes are tied to.
@@ -68,18 +84,97 @@ type server struct {
 	helloRegister *helloRegister
 	// holds the logic for the central auth services
 	centralAuth *centralAuth
+	// messageDefaults holds the default values to use for message
+	// fields not specified when a message enters the system.
+	messageDefaults *messageDefaults
+	// dataIndex is the searchable index of the reply files stored
+	// in the subscribers data folder.
+	dataIndex *dataIndex
+	// degradedMode tells if the node is in degraded mode where only
+	// the read-only methods are allowed.
+	degradedMode *degradedMode
+	// connRegistry keeps track of the active and recent connections
+	// to the local socket, tcp and http listeners.
+	connRegistry *connRegistry
+	// httpListenerAuth is the authentication of the requests to the
+	// HTTP listener.
+	httpListenerAuth *httpListenerAuth
+	// dedupCache remembers the ACK messages received recently, so the
+	// messages resent by the publishers are not handled twice.
+	dedupCache *dedupCache
+	// throughputTests holds the running throughput tests started
+	// with REQMeasureThroughput.
+	throughputTests *throughputTests
+	// queryProviders are the read-only providers that can be
+	// queried with REQQuery.
+	queryProviders *queryProviders
+	// messageCallbacks are the callbacks registered with
+	// RegisterMessageCallback, called when a message is handled.
+	messageCallbacks *messageCallbacks
+	// scheduledShutdown holds the shutdown scheduled with
+	// REQShutdownScheduled.
+	scheduledShutdown *scheduledShutdown
+	// replicaApplied keeps track of the last central state applied
+	// on a standby node with REQReplicateTo.
+	replicaApplied *replicaApplied
+	// deadLetters are the messages that could not be delivered after
+	// all the retries were used.
+	deadLetters *deadLetters
+	// distributedLocks holds the leases of the locks on central, and the
+	// REQRunWithLock requests on a node waiting for a lock.
+	distributedLocks *distributedLocks
+	// streamSessions holds the interactive command sessions started
+	// with REQStreamCommand.
+	streamSessions *streamSessions
+	// tailSessions holds the files being followed with REQTailFile.
+	tailSessions *tailSessions
+	// scheduledMessages holds the messages published on an interval
+	// with REQScheduled.
+	scheduledMessages *scheduledMessages
+	// reachabilityWaits holds the reachability checks waiting for
+	// replies.
+	reachabilityWaits *reachabilityWaits
+	// priorityPolicy holds the priority tiers of the methods used to
+	// order the messages in the ringbuffer.
+	priorityPolicy *priorityPolicy
+	// retryRegistry holds the state of the messages being delivered by
+	// the publishers, and waiting for an ACK or retrying.
+	retryRegistry *retryRegistry
+	// concurrencyLimits holds the limits for the number of messages
+	// handled at the same time by the subscribers of a method.
+	concurrencyLimits *concurrencyLimits
+	// inboundRateLimits holds the limits for the rate of the messages
+	// handled from each node.
+	inboundRateLimits *inboundRateLimits
+	// replyFolderTemplate is the parsed ReplyFolderTemplate used for
+	// the folders of the reply files, or nil if not set.
+	replyFolderTemplate *template.Template
+	// fileLocks holds the locks of the files written by the file
+	// handlers, so a file is not written to while it is rotated.
+	fileLocks *fileLocks
 }
 
 // newServer will prepare and return a server type
 func NewServer(configuration *Configuration, version string) (*server, error) {
+	// Fail fast on a configuration that would make the node fail later.
+	if err := configuration.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Set up the main background context.
 	ctx, cancel := context.WithCancel(context.Background())
 
-	metrics := newMetrics(configuration.PromHostAndPort)
+	buckets, err := parseHistogramBuckets(configuration.PromHandlerDurationBuckets)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("error: failed to parse the handler duration buckets: %v", err)
+	}
+	metrics := newMetrics(configuration.PromHostAndPort, buckets)
 
 	// Start the error kernel that will do all the error handling
 	// that is not done within a process.
-	errorKernel := newErrorKernel(ctx, metrics)
+	logger := newLogger(configuration)
+	errorKernel := newErrorKernel(ctx, metrics, configuration, logger)
 
 	var opt nats.Option
 
@@ -97,33 +192,28 @@ func NewServer(configuration *Configuration, version string) (*server, error) {
 		}
 	}
 
-	var conn *nats.Conn
+	// Connect to the nats server with the number of connections given
+	// for the pool.
+	poolSize := configuration.NatsConnPoolSize
+	if poolSize < 1 {
+		poolSize = 1
+	}
+	var transports []transport
+	for i := 0; i < poolSize; i++ {
+		conn := connectNats(configuration, opt)
 
-	// Connect to the nats server, and retry until succesful.
-	for {
-		var err error
-		// Setting MaxReconnects to -1 which equals unlimited.
-		conn, err = nats.Connect(configuration.BrokerAddress,
-			opt,
-			nats.MaxReconnects(-1),
-			nats.ReconnectJitter(time.Duration(configuration.NatsReconnectJitter)*time.Millisecond, time.Duration(configuration.NatsReconnectJitterTLS)*time.Second),
-			nats.Timeout(time.Second*time.Duration(configuration.NatsConnOptTimeout)),
-		)
-		// If no servers where available, we loop and retry until succesful.
-		if err != nil {
-			log.Printf("error: could not connect, waiting %v seconds, and retrying: %v\n", configuration.NatsConnectRetryInterval, err)
-			time.Sleep(time.Duration(time.Second * time.Duration(configuration.NatsConnectRetryInterval)))
-			continue
-		}
+		log.Printf(" * conn.Opts.ReconnectJitterTLS: %v\n", conn.Opts.ReconnectJitterTLS)
+		log.Printf(" * conn.Opts.ReconnectJitter: %v\n", conn.Opts.ReconnectJitter)
 
-		break
+		transports = append(transports, newNatsTransport(conn))
 	}
 
-	log.Printf(" * conn.Opts.ReconnectJitterTLS: %v\n", conn.Opts.ReconnectJitterTLS)
-	log.Printf(" * conn.Opts.ReconnectJitter: %v\n", conn.Opts.ReconnectJitter)
+	var natsTransport transport = transports[0]
+	if poolSize > 1 {
+		natsTransport = newTransportPool(transports)
+	}
 
 	var stewardSocket net.Listener
-	var err error
 
 	// Open the steward socket file, and start the listener if enabled.
 	if configuration.EnableSocket {
@@ -145,27 +235,77 @@ func NewServer(configuration *Configuration, version string) (*server, error) {
 
 	}
 
+	concurrencyLimits, err := newConcurrencyLimits(configuration.MaxConcurrent)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("error: %v", err)
+	}
+
+	inboundRateLimits, err := newInboundRateLimits(configuration)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("error: %v", err)
+	}
+
+	httpListenerAuth, err := newHTTPListenerAuth(configuration)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("error: httpListener: %v", err)
+	}
+
+	replyFolderTemplate, err := newReplyFolderTemplate(configuration)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("error: replyFolderTemplate: %v", err)
+	}
+
 	nodeAuth := newNodeAuth(configuration, errorKernel)
 	// fmt.Printf(" * DEBUG: newServer: signatures contains: %+v\n", signatures)
 
 	s := server{
-		ctx:            ctx,
-		cancel:         cancel,
-		configuration:  configuration,
-		nodeName:       configuration.NodeName,
-		natsConn:       conn,
-		StewardSocket:  stewardSocket,
-		toRingBufferCh: make(chan []subjectAndMessage),
-		metrics:        metrics,
-		version:        version,
-		tui:            tuiClient,
-		errorKernel:    errorKernel,
-		nodeAuth:       nodeAuth,
-		helloRegister:  newHelloRegister(),
-		centralAuth:    newCentralAuth(configuration, errorKernel),
+		ctx:                 ctx,
+		cancel:              cancel,
+		configuration:       configuration,
+		nodeName:            configuration.NodeName,
+		transport:           natsTransport,
+		StewardSocket:       stewardSocket,
+		toRingBufferCh:      make(chan []subjectAndMessage),
+		metrics:             metrics,
+		version:             version,
+		startedAt:           time.Now(),
+		tui:                 tuiClient,
+		errorKernel:         errorKernel,
+		logger:              logger,
+		nodeAuth:            nodeAuth,
+		helloRegister:       newHelloRegister(),
+		centralAuth:         newCentralAuth(configuration, errorKernel),
+		messageDefaults:     newMessageDefaults(configuration),
+		dataIndex:           newDataIndex(configuration),
+		degradedMode:        newDegradedMode(),
+		connRegistry:        newConnRegistry(),
+		httpListenerAuth:    httpListenerAuth,
+		dedupCache:          newDedupCache(configuration),
+		throughputTests:     newThroughputTests(),
+		queryProviders:      newQueryProviders(),
+		messageCallbacks:    newMessageCallbacks(),
+		scheduledShutdown:   newScheduledShutdown(),
+		replicaApplied:      &replicaApplied{},
+		deadLetters:         newDeadLetters(configuration),
+		distributedLocks:    newDistributedLocks(),
+		streamSessions:      newStreamSessions(),
+		tailSessions:        newTailSessions(),
+		scheduledMessages:   newScheduledMessages(configuration),
+		reachabilityWaits:   newReachabilityWaits(),
+		priorityPolicy:      newPriorityPolicy(configuration),
+		retryRegistry:       newRetryRegistry(),
+		concurrencyLimits:   concurrencyLimits,
+		inboundRateLimits:   inboundRateLimits,
+		replyFolderTemplate: replyFolderTemplate,
+		fileLocks:           newFileLocks(),
 	}
 
 	s.processes = newProcesses(ctx, &s)
+	s.registerBuiltinQueryProviders()
 
 	// Create the default data folder for where subscribers should
 	// write it's data, check if data folder exist, and create it if needed.
@@ -227,6 +367,27 @@ func createSocket(socketFolder string, socketFileName string) (net.Listener, err
 	return nl, nil
 }
 
+// connectNats will connect to the nats server, and retry until succesful.
+func connectNats(configuration *Configuration, opt nats.Option) *nats.Conn {
+	for {
+		// Setting MaxReconnects to -1 which equals unlimited.
+		conn, err := nats.Connect(configuration.BrokerAddress,
+			opt,
+			nats.MaxReconnects(-1),
+			nats.ReconnectJitter(time.Duration(configuration.NatsReconnectJitter)*time.Millisecond, time.Duration(configuration.NatsReconnectJitterTLS)*time.Second),
+			nats.Timeout(time.Second*time.Duration(configuration.NatsConnOptTimeout)),
+		)
+		// If no servers where available, we loop and retry until succesful.
+		if err != nil {
+			log.Printf("error: could not connect, waiting %v seconds, and retrying: %v\n", configuration.NatsConnectRetryInterval, err)
+			time.Sleep(time.Duration(time.Second * time.Duration(configuration.NatsConnectRetryInterval)))
+			continue
+		}
+
+		return conn
+	}
+}
+
 // Start will spawn up all the predefined subscriber processes.
 // Spawning of publisher processes is done on the fly by checking
 // if there is publisher process for a given message subject, and
@@ -235,6 +396,20 @@ func (s *server) Start() {
 	log.Printf("Starting steward, version=%+v\n", s.version)
 	s.metrics.promVersion.With(prometheus.Labels{"version": string(s.version)})
 
+	// Validate the trust store before anything else is started, so we
+	// can abort the startup if problems are found.
+	var trustStoreProblems []error
+	if s.configuration.ValidateTrustStoreOnStartup {
+		trustStoreProblems = s.nodeAuth.validateTrustStore()
+		if len(trustStoreProblems) > 0 && s.configuration.AbortOnTrustStoreError {
+			for _, er := range trustStoreProblems {
+				log.Printf("%v\n", er)
+			}
+			log.Printf("error: aborting startup since the trust store validation failed\n")
+			os.Exit(1)
+		}
+	}
+
 	go func() {
 		err := s.errorKernel.start(s.toRingBufferCh)
 		if err != nil {
@@ -277,6 +452,22 @@ func (s *server) Start() {
 	// Start all wanted subscriber processes.
 	s.processes.Start(s.processInitial)
 
+	// Report the problems found by the trust store validation now that
+	// the error kernel and the processes are started.
+	for _, er := range trustStoreProblems {
+		s.errorKernel.errSend(s.processInitial, Message{}, er)
+	}
+
+	// Start the worker calling the message callbacks registered.
+	go s.messageCallbacks.run(s.ctx, func(m Message, er error) {
+		s.errorKernel.errSend(s.processInitial, m, er)
+	})
+
+	// Start adding the files written in the data folder to the data index.
+	go s.dataIndex.run(s.ctx, func(err error) {
+		s.errorKernel.logConsoleOnlyIfDebug(err, s.configuration)
+	})
+
 	time.Sleep(time.Second * 1)
 	s.processes.printProcessesMap()
 
@@ -307,9 +498,26 @@ func (s *server) Start() {
 }
 
 // Will stop all processes started during startup.
-func (s *server) Stop() {
-	// Stop the started pub/sub message processes.
-	s.processes.Stop()
+// Stop will gracefully stop the server. No new messages are accepted on
+// the socket, tcp and http listeners, the subscribers stop receiving new
+// messages, and the publishers stop after the message they are sending.
+// The messages being handled are given until the context is done to
+// finish, before all the processes are canceled and the nats connection
+// is closed. An error listing the processes that did not finish in time
+// is returned.
+func (s *server) Stop(ctx context.Context) error {
+	s.stopInput(ctx)
+	log.Printf("info: stopped accepting new messages\n")
+
+	pending := s.processes.drain(ctx)
+
+	// Stop the started pub/sub message processes. If some did not finish
+	// in time we only cancel them, since waiting for them could hang.
+	if len(pending) == 0 {
+		s.processes.Stop()
+	} else {
+		s.processes.cancel()
+	}
 	log.Printf("info: stopped all subscribers\n")
 
 	// Stop the errorKernel.
@@ -331,6 +539,35 @@ func (s *server) Stop() {
 		}
 	}
 
+	s.transport.close()
+	log.Printf("info: closed the nats connection\n")
+
+	if len(pending) > 0 {
+		return fmt.Errorf("error: processes did not finish before the shutdown deadline: %v", strings.Join(pending, ", "))
+	}
+
+	return nil
+}
+
+// stopInput will close the socket, tcp and http listeners so no new
+// messages are accepted.
+func (s *server) stopInput(ctx context.Context) {
+	if s.StewardSocket != nil {
+		s.StewardSocket.Close()
+	}
+
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	if s.tcpListener != nil {
+		s.tcpListener.Close()
+	}
+	if s.httpServer != nil {
+		err := s.httpServer.Shutdown(ctx)
+		if err != nil {
+			log.Printf("error: stopInput: failed to shut down http listener: %v\n", err)
+		}
+	}
 }
 
 // sendInfoMessage will put the error message directly on the channel that is
@@ -393,7 +630,7 @@ func (s *server) routeMessagesToProcess(dbFileName string) {
 	const samValueBucket string = "samValueBucket"
 	const indexValueBucket string = "indexValueBucket"
 
-	s.ringBuffer = newringBuffer(s.ctx, s.metrics, s.configuration, bufferSize, dbFileName, Node(s.nodeName), s.toRingBufferCh, samValueBucket, indexValueBucket, s.errorKernel, s.processInitial)
+	s.ringBuffer = newringBuffer(s.ctx, s.metrics, s.configuration, bufferSize, dbFileName, Node(s.nodeName), s.toRingBufferCh, samValueBucket, indexValueBucket, s.errorKernel, s.processInitial, s.priorityPolicy)
 
 	ringBufferInCh := make(chan subjectAndMessage)
 	ringBufferOutCh := make(chan samDBValueAndDelivered)
@@ -515,6 +752,19 @@ func (s *server) routeMessagesToProcess(dbFileName string) {
 func (s *server) exposeDataFolder(ctx context.Context) {
 	fileHandler := func(w http.ResponseWriter, r *http.Request) {
 		// w.Header().Set("Content-Type", "text/html")
+
+		// If the file asked for have been compressed by
+		// REQCompressStoredReplies, serve it decompressed.
+		fp := filepath.Join(s.configuration.SubscribersDataFolder, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		if _, err := os.Stat(fp); os.IsNotExist(err) {
+			if fh, _, err := openStoredFile(fp); err == nil {
+				defer fh.Close()
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				io.Copy(w, fh)
+				return
+			}
+		}
+
 		http.FileServer(http.Dir(s.configuration.SubscribersDataFolder)).ServeHTTP(w, r)
 	}
 
@@ -536,3 +786,11 @@ func (s *server) exposeDataFolder(ctx context.Context) {
 	os.Exit(1)
 
 }
+
+// monotonicNow will return the current time found from the time the
+// server was started and the monotonic time elapsed since then, so the
+// duration between two times returned is not affected by changes to the
+// wall clock while running.
+func (s *server) monotonicNow() time.Time {
+	return s.startedAt.Add(time.Since(s.startedAt))
+}
@@ -0,0 +1,106 @@
+package steward
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shutdownNodeFunc is the function called when a scheduled shutdown
+// fires. It is a variable so it can be replaced in tests.
+var shutdownNodeFunc = shutdownNode
+
+// shutdownNode will gracefully stop all the processes of the server,
+// and exit the program.
+func shutdownNode(s *server) {
+	// Make sure that we exit after a given time if the graceful stop
+	// hangs, the same as when stopping with ctrl+c.
+	go func() {
+		time.Sleep(time.Second * 15)
+		log.Printf("error: doing a non graceful shutdown of all processes..\n")
+		os.Exit(1)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	err := s.Stop(ctx)
+	if err != nil {
+		log.Printf("%v\n", err)
+	}
+	os.Exit(0)
+}
+
+// scheduledShutdown holds the shutdown scheduled with
+// REQShutdownScheduled, if any.
+type scheduledShutdown struct {
+	timer *time.Timer
+	at    time.Time
+	mu    sync.Mutex
+}
+
+func newScheduledShutdown() *scheduledShutdown {
+	return &scheduledShutdown{}
+}
+
+// schedule will call fn at the time given. An already scheduled
+// shutdown is replaced.
+func (s *scheduledShutdown) schedule(at time.Time, fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+
+	s.at = at
+	s.timer = time.AfterFunc(time.Until(at), func() {
+		s.mu.Lock()
+		s.timer = nil
+		s.mu.Unlock()
+
+		fn()
+	})
+}
+
+// cancel will cancel the scheduled shutdown, and return the time it was
+// scheduled for. An error is returned if no shutdown is scheduled.
+func (s *scheduledShutdown) cancel() (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer == nil || !s.timer.Stop() {
+		s.timer = nil
+		return time.Time{}, fmt.Errorf("no shutdown is scheduled")
+	}
+	s.timer = nil
+
+	return s.at, nil
+}
+
+// parseShutdownTime will parse the time to shut down at, given either
+// as an RFC3339 time, or as a duration from now like "30m".
+func parseShutdownTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("duration can not be negative: %v", s)
+		}
+		return now.Add(d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("not a valid RFC3339 time or duration: %v", s)
+	}
+	if t.Before(now) {
+		return time.Time{}, fmt.Errorf("time is in the past: %v", s)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,121 @@
+package steward
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The kinds of sessions held in the stream session registry.
+const (
+	sessionKindStreamCommand = "streamCommand"
+)
+
+// streamSession is an interactive command started with REQStreamCommand.
+type streamSession struct {
+	// The node that started the session, and the only node allowed to
+	// send input to it or close it.
+	fromNode Node
+	// The stdin of the command.
+	stdin io.WriteCloser
+	// cancel will stop the session, and kill the command.
+	cancel context.CancelFunc
+	// The kind of session, and the method that started it.
+	kind   string
+	method Method
+	// The time the session was started.
+	started time.Time
+}
+
+// sessionInfo is the information about an active session replied back
+// by REQListActiveSessions.
+type sessionInfo struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	FromNode Node      `json:"fromNode"`
+	Method   Method    `json:"method"`
+	Started  time.Time `json:"started"`
+}
+
+// streamSessions holds the running interactive command sessions of a
+// node, by session id.
+type streamSessions struct {
+	sessions map[string]*streamSession
+	mu       sync.Mutex
+}
+
+func newStreamSessions() *streamSessions {
+	s := streamSessions{
+		sessions: make(map[string]*streamSession),
+	}
+
+	return &s
+}
+
+// add will register a new session with the id given. An error is
+// returned if a session with the same id is already running.
+func (s *streamSessions) add(id string, ss *streamSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[id]; ok {
+		return fmt.Errorf("a stream session with id %v is already running", id)
+	}
+	s.sessions[id] = ss
+
+	return nil
+}
+
+// get will return the session with the id given, if it was started by
+// the node given.
+func (s *streamSessions) get(id string, fromNode Node) (*streamSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.sessions[id]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("no stream session with id %v", id)
+	case ss.fromNode != fromNode:
+		return nil, fmt.Errorf("stream session %v was not started by node %v", id, fromNode)
+	}
+
+	return ss, nil
+}
+
+// list will return the information about all the active sessions,
+// ordered by the time they were started.
+func (s *streamSessions) list() []sessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	infos := make([]sessionInfo, 0, len(s.sessions))
+	for id, ss := range s.sessions {
+		infos = append(infos, sessionInfo{
+			ID:       id,
+			Kind:     ss.kind,
+			FromNode: ss.fromNode,
+			Method:   ss.method,
+			Started:  ss.started,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Started.Equal(infos[j].Started) {
+			return infos[i].ID < infos[j].ID
+		}
+		return infos[i].Started.Before(infos[j].Started)
+	})
+
+	return infos
+}
+
+// remove will remove the session with the id given.
+func (s *streamSessions) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+}
@@ -0,0 +1,85 @@
+package steward
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// tailSession is a file being followed with REQTailFile.
+type tailSession struct {
+	// The node that started the tail, and the only node allowed to
+	// stop it.
+	fromNode Node
+	// The path of the file being followed.
+	path string
+	// cancel will stop the tail.
+	cancel context.CancelFunc
+	// stopped is set when the tail was stopped with REQTailFileStop,
+	// and not by the method timeout.
+	stopped bool
+}
+
+// tailSessions holds the running tails of a node, by the ID of the
+// REQTailFile message that started them.
+type tailSessions struct {
+	tails map[int]*tailSession
+	mu    sync.Mutex
+}
+
+func newTailSessions() *tailSessions {
+	t := tailSessions{
+		tails: make(map[int]*tailSession),
+	}
+
+	return &t
+}
+
+// add will register a new tail with the message id given. An error is
+// returned if a tail with the same id is already running.
+func (t *tailSessions) add(id int, ts *tailSession) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.tails[id]; ok {
+		return fmt.Errorf("a tail with message id %v is already running", id)
+	}
+	t.tails[id] = ts
+
+	return nil
+}
+
+// stop will cancel the tail with the message id given, if it was
+// started by the node given.
+func (t *tailSessions) stop(id int, fromNode Node) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ts, ok := t.tails[id]
+	switch {
+	case !ok:
+		return fmt.Errorf("no tail running with message id %v", id)
+	case ts.fromNode != fromNode:
+		return fmt.Errorf("tail with message id %v was not started by node %v", id, fromNode)
+	}
+
+	ts.stopped = true
+	ts.cancel()
+
+	return nil
+}
+
+// remove will remove the tail with the message id given, and return
+// true if it was stopped with REQTailFileStop.
+func (t *tailSessions) remove(id int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ts, ok := t.tails[id]
+	if !ok {
+		return false
+	}
+	delete(t.tails, id)
+
+	return ts.stopped
+}
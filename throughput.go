@@ -0,0 +1,123 @@
+package steward
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// The default and the max amount of data to send in a throughput
+	// test.
+	throughputDefaultBytes = 1024 * 1024
+	throughputMaxBytes     = 16 * 1024 * 1024
+	// The size of each message sent in a throughput test. Kept well
+	// below the max payload of the nats server.
+	throughputChunkBytes = 64 * 1024
+	// The max time a throughput test is allowed to run.
+	throughputMaxDuration = time.Second * 60
+)
+
+// throughputTest holds the state of a running throughput test.
+type throughputTest struct {
+	toNode Node
+	chunks int
+	start  time.Time
+
+	// The number of chunks and bytes acknowledged by the receiver.
+	ackedChunks int
+	ackedBytes  int
+	// The time the last chunk was acknowledged.
+	last time.Time
+
+	// done is closed when all the chunks are acknowledged.
+	done chan struct{}
+}
+
+// throughputResult is the result of a throughput test.
+type throughputResult struct {
+	ToNode      Node          `json:"toNode"`
+	Bytes       int           `json:"bytes"`
+	Duration    time.Duration `json:"duration"`
+	BytesPerSec float64       `json:"bytesPerSec"`
+	// Complete is false if the test timed out before all the data was
+	// acknowledged, and the result is based on what was received.
+	Complete bool `json:"complete"`
+}
+
+// throughputTests holds the running throughput tests of a node.
+type throughputTests struct {
+	tests map[string]*throughputTest
+	mu    sync.Mutex
+}
+
+func newThroughputTests() *throughputTests {
+	t := throughputTests{
+		tests: make(map[string]*throughputTest),
+	}
+
+	return &t
+}
+
+// add will register a new test, and return the id of the test.
+func (t *throughputTests) add(toNode Node, chunks int) (string, *throughputTest) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := fmt.Sprintf("%v-%v", toNode, time.Now().UnixNano())
+	tt := throughputTest{
+		toNode: toNode,
+		chunks: chunks,
+		start:  time.Now(),
+		done:   make(chan struct{}),
+	}
+	t.tests[id] = &tt
+
+	return id, &tt
+}
+
+// ack will register that a chunk of the size given was received for
+// the test with the id given.
+func (t *throughputTests) ack(id string, bytes int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tt, ok := t.tests[id]
+	if !ok {
+		return fmt.Errorf("no running throughput test with id %v", id)
+	}
+
+	tt.ackedChunks++
+	tt.ackedBytes += bytes
+	tt.last = time.Now()
+
+	if tt.ackedChunks == tt.chunks {
+		close(tt.done)
+	}
+
+	return nil
+}
+
+// remove will remove the test with the id given, and return the
+// result of the test.
+func (t *throughputTests) remove(id string) throughputResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tt := t.tests[id]
+	delete(t.tests, id)
+
+	r := throughputResult{
+		ToNode:   tt.toNode,
+		Bytes:    tt.ackedBytes,
+		Complete: tt.ackedChunks == tt.chunks,
+	}
+	if tt.ackedChunks > 0 {
+		r.Duration = tt.last.Sub(tt.start)
+		if r.Duration > 0 {
+			r.BytesPerSec = float64(r.Bytes) / r.Duration.Seconds()
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,38 @@
+package steward
+
+import (
+	"fmt"
+	"time"
+)
+
+// setSystemClock is the function used to set the system clock of the
+// node. It is a variable so it can be replaced when testing.
+var setSystemClock = setSystemClockOS
+
+// timeCorrection will return how much the local clock should be adjusted
+// to match the central clock. The request was sent at sent, the central
+// read its clock as centralTime, and the reply was received at received,
+// all local times except centralTime. We assume the network delay is the
+// same both ways, so the central time when the reply was received is the
+// central time plus half of the round trip time.
+func timeCorrection(sent time.Time, centralTime time.Time, received time.Time) (correction time.Duration, rtt time.Duration) {
+	rtt = received.Sub(sent)
+	estimated := centralTime.Add(rtt / 2)
+
+	return estimated.Sub(received), rtt
+}
+
+// checkTimeCorrection will return an error if the correction is larger
+// than maxJump in either direction, and force is not given.
+func checkTimeCorrection(correction time.Duration, maxJump time.Duration, force bool) error {
+	abs := correction
+	if abs < 0 {
+		abs = -abs
+	}
+
+	if abs > maxJump && !force {
+		return fmt.Errorf("error: time correction of %v is larger than the max allowed jump of %v, use force to apply it anyway", correction, maxJump)
+	}
+
+	return nil
+}
@@ -0,0 +1,19 @@
+package steward
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// setSystemClockOS will set the system clock to the time given. The
+// process needs to be privileged to be allowed to set the clock.
+func setSystemClockOS(t time.Time) error {
+	tv := syscall.NsecToTimeval(t.UnixNano())
+	err := syscall.Settimeofday(&tv)
+	if err != nil {
+		return fmt.Errorf("error: failed to set system clock: %v", err)
+	}
+
+	return nil
+}
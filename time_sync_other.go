@@ -0,0 +1,14 @@
+//go:build !linux
+
+package steward
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// setSystemClockOS is only supported on linux.
+func setSystemClockOS(t time.Time) error {
+	return fmt.Errorf("error: setting the system clock is not supported on %v", runtime.GOOS)
+}
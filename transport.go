@@ -0,0 +1,125 @@
+package steward
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// errNoResponders is returned by nextMsg when a message was published
+// with a reply subject, but no subscriber was there to receive it.
+var errNoResponders = errors.New("no responders available for request")
+
+// transportMsg is a message sent or received with a transport.
+type transportMsg struct {
+	Subject string
+	// The subject the receiver should publish the reply to.
+	Reply  string
+	Data   []byte
+	Header map[string][]string
+}
+
+// transport is the broker used to deliver the messages between the
+// nodes. The request-reply used for the ACK's is done by subscribing
+// to the reply subject with subscribeSync before publishing a message
+// with the Reply field set.
+type transport interface {
+	// publish will send the message to the subscribers of its subject.
+	publish(msg *transportMsg) error
+	// queueSubscribe will call the handler for every message received
+	// on the subject. Each message is only given to one subscriber of
+	// the queue. The handler is called for one message at a time.
+	queueSubscribe(subject string, queue string, handler func(msg *transportMsg)) (subscription, error)
+	// subscribeSync will subscribe to the subject, where the messages
+	// are read with nextMsg.
+	subscribeSync(subject string) (syncSubscription, error)
+	// close will close the transport, and all its subscriptions.
+	close()
+}
+
+// subscription is a subscription to a subject on a transport.
+type subscription interface {
+	unsubscribe() error
+}
+
+// syncSubscription is a subscription where the messages are read by
+// the caller.
+type syncSubscription interface {
+	subscription
+	// nextMsg will wait up until the timeout for the next message.
+	nextMsg(timeout time.Duration) (*transportMsg, error)
+}
+
+// natsTransport is the transport using a nats connection, and is the
+// default transport.
+type natsTransport struct {
+	conn *nats.Conn
+}
+
+func newNatsTransport(conn *nats.Conn) *natsTransport {
+	return &natsTransport{conn: conn}
+}
+
+func (n *natsTransport) publish(msg *transportMsg) error {
+	return n.conn.PublishMsg(&nats.Msg{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Data:    msg.Data,
+		Header:  nats.Header(msg.Header),
+	})
+}
+
+func (n *natsTransport) queueSubscribe(subject string, queue string, handler func(msg *transportMsg)) (subscription, error) {
+	sub, err := n.conn.QueueSubscribe(subject, queue, func(msg *nats.Msg) {
+		handler(fromNatsMsg(msg))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return natsSubscription{sub: sub}, nil
+}
+
+func (n *natsTransport) subscribeSync(subject string) (syncSubscription, error) {
+	sub, err := n.conn.SubscribeSync(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return natsSubscription{sub: sub}, nil
+}
+
+func (n *natsTransport) close() {
+	n.conn.Close()
+}
+
+// natsSubscription is a subscription on a natsTransport.
+type natsSubscription struct {
+	sub *nats.Subscription
+}
+
+func (n natsSubscription) unsubscribe() error {
+	return n.sub.Unsubscribe()
+}
+
+func (n natsSubscription) nextMsg(timeout time.Duration) (*transportMsg, error) {
+	msg, err := n.sub.NextMsg(timeout)
+	if err == nats.ErrNoResponders {
+		return nil, errNoResponders
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return fromNatsMsg(msg), nil
+}
+
+func fromNatsMsg(msg *nats.Msg) *transportMsg {
+	return &transportMsg{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Data:    msg.Data,
+		Header:  msg.Header,
+	}
+}
@@ -0,0 +1,212 @@
+package steward
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// memoryTransportQueueSize is the number of messages a subscription on a
+// memoryTransport can hold before new messages are dropped.
+const memoryTransportQueueSize = 1024
+
+var errMemoryTransportClosed = errors.New("memory transport is closed")
+
+// memoryTransport is a transport delivering the messages within the
+// process, without a broker. It is used for testing the publishers and
+// subscribers without a nats server. Subjects are matched exactly, so
+// wildcards are not supported.
+type memoryTransport struct {
+	// The subscriptions by subject.
+	subs map[string][]*memorySubscription
+	// The last subscriber used of each queue, to deliver the messages
+	// of a queue round robin.
+	queueNext map[string]int
+	closed    bool
+	mu        sync.Mutex
+}
+
+func newMemoryTransport() *memoryTransport {
+	t := memoryTransport{
+		subs:      make(map[string][]*memorySubscription),
+		queueNext: make(map[string]int),
+	}
+
+	return &t
+}
+
+// publish will deliver the message to all the subscribers of the
+// subject that are not in a queue, and to one of the subscribers of
+// each queue. As with nats, if the message has a reply subject and no
+// one is subscribing, the subscribers of the reply subject are told
+// there were no responders.
+func (t *memoryTransport) publish(msg *transportMsg) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return errMemoryTransportClosed
+	}
+
+	subs := t.subs[msg.Subject]
+	if len(subs) == 0 && msg.Reply != "" {
+		for _, s := range t.subs[msg.Reply] {
+			// A nil message tells nextMsg there were no responders.
+			s.deliver(nil)
+		}
+		return nil
+	}
+
+	queues := make(map[string][]*memorySubscription)
+	for _, s := range subs {
+		if s.queue == "" {
+			s.deliver(copyTransportMsg(msg))
+			continue
+		}
+		queues[s.queue] = append(queues[s.queue], s)
+	}
+
+	for q, qSubs := range queues {
+		key := msg.Subject + " " + q
+		n := (t.queueNext[key] + 1) % len(qSubs)
+		t.queueNext[key] = n
+		qSubs[n].deliver(copyTransportMsg(msg))
+	}
+
+	return nil
+}
+
+func (t *memoryTransport) queueSubscribe(subject string, queue string, handler func(msg *transportMsg)) (subscription, error) {
+	s, err := t.subscribe(subject, queue)
+	if err != nil {
+		return nil, err
+	}
+
+	// Call the handler for one message at a time, as nats do.
+	go func() {
+		for {
+			select {
+			case msg := <-s.ch:
+				if msg != nil {
+					handler(msg)
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+func (t *memoryTransport) subscribeSync(subject string) (syncSubscription, error) {
+	return t.subscribe(subject, "")
+}
+
+func (t *memoryTransport) subscribe(subject string, queue string) (*memorySubscription, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, errMemoryTransportClosed
+	}
+
+	s := memorySubscription{
+		transport: t,
+		subject:   subject,
+		queue:     queue,
+		ch:        make(chan *transportMsg, memoryTransportQueueSize),
+		done:      make(chan struct{}),
+	}
+	t.subs[subject] = append(t.subs[subject], &s)
+
+	return &s, nil
+}
+
+func (t *memoryTransport) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+	t.closed = true
+
+	for _, subs := range t.subs {
+		for _, s := range subs {
+			close(s.done)
+		}
+	}
+	t.subs = make(map[string][]*memorySubscription)
+}
+
+// memorySubscription is a subscription on a memoryTransport.
+type memorySubscription struct {
+	transport *memoryTransport
+	subject   string
+	queue     string
+	ch        chan *transportMsg
+	// done is closed when unsubscribed.
+	done chan struct{}
+}
+
+// deliver will put the message on the queue of the subscription, or
+// drop it if the queue is full, like nats do with a slow consumer. The
+// caller must hold the lock of the transport.
+func (s *memorySubscription) deliver(msg *transportMsg) {
+	select {
+	case s.ch <- msg:
+	default:
+	}
+}
+
+func (s *memorySubscription) unsubscribe() error {
+	t := s.transport
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	subs := t.subs[s.subject]
+	for i := range subs {
+		if subs[i] == s {
+			t.subs[s.subject] = append(subs[:i:i], subs[i+1:]...)
+			close(s.done)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("memory transport: invalid subscription to %v", s.subject)
+}
+
+func (s *memorySubscription) nextMsg(timeout time.Duration) (*transportMsg, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case msg := <-s.ch:
+		if msg == nil {
+			return nil, errNoResponders
+		}
+		return msg, nil
+	case <-s.done:
+		return nil, fmt.Errorf("memory transport: subscription to %v is closed", s.subject)
+	case <-timer.C:
+		return nil, fmt.Errorf("memory transport: timeout waiting for message on %v", s.subject)
+	}
+}
+
+// copyTransportMsg will copy the message, so the subscribers do not
+// share the data with the publisher.
+func copyTransportMsg(msg *transportMsg) *transportMsg {
+	c := transportMsg{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Data:    append([]byte(nil), msg.Data...),
+		Header:  make(map[string][]string, len(msg.Header)),
+	}
+	for k, v := range msg.Header {
+		c.Header[k] = append([]string(nil), v...)
+	}
+
+	return &c
+}
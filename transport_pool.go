@@ -0,0 +1,58 @@
+package steward
+
+import (
+	"sync/atomic"
+)
+
+// transportPool is a transport spreading the publishing and subscribing
+// over a pool of transports, like several nats connections, so a single
+// connection is not the bottleneck on nodes handling many subjects.
+// The transports are used round robin.
+//
+// The reply to a message must be subscribed to on the same transport as
+// the message is published on, or the reply might arrive before the
+// subscription. A publisher should therefore pick one transport from
+// the pool with pickTransport, and use it for all its messages.
+type transportPool struct {
+	transports []transport
+	next       uint64
+}
+
+func newTransportPool(transports []transport) *transportPool {
+	return &transportPool{transports: transports}
+}
+
+// pick will return the next transport of the pool.
+func (tp *transportPool) pick() transport {
+	n := atomic.AddUint64(&tp.next, 1) - 1
+	return tp.transports[n%uint64(len(tp.transports))]
+}
+
+func (tp *transportPool) publish(msg *transportMsg) error {
+	return tp.pick().publish(msg)
+}
+
+func (tp *transportPool) queueSubscribe(subject string, queue string, handler func(msg *transportMsg)) (subscription, error) {
+	return tp.pick().queueSubscribe(subject, queue, handler)
+}
+
+func (tp *transportPool) subscribeSync(subject string) (syncSubscription, error) {
+	return tp.pick().subscribeSync(subject)
+}
+
+func (tp *transportPool) close() {
+	for _, t := range tp.transports {
+		t.close()
+	}
+}
+
+// pickTransport will return the transport a publisher should use. If
+// the transport is a pool the next transport of the pool is returned,
+// else the transport itself.
+func pickTransport(t transport) transport {
+	if tp, ok := t.(*transportPool); ok {
+		return tp.pick()
+	}
+
+	return t
+}